@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"locog/internal/archive"
+	"locog/internal/models"
+)
+
+// archiveFlags are the flags only verify-archives has.
+type archiveFlags struct {
+	dir  *string
+	json *bool
+}
+
+// newArchivesFlagSet returns verify-archives' flag set and its own flags.
+func newArchivesFlagSet(opts *options, stderr io.Writer) (*flag.FlagSet, archiveFlags) {
+	fs := newServerFlagSet("verify-archives", opts, stderr)
+	return fs, archiveFlags{
+		dir:  fs.String("dir", "", "Verify the archives in this directory, e.g. copied to cold storage, instead of asking the server"),
+		json: fs.Bool("json", false, "Print the reports as JSON"),
+	}
+}
+
+// runVerifyArchives verifies tag policy archives against their manifests,
+// on the server with /api/admin/archives/verify, which also checks them
+// against the logs recorded as exported, or with -dir locally. It fails if
+// any archive isn't ok.
+func runVerifyArchives(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var opts options
+	fs, af := newArchivesFlagSet(&opts, stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+
+	var reports []models.ArchiveReport
+	var err error
+	if *af.dir != "" {
+		reports, err = archive.VerifyDir(*af.dir)
+	} else {
+		err = getJSON(ctx, opts, "/api/admin/archives/verify", nil, &reports)
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range reports {
+		if r.Status != archive.StatusOK {
+			failed++
+		}
+	}
+	if *af.json {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range reports {
+			name := r.Tag
+			if r.File != "" {
+				name = filepath.Join(r.Dir, r.File)
+			}
+			line := fmt.Sprintf("%-10s %s: %d logs in %d chunks", r.Status, name, r.Count, r.Chunks)
+			if r.Exported != nil {
+				line += fmt.Sprintf(", %d stored logs exported, %d pending", *r.Exported, *r.Pending)
+			}
+			if r.Held {
+				line += " (logs held)"
+			}
+			fmt.Fprintln(stdout, line)
+			for _, p := range r.Problems {
+				fmt.Fprintf(stdout, "           %s\n", p)
+			}
+			for _, w := range r.Warnings {
+				fmt.Fprintf(stdout, "           warning: %s\n", w)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d archives failed verification", failed, len(reports))
+	}
+	return nil
+}
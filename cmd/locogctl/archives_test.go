@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"locog/internal/archive"
+	"locog/internal/models"
+)
+
+func TestRunVerifyArchives(t *testing.T) {
+	dir := t.TempDir()
+	if err := archive.Append(dir, "incident-1", nil, []models.Log{{ID: 1, Service: "api", Level: "ERROR", Message: "boom"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	if err := runVerifyArchives(context.Background(), []string{"-dir", dir}, &stdout, &bytes.Buffer{}); err != nil {
+		t.Fatalf("verification failed: %v", err)
+	}
+	if !strings.HasPrefix(stdout.String(), "ok") || !strings.Contains(stdout.String(), "incident-1.ndjson: 1 logs in 1 chunks") {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+
+	os.WriteFile(filepath.Join(dir, "incident-1.ndjson"), []byte("{}\n"), 0o644)
+	stdout.Reset()
+	if err := runVerifyArchives(context.Background(), []string{"-dir", dir}, &stdout, &bytes.Buffer{}); err == nil {
+		t.Error("expected an error for a corrupted archive")
+	}
+	if !strings.HasPrefix(stdout.String(), "incomplete") {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// newBackupFlagSet returns backup's flag set and its -o flag.
+func newBackupFlagSet(opts *options, stderr io.Writer) (*flag.FlagSet, *string) {
+	fs := newServerFlagSet("backup", opts, stderr)
+	out := fs.String("o", "", "File to write the backup to, which must not exist (default locog-<time>.db)")
+	return fs, out
+}
+
+// runBackup downloads a consistent copy of the server's database from
+// /api/admin/backup.
+func runBackup(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var opts options
+	fs, out := newBackupFlagSet(&opts, stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	path := *out
+	if path == "" {
+		path = "locog-" + time.Now().UTC().Format("20060102T150405Z") + ".db"
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	resp, err := request(ctx, opts, http.MethodPost, "/api/admin/backup", nil)
+	if err == nil {
+		defer resp.Body.Close()
+		var n int64
+		if n, err = io.Copy(f, resp.Body); err == nil && resp.ContentLength >= 0 && n != resp.ContentLength {
+			err = fmt.Errorf("backup truncated after %d of %d bytes", n, resp.ContentLength)
+		}
+		if err == nil {
+			fmt.Fprintf(stdout, "wrote %d bytes to %s\n", n, path)
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Don't leave a partial backup that looks like a complete one
+		os.Remove(path)
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunBackup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/admin/backup" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer admin" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "unauthorized"}`))
+			return
+		}
+		w.Write([]byte("SQLite format 3\x00"))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	var stdout bytes.Buffer
+	if err := runBackup(context.Background(), []string{"-server", srv.URL, "-o", path}, &stdout, &bytes.Buffer{}); err == nil {
+		t.Error("expected an error without the admin token")
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Error("expected no file after a failed backup")
+	}
+
+	if err := runBackup(context.Background(), []string{"-server", srv.URL, "-token", "admin", "-o", path}, &stdout, &bytes.Buffer{}); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "SQLite format 3\x00" {
+		t.Errorf("unexpected backup %q", data)
+	}
+	// An existing file is never overwritten
+	if err := runBackup(context.Background(), []string{"-server", srv.URL, "-token", "admin", "-o", path}, &stdout, &bytes.Buffer{}); err == nil {
+		t.Error("expected an error for an existing file")
+	}
+}
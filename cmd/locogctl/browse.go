@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"locog/internal/models"
+)
+
+const (
+	// browseMaxLogs bounds the logs browse loads and keeps
+	browseMaxLogs = 1000
+	// browseRetryDelay is how long browse waits after a failed poll
+	browseRetryDelay = 3 * time.Second
+)
+
+const browseHelp = "↑↓ select  enter details  / search  s service  l level  h host  c clear  p pause  q quit"
+
+// browser is the state of the browse command's terminal UI: a live tail of
+// the logs matching its filters, newest first, with a detail view of the
+// selected log. Keys change it through handleKey and fetched logs through
+// apply; render draws it.
+type browser struct {
+	opts    options
+	filters url.Values
+	choices models.FilterOptions
+
+	logs     []models.Log
+	pending  []models.Log // held while paused, oldest first
+	selected int
+	offset   int // index of the first log shown
+
+	detail       bool
+	detailOffset int
+	editing      bool
+	input        string
+	paused       bool
+	status       string
+
+	width, height int
+
+	// gen numbers fetches; refetch asks the loop to start a new one
+	gen     int
+	refetch bool
+}
+
+// browseResult is a batch of logs, or an error, from fetch number gen.
+type browseResult struct {
+	gen     int
+	logs    []models.Log
+	replace bool
+	err     error
+}
+
+// runBrowse runs the interactive terminal UI until q or ctrl-c.
+func runBrowse(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var opts options
+	fs := newFlagSet("browse", &opts, stderr)
+	query, err := parseFlags(fs, &opts, args)
+	if err != nil {
+		return err
+	}
+	if opts.color == "auto" && os.Getenv("NO_COLOR") != "" {
+		opts.color = "never"
+	}
+
+	term, err := openTerminal(stdout)
+	if err != nil {
+		return err
+	}
+	defer term.restore()
+
+	b := &browser{opts: opts, filters: query, refetch: true}
+	b.width, b.height = terminalSize()
+	getJSON(ctx, opts, "/api/filters", nil, &b.choices)
+
+	keys := make(chan []byte)
+	go func() {
+		defer close(keys)
+		buf := make([]byte, 64)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			keys <- slices.Clone(buf[:n])
+		}
+	}()
+
+	results := make(chan browseResult)
+	stopFetch := func() {}
+	defer func() { stopFetch() }()
+	for {
+		if b.refetch {
+			stopFetch()
+			fetchCtx, cancel := context.WithCancel(ctx)
+			stopFetch = cancel
+			b.gen++
+			b.refetch = false
+			go b.fetch(fetchCtx, b.gen, cloneValues(b.filters), results)
+		}
+		var screen bytes.Buffer
+		b.render(&screen)
+		stdout.Write(screen.Bytes())
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case data, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			for _, key := range parseKeys(data) {
+				if key == "ctrl-l" {
+					// Redraw at the current size, reloading the filter
+					// values and logs, e.g. after a failed fetch
+					b.width, b.height = terminalSize()
+					getJSON(ctx, opts, "/api/filters", nil, &b.choices)
+					b.refetch = true
+				}
+				if b.handleKey(key) {
+					return nil
+				}
+			}
+		case res := <-results:
+			b.apply(res)
+		}
+	}
+}
+
+// cloneValues copies query values so a fetch doesn't share them with the
+// browser.
+func cloneValues(values url.Values) url.Values {
+	clone := url.Values{}
+	for k, v := range values {
+		clone[k] = slices.Clone(v)
+	}
+	return clone
+}
+
+// fetch loads the newest logs matching query, then long-polls for new
+// ones until ctx is canceled, sending what it gets to results.
+func (b *browser) fetch(ctx context.Context, gen int, query url.Values, results chan<- browseResult) {
+	send := func(res browseResult) bool {
+		res.gen = gen
+		select {
+		case results <- res:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	query.Set("limit", strconv.Itoa(browseMaxLogs))
+	var logs []models.Log
+	if err := getJSON(ctx, b.opts, "/api/logs", query, &logs); err != nil {
+		send(browseResult{err: err})
+		return
+	}
+	if !send(browseResult{logs: logs, replace: true}) {
+		return
+	}
+
+	var sinceID int64
+	for _, l := range logs {
+		sinceID = max(sinceID, l.ID)
+	}
+	query.Del("limit")
+	query.Set("wait", "30s")
+	for {
+		query.Set("since_id", strconv.FormatInt(sinceID, 10))
+		var resp models.PollResponse
+		if err := getJSON(ctx, b.opts, "/api/poll", query, &resp); err != nil {
+			if ctx.Err() != nil || !send(browseResult{err: err}) {
+				return
+			}
+			select {
+			case <-time.After(browseRetryDelay):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		if len(resp.Logs) > 0 && !send(browseResult{logs: resp.Logs}) {
+			return
+		}
+		sinceID = resp.LastID
+	}
+}
+
+// apply adds fetched logs: a replacement list newest first, or new logs
+// oldest first, which are held while paused. The selection stays on the
+// same log unless it is the newest.
+func (b *browser) apply(res browseResult) {
+	if res.gen != b.gen {
+		return
+	}
+	if res.err != nil {
+		b.status = res.err.Error()
+		return
+	}
+	b.status = ""
+	if res.replace {
+		b.logs, b.pending = res.logs, nil
+		b.selected, b.offset = 0, 0
+		return
+	}
+	if b.paused {
+		b.pending = append(b.pending, res.logs...)
+		return
+	}
+	b.prepend(res.logs)
+}
+
+// prepend adds logs, oldest first, to the top of the list.
+func (b *browser) prepend(logs []models.Log) {
+	newest := slices.Clone(logs)
+	slices.Reverse(newest)
+	b.logs = append(newest, b.logs...)
+	if len(b.logs) > browseMaxLogs {
+		b.logs = b.logs[:browseMaxLogs]
+	}
+	if b.selected > 0 || b.detail {
+		b.selected = min(b.selected+len(newest), len(b.logs)-1)
+		b.offset += len(newest)
+	}
+}
+
+// handleKey applies a key from parseKeys, reporting whether to quit.
+func (b *browser) handleKey(key string) bool {
+	if key == "ctrl-c" {
+		return true
+	}
+	switch {
+	case b.editing:
+		switch key {
+		case "enter":
+			b.editing = false
+			b.setFilter("search", b.input)
+		case "esc":
+			b.editing = false
+		case "backspace":
+			if _, size := utf8.DecodeLastRuneInString(b.input); size > 0 {
+				b.input = b.input[:len(b.input)-size]
+			}
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				b.input += key
+			}
+		}
+	case b.detail:
+		switch key {
+		case "esc", "enter", "q":
+			b.detail = false
+		case "up", "k":
+			b.detailOffset = max(b.detailOffset-1, 0)
+		case "down", "j":
+			b.detailOffset++
+		}
+	default:
+		switch key {
+		case "q":
+			return true
+		case "up", "k":
+			b.selected--
+		case "down", "j":
+			b.selected++
+		case "pgup":
+			b.selected -= b.rows()
+		case "pgdown":
+			b.selected += b.rows()
+		case "g":
+			b.selected = 0
+		case "enter":
+			b.detail, b.detailOffset = len(b.logs) > 0, 0
+		case "/":
+			b.editing, b.input = true, b.filters.Get("search")
+		case "s":
+			b.cycleFilter("service", b.choices.Services)
+		case "l":
+			b.cycleFilter("level", b.choices.Levels)
+		case "h":
+			b.cycleFilter("host", b.choices.Hosts)
+		case "c":
+			for _, name := range []string{"service", "level", "host", "search", "q"} {
+				b.filters.Del(name)
+			}
+			b.refetch = true
+		case "p":
+			b.paused = !b.paused
+			if !b.paused {
+				b.prepend(b.pending)
+				b.pending = nil
+			}
+		}
+		b.selected = max(min(b.selected, len(b.logs)-1), 0)
+	}
+	return false
+}
+
+// setFilter changes a filter, refetching when it differs.
+func (b *browser) setFilter(name, value string) {
+	if b.filters.Get(name) == value {
+		return
+	}
+	if value == "" {
+		b.filters.Del(name)
+	} else {
+		b.filters.Set(name, value)
+	}
+	b.refetch = true
+}
+
+// cycleFilter sets a filter to the value after its current one, wrapping
+// around through "" (no filter).
+func (b *browser) cycleFilter(name string, values []string) {
+	next := ""
+	if i := slices.Index(values, b.filters.Get(name)); i+1 < len(values) {
+		next = values[i+1]
+	}
+	b.setFilter(name, next)
+}
+
+// rows is the number of logs shown, between the header and footer lines.
+func (b *browser) rows() int {
+	return max(b.height-2, 1)
+}
+
+// render draws the whole screen. Raw mode needs explicit carriage returns.
+func (b *browser) render(w io.Writer) {
+	fmt.Fprint(w, "\x1b[H\x1b[2J")
+
+	var header []string
+	for _, name := range []string{"service", "level", "host", "search", "q"} {
+		if v := b.filters.Get(name); v != "" {
+			header = append(header, name+"="+v)
+		}
+	}
+	if len(header) == 0 {
+		header = append(header, "all logs")
+	}
+	state := "LIVE"
+	if b.paused {
+		state = fmt.Sprintf("PAUSED (%d new)", len(b.pending))
+	}
+	fmt.Fprintf(w, "\x1b[7m%s\x1b[0m\r\n", pad(fmt.Sprintf(" locog  %s  [%s]  %d logs", strings.Join(header, " "), state, len(b.logs)), b.width))
+
+	rows := b.rows()
+	if b.detail && b.selected < len(b.logs) {
+		data, _ := json.MarshalIndent(b.logs[b.selected], "", "  ")
+		lines := strings.Split(string(data), "\n")
+		b.detailOffset = min(b.detailOffset, max(len(lines)-rows, 0))
+		for i := 0; i < rows; i++ {
+			if j := b.detailOffset + i; j < len(lines) {
+				fmt.Fprint(w, truncate(lines[j], b.width))
+			}
+			fmt.Fprint(w, "\r\n")
+		}
+	} else {
+		// Keep the selection in view
+		b.offset = min(max(b.offset, b.selected-rows+1), b.selected)
+		b.offset = max(b.offset, 0)
+		p := &printer{width: b.width, color: b.opts.color != "never"}
+		for i := 0; i < rows; i++ {
+			if j := b.offset + i; j < len(b.logs) {
+				if j == b.selected {
+					p.color = false
+					fmt.Fprintf(w, "\x1b[7m%s\x1b[0m", pad(p.tableRow(b.logs[j]), b.width))
+					p.color = b.opts.color != "never"
+				} else {
+					fmt.Fprint(w, p.tableRow(b.logs[j]))
+				}
+			}
+			fmt.Fprint(w, "\r\n")
+		}
+	}
+
+	switch {
+	case b.editing:
+		fmt.Fprint(w, truncate("search: "+b.input+"_", b.width))
+	case b.status != "":
+		fmt.Fprintf(w, "\x1b[31m%s\x1b[0m", truncate(b.status, b.width))
+	case b.detail:
+		fmt.Fprint(w, truncate("↑↓ scroll  esc back", b.width))
+	default:
+		fmt.Fprint(w, truncate(browseHelp, b.width))
+	}
+}
+
+// pad fills s with spaces to width, so reverse video spans the line.
+func pad(s string, width int) string {
+	s = truncate(s, width)
+	if n := width - utf8.RuneCountInString(s); n > 0 {
+		s += strings.Repeat(" ", n)
+	}
+	return s
+}
+
+// parseKeys splits terminal input into keys: "up", "down", "pgup",
+// "pgdown", "enter", "esc", "backspace", "ctrl-c", "ctrl-l" or the
+// character typed.
+func parseKeys(data []byte) []string {
+	sequences := []struct{ seq, key string }{
+		{"\x1b[A", "up"}, {"\x1bOA", "up"},
+		{"\x1b[B", "down"}, {"\x1bOB", "down"},
+		{"\x1b[5~", "pgup"}, {"\x1b[6~", "pgdown"},
+	}
+	var keys []string
+	s := string(data)
+	for len(s) > 0 {
+		matched := false
+		for _, seq := range sequences {
+			if strings.HasPrefix(s, seq.seq) {
+				keys, s, matched = append(keys, seq.key), s[len(seq.seq):], true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		switch r {
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case 0x1b:
+			// Drop the rest of an escape sequence browse doesn't use
+			if strings.HasPrefix(s, "[") {
+				s = strings.TrimLeft(s[1:], "0123456789;")
+				if len(s) > 0 {
+					s = s[1:]
+				}
+				continue
+			}
+			keys = append(keys, "esc")
+		case 0x7f, 0x08:
+			keys = append(keys, "backspace")
+		case 0x03:
+			keys = append(keys, "ctrl-c")
+		case 0x0c:
+			keys = append(keys, "ctrl-l")
+		default:
+			if r >= ' ' && r != utf8.RuneError {
+				keys = append(keys, string(r))
+			}
+		}
+	}
+	return keys
+}
+
+// terminal is the controlling terminal in raw mode on the alternate screen.
+type terminal struct {
+	out   io.Writer
+	saved string
+}
+
+// openTerminal switches the terminal to raw mode with stty, restoring it on
+// restore.
+func openTerminal(out io.Writer) (*terminal, error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("browse needs an interactive terminal: %w", err)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, fmt.Errorf("browse needs an interactive terminal: %w", err)
+	}
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	return &terminal{out: out, saved: saved}, nil
+}
+
+func (t *terminal) restore() {
+	fmt.Fprint(t.out, "\x1b[?25h\x1b[?1049l")
+	stty(t.saved)
+}
+
+// stty runs stty on the terminal on stdin.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// terminalSize returns the terminal's columns and rows, or 80x24 when they
+// can't be read.
+func terminalSize() (width, height int) {
+	out, err := stty("size")
+	if _, scanErr := fmt.Sscan(out, &height, &width); err != nil || scanErr != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
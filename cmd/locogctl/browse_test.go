@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"locog/internal/models"
+)
+
+func TestParseKeys(t *testing.T) {
+	got := parseKeys([]byte("j\x1b[A\x1b[B\x1b[6~\r\x1b\x7f\x03é\x1b[1;5C/"))
+	want := []string{"j", "up", "down", "pgdown", "enter", "esc", "backspace", "ctrl-c", "é", "/"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBrowser_Keys(t *testing.T) {
+	b := &browser{
+		filters: url.Values{},
+		choices: models.FilterOptions{Services: []string{"api", "web"}},
+		height:  10,
+		width:   80,
+	}
+	b.apply(browseResult{logs: []models.Log{{ID: 3}, {ID: 2}, {ID: 1}}, replace: true})
+
+	// Cycling goes through each value and back to no filter
+	for _, want := range []string{"api", "web", ""} {
+		b.refetch = false
+		b.handleKey("s")
+		if got := b.filters.Get("service"); got != want || !b.refetch {
+			t.Errorf("expected service %q and a refetch, got %q (refetch %v)", want, got, b.refetch)
+		}
+	}
+
+	for _, key := range []string{"/", "t", "o", "x", "backspace", "enter"} {
+		b.handleKey(key)
+	}
+	if got := b.filters.Get("search"); got != "to" {
+		t.Errorf("expected search %q, got %q", "to", got)
+	}
+
+	b.handleKey("down")
+	b.handleKey("down")
+	b.handleKey("down")
+	if b.selected != 2 {
+		t.Errorf("expected the selection to stop at the last log, got %d", b.selected)
+	}
+
+	// New logs arrive above a selection without moving it off its log
+	b.apply(browseResult{logs: []models.Log{{ID: 4}, {ID: 5}}})
+	if b.logs[0].ID != 5 || b.logs[b.selected].ID != 1 {
+		t.Errorf("unexpected logs %v, selected %d", b.logs, b.selected)
+	}
+
+	// Paused logs are held until unpaused; stale fetches are ignored
+	b.handleKey("p")
+	b.apply(browseResult{logs: []models.Log{{ID: 6}}})
+	b.apply(browseResult{gen: -1, logs: []models.Log{{ID: 99}}})
+	if len(b.logs) != 5 || len(b.pending) != 1 {
+		t.Errorf("expected one held log, got %d logs and %d pending", len(b.logs), len(b.pending))
+	}
+	b.handleKey("p")
+	if len(b.logs) != 6 || b.logs[0].ID != 6 {
+		t.Errorf("expected the held log on top after unpausing, got %v", b.logs)
+	}
+
+	if b.handleKey("x") || !b.handleKey("q") {
+		t.Error("expected only q to quit")
+	}
+	b.handleKey("enter")
+	if !b.detail || b.handleKey("q") || b.detail {
+		t.Error("expected q to leave the detail view rather than quit")
+	}
+}
+
+func TestBrowser_Render(t *testing.T) {
+	b := &browser{
+		opts:    options{color: "never"},
+		filters: url.Values{"service": {"api"}},
+		height:  5,
+		width:   60,
+	}
+	b.apply(browseResult{logs: []models.Log{
+		{ID: 2, Service: "api", Level: "error", Message: "second"},
+		{ID: 1, Service: "api", Level: "info", Message: "first"},
+	}, replace: true})
+
+	var screen bytes.Buffer
+	b.render(&screen)
+	lines := strings.Split(screen.String(), "\r\n")
+	if len(lines) != b.height {
+		t.Fatalf("expected %d lines, got %d: %q", b.height, len(lines), screen.String())
+	}
+	if !strings.Contains(lines[0], "service=api") || !strings.Contains(lines[0], "2 logs") {
+		t.Errorf("unexpected header %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "\x1b[7m") || !strings.Contains(lines[1], "second") || !strings.Contains(lines[2], "first") {
+		t.Errorf("expected the newest log selected first, got %q", lines[1:3])
+	}
+
+	b.handleKey("enter")
+	b.height = 20
+	screen.Reset()
+	b.render(&screen)
+	if !strings.Contains(screen.String(), `"message": "second"`) {
+		t.Errorf("expected the selected log's details, got %q", screen.String())
+	}
+}
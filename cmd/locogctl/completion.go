@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"locog/internal/models"
+)
+
+// commands are the commands offered by shell completion.
+var commands = []string{"query", "tail", "browse", "backup", "verify-archives", "completion"}
+
+// bashCompletion completes commands, flags, fixed flag values and the
+// services, levels and hosts the server knows via 'locogctl __complete'.
+const bashCompletion = `# bash completion for locogctl
+_locogctl() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+    if [ "$COMP_CWORD" -eq 1 ]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+        return
+    fi
+    case "$prev" in
+        -service|--service|-level|--level|-host|--host)
+            local IFS=$'\n'
+            COMPREPLY=($(compgen -W "$(locogctl __complete "${prev##*-}" 2>/dev/null)" -- "$cur"))
+            return;;
+        -output|--output)
+            COMPREPLY=($(compgen -W "json logfmt table template" -- "$cur"))
+            return;;
+        -color|--color)
+            COMPREPLY=($(compgen -W "auto always never" -- "$cur"))
+            return;;
+    esac
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "$(locogctl __complete flags "${COMP_WORDS[1]}" 2>/dev/null)" -- "$cur"))
+    fi
+}
+complete -F _locogctl locogctl
+`
+
+// zshCompletion reuses the bash completion through bashcompinit.
+const zshCompletion = `# zsh completion for locogctl
+autoload -U +X bashcompinit && bashcompinit
+`
+
+// fishCompletion mirrors bashCompletion.
+const fishCompletion = `# fish completion for locogctl
+complete -c locogctl -f
+complete -c locogctl -n __fish_use_subcommand -a '%s'
+complete -c locogctl -n 'not __fish_use_subcommand' -a '(locogctl __complete flags (commandline -opc)[2] 2>/dev/null)'
+complete -c locogctl -o service -x -a '(locogctl __complete service 2>/dev/null)'
+complete -c locogctl -o level -x -a '(locogctl __complete level 2>/dev/null)'
+complete -c locogctl -o host -x -a '(locogctl __complete host 2>/dev/null)'
+complete -c locogctl -o output -x -a 'json logfmt table template'
+complete -c locogctl -o color -x -a 'auto always never'
+complete -c locogctl -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'
+`
+
+// runCompletion prints the completion script for a shell, e.g.
+// 'source <(locogctl completion bash)'.
+func runCompletion(args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: locogctl completion bash|zsh|fish")
+	}
+	bash := fmt.Sprintf(bashCompletion, strings.Join(commands, " "))
+	switch args[0] {
+	case "bash":
+		_, err := io.WriteString(stdout, bash)
+		return err
+	case "zsh":
+		_, err := io.WriteString(stdout, zshCompletion+bash)
+		return err
+	case "fish":
+		_, err := fmt.Fprintf(stdout, fishCompletion, strings.Join(commands, " "))
+		return err
+	default:
+		return fmt.Errorf("completion: unknown shell %q; use bash, zsh or fish", args[0])
+	}
+}
+
+// runComplete prints completion candidates, one per line, for the
+// completion scripts: 'flags <command>' lists a command's flags and
+// service, level or host the values in /api/filters. Failures print nothing,
+// so a down server just leaves values uncompleted.
+func runComplete(ctx context.Context, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return nil
+	}
+	var values []string
+	switch args[0] {
+	case "flags":
+		if len(args) < 2 || args[1] == "completion" {
+			return nil
+		}
+		var opts options
+		fs := newFlagSet(args[1], &opts, io.Discard)
+		switch args[1] {
+		case "backup":
+			fs, _ = newBackupFlagSet(&opts, io.Discard)
+		case "verify-archives":
+			fs, _ = newArchivesFlagSet(&opts, io.Discard)
+		}
+		addCommandFlags(args[1], fs)
+		fs.VisitAll(func(f *flag.Flag) { values = append(values, "-"+f.Name) })
+	case "service", "level", "host":
+		opts := options{server: envOr("LOCOG_SERVER", defaultServer), token: envOr("LOCOG_TOKEN", "")}
+		var filters models.FilterOptions
+		if err := getJSON(ctx, opts, "/api/filters", nil, &filters); err != nil {
+			return nil
+		}
+		values = map[string][]string{
+			"service": filters.Services,
+			"level":   filters.Levels,
+			"host":    filters.Hosts,
+		}[args[0]]
+	}
+	for _, v := range values {
+		fmt.Fprintln(stdout, v)
+	}
+	return nil
+}
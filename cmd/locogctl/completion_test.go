@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"locog/internal/models"
+)
+
+func TestRunComplete(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.FilterOptions{Services: []string{"api", "web"}, Levels: []string{"error"}})
+	}))
+	defer srv.Close()
+	t.Setenv("LOCOG_SERVER", srv.URL)
+
+	var out bytes.Buffer
+	runComplete(context.Background(), []string{"service"}, &out)
+	if out.String() != "api\nweb\n" {
+		t.Errorf("unexpected services %q", out.String())
+	}
+
+	out.Reset()
+	runComplete(context.Background(), []string{"flags", "query"}, &out)
+	if !strings.Contains(out.String(), "-since\n") || !strings.Contains(out.String(), "-service\n") {
+		t.Errorf("expected query's flags, got %q", out.String())
+	}
+
+	// A server that can't be reached completes nothing
+	srv.Close()
+	out.Reset()
+	if err := runComplete(context.Background(), []string{"host"}, &out); err != nil || out.Len() != 0 {
+		t.Errorf("expected no values and no error, got %q, %v", out.String(), err)
+	}
+}
+
+func TestRunCompletion(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		var out bytes.Buffer
+		if err := runCompletion([]string{shell}, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "locogctl __complete") || !strings.Contains(out.String(), "browse") {
+			t.Errorf("%s: unexpected script %q", shell, out.String())
+		}
+	}
+	if err := runCompletion([]string{"tcsh"}, &bytes.Buffer{}); err == nil {
+		t.Error("expected an error for an unknown shell")
+	}
+}
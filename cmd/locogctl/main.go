@@ -0,0 +1,304 @@
+// Command locogctl queries and tails a locog server from the terminal.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+)
+
+const usage = `Usage: locogctl <command> [flags] [query]
+
+Commands:
+  query        print the logs matching a filter, newest first
+  tail         follow new logs as they arrive
+  browse       interactive live tail with filters and a detail view
+  backup       download a consistent copy of the server's SQLite database
+  verify-archives
+               check tag policy archives for missing or corrupted data
+  completion   print a bash, zsh or fish completion script
+
+The optional query uses the q= syntax of /api/logs, e.g.
+  locogctl query -since 1h '{service="api"} |= "timeout" | level>=warn'
+
+Run 'locogctl <command> -h' for the command's flags.
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes a command, returning the exit status.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	var err error
+	switch cmd := args[0]; cmd {
+	case "query":
+		err = runQuery(ctx, args[1:], stdout, stderr)
+	case "tail":
+		err = runTail(ctx, args[1:], stdout, stderr)
+	case "browse":
+		err = runBrowse(ctx, args[1:], stdout, stderr)
+	case "backup":
+		err = runBackup(ctx, args[1:], stdout, stderr)
+	case "verify-archives":
+		err = runVerifyArchives(ctx, args[1:], stdout, stderr)
+	case "completion":
+		err = runCompletion(args[1:], stdout)
+	case "__complete":
+		err = runComplete(ctx, args[1:], stdout)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "locogctl: unknown command %q\n\n%s", cmd, usage)
+		return 2
+	}
+	switch {
+	case err == nil, errors.Is(err, context.Canceled):
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	default:
+		fmt.Fprintf(stderr, "locogctl: %v\n", err)
+		return 1
+	}
+}
+
+// defaultServer is the server queried without -server or $LOCOG_SERVER.
+const defaultServer = "http://localhost:5081"
+
+// errUsage reports invalid flags, which the flag set has already printed.
+var errUsage = errors.New("usage error")
+
+// options are the flags shared by the commands.
+type options struct {
+	server   string
+	token    string
+	service  string
+	level    string
+	host     string
+	search   string
+	output   string
+	template string
+	color    string
+	width    int
+}
+
+// newFlagSet returns a flag set for cmd with the shared flags bound to opts.
+func newFlagSet(cmd string, opts *options, stderr io.Writer) *flag.FlagSet {
+	fs := newServerFlagSet(cmd, opts, stderr)
+	fs.StringVar(&opts.service, "service", "", "Only logs from this service")
+	fs.StringVar(&opts.level, "level", "", "Only logs at this level")
+	fs.StringVar(&opts.host, "host", "", "Only logs from this host")
+	fs.StringVar(&opts.search, "search", "", "Only logs whose message contains this text")
+	fs.StringVar(&opts.output, "output", "table", "Output format: json, logfmt, table or template")
+	fs.StringVar(&opts.template, "template", "", "Go template for -output template, e.g. '{{.Level}} {{.Message}}'")
+	fs.StringVar(&opts.color, "color", "auto", "Color levels: auto, always or never")
+	fs.IntVar(&opts.width, "width", terminalWidth(), "Truncate table lines to this many columns; 0 doesn't truncate (default $COLUMNS)")
+	return fs
+}
+
+// newServerFlagSet returns a flag set for cmd with only the flags locating
+// the server bound to opts.
+func newServerFlagSet(cmd string, opts *options, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("locogctl "+cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.server, "server", envOr("LOCOG_SERVER", defaultServer), "locog server URL (default $LOCOG_SERVER)")
+	fs.StringVar(&opts.token, "token", os.Getenv("LOCOG_TOKEN"), "Bearer token for restricted streams or admin endpoints (default $LOCOG_TOKEN)")
+	return fs
+}
+
+// parseFlags parses args, returning the query built from the filter flags
+// and the positional q= query.
+func parseFlags(fs *flag.FlagSet, opts *options, args []string) (url.Values, error) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, errUsage
+	}
+	query := url.Values{}
+	for name, v := range map[string]string{
+		"service": opts.service,
+		"level":   opts.level,
+		"host":    opts.host,
+		"search":  opts.search,
+		"q":       strings.Join(fs.Args(), " "),
+	} {
+		if v != "" {
+			query.Set(name, v)
+		}
+	}
+	return query, nil
+}
+
+// queryFlags are the flags only query has.
+type queryFlags struct {
+	since *time.Duration
+	limit *int
+	order *string
+	db    *string
+}
+
+func addQueryFlags(fs *flag.FlagSet) queryFlags {
+	return queryFlags{
+		since: fs.Duration("since", 0, "Only logs from the last duration, e.g. 15m"),
+		limit: fs.Int("limit", 100, "Maximum number of logs"),
+		order: fs.String("order", "desc", "Order by timestamp: desc (newest first) or asc"),
+		db:    fs.String("db", "", "Query this SQLite database file, opened read-only, instead of a server"),
+	}
+}
+
+// addCommandFlags adds the flags specific to cmd, for completion.
+func addCommandFlags(cmd string, fs *flag.FlagSet) {
+	if cmd == "query" {
+		addQueryFlags(fs)
+	}
+}
+
+// runQuery prints the logs matching the filter flags, from the server or
+// with -db from a database file.
+func runQuery(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var opts options
+	fs := newFlagSet("query", &opts, stderr)
+	qf := addQueryFlags(fs)
+	query, err := parseFlags(fs, &opts, args)
+	if err != nil {
+		return err
+	}
+	p, err := newPrinter(stdout, opts)
+	if err != nil {
+		return err
+	}
+
+	var logs []models.Log
+	if *qf.db != "" {
+		if logs, err = queryFile(ctx, *qf.db, query, qf); err != nil {
+			return err
+		}
+	} else {
+		if *qf.since > 0 {
+			query.Set("start", time.Now().Add(-*qf.since).UTC().Format(time.RFC3339))
+		}
+		query.Set("limit", strconv.Itoa(*qf.limit))
+		query.Set("order", *qf.order)
+		if err := getJSON(ctx, opts, "/api/logs", query, &logs); err != nil {
+			return err
+		}
+	}
+	for _, l := range logs {
+		if err := p.print(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runTail long-polls /api/poll, printing new logs until interrupted.
+func runTail(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var opts options
+	fs := newFlagSet("tail", &opts, stderr)
+	query, err := parseFlags(fs, &opts, args)
+	if err != nil {
+		return err
+	}
+	p, err := newPrinter(stdout, opts)
+	if err != nil {
+		return err
+	}
+	query.Set("wait", "30s")
+
+	var sinceID int64
+	for {
+		query.Set("since_id", strconv.FormatInt(sinceID, 10))
+		var resp models.PollResponse
+		if err := getJSON(ctx, opts, "/api/poll", query, &resp); err != nil {
+			return err
+		}
+		for _, l := range resp.Logs {
+			if err := p.print(l); err != nil {
+				return err
+			}
+		}
+		sinceID = resp.LastID
+	}
+}
+
+// getJSON fetches path with query from the server and decodes the JSON
+// response into v.
+func getJSON(ctx context.Context, opts options, path string, query url.Values, v interface{}) error {
+	resp, err := request(ctx, opts, http.MethodGet, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// request sends a request without a body to the server, returning the
+// response if it is a 200 and the API error otherwise.
+func request(ctx context.Context, opts options, method, path string, query url.Values) (*http.Response, error) {
+	u := strings.TrimSuffix(opts.server, "/") + path + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if opts.token != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Error   string `json:"error"`
+			Details string `json:"details"`
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			if apiErr.Details != "" {
+				return nil, fmt.Errorf("%s: %s: %s", resp.Status, apiErr.Error, apiErr.Details)
+			}
+			return nil, fmt.Errorf("%s: %s", resp.Status, apiErr.Error)
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// envOr returns the environment variable name, or fallback when unset.
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// terminalWidth returns $COLUMNS, or 0 when unset.
+func terminalWidth() int {
+	n, _ := strconv.Atoi(os.Getenv("COLUMNS"))
+	return max(n, 0)
+}
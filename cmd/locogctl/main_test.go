@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"locog/internal/models"
+)
+
+func TestRun_Query(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		if r.URL.Query().Get("service") == "missing" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid query", "details": "no such service"})
+			return
+		}
+		json.NewEncoder(w).Encode([]models.Log{testLog()})
+	}))
+	defer srv.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"query", "-server", srv.URL, "-token", "secret",
+		"-service", "payments-api", "-limit", "5", "-output", "template", "-template", "{{.ID}} {{.Message}}",
+		"level>=error", `|= "declined"`}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("exit status %d: %s", code, stderr.String())
+	}
+	q := got.URL.Query()
+	if got.URL.Path != "/api/logs" || q.Get("service") != "payments-api" || q.Get("limit") != "5" ||
+		q.Get("q") != `level>=error |= "declined"` || q.Get("order") != "desc" {
+		t.Errorf("unexpected request %s", got.URL)
+	}
+	if auth := got.Header.Get("Authorization"); auth != "Bearer secret" {
+		t.Errorf("expected the token as a bearer token, got %q", auth)
+	}
+	if !strings.HasPrefix(stdout.String(), "7 charge failed:") {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+
+	stderr.Reset()
+	code = run(context.Background(), []string{"query", "-server", srv.URL, "-service", "missing"}, &stdout, &stderr)
+	if code != 1 || !strings.Contains(stderr.String(), "Invalid query: no such service") {
+		t.Errorf("expected the API error, got status %d: %s", code, stderr.String())
+	}
+}
+
+func TestRun_Usage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run(context.Background(), nil, &stdout, &stderr); code != 2 {
+		t.Errorf("expected status 2 without a command, got %d", code)
+	}
+	if code := run(context.Background(), []string{"frobnicate"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected status 2 for an unknown command, got %d", code)
+	}
+	if code := run(context.Background(), []string{"query", "-output", "yaml"}, &stdout, &stderr); code != 1 {
+		t.Errorf("expected status 1 for an invalid output format, got %d", code)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"locog/internal/db"
+	"locog/internal/models"
+)
+
+// queryFile runs a query against a SQLite database file opened read-only,
+// e.g. a copied backup, without a server. The filter flags apply as they do
+// on the server; the q= query language is only parsed by the server.
+func queryFile(ctx context.Context, path string, query url.Values, qf queryFlags) ([]models.Log, error) {
+	if query.Get("q") != "" {
+		return nil, fmt.Errorf("-db: queries need a server; use -service, -level, -host and -search")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("-db: %w", err)
+	}
+	if *qf.limit < 1 {
+		return nil, fmt.Errorf("-limit must be positive, got: %d", *qf.limit)
+	}
+
+	filter := models.LogFilter{
+		Service: query.Get("service"),
+		Level:   query.Get("level"),
+		Host:    query.Get("host"),
+		Search:  query.Get("search"),
+		Limit:   *qf.limit,
+	}
+	switch *qf.order {
+	case "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		return nil, fmt.Errorf("-order must be asc or desc, got: %s", *qf.order)
+	}
+	if *qf.since > 0 {
+		start := time.Now().Add(-*qf.since)
+		filter.StartTime = &start
+	}
+
+	database, err := db.NewWithOptions(path, db.Options{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer database.Close()
+	return database.QueryLogs(ctx, filter)
+}
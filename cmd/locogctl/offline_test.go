@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/db"
+	"locog/internal/models"
+)
+
+func TestRun_QueryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.db")
+	database, err := db.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	for i, l := range []models.Log{
+		{Service: "api", Level: "error", Message: "old timeout", Timestamp: now.Add(-2 * time.Hour)},
+		{Service: "api", Level: "error", Message: "new timeout", Timestamp: now.Add(-time.Minute)},
+		{Service: "web", Level: "error", Message: "web timeout", Timestamp: now},
+	} {
+		l.Host = "h1"
+		if err := database.InsertLog(context.Background(), &l); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	database.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"query", "-db", path, "-service", "api", "-search", "timeout",
+		"-order", "asc", "-output", "template", "-template", "{{.Message}}"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("exit status %d: %s", code, stderr.String())
+	}
+	if stdout.String() != "old timeout\nnew timeout\n" {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+
+	stdout.Reset()
+	code = run(context.Background(), []string{"query", "-db", path, "-since", "1h", "-limit", "1",
+		"-output", "template", "-template", "{{.Message}}"}, &stdout, &stderr)
+	if code != 0 || stdout.String() != "web timeout\n" {
+		t.Errorf("expected the newest recent log, got status %d: %q", code, stdout.String())
+	}
+
+	for _, args := range [][]string{
+		{"query", "-db", path, "|= \"timeout\""},
+		{"query", "-db", filepath.Join(t.TempDir(), "missing.db")},
+		{"query", "-db", path, "-order", "sideways"},
+	} {
+		stderr.Reset()
+		if code := run(context.Background(), args, &stdout, &stderr); code != 1 {
+			t.Errorf("%v: expected status 1, got %d", args, code)
+		}
+	}
+	if !strings.Contains(stderr.String(), "-order") {
+		t.Errorf("unexpected error %q", stderr.String())
+	}
+}
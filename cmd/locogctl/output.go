@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"locog/internal/logtext"
+	"locog/internal/models"
+)
+
+// tableServiceWidth is the width of the table's service column; longer names
+// are truncated.
+const tableServiceWidth = 16
+
+// levelColors are the ANSI colors of canonical levels and common spellings.
+var levelColors = map[string]string{
+	"trace":    "90",
+	"debug":    "90",
+	"info":     "32",
+	"warn":     "33",
+	"warning":  "33",
+	"error":    "31",
+	"err":      "31",
+	"fatal":    "1;31",
+	"critical": "1;31",
+	"panic":    "1;31",
+}
+
+// printer writes logs in the chosen output format.
+type printer struct {
+	w        io.Writer
+	format   string
+	tmpl     *template.Template
+	color    bool
+	width    int
+	enc      *json.Encoder
+	wroteHdr bool
+}
+
+// newPrinter validates the output flags and returns a printer writing to w.
+func newPrinter(w io.Writer, opts options) (*printer, error) {
+	p := &printer{w: w, format: opts.output, width: opts.width}
+	switch opts.output {
+	case "json":
+		p.enc = json.NewEncoder(w)
+	case "logfmt", "table":
+	case "template":
+		tmpl, err := logtext.Parse(opts.template)
+		if err != nil {
+			return nil, fmt.Errorf("-template: %w", err)
+		}
+		p.tmpl = tmpl
+	default:
+		return nil, fmt.Errorf("-output must be json, logfmt, table or template, got: %s", opts.output)
+	}
+	if opts.template != "" && opts.output != "template" {
+		return nil, fmt.Errorf("-template requires -output template")
+	}
+	if opts.width < 0 {
+		return nil, fmt.Errorf("-width must not be negative")
+	}
+
+	switch opts.color {
+	case "always":
+		p.color = true
+	case "never":
+	case "auto":
+		// https://no-color.org
+		p.color = os.Getenv("NO_COLOR") == "" && isTerminal(w)
+	default:
+		return nil, fmt.Errorf("-color must be auto, always or never, got: %s", opts.color)
+	}
+	return p, nil
+}
+
+// isTerminal reports whether w is a character device such as a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// print writes one log.
+func (p *printer) print(l models.Log) error {
+	switch p.format {
+	case "json":
+		// One object per line, for jq
+		return p.enc.Encode(l)
+	case "template":
+		return logtext.Write(p.w, p.tmpl, []models.Log{l})
+	case "logfmt":
+		_, err := io.WriteString(p.w, p.logfmt(l)+"\n")
+		return err
+	default:
+		if !p.wroteHdr {
+			p.wroteHdr = true
+			header := fmt.Sprintf("%-23s %-5s %-*s %s", "TIME", "LEVEL", tableServiceWidth, "SERVICE", "MESSAGE")
+			if _, err := io.WriteString(p.w, truncate(header, p.width)+"\n"); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(p.w, p.tableRow(l)+"\n")
+		return err
+	}
+}
+
+// logfmt formats a log as key=value pairs, metadata last with dotted keys in
+// sorted order, e.g. time=... level=error service=api msg="timed out" http.status=504.
+func (p *printer) logfmt(l models.Log) string {
+	var b strings.Builder
+	pair := func(key, value string) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(value))
+	}
+	pair("time", logtext.Time{Time: l.Timestamp}.String())
+	level := logfmtValue(l.Level)
+	if p.color {
+		level = p.colorize(l.Level, level)
+	}
+	b.WriteString(" level=" + level)
+	pair("service", l.Service)
+	pair("host", l.Host)
+	pair("msg", l.Message)
+	if l.Stream != "" {
+		pair("stream", l.Stream)
+	}
+	if len(l.Tags) > 0 {
+		pair("tags", strings.Join(l.Tags, ","))
+	}
+	flat := map[string]string{}
+	flatten(flat, "", l.Metadata)
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		pair(k, flat[k])
+	}
+	return b.String()
+}
+
+// flatten adds metadata's values to flat under dotted keys.
+func flatten(flat map[string]string, prefix string, metadata map[string]interface{}) {
+	for k, v := range metadata {
+		if m, ok := v.(map[string]interface{}); ok && len(m) > 0 {
+			flatten(flat, prefix+k+".", m)
+			continue
+		}
+		flat[prefix+k] = logtext.Value(v)
+	}
+}
+
+// logfmtValue quotes v when it is empty or contains spaces, quotes, '=' or
+// control characters.
+func logfmtValue(v string) string {
+	if v != "" && !strings.ContainsFunc(v, func(r rune) bool {
+		return r <= ' ' || r == '"' || r == '=' || r == 0x7f || r == utf8.RuneError
+	}) {
+		return v
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// tableRow formats a log as a table line, the message on one line and the
+// line truncated to the printer's width.
+func (p *printer) tableRow(l models.Log) string {
+	level := l.Level
+	if utf8.RuneCountInString(level) > 5 {
+		level = string([]rune(level)[:5])
+	}
+	service := l.Service
+	if utf8.RuneCountInString(service) > tableServiceWidth {
+		service = string([]rune(service)[:tableServiceWidth-1]) + "…"
+	}
+	message := strings.Join(strings.Fields(l.Message), " ")
+	row := fmt.Sprintf("%-23s %-5s %-*s %s", l.Timestamp.Local().Format("2006-01-02 15:04:05.000"),
+		level, tableServiceWidth, service, message)
+	row = truncate(row, p.width)
+	if p.color {
+		// The level starts after the 23 character timestamp and a space
+		runes := []rune(row)
+		if len(runes) > 24 {
+			end := min(len(runes), 29)
+			row = string(runes[:24]) + p.colorize(l.Level, string(runes[24:end])) + string(runes[end:])
+		}
+	}
+	return row
+}
+
+// colorize wraps text in the ANSI color of level, if it has one.
+func (p *printer) colorize(level, text string) string {
+	code, ok := levelColors[strings.ToLower(level)]
+	if !ok {
+		return text
+	}
+	return "\x1b[" + code + "m" + text + "\x1b[0m"
+}
+
+// truncate shortens s to width runes, ending in "…"; 0 doesn't truncate.
+func truncate(s string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width-1]) + "…"
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func testLog() models.Log {
+	return models.Log{
+		ID:        7,
+		Timestamp: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Service:   "payments-api",
+		Level:     "error",
+		Message:   "charge failed:\n card declined",
+		Host:      "web-1",
+		Metadata: map[string]interface{}{
+			"http":  map[string]interface{}{"status": float64(402)},
+			"order": "A 17",
+		},
+	}
+}
+
+func TestPrinter_Formats(t *testing.T) {
+	tests := []struct {
+		name string
+		opts options
+		want string
+	}{
+		{
+			name: "logfmt",
+			opts: options{output: "logfmt", color: "never"},
+			want: `time=2024-03-01T12:00:00.000Z level=error service=payments-api host=web-1 msg="charge failed:\n card declined" http.status=402 order="A 17"` + "\n",
+		},
+		{
+			name: "logfmt colored",
+			opts: options{output: "logfmt", color: "always"},
+			want: "time=2024-03-01T12:00:00.000Z level=\x1b[31merror\x1b[0m service=payments-api",
+		},
+		{
+			name: "template",
+			opts: options{output: "template", template: `{{upper .Level}} {{meta . "http.status"}}`, color: "never"},
+			want: "ERROR 402\n",
+		},
+		{
+			name: "table truncated",
+			opts: options{output: "table", color: "never", width: 50},
+			want: "TIME                    LEVEL SERVICE          ME…\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			p, err := newPrinter(&buf, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := p.print(testLog()); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(buf.String(), tt.want) {
+				t.Errorf("got %q, want prefix %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestPrinter_TableRow(t *testing.T) {
+	p, err := newPrinter(&bytes.Buffer{}, options{output: "table", color: "never", width: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := p.tableRow(testLog())
+	if strings.Contains(row, "\n") {
+		t.Errorf("row spans lines: %q", row)
+	}
+	if n := len([]rune(row)); n != 60 {
+		t.Errorf("row is %d columns, want 60: %q", n, row)
+	}
+	if !strings.Contains(row, " error payments-api     charge fail") {
+		t.Errorf("unexpected row %q", row)
+	}
+}
+
+func TestPrinter_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := newPrinter(&buf, options{output: "json", color: "auto"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.print(testLog())
+	p.print(testLog())
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected one line per log, got %q", buf.String())
+	}
+	var l models.Log
+	if err := json.Unmarshal([]byte(lines[0]), &l); err != nil || l.ID != 7 {
+		t.Errorf("unexpected line %q: %v", lines[0], err)
+	}
+}
+
+func TestNewPrinter_Invalid(t *testing.T) {
+	for _, opts := range []options{
+		{output: "yaml", color: "auto"},
+		{output: "table", color: "sometimes"},
+		{output: "json", template: "{{.Message}}", color: "auto"},
+		{output: "template", template: "{{.Message", color: "auto"},
+		{output: "table", color: "auto", width: -1},
+	} {
+		if _, err := newPrinter(&bytes.Buffer{}, opts); err == nil {
+			t.Errorf("expected an error for %+v", opts)
+		}
+	}
+}
@@ -1,183 +0,0 @@
-package main
-
-import (
-	"encoding/json"
-	"log/slog"
-	"net/http"
-	"sync"
-	"time"
-
-	"locog/internal/models"
-
-	"github.com/gorilla/websocket"
-)
-
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins (matches existing CORS policy)
-	},
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-}
-
-// wsClient represents a single WebSocket connection.
-type wsClient struct {
-	hub  *wsHub
-	conn *websocket.Conn
-	send chan []byte
-}
-
-// wsHub manages active WebSocket clients and broadcasts messages.
-type wsHub struct {
-	mu         sync.RWMutex
-	clients    map[*wsClient]struct{}
-	broadcast  chan []byte
-	register   chan *wsClient
-	unregister chan *wsClient
-}
-
-func newWSHub() *wsHub {
-	return &wsHub{
-		clients:    make(map[*wsClient]struct{}),
-		broadcast:  make(chan []byte, 256),
-		register:   make(chan *wsClient),
-		unregister: make(chan *wsClient),
-	}
-}
-
-// run processes register, unregister, and broadcast events.
-func (h *wsHub) run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client] = struct{}{}
-			h.mu.Unlock()
-			slog.Debug("websocket client connected", "clients", h.clientCount())
-
-		case client := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-			}
-			h.mu.Unlock()
-			slog.Debug("websocket client disconnected", "clients", h.clientCount())
-
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					// Client's send buffer is full; disconnect it.
-					h.mu.RUnlock()
-					h.mu.Lock()
-					delete(h.clients, client)
-					close(client.send)
-					h.mu.Unlock()
-					h.mu.RLock()
-				}
-			}
-			h.mu.RUnlock()
-		}
-	}
-}
-
-func (h *wsHub) clientCount() int {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	return len(h.clients)
-}
-
-// broadcastLogs serializes logs and sends them to all connected clients.
-func (h *wsHub) broadcastLogs(logs []models.Log) {
-	data, err := json.Marshal(logs)
-	if err != nil {
-		slog.Error("failed to marshal logs for websocket broadcast", "error", err)
-		return
-	}
-	h.broadcast <- data
-}
-
-const (
-	// Time allowed to write a message to the peer.
-	writeWait = 10 * time.Second
-
-	// Time allowed to read the next pong message from the peer.
-	pongWait = 60 * time.Second
-
-	// Send pings to peer with this period. Must be less than pongWait.
-	pingPeriod = (pongWait * 9) / 10
-)
-
-// readPump reads messages from the WebSocket connection (handles control frames).
-func (c *wsClient) readPump() {
-	defer func() {
-		c.hub.unregister <- c
-		c.conn.Close()
-	}()
-
-	c.conn.SetReadLimit(512)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
-		return nil
-	})
-
-	for {
-		_, _, err := c.conn.ReadMessage()
-		if err != nil {
-			break
-		}
-	}
-}
-
-// writePump pumps messages from the hub to the WebSocket connection.
-func (c *wsClient) writePump() {
-	ticker := time.NewTicker(pingPeriod)
-	defer func() {
-		ticker.Stop()
-		c.conn.Close()
-	}()
-
-	for {
-		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				return
-			}
-
-		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				return
-			}
-		}
-	}
-}
-
-// handleWebSocket upgrades the HTTP connection to WebSocket and registers the client.
-func (s *server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		slog.Error("websocket upgrade failed", "error", err)
-		return
-	}
-
-	client := &wsClient{
-		hub:  s.hub,
-		conn: conn,
-		send: make(chan []byte, 256),
-	}
-
-	s.hub.register <- client
-
-	go client.writePump()
-	go client.readPump()
-}
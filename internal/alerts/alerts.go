@@ -0,0 +1,359 @@
+// Package alerts evaluates threshold rules over stored logs.
+//
+// Rules count the logs matching a filter in a trailing window. The window ends
+// at a watermark held back from wall-clock now, so batches that arrive late
+// are counted before the rule looks at their time range instead of causing a
+// false dip (or, once they land, a false spike).
+//
+// Absence rules instead fire when a service or host has had no logs ingested
+// for a while, going by when its logs last arrived rather than their
+// timestamps.
+package alerts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"locog/internal/models"
+)
+
+const (
+	// DefaultDelay is the watermark delay for automatic rules until arrival
+	// lateness has been observed.
+	DefaultDelay = time.Minute
+	// MaxAutoDelay caps the automatically derived watermark delay.
+	MaxAutoDelay = 10 * time.Minute
+)
+
+// Rule is a threshold on the number of logs matching Filter within Window.
+type Rule struct {
+	Name      string
+	Filter    models.LogFilter
+	Window    time.Duration
+	Condition string // >, >=, <, <=
+	Threshold int64
+	Interval  time.Duration
+
+	// Delay holds the watermark back from now. With AutoDelay it is derived
+	// from the observed arrival lateness of the rule's service instead.
+	Delay     time.Duration
+	AutoDelay bool
+
+	// Absent makes this an absence rule: the value is the seconds since logs
+	// from Filter's service and host (empty for any) were last ingested, and
+	// the rule fires at Absent, with Window equal to it and Condition ">=".
+	Absent time.Duration
+
+	// Notifiers are told when the rule starts firing and, with
+	// NotifyResolved, when it resolves.
+	Notifiers      []Notifier
+	NotifyResolved bool
+	// Link is the web UI address of the rule's query; notifications link to
+	// it with the evaluated window added as start and end.
+	Link string
+}
+
+// Validate checks the rule's settings.
+func (r Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("alert rule: missing name")
+	}
+	switch r.Condition {
+	case ">", ">=", "<", "<=":
+	default:
+		return fmt.Errorf("alert rule %s: condition must be one of >, >=, <, <=, got %q", r.Name, r.Condition)
+	}
+	if r.Window <= 0 {
+		return fmt.Errorf("alert rule %s: window must be positive", r.Name)
+	}
+	if r.Interval <= 0 {
+		return fmt.Errorf("alert rule %s: interval must be positive", r.Name)
+	}
+	if r.Delay < 0 {
+		return fmt.Errorf("alert rule %s: delay must not be negative", r.Name)
+	}
+	if r.Absent < 0 {
+		return fmt.Errorf("alert rule %s: absent must not be negative", r.Name)
+	}
+	return nil
+}
+
+// AbsenceRule returns a rule firing when no logs from service on host (empty
+// for any) have been ingested for absent.
+func AbsenceRule(name, service, host string, absent, interval time.Duration) Rule {
+	return Rule{
+		Name:      name,
+		Filter:    models.LogFilter{Service: service, Host: host},
+		Window:    absent,
+		Condition: ">=",
+		Threshold: int64(absent / time.Second),
+		Interval:  interval,
+		Absent:    absent,
+	}
+}
+
+func (r Rule) breached(value int64) bool {
+	switch r.Condition {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	}
+	return false
+}
+
+// Counter counts logs matching a filter.
+type Counter interface {
+	CountLogs(ctx context.Context, filter models.LogFilter) (int64, error)
+}
+
+// LastLogger reports when logs from a service on a host (empty for any) were
+// last ingested, or false when none have been. Absence rules need the store
+// to implement it.
+type LastLogger interface {
+	LastLogAt(ctx context.Context, service, host string) (time.Time, bool, error)
+}
+
+// LatenessFunc reports the high-percentile arrival lateness observed for a
+// service ("" for all services), or false when nothing has been observed.
+type LatenessFunc func(service string) (time.Duration, bool)
+
+// Engine evaluates alert rules and tracks their state.
+type Engine struct {
+	store    Counter
+	lateness LatenessFunc
+	started  time.Time
+
+	mu    sync.RWMutex
+	rules []*ruleState
+
+	notifying sync.WaitGroup
+}
+
+type ruleState struct {
+	rule   Rule
+	status models.AlertStatus
+	// notified is set once the rule's firing was notified, so a resolution
+	// is notified and an evaluation error in between doesn't notify again.
+	notified bool
+}
+
+// NewEngine creates an engine for the given rules. lateness may be nil, in
+// which case automatic rules use DefaultDelay.
+func NewEngine(store Counter, rules []Rule, lateness LatenessFunc) *Engine {
+	e := &Engine{store: store, lateness: lateness, started: time.Now()}
+	for _, r := range rules {
+		e.rules = append(e.rules, &ruleState{
+			rule: r,
+			status: models.AlertStatus{
+				Name:          r.Name,
+				State:         "pending",
+				Condition:     r.Condition,
+				Threshold:     r.Threshold,
+				AbsentSeconds: r.Absent.Seconds(),
+			},
+		})
+	}
+	return e
+}
+
+// Run evaluates every rule on its interval until ctx is cancelled.
+func (e *Engine) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, rs := range e.rules {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(rs.rule.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case now := <-ticker.C:
+					e.evaluate(ctx, rs, now)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// EvaluateAll evaluates every rule once as of now.
+func (e *Engine) EvaluateAll(ctx context.Context, now time.Time) {
+	for _, rs := range e.rules {
+		e.evaluate(ctx, rs, now)
+	}
+}
+
+// Status returns the current state of every rule.
+func (e *Engine) Status() []models.AlertStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	out := make([]models.AlertStatus, 0, len(e.rules))
+	for _, rs := range e.rules {
+		out = append(out, rs.status)
+	}
+	return out
+}
+
+// Wait waits for notifications in flight.
+func (e *Engine) Wait() {
+	e.notifying.Wait()
+}
+
+// delay returns the watermark delay for a rule.
+func (e *Engine) delay(r Rule) time.Duration {
+	if !r.AutoDelay {
+		return r.Delay
+	}
+	if e.lateness == nil {
+		return DefaultDelay
+	}
+	d, ok := e.lateness(r.Filter.Service)
+	if !ok {
+		return DefaultDelay
+	}
+	// Round up to whole seconds so the delay is never below the observed lateness
+	return min((d + time.Second - 1).Truncate(time.Second), MaxAutoDelay)
+}
+
+func (e *Engine) evaluate(ctx context.Context, rs *ruleState, now time.Time) {
+	delay := e.delay(rs.rule)
+	end := now.Add(-delay)
+	start := end.Add(-rs.rule.Window)
+
+	var value int64
+	var err error
+	if rs.rule.Absent > 0 {
+		value, err = e.silence(ctx, rs.rule, now)
+	} else {
+		filter := rs.rule.Filter
+		filter.StartTime, filter.EndTime = &start, &end
+		value, err = e.store.CountLogs(ctx, filter)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	st := &rs.status
+	prev := st.State
+	st.LastEvaluated = &now
+	st.WindowStart, st.WindowEnd = &start, &end
+	st.DelaySeconds = delay.Seconds()
+	st.Error = ""
+
+	switch {
+	case err != nil:
+		st.State = "error"
+		st.Error = err.Error()
+		slog.Error("alert evaluation failed", "rule", st.Name, "error", err)
+	case rs.rule.breached(value):
+		st.State = "firing"
+		st.Value = value
+	default:
+		st.State = "ok"
+		st.Value = value
+	}
+
+	if st.State == prev {
+		return
+	}
+	st.Since = &now
+	switch {
+	case st.State == "firing":
+		slog.Warn("alert firing", "rule", st.Name, "value", value, "condition", st.Condition,
+			"threshold", st.Threshold, "window_start", start, "window_end", end)
+	case prev == "firing" && st.State == "ok":
+		slog.Info("alert resolved", "rule", st.Name, "value", value)
+	}
+
+	switch {
+	case st.State == "firing" && !rs.notified:
+		rs.notified = true
+		e.notify(ctx, rs.rule, StateFiring, value, start, end, now)
+	case st.State == "ok" && rs.notified:
+		rs.notified = false
+		if rs.rule.NotifyResolved {
+			e.notify(ctx, rs.rule, StateResolved, value, start, end, now)
+		}
+	}
+}
+
+// silence returns the whole seconds since logs matching an absence rule were
+// last ingested. Until any have been, it counts from the engine's start, so a
+// source that never starts logging fires too.
+func (e *Engine) silence(ctx context.Context, r Rule, now time.Time) (int64, error) {
+	ll, ok := e.store.(LastLogger)
+	if !ok {
+		return 0, errors.New("the store doesn't record when logs arrive")
+	}
+	last, found, err := ll.LastLogAt(ctx, r.Filter.Service, r.Filter.Host)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		last = e.started
+	}
+	return int64(max(now.Sub(last), 0) / time.Second), nil
+}
+
+// notify sends a notification to the rule's notifiers in the background so
+// a slow channel doesn't delay evaluation. Deliveries outlive ctx, letting a
+// notification raised at shutdown still go out.
+func (e *Engine) notify(ctx context.Context, r Rule, state string, value int64, start, end, now time.Time) {
+	if len(r.Notifiers) == 0 {
+		return
+	}
+	n := Notification{
+		Rule:      r.Name,
+		State:     state,
+		Value:     value,
+		Condition: r.Condition,
+		Threshold: r.Threshold,
+		Window:    r.Window,
+		Absent:    r.Absent,
+		Start:     start,
+		End:       end,
+		At:        now,
+		Link:      windowLink(r.Link, start, end),
+	}
+	ctx = context.WithoutCancel(ctx)
+	for _, notifier := range r.Notifiers {
+		e.notifying.Add(1)
+		go func() {
+			defer e.notifying.Done()
+			ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+			defer cancel()
+			if err := notifier.Notify(ctx, n); err != nil {
+				slog.Error("alert notification failed", "rule", r.Name, "state", state, "error", err)
+			}
+		}()
+	}
+}
+
+// windowLink adds the window to link as start and end parameters.
+func windowLink(link string, start, end time.Time) string {
+	if link == "" {
+		return ""
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	q := u.Query()
+	q.Set("start", start.UTC().Format(time.RFC3339))
+	q.Set("end", end.UTC().Format(time.RFC3339))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,187 @@
+package alerts
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// fakeCounter returns a fixed count and records the last filter it was given.
+type fakeCounter struct {
+	count int64
+	err   error
+	last  models.LogFilter
+}
+
+func (f *fakeCounter) CountLogs(ctx context.Context, filter models.LogFilter) (int64, error) {
+	f.last = filter
+	return f.count, f.err
+}
+
+func TestEvaluate_Watermark(t *testing.T) {
+	store := &fakeCounter{count: 3}
+	rule := Rule{Name: "errors", Filter: models.LogFilter{Service: "api"}, Window: 5 * time.Minute,
+		Condition: ">", Threshold: 10, Interval: time.Minute, Delay: 2 * time.Minute}
+	e := NewEngine(store, []Rule{rule}, nil)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	e.EvaluateAll(context.Background(), now)
+
+	if want := now.Add(-2 * time.Minute); !store.last.EndTime.Equal(want) {
+		t.Errorf("expected window to end at watermark %v, got %v", want, store.last.EndTime)
+	}
+	if want := now.Add(-7 * time.Minute); !store.last.StartTime.Equal(want) {
+		t.Errorf("expected window to start at %v, got %v", want, store.last.StartTime)
+	}
+	if store.last.Service != "api" {
+		t.Errorf("expected rule filter to be applied, got %+v", store.last)
+	}
+
+	st := e.Status()[0]
+	if st.State != "ok" || st.Value != 3 || st.DelaySeconds != 120 {
+		t.Errorf("unexpected status: %+v", st)
+	}
+}
+
+func TestEvaluate_AutoDelay(t *testing.T) {
+	store := &fakeCounter{}
+	rule := Rule{Name: "r", Filter: models.LogFilter{Service: "api"}, Window: time.Minute,
+		Condition: ">", Threshold: 0, Interval: time.Minute, AutoDelay: true}
+
+	tests := []struct {
+		name     string
+		lateness LatenessFunc
+		want     time.Duration
+	}{
+		{"no stats", nil, DefaultDelay},
+		{"unobserved service", func(string) (time.Duration, bool) { return 0, false }, DefaultDelay},
+		{"rounded up", func(string) (time.Duration, bool) { return 4200 * time.Millisecond, true }, 5 * time.Second},
+		{"capped", func(string) (time.Duration, bool) { return time.Hour, true }, MaxAutoDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine(store, []Rule{rule}, tt.lateness)
+			now := time.Now()
+			e.EvaluateAll(context.Background(), now)
+			if got := now.Sub(*store.last.EndTime); got != tt.want {
+				t.Errorf("expected delay %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEvaluate_Transitions(t *testing.T) {
+	store := &fakeCounter{count: 20}
+	rule := Rule{Name: "r", Window: time.Minute, Condition: ">=", Threshold: 20, Interval: time.Minute}
+	e := NewEngine(store, []Rule{rule}, nil)
+
+	if st := e.Status()[0]; st.State != "pending" {
+		t.Fatalf("expected pending before evaluation, got %s", st.State)
+	}
+
+	first := time.Now()
+	e.EvaluateAll(context.Background(), first)
+	if st := e.Status()[0]; st.State != "firing" || !st.Since.Equal(first) {
+		t.Fatalf("expected firing since first evaluation, got %+v", st)
+	}
+
+	// Still firing: Since is unchanged
+	e.EvaluateAll(context.Background(), first.Add(time.Minute))
+	if st := e.Status()[0]; !st.Since.Equal(first) {
+		t.Errorf("expected since to stay at %v, got %v", first, st.Since)
+	}
+
+	store.count = 5
+	e.EvaluateAll(context.Background(), first.Add(2*time.Minute))
+	if st := e.Status()[0]; st.State != "ok" {
+		t.Errorf("expected resolved, got %s", st.State)
+	}
+
+	store.err = errors.New("database is locked")
+	e.EvaluateAll(context.Background(), first.Add(3*time.Minute))
+	if st := e.Status()[0]; st.State != "error" || st.Error == "" {
+		t.Errorf("expected error state, got %+v", st)
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	valid := Rule{Name: "r", Window: time.Minute, Condition: ">", Interval: time.Minute}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	invalid := []Rule{
+		{Window: time.Minute, Condition: ">", Interval: time.Minute},
+		{Name: "r", Window: time.Minute, Condition: "==", Interval: time.Minute},
+		{Name: "r", Condition: ">", Interval: time.Minute},
+		{Name: "r", Window: time.Minute, Condition: ">"},
+		{Name: "r", Window: time.Minute, Condition: ">", Interval: time.Minute, Delay: -time.Second},
+	}
+	for _, r := range invalid {
+		if err := r.Validate(); err == nil {
+			t.Errorf("expected error for %+v", r)
+		}
+	}
+}
+
+// fakeLastLogger reports a fixed time logs last arrived.
+type fakeLastLogger struct {
+	fakeCounter
+	last                  time.Time
+	lastService, lastHost string
+}
+
+func (f *fakeLastLogger) LastLogAt(ctx context.Context, service, host string) (time.Time, bool, error) {
+	f.lastService, f.lastHost = service, host
+	return f.last, !f.last.IsZero(), f.err
+}
+
+func TestEvaluate_Absence(t *testing.T) {
+	store := &fakeLastLogger{}
+	rec := &recordingNotifier{}
+	rule := AbsenceRule("api-silent", "api", "web-1", 15*time.Minute, time.Minute)
+	rule.Notifiers, rule.NotifyResolved = []Notifier{rec}, true
+	e := NewEngine(store, []Rule{rule}, nil)
+
+	// Nothing ingested yet: silence counts from the engine's start
+	now := e.started.Add(10 * time.Minute)
+	e.EvaluateAll(context.Background(), now)
+	if st := e.Status()[0]; st.State != "ok" || st.Value != 600 || st.AbsentSeconds != 900 {
+		t.Errorf("unexpected status before any logs: %+v", st)
+	}
+	if store.lastService != "api" || store.lastHost != "web-1" {
+		t.Errorf("expected the rule's service and host to be looked up, got %q %q", store.lastService, store.lastHost)
+	}
+
+	store.last = now.Add(-20 * time.Minute)
+	e.EvaluateAll(context.Background(), now)
+	e.Wait()
+	if st := e.Status()[0]; st.State != "firing" || st.Value != 1200 {
+		t.Errorf("expected firing after 20m of silence, got %+v", st)
+	}
+
+	store.last = now.Add(-time.Second)
+	e.EvaluateAll(context.Background(), now)
+	e.Wait()
+	if st := e.Status()[0]; st.State != "ok" || st.Value != 1 {
+		t.Errorf("expected ok once logs arrive, got %+v", st)
+	}
+	if len(rec.sent) != 2 || rec.sent[0].Absent != 15*time.Minute {
+		t.Fatalf("expected firing and resolved notifications, got %+v", rec.sent)
+	}
+	text, _ := render(mustParseTemplate(t, ""), rec.sent[0])
+	if text != "FIRING: api-silent\nno logs for 1200s (alert after 15m0s without logs)" {
+		t.Errorf("unexpected absence message:\n%s", text)
+	}
+
+	// Stores that don't record arrivals can't evaluate absence rules
+	e = NewEngine(&fakeCounter{}, []Rule{rule}, nil)
+	e.EvaluateAll(context.Background(), now)
+	if st := e.Status()[0]; st.State != "error" {
+		t.Errorf("expected an error without arrival times, got %+v", st)
+	}
+}
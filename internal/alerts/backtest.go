@@ -0,0 +1,93 @@
+package alerts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"locog/internal/models"
+)
+
+// MaxBacktestBuckets bounds the histogram a backtest reads. The bucket width
+// is the greatest common divisor of the rule's window and interval.
+const MaxBacktestBuckets = 100000
+
+// ErrBacktestResolution is returned for backtests whose window and interval
+// can't be replayed over the requested range.
+var ErrBacktestResolution = errors.New("backtest resolution")
+
+// Histogrammer counts logs matching a filter in fixed-width time buckets.
+type Histogrammer interface {
+	Histogram(ctx context.Context, filter models.LogFilter, start, end time.Time, buckets int) ([]int64, error)
+}
+
+// Backtest replays r over stored logs between start and end, evaluating it
+// every interval as the engine would have, and reports the periods it would
+// have fired. The watermark delay is ignored since late logs have long
+// arrived. Counts come from one histogram rather than a query per
+// evaluation.
+func Backtest(ctx context.Context, store Histogrammer, r Rule, start, end time.Time) (models.AlertBacktest, error) {
+	bt := models.AlertBacktest{Name: r.Name, Condition: r.Condition, Threshold: r.Threshold,
+		Start: start, End: end, Firings: []models.AlertFiring{}}
+	if err := r.Validate(); err != nil {
+		return bt, err
+	}
+
+	step := gcd(r.Window, r.Interval)
+	if step < time.Second {
+		return bt, fmt.Errorf("%w: alert rule %s: window and interval must be whole seconds", ErrBacktestResolution, r.Name)
+	}
+	buckets := int(end.Sub(start) / step)
+	if buckets > MaxBacktestBuckets {
+		return bt, fmt.Errorf("%w: alert rule %s: needs %d buckets of %s, more than %d; shorten the range or coarsen the interval",
+			ErrBacktestResolution, r.Name, buckets, step, MaxBacktestBuckets)
+	}
+	windowBuckets, intervalBuckets := int(r.Window/step), int(r.Interval/step)
+	if buckets < windowBuckets {
+		return bt, nil
+	}
+	end = start.Add(time.Duration(buckets) * step)
+	bt.End = end
+
+	counts, err := store.Histogram(ctx, r.Filter, start, end, buckets)
+	if err != nil {
+		return bt, err
+	}
+	prefix := make([]int64, len(counts)+1)
+	for i, c := range counts {
+		prefix[i+1] = prefix[i] + c
+	}
+
+	// The first evaluation is the first with a full window of data. A firing
+	// spans consecutive breaching evaluations; its peak is the most extreme
+	// value in the direction of the condition.
+	inFiring := false
+	for e := windowBuckets; e <= buckets; e += intervalBuckets {
+		at := start.Add(time.Duration(e) * step)
+		value := prefix[e] - prefix[e-windowBuckets]
+		bt.Evaluations++
+		if !r.breached(value) {
+			inFiring = false
+			continue
+		}
+		bt.FiringEvaluations++
+		if !inFiring {
+			bt.Firings = append(bt.Firings, models.AlertFiring{Start: at, Peak: value})
+			inFiring = true
+		}
+		f := &bt.Firings[len(bt.Firings)-1]
+		f.End = at
+		if r.Condition[0] == '>' && value > f.Peak || r.Condition[0] == '<' && value < f.Peak {
+			f.Peak = value
+		}
+	}
+	return bt, nil
+}
+
+func gcd(a, b time.Duration) time.Duration {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
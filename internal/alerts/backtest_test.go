@@ -0,0 +1,55 @@
+package alerts
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// fakeHistogram returns fixed bucket counts and records the bucket count asked for.
+type fakeHistogram struct {
+	counts  []int64
+	buckets int
+}
+
+func (f *fakeHistogram) Histogram(ctx context.Context, filter models.LogFilter, start, end time.Time, buckets int) ([]int64, error) {
+	f.buckets = buckets
+	return f.counts, nil
+}
+
+func TestBacktest(t *testing.T) {
+	// Ten one-minute buckets with a burst in the middle
+	store := &fakeHistogram{counts: []int64{1, 0, 2, 9, 8, 1, 0, 0, 7, 0}}
+	rule := Rule{Name: "errors", Window: 2 * time.Minute, Condition: ">", Threshold: 10, Interval: time.Minute}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	bt, err := Backtest(context.Background(), store, rule, start, start.Add(10*time.Minute+30*time.Second))
+	if err != nil {
+		t.Fatalf("Backtest failed: %v", err)
+	}
+	if store.buckets != 10 || !bt.End.Equal(start.Add(10*time.Minute)) {
+		t.Errorf("expected the range trimmed to 10 whole buckets, got %d ending %v", store.buckets, bt.End)
+	}
+	// Windows end at minutes 2..10: sums 1, 2, 11, 17, 9, 1, 0, 7, 7
+	if bt.Evaluations != 9 || bt.FiringEvaluations != 2 {
+		t.Errorf("unexpected evaluations: %+v", bt)
+	}
+	if len(bt.Firings) != 1 || !bt.Firings[0].Start.Equal(start.Add(4*time.Minute)) ||
+		!bt.Firings[0].End.Equal(start.Add(5*time.Minute)) || bt.Firings[0].Peak != 17 {
+		t.Errorf("unexpected firings: %+v", bt.Firings)
+	}
+
+	// A quiet rule fires separately before and after the burst
+	quiet := Rule{Name: "quiet", Window: 2 * time.Minute, Condition: "<", Threshold: 2, Interval: time.Minute}
+	bt, _ = Backtest(context.Background(), store, quiet, start, start.Add(10*time.Minute))
+	if len(bt.Firings) != 2 || !bt.Firings[1].Start.Equal(start.Add(7*time.Minute)) || bt.Firings[1].Peak != 0 {
+		t.Errorf("unexpected quiet backtest: %+v", bt)
+	}
+
+	coarse := Rule{Name: "r", Window: time.Second, Condition: ">", Interval: time.Second}
+	if _, err := Backtest(context.Background(), store, coarse, start, start.Add(30*24*time.Hour)); err == nil {
+		t.Error("expected an error for too many buckets")
+	}
+}
@@ -0,0 +1,188 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Notification states.
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Notification describes a rule starting to fire or resolving.
+type Notification struct {
+	Rule      string
+	State     string // StateFiring or StateResolved
+	Value     int64
+	Condition string
+	Threshold int64
+	Window    time.Duration
+	// Absent is set for absence rules, whose Value is the seconds since
+	// matching logs last arrived.
+	Absent time.Duration
+	Start  time.Time // the evaluated window
+	End    time.Time
+	At     time.Time
+	// Link opens the rule's query over the window in the web UI, or is
+	// empty when no public URL is configured.
+	Link string
+}
+
+// Notifier delivers notifications to a channel such as Slack or email.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// notifyTimeout bounds each delivery.
+const notifyTimeout = 30 * time.Second
+
+// DefaultTemplate renders notifications unless a channel sets its own.
+const DefaultTemplate = `{{if eq .State "firing"}}FIRING{{else}}RESOLVED{{end}}: {{.Rule}}
+{{if .Absent}}{{if eq .State "firing"}}no logs for {{.Value}}s{{else}}logs arriving again{{end}} (alert after {{.Absent}} without logs)
+{{- else}}{{.Value}} matching logs in {{.Window}} (alert when {{.Condition}} {{.Threshold}}){{end}}
+{{- if .Link}}
+{{.Link}}{{end}}`
+
+// ParseTemplate parses a notification template, using DefaultTemplate for
+// an empty text.
+func ParseTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultTemplate
+	}
+	return template.New("notification").Option("missingkey=error").Parse(text)
+}
+
+// render executes tmpl for n.
+func render(tmpl *template.Template, n Notification) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, n); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// SlackAPIURL is the Slack Web API method posting messages with a bot token.
+const SlackAPIURL = "https://slack.com/api/chat.postMessage"
+
+// SlackNotifier posts notifications to Slack, either to an incoming webhook
+// or, with a bot token, to a channel through the Web API.
+type SlackNotifier struct {
+	WebhookURL string
+	Token      string
+	Channel    string
+	Template   *template.Template
+
+	// APIURL overrides SlackAPIURL, for tests
+	APIURL string
+	Client *http.Client
+}
+
+// Notify posts the rendered notification.
+func (s *SlackNotifier) Notify(ctx context.Context, n Notification) error {
+	text, err := render(s.Template, n)
+	if err != nil {
+		return err
+	}
+	target := s.WebhookURL
+	payload := map[string]string{"text": text}
+	if s.Token != "" {
+		target = s.APIURL
+		if target == "" {
+			target = SlackAPIURL
+		}
+		payload["channel"] = s.Channel
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	reply, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack: %s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+	if s.Token != "" {
+		// The Web API answers 200 with ok=false on errors
+		var result struct {
+			OK    bool   `json:"ok"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(reply, &result); err != nil || !result.OK {
+			return fmt.Errorf("slack: %s", result.Error)
+		}
+	}
+	return nil
+}
+
+// EmailNotifier sends notifications as plain-text email over SMTP. With a
+// username it authenticates with PLAIN, which net/smtp only allows over TLS
+// (STARTTLS) or to localhost.
+type EmailNotifier struct {
+	Addr     string // host:port
+	Username string
+	Password string
+	From     string
+	To       []string
+	Template *template.Template
+}
+
+// Notify sends the rendered notification, its first line as the subject.
+func (e *EmailNotifier) Notify(ctx context.Context, n Notification) error {
+	text, err := render(e.Template, n)
+	if err != nil {
+		return err
+	}
+	subject, _, _ := strings.Cut(text, "\n")
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [locog] %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.At.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, _ := net.SplitHostPort(e.Addr)
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	// net/smtp has no context support; run it so the deadline still applies
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(e.Addr, auth, e.From, e.To, msg.Bytes()) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,223 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"locog/internal/models"
+)
+
+// recordingNotifier records the notifications it is given.
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (r *recordingNotifier) Notify(ctx context.Context, n Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+// TestEngine_Notify tests that firing and resolution are notified once each,
+// across an evaluation error, with a link to the window.
+func TestEngine_Notify(t *testing.T) {
+	store := &fakeCounter{count: 20}
+	rec := &recordingNotifier{}
+	rule := Rule{Name: "errors", Filter: models.LogFilter{Service: "api"}, Window: 5 * time.Minute,
+		Condition: ">", Threshold: 10, Interval: time.Minute,
+		Notifiers: []Notifier{rec}, NotifyResolved: true, Link: "https://logs.example.com/?service=api"}
+	e := NewEngine(store, []Rule{rule}, nil)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	step := func(count int64, err error) {
+		store.count, store.err = count, err
+		e.EvaluateAll(context.Background(), now)
+		e.Wait()
+		now = now.Add(time.Minute)
+	}
+	step(20, nil)
+	step(30, nil)                        // still firing
+	step(0, errors.New("database gone")) // error while firing
+	step(25, nil)                        // firing again, already notified
+	step(2, nil)                         // resolved
+	step(1, nil)
+
+	if len(rec.sent) != 2 {
+		t.Fatalf("expected a firing and a resolved notification, got %+v", rec.sent)
+	}
+	firing, resolved := rec.sent[0], rec.sent[1]
+	if firing.State != StateFiring || firing.Value != 20 || firing.Threshold != 10 {
+		t.Errorf("unexpected firing notification: %+v", firing)
+	}
+	if want := "https://logs.example.com/?end=2025-01-01T12%3A00%3A00Z&service=api&start=2025-01-01T11%3A55%3A00Z"; firing.Link != want {
+		t.Errorf("expected link %s, got %s", want, firing.Link)
+	}
+	if resolved.State != StateResolved || resolved.Value != 2 {
+		t.Errorf("unexpected resolved notification: %+v", resolved)
+	}
+
+	text, err := render(mustParseTemplate(t, ""), firing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(text, "FIRING: errors\n20 matching logs in 5m0s (alert when > 10)\nhttps://") {
+		t.Errorf("unexpected default message:\n%s", text)
+	}
+}
+
+// TestEngine_NotifyResolvedOff tests that resolutions aren't notified unless
+// asked for.
+func TestEngine_NotifyResolvedOff(t *testing.T) {
+	store := &fakeCounter{count: 20}
+	rec := &recordingNotifier{}
+	rule := Rule{Name: "errors", Window: time.Minute, Condition: ">", Threshold: 10, Interval: time.Minute,
+		Notifiers: []Notifier{rec}}
+	e := NewEngine(store, []Rule{rule}, nil)
+
+	e.EvaluateAll(context.Background(), time.Now())
+	store.count = 0
+	e.EvaluateAll(context.Background(), time.Now())
+	e.Wait()
+	if len(rec.sent) != 1 || rec.sent[0].State != StateFiring || rec.sent[0].Link != "" {
+		t.Errorf("expected only the firing notification without a link, got %+v", rec.sent)
+	}
+}
+
+func mustParseTemplate(t *testing.T, text string) *template.Template {
+	t.Helper()
+	tmpl, err := ParseTemplate(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tmpl
+}
+
+func TestSlackNotifier(t *testing.T) {
+	var got []map[string]string
+	var auth []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		got = append(got, body)
+		auth = append(auth, r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/webhook":
+			w.Write([]byte("ok"))
+		case "/api":
+			if body["channel"] == "#missing" {
+				w.Write([]byte(`{"ok":false,"error":"channel_not_found"}`))
+				return
+			}
+			w.Write([]byte(`{"ok":true}`))
+		default:
+			http.Error(w, "no_service", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	n := Notification{Rule: "errors", State: StateResolved, Value: 1}
+	tmpl := mustParseTemplate(t, "{{.State}} {{.Rule}}")
+
+	webhook := &SlackNotifier{WebhookURL: srv.URL + "/webhook", Template: tmpl}
+	if err := webhook.Notify(context.Background(), n); err != nil {
+		t.Fatalf("webhook: %v", err)
+	}
+	bot := &SlackNotifier{Token: "xoxb-1", Channel: "#ops", APIURL: srv.URL + "/api", Template: tmpl}
+	if err := bot.Notify(context.Background(), n); err != nil {
+		t.Fatalf("bot: %v", err)
+	}
+	if got[0]["text"] != "resolved errors" || got[0]["channel"] != "" || auth[0] != "" {
+		t.Errorf("unexpected webhook post %v %q", got[0], auth[0])
+	}
+	if got[1]["text"] != "resolved errors" || got[1]["channel"] != "#ops" || auth[1] != "Bearer xoxb-1" {
+		t.Errorf("unexpected API post %v %q", got[1], auth[1])
+	}
+
+	bot.Channel = "#missing"
+	if err := bot.Notify(context.Background(), n); err == nil || !strings.Contains(err.Error(), "channel_not_found") {
+		t.Errorf("expected the API error, got %v", err)
+	}
+	webhook.WebhookURL = srv.URL + "/gone"
+	if err := webhook.Notify(context.Background(), n); err == nil {
+		t.Error("expected an error for a failed webhook")
+	}
+}
+
+// fakeSMTP accepts one message without authentication and returns it.
+func fakeSMTP(t *testing.T) (addr string, message <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	out := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ready")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line + " ")[0]); cmd {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 localhost")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, _ := io.ReadAll(tp.DotReader())
+				out <- string(data)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), out
+}
+
+func TestEmailNotifier(t *testing.T) {
+	addr, message := fakeSMTP(t)
+	e := &EmailNotifier{Addr: addr, From: "locog@example.com", To: []string{"ops@example.com", "dev@example.com"},
+		Template: mustParseTemplate(t, "")}
+	n := Notification{Rule: "errors", State: StateFiring, Value: 12, Condition: ">", Threshold: 10,
+		Window: time.Minute, At: time.Now(), Link: "https://logs.example.com/?level=ERROR"}
+	if err := e.Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-message:
+		for _, want := range []string{
+			"To: ops@example.com, dev@example.com\n", // the dot reader turns CRLF into LF
+			"Subject: [locog] FIRING: errors\n",
+			"12 matching logs in 1m0s (alert when > 10)\nhttps://logs.example.com/?level=ERROR",
+		} {
+			if !strings.Contains(msg, want) {
+				t.Errorf("expected %q in message:\n%s", want, msg)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+}
@@ -0,0 +1,438 @@
+// Package archive writes and verifies the archives tag policies with an
+// export_dir append tagged logs to. The archive of a tag is a data file,
+// <tag>.ndjson or <tag>.log for text exports, and a manifest next to it,
+// <tag>.ndjson.manifest.json, recording the logs' count and time range and,
+// for each chunk appended by an export, its offset, size and SHA-256 hash.
+// Verify checks a data file against its manifest, so archives can be
+// checked for truncation and corruption before the logs are deleted, or
+// after they were copied to cold storage.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"locog/internal/logtext"
+	"locog/internal/models"
+)
+
+// SchemaVersion is the manifest layout written by this version. Verify
+// rejects manifests of a later version.
+const SchemaVersion = 1
+
+// ManifestSuffix is appended to a data file's name to name its manifest.
+const ManifestSuffix = ".manifest.json"
+
+// Formats of data files, by their extension.
+const (
+	FormatNDJSON = "ndjson" // <tag>.ndjson, one JSON log per line
+	FormatText   = "text"   // <tag>.log, rendered with the policy's template
+)
+
+// Verification statuses, in increasing severity.
+const (
+	StatusOK         = "ok"
+	StatusIncomplete = "incomplete" // data or manifest missing or truncated
+	StatusCorrupt    = "corrupt"    // content differs from the manifest
+)
+
+// Manifest describes a data file.
+type Manifest struct {
+	SchemaVersion  int        `json:"schema_version"`
+	Tag            string     `json:"tag"`
+	File           string     `json:"file"` // data file, in the manifest's directory
+	Format         string     `json:"format"`
+	Count          int64      `json:"count"`
+	Bytes          int64      `json:"bytes"`
+	FirstTimestamp *time.Time `json:"first_timestamp,omitempty"`
+	LastTimestamp  *time.Time `json:"last_timestamp,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	Chunks         []Chunk    `json:"chunks"`
+}
+
+// Chunk is the part of a data file written by one export.
+type Chunk struct {
+	Offset         int64      `json:"offset"`
+	Bytes          int64      `json:"bytes"`
+	Count          int64      `json:"count"`
+	FirstTimestamp *time.Time `json:"first_timestamp,omitempty"`
+	LastTimestamp  *time.Time `json:"last_timestamp,omitempty"`
+	SHA256         string     `json:"sha256"`
+	WrittenAt      time.Time  `json:"written_at"`
+	// Legacy marks what a data file held before it had a manifest. Its
+	// time range is unknown, as is its count for text exports.
+	Legacy bool `json:"legacy,omitempty"`
+}
+
+// DataFile returns the name of tag's data file in the format.
+func DataFile(tag, format string) string {
+	if format == FormatText {
+		return tag + ".log"
+	}
+	return tag + ".ndjson"
+}
+
+// parseDataFile returns the tag and format of a data file name, or ok false
+// for other files.
+func parseDataFile(name string) (tag, format string, ok bool) {
+	switch ext := path.Ext(name); ext {
+	case ".ndjson":
+		return strings.TrimSuffix(name, ext), FormatNDJSON, true
+	case ".log":
+		return strings.TrimSuffix(name, ext), FormatText, true
+	}
+	return "", "", false
+}
+
+// ReadManifest reads the manifest of the data file in dir.
+func ReadManifest(dir, file string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, file+ManifestSuffix))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// writeManifest replaces the manifest of m.File in dir, through a temporary
+// file so it is never left half written.
+func writeManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+m.File+ManifestSuffix+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, m.File+ManifestSuffix))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Append appends logs to tag's data file in dir as one chunk, as NDJSON or,
+// with tmpl, as text, and records the chunk in the manifest once the data
+// is synced. Bytes past the end the manifest records are from an export
+// interrupted before its logs were marked as exported, so they are
+// overwritten. A data file shorter than its manifest records is an error.
+func Append(dir, tag string, tmpl *template.Template, logs []models.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	format := FormatNDJSON
+	if tmpl != nil {
+		format = FormatText
+	}
+	file := DataFile(tag, format)
+	m, err := ReadManifest(dir, file)
+	if errors.Is(err, fs.ErrNotExist) {
+		m, err = adopt(dir, tag, format)
+	}
+	if err != nil {
+		return fmt.Errorf("archive %s: %w", file, err)
+	}
+
+	var buf bytes.Buffer
+	if tmpl != nil {
+		err = logtext.Write(&buf, tmpl, logs)
+	} else {
+		enc := json.NewEncoder(&buf)
+		for _, l := range logs {
+			if err = enc.Encode(l); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("archive %s: %w", file, err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, file), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < m.Bytes {
+		return fmt.Errorf("archive %s: data file has %d bytes, its manifest records %d", file, info.Size(), m.Bytes)
+	}
+	if info.Size() > m.Bytes {
+		if err := f.Truncate(m.Bytes); err != nil {
+			return err
+		}
+	}
+	if _, err := f.WriteAt(buf.Bytes(), m.Bytes); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	now := time.Now().UTC()
+	chunk := Chunk{Offset: m.Bytes, Bytes: int64(buf.Len()), Count: int64(len(logs)), SHA256: hex.EncodeToString(sum[:]), WrittenAt: now}
+	for _, l := range logs {
+		ts := l.Timestamp.UTC()
+		if chunk.FirstTimestamp == nil || ts.Before(*chunk.FirstTimestamp) {
+			chunk.FirstTimestamp = &ts
+		}
+		if chunk.LastTimestamp == nil || ts.After(*chunk.LastTimestamp) {
+			chunk.LastTimestamp = &ts
+		}
+	}
+	m.Chunks = append(m.Chunks, chunk)
+	m.Count += chunk.Count
+	m.Bytes += chunk.Bytes
+	if m.FirstTimestamp == nil || chunk.FirstTimestamp.Before(*m.FirstTimestamp) {
+		m.FirstTimestamp = chunk.FirstTimestamp
+	}
+	if m.LastTimestamp == nil || chunk.LastTimestamp.After(*m.LastTimestamp) {
+		m.LastTimestamp = chunk.LastTimestamp
+	}
+	m.UpdatedAt = now
+	return writeManifest(dir, m)
+}
+
+// adopt returns a new manifest for tag's data file in dir. The content of a
+// data file written before archives had manifests becomes a legacy chunk,
+// hashed as it is now.
+func adopt(dir, tag, format string) (*Manifest, error) {
+	now := time.Now().UTC()
+	m := &Manifest{SchemaVersion: SchemaVersion, Tag: tag, File: DataFile(tag, format), Format: format,
+		CreatedAt: now, UpdatedAt: now, Chunks: []Chunk{}}
+	f, err := os.Open(filepath.Join(dir, m.File))
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	var lines int64
+	r := bufio.NewReader(io.TeeReader(f, h))
+	for {
+		line, err := r.ReadSlice('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			lines++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+	}
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return m, err
+	}
+	chunk := Chunk{Bytes: info.Size(), SHA256: hex.EncodeToString(h.Sum(nil)), WrittenAt: info.ModTime().UTC(), Legacy: true}
+	if format == FormatNDJSON {
+		chunk.Count = lines
+	}
+	m.Chunks = append(m.Chunks, chunk)
+	m.Count, m.Bytes = chunk.Count, chunk.Bytes
+	return m, nil
+}
+
+// Adopt writes a manifest for every data file in dir whose tag matches
+// pattern and that was written before archives had manifests, so they can
+// be verified. It returns the number adopted.
+func Adopt(dir, pattern string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	adopted := 0
+	for _, e := range entries {
+		tag, format, ok := parseDataFile(e.Name())
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+		if match, _ := path.Match(pattern, tag); !match {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, e.Name()+ManifestSuffix)); !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		m, err := adopt(dir, tag, format)
+		if err != nil {
+			return adopted, fmt.Errorf("archive %s: %w", e.Name(), err)
+		}
+		if err := writeManifest(dir, m); err != nil {
+			return adopted, err
+		}
+		adopted++
+	}
+	return adopted, nil
+}
+
+// Verify checks the data file in dir against its manifest: that the
+// manifest is consistent, that the data file has exactly the bytes it
+// records and that every chunk's SHA-256 hash matches.
+func Verify(dir, file string) models.ArchiveReport {
+	tag, format, _ := parseDataFile(file)
+	r := models.ArchiveReport{Tag: tag, Dir: dir, File: file, Format: format, Status: StatusOK}
+	m, err := ReadManifest(dir, file)
+	if errors.Is(err, fs.ErrNotExist) {
+		Fail(&r, StatusIncomplete, "no manifest")
+		return r
+	}
+	if err != nil {
+		Fail(&r, StatusCorrupt, "%v", err)
+		return r
+	}
+	r.Tag, r.Format, r.SchemaVersion = m.Tag, m.Format, m.SchemaVersion
+	r.Count, r.Bytes, r.Chunks = m.Count, m.Bytes, len(m.Chunks)
+	r.FirstTimestamp, r.LastTimestamp = m.FirstTimestamp, m.LastTimestamp
+	if m.SchemaVersion < 1 || m.SchemaVersion > SchemaVersion {
+		Fail(&r, StatusCorrupt, "unsupported schema version %d; this version of locog reads version %d", m.SchemaVersion, SchemaVersion)
+		return r
+	}
+	if m.File != file {
+		Fail(&r, StatusCorrupt, "manifest describes %s", m.File)
+		return r
+	}
+
+	var offset, count int64
+	for i, c := range m.Chunks {
+		r.Legacy = r.Legacy || c.Legacy
+		if c.Offset != offset {
+			Fail(&r, StatusCorrupt, "chunk %d starts at byte %d, the previous one ends at %d", i, c.Offset, offset)
+		}
+		offset = c.Offset + c.Bytes
+		count += c.Count
+	}
+	if offset != m.Bytes || count != m.Count {
+		Fail(&r, StatusCorrupt, "chunks hold %d logs in %d bytes, the manifest records %d in %d", count, offset, m.Count, m.Bytes)
+	}
+
+	f, err := os.Open(filepath.Join(dir, file))
+	if errors.Is(err, fs.ErrNotExist) {
+		Fail(&r, StatusIncomplete, "data file missing")
+		return r
+	}
+	if err != nil {
+		Fail(&r, StatusIncomplete, "%v", err)
+		return r
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		Fail(&r, StatusIncomplete, "%v", err)
+		return r
+	}
+	switch size := info.Size(); {
+	case size < m.Bytes:
+		Fail(&r, StatusIncomplete, "data file has %d bytes, the manifest records %d", size, m.Bytes)
+	case size > m.Bytes:
+		r.Warnings = append(r.Warnings, fmt.Sprintf("%d bytes past the end of the last chunk, from an interrupted export; the next export overwrites them", size-m.Bytes))
+	}
+
+	for i, c := range m.Chunks {
+		if c.Offset+c.Bytes > info.Size() {
+			Fail(&r, StatusIncomplete, "chunk %d (bytes %d-%d) is truncated", i, c.Offset, c.Offset+c.Bytes)
+			continue
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(f, c.Offset, c.Bytes)); err != nil {
+			Fail(&r, StatusIncomplete, "chunk %d: %v", i, err)
+			continue
+		}
+		if hex.EncodeToString(h.Sum(nil)) != c.SHA256 {
+			Fail(&r, StatusCorrupt, "chunk %d (bytes %d-%d) doesn't match its SHA-256 hash", i, c.Offset, c.Offset+c.Bytes)
+		}
+	}
+	return r
+}
+
+// VerifyDir verifies every archive in dir: each data file with a manifest,
+// each manifest whose data file is missing and each data file without a
+// manifest, by file name.
+func VerifyDir(dir string) ([]models.ArchiveReport, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool)
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") || e.IsDir() {
+			continue
+		}
+		name = strings.TrimSuffix(name, ManifestSuffix)
+		if _, _, ok := parseDataFile(name); ok {
+			files[name] = true
+		}
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reports := make([]models.ArchiveReport, 0, len(names))
+	for _, name := range names {
+		reports = append(reports, Verify(dir, name))
+	}
+	return reports, nil
+}
+
+// Fail records a problem with the archive, raising its status to status if
+// that is more severe.
+func Fail(r *models.ArchiveReport, status, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+	if severity(status) > severity(r.Status) {
+		r.Status = status
+	}
+}
+
+func severity(status string) int {
+	switch status {
+	case StatusCorrupt:
+		return 2
+	case StatusIncomplete:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestAppendVerify tests appending chunks and detecting truncated, corrupted
+// and interrupted data files.
+func TestAppendVerify(t *testing.T) {
+	dir := t.TempDir()
+	ts := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	logs := []models.Log{
+		{ID: 1, Timestamp: ts, Service: "api", Level: "ERROR", Message: "boom", Host: "h"},
+		{ID: 2, Timestamp: ts.Add(time.Minute), Service: "api", Level: "INFO", Message: "retry", Host: "h"},
+	}
+	if err := Append(dir, "incident-1", nil, logs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := Append(dir, "incident-1", nil, logs[1:]); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadManifest(dir, "incident-1.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.SchemaVersion != SchemaVersion || m.Count != 2 || len(m.Chunks) != 2 || m.Chunks[1].Offset != m.Chunks[0].Bytes ||
+		!m.FirstTimestamp.Equal(ts) || !m.LastTimestamp.Equal(ts.Add(time.Minute)) {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+	if r := Verify(dir, "incident-1.ndjson"); r.Status != StatusOK || r.Count != 2 || r.Chunks != 2 || len(r.Problems) > 0 {
+		t.Fatalf("unexpected report %+v", r)
+	}
+
+	path := filepath.Join(dir, "incident-1.ndjson")
+	data, _ := os.ReadFile(path)
+
+	// Bytes of an interrupted export are reported, then overwritten
+	os.WriteFile(path, append(data, `{"partial`...), 0o644)
+	if r := Verify(dir, "incident-1.ndjson"); r.Status != StatusOK || len(r.Warnings) != 1 {
+		t.Errorf("expected a warning for trailing bytes, got %+v", r)
+	}
+	if err := Append(dir, "incident-1", nil, logs[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if r := Verify(dir, "incident-1.ndjson"); r.Status != StatusOK || r.Count != 3 || len(r.Warnings) > 0 {
+		t.Errorf("expected the trailing bytes overwritten, got %+v", r)
+	}
+
+	data, _ = os.ReadFile(path)
+	corrupted := []byte(strings.Replace(string(data), "retry", "RETRY", 1))
+	os.WriteFile(path, corrupted, 0o644)
+	if r := Verify(dir, "incident-1.ndjson"); r.Status != StatusCorrupt || len(r.Problems) != 1 || !strings.Contains(r.Problems[0], "chunk 1") {
+		t.Errorf("expected chunk 1 corrupt, got %+v", r)
+	}
+
+	os.WriteFile(path, data[:len(data)-1], 0o644)
+	if r := Verify(dir, "incident-1.ndjson"); r.Status != StatusIncomplete {
+		t.Errorf("expected a truncated archive incomplete, got %+v", r)
+	}
+	if err := Append(dir, "incident-1", nil, logs[1:]); err == nil {
+		t.Error("expected appending to a truncated archive to fail")
+	}
+
+	os.Remove(path)
+	if r := Verify(dir, "incident-1.ndjson"); r.Status != StatusIncomplete || r.Problems[0] != "data file missing" {
+		t.Errorf("expected a missing data file incomplete, got %+v", r)
+	}
+}
+
+// TestAdopt tests writing manifests for archives that predate them.
+func TestAdopt(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "incident-1.ndjson"), []byte("{}\n{}\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "incident-2.log"), []byte("[ERROR] boom\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "other.ndjson"), []byte("{}\n"), 0o644)
+
+	reports, err := VerifyDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 3 || reports[0].Status != StatusIncomplete || reports[0].Problems[0] != "no manifest" {
+		t.Fatalf("expected archives without manifests incomplete, got %+v", reports)
+	}
+
+	if n, err := Adopt(dir, "incident-*"); err != nil || n != 2 {
+		t.Fatalf("Adopt = %d, %v", n, err)
+	}
+	reports, _ = VerifyDir(dir)
+	for _, r := range reports {
+		want := StatusOK
+		if r.Tag == "other" {
+			want = StatusIncomplete
+		}
+		if r.Status != want {
+			t.Errorf("unexpected report %+v", r)
+		}
+	}
+	if r := reports[0]; r.Tag != "incident-1" || r.Count != 2 || !r.Legacy {
+		t.Errorf("expected the NDJSON lines counted, got %+v", r)
+	}
+}
@@ -0,0 +1,234 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+	"locog/internal/tracing"
+)
+
+// allowedGroupByColumns are the log columns AggregateMetadata may group by.
+var allowedGroupByColumns = map[string]bool{
+	"service": true,
+	"level":   true,
+	"host":    true,
+}
+
+// groupByExpr returns the SQL expression and arguments for grouping by a log
+// column, a derived field ("derived.<name>"), a metadata key
+// ("metadata.<key>"), or "" for a single group. Logs without the metadata key
+// form the "" group.
+func (db *DB) groupByExpr(groupBy string) (string, []interface{}, error) {
+	if groupBy == "" {
+		return "''", nil, nil
+	}
+	if name, ok := strings.CutPrefix(groupBy, "derived."); ok {
+		expr, ok := db.derivedSQL(name)
+		if !ok {
+			return "", nil, fmt.Errorf("unknown derived field: %s", name)
+		}
+		return "COALESCE(CAST(" + expr + " AS TEXT), '')", nil, nil
+	}
+	if key, ok := strings.CutPrefix(groupBy, "metadata."); ok {
+		value, args := db.metaValue(key)
+		return "COALESCE(CAST(" + value + " AS TEXT), '')", args, nil
+	}
+	if !allowedGroupByColumns[groupBy] {
+		return "", nil, fmt.Errorf("invalid group by column: %s", groupBy)
+	}
+	return "COALESCE(" + groupBy + ", '')", nil, nil
+}
+
+// bucketExpr returns the SQL expression for the Unix time of the start of a
+// log's time bucket, or a constant 0 without buckets.
+func (db *DB) bucketExpr(bucket time.Duration) (string, error) {
+	if bucket <= 0 {
+		return "0", nil
+	}
+	secs := int64(bucket.Seconds())
+	if secs < 1 {
+		return "", fmt.Errorf("bucket must be at least 1s, got %s", bucket)
+	}
+	return fmt.Sprintf("(%s / %d) * %d", db.dialect.unixSeconds("timestamp"), secs, secs), nil
+}
+
+// AggregateMetadata computes count/avg/min/max and percentiles of a numeric
+// metadata field over logs matching filter, grouped by groupBy (see
+// groupByExpr) and optionally by fixed-width time buckets.
+// Non-numeric values are ignored.
+func (db *DB) AggregateMetadata(ctx context.Context, filter models.LogFilter, field, groupBy string, bucket time.Duration) ([]models.MetricAggregate, error) {
+	groupExpr, groupArgs, err := db.groupByExpr(groupBy)
+	if err != nil {
+		return nil, err
+	}
+	bucketExpr, err := db.bucketExpr(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	// Restrict to numeric values using the same guard as metadata filters
+	path := db.dialect.metaPath(field)
+	numeric, numericArgs := db.dialect.numericGuard(path)
+	where, args := db.buildWhere(filter)
+	where += " AND " + numeric
+	args = append(args, numericArgs...)
+
+	// Ordering by value lets percentiles be computed in one streaming pass
+	value, valueArgs := db.dialect.metaNumber(path)
+	query := fmt.Sprintf(`SELECT %s AS grp, %s AS bucket, %s AS value
+		FROM logs%s ORDER BY grp, bucket, value`, groupExpr, bucketExpr, value, where)
+	args = append(append(groupArgs, valueArgs...), args...)
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		results     []models.MetricAggregate
+		values      []float64
+		curGroup    string
+		curBucket   int64
+		haveCurrent bool
+	)
+
+	flush := func() {
+		if len(values) == 0 {
+			return
+		}
+		agg := summarize(values)
+		agg.Group = curGroup
+		if bucket > 0 {
+			t := time.Unix(curBucket, 0).UTC()
+			agg.Bucket = &t
+		}
+		results = append(results, agg)
+		values = values[:0]
+	}
+
+	for rows.Next() {
+		var group string
+		var b int64
+		var v float64
+		if err := rows.Scan(&group, &b, &v); err != nil {
+			return nil, err
+		}
+		if !haveCurrent || group != curGroup || b != curBucket {
+			flush()
+			curGroup, curBucket, haveCurrent = group, b, true
+		}
+		values = append(values, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	return results, nil
+}
+
+// CountLogsByGroup counts the logs matching filter per group (see
+// groupByExpr), most frequent first, keeping the limit largest groups. With a
+// bucket the counts are also split by time bucket, ordered by group rank and
+// then time; buckets without logs are omitted.
+func (db *DB) CountLogsByGroup(ctx context.Context, filter models.LogFilter, groupBy string, bucket time.Duration, limit int) ([]models.GroupCount, error) {
+	ctx, span := db.startSpan(ctx, "CountLogsByGroup", tracing.String("db.group_by", groupBy))
+	defer span.End()
+
+	groupExpr, groupArgs, err := db.groupByExpr(groupBy)
+	if err != nil {
+		return nil, err
+	}
+	where, whereArgs := db.buildWhere(filter)
+
+	query := `SELECT ` + groupExpr + ` AS grp, COUNT(*) AS n FROM logs` + where +
+		` GROUP BY grp ORDER BY n DESC, grp LIMIT ?`
+	args := append(append(groupArgs, whereArgs...), limit)
+	totals, err := db.scanGroupCounts(ctx, query, args, false)
+	if err != nil || bucket <= 0 || len(totals) == 0 {
+		return totals, err
+	}
+
+	// Split the top groups by bucket
+	bucketExpr, err := db.bucketExpr(bucket)
+	if err != nil {
+		return nil, err
+	}
+	rank := make(map[string]int, len(totals))
+	inArgs := make([]interface{}, 0, len(totals))
+	for i, g := range totals {
+		rank[g.Group] = i
+		inArgs = append(inArgs, g.Group)
+	}
+	query = `SELECT ` + groupExpr + ` AS grp, ` + bucketExpr + ` AS bucket, COUNT(*) FROM logs` + where +
+		` AND ` + groupExpr + ` IN (?` + strings.Repeat(", ?", len(inArgs)-1) + `) GROUP BY grp, bucket`
+	args = append(append(append(groupArgs, whereArgs...), groupArgs...), inArgs...)
+	counts, err := db.scanGroupCounts(ctx, query, args, true)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(counts, func(a, b models.GroupCount) int {
+		return cmp.Or(cmp.Compare(rank[a.Group], rank[b.Group]), a.Bucket.Compare(*b.Bucket))
+	})
+	return counts, nil
+}
+
+func (db *DB) scanGroupCounts(ctx context.Context, query string, args []interface{}, bucketed bool) ([]models.GroupCount, error) {
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []models.GroupCount
+	for rows.Next() {
+		var c models.GroupCount
+		var bucket int64
+		dest := []interface{}{&c.Group, &c.Count}
+		if bucketed {
+			dest = []interface{}{&c.Group, &bucket, &c.Count}
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if bucketed {
+			t := time.Unix(bucket, 0).UTC()
+			c.Bucket = &t
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// summarize computes statistics over values, which must be sorted ascending.
+func summarize(values []float64) models.MetricAggregate {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return models.MetricAggregate{
+		Count: int64(len(values)),
+		Avg:   sum / float64(len(values)),
+		Min:   values[0],
+		Max:   values[len(values)-1],
+		P50:   percentile(values, 50),
+		P95:   percentile(values, 95),
+		P99:   percentile(values, 99),
+	}
+}
+
+// percentile returns the nearest-rank percentile p (0-100) of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
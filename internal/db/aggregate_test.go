@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestAggregateMetadata_GroupByService(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	for i := 1; i <= 100; i++ {
+		db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "req", Host: "h",
+			Metadata: map[string]interface{}{"duration_ms": i}})
+	}
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "worker", Level: "info", Message: "job", Host: "h",
+		Metadata: map[string]interface{}{"duration_ms": "42"}})
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "worker", Level: "info", Message: "job", Host: "h",
+		Metadata: map[string]interface{}{"duration_ms": "n/a"}})
+
+	results, err := db.AggregateMetadata(ctx, models.LogFilter{}, "duration_ms", "service", 0)
+	if err != nil {
+		t.Fatalf("AggregateMetadata failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(results))
+	}
+
+	api := results[0]
+	if api.Group != "api" || api.Count != 100 {
+		t.Fatalf("expected api group with 100 values, got %+v", api)
+	}
+	if api.Avg != 50.5 || api.Min != 1 || api.Max != 100 || api.P50 != 50 || api.P95 != 95 || api.P99 != 99 {
+		t.Errorf("unexpected api stats: %+v", api)
+	}
+	if api.Bucket != nil {
+		t.Error("expected no bucket without a bucket size")
+	}
+
+	worker := results[1]
+	if worker.Count != 1 || worker.Max != 42 {
+		t.Errorf("expected worker stats to ignore non-numeric values, got %+v", worker)
+	}
+}
+
+func TestAggregateMetadata_TimeBuckets(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	base := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i, d := range []int{10, 20, 300} {
+		ts := base.Add(time.Duration(i) * 30 * time.Minute)
+		db.InsertLog(ctx, &models.Log{Timestamp: ts, Service: "api", Level: "info", Message: "req", Host: "h",
+			Metadata: map[string]interface{}{"duration_ms": d}})
+	}
+
+	results, err := db.AggregateMetadata(ctx, models.LogFilter{}, "duration_ms", "", time.Hour)
+	if err != nil {
+		t.Fatalf("AggregateMetadata failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 hourly buckets, got %d", len(results))
+	}
+	if !results[0].Bucket.Equal(base) || results[0].Count != 2 {
+		t.Errorf("unexpected first bucket: %+v", results[0])
+	}
+	if !results[1].Bucket.Equal(base.Add(time.Hour)) || results[1].Max != 300 {
+		t.Errorf("unexpected second bucket: %+v", results[1])
+	}
+}
+
+func TestAggregateMetadata_InvalidGroupBy(t *testing.T) {
+	db := newTestDB(t)
+
+	if _, err := db.AggregateMetadata(context.Background(), models.LogFilter{}, "duration_ms", "message; DROP TABLE logs", 0); err == nil {
+		t.Error("expected error for invalid group by column")
+	}
+}
+
+func TestCountLogsByGroup(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	base := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+	for i, svc := range []string{"api", "api", "api", "worker", "worker", "cron"} {
+		db.InsertLog(ctx, &models.Log{Timestamp: base.Add(time.Duration(i) * 40 * time.Minute), Service: svc, Level: "ERROR",
+			Message: "m", Host: "h", Metadata: map[string]interface{}{"region": "eu"}})
+	}
+
+	counts, err := db.CountLogsByGroup(ctx, models.LogFilter{}, "service", 0, 2)
+	if err != nil {
+		t.Fatalf("CountLogsByGroup failed: %v", err)
+	}
+	if len(counts) != 2 || counts[0].Group != "api" || counts[0].Count != 3 || counts[1].Group != "worker" || counts[1].Count != 2 {
+		t.Errorf("unexpected counts: %+v", counts)
+	}
+
+	counts, err = db.CountLogsByGroup(ctx, models.LogFilter{}, "metadata.region", 0, 10)
+	if err != nil {
+		t.Fatalf("CountLogsByGroup by metadata failed: %v", err)
+	}
+	if len(counts) != 1 || counts[0].Group != "eu" || counts[0].Count != 6 {
+		t.Errorf("unexpected metadata counts: %+v", counts)
+	}
+
+	// api logs fall at +0m, +40m and +80m, worker logs at +120m and +160m
+	counts, err = db.CountLogsByGroup(ctx, models.LogFilter{}, "service", time.Hour, 2)
+	if err != nil {
+		t.Fatalf("CountLogsByGroup with buckets failed: %v", err)
+	}
+	want := []struct {
+		group  string
+		bucket time.Time
+		count  int64
+	}{
+		{"api", base, 2},
+		{"api", base.Add(time.Hour), 1},
+		{"worker", base.Add(2 * time.Hour), 2},
+	}
+	if len(counts) != len(want) {
+		t.Fatalf("expected %d group buckets, got %+v", len(want), counts)
+	}
+	for i, w := range want {
+		c := counts[i]
+		if c.Group != w.group || c.Count != w.count || c.Bucket == nil || !c.Bucket.Equal(w.bucket) {
+			t.Errorf("bucket %d: expected %s@%s=%d, got %+v", i, w.group, w.bucket, w.count, c)
+		}
+	}
+}
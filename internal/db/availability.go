@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+)
+
+// Availability counts the logs and error logs (those at errorLevels) of each
+// service matching filter, in buckets of width starting at start. A service's
+// availability is the share of its logs that aren't errors; buckets without
+// logs have none. Services are sorted by name.
+func (db *DB) Availability(ctx context.Context, filter models.LogFilter, errorLevels []string, start time.Time, width time.Duration, buckets int) ([]models.ServiceAvailability, error) {
+	end := start.Add(time.Duration(buckets) * width)
+	filter.StartTime, filter.EndTime = &start, &end
+	where, whereArgs := db.buildWhere(filter)
+
+	isError := "UPPER(level) IN (?" + strings.Repeat(", ?", len(errorLevels)-1) + ")"
+	args := []interface{}{start, width.Seconds()}
+	for _, l := range errorLevels {
+		args = append(args, strings.ToUpper(l))
+	}
+	query := `SELECT service, ` + db.dialect.histogramBucket() + ` AS bucket, COUNT(*),
+			SUM(CASE WHEN ` + isError + ` THEN 1 ELSE 0 END)
+		FROM logs` + where + ` GROUP BY service, bucket ORDER BY service, bucket`
+	args = append(args, whereArgs...)
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var services []models.ServiceAvailability
+	for rows.Next() {
+		var service string
+		var bucket, total, errors int64
+		if err := rows.Scan(&service, &bucket, &total, &errors); err != nil {
+			return nil, err
+		}
+		// The end bound is inclusive, so a log exactly at end lands one past the last bucket
+		bucket = min(bucket, int64(buckets)-1)
+		if bucket < 0 {
+			continue
+		}
+		if len(services) == 0 || services[len(services)-1].Service != service {
+			sa := models.ServiceAvailability{Service: service, Buckets: make([]models.AvailabilityBucket, buckets)}
+			for i := range sa.Buckets {
+				sa.Buckets[i].Start = start.Add(time.Duration(i) * width)
+			}
+			services = append(services, sa)
+		}
+		sa := &services[len(services)-1]
+		sa.Total += total
+		sa.Errors += errors
+		sa.Buckets[bucket].Total += total
+		sa.Buckets[bucket].Errors += errors
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range services {
+		sa := &services[i]
+		sa.Availability = availability(sa.Total, sa.Errors)
+		for j := range sa.Buckets {
+			sa.Buckets[j].Availability = availability(sa.Buckets[j].Total, sa.Buckets[j].Errors)
+		}
+	}
+	return services, nil
+}
+
+// availability is the share of non-error logs, or nil without logs.
+func availability(total, errors int64) *float64 {
+	if total == 0 {
+		return nil
+	}
+	a := float64(total-errors) / float64(total)
+	return &a
+}
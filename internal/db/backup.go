@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Backup copies the database to a new SQLite file at path with SQLite's
+// online backup API. The copy is a consistent snapshot: it is taken in one
+// read transaction, which in WAL mode doesn't hold up writers. Copying the
+// database file itself while the service runs can miss pages still in the
+// WAL.
+func (db *DB) Backup(ctx context.Context, path string) error {
+	if db.dialect.name() != "sqlite" {
+		return fmt.Errorf("backup is only supported for SQLite databases; back up PostgreSQL with pg_dump")
+	}
+	if db.encrypted {
+		return fmt.Errorf("encrypted databases are copied with -export-db")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	ctx, span := db.startSpan(ctx, "Backup")
+	defer span.End()
+
+	src, err := db.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := copyInto(ctx, path+"?_busy_timeout=5000", src); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// Restore replaces the database at dbPath with the backup at backupPath,
+// after checking the backup with PRAGMA quick_check. It takes dbPath's
+// writer lock, so it fails with ErrInUse while the service runs. Pages are
+// copied with the backup API, which keeps the database's WAL consistent,
+// rather than by replacing the file.
+func Restore(ctx context.Context, backupPath, dbPath string) error {
+	if _, err := os.Stat(backupPath); err != nil {
+		return err
+	}
+	lock, err := lockWriter(dbPath)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	backup, err := NewWithOptions(backupPath, Options{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("open backup: %w", err)
+	}
+	defer backup.Close()
+	if err := backup.CheckIntegrity(ctx, false); err != nil {
+		return fmt.Errorf("backup %s: %w", backupPath, err)
+	}
+	var logs int64
+	if err := backup.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM logs").Scan(&logs); err != nil {
+		return fmt.Errorf("backup %s is not a locog database: %w", backupPath, err)
+	}
+
+	src, err := backup.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return copyInto(ctx, dbPath+"?_journal_mode=WAL&_busy_timeout=5000", src)
+}
+
+// copyInto copies every page of the database src is connected to into the
+// SQLite database at dsn, in one step so the copy is consistent.
+func copyInto(ctx context.Context, dsn string, src *sql.Conn) error {
+	destDB, err := sql.Open(sqliteDriver, dsn)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+	dest, err := destDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	return dest.Raw(func(destDriver any) error {
+		return src.Raw(func(srcDriver any) error {
+			destConn, ok1 := destDriver.(*sqlite3.SQLiteConn)
+			srcConn, ok2 := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok1 || !ok2 {
+				return errors.New("backup needs SQLite connections")
+			}
+			b, err := destConn.Backup("main", srcConn, "main")
+			if err != nil {
+				return err
+			}
+			// A step of -1 copies every page; smaller steps would restart
+			// whenever another connection writes in between
+			if _, err := b.Step(-1); err != nil {
+				b.Close()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
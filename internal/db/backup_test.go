@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestBackupRestore tests taking a backup of a database in use and restoring
+// it over another one.
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	insert := func(db *DB, message string) {
+		db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: message, Host: "h"})
+	}
+	count := func(path string) int64 {
+		db, err := NewWithOptions(path, Options{ReadOnly: true})
+		if err != nil {
+			t.Fatalf("open %s: %v", path, err)
+		}
+		defer db.Close()
+		n, _ := db.CountLogs(ctx, models.LogFilter{})
+		return n
+	}
+
+	live, err := New(filepath.Join(dir, "live.db"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer live.Close()
+	insert(live, "one")
+	insert(live, "two")
+
+	backup := filepath.Join(dir, "backup.db")
+	if err := live.Backup(ctx, backup); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if err := live.Backup(ctx, backup); err == nil {
+		t.Error("expected an existing file to be refused")
+	}
+	insert(live, "after the backup")
+	if n := count(backup); n != 2 {
+		t.Errorf("expected 2 logs in the backup, got %d", n)
+	}
+
+	// Restoring over a database in use is refused
+	if err := Restore(ctx, backup, filepath.Join(dir, "live.db")); !errors.Is(err, ErrInUse) {
+		t.Errorf("expected ErrInUse, got %v", err)
+	}
+
+	other := filepath.Join(dir, "other.db")
+	db, err := New(other)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	insert(db, "replaced")
+	db.Close()
+	if err := Restore(ctx, backup, other); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	db, err = New(other)
+	if err != nil {
+		t.Fatalf("open restored database: %v", err)
+	}
+	defer db.Close()
+	logs, _ := db.QueryLogs(ctx, models.LogFilter{})
+	if len(logs) != 2 || logs[0].Message == "replaced" || logs[1].Message == "replaced" {
+		t.Errorf("expected the backup's logs, got %+v", logs)
+	}
+}
@@ -0,0 +1,1264 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/ClickHouse/clickhouse-go/v2"
+
+	"locog/internal/models"
+)
+
+//go:embed schema_clickhouse.sql
+var clickhouseSchema string
+
+// ClickHouse is a Store for installs ingesting more than a database file
+// keeps up with. Logs go in a MergeTree table partitioned by day, so
+// retention drops whole days, and concurrent inserts are buffered into
+// blocks, since ClickHouse handles a few large inserts far better than many
+// small ones.
+//
+// ClickHouse has no upserts, transactions or RETURNING, so nothing is
+// updated in place: state that changes (preferences, host groups,
+// incidents) is stored in ReplacingMergeTree tables read with FINAL,
+// counters (filter values, sources) in AggregatingMergeTree tables summed
+// when read, and deletes are lightweight DELETEs counted beforehand. IDs are
+// assigned here rather than by the server, so only one locog may write to a
+// database.
+//
+// Derived fields compile to SQLite SQL: as with a Ring, filters on them
+// match nothing and grouping by them fails.
+type ClickHouse struct {
+	derivedFields
+	policies
+	maintenance maintenanceTracker
+
+	conn    *sql.DB
+	batcher *chBatcher
+
+	mu sync.Mutex
+	// lastID is the ID of the last log written; flushes are serialized
+	// by the batcher
+	lastID       int64
+	lastIncident int64
+	lastReveal   int64
+
+	keysMu       sync.RWMutex
+	metadataKeys []metadataKeyRow
+}
+
+const (
+	// chFlushRows and chFlushInterval bound a block of logs: it is written
+	// once it holds this many logs or this long after its first.
+	chFlushRows     = 100000
+	chFlushInterval = 250 * time.Millisecond
+	// chFlushTimeout bounds writing a block.
+	chFlushTimeout = time.Minute
+	// chTagChunk is how many logs EachLog reads the tags of at a time.
+	chTagChunk = 1000
+)
+
+// chTime is a timestamp bound as Unix nanoseconds: the driver formats
+// time.Time arguments to whole seconds.
+const chTime = "fromUnixTimestamp64Nano(toInt64(?), 'UTC')"
+
+var (
+	// errStoreClosed is returned for inserts into a closed store.
+	errStoreClosed = errors.New("storage is closed")
+	// errNoClickHouseFile is returned for operations on a ClickHouse store
+	// that copy a database file.
+	errNoClickHouseFile = errors.New("clickhouse storage has no database file; use ClickHouse's BACKUP")
+)
+
+// NewClickHouse opens a ClickHouse database, e.g.
+// "clickhouse://locog@localhost:9000/locog", creating the schema if
+// needed.
+func NewClickHouse(dsn string, opts Options) (*ClickHouse, error) {
+	conn, err := sql.Open("clickhouse", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// EachLog reads tags on a second connection while the logs stream
+	if opts.MaxOpenConns > 0 {
+		conn.SetMaxOpenConns(max(opts.MaxOpenConns, 2))
+	}
+	if opts.MaxIdleConns > 0 {
+		conn.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	ctx := context.Background()
+	for _, stmt := range strings.Split(clickhouseSchema, ";") {
+		if stmt = strings.TrimSpace(stmt); stmt == "" {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+
+	c := &ClickHouse{conn: conn}
+	for _, seq := range []struct {
+		table string
+		last  *int64
+	}{{"logs", &c.lastID}, {"incidents", &c.lastIncident}, {"vault_reveals", &c.lastReveal}} {
+		if err := conn.QueryRowContext(ctx, "SELECT max(id) FROM "+seq.table).Scan(seq.last); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	c.batcher = newCHBatcher(c.writeLogs, chFlushRows, chFlushInterval)
+	return c, nil
+}
+
+// chRow is a log waiting to be written, with its metadata as JSON.
+type chRow struct {
+	log      models.Log
+	metadata string
+}
+
+// chInsert is one InsertBatch call waiting for its block to be written.
+type chInsert struct {
+	rows []chRow
+	done chan error
+}
+
+// chBatcher groups concurrent inserts into blocks, each written by one call
+// to flush once it is full or old enough. Every insert in a block gets the
+// block's outcome.
+type chBatcher struct {
+	flush    func(batches [][]chRow) error
+	maxRows  int
+	interval time.Duration
+
+	mu      sync.RWMutex // held to send on queue, and to close it
+	closed  bool
+	queue   chan *chInsert
+	stopped chan struct{}
+}
+
+func newCHBatcher(flush func([][]chRow) error, maxRows int, interval time.Duration) *chBatcher {
+	b := &chBatcher{flush: flush, maxRows: maxRows, interval: interval,
+		queue: make(chan *chInsert), stopped: make(chan struct{})}
+	go b.run()
+	return b
+}
+
+// add queues rows and waits for the block holding them to be written.
+func (b *chBatcher) add(ctx context.Context, rows []chRow) error {
+	ins := &chInsert{rows: rows, done: make(chan error, 1)}
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return errStoreClosed
+	}
+	select {
+	case b.queue <- ins:
+	case <-ctx.Done():
+		b.mu.RUnlock()
+		return ctx.Err()
+	}
+	b.mu.RUnlock()
+	// Queued rows are written whatever becomes of ctx, so wait for the
+	// outcome rather than report a failure that may not be one
+	return <-ins.done
+}
+
+func (b *chBatcher) run() {
+	defer close(b.stopped)
+	var pending []*chInsert
+	rows := 0
+	timer := time.NewTimer(b.interval)
+	timer.Stop()
+	flush := func() {
+		timer.Stop()
+		batches := make([][]chRow, len(pending))
+		for i, ins := range pending {
+			batches[i] = ins.rows
+		}
+		err := b.flush(batches)
+		for _, ins := range pending {
+			ins.done <- err
+		}
+		pending, rows = nil, 0
+	}
+	for {
+		select {
+		case ins, ok := <-b.queue:
+			if !ok {
+				if len(pending) > 0 {
+					flush()
+				}
+				return
+			}
+			if len(pending) == 0 {
+				timer.Reset(b.interval)
+			}
+			pending = append(pending, ins)
+			if rows += len(ins.rows); rows >= b.maxRows {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// close writes the pending block and stops the batcher. Later adds fail.
+func (b *chBatcher) close() {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+	<-b.stopped
+}
+
+func (c *ClickHouse) InsertLog(ctx context.Context, log *models.Log) error {
+	if _, err := json.Marshal(log.Metadata); err != nil {
+		return err
+	}
+	return c.InsertBatch(ctx, []models.Log{*log})
+}
+
+// InsertBatch stores logs in the next block written, returning once it has
+// been.
+func (c *ClickHouse) InsertBatch(ctx context.Context, logs []models.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	rows := make([]chRow, len(logs))
+	now := time.Now().UTC()
+	for i, l := range logs {
+		rows[i].log = models.Log{Timestamp: l.Timestamp.UTC(), Service: l.Service, Level: l.Level,
+			Message: l.Message, Host: l.Host, CreatedAt: now, Stream: streamName(&l)}
+		if l.Metadata != nil {
+			data, err := json.Marshal(l.Metadata)
+			if err != nil {
+				// Keep the log without metadata rather than failing the batch
+				slog.Warn("failed to marshal metadata", "service", l.Service, "error", err)
+				continue
+			}
+			rows[i].metadata = string(data)
+		}
+	}
+	return c.batcher.add(ctx, rows)
+}
+
+// writeLogs writes a block of logs, numbering them, then adds them to the
+// filter values and sources. Those are only logged if they fail, since the
+// logs are stored by then.
+func (c *ClickHouse) writeLogs(batches [][]chRow) error {
+	ctx, cancel := context.WithTimeout(context.Background(), chFlushTimeout)
+	defer cancel()
+
+	c.mu.Lock()
+	id := c.lastID
+	c.mu.Unlock()
+	var logs [][]interface{}
+	type valueKey struct{ kind, value string }
+	values := make(map[valueKey][]interface{})
+	sources := make(map[sourceKey][]interface{})
+	for _, rows := range batches {
+		for i := range rows {
+			id++
+			l := &rows[i].log
+			l.ID = id
+			logs = append(logs, []interface{}{l.ID, l.Timestamp, l.Service, l.Level, l.Message,
+				rows[i].metadata, l.Host, l.Stream, l.CreatedAt})
+
+			for _, v := range []valueKey{{"service", l.Service}, {"level", l.Level}, {"host", l.Host}} {
+				if row := values[v]; row != nil {
+					row[2] = row[2].(int64) + 1
+					row[3] = maxTime(row[3].(time.Time), l.Timestamp)
+				} else {
+					values[v] = []interface{}{v.kind, v.value, int64(1), l.Timestamp}
+				}
+			}
+			if row := sources[sourceKey{l.Host, l.Service}]; row != nil {
+				row[2] = row[2].(int64) + 1
+			} else {
+				sources[sourceKey{l.Host, l.Service}] = []interface{}{l.Host, l.Service, int64(1), l.CreatedAt, time.Unix(0, 0).UTC()}
+			}
+		}
+	}
+
+	if err := c.insert(ctx, "logs (id, timestamp, service, level, message, metadata, host, stream, created_at)", logs); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.lastID = id
+	c.mu.Unlock()
+
+	if err := c.insert(ctx, "filter_values (kind, value, count, last_seen)", slices.Collect(maps.Values(values))); err != nil {
+		slog.Warn("failed to count filter values", "error", err)
+	}
+	if err := c.insert(ctx, "sources (host, service, logs, last_log_at, last_heartbeat_at)", slices.Collect(maps.Values(sources))); err != nil {
+		slog.Warn("failed to record sources", "error", err)
+	}
+	return nil
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// insert writes rows to a table, given with its columns, in one block.
+func (c *ClickHouse) insert(ctx context.Context, table string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	tx, err := c.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO "+table)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// chList returns placeholders for n values, e.g. (?, ?, ?).
+func chList(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+}
+
+// chArgs converts values to arguments.
+func chArgs[T any](values []T) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+// chWhere renders conditions as a WHERE clause, or "" if there are none.
+func chWhere(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// chGlobRegexp returns the RE2 expression matching what the glob pattern
+// matches as GlobMatch does: * any run of characters, ? any one.
+func chGlobRegexp(pattern string) string {
+	var sb strings.Builder
+	sb.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// chPatterns matches column against globs as patternsClause does: plain
+// names by equality, globs with match().
+func chPatterns(column string, patterns []string) (string, []interface{}) {
+	var names, ors []string
+	var args []interface{}
+	for _, p := range patterns {
+		if strings.ContainsAny(p, "*?") {
+			ors = append(ors, "match("+column+", ?)")
+			args = append(args, chGlobRegexp(p))
+		} else {
+			names = append(names, p)
+		}
+	}
+	if len(names) > 0 {
+		ors = append(ors, column+" IN "+chList(len(names)))
+		args = append(args, chArgs(names)...)
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+// chMetaPath returns the JSON function arguments locating a dotted metadata
+// key, e.g. "metadata, ?, ?" for http.status.
+func chMetaPath(key string) (string, []interface{}) {
+	parts := strings.Split(key, ".")
+	return "metadata" + strings.Repeat(", ?", len(parts)), chArgs(parts)
+}
+
+// chRepeat returns args n times over, for an expression naming a path n
+// times.
+func chRepeat(n int, args []interface{}) []interface{} {
+	var all []interface{}
+	for range n {
+		all = append(all, args...)
+	}
+	return all
+}
+
+// chMetaNumeric returns the condition that a metadata value is a number, as
+// metaNumeric accepts them, and the expression giving it.
+func chMetaNumeric(key string) (guard string, guardArgs []interface{}, number string, numberArgs []interface{}) {
+	path, args := chMetaPath(key)
+	guard = "(JSONType(" + path + ") IN ('Int64', 'UInt64', 'Double') OR (JSONType(" + path +
+		") = 'String' AND toFloat64OrNull(JSONExtractString(" + path + ")) IS NOT NULL))"
+	number = "if(JSONType(" + path + ") = 'String', toFloat64OrZero(JSONExtractString(" + path +
+		")), JSONExtractFloat(" + path + "))"
+	return guard, chRepeat(3, args), number, chRepeat(3, args)
+}
+
+// chMetaText returns the expression giving a metadata value as metaText
+// does.
+func chMetaText(key string) (string, []interface{}) {
+	path, args := chMetaPath(key)
+	return fmt.Sprintf("multiIf(JSONType(%[1]s) = 'String', JSONExtractString(%[1]s), "+
+		"JSONType(%[1]s) = 'Bool', if(JSONExtractBool(%[1]s), '1', '0'), "+
+		"JSONType(%[1]s) = 'Null', '', JSONExtractRaw(%[1]s))", path), chRepeat(6, args)
+}
+
+// chMetaClause returns the condition of a metadata filter, matching as
+// metaMatches does.
+func chMetaClause(m models.MetaFilter) (string, []interface{}) {
+	if m.Derived || !metaNumericOps[m.Op] || !m.Numeric && m.Op != "=" && m.Op != "!=" {
+		return "0", nil
+	}
+	var cond string
+	var args []interface{}
+	if m.Numeric {
+		guard, guardArgs, number, numberArgs := chMetaNumeric(m.Key)
+		op := m.Op
+		if op == "!=" {
+			op = "="
+		}
+		cond = "(" + guard + " AND " + number + " " + op + " ?)"
+		args = append(append(guardArgs, numberArgs...), m.Number)
+	} else {
+		path, pathArgs := chMetaPath(m.Key)
+		cond = "(JSONType(" + path + ") = 'String' AND JSONExtractString(" + path + ") = ?)"
+		args = append(chRepeat(2, pathArgs), m.Value)
+	}
+	if m.Op == "!=" {
+		cond = "NOT " + cond
+	}
+	return cond, args
+}
+
+// where returns the conditions of filter, matching logs as buildWhere
+// does, except that search, like a Ring's, doesn't treat % and _ as
+// wildcards.
+func (c *ClickHouse) where(filter models.LogFilter) ([]string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, a ...interface{}) {
+		conds = append(conds, cond)
+		args = append(args, a...)
+	}
+	for _, f := range []struct{ column, value string }{
+		{"service", filter.Service}, {"level", filter.Level}, {"host", filter.Host}, {"stream", filter.Stream},
+	} {
+		if f.value != "" {
+			add(f.column+" = ?", f.value)
+		}
+	}
+	upper := func(levels []string) []interface{} {
+		args := make([]interface{}, len(levels))
+		for i, l := range levels {
+			args[i] = strings.ToUpper(l)
+		}
+		return args
+	}
+	if len(filter.Levels) > 0 {
+		add("upperUTF8(level) IN "+chList(len(filter.Levels)), upper(filter.Levels)...)
+	}
+	if len(filter.ExcludeLevels) > 0 {
+		add("upperUTF8(level) NOT IN "+chList(len(filter.ExcludeLevels)), upper(filter.ExcludeLevels)...)
+	}
+	for _, f := range []struct {
+		column string
+		values []string
+	}{{"service", filter.ExcludeServices}, {"host", filter.ExcludeHosts}, {"stream", filter.ExcludeStreams}} {
+		if len(f.values) > 0 {
+			add(f.column+" NOT IN "+chList(len(f.values)), chArgs(f.values)...)
+		}
+	}
+	if len(filter.HostPatterns) > 0 {
+		cond, a := chPatterns("host", filter.HostPatterns)
+		add(cond, a...)
+	}
+	if len(filter.ServicePatterns) > 0 {
+		cond, a := chPatterns("service", filter.ServicePatterns)
+		add(cond, a...)
+	}
+	for _, s := range filter.ExcludeSearch {
+		add("positionCaseInsensitiveUTF8(message, ?) = 0", s)
+	}
+	if filter.StartTime != nil {
+		add("timestamp >= "+chTime, filter.StartTime.UnixNano())
+	}
+	if filter.EndTime != nil {
+		add("timestamp <= "+chTime, filter.EndTime.UnixNano())
+	}
+	if filter.Search != "" {
+		if filter.SearchRegex {
+			add("match(message, ?)", filter.Search)
+		} else {
+			add("positionCaseInsensitiveUTF8(message, ?) > 0", filter.Search)
+		}
+	}
+	if filter.Tag != "" {
+		add("id IN (SELECT log_id FROM log_tags WHERE tag = ?)", filter.Tag)
+	}
+	if filter.SinceID > 0 {
+		add("id > ?", filter.SinceID)
+	}
+	if filter.MaxID > 0 {
+		add("id <= ?", filter.MaxID)
+	}
+	if cur := filter.Cursor; cur != nil {
+		// Continue in the order EachLog sorts by
+		ts := cur.Timestamp.UnixNano()
+		if filter.Ascending {
+			add("(timestamp > "+chTime+" OR (timestamp = "+chTime+" AND id < ?))", ts, ts, cur.ID)
+		} else {
+			add("(timestamp < "+chTime+" OR (timestamp = "+chTime+" AND id > ?))", ts, ts, cur.ID)
+		}
+	}
+	for _, m := range filter.Meta {
+		cond, a := chMetaClause(m)
+		add(cond, a...)
+	}
+	return conds, args
+}
+
+// selectCHLogs is the SELECT of logs as scanLogs reads them, up to the WHERE
+// clause.
+func selectCHLogs(omitMetadata bool) string {
+	metadata := "metadata"
+	if omitMetadata {
+		metadata = "''"
+	}
+	return "SELECT id, timestamp, service, level, message, " + metadata + ", host, created_at, stream FROM logs"
+}
+
+// scanLogs runs a query built on selectCHLogs and calls fn with each log,
+// reading tags for chTagChunk logs at a time.
+func (c *ClickHouse) scanLogs(ctx context.Context, query string, args []interface{}, fn func(models.Log) error) error {
+	rows, err := c.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var chunk []models.Log
+	deliver := func() error {
+		if err := c.readTags(ctx, chunk); err != nil {
+			return err
+		}
+		for _, l := range chunk {
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+		chunk = chunk[:0]
+		return nil
+	}
+	for rows.Next() {
+		var l models.Log
+		var metadata string
+		if err := rows.Scan(&l.ID, &l.Timestamp, &l.Service, &l.Level, &l.Message, &metadata,
+			&l.Host, &l.CreatedAt, &l.Stream); err != nil {
+			return err
+		}
+		if metadata != "" {
+			json.Unmarshal([]byte(metadata), &l.Metadata)
+		}
+		if chunk = append(chunk, l); len(chunk) == chTagChunk {
+			if err := deliver(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return deliver()
+}
+
+// readTags sets the tags of logs, sorted.
+func (c *ClickHouse) readTags(ctx context.Context, logs []models.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(logs))
+	for i, l := range logs {
+		ids[i] = l.ID
+	}
+	rows, err := c.conn.QueryContext(ctx, "SELECT log_id, arraySort(groupUniqArray(tag)) FROM log_tags WHERE log_id IN "+
+		chList(len(ids))+" GROUP BY log_id", ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	tags := make(map[int64][]string)
+	for rows.Next() {
+		var id int64
+		var t []string
+		if err := rows.Scan(&id, &t); err != nil {
+			return err
+		}
+		tags[id] = t
+	}
+	for i := range logs {
+		logs[i].Tags = tags[logs[i].ID]
+	}
+	return rows.Err()
+}
+
+func (c *ClickHouse) QueryLogs(ctx context.Context, filter models.LogFilter) ([]models.Log, error) {
+	var logs []models.Log
+	err := c.EachLog(ctx, filter, func(l models.Log) error {
+		logs = append(logs, l)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// EachLog calls fn with each log QueryLogs would return, in order, without
+// holding them all in memory. It stops at the first error fn returns.
+func (c *ClickHouse) EachLog(ctx context.Context, filter models.LogFilter, fn func(models.Log) error) error {
+	conds, args := c.where(filter)
+	query := selectCHLogs(filter.OmitMetadata) + chWhere(conds)
+	switch {
+	case filter.SinceID > 0:
+		query += " ORDER BY id ASC"
+	case filter.Ascending:
+		query += " ORDER BY timestamp ASC, id DESC"
+	default:
+		query += " ORDER BY timestamp DESC, id ASC"
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	return c.scanLogs(ctx, query+" LIMIT ?", append(args, limit), fn)
+}
+
+// GetLog returns the log with the given ID, or nil if there is none.
+func (c *ClickHouse) GetLog(ctx context.Context, id int64) (*models.Log, error) {
+	var found *models.Log
+	err := c.scanLogs(ctx, selectCHLogs(false)+" WHERE id = ? LIMIT 1", []interface{}{id}, func(l models.Log) error {
+		found = &l
+		return nil
+	})
+	return found, err
+}
+
+// LogContext returns up to before logs preceding l and up to after logs
+// following it among those matching filter, each oldest first, ordered as
+// DB.LogContext orders them.
+func (c *ClickHouse) LogContext(ctx context.Context, filter models.LogFilter, l models.Log, before, after int) ([]models.Log, []models.Log, error) {
+	conds, args := c.where(filter)
+	collect := func(logs *[]models.Log) func(models.Log) error {
+		return func(l models.Log) error {
+			*logs = append(*logs, l)
+			return nil
+		}
+	}
+	ts := l.Timestamp.UnixNano()
+
+	preceding := []models.Log{}
+	if before > 0 {
+		query := selectCHLogs(false) + chWhere(append(slices.Clip(conds), "(timestamp, id) < ("+chTime+", ?)")) +
+			" ORDER BY timestamp DESC, id DESC LIMIT ?"
+		if err := c.scanLogs(ctx, query, append(slices.Clip(args), ts, l.ID, before), collect(&preceding)); err != nil {
+			return nil, nil, err
+		}
+		slices.Reverse(preceding)
+	}
+
+	following := []models.Log{}
+	if after > 0 {
+		query := selectCHLogs(false) + chWhere(append(slices.Clip(conds), "(timestamp, id) > ("+chTime+", ?)")) +
+			" ORDER BY timestamp ASC, id ASC LIMIT ?"
+		if err := c.scanLogs(ctx, query, append(slices.Clip(args), ts, l.ID, after), collect(&following)); err != nil {
+			return nil, nil, err
+		}
+	}
+	return preceding, following, nil
+}
+
+// MaxLogID returns the highest log ID written, or 0 when there are no logs.
+func (c *ClickHouse) MaxLogID(ctx context.Context) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastID, nil
+}
+
+// CountLogs returns the number of logs matching filter, ignoring its limit.
+func (c *ClickHouse) CountLogs(ctx context.Context, filter models.LogFilter) (int64, error) {
+	conds, args := c.where(filter)
+	var n int64
+	err := c.conn.QueryRowContext(ctx, "SELECT count() FROM logs"+chWhere(conds), args...).Scan(&n)
+	return n, err
+}
+
+// CountLogsUpTo is CountLogs but stops counting at max.
+func (c *ClickHouse) CountLogsUpTo(ctx context.Context, filter models.LogFilter, max int64) (int64, error) {
+	conds, args := c.where(filter)
+	var n int64
+	err := c.conn.QueryRowContext(ctx, "SELECT count() FROM (SELECT 1 FROM logs"+chWhere(conds)+" LIMIT ?)",
+		append(args, max)...).Scan(&n)
+	return n, err
+}
+
+// EstimateLogs reports the logs ClickHouse expects to read for filter,
+// after pruning days and the primary key. EstimatedRows is that too, an
+// upper bound, since it has no statistics on other columns.
+func (c *ClickHouse) EstimateLogs(ctx context.Context, filter models.LogFilter) (models.QueryEstimate, error) {
+	est := models.QueryEstimate{Indexes: []string{}, Plan: []string{}}
+	if err := c.conn.QueryRowContext(ctx, "SELECT count() FROM logs").Scan(&est.TotalRows); err != nil {
+		return est, err
+	}
+	conds, args := c.where(filter)
+	rows, err := c.conn.QueryContext(ctx, "EXPLAIN ESTIMATE SELECT id FROM logs"+chWhere(conds), args...)
+	if err != nil {
+		return est, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var database, table string
+		var parts, n, marks int64
+		if err := rows.Scan(&database, &table, &parts, &n, &marks); err != nil {
+			return est, err
+		}
+		est.ScannedRows += n
+		est.Plan = append(est.Plan, fmt.Sprintf("READ %s: %d rows in %d parts, %d marks", table, n, parts, marks))
+	}
+	if err := rows.Err(); err != nil {
+		return est, err
+	}
+	est.EstimatedRows = est.ScannedRows
+	if est.FullScan = est.ScannedRows >= est.TotalRows; !est.FullScan {
+		est.Indexes = append(est.Indexes, "partition by day", "primary key (service, timestamp, id)")
+	}
+	return est, nil
+}
+
+// GetFilterOptions returns the services, levels and hosts of stored logs,
+// sorted, up to 100 of each as the database returns.
+func (c *ClickHouse) GetFilterOptions(ctx context.Context) (models.FilterOptions, error) {
+	var opts models.FilterOptions
+	for _, f := range []struct {
+		kind   string
+		values *[]string
+	}{{"service", &opts.Services}, {"level", &opts.Levels}, {"host", &opts.Hosts}} {
+		values, err := c.filterValues(ctx, "SELECT value FROM filter_values WHERE kind = ? GROUP BY value ORDER BY value LIMIT 100", f.kind)
+		if err != nil {
+			return opts, err
+		}
+		*f.values = values
+	}
+	return opts, nil
+}
+
+// SuggestFilterValues returns up to limit values of column starting with
+// prefix, compared case-insensitively, most frequent first.
+func (c *ClickHouse) SuggestFilterValues(ctx context.Context, column, prefix string, limit int) ([]string, error) {
+	if !allowedFilterColumns[column] {
+		return nil, fmt.Errorf("invalid column name: %s", column)
+	}
+	return c.filterValues(ctx, `SELECT value FROM filter_values WHERE kind = ? AND startsWith(lowerUTF8(value), lowerUTF8(?))
+		GROUP BY value ORDER BY sum(count) DESC, value LIMIT ?`, column, prefix, max(limit, 0))
+}
+
+func (c *ClickHouse) filterValues(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := c.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// Histogram counts logs matching filter in equal-width buckets between start
+// and end. The filter's own time range is ignored in favour of start/end.
+func (c *ClickHouse) Histogram(ctx context.Context, filter models.LogFilter, start, end time.Time, buckets int) ([]int64, error) {
+	if buckets <= 0 {
+		return nil, fmt.Errorf("buckets must be positive, got %d", buckets)
+	}
+	counts := make([]int64, buckets)
+	if !end.After(start) {
+		return counts, nil
+	}
+	filter.StartTime, filter.EndTime = &start, &end
+	conds, args := c.where(filter)
+	width := max(int64(end.Sub(start))/int64(buckets), 1)
+	// The end bound is inclusive, so a log exactly at end lands one past the last bucket
+	rows, err := c.conn.QueryContext(ctx, `SELECT least(intDiv(toUnixTimestamp64Nano(timestamp) - toInt64(?), toInt64(?)), ?) AS bucket, count()
+		FROM logs`+chWhere(conds)+` GROUP BY bucket`, append([]interface{}{start.UnixNano(), width, buckets - 1}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bucket, n int64
+		if err := rows.Scan(&bucket, &n); err != nil {
+			return nil, err
+		}
+		counts[bucket] += n
+	}
+	return counts, rows.Err()
+}
+
+// groupExpr returns the expression giving the group of a log for groupBy,
+// as ringGroup does.
+func (c *ClickHouse) groupExpr(groupBy string) (string, []interface{}, error) {
+	if groupBy == "" {
+		return "''", nil, nil
+	}
+	if name, ok := strings.CutPrefix(groupBy, "derived."); ok {
+		if _, ok := c.derivedSQL(name); ok {
+			return "", nil, fmt.Errorf("derived field %s needs SQL storage", name)
+		}
+		return "", nil, fmt.Errorf("unknown derived field: %s", name)
+	}
+	if key, ok := strings.CutPrefix(groupBy, "metadata."); ok {
+		expr, args := chMetaText(key)
+		return expr, args, nil
+	}
+	switch groupBy {
+	case "service", "level", "host":
+		return groupBy, nil, nil
+	}
+	return "", nil, fmt.Errorf("invalid group by column: %s", groupBy)
+}
+
+// bucketCHExpr returns the expression giving the Unix time of the start of a
+// log's bucket, as ringBucket does.
+func bucketCHExpr(bucket time.Duration) (string, []interface{}, error) {
+	if bucket <= 0 {
+		return "0", nil, nil
+	}
+	secs := int64(bucket.Seconds())
+	if secs < 1 {
+		return "", nil, fmt.Errorf("bucket must be at least 1s, got %s", bucket)
+	}
+	return "intDiv(toInt64(toUnixTimestamp(timestamp)), ?) * ?", []interface{}{secs, secs}, nil
+}
+
+// AggregateMetadata computes count/avg/min/max and percentiles of a numeric
+// metadata field over logs matching filter, grouped by groupBy and
+// optionally by fixed-width time buckets. Non-numeric values are ignored.
+// Percentiles are nearest-rank, as summarize computes them.
+func (c *ClickHouse) AggregateMetadata(ctx context.Context, filter models.LogFilter, field, groupBy string, bucket time.Duration) ([]models.MetricAggregate, error) {
+	group, groupArgs, err := c.groupExpr(groupBy)
+	if err != nil {
+		return nil, err
+	}
+	bucketExpr, bucketArgs, err := bucketCHExpr(bucket)
+	if err != nil {
+		return nil, err
+	}
+	guard, guardArgs, number, numberArgs := chMetaNumeric(field)
+	conds, args := c.where(filter)
+	conds = append(conds, guard)
+	args = append(args, guardArgs...)
+
+	rank := func(p string) string { return "vs[toUInt64(greatest(1, ceil(" + p + " * length(vs))))]" }
+	query := `SELECT g, b, length(vs), arrayAvg(vs), vs[1], vs[-1], ` + rank("0.5") + `, ` + rank("0.95") + `, ` + rank("0.99") + `
+		FROM (SELECT ` + group + ` AS g, ` + bucketExpr + ` AS b, arraySort(groupArray(` + number + `)) AS vs
+			FROM logs` + chWhere(conds) + ` GROUP BY g, b)
+		ORDER BY g, b`
+	all := slices.Concat(groupArgs, bucketArgs, numberArgs, args)
+	rows, err := c.conn.QueryContext(ctx, query, all...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []models.MetricAggregate
+	for rows.Next() {
+		var agg models.MetricAggregate
+		var b int64
+		var n uint64
+		if err := rows.Scan(&agg.Group, &b, &n, &agg.Avg, &agg.Min, &agg.Max, &agg.P50, &agg.P95, &agg.P99); err != nil {
+			return nil, err
+		}
+		agg.Count = int64(n)
+		if bucket > 0 {
+			t := time.Unix(b, 0).UTC()
+			agg.Bucket = &t
+		}
+		results = append(results, agg)
+	}
+	return results, rows.Err()
+}
+
+// CountLogsByGroup counts the logs matching filter per group, most frequent
+// first, keeping the limit largest groups, optionally split by time bucket
+// as DB.CountLogsByGroup does.
+func (c *ClickHouse) CountLogsByGroup(ctx context.Context, filter models.LogFilter, groupBy string, bucket time.Duration, limit int) ([]models.GroupCount, error) {
+	group, groupArgs, err := c.groupExpr(groupBy)
+	if err != nil {
+		return nil, err
+	}
+	bucketExpr, bucketArgs, err := bucketCHExpr(bucket)
+	if err != nil {
+		return nil, err
+	}
+	conds, args := c.where(filter)
+
+	rows, err := c.conn.QueryContext(ctx, "SELECT "+group+" AS g, count() AS n FROM logs"+chWhere(conds)+
+		" GROUP BY g ORDER BY n DESC, g LIMIT ?", slices.Concat(groupArgs, args, []interface{}{max(limit, 0)})...)
+	if err != nil {
+		return nil, err
+	}
+	var totals []models.GroupCount
+	for rows.Next() {
+		var gc models.GroupCount
+		if err := rows.Scan(&gc.Group, &gc.Count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		totals = append(totals, gc)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || bucket <= 0 || len(totals) == 0 {
+		return totals, err
+	}
+
+	rank := make(map[string]int, len(totals))
+	groups := make([]interface{}, len(totals))
+	for i, gc := range totals {
+		rank[gc.Group] = i
+		groups[i] = gc.Group
+	}
+	rows, err = c.conn.QueryContext(ctx, "SELECT "+group+" AS g, "+bucketExpr+" AS b, count() FROM logs"+
+		chWhere(append(conds, "g IN "+chList(len(groups))))+" GROUP BY g, b",
+		slices.Concat(groupArgs, bucketArgs, args, groups)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []models.GroupCount
+	for rows.Next() {
+		var gc models.GroupCount
+		var b int64
+		if err := rows.Scan(&gc.Group, &b, &gc.Count); err != nil {
+			return nil, err
+		}
+		t := time.Unix(b, 0).UTC()
+		gc.Bucket = &t
+		results = append(results, gc)
+	}
+	slices.SortFunc(results, func(a, b models.GroupCount) int {
+		return cmp.Or(cmp.Compare(rank[a.Group], rank[b.Group]), a.Bucket.Compare(*b.Bucket))
+	})
+	return results, rows.Err()
+}
+
+// Availability counts the logs and error logs of each service matching
+// filter in buckets of width from start, as DB.Availability does.
+func (c *ClickHouse) Availability(ctx context.Context, filter models.LogFilter, errorLevels []string, start time.Time, width time.Duration, buckets int) ([]models.ServiceAvailability, error) {
+	end := start.Add(time.Duration(buckets) * width)
+	filter.StartTime, filter.EndTime = &start, &end
+	conds, args := c.where(filter)
+
+	isError, errorArgs := "0", []interface{}{}
+	if len(errorLevels) > 0 {
+		isError = "upperUTF8(level) IN " + chList(len(errorLevels))
+		for _, l := range errorLevels {
+			errorArgs = append(errorArgs, strings.ToUpper(l))
+		}
+	}
+	// The end bound is inclusive, so a log exactly at end lands one past the last bucket
+	rows, err := c.conn.QueryContext(ctx, `SELECT service, least(intDiv(toUnixTimestamp64Nano(timestamp) - toInt64(?), toInt64(?)), ?) AS b,
+			count(), countIf(`+isError+`)
+		FROM logs`+chWhere(conds)+` GROUP BY service, b`,
+		slices.Concat([]interface{}{start.UnixNano(), int64(width), buckets - 1}, errorArgs, args)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byService := make(map[string]*models.ServiceAvailability)
+	for rows.Next() {
+		var service string
+		var b, total, errs int64
+		if err := rows.Scan(&service, &b, &total, &errs); err != nil {
+			return nil, err
+		}
+		sa := byService[service]
+		if sa == nil {
+			sa = &models.ServiceAvailability{Service: service, Buckets: make([]models.AvailabilityBucket, buckets)}
+			for i := range sa.Buckets {
+				sa.Buckets[i].Start = start.Add(time.Duration(i) * width)
+			}
+			byService[service] = sa
+		}
+		sa.Total += total
+		sa.Errors += errs
+		sa.Buckets[b].Total += total
+		sa.Buckets[b].Errors += errs
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var services []models.ServiceAvailability
+	for _, name := range slices.Sorted(maps.Keys(byService)) {
+		sa := byService[name]
+		sa.Availability = availability(sa.Total, sa.Errors)
+		for j := range sa.Buckets {
+			sa.Buckets[j].Availability = availability(sa.Buckets[j].Total, sa.Buckets[j].Errors)
+		}
+		services = append(services, *sa)
+	}
+	return services, nil
+}
+
+// messageCounts counts the logs matching filter by message and version (see
+// VersionKeys) if versions is set, keeping the max most frequent.
+func (c *ClickHouse) messageCounts(ctx context.Context, filter models.LogFilter, versions bool, max int) ([]messageCount, error) {
+	version, versionArgs := "''", []interface{}{}
+	if versions {
+		// The first of the keys a log has, as ringLog lookups find it
+		var branches []string
+		for _, key := range VersionKeys {
+			path, pathArgs := chMetaPath(key)
+			text, textArgs := chMetaText(key)
+			branches = append(branches, "JSONType("+path+") != 'Null'", text)
+			versionArgs = slices.Concat(versionArgs, pathArgs, textArgs)
+		}
+		version = "multiIf(" + strings.Join(branches, ", ") + ", '')"
+	}
+	conds, args := c.where(filter)
+	rows, err := c.conn.QueryContext(ctx, "SELECT message, "+version+" AS v, count() AS n FROM logs"+chWhere(conds)+
+		" GROUP BY message, v ORDER BY n DESC, message, v LIMIT ?", slices.Concat(versionArgs, args, []interface{}{max})...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var counts []messageCount
+	for rows.Next() {
+		var mc messageCount
+		if err := rows.Scan(&mc.message, &mc.version, &mc.count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, mc)
+	}
+	return counts, rows.Err()
+}
+
+// MessageTemplates counts the messages matching filter by template, most
+// frequent first, considering only the maxMessages most frequent messages.
+func (c *ClickHouse) MessageTemplates(ctx context.Context, filter models.LogFilter, maxMessages int) ([]models.TemplateCount, error) {
+	counts, err := c.messageCounts(ctx, filter, false, maxMessages)
+	if err != nil {
+		return nil, err
+	}
+	return countTemplates(counts), nil
+}
+
+// TopErrors groups the logs matching filter by message template and version
+// as DB.TopErrors does.
+func (c *ClickHouse) TopErrors(ctx context.Context, filter models.LogFilter, maxMessages int) ([]models.ErrorGroup, error) {
+	counts, err := c.messageCounts(ctx, filter, true, maxMessages)
+	if err != nil {
+		return nil, err
+	}
+	return groupErrors(counts), nil
+}
+
+// RefreshRollups does nothing: LogStats counts the logs themselves, which
+// ClickHouse does quickly enough.
+func (c *ClickHouse) RefreshRollups(ctx context.Context, now time.Time) error {
+	return nil
+}
+
+// LogStats counts the logs matching filter per bucket of size, grouped by
+// groupBy, taking the same arguments as DB.LogStats. The counts are exact
+// and reach filter.EndTime.
+func (c *ClickHouse) LogStats(ctx context.Context, filter models.LogFilter, size time.Duration, groupBy string) (models.LogStats, error) {
+	cfg := c.Rollups()
+	stats := models.LogStats{BucketSeconds: size.Seconds(), GroupBy: groupBy, Buckets: []models.StatsBucket{}}
+	if !slices.Contains(cfg.Sizes, size) {
+		return stats, fmt.Errorf("no rollups of %s", size)
+	}
+	if groupBy != "" && !slices.Contains(rollupGroups, groupBy) {
+		return stats, fmt.Errorf("can't group by %q; use %s", groupBy, strings.Join(rollupGroups, ", "))
+	}
+	if !RollupFilter(filter) || filter.StartTime == nil || filter.EndTime == nil {
+		return stats, fmt.Errorf("rollups need a time range and only filter by service, level, host and stream")
+	}
+
+	start, end := filter.StartTime.UTC().Truncate(size), filter.EndTime.UTC()
+	stats.RolledUntil = &end
+	if !start.Before(end) {
+		return stats, nil
+	}
+	n := int((end.Sub(start) + size - 1) / size)
+	stats.Buckets = make([]models.StatsBucket, n)
+	for i := range stats.Buckets {
+		stats.Buckets[i].Start = start.Add(time.Duration(i) * size)
+	}
+
+	group := "''"
+	if groupBy != "" {
+		group = groupBy // one of rollupGroups
+	}
+	filter.StartTime = &start
+	conds, args := c.where(filter)
+	rows, err := c.conn.QueryContext(ctx, "SELECT intDiv(toUnixTimestamp64Nano(timestamp) - toInt64(?), toInt64(?)) AS i, "+group+
+		" AS g, count() FROM logs"+chWhere(conds)+" GROUP BY i, g", append([]interface{}{start.UnixNano(), int64(size)}, args...)...)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i, count int64
+		var g string
+		if err := rows.Scan(&i, &g, &count); err != nil {
+			return stats, err
+		}
+		if i >= int64(n) {
+			continue
+		}
+		b := &stats.Buckets[i]
+		b.Count += count
+		if groupBy != "" {
+			if b.Groups == nil {
+				b.Groups = make(map[string]int64)
+			}
+			b.Groups[g] += count
+		}
+	}
+	return stats, rows.Err()
+}
+
+// RefreshMetadataKeys rebuilds the metadata keys report from logs newer than
+// since, as Ring.RefreshMetadataKeys does, reading their metadata here.
+func (c *ClickHouse) RefreshMetadataKeys(ctx context.Context, since time.Time) error {
+	type key struct{ service, key, kind string }
+	keys := make(map[key]*metadataKeyRow)
+	totals := make(map[string]int64)
+	now := time.Now()
+
+	rows, err := c.conn.QueryContext(ctx, "SELECT service, count() FROM logs WHERE timestamp >= "+chTime+" GROUP BY service", since.UnixNano())
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var service string
+		var n int64
+		if err := rows.Scan(&service, &n); err != nil {
+			rows.Close()
+			return err
+		}
+		totals[service] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = c.conn.QueryContext(ctx, "SELECT service, timestamp, metadata FROM logs WHERE timestamp >= "+chTime+" AND metadata != ''", since.UnixNano())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var service, metadata string
+		var ts time.Time
+		if err := rows.Scan(&service, &ts, &metadata); err != nil {
+			return err
+		}
+		var meta map[string]interface{}
+		if json.Unmarshal([]byte(metadata), &meta) != nil {
+			continue
+		}
+		walkMeta("", meta, func(path string, v interface{}) {
+			kind, example := metaKind(v)
+			k := key{service, path, kind}
+			row := keys[k]
+			if row == nil {
+				row = &metadataKeyRow{service: service, key: path, kind: kind, generatedAt: now}
+				keys[k] = row
+			}
+			row.count++
+			if ts.After(row.lastSeen) {
+				row.lastSeen = ts
+			}
+			if example == nil {
+				return
+			}
+			if !row.exampleMin.Valid || *example < row.exampleMin.String {
+				row.exampleMin.String, row.exampleMin.Valid = *example, true
+			}
+			if !row.exampleMax.Valid || *example > row.exampleMax.String {
+				row.exampleMax.String, row.exampleMax.Valid = *example, true
+			}
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	report := make([]metadataKeyRow, 0, len(keys))
+	for _, row := range keys {
+		row.serviceTotal = totals[row.service]
+		report = append(report, *row)
+	}
+	slices.SortFunc(report, func(a, b metadataKeyRow) int {
+		return cmp.Or(cmp.Compare(a.service, b.service), cmp.Compare(a.key, b.key), cmp.Compare(a.kind, b.kind))
+	})
+	c.keysMu.Lock()
+	c.metadataKeys = report
+	c.keysMu.Unlock()
+	return nil
+}
+
+// MetadataKeys returns the latest metadata keys report, optionally limited to
+// one service. GeneratedAt is nil until the report has been built.
+func (c *ClickHouse) MetadataKeys(ctx context.Context, service string) (models.MetadataKeysReport, error) {
+	c.keysMu.RLock()
+	defer c.keysMu.RUnlock()
+	rows := c.metadataKeys
+	if service != "" {
+		rows = slices.DeleteFunc(slices.Clone(rows), func(row metadataKeyRow) bool { return row.service != service })
+	}
+	return metadataKeysReport(rows), nil
+}
@@ -0,0 +1,837 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+)
+
+// pin returns filter limited to the logs written so far, so a count and the
+// change it precedes see the same logs.
+func (c *ClickHouse) pin(filter models.LogFilter) models.LogFilter {
+	if filter.MaxID == 0 {
+		c.mu.Lock()
+		filter.MaxID = max(c.lastID, 1)
+		c.mu.Unlock()
+	}
+	return filter
+}
+
+// count runs a SELECT count() query.
+func (c *ClickHouse) count(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	var n int64
+	err := c.conn.QueryRowContext(ctx, query, args...).Scan(&n)
+	return n, err
+}
+
+// TagLogs adds tag to every log matching filter (its limit is ignored) and
+// returns the number of logs newly tagged.
+func (c *ClickHouse) TagLogs(ctx context.Context, filter models.LogFilter, tag string) (int64, error) {
+	conds, args := c.where(c.pin(filter))
+	conds = append(conds, "id NOT IN (SELECT log_id FROM log_tags WHERE tag = ?)")
+	args = append(args, tag)
+	n, err := c.count(ctx, "SELECT count() FROM logs"+chWhere(conds), args...)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	_, err = c.conn.ExecContext(ctx, "INSERT INTO log_tags (log_id, tag, log_timestamp, tagged_at) SELECT id, ?, timestamp, now64(9) FROM logs"+
+		chWhere(conds), append([]interface{}{tag}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// UntagLogs removes tag from every log matching filter and returns the number
+// of logs it was removed from.
+func (c *ClickHouse) UntagLogs(ctx context.Context, filter models.LogFilter, tag string) (int64, error) {
+	conds, args := c.where(c.pin(filter))
+	where := "tag = ? AND log_id IN (SELECT id FROM logs" + chWhere(conds) + ")"
+	args = append([]interface{}{tag}, args...)
+	n, err := c.count(ctx, "SELECT count() FROM log_tags FINAL WHERE "+where, args...)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	if _, err := c.conn.ExecContext(ctx, "DELETE FROM log_tags WHERE "+where, args...); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// PendingTagExports returns up to limit logs carrying a tag matching pattern
+// that have not yet been exported for that tag, oldest first.
+func (c *ClickHouse) PendingTagExports(ctx context.Context, pattern string, limit int) ([]TagExport, error) {
+	rows, err := c.conn.QueryContext(ctx, `SELECT log_id, tag FROM log_tags FINAL
+		WHERE match(tag, ?) AND (log_id, tag) NOT IN (SELECT log_id, tag FROM tag_exports)
+		ORDER BY log_timestamp, log_id, tag LIMIT ?`, chGlobRegexp(pattern), max(limit, 0))
+	if err != nil {
+		return nil, err
+	}
+	var pending []TagExport
+	var ids []interface{}
+	for rows.Next() {
+		var e TagExport
+		if err := rows.Scan(&e.Log.ID, &e.Tag); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pending = append(pending, e)
+		ids = append(ids, e.Log.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	logs := make(map[int64]models.Log, len(ids))
+	err = c.scanLogs(ctx, selectCHLogs(false)+" WHERE id IN "+chList(len(ids)), ids, func(l models.Log) error {
+		logs[l.ID] = l
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	exports := make([]TagExport, 0, len(pending))
+	for _, e := range pending {
+		// Skip logs deleted since they were tagged
+		if l, ok := logs[e.Log.ID]; ok {
+			exports = append(exports, TagExport{Tag: e.Tag, Log: l})
+		}
+	}
+	return exports, nil
+}
+
+// MarkTagsExported records that the given logs were exported for their tags.
+func (c *ClickHouse) MarkTagsExported(ctx context.Context, exports []TagExport) error {
+	now := time.Now().UTC()
+	rows := make([][]interface{}, len(exports))
+	for i, e := range exports {
+		rows[i] = []interface{}{e.Log.ID, e.Tag, e.Log.Timestamp.UTC(), now}
+	}
+	return c.insert(ctx, "tag_exports (log_id, tag, log_timestamp, exported_at)", rows)
+}
+
+// TagExportCounts returns the export counts of every tag matching pattern
+// that is on a log or has been exported. Export records are pruned with
+// their logs, so Exported only counts exports of logs still stored.
+func (c *ClickHouse) TagExportCounts(ctx context.Context, pattern string) (map[string]TagExportCount, error) {
+	re := chGlobRegexp(pattern)
+	rows, err := c.conn.QueryContext(ctx, `SELECT tag, sum(exported), sum(pending) FROM (
+			SELECT tag, count() AS exported, 0 AS pending FROM tag_exports FINAL WHERE match(tag, ?) GROUP BY tag
+			UNION ALL
+			SELECT tag, 0, count() FROM log_tags FINAL
+			WHERE match(tag, ?) AND (log_id, tag) NOT IN (SELECT log_id, tag FROM tag_exports) GROUP BY tag)
+		GROUP BY tag`, re, re)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[string]TagExportCount)
+	for rows.Next() {
+		var tag string
+		var n TagExportCount
+		if err := rows.Scan(&tag, &n.Exported, &n.Pending); err != nil {
+			return nil, err
+		}
+		counts[tag] = n
+	}
+	return counts, rows.Err()
+}
+
+// chRetention is a retention cleanup in ClickHouse SQL, from the cutoffs of
+// a ringRetention.
+type chRetention struct {
+	ringRetention
+	// newest is the latest cutoff: no log from then on is deleted
+	newest time.Time
+}
+
+func newCHRetention(rule ringRetention) chRetention {
+	r := chRetention{ringRetention: rule, newest: rule.cutoff}
+	for _, cutoff := range rule.streams {
+		r.newest = maxTime(r.newest, cutoff)
+	}
+	return r
+}
+
+// expired is the condition that a log is older than its stream's retention.
+func (r chRetention) expired() (string, []interface{}) {
+	cutoff, args := chTime, []interface{}{r.cutoff.UnixNano()}
+	if len(r.streams) > 0 {
+		var branches []string
+		var branchArgs []interface{}
+		for _, name := range slices.Sorted(maps.Keys(r.streams)) {
+			branches = append(branches, "stream = ?", chTime)
+			branchArgs = append(branchArgs, name, r.streams[name].UnixNano())
+		}
+		cutoff = "multiIf(" + strings.Join(branches, ", ") + ", " + chTime + ")"
+		args = append(branchArgs, args...)
+	}
+	// The bare bound lets ClickHouse skip the days no cutoff reaches
+	return "timestamp < " + chTime + " AND timestamp < " + cutoff, append([]interface{}{r.newest.UnixNano()}, args...)
+}
+
+// keptByTags is the condition that a tag policy or archive hold keeps a log.
+func (r chRetention) keptByTags() (string, []interface{}) {
+	var ors []string
+	var args []interface{}
+	if len(r.holds) > 0 {
+		ors = append(ors, "tag IN "+chList(len(r.holds)))
+		args = append(args, chArgs(r.holds)...)
+	}
+	for _, p := range r.tags {
+		ors = append(ors, "(match(tag, ?) AND log_timestamp >= "+chTime+")")
+		args = append(args, chGlobRegexp(p.pattern), p.cutoff.UnixNano())
+	}
+	if len(ors) == 0 {
+		return "0", nil
+	}
+	return "id IN (SELECT log_id FROM log_tags WHERE " + strings.Join(ors, " OR ") + ")", args
+}
+
+// keptByIncidents is the condition that an incident keeps a log.
+func (r chRetention) keptByIncidents() (string, []interface{}) {
+	var ors []string
+	var args []interface{}
+	for _, inc := range r.incidents {
+		ors = append(ors, "(service IN "+chList(len(inc.Services))+" AND timestamp >= "+chTime+" AND timestamp < "+chTime+")")
+		args = slices.Concat(args, chArgs(inc.Services), []interface{}{inc.StartedAt.UnixNano(), inc.EndsAt.UnixNano()})
+	}
+	if len(ors) == 0 {
+		return "0", nil
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+// deletes is the condition that the cleanup deletes a log.
+func (r chRetention) deletes() (string, []interface{}) {
+	expired, args := r.expired()
+	tags, tagArgs := r.keptByTags()
+	incidents, incidentArgs := r.keptByIncidents()
+	return expired + " AND NOT " + tags + " AND NOT " + incidents, slices.Concat(args, tagArgs, incidentArgs)
+}
+
+// DeleteOldLogs deletes logs older than olderThan, or their stream's
+// retention, except those kept longer by a tag policy or an incident, as
+// DB.DeleteOldLogs does. Days older than anything is kept are dropped whole;
+// the rest are deleted with a lightweight DELETE.
+func (c *ClickHouse) DeleteOldLogs(ctx context.Context, olderThan time.Duration) (int64, error) {
+	now := time.Now()
+	incidents, err := c.Incidents(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	rule := newCHRetention(newRingRetention(now, olderThan, c.StreamRetention(), c.TagPolicies(), c.ArchiveHolds(), incidents))
+
+	deleted, err := c.dropOldDays(ctx, rule)
+	if err != nil {
+		return deleted, err
+	}
+	where, args := rule.deletes()
+	n, err := c.count(ctx, "SELECT count() FROM logs WHERE "+where, args...)
+	if err == nil && n > 0 {
+		_, err = c.conn.ExecContext(ctx, "DELETE FROM logs WHERE "+where, args...)
+	}
+	if err != nil {
+		c.maintenance.deleted(deleted)
+		return deleted, err
+	}
+	deleted += n
+	c.maintenance.deleted(deleted)
+
+	oldest, newest := rule.oldest.UnixNano(), rule.newest.UnixNano()
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		// Tags and export records of deleted logs
+		{"DELETE FROM log_tags WHERE log_timestamp < " + chTime + " AND log_id NOT IN (SELECT id FROM logs WHERE timestamp < " + chTime + ")",
+			[]interface{}{newest, newest}},
+		{"DELETE FROM tag_exports WHERE log_timestamp < " + chTime + " AND log_id NOT IN (SELECT id FROM logs WHERE timestamp < " + chTime + ")",
+			[]interface{}{newest, newest}},
+		// Vault entries outlive their logs until past the oldest retained
+		// log, since entries don't record which log references them
+		{"DELETE FROM redaction_vault WHERE created_at < " + chTime, []interface{}{oldest}},
+		// Filter values and sources no log is kept for
+		{"DELETE FROM filter_values WHERE (kind, value) IN (SELECT kind, value FROM filter_values GROUP BY kind, value HAVING max(last_seen) < " + chTime + ")",
+			[]interface{}{oldest}},
+		{"DELETE FROM sources WHERE (host, service) IN (SELECT host, service FROM sources GROUP BY host, service HAVING greatest(max(last_log_at), max(last_heartbeat_at)) < " + chTime + ")",
+			[]interface{}{oldest}},
+		{"DELETE FROM incidents WHERE keep_until <= " + chTime, []interface{}{now.UnixNano()}},
+	} {
+		if _, err := c.conn.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// dropOldDays drops the partitions, one per day, holding only logs the
+// cleanup deletes: days before any log is kept from, without logs under an
+// archive hold. It returns how many logs they held.
+func (c *ClickHouse) dropOldDays(ctx context.Context, rule chRetention) (int64, error) {
+	y, m, d := rule.oldest.UTC().Date()
+	before := int64(y*10000 + int(m)*100 + d)
+
+	held := make(map[int64]bool)
+	if len(rule.holds) > 0 {
+		rows, err := c.conn.QueryContext(ctx, "SELECT DISTINCT toInt64(toYYYYMMDD(log_timestamp)) FROM log_tags WHERE tag IN "+
+			chList(len(rule.holds)), chArgs(rule.holds)...)
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+			var day int64
+			if err := rows.Scan(&day); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			held[day] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	rows, err := c.conn.QueryContext(ctx, "SELECT DISTINCT partition FROM system.parts WHERE database = currentDatabase() AND table = 'logs' AND active")
+	if err != nil {
+		return 0, err
+	}
+	var days []int64
+	for rows.Next() {
+		var partition string
+		if err := rows.Scan(&partition); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if day, err := strconv.ParseInt(partition, 10, 64); err == nil && day < before && !held[day] {
+			days = append(days, day)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for _, day := range days {
+		n, err := c.count(ctx, "SELECT count() FROM logs WHERE toYYYYMMDD(timestamp) = ?", day)
+		if err == nil {
+			_, err = c.conn.ExecContext(ctx, "ALTER TABLE logs DROP PARTITION ?", day)
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+		slog.Info("dropped expired day of logs", "day", day, "logs", n)
+	}
+	return deleted, nil
+}
+
+// RetentionPreview reports what DeleteOldLogs(olderThan) would delete if the
+// given tag policies were in effect, without deleting anything.
+func (c *ClickHouse) RetentionPreview(ctx context.Context, olderThan time.Duration, policies []TagPolicy) (models.RetentionPreview, error) {
+	now := time.Now()
+	preview := models.RetentionPreview{
+		Cutoff: now.Add(-olderThan).UTC(),
+		Groups: []models.RetentionGroup{},
+	}
+	incidents, err := c.Incidents(ctx, now)
+	if err != nil {
+		return preview, err
+	}
+	rule := newCHRetention(newRingRetention(now, olderThan, c.StreamRetention(), policies, c.ArchiveHolds(), incidents))
+	expired, expiredArgs := rule.expired()
+	tags, tagArgs := rule.keptByTags()
+	incident, incidentArgs := rule.keptByIncidents()
+
+	rows, err := c.conn.QueryContext(ctx, `SELECT stream, service, level, countIf(NOT kt AND NOT ki), countIf(kt), countIf(ki)
+		FROM (SELECT stream, service, level, `+tags+` AS kt, `+incident+` AS ki FROM logs WHERE `+expired+`)
+		GROUP BY stream, service, level`, slices.Concat(tagArgs, incidentArgs, expiredArgs)...)
+	if err != nil {
+		return preview, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var g models.RetentionGroup
+		var keptByTags, keptByIncidents int64
+		if err := rows.Scan(&g.Stream, &g.Service, &g.Level, &g.Count, &keptByTags, &keptByIncidents); err != nil {
+			return preview, err
+		}
+		preview.KeptByTags += keptByTags
+		preview.KeptByIncidents += keptByIncidents
+		if g.Count > 0 {
+			preview.Groups = append(preview.Groups, g)
+			preview.Total += g.Count
+		}
+	}
+	slices.SortFunc(preview.Groups, func(a, b models.RetentionGroup) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Stream, b.Stream),
+			cmp.Compare(a.Service, b.Service), cmp.Compare(a.Level, b.Level))
+	})
+	return preview, rows.Err()
+}
+
+// DeleteOldRows deletes the rows of an auxiliary table older than olderThan
+// and returns how many were deleted.
+func (c *ClickHouse) DeleteOldRows(ctx context.Context, table string, olderThan time.Duration) (int64, error) {
+	n, err := c.CountOldRows(ctx, table, olderThan)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	// A table is only named once CountOldRows has checked it
+	_, err = c.conn.ExecContext(ctx, "DELETE FROM "+table+" WHERE "+retainedTables[table]+" < "+chTime, time.Now().Add(-olderThan).UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// CountOldRows counts the rows of an auxiliary table DeleteOldRows would
+// delete.
+func (c *ClickHouse) CountOldRows(ctx context.Context, table string, olderThan time.Duration) (int64, error) {
+	column, ok := retainedTables[table]
+	if !ok {
+		return 0, fmt.Errorf("table %q has no retention of its own; use one of %s", table, strings.Join(RetainedTables(), ", "))
+	}
+	return c.count(ctx, "SELECT count() FROM "+table+" WHERE "+column+" < "+chTime, time.Now().Add(-olderThan).UnixNano())
+}
+
+// storeIncident writes a version of an incident, replacing earlier ones.
+func (c *ClickHouse) storeIncident(ctx context.Context, inc models.Incident) error {
+	return c.insert(ctx, "incidents (id, name, services, started_at, ends_at, keep_until, version)", [][]interface{}{{
+		inc.ID, inc.Name, inc.Services, inc.StartedAt.UTC(), inc.EndsAt.UTC(), inc.KeepUntil.UTC(), time.Now().UnixNano(),
+	}})
+}
+
+// StartIncident stores an incident and sets its ID.
+func (c *ClickHouse) StartIncident(ctx context.Context, inc *models.Incident) error {
+	c.mu.Lock()
+	c.lastIncident++
+	id := c.lastIncident
+	c.mu.Unlock()
+	stored := *inc
+	stored.ID = id
+	if stored.Services == nil {
+		stored.Services = []string{}
+	}
+	if err := c.storeIncident(ctx, stored); err != nil {
+		return err
+	}
+	inc.ID = id
+	return nil
+}
+
+// scanIncidents runs a query of incidents.
+func (c *ClickHouse) scanIncidents(ctx context.Context, query string, args ...interface{}) ([]models.Incident, error) {
+	rows, err := c.conn.QueryContext(ctx, "SELECT id, name, services, started_at, ends_at, keep_until FROM incidents FINAL"+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	incidents := []models.Incident{}
+	for rows.Next() {
+		var inc models.Incident
+		if err := rows.Scan(&inc.ID, &inc.Name, &inc.Services, &inc.StartedAt, &inc.EndsAt, &inc.KeepUntil); err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, inc)
+	}
+	return incidents, rows.Err()
+}
+
+// EndIncident ends an incident at now if it is still active, moving its
+// KeepUntil forward by as much as its end, and returns it. ok is false when
+// there is no incident with the ID.
+func (c *ClickHouse) EndIncident(ctx context.Context, id int64, now time.Time) (models.Incident, bool, error) {
+	found, err := c.scanIncidents(ctx, " WHERE id = ?", id)
+	if err != nil || len(found) == 0 {
+		return models.Incident{}, false, err
+	}
+	inc := found[0]
+	if inc.EndsAt.After(now) {
+		inc.KeepUntil = now.Add(inc.KeepUntil.Sub(inc.EndsAt)).UTC()
+		inc.EndsAt = now.UTC()
+		if err := c.storeIncident(ctx, inc); err != nil {
+			return models.Incident{}, false, err
+		}
+	}
+	return inc, true, nil
+}
+
+// Incidents returns the incidents still keeping logs at now, newest first.
+func (c *ClickHouse) Incidents(ctx context.Context, now time.Time) ([]models.Incident, error) {
+	return c.scanIncidents(ctx, " WHERE keep_until > "+chTime+" ORDER BY started_at DESC, id DESC", now.UnixNano())
+}
+
+// RecordHeartbeat notes a heartbeat from a shipper; service may be empty.
+func (c *ClickHouse) RecordHeartbeat(ctx context.Context, host, service string, at time.Time) error {
+	return c.insert(ctx, "sources (host, service, logs, last_log_at, last_heartbeat_at)", [][]interface{}{{
+		host, service, int64(0), time.Unix(0, 0).UTC(), at.UTC(),
+	}})
+}
+
+// Sources returns every known source, ordered by host and service. LastSeen
+// is the later of the last log and heartbeat; QuietSeconds is left to the
+// caller.
+func (c *ClickHouse) Sources(ctx context.Context) ([]models.Source, error) {
+	rows, err := c.conn.QueryContext(ctx, `SELECT host, service, sum(logs), max(last_log_at), max(last_heartbeat_at)
+		FROM sources GROUP BY host, service ORDER BY host, service`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sources := []models.Source{}
+	for rows.Next() {
+		var src models.Source
+		var lastLog, lastHeartbeat time.Time
+		if err := rows.Scan(&src.Host, &src.Service, &src.Logs, &lastLog, &lastHeartbeat); err != nil {
+			return nil, err
+		}
+		// The epoch stands for never
+		if lastLog.Unix() > 0 {
+			src.LastLogAt = &lastLog
+		}
+		if lastHeartbeat.Unix() > 0 {
+			src.LastHeartbeatAt = &lastHeartbeat
+		}
+		src.LastSeen = lastSeen(&src)
+		sources = append(sources, src)
+	}
+	return sources, rows.Err()
+}
+
+// LastLogAt returns when logs from service on host were last ingested, an
+// empty service or host matching any, or false when none have been.
+func (c *ClickHouse) LastLogAt(ctx context.Context, service, host string) (time.Time, bool, error) {
+	var last time.Time
+	err := c.conn.QueryRowContext(ctx, "SELECT max(last_log_at) FROM sources WHERE (? = '' OR service = ?) AND (? = '' OR host = ?)",
+		service, service, host, host).Scan(&last)
+	if err != nil || last.Unix() <= 0 {
+		return time.Time{}, false, err
+	}
+	return last, true, nil
+}
+
+// Prefs returns all preferences stored for user, keyed by name.
+func (c *ClickHouse) Prefs(ctx context.Context, user string) (map[string]json.RawMessage, error) {
+	rows, err := c.conn.QueryContext(ctx, "SELECT key, value FROM user_prefs FINAL WHERE user = ?", user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	prefs := make(map[string]json.RawMessage)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		prefs[key] = json.RawMessage(value)
+	}
+	return prefs, rows.Err()
+}
+
+// Pref returns a single preference, or nil if it is not set.
+func (c *ClickHouse) Pref(ctx context.Context, user, key string) (json.RawMessage, error) {
+	rows, err := c.conn.QueryContext(ctx, "SELECT value FROM user_prefs FINAL WHERE user = ? AND key = ?", user, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var value json.RawMessage
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		value = json.RawMessage(v)
+	}
+	return value, rows.Err()
+}
+
+// SetPref stores a preference, replacing any previous value. value must be
+// valid JSON.
+func (c *ClickHouse) SetPref(ctx context.Context, user, key string, value json.RawMessage) error {
+	return c.insert(ctx, "user_prefs (user, key, value, updated_at)", [][]interface{}{{user, key, string(value), time.Now().UTC()}})
+}
+
+// DeletePref removes a preference and reports whether it existed.
+func (c *ClickHouse) DeletePref(ctx context.Context, user, key string) (bool, error) {
+	n, err := c.count(ctx, "SELECT count() FROM user_prefs FINAL WHERE user = ? AND key = ?", user, key)
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = c.conn.ExecContext(ctx, "DELETE FROM user_prefs WHERE user = ? AND key = ?", user, key)
+	return err == nil, err
+}
+
+// HostGroups returns the host groups stored via the API, keyed by name.
+func (c *ClickHouse) HostGroups(ctx context.Context) (map[string][]string, error) {
+	rows, err := c.conn.QueryContext(ctx, "SELECT name, hosts FROM host_groups FINAL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	groups := make(map[string][]string)
+	for rows.Next() {
+		var name string
+		var hosts []string
+		if err := rows.Scan(&name, &hosts); err != nil {
+			return nil, err
+		}
+		groups[name] = hosts
+	}
+	return groups, rows.Err()
+}
+
+// SetHostGroup stores a host group, replacing any previous members.
+func (c *ClickHouse) SetHostGroup(ctx context.Context, name string, hosts []string) error {
+	if hosts == nil {
+		hosts = []string{}
+	}
+	return c.insert(ctx, "host_groups (name, hosts, updated_at)", [][]interface{}{{name, hosts, time.Now().UTC()}})
+}
+
+// DeleteHostGroup removes a stored host group and reports whether it existed.
+func (c *ClickHouse) DeleteHostGroup(ctx context.Context, name string) (bool, error) {
+	n, err := c.count(ctx, "SELECT count() FROM host_groups FINAL WHERE name = ?", name)
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = c.conn.ExecContext(ctx, "DELETE FROM host_groups WHERE name = ?", name)
+	return err == nil, err
+}
+
+// StoreVaultEntries saves encrypted originals of redacted values. Entries are
+// pruned once older than any log retention keeps (see DeleteOldLogs).
+func (c *ClickHouse) StoreVaultEntries(ctx context.Context, entries []VaultEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	tokens := make([]string, len(entries))
+	rows := make([][]interface{}, len(entries))
+	for i, e := range entries {
+		tokens[i] = e.Token
+		rows[i] = []interface{}{e.Token, e.Rule, string(e.Ciphertext), e.CreatedAt.UTC()}
+	}
+	stored, err := c.VaultEntries(ctx, tokens)
+	if err != nil {
+		return err
+	}
+	if len(stored) > 0 {
+		return fmt.Errorf("vault token %s already stored", stored[0].Token)
+	}
+	return c.insert(ctx, "redaction_vault (token, rule, ciphertext, created_at)", rows)
+}
+
+// VaultEntries returns the stored entries for tokens; unknown tokens are
+// skipped.
+func (c *ClickHouse) VaultEntries(ctx context.Context, tokens []string) ([]VaultEntry, error) {
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	rows, err := c.conn.QueryContext(ctx, "SELECT token, rule, ciphertext, created_at FROM redaction_vault WHERE token IN "+
+		chList(len(tokens)), chArgs(tokens)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byToken := make(map[string]VaultEntry)
+	for rows.Next() {
+		var e VaultEntry
+		var ciphertext string
+		if err := rows.Scan(&e.Token, &e.Rule, &ciphertext, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Ciphertext = []byte(ciphertext)
+		byToken[e.Token] = e
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var entries []VaultEntry
+	for _, token := range tokens {
+		if e, ok := byToken[token]; ok {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// RecordVaultReveal appends to the reveal audit trail.
+func (c *ClickHouse) RecordVaultReveal(ctx context.Context, reveal models.VaultReveal) error {
+	c.mu.Lock()
+	c.lastReveal++
+	id := c.lastReveal
+	c.mu.Unlock()
+	tokens := reveal.Tokens
+	if tokens == nil {
+		tokens = []string{}
+	}
+	return c.insert(ctx, "vault_reveals (id, revealed_at, client, reason, tokens)", [][]interface{}{{
+		id, reveal.RevealedAt.UTC(), reveal.Client, reveal.Reason, tokens,
+	}})
+}
+
+// VaultReveals returns the most recent reveals, newest first.
+func (c *ClickHouse) VaultReveals(ctx context.Context, limit int) ([]models.VaultReveal, error) {
+	rows, err := c.conn.QueryContext(ctx, "SELECT id, revealed_at, client, reason, tokens FROM vault_reveals ORDER BY id DESC LIMIT ?", max(limit, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reveals := []models.VaultReveal{}
+	for rows.Next() {
+		var v models.VaultReveal
+		if err := rows.Scan(&v.ID, &v.RevealedAt, &v.Client, &v.Reason, &v.Tokens); err != nil {
+			return nil, err
+		}
+		if len(v.Tokens) == 0 {
+			v.Tokens = nil
+		}
+		reveals = append(reveals, v)
+	}
+	return reveals, rows.Err()
+}
+
+// chMergedTables are the tables whose rows are replaced or summed as
+// ClickHouse merges their parts.
+var chMergedTables = []string{"log_tags", "tag_exports", "filter_values", "sources", "incidents", "user_prefs", "host_groups"}
+
+// MaintenanceDue reports false: ClickHouse merges parts in the background.
+func (c *ClickHouse) MaintenanceDue(ctx context.Context) (bool, error) {
+	return false, nil
+}
+
+// Maintain merges the parts of the tables holding replaced or summed rows
+// one at a time, so reads with FINAL and sums have fewer rows to combine,
+// recording its progress for MaintenanceStatus. It returns
+// ErrMaintenanceRunning if a run is under way.
+func (c *ClickHouse) Maintain(ctx context.Context, reason string) error {
+	m := &c.maintenance
+	if !m.running.TryLock() {
+		return ErrMaintenanceRunning
+	}
+	defer m.running.Unlock()
+
+	start := time.Now()
+	started := start.UTC()
+	m.mu.Lock()
+	deletedBefore := m.status.DeletedSince
+	m.status = models.MaintenanceStatus{State: "running", Reason: reason, StepsTotal: len(chMergedTables),
+		StartedAt: &started, Runs: m.status.Runs + 1, DeletedSince: deletedBefore}
+	m.mu.Unlock()
+
+	var err error
+	for _, table := range chMergedTables {
+		name := "optimize " + table
+		m.mu.Lock()
+		m.status.Step = name
+		m.mu.Unlock()
+		stepStart := time.Now()
+		if _, err = c.conn.ExecContext(ctx, "OPTIMIZE TABLE "+table+" FINAL"); err != nil {
+			err = fmt.Errorf("%s: %w", name, err)
+			break
+		}
+		m.mu.Lock()
+		m.status.StepsDone++
+		m.status.Steps = append(m.status.Steps, models.MaintenanceStep{Name: name, DurationMs: time.Since(stepStart).Milliseconds()})
+		m.mu.Unlock()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	finished := time.Now().UTC()
+	m.status.Step = ""
+	m.status.FinishedAt = &finished
+	m.status.DurationMs = finished.Sub(start).Milliseconds()
+	if err != nil {
+		m.status.State, m.status.Error = "failed", err.Error()
+		return err
+	}
+	// Deletes during the run count toward the next one
+	m.status.State = "done"
+	m.status.DeletedSince -= deletedBefore
+	return nil
+}
+
+// MaintenanceStatus returns the progress of the running maintenance, or the
+// outcome of the last one.
+func (c *ClickHouse) MaintenanceStatus() models.MaintenanceStatus {
+	return c.maintenance.snapshot()
+}
+
+// MigrationStatus returns no migrations: the schema is created whole.
+func (c *ClickHouse) MigrationStatus() []models.MigrationStatus {
+	return []models.MigrationStatus{}
+}
+
+// CheckIntegrity checks the server answers and, if full, that the parts of
+// logs pass CHECK TABLE.
+func (c *ClickHouse) CheckIntegrity(ctx context.Context, full bool) error {
+	if !full {
+		return c.conn.PingContext(ctx)
+	}
+	var ok uint8
+	if err := c.conn.QueryRowContext(ctx, "CHECK TABLE logs SETTINGS check_query_single_value_result = 1").Scan(&ok); err != nil {
+		return err
+	}
+	if ok != 1 {
+		return fmt.Errorf("CHECK TABLE logs found damaged parts")
+	}
+	return nil
+}
+
+// Backup fails: ClickHouse backups are taken with its BACKUP statement.
+func (c *ClickHouse) Backup(ctx context.Context, path string) error {
+	return errNoClickHouseFile
+}
+
+// ExportSQLite fails: a ClickHouse database has no file to copy.
+func (c *ClickHouse) ExportSQLite(ctx context.Context, path string, key []byte) error {
+	return errNoClickHouseFile
+}
+
+// Backend returns "clickhouse".
+func (c *ClickHouse) Backend() string {
+	return "clickhouse"
+}
+
+// Version returns the version of the ClickHouse server.
+func (c *ClickHouse) Version(ctx context.Context) (string, error) {
+	var version string
+	err := c.conn.QueryRowContext(ctx, "SELECT version()").Scan(&version)
+	return version, err
+}
+
+// ReadOnly returns false.
+func (c *ClickHouse) ReadOnly() bool {
+	return false
+}
+
+// Encrypted returns false: encryption at rest is configured in ClickHouse.
+func (c *ClickHouse) Encrypted() bool {
+	return false
+}
+
+// MaxLogs returns 0: the database keeps every log until retention deletes
+// it.
+func (c *ClickHouse) MaxLogs() int {
+	return 0
+}
+
+// Close writes the logs waiting to be inserted and closes the connections.
+func (c *ClickHouse) Close() error {
+	c.batcher.close()
+	return c.conn.Close()
+}
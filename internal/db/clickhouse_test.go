@@ -0,0 +1,312 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestCHGlobRegexp(t *testing.T) {
+	for _, tc := range globCases {
+		re := regexp.MustCompile(chGlobRegexp(tc.pattern))
+		if got := re.MatchString(tc.s); got != tc.want {
+			t.Errorf("%q against %q: regexp %s matched %v, want %v", tc.pattern, tc.s, re, got, tc.want)
+		}
+	}
+}
+
+func TestCHBatcher(t *testing.T) {
+	var mu sync.Mutex
+	var blocks []int
+	fail := errors.New("server gone")
+	b := newCHBatcher(func(batches [][]chRow) error {
+		n := 0
+		for _, rows := range batches {
+			for i := range rows {
+				n++
+				rows[i].log.ID = int64(n)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		blocks = append(blocks, n)
+		if n == 3 {
+			return fail
+		}
+		return nil
+	}, 5, 50*time.Millisecond)
+	ctx := context.Background()
+
+	// Concurrent inserts share a block, written once it is old enough
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := b.add(ctx, make([]chRow, 2)); err != nil {
+				t.Errorf("expected the block to be written, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// A full block is written at once, numbering the caller's rows
+	rows := make([]chRow, 6)
+	start := time.Now()
+	if err := b.add(ctx, rows); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) >= 50*time.Millisecond || rows[5].log.ID != 6 {
+		t.Errorf("expected a full block to be written at once and numbered, took %s, last ID %d", time.Since(start), rows[5].log.ID)
+	}
+
+	// Every insert in a failed block gets its error
+	if err := b.add(ctx, make([]chRow, 3)); !errors.Is(err, fail) {
+		t.Errorf("expected the block's error, got %v", err)
+	}
+
+	// Close writes the pending block, then refuses inserts
+	done := make(chan error)
+	go func() { done <- b.add(ctx, make([]chRow, 1)) }()
+	time.Sleep(10 * time.Millisecond)
+	b.close()
+	if err := <-done; err != nil {
+		t.Errorf("expected the pending block to be written on close, got %v", err)
+	}
+	if err := b.add(ctx, make([]chRow, 1)); !errors.Is(err, errStoreClosed) {
+		t.Errorf("expected errStoreClosed after close, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(blocks, []int{4, 6, 3, 1}) {
+		t.Errorf("unexpected blocks: %v", blocks)
+	}
+}
+
+func TestCHMetaClause(t *testing.T) {
+	cond, args := chMetaClause(models.MetaFilter{Key: "http.status", Op: "!=", Value: "ok"})
+	want := "NOT (JSONType(metadata, ?, ?) = 'String' AND JSONExtractString(metadata, ?, ?) = ?)"
+	if cond != want || !reflect.DeepEqual(args, []interface{}{"http", "status", "http", "status", "ok"}) {
+		t.Errorf("unexpected clause %q %v", cond, args)
+	}
+	for _, m := range []models.MetaFilter{{Key: "a", Op: ">", Value: "1"}, {Key: "a", Op: "~", Numeric: true}, {Key: "a", Op: "=", Derived: true}} {
+		if cond, _ := chMetaClause(m); cond != "0" {
+			t.Errorf("expected %+v to match nothing, got %q", m, cond)
+		}
+	}
+}
+
+// newCHTestDB connects to the scratch database in
+// LOCOG_TEST_CLICKHOUSE_DSN, emptying its tables, or skips the test.
+func newCHTestDB(t *testing.T) *ClickHouse {
+	t.Helper()
+	dsn := os.Getenv("LOCOG_TEST_CLICKHOUSE_DSN")
+	if dsn == "" {
+		t.Skip("LOCOG_TEST_CLICKHOUSE_DSN not set")
+	}
+	c, err := NewClickHouse(dsn, Options{})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	for _, table := range []string{"logs", "log_tags", "tag_exports", "filter_values", "sources", "incidents",
+		"user_prefs", "host_groups", "redaction_vault", "vault_reveals"} {
+		if _, err := c.conn.Exec("TRUNCATE TABLE " + table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.lastID, c.lastIncident, c.lastReveal = 0, 0, 0
+	return c
+}
+
+// TestClickHouse_MatchesRing tests that ClickHouse answers queries as a
+// Ring holding the same logs does; both match search literally.
+func TestClickHouse_MatchesRing(t *testing.T) {
+	ctx := context.Background()
+	ch := newCHTestDB(t)
+	r := newTestRing(t, 1000)
+
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var logs []models.Log
+	services := []string{"api", "web", "worker"}
+	levels := []string{"info", "ERROR", "warn", "debug"}
+	for i := range 60 {
+		l := models.Log{
+			Timestamp: base.Add(time.Duration(i/2)*time.Minute + time.Duration(i%2)*time.Nanosecond),
+			Service:   services[i%3],
+			Level:     levels[i%4],
+			Message:   []string{"Request done", "request FAILED", "cache miss"}[i%3],
+			Host:      []string{"web-1", "web-2", "db-1"}[i%3],
+			Metadata:  map[string]interface{}{"status": 200 + i%5*100, "user": []string{"ann", "bob"}[i%2], "http": map[string]interface{}{"ms": i}},
+		}
+		if i%7 == 0 {
+			l.Metadata = nil
+		}
+		if i%4 == 0 {
+			l.Stream = "audit"
+		}
+		logs = append(logs, l)
+	}
+	for _, s := range []Store{r, ch} {
+		if err := s.InsertBatch(ctx, logs); err != nil {
+			t.Fatalf("%s: InsertBatch failed: %v", s.Backend(), err)
+		}
+		if _, err := s.TagLogs(ctx, models.LogFilter{Level: "ERROR"}, "incident-1"); err != nil {
+			t.Fatalf("%s: TagLogs failed: %v", s.Backend(), err)
+		}
+	}
+	same := func(t *testing.T, what string, want, got interface{}) {
+		t.Helper()
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: the ring returned %v, ClickHouse %v", what, want, got)
+		}
+	}
+
+	start, end := base.Add(5*time.Minute), base.Add(20*time.Minute)
+	filters := map[string]models.LogFilter{
+		"all":        {},
+		"service":    {Service: "api"},
+		"levels":     {Levels: []string{"error", "WARN"}},
+		"exclude":    {ExcludeLevels: []string{"DEBUG"}, ExcludeHosts: []string{"db-1"}, ExcludeSearch: []string{"miss"}},
+		"patterns":   {HostPatterns: []string{"web-*"}, ServicePatterns: []string{"w?b", "api"}},
+		"search":     {Search: "request"},
+		"regex":      {Search: "^Request|MISS$", SearchRegex: true},
+		"range":      {StartTime: &start, EndTime: &end},
+		"ascending":  {Ascending: true, Limit: 7},
+		"since":      {SinceID: 10, MaxID: 40},
+		"cursor":     {Cursor: &models.LogCursor{Timestamp: base.Add(10*time.Minute + time.Nanosecond), ID: 21}, Limit: 5},
+		"cursor asc": {Cursor: &models.LogCursor{Timestamp: base.Add(10 * time.Minute), ID: 21}, Ascending: true, Limit: 5},
+		"tag":        {Tag: "incident-1"},
+		"stream":     {Stream: "default"},
+		"meta":       {Meta: []models.MetaFilter{{Key: "user", Op: "=", Value: "ann"}}},
+		"meta not":   {Meta: []models.MetaFilter{{Key: "user", Op: "!=", Value: "ann"}}},
+		"numeric":    {Meta: []models.MetaFilter{{Key: "status", Op: ">=", Numeric: true, Number: 400}}},
+		"nested":     {Meta: []models.MetaFilter{{Key: "http.ms", Op: "!=", Numeric: true, Number: 3}}},
+	}
+	for name, filter := range filters {
+		t.Run(name, func(t *testing.T) {
+			same(t, "QueryLogs", ids(r.QueryLogs(ctx, filter)), ids(ch.QueryLogs(ctx, filter)))
+			same(t, "CountLogs", value(r.CountLogs(ctx, filter)), value(ch.CountLogs(ctx, filter)))
+			from, to := base.Add(-30*time.Second), base.Add(30*time.Minute-30*time.Second)
+			same(t, "Histogram", value(r.Histogram(ctx, filter, from, to, 6)), value(ch.Histogram(ctx, filter, from, to, 6)))
+			same(t, "CountLogsByGroup", value(r.CountLogsByGroup(ctx, filter, "metadata.user", 10*time.Minute, 5)),
+				value(ch.CountLogsByGroup(ctx, filter, "metadata.user", 10*time.Minute, 5)))
+			same(t, "AggregateMetadata", value(r.AggregateMetadata(ctx, filter, "status", "service", 0)),
+				value(ch.AggregateMetadata(ctx, filter, "status", "service", 0)))
+			same(t, "TopErrors", value(r.TopErrors(ctx, filter, 100)), value(ch.TopErrors(ctx, filter, 100)))
+		})
+	}
+
+	same(t, "GetFilterOptions", value(r.GetFilterOptions(ctx)), value(ch.GetFilterOptions(ctx)))
+	same(t, "SuggestFilterValues", value(r.SuggestFilterValues(ctx, "host", "WEB", 10)),
+		value(ch.SuggestFilterValues(ctx, "host", "WEB", 10)))
+	same(t, "Availability", value(r.Availability(ctx, models.LogFilter{}, []string{"error"}, base, 10*time.Minute, 3)),
+		value(ch.Availability(ctx, models.LogFilter{}, []string{"error"}, base, 10*time.Minute, 3)))
+
+	l, _ := r.GetLog(ctx, 30)
+	before, after, err := r.LogContext(ctx, models.LogFilter{Service: "web"}, *l, 2, 2)
+	chBefore, chAfter, chErr := ch.LogContext(ctx, models.LogFilter{Service: "web"}, *l, 2, 2)
+	same(t, "LogContext before", ids(before, err), ids(chBefore, chErr))
+	same(t, "LogContext after", ids(after, err), ids(chAfter, chErr))
+
+	olderThan := time.Since(base.Add(15 * time.Minute))
+	policies := []TagPolicy{{Pattern: "incident-*", Keep: time.Since(base.Add(5 * time.Minute))}}
+	for _, s := range []Store{r, ch} {
+		s.SetStreamRetention(map[string]time.Duration{"audit": time.Since(base.Add(25 * time.Minute))})
+		s.SetTagPolicies(policies)
+	}
+	preview, err := r.RetentionPreview(ctx, olderThan, policies)
+	chPreview, chErr := ch.RetentionPreview(ctx, olderThan, policies)
+	chPreview.Cutoff = preview.Cutoff
+	same(t, "RetentionPreview", value(preview, err), value(chPreview, chErr))
+	same(t, "DeleteOldLogs", value(r.DeleteOldLogs(ctx, olderThan)), value(ch.DeleteOldLogs(ctx, olderThan)))
+	same(t, "QueryLogs after DeleteOldLogs", ids(r.QueryLogs(ctx, models.LogFilter{})), ids(ch.QueryLogs(ctx, models.LogFilter{})))
+}
+
+// TestClickHouse_GlobPatterns tests that match() with chGlobRegexp matches
+// service globs as GlobMatch does.
+func TestClickHouse_GlobPatterns(t *testing.T) {
+	testGlobPatterns(t, newCHTestDB(t))
+}
+
+func TestClickHouse_State(t *testing.T) {
+	ctx := context.Background()
+	ch := newCHTestDB(t)
+
+	now := time.Now().UTC()
+	if err := ch.InsertLog(ctx, &models.Log{Timestamp: now, Service: "api", Level: "info", Message: "m", Host: "h"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := ch.TagLogs(ctx, models.LogFilter{}, "incident-7"); err != nil || n != 1 {
+		t.Fatalf("expected 1 log tagged, got %d, %v", n, err)
+	}
+	if n, _ := ch.TagLogs(ctx, models.LogFilter{}, "incident-7"); n != 0 {
+		t.Errorf("expected a tagged log not to be tagged again, got %d", n)
+	}
+	pending, err := ch.PendingTagExports(ctx, "incident-*", 10)
+	if err != nil || len(pending) != 1 || pending[0].Log.Message != "m" {
+		t.Fatalf("expected 1 pending export, got %v, %v", pending, err)
+	}
+	if err := ch.MarkTagsExported(ctx, pending); err != nil {
+		t.Fatal(err)
+	}
+	counts, err := ch.TagExportCounts(ctx, "incident-*")
+	if err != nil || counts["incident-7"] != (TagExportCount{Exported: 1}) {
+		t.Errorf("unexpected export counts %v, %v", counts, err)
+	}
+	if n, err := ch.UntagLogs(ctx, models.LogFilter{}, "incident-7"); err != nil || n != 1 {
+		t.Errorf("expected 1 log untagged, got %d, %v", n, err)
+	}
+
+	if err := ch.SetPref(ctx, "ann", "theme", []byte(`"dark"`)); err != nil {
+		t.Fatal(err)
+	}
+	ch.SetPref(ctx, "ann", "theme", []byte(`"light"`))
+	if v, _ := ch.Pref(ctx, "ann", "theme"); string(v) != `"light"` {
+		t.Errorf("expected the later value, got %s", v)
+	}
+	if ok, _ := ch.DeletePref(ctx, "ann", "theme"); !ok {
+		t.Error("expected the preference to be deleted")
+	}
+	if ok, _ := ch.DeletePref(ctx, "ann", "theme"); ok {
+		t.Error("expected no preference left to delete")
+	}
+
+	inc := models.Incident{Name: "outage", Services: []string{"api"}, StartedAt: now.Add(-time.Hour),
+		EndsAt: now.Add(time.Hour), KeepUntil: now.Add(24 * time.Hour)}
+	if err := ch.StartIncident(ctx, &inc); err != nil || inc.ID != 1 {
+		t.Fatalf("expected incident 1, got %d, %v", inc.ID, err)
+	}
+	ended, ok, err := ch.EndIncident(ctx, inc.ID, now)
+	if err != nil || !ok || !ended.EndsAt.Equal(now) || !ended.KeepUntil.Equal(now.Add(23*time.Hour)) {
+		t.Errorf("unexpected ended incident %+v, %v, %v", ended, ok, err)
+	}
+	if incidents, _ := ch.Incidents(ctx, now); len(incidents) != 1 || !incidents[0].EndsAt.Equal(now) {
+		t.Errorf("expected the ended incident once, got %+v", incidents)
+	}
+
+	if err := ch.RecordHeartbeat(ctx, "h", "api", now); err != nil {
+		t.Fatal(err)
+	}
+	sources, err := ch.Sources(ctx)
+	if err != nil || len(sources) != 1 || sources[0].Logs != 1 || sources[0].LastHeartbeatAt == nil {
+		t.Errorf("expected one source with a log and a heartbeat, got %+v, %v", sources, err)
+	}
+
+	if err := ch.StoreVaultEntries(ctx, []VaultEntry{{Token: "t1", Rule: "email", Ciphertext: []byte{0, 1, 2}, CreatedAt: now}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.StoreVaultEntries(ctx, []VaultEntry{{Token: "t1"}}); err == nil {
+		t.Error("expected a stored token to be refused")
+	}
+	if entries, _ := ch.VaultEntries(ctx, []string{"t1", "t2"}); len(entries) != 1 || string(entries[0].Ciphertext) != "\x00\x01\x02" {
+		t.Errorf("unexpected vault entries %+v", entries)
+	}
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"slices"
+
+	"locog/internal/models"
+)
+
+// GetLog returns the log with the given ID, or nil if there is none.
+func (db *DB) GetLog(ctx context.Context, id int64) (*models.Log, error) {
+	ctx, span := db.startSpan(ctx, "GetLog")
+	defer span.End()
+
+	var found *models.Log
+	err := db.scanLogs(ctx, db.selectLogs(false)+" WHERE id = ?", []interface{}{id}, func(l models.Log) error {
+		found = &l
+		return nil
+	})
+	return found, err
+}
+
+// LogContext returns up to before logs preceding l and up to after logs
+// following it among those matching filter, each oldest first. Logs are
+// ordered by timestamp, then ID, so logs sharing l's timestamp fall on the
+// side their ID puts them.
+func (db *DB) LogContext(ctx context.Context, filter models.LogFilter, l models.Log, before, after int) ([]models.Log, []models.Log, error) {
+	ctx, span := db.startSpan(ctx, "LogContext")
+	defer span.End()
+
+	where, args := db.buildWhere(filter)
+	if where == "" {
+		where = " WHERE 1 = 1"
+	}
+	collect := func(logs *[]models.Log) func(models.Log) error {
+		return func(l models.Log) error {
+			*logs = append(*logs, l)
+			return nil
+		}
+	}
+
+	preceding := []models.Log{}
+	if before > 0 {
+		query := db.selectLogs(false) + where + " AND (timestamp < ? OR (timestamp = ? AND id < ?)) ORDER BY timestamp DESC, id DESC LIMIT ?"
+		if err := db.scanLogs(ctx, query, append(slices.Clip(args), l.Timestamp, l.Timestamp, l.ID, before), collect(&preceding)); err != nil {
+			return nil, nil, err
+		}
+		slices.Reverse(preceding)
+	}
+
+	following := []models.Log{}
+	if after > 0 {
+		query := db.selectLogs(false) + where + " AND (timestamp > ? OR (timestamp = ? AND id > ?)) ORDER BY timestamp ASC, id ASC LIMIT ?"
+		if err := db.scanLogs(ctx, query, append(slices.Clip(args), l.Timestamp, l.Timestamp, l.ID, after), collect(&following)); err != nil {
+			return nil, nil, err
+		}
+	}
+	return preceding, following, nil
+}
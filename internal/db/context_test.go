@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestLogContext(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	base := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		db.InsertLog(ctx, &models.Log{Timestamp: base.Add(time.Duration(i) * time.Second), Service: "api", Level: "INFO",
+			Message: string(rune('a' + i)), Host: "h1"})
+	}
+	// Same second as "c" but inserted later, so it follows it
+	tie := &models.Log{Timestamp: base.Add(2 * time.Second), Service: "api", Level: "INFO", Message: "c2", Host: "h1"}
+	db.InsertLog(ctx, tie)
+	// Other hosts and services are not context
+	db.InsertLog(ctx, &models.Log{Timestamp: base.Add(2 * time.Second), Service: "api", Level: "INFO", Message: "other host", Host: "h2"})
+	db.InsertLog(ctx, &models.Log{Timestamp: base.Add(2 * time.Second), Service: "web", Level: "INFO", Message: "other service", Host: "h1"})
+
+	// IDs follow insertion order
+	l, err := db.GetLog(ctx, 3)
+	if err != nil || l == nil || l.Message != "c" {
+		t.Fatalf("GetLog: expected log c, got %+v, %v", l, err)
+	}
+	if missing, err := db.GetLog(ctx, 9999); err != nil || missing != nil {
+		t.Errorf("GetLog: expected nil for a missing log, got %+v, %v", missing, err)
+	}
+
+	before, after, err := db.LogContext(ctx, models.LogFilter{Service: "api", Host: "h1"}, *l, 5, 2)
+	if err != nil {
+		t.Fatalf("LogContext failed: %v", err)
+	}
+	messages := func(logs []models.Log) (out []string) {
+		for _, l := range logs {
+			out = append(out, l.Message)
+		}
+		return out
+	}
+	if got := messages(before); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("expected a, b before, got %v", got)
+	}
+	if got := messages(after); len(got) != 2 || got[0] != "c2" || got[1] != "d" {
+		t.Errorf("expected c2, d after, got %v", got)
+	}
+
+	before, after, err = db.LogContext(ctx, models.LogFilter{Service: "api", Host: "h1"}, *l, 0, 0)
+	if err != nil || len(before) != 0 || len(after) != 0 {
+		t.Errorf("expected no context, got %v, %v, %v", before, after, err)
+	}
+}
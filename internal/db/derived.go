@@ -0,0 +1,283 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// DerivedField is a named expression over log columns and metadata, defined by
+// the operator and evaluated at query time, e.g.
+//
+//	status_class = floor(meta.status_code / 100)
+//
+// Expressions support numbers, 'strings', the columns service, level, host and
+// message, meta.<dotted.key>, the operators + - * / % and the functions floor,
+// ceil, round, abs, lower, upper, length and coalesce.
+type DerivedField struct {
+	Name string
+	Expr string
+	sql  string
+}
+
+var derivedNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// CompileDerivedField parses expr and translates it to SQL.
+func CompileDerivedField(name, expr string) (DerivedField, error) {
+	if !derivedNamePattern.MatchString(name) {
+		return DerivedField{}, fmt.Errorf("invalid derived field name %q", name)
+	}
+	p := &exprParser{tokens: tokenizeExpr(expr)}
+	sql, err := p.parseExpr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return DerivedField{}, fmt.Errorf("derived field %s: %w", name, err)
+	}
+	return DerivedField{Name: name, Expr: expr, sql: sql}, nil
+}
+
+// derivedFields hold a store's derived fields by name.
+type derivedFields struct {
+	derivedMu sync.RWMutex
+	derived   map[string]DerivedField
+}
+
+// SetDerivedFields replaces the derived fields available to filters and
+// group-bys.
+func (d *derivedFields) SetDerivedFields(fields []DerivedField) {
+	m := make(map[string]DerivedField, len(fields))
+	for _, f := range fields {
+		m[f.Name] = f
+	}
+	d.derivedMu.Lock()
+	d.derived = m
+	d.derivedMu.Unlock()
+}
+
+// DerivedFields returns the configured derived fields.
+func (d *derivedFields) DerivedFields() []DerivedField {
+	d.derivedMu.RLock()
+	defer d.derivedMu.RUnlock()
+	out := make([]DerivedField, 0, len(d.derived))
+	for _, f := range d.derived {
+		out = append(out, f)
+	}
+	return out
+}
+
+func (d *derivedFields) derivedSQL(name string) (string, bool) {
+	d.derivedMu.RLock()
+	defer d.derivedMu.RUnlock()
+	f, ok := d.derived[name]
+	return f.sql, ok
+}
+
+func tokenizeExpr(s string) []string {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '\'':
+			j := i + 1
+			for j < len(s) {
+				if s[j] == '\'' {
+					if j+1 < len(s) && s[j+1] == '\'' {
+						j += 2 // escaped quote
+						continue
+					}
+					break
+				}
+				j++
+			}
+			tokens = append(tokens, s[i:min(j+1, len(s))])
+			i = j + 1
+		case unicode.IsDigit(c) || c == '.' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1])):
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_' || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens
+}
+
+// exprParser is a recursive descent parser producing SQL.
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// parseExpr := term (('+' | '-') term)*
+func (p *exprParser) parseExpr() (string, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return "", err
+	}
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.next()
+		right, err := p.parseTerm()
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " " + op + " " + right + ")"
+	}
+	return left, nil
+}
+
+// parseTerm := unary (('*' | '/' | '%') unary)*
+func (p *exprParser) parseTerm() (string, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return "", err
+	}
+	for p.peek() == "*" || p.peek() == "/" || p.peek() == "%" {
+		op := p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " " + op + " " + right + ")"
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (string, error) {
+	if p.peek() == "-" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return "", err
+		}
+		return "(-" + operand + ")", nil
+	}
+	return p.parsePrimary()
+}
+
+var derivedColumns = map[string]bool{"service": true, "level": true, "host": true, "message": true}
+
+func (p *exprParser) parsePrimary() (string, error) {
+	tok := p.next()
+	switch {
+	case tok == "":
+		return "", fmt.Errorf("unexpected end of expression")
+	case tok == "(":
+		inner, err := p.parseExpr()
+		if err != nil {
+			return "", err
+		}
+		if p.next() != ")" {
+			return "", fmt.Errorf("missing ')'")
+		}
+		return "(" + inner + ")", nil
+	case strings.HasPrefix(tok, "'"):
+		if len(tok) < 2 || !strings.HasSuffix(tok, "'") {
+			return "", fmt.Errorf("unterminated string")
+		}
+		content := strings.ReplaceAll(tok[1:len(tok)-1], "''", "'")
+		return "'" + strings.ReplaceAll(content, "'", "''") + "'", nil
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		if _, err := strconv.ParseFloat(tok, 64); err != nil {
+			return "", fmt.Errorf("invalid number %q", tok)
+		}
+		return tok, nil
+	case strings.HasPrefix(tok, "meta."):
+		key := strings.TrimPrefix(tok, "meta.")
+		if key == "" {
+			return "", fmt.Errorf("missing metadata key")
+		}
+		return "json_extract(metadata, '" + strings.ReplaceAll(metaJSONPath(key), "'", "") + "')", nil
+	case derivedColumns[tok]:
+		return tok, nil
+	case p.peek() == "(":
+		return p.parseCall(strings.ToLower(tok))
+	default:
+		return "", fmt.Errorf("unknown identifier %q", tok)
+	}
+}
+
+func (p *exprParser) parseCall(name string) (string, error) {
+	p.next() // (
+	var args []string
+	if p.peek() != ")" {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return "", err
+			}
+			args = append(args, arg)
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+	}
+	if p.next() != ")" {
+		return "", fmt.Errorf("missing ')' after %s arguments", name)
+	}
+
+	unary := func() (string, error) {
+		if len(args) != 1 {
+			return "", fmt.Errorf("%s takes exactly one argument", name)
+		}
+		return args[0], nil
+	}
+
+	switch name {
+	case "floor", "ceil":
+		x, err := unary()
+		if err != nil {
+			return "", err
+		}
+		// SQLite is built without math functions, so emulate them
+		cmp, adj := "<", "- 1"
+		if name == "ceil" {
+			cmp, adj = ">", "+ 1"
+		}
+		return fmt.Sprintf("(CASE WHEN %[1]s %[2]s CAST(%[1]s AS INTEGER) THEN CAST(%[1]s AS INTEGER) %[3]s ELSE CAST(%[1]s AS INTEGER) END)", x, cmp, adj), nil
+	case "round", "abs", "lower", "upper", "length":
+		x, err := unary()
+		if err != nil {
+			return "", err
+		}
+		return name + "(" + x + ")", nil
+	case "coalesce":
+		if len(args) < 2 {
+			return "", fmt.Errorf("coalesce takes at least two arguments")
+		}
+		return "coalesce(" + strings.Join(args, ", ") + ")", nil
+	default:
+		return "", fmt.Errorf("unknown function %q", name)
+	}
+}
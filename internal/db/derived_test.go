@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestCompileDerivedField(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{"status_class", "floor(meta.status_code / 100)", false},
+		{"slow", "meta.duration_ms > 5", true}, // comparisons belong in filters
+		{"svc", "upper(service)", false},
+		{"total", "coalesce(meta.a, 0) + coalesce(meta.b, 0) * 2", false},
+		{"neg", "-meta.x", false},
+		{"str", "'it''s'", false},
+		{"bad_func", "sleep(1)", true},
+		{"bad_ident", "timestamp", true},
+		{"unbalanced", "floor(meta.x", true},
+		{"bad name!", "1", true},
+		{"empty", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileDerivedField(tt.name, tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CompileDerivedField(%q, %q) error = %v, wantErr %v", tt.name, tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDerivedFields_FilterAndGroupBy(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	f, err := CompileDerivedField("status_class", "floor(meta.status_code / 100)")
+	if err != nil {
+		t.Fatalf("CompileDerivedField failed: %v", err)
+	}
+	db.SetDerivedFields([]DerivedField{f})
+
+	for _, code := range []interface{}{200, 204, 404, 503, "500"} {
+		db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "req", Host: "h",
+			Metadata: map[string]interface{}{"status_code": code, "duration_ms": 10}})
+	}
+
+	logs, err := db.QueryLogs(ctx, models.LogFilter{Meta: []models.MetaFilter{
+		{Key: "status_class", Op: "=", Numeric: true, Number: 5, Derived: true},
+	}})
+	if err != nil {
+		t.Fatalf("QueryLogs failed: %v", err)
+	}
+	if len(logs) != 2 {
+		t.Errorf("expected 2 logs with status_class 5, got %d", len(logs))
+	}
+
+	results, err := db.AggregateMetadata(ctx, models.LogFilter{}, "duration_ms", "derived.status_class", 0)
+	if err != nil {
+		t.Fatalf("AggregateMetadata failed: %v", err)
+	}
+	counts := map[string]int64{}
+	for _, r := range results {
+		counts[r.Group] = r.Count
+	}
+	if counts["2"] != 2 || counts["4"] != 1 || counts["5"] != 2 {
+		t.Errorf("unexpected group counts: %v", counts)
+	}
+
+	if _, err := db.AggregateMetadata(ctx, models.LogFilter{}, "duration_ms", "derived.unknown", 0); err == nil {
+		t.Error("expected error for unknown derived field")
+	}
+}
+
+func TestFloorCeilEmulation(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, tc := range []struct {
+		expr string
+		want float64
+	}{
+		{"floor(2.5)", 2}, {"floor(-2.5)", -3}, {"floor(3)", 3},
+		{"ceil(2.5)", 3}, {"ceil(-2.5)", -2}, {"ceil(3)", 3},
+	} {
+		f, err := CompileDerivedField("x", tc.expr)
+		if err != nil {
+			t.Fatalf("CompileDerivedField(%q) failed: %v", tc.expr, err)
+		}
+		var got float64
+		if err := db.conn.QueryRow("SELECT " + f.sql).Scan(&got); err != nil {
+			t.Fatalf("evaluating %q failed: %v", tc.expr, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
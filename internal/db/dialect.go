@@ -0,0 +1,340 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"locog/internal/tracing"
+)
+
+// dialect is the SQL that differs between storage backends. Queries are
+// written with ? placeholders and the SQLite spelling of everything else;
+// only the fragments below vary.
+type dialect interface {
+	// name identifies the backend, e.g. "sqlite".
+	name() string
+	// schema creates all tables and indexes if they don't exist.
+	schema() string
+	// rebind converts ? placeholders to the driver's syntax.
+	rebind(query string) string
+
+	// jsonArg converts serialized JSON to a query argument for a JSON column.
+	jsonArg(data []byte) interface{}
+	// metaPath converts a dotted metadata key to the argument metaValue and
+	// friends expect for their path placeholder.
+	metaPath(key string) interface{}
+	// metaValue is the scalar metadata value at the path placeholder.
+	metaValue() string
+	// numericGuard matches logs whose metadata value at path is a JSON number
+	// or a string that looks like one.
+	numericGuard(path interface{}) (string, []interface{})
+	// metaNumber is the metadata value at path as a number.
+	metaNumber(path interface{}) (string, []interface{})
+	// generatedColumn is the definition of a column holding metaValue for
+	// key, for ALTER TABLE ADD COLUMN.
+	generatedColumn(key string) string
+
+	// search matches column case-insensitively against a LIKE pattern
+	// placeholder.
+	search(column string) string
+	// regexp matches column against a regular expression placeholder.
+	regexp(column string) string
+	// tagList aggregates the tags of logs.id into one string separated by
+	// \x1f, or NULL when there are none.
+	tagList() string
+	// histogramBucket is the bucket of timestamp for buckets of width seconds
+	// starting at start, with placeholders for start and width.
+	histogramBucket() string
+	// unixSeconds is the Unix time of a timestamp column in whole seconds.
+	unixSeconds(column string) string
+	// glob matches column against a glob placeholder; globPattern converts the
+	// glob to its argument.
+	glob(column string) string
+	globPattern(pattern string) interface{}
+	// greatest is the larger of two values.
+	greatest(a, b string) string
+
+	// columnExists is a query with table and column placeholders reporting
+	// whether the column exists.
+	columnExists() string
+	// explain prefixes a query to return its plan, which parsePlan reads.
+	explain() string
+	parsePlan(rows *sql.Rows) (queryPlan, error)
+	// version is a query returning the database server or library version.
+	version() string
+	// metadataKeys is the insert rebuilding the metadata keys report, with
+	// placeholders for since, example length (twice), generated at and since.
+	metadataKeys() string
+	// reclaimSpace returns the statements giving space freed by deletes back
+	// to the filesystem, run after the retention cleanup.
+	reclaimSpace() []string
+	// indexes is a query with a table placeholder returning the names of the
+	// table's indexes, other than those backing constraints.
+	indexes() string
+	// reindex rebuilds an index.
+	reindex(index string) string
+	// analyze returns the statements refreshing the planner's statistics on
+	// logs after maintenance.
+	analyze() []string
+}
+
+// rebindNumbered replaces ? placeholders outside string literals with $1, $2,
+// and so on.
+func rebindNumbered(query string) string {
+	var b strings.Builder
+	n := 0
+	inString := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			inString = !inString
+		case c == '?' && !inString:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// dbConn wraps the connection pool so queries written with ? placeholders run
+// unchanged on every backend. Query errors are recorded on the span in the
+// context, if any. With a writer, statements run with Exec and transactions
+// that aren't read-only take turns holding its write lock.
+type dbConn struct {
+	*sql.DB
+	dialect dialect
+	writer  *dbWriter
+}
+
+func (c *dbConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+func (c *dbConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	release, err := c.writer.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	result, err := c.DB.ExecContext(ctx, c.dialect.rebind(query), args...)
+	tracing.FromContext(ctx).RecordError(err)
+	return result, err
+}
+
+func (c *dbConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.rebind(query), args...)
+}
+
+func (c *dbConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	result, err := c.DB.QueryContext(ctx, c.dialect.rebind(query), args...)
+	tracing.FromContext(ctx).RecordError(err)
+	return result, err
+}
+
+func (c *dbConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.DB.QueryRow(c.dialect.rebind(query), args...)
+}
+
+func (c *dbConn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return c.DB.QueryRowContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c *dbConn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*dbTx, error) {
+	release := func() {}
+	if opts == nil || !opts.ReadOnly {
+		var err error
+		if release, err = c.writer.acquire(ctx); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := c.DB.BeginTx(ctx, opts)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &dbTx{Tx: tx, dialect: c.dialect, release: release}, nil
+}
+
+// Close stops the writer and closes the pool.
+func (c *dbConn) Close() error {
+	c.writer.close()
+	return c.DB.Close()
+}
+
+// dbTx is a transaction on a dbConn, holding the write lock until it is
+// committed or rolled back.
+type dbTx struct {
+	*sql.Tx
+	dialect dialect
+	release func()
+}
+
+func (tx *dbTx) Commit() error {
+	defer tx.release()
+	return tx.Tx.Commit()
+}
+
+func (tx *dbTx) Rollback() error {
+	defer tx.release()
+	return tx.Tx.Rollback()
+}
+
+func (tx *dbTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	result, err := tx.Tx.ExecContext(ctx, tx.dialect.rebind(query), args...)
+	tracing.FromContext(ctx).RecordError(err)
+	return result, err
+}
+
+func (tx *dbTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	result, err := tx.Tx.QueryContext(ctx, tx.dialect.rebind(query), args...)
+	tracing.FromContext(ctx).RecordError(err)
+	return result, err
+}
+
+func (tx *dbTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, tx.dialect.rebind(query), args...)
+}
+
+func (tx *dbTx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return tx.Tx.PrepareContext(ctx, tx.dialect.rebind(query))
+}
+
+// sqliteDialect is the default, embedded SQLite backend.
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string               { return "sqlite" }
+func (sqliteDialect) schema() string             { return schema }
+func (sqliteDialect) rebind(query string) string { return query }
+
+func (sqliteDialect) jsonArg(data []byte) interface{} { return data }
+
+func (sqliteDialect) metaPath(key string) interface{} { return metaJSONPath(key) }
+
+func (sqliteDialect) metaValue() string { return "json_extract(metadata, ?)" }
+
+func (sqliteDialect) numericGuard(path interface{}) (string, []interface{}) {
+	return `(json_type(metadata, ?) IN ('integer', 'real')
+		OR (json_type(metadata, ?) = 'text' AND json_extract(metadata, ?) <> ''
+			AND trim(json_extract(metadata, ?), '0123456789.eE+-') = ''))`,
+		[]interface{}{path, path, path, path}
+}
+
+func (sqliteDialect) metaNumber(path interface{}) (string, []interface{}) {
+	return "CAST(json_extract(metadata, ?) AS REAL)", []interface{}{path}
+}
+
+// generatedColumn is VIRTUAL since SQLite can't add STORED columns to an
+// existing table; its index still stores the values. It has no declared type
+// so it compares exactly like json_extract.
+func (sqliteDialect) generatedColumn(key string) string {
+	return "GENERATED ALWAYS AS (json_extract(metadata, " + sqlString(metaJSONPath(key)) + ")) VIRTUAL"
+}
+
+func (sqliteDialect) search(column string) string { return column + " LIKE ?" }
+
+// regexp uses the REGEXP function registered by the locog SQLite driver.
+func (sqliteDialect) regexp(column string) string { return column + " REGEXP ?" }
+
+func (sqliteDialect) tagList() string {
+	return "(SELECT group_concat(tag, char(31)) FROM log_tags WHERE log_id = logs.id)"
+}
+
+func (sqliteDialect) histogramBucket() string {
+	return "CAST((julianday(timestamp) - julianday(?)) * 86400.0 / ? AS INTEGER)"
+}
+
+func (sqliteDialect) unixSeconds(column string) string {
+	return "CAST(strftime('%s', " + column + ") AS INTEGER)"
+}
+
+func (sqliteDialect) glob(column string) string              { return column + " GLOB ?" }
+func (sqliteDialect) globPattern(pattern string) interface{} { return pattern }
+func (sqliteDialect) greatest(a, b string) string            { return "MAX(" + a + ", " + b + ")" }
+
+func (sqliteDialect) explain() string { return "EXPLAIN QUERY PLAN " }
+
+// sqliteIndexPattern finds the index and its conditions in a plan step, e.g.
+// "SEARCH logs USING INDEX idx_service_timestamp (service=? AND timestamp>?)".
+var sqliteIndexPattern = regexp.MustCompile(`USING (?:COVERING )?(INDEX (\w+)|INTEGER PRIMARY KEY)(?: \((.*)\))?`)
+
+func (sqliteDialect) parsePlan(rows *sql.Rows) (queryPlan, error) {
+	var plan queryPlan
+	for rows.Next() {
+		var id, parent, notused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			return plan, err
+		}
+		plan.steps = append(plan.steps, detail)
+		if strings.HasPrefix(detail, "SCAN logs") {
+			plan.fullScan = true
+		}
+		if m := sqliteIndexPattern.FindStringSubmatch(detail); m != nil {
+			if m[2] != "" {
+				plan.indexes = append(plan.indexes, m[2])
+			} else {
+				plan.indexes = append(plan.indexes, "PRIMARY KEY")
+			}
+			plan.conditions += m[3] + " "
+		}
+	}
+	return plan, rows.Err()
+}
+
+// columnExists uses table_xinfo since table_info omits generated columns.
+func (sqliteDialect) columnExists() string {
+	return "SELECT COUNT(*) > 0 FROM pragma_table_xinfo(?) WHERE name = ?"
+}
+
+func (sqliteDialect) version() string { return "SELECT sqlite_version()" }
+
+// reclaimSpace truncates free pages off the file, which needs
+// auto_vacuum=incremental (set for new databases by the DSN, and for older
+// ones by enableIncrementalVacuum), and resets the WAL, which otherwise keeps
+// the size it grew to during the deletes.
+func (sqliteDialect) reclaimSpace() []string {
+	return []string{"PRAGMA incremental_vacuum", "PRAGMA wal_checkpoint(TRUNCATE)"}
+}
+
+// indexes leaves out automatic indexes, which have no SQL.
+func (sqliteDialect) indexes() string {
+	return "SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL ORDER BY name"
+}
+
+func (sqliteDialect) reindex(index string) string { return `REINDEX "` + index + `"` }
+
+// analyze gathers no statistics: without sqlite_stat1 the planner keeps the
+// index choices the queries were written for.
+func (sqliteDialect) analyze() []string { return nil }
+
+// metadataKeys walks metadata with json_tree. It quotes path segments that
+// are not plain identifiers, so quotes are stripped to give the same dotted
+// keys accepted by meta filters.
+func (sqliteDialect) metadataKeys() string {
+	return `
+		INSERT INTO metadata_keys (service, key, type, count, service_total, example_min, example_max, last_seen, generated_at)
+		SELECT l.service, replace(substr(t.fullkey, 3), '"', '') AS key,
+			CASE t.type
+				WHEN 'integer' THEN 'number' WHEN 'real' THEN 'number'
+				WHEN 'true' THEN 'boolean' WHEN 'false' THEN 'boolean'
+				WHEN 'text' THEN 'string'
+				ELSE t.type
+			END AS kind,
+			COUNT(*),
+			(SELECT COUNT(*) FROM logs WHERE service = l.service AND timestamp >= ?),
+			MIN(substr(CASE WHEN t.type = 'array' THEN t.value ELSE CAST(t.atom AS TEXT) END, 1, ?)),
+			MAX(substr(CASE WHEN t.type = 'array' THEN t.value ELSE CAST(t.atom AS TEXT) END, 1, ?)),
+			MAX(l.timestamp),
+			?
+		FROM logs l, json_tree(l.metadata) t
+		WHERE l.timestamp >= ? AND l.metadata IS NOT NULL AND json_valid(l.metadata)
+			AND t.type != 'object' AND t.fullkey NOT LIKE '%[%'
+		GROUP BY l.service, key, kind`
+}
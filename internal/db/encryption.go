@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// EncryptionKeySize is the length of SQLCipher keys, which are used raw
+// rather than derived from a passphrase.
+const EncryptionKeySize = 32
+
+// ErrEncryptionUnsupported is returned when a key is given but the linked
+// SQLite library is not SQLCipher, which would silently ignore it.
+var ErrEncryptionUnsupported = errors.New("database encryption requires building locog against SQLCipher (-tags libsqlite3); see the README")
+
+// keyPragma is the SQLCipher statement setting a raw key.
+func keyPragma(key []byte) string {
+	return fmt.Sprintf(`PRAGMA key = "x'%X'"`, key)
+}
+
+// sqliteConnector opens connections with its own driver, so each database
+// can have its own connect hook.
+type sqliteConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func (c *sqliteConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *sqliteConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// openEncrypted opens a SQLCipher database. SQLCipher needs the key before
+// anything reads the file, so pragmas that do (journal_mode, cache_size) run
+// after it in the connect hook instead of the DSN.
+func openEncrypted(dbPath string, opts Options) (*DB, error) {
+	if len(opts.EncryptionKey) != EncryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", EncryptionKeySize, len(opts.EncryptionKey))
+	}
+	pragmas := []string{keyPragma(opts.EncryptionKey), "PRAGMA auto_vacuum = INCREMENTAL", "PRAGMA journal_mode = WAL", "PRAGMA cache_size = -64000"}
+	dsn := dbPath + "?_busy_timeout=5000&_synchronous=NORMAL"
+	if opts.ReadOnly {
+		pragmas = []string{keyPragma(opts.EncryptionKey), "PRAGMA cache_size = -64000", "PRAGMA query_only = true"}
+		dsn = "file:" + dbPath + "?mode=ro&_busy_timeout=5000"
+	}
+	sqlDB := sql.OpenDB(&sqliteConnector{
+		dsn: dsn,
+		driver: &sqlite3.SQLiteDriver{ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			for _, pragma := range pragmas {
+				if _, err := conn.Exec(pragma, nil); err != nil {
+					return fmt.Errorf("open encrypted database (wrong key?): %w", err)
+				}
+			}
+			return registerFunctions(conn)
+		}},
+	})
+	if err := checkCipher(sqlDB); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return openDB(sqlDB, sqliteDialect{}, opts)
+}
+
+// checkCipher returns ErrEncryptionUnsupported unless SQLite is SQLCipher,
+// which answers PRAGMA cipher_version.
+func checkCipher(sqlDB *sql.DB) error {
+	var version string
+	err := sqlDB.QueryRow("PRAGMA cipher_version").Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && version == "" {
+		return ErrEncryptionUnsupported
+	}
+	return err
+}
+
+// ExportSQLite copies the database to a new SQLite file at path, encrypted
+// with key, or in plain text when key is empty. It encrypts plain text
+// databases, rotates keys and decrypts, all with SQLCipher's
+// sqlcipher_export, so it requires SQLCipher too.
+func (db *DB) ExportSQLite(ctx context.Context, path string, key []byte) error {
+	if db.dialect.name() != "sqlite" {
+		return fmt.Errorf("export is only supported for SQLite databases")
+	}
+	if len(key) != 0 && len(key) != EncryptionKeySize {
+		return fmt.Errorf("encryption key must be %d bytes, got %d", EncryptionKeySize, len(key))
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if err := checkCipher(db.conn.DB); err != nil {
+		return err
+	}
+
+	// ATTACH applies to one connection, so hold one for the whole export
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var keyArg string
+	if len(key) > 0 {
+		keyArg = fmt.Sprintf("x'%X'", key)
+	}
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS export KEY ?", path, keyArg); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "SELECT sqlcipher_export('export')")
+	if _, detachErr := conn.ExecContext(ctx, "DETACH DATABASE export"); err == nil {
+		err = detachErr
+	}
+	return err
+}
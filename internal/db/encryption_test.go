@@ -0,0 +1,59 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "logs.db")
+	key := bytes.Repeat([]byte{0x42}, EncryptionKeySize)
+
+	if _, err := NewWithOptions(dbPath, Options{EncryptionKey: key[:16]}); err == nil {
+		t.Fatal("expected an error for a short key")
+	}
+
+	db, err := NewWithOptions(dbPath, Options{EncryptionKey: key})
+	if errors.Is(err, ErrEncryptionUnsupported) {
+		t.Skip("SQLite is not SQLCipher in this build")
+	}
+	if err != nil {
+		t.Fatalf("failed to open encrypted database: %v", err)
+	}
+	if !db.Encrypted() {
+		t.Error("expected Encrypted() to be true")
+	}
+	log := sampleLog("api", "INFO", "secret")
+	if err := db.InsertLog(context.Background(), &log); err != nil {
+		t.Fatalf("failed to insert log: %v", err)
+	}
+
+	plainPath := filepath.Join(t.TempDir(), "plain.db")
+	if err := db.ExportSQLite(context.Background(), plainPath, nil); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	db.Close()
+
+	if _, err := NewWithOptions(dbPath, Options{}); err == nil {
+		t.Error("expected opening the encrypted database without a key to fail")
+	}
+	plain, err := NewWithOptions(plainPath, Options{})
+	if err != nil {
+		t.Fatalf("failed to open exported database: %v", err)
+	}
+	defer plain.Close()
+	if plain.Encrypted() {
+		t.Error("expected the export to be plain text")
+	}
+}
+
+func TestExportSQLite_Unsupported(t *testing.T) {
+	db := newTestDB(t)
+	err := db.ExportSQLite(context.Background(), filepath.Join(t.TempDir(), "out.db"), nil)
+	if err != nil && !errors.Is(err, ErrEncryptionUnsupported) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+
+	"locog/internal/models"
+)
+
+// VersionKeys are the metadata keys holding the release a log came from,
+// checked in order: OTLP's service.version, Sentry's release, then common
+// application fields.
+var VersionKeys = []string{"service.version", "release", "version", "build"}
+
+// TemplateID returns a short, stable hash of a message template, so an error
+// keeps its identity across deploys and restarts.
+func TemplateID(template string) string {
+	h := fnv.New64a()
+	h.Write([]byte(template))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// TopErrors groups the logs matching filter by message template, most
+// frequent first, and breaks each template down by version (see VersionKeys).
+// Only the maxMessages most frequent distinct message and version pairs are
+// considered, which bounds the work on very large windows.
+func (db *DB) TopErrors(ctx context.Context, filter models.LogFilter, maxMessages int) ([]models.ErrorGroup, error) {
+	versions := make([]string, len(VersionKeys))
+	var args []interface{}
+	for i, key := range VersionKeys {
+		var keyArgs []interface{}
+		versions[i], keyArgs = db.metaValue(key)
+		args = append(args, keyArgs...)
+	}
+
+	where, whereArgs := db.buildWhere(filter)
+	query := `SELECT message, CAST(COALESCE(` + strings.Join(versions, ", ") + `, '') AS TEXT) AS version, COUNT(*) AS n
+		FROM logs` + where + ` GROUP BY message, version ORDER BY n DESC LIMIT ?`
+	args = append(append(args, whereArgs...), maxMessages)
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []messageCount
+	for rows.Next() {
+		var c messageCount
+		if err := rows.Scan(&c.message, &c.version, &c.count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return groupErrors(counts), nil
+}
+
+// groupErrors adds up counts, most frequent first, by message template and
+// version.
+func groupErrors(counts []messageCount) []models.ErrorGroup {
+	byTemplate := make(map[string]*models.ErrorGroup)
+	versionCounts := make(map[string]map[string]int64)
+	for _, c := range counts {
+		tmpl := MessageTemplate(c.message)
+		g, ok := byTemplate[tmpl]
+		if !ok {
+			// Counts are most frequent first, so the example is the commonest message
+			g = &models.ErrorGroup{ID: TemplateID(tmpl), Template: tmpl, Example: c.message}
+			byTemplate[tmpl] = g
+			versionCounts[tmpl] = make(map[string]int64)
+		}
+		g.Count += c.count
+		if c.version != "" {
+			versionCounts[tmpl][c.version] += c.count
+		}
+	}
+
+	groups := make([]models.ErrorGroup, 0, len(byTemplate))
+	for tmpl, g := range byTemplate {
+		for version, count := range versionCounts[tmpl] {
+			g.Versions = append(g.Versions, models.VersionCount{Version: version, Count: count})
+		}
+		slices.SortFunc(g.Versions, func(a, b models.VersionCount) int {
+			return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Version, b.Version))
+		})
+		groups = append(groups, *g)
+	}
+	slices.SortFunc(groups, func(a, b models.ErrorGroup) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Template, b.Template))
+	})
+	return groups
+}
@@ -0,0 +1,225 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"slices"
+	"strings"
+
+	"locog/internal/models"
+)
+
+// queryPlan summarizes how the database would run a query on logs.
+type queryPlan struct {
+	steps    []string // one line per plan node
+	indexes  []string // indexes used, in plan order
+	fullScan bool     // every log is read
+	// conditions are the index conditions, naming the columns that narrow
+	// the scan
+	conditions string
+}
+
+// EstimateLogs estimates how many logs match filter and how many the query
+// reads to find them, without running it. Row counts come from log IDs, which
+// follow ingest order, and the value counts kept in filter_values; the scan
+// comes from the query plan. Filters without statistics are listed in
+// Unestimated and ignored, making EstimatedRows an upper bound.
+func (db *DB) EstimateLogs(ctx context.Context, filter models.LogFilter) (models.QueryEstimate, error) {
+	var est models.QueryEstimate
+
+	where, args := db.buildWhere(filter)
+	rows, err := db.conn.QueryContext(ctx, db.dialect.explain()+"SELECT id FROM logs"+where+" ORDER BY timestamp DESC, id ASC", args...)
+	if err != nil {
+		return est, err
+	}
+	plan, err := db.dialect.parsePlan(rows)
+	rows.Close()
+	if err != nil {
+		return est, err
+	}
+	est.Plan, est.Indexes, est.FullScan = plan.steps, plan.indexes, plan.fullScan
+
+	var minID, maxID int64
+	err = db.conn.QueryRowContext(ctx, "SELECT COALESCE(MIN(id), 0), COALESCE(MAX(id), 0) FROM logs").Scan(&minID, &maxID)
+	if err != nil || maxID == 0 {
+		return est, err
+	}
+	est.TotalRows = maxID - minID + 1
+
+	// Logs in the time range span the IDs between the first and last log in it
+	first, last := minID, maxID
+	if filter.StartTime != nil {
+		if first, err = db.boundaryID(ctx, "timestamp >= ? ORDER BY timestamp ASC", filter.StartTime); err != nil {
+			return est, err
+		}
+	}
+	if filter.EndTime != nil {
+		if last, err = db.boundaryID(ctx, "timestamp <= ? ORDER BY timestamp DESC", filter.EndTime); err != nil {
+			return est, err
+		}
+	}
+	var window int64
+	if first > 0 && last > 0 {
+		first = max(first, filter.SinceID+1)
+		window = max(0, last-first+1)
+	}
+
+	// Shares of the logs with each filtered value, from filter_values
+	shares := map[string]float64{"service": 1, "level": 1, "host": 1}
+	if filter.Service != "" || len(filter.ExcludeServices) > 0 || len(filter.ServicePatterns) > 0 {
+		shares["service"], err = db.valueShare(ctx, "service", func(v string) bool {
+			return (filter.Service == "" || v == filter.Service) && !slices.Contains(filter.ExcludeServices, v) &&
+				(len(filter.ServicePatterns) == 0 || slices.ContainsFunc(filter.ServicePatterns, func(p string) bool { return GlobMatch(p, v) }))
+		})
+		if err != nil {
+			return est, err
+		}
+	}
+	if filter.Level != "" || len(filter.Levels) > 0 || len(filter.ExcludeLevels) > 0 {
+		shares["level"], err = db.valueShare(ctx, "level", func(v string) bool {
+			upper := strings.ToUpper(v)
+			return (filter.Level == "" || v == filter.Level) &&
+				(len(filter.Levels) == 0 || containsFold(filter.Levels, upper)) &&
+				!containsFold(filter.ExcludeLevels, upper)
+		})
+		if err != nil {
+			return est, err
+		}
+	}
+	if filter.Host != "" || len(filter.ExcludeHosts) > 0 || len(filter.HostPatterns) > 0 {
+		shares["host"], err = db.valueShare(ctx, "host", func(v string) bool {
+			return (filter.Host == "" || v == filter.Host) && !slices.Contains(filter.ExcludeHosts, v) &&
+				(len(filter.HostPatterns) == 0 || slices.ContainsFunc(filter.HostPatterns, func(p string) bool { return GlobMatch(p, v) }))
+		})
+		if err != nil {
+			return est, err
+		}
+	}
+
+	matched := float64(window) * shares["service"] * shares["level"] * shares["host"]
+	if filter.Tag != "" {
+		var tagged int64
+		if err := db.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM log_tags WHERE tag = ?", filter.Tag).Scan(&tagged); err != nil {
+			return est, err
+		}
+		matched = min(matched, float64(tagged))
+	}
+	est.EstimatedRows = int64(matched + 0.5)
+
+	// A full scan reads everything; otherwise the index conditions narrow
+	// the rows read in the same way as the filters on those columns
+	scanned := float64(est.TotalRows)
+	if !plan.fullScan {
+		if planMentions(plan.conditions, "timestamp") || planMentions(plan.conditions, "id") || planMentions(plan.conditions, "rowid") {
+			scanned = float64(window)
+		}
+		for column, share := range shares {
+			if planMentions(plan.conditions, column) {
+				scanned *= share
+			}
+		}
+	}
+	est.ScannedRows = int64(max(scanned, matched) + 0.5)
+
+	if filter.Search != "" {
+		est.Unestimated = append(est.Unestimated, "search")
+	}
+	if len(filter.ExcludeSearch) > 0 {
+		est.Unestimated = append(est.Unestimated, "exclude")
+	}
+	if len(filter.Meta) > 0 {
+		est.Unestimated = append(est.Unestimated, "meta")
+	}
+	if filter.Stream != "" {
+		est.Unestimated = append(est.Unestimated, "stream")
+	}
+	return est, nil
+}
+
+// boundaryID returns the ID of the first log by the given condition and
+// order, or 0 when none matches.
+func (db *DB) boundaryID(ctx context.Context, condition string, arg interface{}) (int64, error) {
+	var id int64
+	err := db.conn.QueryRowContext(ctx, "SELECT id FROM logs WHERE "+condition+" LIMIT 1", arg).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// valueShare returns the fraction of logs whose kind (service, level or host)
+// matches, or 1 when filter_values has no counts yet.
+func (db *DB) valueShare(ctx context.Context, kind string, match func(string) bool) (float64, error) {
+	rows, err := db.conn.QueryContext(ctx, "SELECT value, count FROM filter_values WHERE kind = ?", kind)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var matched, total int64
+	for rows.Next() {
+		var value string
+		var count int64
+		if err := rows.Scan(&value, &count); err != nil {
+			return 0, err
+		}
+		total += count
+		if match(value) {
+			matched += count
+		}
+	}
+	if err := rows.Err(); err != nil || total == 0 {
+		return 1, err
+	}
+	return float64(matched) / float64(total), nil
+}
+
+func containsFold(values []string, upper string) bool {
+	for _, v := range values {
+		if strings.ToUpper(v) == upper {
+			return true
+		}
+	}
+	return false
+}
+
+// planMentions reports whether plan conditions reference column.
+func planMentions(conditions, column string) bool {
+	return regexp.MustCompile(`\b` + column + `\b`).MatchString(conditions)
+}
+
+// ValidGlob reports whether pattern is a glob every store matches alike:
+// not empty, with * and ? its only wildcards. SQLite's GLOB would read [ and
+// ] as a character class, which PostgreSQL's LIKE and the ring lack.
+func ValidGlob(pattern string) bool {
+	return pattern != "" && !strings.ContainsAny(pattern, "[]")
+}
+
+// GlobMatch reports whether s matches a glob of * and ? as the stores'
+// pattern filters do, SQLite's GLOB among them: * also matches '/', and
+// everything else, % and _ included, matches itself.
+func GlobMatch(pattern, s string) bool {
+	p, t := []rune(pattern), []rune(s)
+	pi, ti := 0, 0
+	star, mark := -1, 0 // the last * seen and where in t it resumes
+	for ti < len(t) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == t[ti]):
+			pi++
+			ti++
+		case pi < len(p) && p[pi] == '*':
+			star, mark = pi, ti
+			pi++
+		case star >= 0:
+			mark++
+			pi, ti = star+1, mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
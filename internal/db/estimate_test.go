@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestEstimateLogs(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	// 100 logs a minute apart; every fifth is from web, the rest from api
+	base := time.Now().Add(-100 * time.Minute).Truncate(time.Minute)
+	var logs []models.Log
+	for i := range 100 {
+		service := "api"
+		if i%5 == 0 {
+			service = "web"
+		}
+		logs = append(logs, models.Log{Timestamp: base.Add(time.Duration(i) * time.Minute), Service: service, Level: "INFO", Message: "m", Host: "h"})
+	}
+	if err := db.InsertBatch(ctx, logs); err != nil {
+		t.Fatalf("InsertBatch failed: %v", err)
+	}
+
+	est, err := db.EstimateLogs(ctx, models.LogFilter{})
+	if err != nil {
+		t.Fatalf("EstimateLogs failed: %v", err)
+	}
+	if est.TotalRows != 100 || est.EstimatedRows != 100 || est.ScannedRows != 100 {
+		t.Errorf("unexpected estimate without filters: %+v", est)
+	}
+
+	start := base.Add(50 * time.Minute)
+	est, err = db.EstimateLogs(ctx, models.LogFilter{Service: "web", StartTime: &start})
+	if err != nil {
+		t.Fatalf("EstimateLogs failed: %v", err)
+	}
+	if est.EstimatedRows != 10 || est.FullScan || len(est.Indexes) == 0 {
+		t.Errorf("expected ~10 rows through an index, got %+v", est)
+	}
+	if est.ScannedRows > 50 {
+		t.Errorf("expected the index to narrow the scan, got %d rows", est.ScannedRows)
+	}
+
+	est, err = db.EstimateLogs(ctx, models.LogFilter{Search: "m"})
+	if err != nil {
+		t.Fatalf("EstimateLogs failed: %v", err)
+	}
+	if !est.FullScan || est.ScannedRows != 100 || len(est.Unestimated) != 1 || est.Unestimated[0] != "search" {
+		t.Errorf("expected an unestimated full scan, got %+v", est)
+	}
+
+	future := time.Now().Add(time.Hour)
+	est, _ = db.EstimateLogs(ctx, models.LogFilter{StartTime: &future})
+	if est.EstimatedRows != 0 {
+		t.Errorf("expected no rows after the newest log, got %+v", est)
+	}
+}
+
+// globCases are the patterns every store's pattern filters must match
+// alike, with the services they match or don't.
+var globCases = []struct {
+	pattern, s string
+	want       bool
+}{
+	{"web-*", "web-01", true},
+	{"web-*", "web-", true},
+	{"web-*", "db-01", false},
+	{"web-??", "web-01", true},
+	{"web-??", "web-1", false},
+	{"*-01", "web-01", true},
+	{"w*b*1", "web-01", true},
+	{"w*b*2", "web-01", false},
+	{"*", "a/b/c", true},
+	{"a/*", "a/b/c", true},
+	{"web-01", "web-01", true},
+	{"web-01", "web-010", false},
+	{"Web-*", "web-01", false},
+	{"100%*", "100%-off", true},
+	{"100%*", "1000", false},
+	{"a_b*", "a_b-1", true},
+	{"a_b*", "axb-1", false},
+	{"a?b", "a_b", true},
+	{"a?b", "a/b", true},
+	{`a\*`, `a\b`, true},
+	{`a\*`, "a*", false},
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, tc := range globCases {
+		if got := GlobMatch(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("GlobMatch(%q, %q) = %v, want %v", tc.pattern, tc.s, got, tc.want)
+		}
+	}
+	if !GlobMatch("*", "") {
+		t.Error("expected * to match the empty string")
+	}
+}
+
+func TestValidGlob(t *testing.T) {
+	for _, tc := range globCases {
+		if !ValidGlob(tc.pattern) {
+			t.Errorf("expected %q to be valid", tc.pattern)
+		}
+	}
+	for _, p := range []string{"", "web-[0-9]", "a]"} {
+		if ValidGlob(p) {
+			t.Errorf("expected %q to be invalid", p)
+		}
+	}
+}
+
+// testGlobPatterns tests that the store's ServicePatterns filter matches
+// globCases as GlobMatch does.
+func testGlobPatterns(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+	var services []string
+	for _, tc := range globCases {
+		if !slices.Contains(services, tc.s) {
+			services = append(services, tc.s)
+			if err := s.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: tc.s, Level: "info", Message: "m", Host: "h"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, tc := range globCases {
+		logs, err := s.QueryLogs(ctx, models.LogFilter{ServicePatterns: []string{tc.pattern}})
+		if err != nil {
+			t.Fatalf("%q: %v", tc.pattern, err)
+		}
+		matched := slices.ContainsFunc(logs, func(l models.Log) bool { return l.Service == tc.s })
+		if matched != tc.want {
+			t.Errorf("%s: %q against %q matched %v, want %v", s.Backend(), tc.pattern, tc.s, matched, tc.want)
+		}
+		for _, l := range logs {
+			if !GlobMatch(tc.pattern, l.Service) {
+				t.Errorf("%s: %q matched %q, which GlobMatch doesn't", s.Backend(), tc.pattern, l.Service)
+			}
+		}
+	}
+}
+
+func TestGlobPatterns(t *testing.T) {
+	testGlobPatterns(t, newTestDB(t))
+	testGlobPatterns(t, newTestRing(t, 100))
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// HostGroups returns the host groups stored via the API, keyed by name.
+func (db *DB) HostGroups(ctx context.Context) (map[string][]string, error) {
+	rows, err := db.conn.QueryContext(ctx, "SELECT name, hosts FROM host_groups")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make(map[string][]string)
+	for rows.Next() {
+		var name, hosts string
+		if err := rows.Scan(&name, &hosts); err != nil {
+			return nil, err
+		}
+		var list []string
+		if err := json.Unmarshal([]byte(hosts), &list); err != nil {
+			return nil, err
+		}
+		groups[name] = list
+	}
+	return groups, rows.Err()
+}
+
+// SetHostGroup stores a host group, replacing any previous members.
+func (db *DB) SetHostGroup(ctx context.Context, name string, hosts []string) error {
+	data, err := json.Marshal(hosts)
+	if err != nil {
+		return err
+	}
+	_, err = db.conn.ExecContext(ctx,
+		`INSERT INTO host_groups (name, hosts, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET hosts = excluded.hosts, updated_at = excluded.updated_at`,
+		name, string(data), time.Now().UTC())
+	return err
+}
+
+// DeleteHostGroup removes a stored host group and reports whether it existed.
+func (db *DB) DeleteHostGroup(ctx context.Context, name string) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, "DELETE FROM host_groups WHERE name = ?", name)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
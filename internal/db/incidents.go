@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+)
+
+// StartIncident stores an incident and sets its ID.
+func (db *DB) StartIncident(ctx context.Context, inc *models.Incident) error {
+	services, err := json.Marshal(inc.Services)
+	if err != nil {
+		return err
+	}
+	return db.conn.QueryRowContext(ctx,
+		"INSERT INTO incidents (name, services, started_at, ends_at, keep_until) VALUES (?, ?, ?, ?, ?) RETURNING id",
+		inc.Name, string(services), inc.StartedAt.UTC(), inc.EndsAt.UTC(), inc.KeepUntil.UTC()).Scan(&inc.ID)
+}
+
+// EndIncident ends an incident at now if it is still active, moving its
+// KeepUntil forward by as much as its end, and returns it. ok is false when
+// there is no incident with the ID.
+func (db *DB) EndIncident(ctx context.Context, id int64, now time.Time) (inc models.Incident, ok bool, err error) {
+	incidents, err := db.queryIncidents(ctx, "WHERE id = ?", id)
+	if err != nil || len(incidents) == 0 {
+		return inc, false, err
+	}
+	inc = incidents[0]
+	if !inc.EndsAt.After(now) {
+		return inc, true, nil
+	}
+	inc.KeepUntil = now.Add(inc.KeepUntil.Sub(inc.EndsAt))
+	inc.EndsAt = now
+	_, err = db.conn.ExecContext(ctx, "UPDATE incidents SET ends_at = ?, keep_until = ? WHERE id = ?",
+		inc.EndsAt.UTC(), inc.KeepUntil.UTC(), id)
+	return inc, true, err
+}
+
+// Incidents returns the incidents still keeping logs at now, newest first.
+func (db *DB) Incidents(ctx context.Context, now time.Time) ([]models.Incident, error) {
+	return db.queryIncidents(ctx, "WHERE keep_until > ? ORDER BY started_at DESC, id DESC", now.UTC())
+}
+
+func (db *DB) queryIncidents(ctx context.Context, where string, args ...interface{}) ([]models.Incident, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT id, name, services, started_at, ends_at, keep_until FROM incidents "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	incidents := []models.Incident{}
+	for rows.Next() {
+		var inc models.Incident
+		var services string
+		if err := rows.Scan(&inc.ID, &inc.Name, &services, &inc.StartedAt, &inc.EndsAt, &inc.KeepUntil); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(services), &inc.Services); err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, inc)
+	}
+	return incidents, rows.Err()
+}
+
+// incidentExceptions returns the SQL matching logs kept for the incidents:
+// those of their services sent during their window.
+func incidentExceptions(incidents []models.Incident) (string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+	for _, inc := range incidents {
+		if len(inc.Services) == 0 {
+			continue
+		}
+		clauses = append(clauses, "(logs.service IN (?"+strings.Repeat(", ?", len(inc.Services)-1)+
+			") AND logs.timestamp >= ? AND logs.timestamp < ?)")
+		for _, service := range inc.Services {
+			args = append(args, service)
+		}
+		args = append(args, inc.StartedAt, inc.EndsAt)
+	}
+	if len(clauses) == 0 {
+		return "", nil
+	}
+	return "(" + strings.Join(clauses, " OR ") + ")", args
+}
+
+// deleteExpiredIncidents forgets incidents no longer keeping any logs.
+func (db *DB) deleteExpiredIncidents(ctx context.Context, now time.Time) error {
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM incidents WHERE keep_until <= ?", now.UTC())
+	return err
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestIncidents(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+	day := 24 * time.Hour
+
+	insert := func(age time.Duration, service string) {
+		db.InsertLog(ctx, &models.Log{Timestamp: now.Add(-age), Service: service, Level: "info", Message: "m", Host: "h"})
+	}
+	insert(41*day, "api")      // before the incident
+	insert(40*day, "api")      // during it
+	insert(40*day, "checkout") // during it, another service
+	insert(39*day, "api")      // after it
+
+	inc := models.Incident{
+		Name:      "outage",
+		Services:  []string{"api"},
+		StartedAt: now.Add(-40*day - time.Hour),
+		EndsAt:    now.Add(-40*day + time.Hour),
+		KeepUntil: now.Add(50 * day),
+	}
+	if err := db.StartIncident(ctx, &inc); err != nil {
+		t.Fatalf("StartIncident failed: %v", err)
+	}
+	if inc.ID == 0 {
+		t.Error("expected the incident's ID to be set")
+	}
+
+	preview, err := db.RetentionPreview(ctx, 30*day, nil)
+	if err != nil {
+		t.Fatalf("RetentionPreview failed: %v", err)
+	}
+	if preview.Total != 3 || preview.KeptByIncidents != 1 {
+		t.Errorf("expected 3 deleted and 1 kept by the incident, got %+v", preview)
+	}
+
+	deleted, err := db.DeleteOldLogs(ctx, 30*day)
+	if err != nil {
+		t.Fatalf("DeleteOldLogs failed: %v", err)
+	}
+	logs, _ := db.QueryLogs(ctx, models.LogFilter{})
+	if deleted != 3 || len(logs) != 1 || logs[0].Service != "api" {
+		t.Errorf("expected only the api log from the incident to remain, deleted %d, got %+v", deleted, logs)
+	}
+
+	// Ending an active incident keeps its logs as long after the new end
+	active := models.Incident{Name: "now", Services: []string{"api"}, StartedAt: now.Add(-time.Hour),
+		EndsAt: now.Add(time.Hour), KeepUntil: now.Add(time.Hour + day)}
+	db.StartIncident(ctx, &active)
+	ended, ok, err := db.EndIncident(ctx, active.ID, now)
+	if err != nil || !ok {
+		t.Fatalf("EndIncident failed: %v, %v", ok, err)
+	}
+	if !ended.EndsAt.Equal(now) || !ended.KeepUntil.Equal(now.Add(day)) {
+		t.Errorf("expected the incident to end now and keep logs a day, got %+v", ended)
+	}
+	if _, ok, _ := db.EndIncident(ctx, 999, now); ok {
+		t.Error("expected no incident with an unknown ID")
+	}
+
+	incidents, err := db.Incidents(ctx, now)
+	if err != nil || len(incidents) != 2 || incidents[0].Name != "now" {
+		t.Fatalf("expected both incidents, newest first, got %+v, %v", incidents, err)
+	}
+	if !incidents[0].EndsAt.Equal(now) || len(incidents[1].Services) != 1 {
+		t.Errorf("unexpected stored incidents %+v", incidents)
+	}
+
+	// Incidents are forgotten once they keep nothing
+	db.DeleteOldLogs(ctx, 30*day)
+	if incidents, _ := db.Incidents(ctx, now.Add(2*day)); len(incidents) != 1 {
+		t.Errorf("expected only the long incident after a day, got %+v", incidents)
+	}
+	db.conn.ExecContext(ctx, "UPDATE incidents SET keep_until = ?", now.Add(-time.Minute).UTC())
+	db.DeleteOldLogs(ctx, 30*day)
+	var n int
+	db.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM incidents").Scan(&n)
+	if n != 0 {
+		t.Errorf("expected expired incidents deleted, %d remain", n)
+	}
+}
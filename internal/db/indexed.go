@@ -0,0 +1,80 @@
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// indexedKeyPattern restricts indexed metadata keys to dotted identifiers, so
+// each maps to a plain column name.
+var indexedKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_\-]+(\.[A-Za-z0-9_\-]+)*$`)
+
+// maxIdentifierLength is PostgreSQL's limit on column and index names.
+const maxIdentifierLength = 63
+
+// ValidateIndexedKeys checks metadata keys to index (see
+// Options.IndexedMetadataKeys) without opening a database.
+func ValidateIndexedKeys(keys []string) error {
+	_, err := indexedColumns(keys)
+	return err
+}
+
+// indexedColumns maps each indexed metadata key to its generated column, e.g.
+// http.request_id -> meta_http_request_id.
+func indexedColumns(keys []string) (map[string]string, error) {
+	columns := make(map[string]string, len(keys))
+	keyOf := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if !indexedKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid indexed metadata key %q", key)
+		}
+		column := "meta_" + strings.ToLower(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+		if len("idx_"+column) > maxIdentifierLength {
+			return nil, fmt.Errorf("indexed metadata key %q is too long", key)
+		}
+		if other, ok := keyOf[column]; ok {
+			return nil, fmt.Errorf("indexed metadata keys %q and %q both map to column %s", other, key, column)
+		}
+		keyOf[column] = key
+		columns[key] = column
+	}
+	return columns, nil
+}
+
+// indexMetadataKeys adds a generated column and index for each key that
+// doesn't have one yet. Columns of keys no longer configured are left in
+// place; they cost an index update per insert until dropped by hand.
+func indexMetadataKeys(conn *dbConn, columns map[string]string) error {
+	for key, column := range columns {
+		var exists bool
+		if err := conn.QueryRow(conn.dialect.columnExists(), "logs", column).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			slog.Info("adding indexed metadata column", "key", key, "column", column)
+			if _, err := conn.Exec("ALTER TABLE logs ADD COLUMN " + column + " " + conn.dialect.generatedColumn(key)); err != nil {
+				return fmt.Errorf("add column for metadata key %s: %w", key, err)
+			}
+		}
+		if _, err := conn.Exec("CREATE INDEX IF NOT EXISTS idx_" + column + " ON logs(" + column + ", timestamp DESC)"); err != nil {
+			return fmt.Errorf("index metadata key %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// metaValue is the scalar metadata value at key and its arguments, read from
+// the key's generated column when it has one.
+func (db *DB) metaValue(key string) (string, []interface{}) {
+	if column, ok := db.indexed[key]; ok {
+		return column, nil
+	}
+	return db.dialect.metaValue(), []interface{}{db.dialect.metaPath(key)}
+}
+
+// sqlString quotes s as an SQL string literal.
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
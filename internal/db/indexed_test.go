@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestIndexedColumns(t *testing.T) {
+	columns, err := indexedColumns([]string{"request_id", "http.Trace-ID"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns["request_id"] != "meta_request_id" || columns["http.Trace-ID"] != "meta_http_trace_id" {
+		t.Errorf("unexpected columns: %v", columns)
+	}
+
+	for _, keys := range [][]string{
+		{"user id"},
+		{"a.b", "a_b"},
+		{strings.Repeat("k", 60)},
+	} {
+		if _, err := indexedColumns(keys); err == nil {
+			t.Errorf("expected %q to be rejected", keys)
+		}
+	}
+}
+
+func TestIndexedMetadataKeys(t *testing.T) {
+	path := t.TempDir() + "/logs.db"
+	opts := Options{IndexedMetadataKeys: []string{"request_id", "http.trace_id"}}
+	db, err := NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %v", err)
+	}
+	ctx := context.Background()
+	db.InsertBatch(ctx, []models.Log{
+		{Timestamp: time.Now(), Service: "api", Level: "INFO", Message: "a", Host: "h",
+			Metadata: map[string]interface{}{"request_id": "r-1", "http": map[string]interface{}{"trace_id": "t-1"}}},
+		{Timestamp: time.Now(), Service: "api", Level: "INFO", Message: "b", Host: "h",
+			Metadata: map[string]interface{}{"request_id": "r-2"}},
+	})
+	db.Close()
+
+	// Reopening finds the columns already in place
+	db, err = NewWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		meta models.MetaFilter
+		want int
+	}{
+		{models.MetaFilter{Key: "request_id", Op: "=", Value: "r-1"}, 1},
+		{models.MetaFilter{Key: "http.trace_id", Op: "=", Value: "t-1"}, 1},
+		{models.MetaFilter{Key: "http.trace_id", Op: "!=", Value: "t-1"}, 1},
+	} {
+		logs, err := db.QueryLogs(ctx, models.LogFilter{Meta: []models.MetaFilter{tc.meta}})
+		if err != nil {
+			t.Fatalf("QueryLogs failed: %v", err)
+		}
+		if len(logs) != tc.want {
+			t.Errorf("%+v: expected %d logs, got %d", tc.meta, tc.want, len(logs))
+		}
+	}
+
+	where, args := db.buildWhere(models.LogFilter{Meta: []models.MetaFilter{{Key: "request_id", Op: "=", Value: "r-1"}}})
+	rows, err := db.conn.Query("EXPLAIN QUERY PLAN SELECT id FROM logs"+where, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var id, parent, notused int
+		var detail string
+		rows.Scan(&id, &parent, &notused, &detail)
+		plan = append(plan, detail)
+	}
+	if !strings.Contains(strings.Join(plan, "\n"), "idx_meta_request_id") {
+		t.Errorf("expected the filter to use idx_meta_request_id, got plan %q", plan)
+	}
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"locog/internal/models"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "logs.db")
+	db, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		log := sampleLog("api", "INFO", "a message long enough to fill a few pages of the database file")
+		db.InsertLog(context.Background(), &log)
+	}
+	for _, full := range []bool{false, true} {
+		if err := db.CheckIntegrity(context.Background(), full); err != nil {
+			t.Errorf("expected a healthy database to pass (full=%v): %v", full, err)
+		}
+	}
+	db.conn.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	db.Close()
+
+	// Overwrite everything after the header page
+	data, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 4096; i < len(data); i++ {
+		data[i] = 0xff
+	}
+	if err := os.WriteFile(dbPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = New(dbPath)
+	if err == nil {
+		defer db.Close()
+		err = db.CheckIntegrity(context.Background(), false)
+	}
+	if err == nil {
+		t.Error("expected a corrupted database to fail to open or verify")
+	}
+}
+
+func TestReadOnlySnapshot(t *testing.T) {
+	db := newTestDB(t)
+	log := sampleLog("api", "INFO", "before the snapshot")
+	db.InsertLog(context.Background(), &log)
+
+	snapshot := filepath.Join(t.TempDir(), "snapshot.db")
+	if _, err := db.conn.Exec("VACUUM INTO ?", snapshot); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+
+	ro, err := NewWithOptions(snapshot, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open snapshot: %v", err)
+	}
+	defer ro.Close()
+	if !ro.ReadOnly() {
+		t.Error("expected ReadOnly() to be true")
+	}
+
+	logs, err := ro.QueryLogs(context.Background(), models.LogFilter{})
+	if err != nil || len(logs) != 1 || logs[0].Message != "before the snapshot" {
+		t.Errorf("expected the snapshot's log, got %v, %v", logs, err)
+	}
+	if err := ro.InsertLog(context.Background(), &log); err == nil {
+		t.Error("expected inserting into a read-only snapshot to fail")
+	}
+
+	if _, err := NewWithOptions(filepath.Join(t.TempDir(), "missing.db"), Options{ReadOnly: true}); err == nil {
+		t.Error("expected opening a missing snapshot to fail")
+	}
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrInUse is returned when another process has the database open for
+// writing. Two writers would each trust caches (filter values, rollup state)
+// the other invalidates.
+var ErrInUse = errors.New("database is open for writing by another process")
+
+// writerLock is an exclusive lock on a sidecar SQLite file next to the
+// database, held by the process that has the database open for writing. It
+// is the operating system's file lock, so it goes away with the process
+// even if it crashes, and works wherever SQLite does.
+type writerLock struct {
+	db   *sql.DB
+	conn *sql.Conn
+}
+
+// lockWriter takes the writer lock of the database at dbPath, returning
+// ErrInUse without waiting if another process holds it.
+func lockWriter(dbPath string) (*writerLock, error) {
+	// In exclusive locking mode the lock taken by BEGIN EXCLUSIVE is kept
+	// after COMMIT, until the connection closes
+	lockDB, err := sql.Open(sqliteDriver, dbPath+".lock?_locking_mode=EXCLUSIVE&_busy_timeout=0")
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	conn, err := lockDB.Conn(ctx)
+	if err == nil {
+		if _, err = conn.ExecContext(ctx, "BEGIN EXCLUSIVE"); err == nil {
+			_, err = conn.ExecContext(ctx, "COMMIT")
+		}
+	}
+	if err != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		lockDB.Close()
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked) {
+			return nil, fmt.Errorf("%s: %w", dbPath, ErrInUse)
+		}
+		return nil, fmt.Errorf("lock %s: %w", dbPath, err)
+	}
+	return &writerLock{db: lockDB, conn: conn}, nil
+}
+
+// release gives up the lock. It is a no-op on a nil lock.
+func (l *writerLock) release() {
+	if l == nil {
+		return
+	}
+	l.conn.Close()
+	l.db.Close()
+}
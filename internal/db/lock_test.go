@@ -0,0 +1,34 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// TestNewWithOptions_InUse tests that a second writer is refused while a
+// reader may still open the database.
+func TestNewWithOptions_InUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.db")
+	first, err := New(path)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if _, err := New(path); !errors.Is(err, ErrInUse) {
+		t.Fatalf("expected ErrInUse, got %v", err)
+	}
+	reader, err := NewWithOptions(path, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("read-only open failed: %v", err)
+	}
+	reader.Close()
+
+	// Closing releases the lock
+	first.Close()
+	second, err := New(path)
+	if err != nil {
+		t.Fatalf("expected the lock to be released, got %v", err)
+	}
+	second.Close()
+}
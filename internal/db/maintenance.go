@@ -0,0 +1,185 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"locog/internal/models"
+)
+
+const (
+	// maintenanceMinDeleted and maintenanceShare set when maintenance is
+	// due: once this many logs, and this share of the logs there were, have
+	// been deleted since the last run. Indexes of a table with that much
+	// churn hold mostly emptied pages.
+	maintenanceMinDeleted = 100000
+	maintenanceShare      = 0.25
+)
+
+// ErrMaintenanceRunning is returned by Maintain while a run is under way.
+var ErrMaintenanceRunning = errors.New("maintenance is already running")
+
+// maintenanceTracker counts deleted logs and records maintenance runs.
+type maintenanceTracker struct {
+	// running is held for the whole of a run
+	running sync.Mutex
+
+	mu     sync.Mutex
+	status models.MaintenanceStatus
+}
+
+// deleted records that n logs were deleted.
+func (m *maintenanceTracker) deleted(n int64) {
+	m.mu.Lock()
+	m.status.DeletedSince += n
+	m.mu.Unlock()
+}
+
+// MaintenanceStatus returns the progress of the running maintenance, or the
+// outcome of the last one.
+func (db *DB) MaintenanceStatus() models.MaintenanceStatus {
+	return db.maintenance.snapshot()
+}
+
+// snapshot returns a copy of the status.
+func (m *maintenanceTracker) snapshot() models.MaintenanceStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.status
+	if st.State == "" {
+		st.State = "idle"
+	}
+	st.Steps = append([]models.MaintenanceStep{}, m.status.Steps...)
+	return st
+}
+
+// MaintenanceDue reports whether deletes since the last maintenance run,
+// or since startup, removed a large share of the logs.
+func (db *DB) MaintenanceDue(ctx context.Context) (bool, error) {
+	db.maintenance.mu.Lock()
+	deleted := db.maintenance.status.DeletedSince
+	db.maintenance.mu.Unlock()
+	if deleted < maintenanceMinDeleted {
+		return false, nil
+	}
+	var remaining int64
+	if err := db.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM logs").Scan(&remaining); err != nil {
+		return false, err
+	}
+	return float64(deleted) >= maintenanceShare*float64(deleted+remaining), nil
+}
+
+// Maintain rebuilds the indexes of logs one at a time, refreshes the
+// planner's statistics where the backend keeps them and reclaims free
+// space, recording its progress for MaintenanceStatus. On SQLite each step
+// holds the write lock, so ingestion waits for one index at a time. It
+// returns ErrMaintenanceRunning if a run is under way.
+func (db *DB) Maintain(ctx context.Context, reason string) error {
+	m := &db.maintenance
+	if !m.running.TryLock() {
+		return ErrMaintenanceRunning
+	}
+	defer m.running.Unlock()
+	ctx, span := db.startSpan(ctx, "Maintain")
+	defer span.End()
+
+	type step struct {
+		name  string
+		stmts []string
+	}
+	var steps []step
+	indexes, err := db.logIndexes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		steps = append(steps, step{"reindex " + index, []string{db.dialect.reindex(index)}})
+	}
+	if stmts := db.dialect.analyze(); len(stmts) > 0 {
+		steps = append(steps, step{"analyze", stmts})
+	}
+	reclaim := len(db.dialect.reclaimSpace()) > 0
+	total := len(steps)
+	if reclaim {
+		total++
+	}
+
+	start := time.Now()
+	started := start.UTC()
+	m.mu.Lock()
+	deletedBefore := m.status.DeletedSince
+	m.status = models.MaintenanceStatus{State: "running", Reason: reason, StepsTotal: total,
+		StartedAt: &started, Runs: m.status.Runs + 1, DeletedSince: deletedBefore}
+	m.mu.Unlock()
+	slog.Info("database maintenance started", "reason", reason, "steps", total, "deleted_since", deletedBefore)
+
+	run := func(name string, fn func() error) error {
+		m.mu.Lock()
+		m.status.Step = name
+		m.mu.Unlock()
+		stepStart := time.Now()
+		err := fn()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		m.status.StepsDone++
+		m.status.Steps = append(m.status.Steps, models.MaintenanceStep{Name: name, DurationMs: time.Since(stepStart).Milliseconds()})
+		return nil
+	}
+	for _, st := range steps {
+		if err = run(st.name, func() error {
+			for _, stmt := range st.stmts {
+				if _, err := db.conn.ExecContext(ctx, stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			break
+		}
+	}
+	if err == nil && reclaim {
+		err = run("reclaim space", func() error { return db.reclaimSpace(ctx) })
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	finished := time.Now().UTC()
+	m.status.Step = ""
+	m.status.FinishedAt = &finished
+	m.status.DurationMs = finished.Sub(start).Milliseconds()
+	if err != nil {
+		m.status.State, m.status.Error = "failed", err.Error()
+		span.RecordError(err)
+		return err
+	}
+	// Deletes during the run count toward the next one
+	m.status.State = "done"
+	m.status.DeletedSince -= deletedBefore
+	slog.Info("database maintenance completed", "duration_ms", m.status.DurationMs)
+	return nil
+}
+
+// logIndexes returns the names of the indexes of logs.
+func (db *DB) logIndexes(ctx context.Context) ([]string, error) {
+	rows, err := db.conn.QueryContext(ctx, db.dialect.indexes(), "logs")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestMaintain tests scheduling maintenance on churn and recording a run.
+func TestMaintain(t *testing.T) {
+	ctx := context.Background()
+	db, err := New(filepath.Join(t.TempDir(), "logs.db"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer db.Close()
+	for range 10 {
+		db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "m", Host: "h"})
+	}
+
+	if st := db.MaintenanceStatus(); st.State != "idle" || st.Runs != 0 {
+		t.Errorf("unexpected initial status %+v", st)
+	}
+	db.maintenance.deleted(maintenanceMinDeleted - 1)
+	if due, err := db.MaintenanceDue(ctx); err != nil || due {
+		t.Errorf("expected maintenance not due below the minimum, got %v, %v", due, err)
+	}
+	db.maintenance.deleted(1)
+	if due, err := db.MaintenanceDue(ctx); err != nil || !due {
+		t.Errorf("expected maintenance due, got %v, %v", due, err)
+	}
+
+	db.maintenance.running.Lock()
+	if err := db.Maintain(ctx, "test"); !errors.Is(err, ErrMaintenanceRunning) {
+		t.Errorf("expected ErrMaintenanceRunning, got %v", err)
+	}
+	db.maintenance.running.Unlock()
+
+	if err := db.Maintain(ctx, "test"); err != nil {
+		t.Fatalf("Maintain failed: %v", err)
+	}
+	st := db.MaintenanceStatus()
+	if st.State != "done" || st.Reason != "test" || st.Runs != 1 || st.DeletedSince != 0 || st.FinishedAt == nil {
+		t.Errorf("unexpected status %+v", st)
+	}
+	if st.StepsDone != st.StepsTotal || len(st.Steps) != st.StepsTotal {
+		t.Errorf("expected every step done, got %+v", st)
+	}
+	if !strings.HasPrefix(st.Steps[0].Name, "reindex idx_") || st.Steps[len(st.Steps)-1].Name != "reclaim space" {
+		t.Errorf("unexpected steps %+v", st.Steps)
+	}
+	if due, _ := db.MaintenanceDue(ctx); due {
+		t.Error("expected maintenance not due after a run")
+	}
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"locog/internal/models"
+)
+
+// maxMetadataExampleLen truncates example values stored in the report.
+const maxMetadataExampleLen = 100
+
+// RefreshMetadataKeys rebuilds the metadata keys report from logs newer than
+// since. Every leaf key is recorded with its dotted path; arrays are reported
+// as a whole rather than per element.
+func (db *DB) RefreshMetadataKeys(ctx context.Context, since time.Time) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM metadata_keys"); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, db.dialect.metadataKeys(),
+		since, maxMetadataExampleLen, maxMetadataExampleLen, time.Now(), since)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MetadataKeys returns the latest metadata keys report, optionally limited to
+// one service. GeneratedAt is nil until the report has been built.
+func (db *DB) MetadataKeys(ctx context.Context, service string) (models.MetadataKeysReport, error) {
+	query := `SELECT service, key, type, count, service_total, example_min, example_max, last_seen, generated_at
+		FROM metadata_keys`
+	var args []interface{}
+	if service != "" {
+		query += " WHERE service = ?"
+		args = append(args, service)
+	}
+	query += " ORDER BY service, key, type"
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return models.MetadataKeysReport{}, err
+	}
+	defer rows.Close()
+
+	var keys []metadataKeyRow
+	for rows.Next() {
+		var k metadataKeyRow
+		if err := rows.Scan(&k.service, &k.key, &k.kind, &k.count, &k.serviceTotal, &k.exampleMin, &k.exampleMax,
+			&k.lastSeen, &k.generatedAt); err != nil {
+			return models.MetadataKeysReport{}, err
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return models.MetadataKeysReport{}, err
+	}
+	return metadataKeysReport(keys), nil
+}
+
+// metadataKeyRow is one row of the report: the logs of a service carrying a
+// key with values of one type.
+type metadataKeyRow struct {
+	service, key, kind     string
+	count, serviceTotal    int64
+	exampleMin, exampleMax sql.NullString
+	lastSeen, generatedAt  time.Time
+}
+
+// metadataKeysReport merges the rows of each key, which must be ordered by
+// service, key and type, into the report.
+func metadataKeysReport(rows []metadataKeyRow) models.MetadataKeysReport {
+	report := models.MetadataKeysReport{Keys: []models.MetadataKeyStats{}}
+	var current *models.MetadataKeyStats
+	for _, row := range rows {
+		report.GeneratedAt = &row.generatedAt
+		// All types of a key are adjacent
+		if current == nil || current.Service != row.service || current.Key != row.key {
+			report.Keys = append(report.Keys, models.MetadataKeyStats{
+				Service:  row.service,
+				Key:      row.key,
+				Types:    make(map[string]int64),
+				Examples: []string{},
+			})
+			current = &report.Keys[len(report.Keys)-1]
+		}
+		current.Count += row.count
+		if row.serviceTotal > 0 {
+			current.Frequency = float64(current.Count) / float64(row.serviceTotal)
+		}
+		current.Types[row.kind] += row.count
+		if row.lastSeen.After(current.LastSeen) {
+			current.LastSeen = row.lastSeen
+		}
+		for _, ex := range []sql.NullString{row.exampleMin, row.exampleMax} {
+			if ex.Valid && !slices.Contains(current.Examples, ex.String) {
+				current.Examples = append(current.Examples, ex.String)
+			}
+		}
+	}
+	return report
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestMetadataKeys_Report(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "req", Host: "h",
+		Metadata: map[string]interface{}{"status": 200, "http": map[string]interface{}{"method": "GET"}, "tags": []string{"a", "b"}}})
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "req", Host: "h",
+		Metadata: map[string]interface{}{"status": "timeout"}})
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "req", Host: "h"})
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "worker", Level: "info", Message: "job", Host: "h",
+		Metadata: map[string]interface{}{"job_id": 7}})
+	// Outside the report window
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now().Add(-48 * time.Hour), Service: "worker", Level: "info", Message: "old", Host: "h",
+		Metadata: map[string]interface{}{"legacy": true}})
+
+	report, err := db.MetadataKeys(ctx, "")
+	if err != nil {
+		t.Fatalf("MetadataKeys failed: %v", err)
+	}
+	if report.GeneratedAt != nil || len(report.Keys) != 0 {
+		t.Fatalf("expected empty report before refresh, got %+v", report)
+	}
+
+	if err := db.RefreshMetadataKeys(ctx, time.Now().Add(-24*time.Hour)); err != nil {
+		t.Fatalf("RefreshMetadataKeys failed: %v", err)
+	}
+
+	report, err = db.MetadataKeys(ctx, "api")
+	if err != nil {
+		t.Fatalf("MetadataKeys failed: %v", err)
+	}
+	if report.GeneratedAt == nil {
+		t.Fatal("expected generated_at after refresh")
+	}
+
+	keys := make(map[string]models.MetadataKeyStats)
+	for _, k := range report.Keys {
+		keys[k.Key] = k
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected keys http.method, status and tags, got %+v", report.Keys)
+	}
+
+	status := keys["status"]
+	if status.Count != 2 || status.Types["number"] != 1 || status.Types["string"] != 1 {
+		t.Errorf("unexpected status stats: %+v", status)
+	}
+	if want := 2.0 / 3.0; status.Frequency != want {
+		t.Errorf("expected status frequency %v, got %v", want, status.Frequency)
+	}
+	if len(status.Examples) != 2 {
+		t.Errorf("expected 2 status examples, got %v", status.Examples)
+	}
+	if keys["http.method"].Types["string"] != 1 {
+		t.Errorf("expected nested key reported with dotted path, got %+v", keys["http.method"])
+	}
+	if tags := keys["tags"]; tags.Types["array"] != 1 || len(tags.Examples) != 1 || tags.Examples[0] != `["a","b"]` {
+		t.Errorf("expected tags reported as an array, got %+v", tags)
+	}
+
+	report, _ = db.MetadataKeys(ctx, "worker")
+	if len(report.Keys) != 1 || report.Keys[0].Key != "job_id" {
+		t.Errorf("expected only job_id for worker within the window, got %+v", report.Keys)
+	}
+}
@@ -0,0 +1,255 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"locog/internal/models"
+)
+
+// migrationChunkSize is the number of log IDs processed per backfill step.
+const migrationChunkSize = 50000
+
+// migrationWork is the resumable part of a data migration. It reports progress
+// as it goes so long migrations are visible in the log and via MigrationStatus.
+type migrationWork func(ctx context.Context, report func(processed, total int64)) error
+
+// dataMigration is a one-off backfill run at startup. prepare always runs
+// synchronously, before the service accepts writes, and returns the work that
+// may then run in the background.
+type dataMigration struct {
+	name    string
+	prepare func(ctx context.Context, db *DB) (migrationWork, error)
+}
+
+const filterValuesMigration = "filter_values_backfill"
+
+var dataMigrations = []dataMigration{
+	{name: filterValuesMigration, prepare: prepareFilterValuesBackfill},
+}
+
+// migrationTracker holds the status of every data migration known to this process.
+type migrationTracker struct {
+	mu       sync.RWMutex
+	statuses []*models.MigrationStatus
+
+	// cancel and finished stop the migrations run in the background
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+// stop cancels the migrations running in the background, if any, and waits
+// for them to return.
+func (t *migrationTracker) stop() {
+	if t.cancel != nil {
+		t.cancel()
+		<-t.finished
+	}
+}
+
+func (t *migrationTracker) get(name string) *models.MigrationStatus {
+	for _, st := range t.statuses {
+		if st.Name == name {
+			return st
+		}
+	}
+	return nil
+}
+
+// done reports whether the named migration has completed (or was never needed).
+func (t *migrationTracker) done(name string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	st := t.get(name)
+	return st == nil || st.State == "done"
+}
+
+func (t *migrationTracker) update(name string, fn func(st *models.MigrationStatus)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if st := t.get(name); st != nil {
+		fn(st)
+	}
+}
+
+// MigrationStatus returns a snapshot of all data migrations and their progress.
+func (db *DB) MigrationStatus() []models.MigrationStatus {
+	db.migrations.mu.RLock()
+	defer db.migrations.mu.RUnlock()
+
+	out := make([]models.MigrationStatus, 0, len(db.migrations.statuses))
+	for _, st := range db.migrations.statuses {
+		out = append(out, *st)
+	}
+	return out
+}
+
+// runMigrations runs all data migrations that have not completed yet, either
+// synchronously or in a background goroutine that Close stops.
+func (db *DB) runMigrations(background bool) error {
+	completed := make(map[string]bool)
+	rows, err := db.conn.Query("SELECT name FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		completed[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var pending []dataMigration
+	db.migrations.mu.Lock()
+	for _, m := range dataMigrations {
+		state := "pending"
+		if completed[m.name] {
+			state = "done"
+		} else {
+			pending = append(pending, m)
+		}
+		db.migrations.statuses = append(db.migrations.statuses, &models.MigrationStatus{Name: m.name, State: state})
+	}
+	db.migrations.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	works := make([]migrationWork, len(pending))
+	for i, m := range pending {
+		work, err := m.prepare(context.Background(), db)
+		if err != nil {
+			return fmt.Errorf("prepare migration %s: %w", m.name, err)
+		}
+		works[i] = work
+	}
+
+	if background {
+		ctx, cancel := context.WithCancel(context.Background())
+		db.migrations.cancel, db.migrations.finished = cancel, make(chan struct{})
+		go func() {
+			defer close(db.migrations.finished)
+			for i, m := range pending {
+				if err := db.runMigration(ctx, m.name, works[i]); err != nil {
+					// Later migrations may depend on this one
+					return
+				}
+			}
+		}()
+		return nil
+	}
+
+	for i, m := range pending {
+		if err := db.runMigration(context.Background(), m.name, works[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runMigration runs a prepared migration and records it completed. One
+// interrupted by canceling ctx is pending again, to run on the next start.
+func (db *DB) runMigration(ctx context.Context, name string, work migrationWork) error {
+	start := time.Now()
+	db.migrations.update(name, func(st *models.MigrationStatus) {
+		st.State = "running"
+		st.StartedAt = &start
+	})
+	slog.Info("data migration started", "migration", name)
+
+	report := func(processed, total int64) {
+		db.migrations.update(name, func(st *models.MigrationStatus) {
+			st.Processed = processed
+			st.Total = total
+		})
+		percent := 100.0
+		if total > 0 {
+			percent = float64(processed) * 100 / float64(total)
+		}
+		slog.Info("data migration progress", "migration", name,
+			"processed", processed, "total", total, "percent", fmt.Sprintf("%.1f", percent))
+	}
+
+	err := work(ctx, report)
+	if err == nil {
+		_, err = db.conn.Exec("INSERT INTO schema_migrations (name, completed_at) VALUES (?, ?)", name, time.Now())
+	}
+
+	finished := time.Now()
+	if err != nil && ctx.Err() != nil {
+		db.migrations.update(name, func(st *models.MigrationStatus) {
+			st.State, st.StartedAt = "pending", nil
+		})
+		slog.Info("data migration interrupted; it runs again on the next start", "migration", name,
+			"duration_ms", finished.Sub(start).Milliseconds())
+		return fmt.Errorf("migration %s: %w", name, err)
+	}
+	db.migrations.update(name, func(st *models.MigrationStatus) {
+		st.FinishedAt = &finished
+		if err != nil {
+			st.State = "failed"
+			st.Error = err.Error()
+		} else {
+			st.State = "done"
+		}
+	})
+
+	if err != nil {
+		slog.Error("data migration failed", "migration", name, "error", err,
+			"duration_ms", finished.Sub(start).Milliseconds())
+		return fmt.Errorf("migration %s: %w", name, err)
+	}
+	slog.Info("data migration completed", "migration", name,
+		"duration_ms", finished.Sub(start).Milliseconds())
+	return nil
+}
+
+// prepareFilterValuesBackfill clears filter_values (a previous run may have
+// been interrupted) and snapshots the highest log ID. The returned work rebuilds
+// filter_values from logs up to that ID in chunks; newer logs are maintained by
+// ingest itself.
+func prepareFilterValuesBackfill(ctx context.Context, db *DB) (migrationWork, error) {
+	if _, err := db.conn.ExecContext(ctx, "DELETE FROM filter_values"); err != nil {
+		return nil, err
+	}
+
+	var maxID int64
+	if err := db.conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM logs").Scan(&maxID); err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, report func(processed, total int64)) error {
+		report(0, maxID)
+		for lo := int64(0); lo < maxID; lo += migrationChunkSize {
+			hi := min(lo+migrationChunkSize, maxID)
+
+			for column := range allowedFilterColumns {
+				query := fmt.Sprintf(`INSERT INTO filter_values (kind, value, count, last_seen)
+					SELECT '%s', %s, COUNT(*), MAX(timestamp) FROM logs
+					WHERE id > ? AND id <= ? AND %s IS NOT NULL GROUP BY %s
+					ON CONFLICT(kind, value) DO UPDATE SET
+						count = filter_values.count + excluded.count,
+						last_seen = %s`,
+					column, column, column, column,
+					db.dialect.greatest("filter_values.last_seen", "excluded.last_seen"))
+				if _, err := db.conn.ExecContext(ctx, query, lo, hi); err != nil {
+					return err
+				}
+			}
+			report(hi, maxID)
+		}
+
+		db.invalidateFilterCache()
+		return nil
+	}, nil
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestMigrationStatus_FreshDatabase(t *testing.T) {
+	db := newTestDB(t)
+
+	statuses := db.MigrationStatus()
+	if len(statuses) != len(dataMigrations) {
+		t.Fatalf("expected %d migrations, got %d", len(dataMigrations), len(statuses))
+	}
+	for _, st := range statuses {
+		if st.State != "done" {
+			t.Errorf("expected migration %s to be done, got %s", st.Name, st.State)
+		}
+	}
+}
+
+func TestMigrations_RunOnce(t *testing.T) {
+	path := t.TempDir() + "/logs.db"
+	db, err := New(path)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	db.Close()
+
+	db, err = New(path)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer db.Close()
+
+	// Already-completed migrations are reported done without a start time
+	for _, st := range db.MigrationStatus() {
+		if st.State != "done" || st.StartedAt != nil {
+			t.Errorf("expected %s to be skipped as done, got %+v", st.Name, st)
+		}
+	}
+}
+
+func TestMigrations_Background(t *testing.T) {
+	path := t.TempDir() + "/logs.db"
+	db, err := New(path)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx := context.Background()
+	db.InsertBatch(ctx, []models.Log{
+		sampleLog("api", "info", "a"),
+		sampleLog("worker", "error", "b"),
+	})
+	db.conn.Exec("DELETE FROM schema_migrations")
+	db.Close()
+
+	db, err = NewWithOptions(path, Options{BackgroundMigrations: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %v", err)
+	}
+	defer db.Close()
+
+	// Reads fall back to scanning logs while the backfill runs
+	options, err := db.GetFilterOptions(ctx)
+	if err != nil {
+		t.Fatalf("GetFilterOptions failed: %v", err)
+	}
+	if len(options.Services) != 2 {
+		t.Errorf("expected 2 services during migration, got %v", options.Services)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !db.migrations.done(filterValuesMigration) {
+		if time.Now().After(deadline) {
+			t.Fatalf("migration did not finish: %+v", db.MigrationStatus())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	st := db.MigrationStatus()[0]
+	if st.Processed != 2 || st.Total != 2 || st.FinishedAt == nil {
+		t.Errorf("expected completed progress 2/2, got %+v", st)
+	}
+}
+
+// TestMigrations_BackgroundClose tests that Close stops a background
+// migration before closing the database.
+func TestMigrations_BackgroundClose(t *testing.T) {
+	path := t.TempDir() + "/logs.db"
+	db, err := New(path)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	logs := make([]models.Log, 5000)
+	for i := range logs {
+		logs[i] = sampleLog("api", "info", "a")
+	}
+	db.InsertBatch(context.Background(), logs)
+	db.conn.Exec("DELETE FROM schema_migrations")
+	db.Close()
+
+	db, err = NewWithOptions(path, Options{BackgroundMigrations: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %v", err)
+	}
+	db.Close()
+	for _, st := range db.MigrationStatus() {
+		if st.State != "done" && st.State != "pending" {
+			t.Errorf("expected %s done or interrupted by Close, got %+v", st.Name, st)
+		}
+	}
+}
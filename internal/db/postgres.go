@@ -0,0 +1,223 @@
+package db
+
+import (
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+//go:embed schema_postgres.sql
+var postgresSchema string
+
+// NewPostgres opens a PostgreSQL database, e.g.
+// "postgres://locog@localhost/locog?sslmode=disable", creating the schema if
+// needed.
+func NewPostgres(dsn string, opts Options) (*DB, error) {
+	return open("postgres", dsn, postgresDialect{}, opts)
+}
+
+// postgresDialect stores metadata as JSONB. Paths are bound as text arrays
+// for the #> and #>> operators.
+type postgresDialect struct{}
+
+func (postgresDialect) name() string               { return "postgres" }
+func (postgresDialect) schema() string             { return postgresSchema }
+func (postgresDialect) rebind(query string) string { return rebindNumbered(query) }
+
+// jsonArg passes JSON as text; the driver would send []byte as bytea.
+func (postgresDialect) jsonArg(data []byte) interface{} {
+	if data == nil {
+		return nil
+	}
+	return string(data)
+}
+
+// metaPath returns a text array literal, e.g. http.status -> {"http","status"}.
+func (postgresDialect) metaPath(key string) interface{} {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		part = strings.ReplaceAll(part, `\`, `\\`)
+		parts[i] = `"` + strings.ReplaceAll(part, `"`, `\"`) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func (postgresDialect) metaValue() string { return "(metadata #>> CAST(? AS text[]))" }
+
+// numericPattern matches the strings that CAST to double precision without
+// error, so a guarded CAST never fails the query.
+const numericPattern = `'^[+-]?([0-9]+[.]?[0-9]*|[.][0-9]+)([eE][+-]?[0-9]+)?$'`
+
+func (postgresDialect) numericGuard(path interface{}) (string, []interface{}) {
+	return `(jsonb_typeof(metadata #> CAST(? AS text[])) = 'number'
+		OR (jsonb_typeof(metadata #> CAST(? AS text[])) = 'string'
+			AND (metadata #>> CAST(? AS text[])) ~ ` + numericPattern + `))`,
+		[]interface{}{path, path, path}
+}
+
+// metaNumber repeats the guard inside a CASE since PostgreSQL may evaluate
+// conditions in any order.
+func (d postgresDialect) metaNumber(path interface{}) (string, []interface{}) {
+	guard, args := d.numericGuard(path)
+	return "(CASE WHEN " + guard + " THEN CAST(metadata #>> CAST(? AS text[]) AS double precision) END)",
+		append(args, path)
+}
+
+// generatedColumn is STORED, the only kind PostgreSQL supports; adding one
+// rewrites the table once.
+func (d postgresDialect) generatedColumn(key string) string {
+	return "TEXT GENERATED ALWAYS AS (metadata #>> " + sqlString(d.metaPath(key).(string)) + ") STORED"
+}
+
+func (postgresDialect) search(column string) string { return column + " ILIKE ?" }
+
+// regexp uses PostgreSQL's advanced regular expressions, which agree with RE2
+// on the common syntax.
+func (postgresDialect) regexp(column string) string { return column + " ~ ?" }
+
+func (postgresDialect) tagList() string {
+	return "(SELECT string_agg(tag, chr(31)) FROM log_tags WHERE log_id = logs.id)"
+}
+
+func (postgresDialect) histogramBucket() string {
+	return "CAST(FLOOR(EXTRACT(EPOCH FROM timestamp - CAST(? AS timestamptz)) / ?) AS BIGINT)"
+}
+
+func (postgresDialect) unixSeconds(column string) string {
+	return "CAST(FLOOR(EXTRACT(EPOCH FROM " + column + ")) AS BIGINT)"
+}
+
+func (postgresDialect) glob(column string) string { return column + ` LIKE ? ESCAPE '\'` }
+
+// globPattern converts a glob to a LIKE pattern. Tag patterns only use *, but
+// ? is translated too.
+func (postgresDialect) globPattern(pattern string) interface{} {
+	var b strings.Builder
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			b.WriteByte('%')
+		case '?':
+			b.WriteByte('_')
+		case '%', '_', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func (postgresDialect) greatest(a, b string) string { return "GREATEST(" + a + ", " + b + ")" }
+
+func (postgresDialect) columnExists() string {
+	return `SELECT COUNT(*) > 0 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`
+}
+
+func (postgresDialect) explain() string { return "EXPLAIN (FORMAT JSON) " }
+
+// postgresPlanNode is a node of an EXPLAIN (FORMAT JSON) plan.
+type postgresPlanNode struct {
+	NodeType     string             `json:"Node Type"`
+	RelationName string             `json:"Relation Name"`
+	IndexName    string             `json:"Index Name"`
+	IndexCond    string             `json:"Index Cond"`
+	Plans        []postgresPlanNode `json:"Plans"`
+}
+
+func (postgresDialect) parsePlan(rows *sql.Rows) (queryPlan, error) {
+	var plan queryPlan
+	var data []byte
+	if rows.Next() {
+		if err := rows.Scan(&data); err != nil {
+			return plan, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return plan, err
+	}
+	var explained []struct {
+		Plan postgresPlanNode `json:"Plan"`
+	}
+	if err := json.Unmarshal(data, &explained); err != nil {
+		return plan, err
+	}
+
+	var walk func(n postgresPlanNode, depth int)
+	walk = func(n postgresPlanNode, depth int) {
+		step := strings.Repeat("  ", depth) + n.NodeType
+		if n.IndexName != "" {
+			step += " using " + n.IndexName
+			plan.indexes = append(plan.indexes, n.IndexName)
+			plan.conditions += n.IndexCond + " "
+		}
+		if n.RelationName != "" {
+			step += " on " + n.RelationName
+		}
+		plan.steps = append(plan.steps, step)
+		if n.NodeType == "Seq Scan" && n.RelationName == "logs" {
+			plan.fullScan = true
+		}
+		for _, child := range n.Plans {
+			walk(child, depth+1)
+		}
+	}
+	for _, e := range explained {
+		walk(e.Plan, 0)
+	}
+	return plan, nil
+}
+
+func (postgresDialect) version() string { return "SHOW server_version" }
+
+// reclaimSpace leaves dead rows to autovacuum, which makes their space
+// reusable; VACUUM FULL would lock the table while it rewrites it.
+func (postgresDialect) reclaimSpace() []string { return nil }
+
+// indexes leaves out indexes backing constraints, such as the primary key.
+func (postgresDialect) indexes() string {
+	return `SELECT i.indexname FROM pg_indexes i
+		WHERE i.tablename = ? AND i.schemaname = current_schema()
+			AND NOT EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conname = i.indexname)
+		ORDER BY i.indexname`
+}
+
+// reindex rebuilds the index alongside the old one, so queries and ingest
+// carry on while it runs.
+func (postgresDialect) reindex(index string) string {
+	return `REINDEX INDEX CONCURRENTLY "` + index + `"`
+}
+
+func (postgresDialect) analyze() []string { return []string{"ANALYZE logs"} }
+
+// metadataKeys walks metadata with a recursive jsonb_each, joining nested
+// keys with dots. Arrays are leaves, as with SQLite.
+func (postgresDialect) metadataKeys() string {
+	return `
+		INSERT INTO metadata_keys (service, key, type, count, service_total, example_min, example_max, last_seen, generated_at)
+		SELECT t.service, t.key, jsonb_typeof(t.value) AS kind,
+			COUNT(*),
+			(SELECT COUNT(*) FROM logs WHERE service = t.service AND timestamp >= ?),
+			MIN(substr(CASE WHEN jsonb_typeof(t.value) = 'array' THEN t.value::text ELSE t.value #>> '{}' END, 1, ?)),
+			MAX(substr(CASE WHEN jsonb_typeof(t.value) = 'array' THEN t.value::text ELSE t.value #>> '{}' END, 1, ?)),
+			MAX(t.ts),
+			CAST(? AS timestamptz)
+		FROM (
+			WITH RECURSIVE tree(service, key, value, ts) AS (
+				SELECT l.service, e.key, e.value, l.timestamp
+				FROM logs l, jsonb_each(CASE WHEN jsonb_typeof(l.metadata) = 'object' THEN l.metadata ELSE '{}' END) e
+				WHERE l.timestamp >= ?
+				UNION ALL
+				SELECT tree.service, tree.key || '.' || e.key, e.value, tree.ts
+				FROM tree, jsonb_each(CASE WHEN jsonb_typeof(tree.value) = 'object' THEN tree.value ELSE '{}' END) e
+			)
+			SELECT * FROM tree
+		) t
+		WHERE jsonb_typeof(t.value) != 'object'
+		GROUP BY t.service, t.key, kind`
+}
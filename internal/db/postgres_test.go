@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestRebindNumbered(t *testing.T) {
+	got := rebindNumbered("SELECT a FROM t WHERE b = ? AND c ~ '^x?$' AND d IN (?, ?)")
+	want := "SELECT a FROM t WHERE b = $1 AND c ~ '^x?$' AND d IN ($2, $3)"
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestPostgresDialect(t *testing.T) {
+	var d postgresDialect
+	if got := d.metaPath(`http.st"atus`); got != `{"http","st\"atus"}` {
+		t.Errorf("unexpected path: %v", got)
+	}
+	if got := d.globPattern("incident_*"); got != `incident\_%` {
+		t.Errorf("unexpected pattern: %v", got)
+	}
+	if got := d.globPattern(`100%?\`); got != `100\%_\\` {
+		t.Errorf("unexpected pattern: %v", got)
+	}
+	if got := d.generatedColumn("http.trace_id"); got != `TEXT GENERATED ALWAYS AS (metadata #>> '{"http","trace_id"}') STORED` {
+		t.Errorf("unexpected generated column: %v", got)
+	}
+	if d.jsonArg(nil) != nil || d.jsonArg([]byte(`{}`)) != "{}" {
+		t.Error("expected JSON to be passed as text and nil as NULL")
+	}
+}
+
+// newPostgresTestDB connects to the scratch database in
+// LOCOG_TEST_POSTGRES_DSN, emptying its tables, or skips the test.
+func newPostgresTestDB(t *testing.T) *DB {
+	t.Helper()
+	dsn := os.Getenv("LOCOG_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("LOCOG_TEST_POSTGRES_DSN not set")
+	}
+	db, err := NewPostgres(dsn, Options{})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.conn.Exec(`TRUNCATE logs, filter_values, metadata_keys, log_tags, tag_exports, user_prefs, redaction_vault, vault_reveals, sources, host_groups, log_rollups, log_rollup_state, incidents`); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestPostgres_QueryLogs(t *testing.T) {
+	db := newPostgresTestDB(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	err := db.InsertBatch(ctx, []models.Log{
+		{Timestamp: now.Add(-time.Minute), Service: "api", Level: "ERROR", Message: "Request failed", Host: "h",
+			Metadata: map[string]interface{}{"http": map[string]interface{}{"status": 503}, "latency": "12.5"}},
+		{Timestamp: now, Service: "api", Level: "INFO", Message: "request ok", Host: "h",
+			Metadata: map[string]interface{}{"http": map[string]interface{}{"status": 200}, "latency": "fast"}},
+	})
+	if err != nil {
+		t.Fatalf("InsertBatch failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		filter models.LogFilter
+		want   int
+	}{
+		{"search is case-insensitive", models.LogFilter{Search: "REQUEST"}, 2},
+		{"numeric meta", models.LogFilter{Meta: []models.MetaFilter{{Key: "http.status", Op: ">=", Numeric: true, Number: 500}}}, 1},
+		{"numeric string meta", models.LogFilter{Meta: []models.MetaFilter{{Key: "latency", Op: "<", Numeric: true, Number: 20}}}, 1},
+		{"negated meta", models.LogFilter{Meta: []models.MetaFilter{{Key: "latency", Op: "!=", Value: "fast"}}}, 1},
+	} {
+		logs, err := db.QueryLogs(ctx, tc.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(logs) != tc.want {
+			t.Errorf("%s: expected %d logs, got %d", tc.name, tc.want, len(logs))
+		}
+	}
+
+	if _, err := db.TagLogs(ctx, models.LogFilter{Level: "ERROR"}, "incident-1"); err != nil {
+		t.Fatalf("TagLogs failed: %v", err)
+	}
+	logs, _ := db.QueryLogs(ctx, models.LogFilter{Tag: "incident-1"})
+	if len(logs) != 1 || len(logs[0].Tags) != 1 || logs[0].Metadata["latency"] != "12.5" {
+		t.Errorf("unexpected tagged logs: %+v", logs)
+	}
+
+	counts, err := db.Histogram(ctx, models.LogFilter{}, now.Add(-2*time.Minute), now, 2)
+	if err != nil {
+		t.Fatalf("Histogram failed: %v", err)
+	}
+	if counts[0]+counts[1] != 2 {
+		t.Errorf("unexpected histogram: %v", counts)
+	}
+
+	if err := db.RefreshMetadataKeys(ctx, now.Add(-time.Hour)); err != nil {
+		t.Fatalf("RefreshMetadataKeys failed: %v", err)
+	}
+	report, _ := db.MetadataKeys(ctx, "api")
+	if len(report.Keys) != 2 || report.Keys[0].Key != "http.status" || report.Keys[0].Types["number"] != 2 {
+		t.Errorf("unexpected metadata keys: %+v", report.Keys)
+	}
+}
+
+// TestPostgres_GlobPatterns tests that LIKE matches service globs as
+// GlobMatch does, % and _ included.
+func TestPostgres_GlobPatterns(t *testing.T) {
+	testGlobPatterns(t, newPostgresTestDB(t))
+}
+
+func TestPostgres_DeleteOldLogs(t *testing.T) {
+	db := newPostgresTestDB(t)
+	ctx := context.Background()
+
+	old := time.Now().Add(-48 * time.Hour)
+	db.InsertBatch(ctx, []models.Log{
+		{Timestamp: old, Service: "api", Level: "INFO", Message: "old", Host: "h"},
+		{Timestamp: old, Service: "api", Level: "INFO", Message: "kept", Host: "h"},
+	})
+	db.TagLogs(ctx, models.LogFilter{Search: "kept"}, "incident-2")
+	db.SetTagPolicies([]TagPolicy{{Pattern: "incident-*", Keep: 72 * time.Hour}})
+
+	deleted, err := db.DeleteOldLogs(ctx, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("DeleteOldLogs failed: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("expected 1 log deleted, got %d", deleted)
+	}
+}
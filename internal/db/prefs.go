@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Prefs returns all preferences stored for user, keyed by name.
+func (db *DB) Prefs(ctx context.Context, user string) (map[string]json.RawMessage, error) {
+	rows, err := db.conn.QueryContext(ctx, "SELECT key, value FROM user_prefs WHERE user_id = ?", user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prefs := make(map[string]json.RawMessage)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		prefs[key] = json.RawMessage(value)
+	}
+	return prefs, rows.Err()
+}
+
+// Pref returns a single preference, or nil if it is not set.
+func (db *DB) Pref(ctx context.Context, user, key string) (json.RawMessage, error) {
+	var value string
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT value FROM user_prefs WHERE user_id = ? AND key = ?", user, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(value), nil
+}
+
+// SetPref stores a preference, replacing any previous value. value must be
+// valid JSON.
+func (db *DB) SetPref(ctx context.Context, user, key string, value json.RawMessage) error {
+	_, err := db.conn.ExecContext(ctx,
+		`INSERT INTO user_prefs (user_id, key, value, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+		user, key, string(value), time.Now().UTC())
+	return err
+}
+
+// DeletePref removes a preference and reports whether it existed.
+func (db *DB) DeletePref(ctx context.Context, user, key string) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, "DELETE FROM user_prefs WHERE user_id = ? AND key = ?", user, key)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestPrefs(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	if err := db.SetPref(ctx, "alice", "theme", json.RawMessage(`"dark"`)); err != nil {
+		t.Fatalf("SetPref failed: %v", err)
+	}
+	db.SetPref(ctx, "alice", "columns", json.RawMessage(`["timestamp","message"]`))
+	db.SetPref(ctx, "alice", "theme", json.RawMessage(`"light"`))
+	db.SetPref(ctx, "bob", "theme", json.RawMessage(`"auto"`))
+
+	prefs, err := db.Prefs(ctx, "alice")
+	if err != nil {
+		t.Fatalf("Prefs failed: %v", err)
+	}
+	if len(prefs) != 2 || string(prefs["theme"]) != `"light"` {
+		t.Errorf("unexpected prefs: %s", prefs)
+	}
+
+	value, err := db.Pref(ctx, "bob", "columns")
+	if err != nil || value != nil {
+		t.Errorf("expected unset pref to be nil, got %s (%v)", value, err)
+	}
+
+	if ok, _ := db.DeletePref(ctx, "alice", "theme"); !ok {
+		t.Error("expected delete to report the pref existed")
+	}
+	if ok, _ := db.DeletePref(ctx, "alice", "theme"); ok {
+		t.Error("expected second delete to report nothing removed")
+	}
+	if value, _ := db.Pref(ctx, "bob", "theme"); string(value) != `"auto"` {
+		t.Errorf("expected other users' prefs to be untouched, got %s", value)
+	}
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"database/sql"
+	"regexp"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver is go-sqlite3 with a REGEXP function, which SQLite declares
+// but doesn't implement. Patterns use Go's RE2 syntax.
+const sqliteDriver = "sqlite3_locog"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{ConnectHook: registerFunctions})
+}
+
+// registerFunctions adds locog's SQL functions to a new connection.
+func registerFunctions(conn *sqlite3.SQLiteConn) error {
+	return conn.RegisterFunc("regexp", regexpMatch, true)
+}
+
+// maxCachedRegexps bounds the compiled patterns kept for REGEXP. The cache is
+// cleared when full; a query uses one pattern for all its rows.
+const maxCachedRegexps = 64
+
+var regexpCache = struct {
+	sync.Mutex
+	compiled map[string]*regexp.Regexp
+}{compiled: make(map[string]*regexp.Regexp)}
+
+// regexpMatch implements `text REGEXP pattern`, which SQLite calls as
+// regexp(pattern, text).
+func regexpMatch(pattern, text string) (bool, error) {
+	regexpCache.Lock()
+	re, ok := regexpCache.compiled[pattern]
+	regexpCache.Unlock()
+	if !ok {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return false, err
+		}
+		regexpCache.Lock()
+		if len(regexpCache.compiled) >= maxCachedRegexps {
+			clear(regexpCache.compiled)
+		}
+		regexpCache.compiled[pattern] = re
+		regexpCache.Unlock()
+	}
+	return re.MatchString(text), nil
+}
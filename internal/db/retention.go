@@ -0,0 +1,385 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"locog/internal/models"
+	"locog/internal/tracing"
+)
+
+// TagPolicy overrides the retention of logs carrying a tag that matches
+// Pattern, a glob such as "incident-*".
+type TagPolicy struct {
+	Pattern string
+	Keep    time.Duration
+}
+
+var tagPolicyPattern = regexp.MustCompile(`^[A-Za-z0-9_.:\-*]{1,64}$`)
+
+// Validate checks the policy's pattern and retention.
+func (p TagPolicy) Validate() error {
+	if !tagPolicyPattern.MatchString(p.Pattern) {
+		return fmt.Errorf("invalid tag pattern %q", p.Pattern)
+	}
+	if p.Keep < 0 {
+		return fmt.Errorf("tag pattern %s: retention must not be negative", p.Pattern)
+	}
+	return nil
+}
+
+// retainedTables maps the auxiliary tables with a retention of their own to
+// the column dating their rows. Tables describing logs (log_tags,
+// tag_exports, redaction_vault, sources and filter_values) are pruned along
+// with the logs by DeleteOldLogs.
+var retainedTables = map[string]string{
+	"vault_reveals": "revealed_at",
+}
+
+// RetainedTables lists the tables DeleteOldRows accepts, sorted.
+func RetainedTables() []string {
+	return slices.Sorted(maps.Keys(retainedTables))
+}
+
+// DeleteOldRows deletes the rows of an auxiliary table older than olderThan
+// and returns how many were deleted.
+func (db *DB) DeleteOldRows(ctx context.Context, table string, olderThan time.Duration) (int64, error) {
+	column, ok := retainedTables[table]
+	if !ok {
+		return 0, fmt.Errorf("table %q has no retention of its own; use one of %s", table, strings.Join(RetainedTables(), ", "))
+	}
+	ctx, span := db.startSpan(ctx, "DeleteOldRows", tracing.String("db.table", table))
+	defer span.End()
+
+	result, err := db.conn.ExecContext(ctx, "DELETE FROM "+table+" WHERE "+column+" < ?", time.Now().Add(-olderThan).UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// CountOldRows counts the rows of an auxiliary table DeleteOldRows would
+// delete.
+func (db *DB) CountOldRows(ctx context.Context, table string, olderThan time.Duration) (int64, error) {
+	column, ok := retainedTables[table]
+	if !ok {
+		return 0, fmt.Errorf("table %q has no retention of its own; use one of %s", table, strings.Join(RetainedTables(), ", "))
+	}
+	var n int64
+	err := db.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+" WHERE "+column+" < ?", time.Now().Add(-olderThan).UTC()).Scan(&n)
+	return n, err
+}
+
+// policies hold the retention and rollup settings a store applies, shared
+// by DB and Ring.
+type policies struct {
+	policyMu        sync.RWMutex
+	tagPolicies     []TagPolicy
+	streamRetention map[string]time.Duration
+	archiveHolds    []string
+	rollups         RollupConfig
+}
+
+// SetTagPolicies replaces the tag retention policies applied by DeleteOldLogs.
+func (p *policies) SetTagPolicies(policies []TagPolicy) {
+	p.policyMu.Lock()
+	p.tagPolicies = append([]TagPolicy(nil), policies...)
+	p.policyMu.Unlock()
+}
+
+// TagPolicies returns the tag retention policies applied by DeleteOldLogs.
+func (p *policies) TagPolicies() []TagPolicy {
+	p.policyMu.RLock()
+	defer p.policyMu.RUnlock()
+	return append([]TagPolicy(nil), p.tagPolicies...)
+}
+
+// SetStreamRetention replaces the per-stream retention applied by
+// DeleteOldLogs. Streams without an entry use the default retention.
+func (p *policies) SetStreamRetention(retention map[string]time.Duration) {
+	p.policyMu.Lock()
+	p.streamRetention = maps.Clone(retention)
+	p.policyMu.Unlock()
+}
+
+// SetArchiveHolds replaces the tags whose logs DeleteOldLogs keeps whatever
+// their retention, e.g. because their archive failed verification.
+func (p *policies) SetArchiveHolds(tags []string) {
+	p.policyMu.Lock()
+	p.archiveHolds = slices.Compact(slices.Sorted(slices.Values(tags)))
+	p.policyMu.Unlock()
+}
+
+// ArchiveHolds returns the tags whose logs DeleteOldLogs keeps, sorted.
+func (p *policies) ArchiveHolds() []string {
+	p.policyMu.RLock()
+	defer p.policyMu.RUnlock()
+	return slices.Clone(p.archiveHolds)
+}
+
+// StreamRetention returns the per-stream retention applied by DeleteOldLogs.
+func (p *policies) StreamRetention() map[string]time.Duration {
+	p.policyMu.RLock()
+	defer p.policyMu.RUnlock()
+	return maps.Clone(p.streamRetention)
+}
+
+// retentionRule is the SQL for a retention cleanup: logs matching expired
+// and not exceptions are deleted.
+type retentionRule struct {
+	expired       string // logs older than their stream's retention
+	expiredArgs   []interface{}
+	exceptions    string // expired logs kept by a tag policy or hold; "" if none
+	exceptionArgs []interface{}
+	incidents     string // expired logs kept for an incident; "" if none
+	incidentArgs  []interface{}
+	oldest        time.Time // oldest timestamp any log may be kept from
+}
+
+// buildRetentionRule builds the cleanup for a default retention of olderThan,
+// per-stream overrides, tag policies, archive holds and the incidents still
+// keeping logs. Held logs don't move rule.oldest: holds are meant to be
+// short-lived, until the archive is repaired.
+func (db *DB) buildRetentionRule(now time.Time, olderThan time.Duration, streams map[string]time.Duration, policies []TagPolicy, holds []string, incidents []models.Incident) retentionRule {
+	rule := retentionRule{oldest: now.Add(-olderThan)}
+	shortest := olderThan
+
+	if len(streams) == 0 {
+		rule.expired = "timestamp < ?"
+		rule.expiredArgs = []interface{}{now.Add(-olderThan)}
+	} else {
+		names := slices.Sorted(maps.Keys(streams))
+		clauses := []string{"(stream NOT IN (?" + strings.Repeat(", ?", len(names)-1) + ") AND timestamp < ?)"}
+		for _, name := range names {
+			rule.expiredArgs = append(rule.expiredArgs, name)
+		}
+		rule.expiredArgs = append(rule.expiredArgs, now.Add(-olderThan))
+		for _, name := range names {
+			keep := streams[name]
+			clauses = append(clauses, "(stream = ? AND timestamp < ?)")
+			rule.expiredArgs = append(rule.expiredArgs, name, now.Add(-keep))
+			if cutoff := now.Add(-keep); cutoff.Before(rule.oldest) {
+				rule.oldest = cutoff
+			}
+			shortest = min(shortest, keep)
+		}
+		rule.expired = "(" + strings.Join(clauses, " OR ") + ")"
+	}
+
+	// Policies keeping tagged logs no longer than every stream does change
+	// nothing
+	var clauses []string
+	for _, p := range policies {
+		if p.Keep <= shortest {
+			continue
+		}
+		cutoff := now.Add(-p.Keep)
+		clauses = append(clauses, "("+db.dialect.glob("t.tag")+" AND logs.timestamp >= ?)")
+		rule.exceptionArgs = append(rule.exceptionArgs, db.dialect.globPattern(p.Pattern), cutoff)
+		if cutoff.Before(rule.oldest) {
+			rule.oldest = cutoff
+		}
+	}
+	if len(holds) > 0 {
+		clauses = append(clauses, "t.tag IN (?"+strings.Repeat(", ?", len(holds)-1)+")")
+		for _, tag := range holds {
+			rule.exceptionArgs = append(rule.exceptionArgs, tag)
+		}
+	}
+	if len(clauses) > 0 {
+		rule.exceptions = "EXISTS (SELECT 1 FROM log_tags t WHERE t.log_id = logs.id AND (" +
+			strings.Join(clauses, " OR ") + "))"
+	}
+
+	rule.incidents, rule.incidentArgs = incidentExceptions(incidents)
+	for _, inc := range incidents {
+		if inc.StartedAt.Before(rule.oldest) {
+			rule.oldest = inc.StartedAt
+		}
+	}
+	return rule
+}
+
+// deleteWhere returns the WHERE clause selecting the logs the rule deletes.
+func (rule retentionRule) deleteWhere() (string, []interface{}) {
+	where := " WHERE " + rule.expired
+	args := append([]interface{}(nil), rule.expiredArgs...)
+	if rule.exceptions != "" {
+		where += " AND NOT " + rule.exceptions
+		args = append(args, rule.exceptionArgs...)
+	}
+	if rule.incidents != "" {
+		where += " AND NOT " + rule.incidents
+		args = append(args, rule.incidentArgs...)
+	}
+	return where, args
+}
+
+// TagExport is a log pending export because it carries Tag.
+type TagExport struct {
+	Tag string
+	Log models.Log
+}
+
+// PendingTagExports returns up to limit logs carrying a tag matching pattern
+// that have not yet been exported for that tag, oldest first.
+func (db *DB) PendingTagExports(ctx context.Context, pattern string, limit int) ([]TagExport, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT t.tag, l.id, l.timestamp, l.service, l.level, l.message, l.metadata, l.host, l.created_at
+		FROM log_tags t JOIN logs l ON l.id = t.log_id
+		WHERE `+db.dialect.glob("t.tag")+`
+			AND NOT EXISTS (SELECT 1 FROM tag_exports e WHERE e.log_id = t.log_id AND e.tag = t.tag)
+		ORDER BY l.timestamp, l.id
+		LIMIT ?`, db.dialect.globPattern(pattern), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []TagExport
+	for rows.Next() {
+		var e TagExport
+		var metadataJSON []byte
+		if err := rows.Scan(&e.Tag, &e.Log.ID, &e.Log.Timestamp, &e.Log.Service, &e.Log.Level,
+			&e.Log.Message, &metadataJSON, &e.Log.Host, &e.Log.CreatedAt); err != nil {
+			return nil, err
+		}
+		if len(metadataJSON) > 0 {
+			json.Unmarshal(metadataJSON, &e.Log.Metadata)
+		}
+		exports = append(exports, e)
+	}
+	return exports, rows.Err()
+}
+
+// MarkTagsExported records that the given logs were exported for their tags.
+func (db *DB) MarkTagsExported(ctx context.Context, exports []TagExport) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO tag_exports (log_id, tag, exported_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for _, e := range exports {
+		if _, err := stmt.ExecContext(ctx, e.Log.ID, e.Tag, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// TagExportCount is how many logs carrying a tag have been exported for it,
+// and how many are still pending export.
+type TagExportCount struct {
+	Exported int64
+	Pending  int64
+}
+
+// TagExportCounts returns the export counts of every tag matching pattern
+// that is on a log or has been exported. Export records are pruned with
+// their logs, so Exported only counts exports of logs still stored.
+func (db *DB) TagExportCounts(ctx context.Context, pattern string) (map[string]TagExportCount, error) {
+	counts := make(map[string]TagExportCount)
+	rows, err := db.conn.QueryContext(ctx, "SELECT tag, COUNT(*) FROM tag_exports WHERE "+db.dialect.glob("tag")+" GROUP BY tag",
+		db.dialect.globPattern(pattern))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag string
+		var c TagExportCount
+		if err := rows.Scan(&tag, &c.Exported); err != nil {
+			return nil, err
+		}
+		counts[tag] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pending, err := db.conn.QueryContext(ctx, `
+		SELECT t.tag, COUNT(*) FROM log_tags t
+		WHERE `+db.dialect.glob("t.tag")+`
+			AND NOT EXISTS (SELECT 1 FROM tag_exports e WHERE e.log_id = t.log_id AND e.tag = t.tag)
+		GROUP BY t.tag`, db.dialect.globPattern(pattern))
+	if err != nil {
+		return nil, err
+	}
+	defer pending.Close()
+	for pending.Next() {
+		var tag string
+		var n int64
+		if err := pending.Scan(&tag, &n); err != nil {
+			return nil, err
+		}
+		c := counts[tag]
+		c.Pending = n
+		counts[tag] = c
+	}
+	return counts, pending.Err()
+}
+
+// RetentionPreview reports what DeleteOldLogs(olderThan) would delete if the
+// given tag policies were in effect, without deleting anything. Per-stream
+// retention, archive holds and incidents are the current ones.
+func (db *DB) RetentionPreview(ctx context.Context, olderThan time.Duration, policies []TagPolicy) (models.RetentionPreview, error) {
+	now := time.Now()
+	preview := models.RetentionPreview{
+		Cutoff: now.Add(-olderThan).UTC(),
+		Groups: []models.RetentionGroup{},
+	}
+
+	incidents, err := db.Incidents(ctx, now)
+	if err != nil {
+		return preview, err
+	}
+	rule := db.buildRetentionRule(now, olderThan, db.StreamRetention(), policies, db.ArchiveHolds(), incidents)
+	if rule.exceptions != "" {
+		err := db.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM logs WHERE "+rule.expired+" AND "+rule.exceptions,
+			append(append([]interface{}(nil), rule.expiredArgs...), rule.exceptionArgs...)...).Scan(&preview.KeptByTags)
+		if err != nil {
+			return preview, err
+		}
+	}
+	if rule.incidents != "" {
+		err := db.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM logs WHERE "+rule.expired+" AND "+rule.incidents,
+			append(append([]interface{}(nil), rule.expiredArgs...), rule.incidentArgs...)...).Scan(&preview.KeptByIncidents)
+		if err != nil {
+			return preview, err
+		}
+	}
+
+	where, args := rule.deleteWhere()
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT stream, service, level, COUNT(*) FROM logs"+where+
+			" GROUP BY stream, service, level ORDER BY COUNT(*) DESC, stream, service, level", args...)
+	if err != nil {
+		return preview, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g models.RetentionGroup
+		if err := rows.Scan(&g.Stream, &g.Service, &g.Level, &g.Count); err != nil {
+			return preview, err
+		}
+		preview.Groups = append(preview.Groups, g)
+		preview.Total += g.Count
+	}
+	return preview, rows.Err()
+}
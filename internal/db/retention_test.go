@@ -0,0 +1,279 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestDeleteOldLogs_TagPolicies(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	insert := func(age time.Duration, message string) int64 {
+		l := &models.Log{Timestamp: time.Now().Add(-age), Service: "api", Level: "error", Message: message, Host: "h"}
+		db.InsertLog(ctx, l)
+		return l.ID
+	}
+	insert(40*24*time.Hour, "untagged")
+	insert(40*24*time.Hour, "incident")
+	insert(400*24*time.Hour, "ancient incident")
+	insert(40*24*time.Hour, "other tag")
+
+	db.TagLogs(ctx, models.LogFilter{Search: "incident"}, "incident-142")
+	db.TagLogs(ctx, models.LogFilter{Search: "other"}, "debug")
+
+	db.SetTagPolicies([]TagPolicy{{Pattern: "incident-*", Keep: 365 * 24 * time.Hour}})
+
+	deleted, err := db.DeleteOldLogs(ctx, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("DeleteOldLogs failed: %v", err)
+	}
+	if deleted != 3 {
+		t.Errorf("expected 3 logs deleted, got %d", deleted)
+	}
+
+	logs, _ := db.QueryLogs(ctx, models.LogFilter{})
+	if len(logs) != 1 || logs[0].Message != "incident" {
+		t.Errorf("expected only the incident log within its policy to remain, got %+v", logs)
+	}
+
+	// The kept log's service stays in the filter dropdowns
+	options, _ := db.GetFilterOptions(ctx)
+	if len(options.Services) != 1 {
+		t.Errorf("expected service of kept log to remain in filter options, got %v", options.Services)
+	}
+}
+
+// TestDeleteOldLogs_Chunks tests deleting in chunks and returning the freed
+// pages to the filesystem.
+func TestDeleteOldLogs_Chunks(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "logs.db"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	old := time.Now().Add(-40 * 24 * time.Hour)
+	var batch []models.Log
+	for i := 0; i < 500; i++ {
+		batch = append(batch, models.Log{Timestamp: old, Service: "api", Level: "info", Message: strings.Repeat("x", 1000), Host: "h"})
+	}
+	if err := db.InsertBatch(ctx, batch); err != nil {
+		t.Fatalf("InsertBatch failed: %v", err)
+	}
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "recent", Host: "h"})
+
+	// A chunk smaller than what matches takes several statements
+	deleted, err := db.deleteLogs(ctx, " WHERE timestamp < ?", []interface{}{old.Add(time.Hour)}, 150)
+	if err != nil || deleted != 500 {
+		t.Fatalf("expected 500 deleted, got %d (%v)", deleted, err)
+	}
+	if n, _ := db.CountLogs(ctx, models.LogFilter{}); n != 1 {
+		t.Errorf("expected the recent log to remain, got %d logs", n)
+	}
+
+	var autoVacuum, freePages int
+	db.conn.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&autoVacuum)
+	if autoVacuum != 2 {
+		t.Errorf("expected auto_vacuum=incremental (2) on a new database, got %d", autoVacuum)
+	}
+	db.conn.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&freePages)
+	if freePages == 0 {
+		t.Fatal("expected the deletes to leave free pages")
+	}
+
+	// The cleanup proper returns them once it has deleted something
+	db.InsertLog(ctx, &models.Log{Timestamp: old, Service: "api", Level: "info", Message: "old", Host: "h"})
+	if deleted, err := db.DeleteOldLogs(ctx, 30*24*time.Hour); err != nil || deleted != 1 {
+		t.Fatalf("expected 1 deleted, got %d (%v)", deleted, err)
+	}
+	db.conn.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&freePages)
+	if freePages != 0 {
+		t.Errorf("expected no free pages after cleanup, got %d", freePages)
+	}
+}
+
+// TestEnableIncrementalVacuum tests that a database created without
+// auto_vacuum is converted once, keeping its logs, so cleanups shrink it.
+func TestEnableIncrementalVacuum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.db")
+	old, err := sql.Open(sqliteDriver, path+"?_journal_mode=WAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := initSchema(&dbConn{DB: old, dialect: sqliteDialect{}}); err != nil {
+		t.Fatal(err)
+	}
+	old.Exec("INSERT INTO logs (timestamp, service, level, message, host) VALUES (?, 'api', 'info', 'kept', 'h')", time.Now())
+	var autoVacuum int
+	old.QueryRow("PRAGMA auto_vacuum").Scan(&autoVacuum)
+	old.Close()
+	if autoVacuum != 0 {
+		t.Fatalf("expected the old database without auto_vacuum, got %d", autoVacuum)
+	}
+
+	db, err := New(path)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer db.Close()
+	db.conn.QueryRow("PRAGMA auto_vacuum").Scan(&autoVacuum)
+	if autoVacuum != 2 {
+		t.Errorf("expected auto_vacuum=incremental (2) after opening, got %d", autoVacuum)
+	}
+	if logs, _ := db.QueryLogs(context.Background(), models.LogFilter{}); len(logs) != 1 || logs[0].Message != "kept" {
+		t.Errorf("expected the log kept, got %+v", logs)
+	}
+}
+
+func TestTagPolicy_Validate(t *testing.T) {
+	if err := (TagPolicy{Pattern: "incident-*", Keep: time.Hour}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, p := range []TagPolicy{{Pattern: ""}, {Pattern: "a[b]"}, {Pattern: "ok", Keep: -time.Hour}} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("expected error for %+v", p)
+		}
+	}
+}
+
+func TestPendingTagExports(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "error", Message: "boom", Host: "h",
+		Metadata: map[string]interface{}{"k": "v"}})
+	db.TagLogs(ctx, models.LogFilter{}, "incident-1")
+	db.TagLogs(ctx, models.LogFilter{}, "incident-2")
+	db.TagLogs(ctx, models.LogFilter{}, "debug")
+
+	pending, err := db.PendingTagExports(ctx, "incident-*", 100)
+	if err != nil {
+		t.Fatalf("PendingTagExports failed: %v", err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("expected the log pending once per matching tag, got %d", len(pending))
+	}
+	if pending[0].Log.Message != "boom" || pending[0].Log.Metadata["k"] != "v" {
+		t.Errorf("unexpected pending log: %+v", pending[0].Log)
+	}
+
+	if err := db.MarkTagsExported(ctx, pending[:1]); err != nil {
+		t.Fatalf("MarkTagsExported failed: %v", err)
+	}
+	pending, _ = db.PendingTagExports(ctx, "incident-*", 100)
+	if len(pending) != 1 || pending[0].Tag != "incident-2" {
+		t.Errorf("expected only incident-2 still pending, got %+v", pending)
+	}
+}
+
+func TestRetentionPreview(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	insert := func(age time.Duration, service, level, message string) {
+		db.InsertLog(ctx, &models.Log{Timestamp: time.Now().Add(-age), Service: service, Level: level, Message: message, Host: "h"})
+	}
+	insert(40*24*time.Hour, "api", "ERROR", "old incident")
+	insert(40*24*time.Hour, "api", "ERROR", "old")
+	insert(50*24*time.Hour, "api", "INFO", "old")
+	insert(10*24*time.Hour, "worker", "INFO", "recent")
+	db.TagLogs(ctx, models.LogFilter{Search: "incident"}, "incident-142")
+
+	policies := []TagPolicy{{Pattern: "incident-*", Keep: 365 * 24 * time.Hour}}
+	preview, err := db.RetentionPreview(ctx, 30*24*time.Hour, policies)
+	if err != nil {
+		t.Fatalf("RetentionPreview failed: %v", err)
+	}
+	if preview.Total != 2 || preview.KeptByTags != 1 || len(preview.Groups) != 2 {
+		t.Fatalf("unexpected preview: %+v", preview)
+	}
+	if g := preview.Groups[0]; g.Service != "api" || g.Count != 1 {
+		t.Errorf("unexpected group: %+v", g)
+	}
+
+	// A shorter hypothetical retention without policies reaches further
+	preview, _ = db.RetentionPreview(ctx, 7*24*time.Hour, nil)
+	if preview.Total != 4 || preview.KeptByTags != 0 {
+		t.Errorf("unexpected hypothetical preview: %+v", preview)
+	}
+
+	// Nothing was deleted
+	if logs, _ := db.QueryLogs(ctx, models.LogFilter{}); len(logs) != 4 {
+		t.Errorf("expected preview not to delete logs, got %d remaining", len(logs))
+	}
+}
+
+func TestDeleteOldLogs_StreamRetention(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	insert := func(age time.Duration, stream, message string) {
+		db.InsertLog(ctx, &models.Log{Timestamp: time.Now().Add(-age), Service: "api", Level: "INFO",
+			Message: message, Host: "h", Stream: stream})
+	}
+	insert(40*24*time.Hour, "", "expired default")
+	insert(10*24*time.Hour, "", "kept default")
+	insert(40*24*time.Hour, "audit", "kept audit")
+	insert(400*24*time.Hour, "audit", "expired audit")
+	insert(5*24*time.Hour, "debug", "expired debug")
+	insert(5*24*time.Hour, "debug", "tagged debug")
+	db.TagLogs(ctx, models.LogFilter{Search: "tagged"}, "incident-1")
+
+	db.SetStreamRetention(map[string]time.Duration{"audit": 365 * 24 * time.Hour, "debug": 3 * 24 * time.Hour})
+	db.SetTagPolicies([]TagPolicy{{Pattern: "incident-*", Keep: 7 * 24 * time.Hour}})
+
+	preview, err := db.RetentionPreview(ctx, 30*24*time.Hour, db.TagPolicies())
+	if err != nil {
+		t.Fatalf("RetentionPreview failed: %v", err)
+	}
+	if preview.Total != 3 || preview.KeptByTags != 1 {
+		t.Errorf("unexpected preview: %+v", preview)
+	}
+
+	deleted, err := db.DeleteOldLogs(ctx, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("DeleteOldLogs failed: %v", err)
+	}
+	if deleted != 3 {
+		t.Errorf("expected 3 logs deleted, got %d", deleted)
+	}
+	logs, _ := db.QueryLogs(ctx, models.LogFilter{})
+	for _, l := range logs {
+		if !strings.HasPrefix(l.Message, "kept") && !strings.HasPrefix(l.Message, "tagged") {
+			t.Errorf("unexpected remaining log: %+v", l)
+		}
+	}
+}
+
+func TestDeleteOldRows(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	for _, age := range []time.Duration{400 * 24 * time.Hour, time.Hour} {
+		if err := db.RecordVaultReveal(ctx, models.VaultReveal{RevealedAt: time.Now().Add(-age), Client: "10.0.0.1",
+			Reason: "incident", Tokens: []string{"t"}}); err != nil {
+			t.Fatalf("RecordVaultReveal failed: %v", err)
+		}
+	}
+
+	deleted, err := db.DeleteOldRows(ctx, "vault_reveals", 365*24*time.Hour)
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected 1 deleted reveal, got %d, %v", deleted, err)
+	}
+	reveals, _ := db.VaultReveals(ctx, 10)
+	if len(reveals) != 1 {
+		t.Errorf("expected the recent reveal to remain, got %d", len(reveals))
+	}
+
+	if _, err := db.DeleteOldRows(ctx, "logs", time.Hour); err == nil {
+		t.Error("expected an error for a table without its own retention")
+	}
+}
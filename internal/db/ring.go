@@ -0,0 +1,998 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"locog/internal/models"
+)
+
+// Ring is a Store keeping the last logs in memory with no database at all,
+// for demos, development and sidecars that only need recent logs. Once it
+// holds its capacity, each new log evicts the oldest along with its tags,
+// export records and share of the filter values, so memory use stays flat.
+// Queries scan the logs under a read lock. Everything is lost on Close.
+//
+// Derived fields compile to SQL, which a Ring can't evaluate: filters on them
+// match nothing and grouping by them fails.
+type Ring struct {
+	derivedFields
+	policies
+	maintenance maintenanceTracker
+
+	mu sync.RWMutex
+	// logs holds up to max logs in ID order, a circular buffer starting at
+	// head once full
+	logs   []ringLog
+	head   int
+	max    int
+	lastID int64
+	// values counts the stored logs with each service, level and host
+	values       map[string]map[string]int64
+	sources      map[sourceKey]*models.Source
+	metadataKeys []metadataKeyRow
+	prefs        map[string]map[string]json.RawMessage
+	hostGroups   map[string][]string
+	incidents    []models.Incident
+	vault        map[string]VaultEntry
+	reveals      []models.VaultReveal
+	lastIncident int64
+	lastReveal   int64
+}
+
+// ringLog is a stored log. Its metadata has been through JSON like a
+// database's, so values have the types a database returns; readers get a
+// copy.
+type ringLog struct {
+	log      models.Log // Metadata is kept in meta
+	meta     map[string]interface{}
+	exported []string // tags the log was exported for
+}
+
+type sourceKey struct{ host, service string }
+
+// ringCheckInterval is how many logs a scan reads between checks of its
+// context, so a canceled or timed out query stops early.
+const ringCheckInterval = 4096
+
+// errNoDatabaseFile is returned for operations on a Ring that copy a database
+// file.
+var errNoDatabaseFile = errors.New("memory storage has no database file")
+
+// NewRing returns an empty Ring keeping the last maxLogs logs.
+func NewRing(maxLogs int) (*Ring, error) {
+	if maxLogs < 1 {
+		return nil, fmt.Errorf("a ring must keep at least 1 log, got %d", maxLogs)
+	}
+	return &Ring{
+		max:        maxLogs,
+		values:     map[string]map[string]int64{"service": {}, "level": {}, "host": {}},
+		sources:    make(map[sourceKey]*models.Source),
+		prefs:      make(map[string]map[string]json.RawMessage),
+		hostGroups: make(map[string][]string),
+		vault:      make(map[string]VaultEntry),
+	}, nil
+}
+
+// at returns the i-th oldest log.
+func (r *Ring) at(i int) *ringLog {
+	return &r.logs[(r.head+i)%len(r.logs)]
+}
+
+// find returns the log with the given ID, or nil.
+func (r *Ring) find(id int64) *ringLog {
+	i := sort.Search(len(r.logs), func(i int) bool { return r.at(i).log.ID >= id })
+	if i == len(r.logs) || r.at(i).log.ID != id {
+		return nil
+	}
+	return r.at(i)
+}
+
+// count adds delta to the filter values of a log, forgetting values no
+// stored log has.
+func (r *Ring) count(l *models.Log, delta int64) {
+	for _, f := range [...]struct{ column, value string }{{"service", l.Service}, {"level", l.Level}, {"host", l.Host}} {
+		values := r.values[f.column]
+		if values[f.value] += delta; values[f.value] <= 0 {
+			delete(values, f.value)
+		}
+	}
+}
+
+func (r *Ring) InsertLog(ctx context.Context, log *models.Log) error {
+	if _, err := json.Marshal(log.Metadata); err != nil {
+		return err
+	}
+	return r.InsertBatch(ctx, []models.Log{*log})
+}
+
+func (r *Ring) InsertBatch(ctx context.Context, logs []models.Log) error {
+	entries := make([]ringLog, len(logs))
+	now := time.Now().UTC()
+	for i, l := range logs {
+		e := ringLog{log: models.Log{Timestamp: l.Timestamp.UTC(), Service: l.Service, Level: l.Level,
+			Message: l.Message, Host: l.Host, CreatedAt: now, Stream: streamName(&l)}}
+		if l.Metadata != nil {
+			data, err := json.Marshal(l.Metadata)
+			if err == nil {
+				err = json.Unmarshal(data, &e.meta)
+			}
+			if err != nil {
+				// Keep the log without metadata rather than failing the batch
+				slog.Warn("failed to marshal metadata", "service", l.Service, "error", err)
+				e.meta = nil
+			}
+		}
+		entries[i] = e
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range entries {
+		r.lastID++
+		e.log.ID = r.lastID
+		if len(r.logs) < r.max {
+			r.logs = append(r.logs, e)
+		} else {
+			r.count(&r.logs[r.head].log, -1)
+			r.logs[r.head] = e
+			r.head = (r.head + 1) % len(r.logs)
+		}
+		r.count(&e.log, 1)
+
+		src := r.sources[sourceKey{e.log.Host, e.log.Service}]
+		if src == nil {
+			src = &models.Source{Host: e.log.Host, Service: e.log.Service}
+			r.sources[sourceKey{e.log.Host, e.log.Service}] = src
+		}
+		src.Logs++
+		src.LastLogAt = &now
+	}
+	return nil
+}
+
+// ringFilter is a LogFilter prepared for matching logs in memory the way
+// buildWhere matches them in SQL.
+type ringFilter struct {
+	models.LogFilter
+	re *regexp.Regexp
+}
+
+func newRingFilter(filter models.LogFilter) (*ringFilter, error) {
+	f := &ringFilter{LogFilter: filter}
+	if filter.Search != "" && filter.SearchRegex {
+		re, err := regexp.Compile(filter.Search)
+		if err != nil {
+			return nil, err
+		}
+		f.re = re
+	}
+	return f, nil
+}
+
+// matches reports whether a log satisfies every condition of the filter
+// except its ID range, which scan applies.
+func (f *ringFilter) matches(e *ringLog) bool {
+	l := &e.log
+	switch {
+	case f.Service != "" && l.Service != f.Service,
+		f.Level != "" && l.Level != f.Level,
+		len(f.Levels) > 0 && !slices.ContainsFunc(f.Levels, func(v string) bool { return strings.EqualFold(v, l.Level) }),
+		slices.ContainsFunc(f.ExcludeLevels, func(v string) bool { return strings.EqualFold(v, l.Level) }),
+		f.Host != "" && l.Host != f.Host,
+		f.Stream != "" && l.Stream != f.Stream,
+		slices.Contains(f.ExcludeServices, l.Service),
+		slices.Contains(f.ExcludeHosts, l.Host),
+		len(f.HostPatterns) > 0 && !matchesAnyGlob(f.HostPatterns, l.Host),
+		len(f.ServicePatterns) > 0 && !matchesAnyGlob(f.ServicePatterns, l.Service),
+		slices.ContainsFunc(f.ExcludeSearch, func(s string) bool { return likeMatch(l.Message, s) }),
+		slices.Contains(f.ExcludeStreams, l.Stream),
+		f.StartTime != nil && l.Timestamp.Before(*f.StartTime),
+		f.EndTime != nil && l.Timestamp.After(*f.EndTime),
+		f.re != nil && !f.re.MatchString(l.Message),
+		f.re == nil && f.Search != "" && !likeMatch(l.Message, f.Search),
+		f.Tag != "" && !slices.Contains(l.Tags, f.Tag):
+		return false
+	}
+	if c := f.Cursor; c != nil {
+		// Continue in the order sortLogs sorts by
+		if f.Ascending && !(l.Timestamp.After(c.Timestamp) || l.Timestamp.Equal(c.Timestamp) && l.ID < c.ID) ||
+			!f.Ascending && !(l.Timestamp.Before(c.Timestamp) || l.Timestamp.Equal(c.Timestamp) && l.ID > c.ID) {
+			return false
+		}
+	}
+	for _, m := range f.Meta {
+		if m.Derived || !metaMatches(e.meta, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAnyGlob reports whether s matches any of the patterns as
+// patternsClause matches them.
+func matchesAnyGlob(patterns []string, s string) bool {
+	return slices.ContainsFunc(patterns, func(p string) bool { return GlobMatch(p, s) })
+}
+
+// likeMatch reports whether s contains substr, compared
+// case-insensitively as LIKE compares.
+func likeMatch(s, substr string) bool {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// metaLookup returns the metadata value at a dotted key, or nil.
+func metaLookup(meta map[string]interface{}, key string) interface{} {
+	var v interface{} = meta
+	for _, part := range strings.Split(key, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = obj[part]
+	}
+	return v
+}
+
+// metaNumeric returns a metadata value as a number if numericGuard accepts
+// it: a JSON number or a string holding one.
+func metaNumeric(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case string:
+		n, err := strconv.ParseFloat(x, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// metaText returns a metadata value as the text CAST(... AS TEXT) gives for
+// it: strings as they are, booleans as 1 or 0, objects and arrays as JSON and
+// nothing as "".
+func metaText(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		if x {
+			return "1"
+		}
+		return "0"
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// metaMatches applies a metadata filter as metaFilterClause does: text
+// comparisons only match strings, numeric ones what numericGuard accepts,
+// and != also matches logs without the key.
+func metaMatches(meta map[string]interface{}, m models.MetaFilter) bool {
+	v := metaLookup(meta, m.Key)
+	if !m.Numeric {
+		s, ok := v.(string)
+		return (ok && s == m.Value) == (m.Op == "=")
+	}
+	n, ok := metaNumeric(v)
+	if !ok {
+		return m.Op == "!="
+	}
+	switch m.Op {
+	case "=":
+		return n == m.Number
+	case "!=":
+		return n != m.Number
+	case ">":
+		return n > m.Number
+	case ">=":
+		return n >= m.Number
+	case "<":
+		return n < m.Number
+	case "<=":
+		return n <= m.Number
+	}
+	return false
+}
+
+// cloneMeta deep copies metadata decoded from JSON.
+func cloneMeta(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, child := range x {
+			m[k] = cloneMeta(child)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(x))
+		for i, child := range x {
+			s[i] = cloneMeta(child)
+		}
+		return s
+	}
+	return v
+}
+
+// copyLog returns a copy of a stored log for a reader.
+func (e *ringLog) copyLog(omitMetadata bool) models.Log {
+	l := e.log
+	l.Tags = slices.Clone(e.log.Tags)
+	if e.meta != nil && !omitMetadata {
+		l.Metadata = cloneMeta(e.meta).(map[string]interface{})
+	}
+	return l
+}
+
+// scan calls fn with each log matching f, oldest first. The caller holds
+// r.mu.
+func (r *Ring) scan(ctx context.Context, f *ringFilter, fn func(*ringLog)) error {
+	// IDs follow insertion order, so an ID range is a range of the buffer
+	first, last := 0, len(r.logs)
+	if f.SinceID > 0 {
+		first = sort.Search(len(r.logs), func(i int) bool { return r.at(i).log.ID > f.SinceID })
+	}
+	if f.MaxID > 0 {
+		last = sort.Search(len(r.logs), func(i int) bool { return r.at(i).log.ID > f.MaxID })
+	}
+	for i := first; i < last; i++ {
+		if (i-first)%ringCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if e := r.at(i); f.matches(e) {
+			fn(e)
+		}
+	}
+	return nil
+}
+
+// matching returns the logs matching filter, oldest first. The caller holds
+// r.mu.
+func (r *Ring) matching(ctx context.Context, filter models.LogFilter) ([]*ringLog, error) {
+	f, err := newRingFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	var logs []*ringLog
+	err = r.scan(ctx, f, func(e *ringLog) { logs = append(logs, e) })
+	return logs, err
+}
+
+// sortLogs sorts logs in the order QueryLogs returns them for filter.
+func sortLogs(logs []*ringLog, filter models.LogFilter) {
+	switch {
+	case filter.SinceID > 0:
+		// Already in ID order
+	case filter.Ascending:
+		slices.SortStableFunc(logs, func(a, b *ringLog) int {
+			return cmp.Or(a.log.Timestamp.Compare(b.log.Timestamp), cmp.Compare(b.log.ID, a.log.ID))
+		})
+	default:
+		slices.SortStableFunc(logs, func(a, b *ringLog) int {
+			return cmp.Or(b.log.Timestamp.Compare(a.log.Timestamp), cmp.Compare(a.log.ID, b.log.ID))
+		})
+	}
+}
+
+func (r *Ring) QueryLogs(ctx context.Context, filter models.LogFilter) ([]models.Log, error) {
+	var logs []models.Log
+	err := r.EachLog(ctx, filter, func(l models.Log) error {
+		logs = append(logs, l)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// EachLog calls fn with each log QueryLogs would return, in order. The logs
+// are copied first, so fn doesn't hold up ingest.
+func (r *Ring) EachLog(ctx context.Context, filter models.LogFilter, fn func(models.Log) error) error {
+	r.mu.RLock()
+	matched, err := r.matching(ctx, filter)
+	if err != nil {
+		r.mu.RUnlock()
+		return err
+	}
+	sortLogs(matched, filter)
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	logs := make([]models.Log, 0, min(limit, len(matched)))
+	for _, e := range matched[:min(limit, len(matched))] {
+		logs = append(logs, e.copyLog(filter.OmitMetadata))
+	}
+	r.mu.RUnlock()
+
+	for _, l := range logs {
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetLog returns the log with the given ID, or nil if there is none.
+func (r *Ring) GetLog(ctx context.Context, id int64) (*models.Log, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if e := r.find(id); e != nil {
+		l := e.copyLog(false)
+		return &l, nil
+	}
+	return nil, nil
+}
+
+// LogContext returns up to before logs preceding l and up to after logs
+// following it among those matching filter, each oldest first, ordered as
+// DB.LogContext orders them.
+func (r *Ring) LogContext(ctx context.Context, filter models.LogFilter, l models.Log, before, after int) ([]models.Log, []models.Log, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	matched, err := r.matching(ctx, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	slices.SortFunc(matched, func(a, b *ringLog) int {
+		return cmp.Or(a.log.Timestamp.Compare(b.log.Timestamp), cmp.Compare(a.log.ID, b.log.ID))
+	})
+	// The first log after l
+	i, _ := slices.BinarySearchFunc(matched, l, func(e *ringLog, l models.Log) int {
+		return cmp.Or(e.log.Timestamp.Compare(l.Timestamp), cmp.Compare(e.log.ID, l.ID))
+	})
+	j := i
+	if j < len(matched) && matched[j].log.ID == l.ID {
+		j++
+	}
+
+	preceding := []models.Log{}
+	for _, e := range matched[max(0, i-before):i] {
+		preceding = append(preceding, e.copyLog(false))
+	}
+	following := []models.Log{}
+	for _, e := range matched[j:min(len(matched), j+max(0, after))] {
+		following = append(following, e.copyLog(false))
+	}
+	return preceding, following, nil
+}
+
+// MaxLogID returns the highest log ID, or 0 when there are no logs.
+func (r *Ring) MaxLogID(ctx context.Context) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.logs) == 0 {
+		return 0, nil
+	}
+	return r.at(len(r.logs) - 1).log.ID, nil
+}
+
+// CountLogs returns the number of logs matching filter, ignoring its limit.
+func (r *Ring) CountLogs(ctx context.Context, filter models.LogFilter) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	matched, err := r.matching(ctx, filter)
+	return int64(len(matched)), err
+}
+
+// CountLogsUpTo is CountLogs capped at max.
+func (r *Ring) CountLogsUpTo(ctx context.Context, filter models.LogFilter, max int64) (int64, error) {
+	n, err := r.CountLogs(ctx, filter)
+	return min(n, max), err
+}
+
+// EstimateLogs counts the logs matching filter exactly, since every query
+// scans the whole ring anyway.
+func (r *Ring) EstimateLogs(ctx context.Context, filter models.LogFilter) (models.QueryEstimate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	matched, err := r.matching(ctx, filter)
+	if err != nil {
+		return models.QueryEstimate{}, err
+	}
+	return models.QueryEstimate{
+		EstimatedRows: int64(len(matched)),
+		ScannedRows:   int64(len(r.logs)),
+		TotalRows:     int64(len(r.logs)),
+		FullScan:      true,
+		Plan:          []string{"SCAN ring buffer"},
+	}, nil
+}
+
+// GetFilterOptions returns the services, levels and hosts of the stored
+// logs, sorted, up to 100 of each as the database returns.
+func (r *Ring) GetFilterOptions(ctx context.Context) (models.FilterOptions, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sorted := func(column string) []string {
+		values := slices.Sorted(maps.Keys(r.values[column]))
+		return values[:min(len(values), 100)]
+	}
+	return models.FilterOptions{Services: sorted("service"), Levels: sorted("level"), Hosts: sorted("host")}, nil
+}
+
+// SuggestFilterValues returns up to limit values of column starting with
+// prefix, compared case-insensitively, most frequent first.
+func (r *Ring) SuggestFilterValues(ctx context.Context, column, prefix string, limit int) ([]string, error) {
+	if !allowedFilterColumns[column] {
+		return nil, fmt.Errorf("invalid column name: %s", column)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	counts := r.values[column]
+	values := []string{}
+	for v := range counts {
+		if len(v) >= len(prefix) && strings.EqualFold(v[:len(prefix)], prefix) {
+			values = append(values, v)
+		}
+	}
+	slices.SortFunc(values, func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+	})
+	return values[:min(len(values), max(limit, 0))], nil
+}
+
+// Histogram counts logs matching filter in equal-width buckets between start
+// and end. The filter's own time range is ignored in favour of start/end.
+func (r *Ring) Histogram(ctx context.Context, filter models.LogFilter, start, end time.Time, buckets int) ([]int64, error) {
+	if buckets <= 0 {
+		return nil, fmt.Errorf("buckets must be positive, got %d", buckets)
+	}
+	counts := make([]int64, buckets)
+	if !end.After(start) {
+		return counts, nil
+	}
+	filter.StartTime, filter.EndTime = &start, &end
+	width := end.Sub(start).Seconds() / float64(buckets)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	matched, err := r.matching(ctx, filter)
+	for _, e := range matched {
+		// The end bound is inclusive, so a log exactly at end lands one past the last bucket
+		counts[min(int(e.log.Timestamp.Sub(start).Seconds()/width), buckets-1)]++
+	}
+	return counts, err
+}
+
+// ringGroup returns the function giving the group of a log for groupBy, as
+// groupByExpr does in SQL.
+func (r *Ring) ringGroup(groupBy string) (func(*ringLog) string, error) {
+	if groupBy == "" {
+		return func(*ringLog) string { return "" }, nil
+	}
+	if name, ok := strings.CutPrefix(groupBy, "derived."); ok {
+		if _, ok := r.derivedSQL(name); ok {
+			return nil, fmt.Errorf("derived field %s needs SQL storage", name)
+		}
+		return nil, fmt.Errorf("unknown derived field: %s", name)
+	}
+	if key, ok := strings.CutPrefix(groupBy, "metadata."); ok {
+		return func(e *ringLog) string { return metaText(metaLookup(e.meta, key)) }, nil
+	}
+	switch groupBy {
+	case "service":
+		return func(e *ringLog) string { return e.log.Service }, nil
+	case "level":
+		return func(e *ringLog) string { return e.log.Level }, nil
+	case "host":
+		return func(e *ringLog) string { return e.log.Host }, nil
+	}
+	return nil, fmt.Errorf("invalid group by column: %s", groupBy)
+}
+
+// ringBucket returns the function giving the Unix time of the start of a
+// log's bucket, as bucketExpr does in SQL.
+func ringBucket(bucket time.Duration) (func(*ringLog) int64, error) {
+	if bucket <= 0 {
+		return func(*ringLog) int64 { return 0 }, nil
+	}
+	secs := int64(bucket.Seconds())
+	if secs < 1 {
+		return nil, fmt.Errorf("bucket must be at least 1s, got %s", bucket)
+	}
+	return func(e *ringLog) int64 { return e.log.Timestamp.Unix() / secs * secs }, nil
+}
+
+type groupBucket struct {
+	group  string
+	bucket int64
+}
+
+// AggregateMetadata computes count/avg/min/max and percentiles of a numeric
+// metadata field over logs matching filter, grouped by groupBy and
+// optionally by fixed-width time buckets. Non-numeric values are ignored.
+func (r *Ring) AggregateMetadata(ctx context.Context, filter models.LogFilter, field, groupBy string, bucket time.Duration) ([]models.MetricAggregate, error) {
+	group, err := r.ringGroup(groupBy)
+	if err != nil {
+		return nil, err
+	}
+	bucketOf, err := ringBucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[groupBucket][]float64)
+	r.mu.RLock()
+	matched, err := r.matching(ctx, filter)
+	for _, e := range matched {
+		if v, ok := metaNumeric(metaLookup(e.meta, field)); ok {
+			k := groupBucket{group(e), bucketOf(e)}
+			values[k] = append(values[k], v)
+		}
+	}
+	r.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := slices.SortedFunc(maps.Keys(values), func(a, b groupBucket) int {
+		return cmp.Or(cmp.Compare(a.group, b.group), cmp.Compare(a.bucket, b.bucket))
+	})
+	var results []models.MetricAggregate
+	for _, k := range keys {
+		slices.Sort(values[k])
+		agg := summarize(values[k])
+		agg.Group = k.group
+		if bucket > 0 {
+			t := time.Unix(k.bucket, 0).UTC()
+			agg.Bucket = &t
+		}
+		results = append(results, agg)
+	}
+	return results, nil
+}
+
+// CountLogsByGroup counts the logs matching filter per group, most frequent
+// first, keeping the limit largest groups, optionally split by time bucket
+// as DB.CountLogsByGroup does.
+func (r *Ring) CountLogsByGroup(ctx context.Context, filter models.LogFilter, groupBy string, bucket time.Duration, limit int) ([]models.GroupCount, error) {
+	group, err := r.ringGroup(groupBy)
+	if err != nil {
+		return nil, err
+	}
+	bucketOf, err := ringBucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]int64)
+	counts := make(map[groupBucket]int64)
+	r.mu.RLock()
+	matched, err := r.matching(ctx, filter)
+	for _, e := range matched {
+		g := group(e)
+		totals[g]++
+		if bucket > 0 {
+			counts[groupBucket{g, bucketOf(e)}]++
+		}
+	}
+	r.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := slices.SortedFunc(maps.Keys(totals), func(a, b string) int {
+		return cmp.Or(cmp.Compare(totals[b], totals[a]), cmp.Compare(a, b))
+	})
+	groups = groups[:min(len(groups), max(limit, 0))]
+	if bucket <= 0 {
+		var results []models.GroupCount
+		for _, g := range groups {
+			results = append(results, models.GroupCount{Group: g, Count: totals[g]})
+		}
+		return results, nil
+	}
+
+	rank := make(map[string]int, len(groups))
+	for i, g := range groups {
+		rank[g] = i
+	}
+	var results []models.GroupCount
+	for k, n := range counts {
+		if _, ok := rank[k.group]; ok {
+			t := time.Unix(k.bucket, 0).UTC()
+			results = append(results, models.GroupCount{Group: k.group, Bucket: &t, Count: n})
+		}
+	}
+	slices.SortFunc(results, func(a, b models.GroupCount) int {
+		return cmp.Or(cmp.Compare(rank[a.Group], rank[b.Group]), a.Bucket.Compare(*b.Bucket))
+	})
+	return results, nil
+}
+
+// Availability counts the logs and error logs of each service matching
+// filter in buckets of width from start, as DB.Availability does.
+func (r *Ring) Availability(ctx context.Context, filter models.LogFilter, errorLevels []string, start time.Time, width time.Duration, buckets int) ([]models.ServiceAvailability, error) {
+	end := start.Add(time.Duration(buckets) * width)
+	filter.StartTime, filter.EndTime = &start, &end
+
+	byService := make(map[string]*models.ServiceAvailability)
+	r.mu.RLock()
+	matched, err := r.matching(ctx, filter)
+	for _, e := range matched {
+		// The end bound is inclusive, so a log exactly at end lands one past the last bucket
+		b := min(int(e.log.Timestamp.Sub(start)/width), buckets-1)
+		sa := byService[e.log.Service]
+		if sa == nil {
+			sa = &models.ServiceAvailability{Service: e.log.Service, Buckets: make([]models.AvailabilityBucket, buckets)}
+			for i := range sa.Buckets {
+				sa.Buckets[i].Start = start.Add(time.Duration(i) * width)
+			}
+			byService[e.log.Service] = sa
+		}
+		isError := int64(0)
+		if slices.ContainsFunc(errorLevels, func(v string) bool { return strings.EqualFold(v, e.log.Level) }) {
+			isError = 1
+		}
+		sa.Total++
+		sa.Errors += isError
+		sa.Buckets[b].Total++
+		sa.Buckets[b].Errors += isError
+	}
+	r.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var services []models.ServiceAvailability
+	for _, name := range slices.Sorted(maps.Keys(byService)) {
+		sa := byService[name]
+		sa.Availability = availability(sa.Total, sa.Errors)
+		for j := range sa.Buckets {
+			sa.Buckets[j].Availability = availability(sa.Buckets[j].Total, sa.Buckets[j].Errors)
+		}
+		services = append(services, *sa)
+	}
+	return services, nil
+}
+
+// messageCounts counts the logs matching filter by message and version (see
+// VersionKeys) if versions is set, keeping the max most frequent.
+func (r *Ring) messageCounts(ctx context.Context, filter models.LogFilter, versions bool, max int) ([]messageCount, error) {
+	type key struct{ message, version string }
+	byKey := make(map[key]int64)
+	r.mu.RLock()
+	matched, err := r.matching(ctx, filter)
+	for _, e := range matched {
+		k := key{message: e.log.Message}
+		for _, name := range VersionKeys {
+			if v := metaLookup(e.meta, name); versions && v != nil {
+				k.version = metaText(v)
+				break
+			}
+		}
+		byKey[k]++
+	}
+	r.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]messageCount, 0, len(byKey))
+	for k, n := range byKey {
+		counts = append(counts, messageCount{message: k.message, version: k.version, count: n})
+	}
+	slices.SortFunc(counts, func(a, b messageCount) int {
+		return cmp.Or(cmp.Compare(b.count, a.count), cmp.Compare(a.message, b.message), cmp.Compare(a.version, b.version))
+	})
+	return counts[:min(len(counts), max)], nil
+}
+
+// MessageTemplates counts the messages matching filter by template, most
+// frequent first, considering only the maxMessages most frequent messages.
+func (r *Ring) MessageTemplates(ctx context.Context, filter models.LogFilter, maxMessages int) ([]models.TemplateCount, error) {
+	counts, err := r.messageCounts(ctx, filter, false, maxMessages)
+	if err != nil {
+		return nil, err
+	}
+	return countTemplates(counts), nil
+}
+
+// TopErrors groups the logs matching filter by message template and version
+// as DB.TopErrors does.
+func (r *Ring) TopErrors(ctx context.Context, filter models.LogFilter, maxMessages int) ([]models.ErrorGroup, error) {
+	counts, err := r.messageCounts(ctx, filter, true, maxMessages)
+	if err != nil {
+		return nil, err
+	}
+	return groupErrors(counts), nil
+}
+
+// RefreshRollups does nothing: LogStats counts the logs themselves.
+func (r *Ring) RefreshRollups(ctx context.Context, now time.Time) error {
+	return nil
+}
+
+// LogStats counts the logs matching filter per bucket of size, grouped by
+// groupBy, taking the same arguments as DB.LogStats. The counts are exact
+// and reach filter.EndTime.
+func (r *Ring) LogStats(ctx context.Context, filter models.LogFilter, size time.Duration, groupBy string) (models.LogStats, error) {
+	cfg := r.Rollups()
+	stats := models.LogStats{BucketSeconds: size.Seconds(), GroupBy: groupBy, Buckets: []models.StatsBucket{}}
+	if !slices.Contains(cfg.Sizes, size) {
+		return stats, fmt.Errorf("no rollups of %s", size)
+	}
+	if groupBy != "" && !slices.Contains(rollupGroups, groupBy) {
+		return stats, fmt.Errorf("can't group by %q; use %s", groupBy, strings.Join(rollupGroups, ", "))
+	}
+	if !RollupFilter(filter) || filter.StartTime == nil || filter.EndTime == nil {
+		return stats, fmt.Errorf("rollups need a time range and only filter by service, level, host and stream")
+	}
+
+	start, end := filter.StartTime.UTC().Truncate(size), filter.EndTime.UTC()
+	stats.RolledUntil = &end
+	if !start.Before(end) {
+		return stats, nil
+	}
+	n := int((end.Sub(start) + size - 1) / size)
+	stats.Buckets = make([]models.StatsBucket, n)
+	for i := range stats.Buckets {
+		stats.Buckets[i].Start = start.Add(time.Duration(i) * size)
+	}
+
+	filter.StartTime = &start
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	matched, err := r.matching(ctx, filter)
+	for _, e := range matched {
+		i := int(e.log.Timestamp.Sub(start) / size)
+		if i >= n {
+			continue
+		}
+		b := &stats.Buckets[i]
+		b.Count++
+		if groupBy != "" {
+			if b.Groups == nil {
+				b.Groups = make(map[string]int64)
+			}
+			b.Groups[ringColumn(&e.log, groupBy)]++
+		}
+	}
+	return stats, err
+}
+
+// ringColumn returns a log's service, level, host or stream.
+func ringColumn(l *models.Log, column string) string {
+	switch column {
+	case "service":
+		return l.Service
+	case "level":
+		return l.Level
+	case "host":
+		return l.Host
+	}
+	return l.Stream
+}
+
+// RefreshMetadataKeys rebuilds the metadata keys report from logs newer than
+// since, as DB.RefreshMetadataKeys does.
+func (r *Ring) RefreshMetadataKeys(ctx context.Context, since time.Time) error {
+	type key struct{ service, key, kind string }
+	rows := make(map[key]*metadataKeyRow)
+	totals := make(map[string]int64)
+	now := time.Now()
+
+	r.mu.RLock()
+	err := r.scan(ctx, &ringFilter{LogFilter: models.LogFilter{StartTime: &since}}, func(e *ringLog) {
+		totals[e.log.Service]++
+		walkMeta("", e.meta, func(path string, v interface{}) {
+			kind, example := metaKind(v)
+			k := key{e.log.Service, path, kind}
+			row := rows[k]
+			if row == nil {
+				row = &metadataKeyRow{service: k.service, key: k.key, kind: kind, generatedAt: now}
+				rows[k] = row
+			}
+			row.count++
+			if e.log.Timestamp.After(row.lastSeen) {
+				row.lastSeen = e.log.Timestamp
+			}
+			if example == nil {
+				return
+			}
+			if !row.exampleMin.Valid || *example < row.exampleMin.String {
+				row.exampleMin.String, row.exampleMin.Valid = *example, true
+			}
+			if !row.exampleMax.Valid || *example > row.exampleMax.String {
+				row.exampleMax.String, row.exampleMax.Valid = *example, true
+			}
+		})
+	})
+	r.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	report := make([]metadataKeyRow, 0, len(rows))
+	for _, row := range rows {
+		row.serviceTotal = totals[row.service]
+		report = append(report, *row)
+	}
+	slices.SortFunc(report, func(a, b metadataKeyRow) int {
+		return cmp.Or(cmp.Compare(a.service, b.service), cmp.Compare(a.key, b.key), cmp.Compare(a.kind, b.kind))
+	})
+	r.mu.Lock()
+	r.metadataKeys = report
+	r.mu.Unlock()
+	return nil
+}
+
+// walkMeta calls fn with the dotted path and value of every leaf of
+// metadata, arrays included whole, as the metadataKeys walk reports them.
+func walkMeta(prefix string, v interface{}, fn func(path string, v interface{})) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		if prefix != "" {
+			fn(prefix, v)
+		}
+		return
+	}
+	for k, child := range obj {
+		path := strings.ReplaceAll(k, `"`, "")
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		walkMeta(path, child, fn)
+	}
+}
+
+// metaKind returns the JSON type of a metadata value as the report names it
+// and its example text, truncated to maxMetadataExampleLen characters, or
+// nil for null.
+func metaKind(v interface{}) (string, *string) {
+	var kind string
+	switch v.(type) {
+	case nil:
+		return "null", nil
+	case string:
+		kind = "string"
+	case float64:
+		kind = "number"
+	case bool:
+		kind = "boolean"
+	case []interface{}:
+		kind = "array"
+	}
+	example := metaText(v)
+	if utf8.RuneCountInString(example) > maxMetadataExampleLen {
+		example = string([]rune(example)[:maxMetadataExampleLen])
+	}
+	return kind, &example
+}
+
+// MetadataKeys returns the latest metadata keys report, optionally limited to
+// one service. GeneratedAt is nil until the report has been built.
+func (r *Ring) MetadataKeys(ctx context.Context, service string) (models.MetadataKeysReport, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rows := r.metadataKeys
+	if service != "" {
+		rows = slices.DeleteFunc(slices.Clone(rows), func(row metadataKeyRow) bool { return row.service != service })
+	}
+	return metadataKeysReport(rows), nil
+}
@@ -0,0 +1,636 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TagLogs adds tag to every log matching filter (its limit is ignored) and
+// returns the number of logs newly tagged.
+func (r *Ring) TagLogs(ctx context.Context, filter models.LogFilter, tag string) (int64, error) {
+	f, err := newRingFilter(filter)
+	if err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	err = r.scan(ctx, f, func(e *ringLog) {
+		if !slices.Contains(e.log.Tags, tag) {
+			e.log.Tags = append(e.log.Tags, tag)
+			slices.Sort(e.log.Tags)
+			n++
+		}
+	})
+	return n, err
+}
+
+// UntagLogs removes tag from every log matching filter and returns the number
+// of logs it was removed from.
+func (r *Ring) UntagLogs(ctx context.Context, filter models.LogFilter, tag string) (int64, error) {
+	f, err := newRingFilter(filter)
+	if err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	err = r.scan(ctx, f, func(e *ringLog) {
+		if i := slices.Index(e.log.Tags, tag); i >= 0 {
+			e.log.Tags = slices.Delete(e.log.Tags, i, i+1)
+			if len(e.log.Tags) == 0 {
+				e.log.Tags = nil
+			}
+			n++
+		}
+	})
+	return n, err
+}
+
+// PendingTagExports returns up to limit logs carrying a tag matching pattern
+// that have not yet been exported for that tag, oldest first.
+func (r *Ring) PendingTagExports(ctx context.Context, pattern string, limit int) ([]TagExport, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var exports []TagExport
+	for i := range r.logs {
+		e := r.at(i)
+		for _, tag := range e.log.Tags {
+			if GlobMatch(pattern, tag) && !slices.Contains(e.exported, tag) {
+				exports = append(exports, TagExport{Tag: tag, Log: e.copyLog(false)})
+			}
+		}
+	}
+	slices.SortStableFunc(exports, func(a, b TagExport) int {
+		return cmp.Or(a.Log.Timestamp.Compare(b.Log.Timestamp), cmp.Compare(a.Log.ID, b.Log.ID))
+	})
+	return exports[:min(len(exports), max(limit, 0))], nil
+}
+
+// MarkTagsExported records that the given logs were exported for their tags.
+func (r *Ring) MarkTagsExported(ctx context.Context, exports []TagExport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, x := range exports {
+		if e := r.find(x.Log.ID); e != nil && !slices.Contains(e.exported, x.Tag) {
+			e.exported = append(e.exported, x.Tag)
+		}
+	}
+	return nil
+}
+
+// TagExportCounts returns the export counts of every tag matching pattern
+// that is on a log or has been exported. Export records go with their logs,
+// so Exported only counts exports of logs still stored.
+func (r *Ring) TagExportCounts(ctx context.Context, pattern string) (map[string]TagExportCount, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	counts := make(map[string]TagExportCount)
+	for i := range r.logs {
+		e := r.at(i)
+		for _, tag := range e.exported {
+			if GlobMatch(pattern, tag) {
+				c := counts[tag]
+				c.Exported++
+				counts[tag] = c
+			}
+		}
+		for _, tag := range e.log.Tags {
+			if GlobMatch(pattern, tag) && !slices.Contains(e.exported, tag) {
+				c := counts[tag]
+				c.Pending++
+				counts[tag] = c
+			}
+		}
+	}
+	return counts, nil
+}
+
+// ringRetention is a retention cleanup applied to logs in memory, as
+// retentionRule is in SQL.
+type ringRetention struct {
+	cutoff    time.Time            // for streams without their own retention
+	streams   map[string]time.Time // per-stream cutoffs
+	tags      []tagCutoff          // tag policies keeping logs longer
+	holds     []string
+	incidents []models.Incident
+	oldest    time.Time // oldest timestamp any log may be kept from
+}
+
+type tagCutoff struct {
+	pattern string
+	cutoff  time.Time
+}
+
+// newRingRetention builds the cleanup buildRetentionRule would for the same
+// settings.
+func newRingRetention(now time.Time, olderThan time.Duration, streams map[string]time.Duration, policies []TagPolicy, holds []string, incidents []models.Incident) ringRetention {
+	rule := ringRetention{cutoff: now.Add(-olderThan), streams: make(map[string]time.Time), holds: holds, oldest: now.Add(-olderThan)}
+	shortest := olderThan
+	for name, keep := range streams {
+		rule.streams[name] = now.Add(-keep)
+		if rule.streams[name].Before(rule.oldest) {
+			rule.oldest = rule.streams[name]
+		}
+		shortest = min(shortest, keep)
+	}
+	for _, p := range policies {
+		if p.Keep <= shortest {
+			continue
+		}
+		rule.tags = append(rule.tags, tagCutoff{p.Pattern, now.Add(-p.Keep)})
+		if cutoff := now.Add(-p.Keep); cutoff.Before(rule.oldest) {
+			rule.oldest = cutoff
+		}
+	}
+	for _, inc := range incidents {
+		if len(inc.Services) > 0 {
+			rule.incidents = append(rule.incidents, inc)
+		}
+		if inc.StartedAt.Before(rule.oldest) {
+			rule.oldest = inc.StartedAt
+		}
+	}
+	return rule
+}
+
+// expired reports whether a log is older than its stream's retention.
+func (rule ringRetention) expired(l *models.Log) bool {
+	cutoff, ok := rule.streams[l.Stream]
+	if !ok {
+		cutoff = rule.cutoff
+	}
+	return l.Timestamp.Before(cutoff)
+}
+
+// keptByTags reports whether a tag policy or archive hold keeps a log.
+func (rule ringRetention) keptByTags(l *models.Log) bool {
+	for _, tag := range l.Tags {
+		if slices.Contains(rule.holds, tag) {
+			return true
+		}
+		for _, p := range rule.tags {
+			if GlobMatch(p.pattern, tag) && !l.Timestamp.Before(p.cutoff) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keptByIncidents reports whether an incident keeps a log.
+func (rule ringRetention) keptByIncidents(l *models.Log) bool {
+	return slices.ContainsFunc(rule.incidents, func(inc models.Incident) bool {
+		return slices.Contains(inc.Services, l.Service) && !l.Timestamp.Before(inc.StartedAt) && l.Timestamp.Before(inc.EndsAt)
+	})
+}
+
+// deletes reports whether the cleanup deletes a log.
+func (rule ringRetention) deletes(l *models.Log) bool {
+	return rule.expired(l) && !rule.keptByTags(l) && !rule.keptByIncidents(l)
+}
+
+// DeleteOldLogs deletes logs older than olderThan, or their stream's
+// retention, except those kept longer by a tag policy or an incident, as
+// DB.DeleteOldLogs does. Most logs are evicted by newer ones first.
+func (r *Ring) DeleteOldLogs(ctx context.Context, olderThan time.Duration) (int64, error) {
+	now := time.Now()
+	incidents, err := r.Incidents(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	rule := newRingRetention(now, olderThan, r.StreamRetention(), r.TagPolicies(), r.ArchiveHolds(), incidents)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := make([]ringLog, 0, len(r.logs))
+	for i := range r.logs {
+		if e := r.at(i); rule.deletes(&e.log) {
+			r.count(&e.log, -1)
+		} else {
+			kept = append(kept, *e)
+		}
+	}
+	deleted := int64(len(r.logs) - len(kept))
+	r.logs, r.head = kept, 0
+	r.maintenance.deleted(deleted)
+
+	// Vault entries outlive their logs until past the oldest retained log,
+	// since entries don't record which log references them
+	maps.DeleteFunc(r.vault, func(_ string, e VaultEntry) bool { return e.CreatedAt.Before(rule.oldest) })
+
+	// Forget sources silent for longer than any log is kept
+	maps.DeleteFunc(r.sources, func(_ sourceKey, src *models.Source) bool {
+		return lastSeen(src).Before(rule.oldest)
+	})
+
+	r.incidents = slices.DeleteFunc(r.incidents, func(inc models.Incident) bool { return !inc.KeepUntil.After(now) })
+	return deleted, nil
+}
+
+// RetentionPreview reports what DeleteOldLogs(olderThan) would delete if the
+// given tag policies were in effect, without deleting anything.
+func (r *Ring) RetentionPreview(ctx context.Context, olderThan time.Duration, policies []TagPolicy) (models.RetentionPreview, error) {
+	now := time.Now()
+	preview := models.RetentionPreview{
+		Cutoff: now.Add(-olderThan).UTC(),
+		Groups: []models.RetentionGroup{},
+	}
+	incidents, err := r.Incidents(ctx, now)
+	if err != nil {
+		return preview, err
+	}
+	rule := newRingRetention(now, olderThan, r.StreamRetention(), policies, r.ArchiveHolds(), incidents)
+
+	type key struct{ stream, service, level string }
+	counts := make(map[key]int64)
+	r.mu.RLock()
+	for i := range r.logs {
+		l := &r.at(i).log
+		if !rule.expired(l) {
+			continue
+		}
+		tags, incident := rule.keptByTags(l), rule.keptByIncidents(l)
+		if tags {
+			preview.KeptByTags++
+		}
+		if incident {
+			preview.KeptByIncidents++
+		}
+		if !tags && !incident {
+			counts[key{l.Stream, l.Service, l.Level}]++
+		}
+	}
+	r.mu.RUnlock()
+
+	for k, n := range counts {
+		preview.Groups = append(preview.Groups, models.RetentionGroup{Stream: k.stream, Service: k.service, Level: k.level, Count: n})
+		preview.Total += n
+	}
+	slices.SortFunc(preview.Groups, func(a, b models.RetentionGroup) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Stream, b.Stream),
+			cmp.Compare(a.Service, b.Service), cmp.Compare(a.Level, b.Level))
+	})
+	return preview, nil
+}
+
+// DeleteOldRows deletes the rows of an auxiliary table older than olderThan
+// and returns how many were deleted.
+func (r *Ring) DeleteOldRows(ctx context.Context, table string, olderThan time.Duration) (int64, error) {
+	if _, ok := retainedTables[table]; !ok {
+		return 0, fmt.Errorf("table %q has no retention of its own; use one of %s", table, strings.Join(RetainedTables(), ", "))
+	}
+	cutoff := time.Now().Add(-olderThan)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(r.reveals)
+	r.reveals = slices.DeleteFunc(r.reveals, func(v models.VaultReveal) bool { return v.RevealedAt.Before(cutoff) })
+	return int64(n - len(r.reveals)), nil
+}
+
+// CountOldRows counts the rows of an auxiliary table DeleteOldRows would
+// delete.
+func (r *Ring) CountOldRows(ctx context.Context, table string, olderThan time.Duration) (int64, error) {
+	if _, ok := retainedTables[table]; !ok {
+		return 0, fmt.Errorf("table %q has no retention of its own; use one of %s", table, strings.Join(RetainedTables(), ", "))
+	}
+	cutoff := time.Now().Add(-olderThan)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var n int64
+	for _, v := range r.reveals {
+		if v.RevealedAt.Before(cutoff) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// StartIncident stores an incident and sets its ID.
+func (r *Ring) StartIncident(ctx context.Context, inc *models.Incident) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastIncident++
+	inc.ID = r.lastIncident
+	stored := *inc
+	stored.Services = slices.Clone(inc.Services)
+	stored.StartedAt, stored.EndsAt, stored.KeepUntil = inc.StartedAt.UTC(), inc.EndsAt.UTC(), inc.KeepUntil.UTC()
+	r.incidents = append(r.incidents, stored)
+	return nil
+}
+
+// EndIncident ends an incident at now if it is still active, moving its
+// KeepUntil forward by as much as its end, and returns it. ok is false when
+// there is no incident with the ID.
+func (r *Ring) EndIncident(ctx context.Context, id int64, now time.Time) (models.Incident, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := slices.IndexFunc(r.incidents, func(inc models.Incident) bool { return inc.ID == id })
+	if i < 0 {
+		return models.Incident{}, false, nil
+	}
+	inc := &r.incidents[i]
+	if inc.EndsAt.After(now) {
+		inc.KeepUntil = now.Add(inc.KeepUntil.Sub(inc.EndsAt)).UTC()
+		inc.EndsAt = now.UTC()
+	}
+	ended := *inc
+	ended.Services = slices.Clone(inc.Services)
+	return ended, true, nil
+}
+
+// Incidents returns the incidents still keeping logs at now, newest first.
+func (r *Ring) Incidents(ctx context.Context, now time.Time) ([]models.Incident, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	incidents := []models.Incident{}
+	for _, inc := range r.incidents {
+		if inc.KeepUntil.After(now) {
+			inc.Services = slices.Clone(inc.Services)
+			incidents = append(incidents, inc)
+		}
+	}
+	slices.SortFunc(incidents, func(a, b models.Incident) int {
+		return cmp.Or(b.StartedAt.Compare(a.StartedAt), cmp.Compare(b.ID, a.ID))
+	})
+	return incidents, nil
+}
+
+// RecordHeartbeat notes a heartbeat from a shipper; service may be empty.
+func (r *Ring) RecordHeartbeat(ctx context.Context, host, service string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	src := r.sources[sourceKey{host, service}]
+	if src == nil {
+		src = &models.Source{Host: host, Service: service}
+		r.sources[sourceKey{host, service}] = src
+	}
+	at = at.UTC()
+	src.LastHeartbeatAt = &at
+	return nil
+}
+
+// Sources returns every known source, ordered by host and service. LastSeen
+// is the later of the last log and heartbeat; QuietSeconds is left to the
+// caller.
+func (r *Ring) Sources(ctx context.Context) ([]models.Source, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sources := []models.Source{}
+	for _, src := range r.sources {
+		s := *src
+		s.LastSeen = lastSeen(src)
+		sources = append(sources, s)
+	}
+	slices.SortFunc(sources, func(a, b models.Source) int {
+		return cmp.Or(cmp.Compare(a.Host, b.Host), cmp.Compare(a.Service, b.Service))
+	})
+	return sources, nil
+}
+
+// lastSeen returns the later of a source's last log and heartbeat.
+func lastSeen(src *models.Source) time.Time {
+	var last time.Time
+	for _, t := range []*time.Time{src.LastLogAt, src.LastHeartbeatAt} {
+		if t != nil && t.After(last) {
+			last = *t
+		}
+	}
+	return last
+}
+
+// LastLogAt returns when logs from service on host were last ingested, an
+// empty service or host matching any, or false when none have been.
+func (r *Ring) LastLogAt(ctx context.Context, service, host string) (time.Time, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var last time.Time
+	found := false
+	for k, src := range r.sources {
+		if src.LastLogAt == nil || service != "" && k.service != service || host != "" && k.host != host {
+			continue
+		}
+		if !found || src.LastLogAt.After(last) {
+			last, found = *src.LastLogAt, true
+		}
+	}
+	return last, found, nil
+}
+
+// Prefs returns all preferences stored for user, keyed by name.
+func (r *Ring) Prefs(ctx context.Context, user string) (map[string]json.RawMessage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	prefs := make(map[string]json.RawMessage)
+	for key, value := range r.prefs[user] {
+		prefs[key] = slices.Clone(value)
+	}
+	return prefs, nil
+}
+
+// Pref returns a single preference, or nil if it is not set.
+func (r *Ring) Pref(ctx context.Context, user, key string) (json.RawMessage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.prefs[user][key]), nil
+}
+
+// SetPref stores a preference, replacing any previous value. value must be
+// valid JSON.
+func (r *Ring) SetPref(ctx context.Context, user, key string, value json.RawMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.prefs[user] == nil {
+		r.prefs[user] = make(map[string]json.RawMessage)
+	}
+	r.prefs[user][key] = slices.Clone(value)
+	return nil
+}
+
+// DeletePref removes a preference and reports whether it existed.
+func (r *Ring) DeletePref(ctx context.Context, user, key string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.prefs[user][key]
+	delete(r.prefs[user], key)
+	return ok, nil
+}
+
+// HostGroups returns the host groups stored via the API, keyed by name.
+func (r *Ring) HostGroups(ctx context.Context) (map[string][]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	groups := make(map[string][]string, len(r.hostGroups))
+	for name, hosts := range r.hostGroups {
+		groups[name] = slices.Clone(hosts)
+	}
+	return groups, nil
+}
+
+// SetHostGroup stores a host group, replacing any previous members.
+func (r *Ring) SetHostGroup(ctx context.Context, name string, hosts []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hostGroups[name] = slices.Clone(hosts)
+	return nil
+}
+
+// DeleteHostGroup removes a stored host group and reports whether it existed.
+func (r *Ring) DeleteHostGroup(ctx context.Context, name string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.hostGroups[name]
+	delete(r.hostGroups, name)
+	return ok, nil
+}
+
+// StoreVaultEntries saves encrypted originals of redacted values. Entries are
+// pruned once older than any log retention keeps (see DeleteOldLogs).
+func (r *Ring) StoreVaultEntries(ctx context.Context, entries []VaultEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range entries {
+		if _, ok := r.vault[e.Token]; ok {
+			return fmt.Errorf("vault token %s already stored", e.Token)
+		}
+	}
+	for _, e := range entries {
+		e.Ciphertext = slices.Clone(e.Ciphertext)
+		e.CreatedAt = e.CreatedAt.UTC()
+		r.vault[e.Token] = e
+	}
+	return nil
+}
+
+// VaultEntries returns the stored entries for tokens; unknown tokens are
+// skipped.
+func (r *Ring) VaultEntries(ctx context.Context, tokens []string) ([]VaultEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var entries []VaultEntry
+	for _, token := range tokens {
+		if e, ok := r.vault[token]; ok {
+			e.Ciphertext = slices.Clone(e.Ciphertext)
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// RecordVaultReveal appends to the reveal audit trail.
+func (r *Ring) RecordVaultReveal(ctx context.Context, reveal models.VaultReveal) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastReveal++
+	reveal.ID = r.lastReveal
+	reveal.RevealedAt = reveal.RevealedAt.UTC()
+	if len(reveal.Tokens) == 0 {
+		reveal.Tokens = nil
+	}
+	reveal.Tokens = slices.Clone(reveal.Tokens)
+	r.reveals = append(r.reveals, reveal)
+	return nil
+}
+
+// VaultReveals returns the most recent reveals, newest first.
+func (r *Ring) VaultReveals(ctx context.Context, limit int) ([]models.VaultReveal, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	reveals := []models.VaultReveal{}
+	for i := len(r.reveals) - 1; i >= 0 && len(reveals) < limit; i-- {
+		reveal := r.reveals[i]
+		reveal.Tokens = slices.Clone(reveal.Tokens)
+		reveals = append(reveals, reveal)
+	}
+	return reveals, nil
+}
+
+// MaintenanceDue reports false: a Ring has no indexes to rebuild.
+func (r *Ring) MaintenanceDue(ctx context.Context) (bool, error) {
+	return false, nil
+}
+
+// Maintain records a run with nothing to do, so the admin endpoint behaves
+// as it does for a database. It returns ErrMaintenanceRunning if a run is
+// under way.
+func (r *Ring) Maintain(ctx context.Context, reason string) error {
+	m := &r.maintenance
+	if !m.running.TryLock() {
+		return ErrMaintenanceRunning
+	}
+	defer m.running.Unlock()
+	now := time.Now().UTC()
+	m.mu.Lock()
+	m.status = models.MaintenanceStatus{State: "done", Reason: reason, StartedAt: &now, FinishedAt: &now, Runs: m.status.Runs + 1}
+	m.mu.Unlock()
+	return nil
+}
+
+// MaintenanceStatus returns the outcome of the last maintenance run.
+func (r *Ring) MaintenanceStatus() models.MaintenanceStatus {
+	return r.maintenance.snapshot()
+}
+
+// MigrationStatus returns no migrations: a Ring has no schema.
+func (r *Ring) MigrationStatus() []models.MigrationStatus {
+	return []models.MigrationStatus{}
+}
+
+// CheckIntegrity returns nil: there is no file to corrupt.
+func (r *Ring) CheckIntegrity(ctx context.Context, full bool) error {
+	return nil
+}
+
+// Backup fails: a Ring has no database file to copy.
+func (r *Ring) Backup(ctx context.Context, path string) error {
+	return errNoDatabaseFile
+}
+
+// ExportSQLite fails: a Ring has no database file to copy.
+func (r *Ring) ExportSQLite(ctx context.Context, path string, key []byte) error {
+	return errNoDatabaseFile
+}
+
+// Backend returns "memory".
+func (r *Ring) Backend() string {
+	return "memory"
+}
+
+// Version returns "": there is no database library or server.
+func (r *Ring) Version(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+// ReadOnly returns false.
+func (r *Ring) ReadOnly() bool {
+	return false
+}
+
+// Encrypted returns false.
+func (r *Ring) Encrypted() bool {
+	return false
+}
+
+// MaxLogs returns how many logs the ring keeps.
+func (r *Ring) MaxLogs() int {
+	return r.max
+}
+
+// Close drops everything the ring holds.
+func (r *Ring) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logs, r.head = nil, 0
+	r.values = map[string]map[string]int64{"service": {}, "level": {}, "host": {}}
+	clear(r.sources)
+	r.metadataKeys = nil
+	return nil
+}
@@ -0,0 +1,227 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func newTestRing(t *testing.T, maxLogs int) *Ring {
+	t.Helper()
+	r, err := NewRing(maxLogs)
+	if err != nil {
+		t.Fatalf("NewRing failed: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestRing_Evicts(t *testing.T) {
+	r := newTestRing(t, 3)
+	ctx := context.Background()
+
+	now := time.Now()
+	r.InsertLog(ctx, &models.Log{Timestamp: now, Service: "old", Level: "info", Message: "m0", Host: "h"})
+	r.TagLogs(ctx, models.LogFilter{Service: "old"}, "keep")
+	if err := r.InsertBatch(ctx, []models.Log{
+		{Timestamp: now, Service: "api", Level: "info", Message: "m1", Host: "h"},
+		{Timestamp: now, Service: "api", Level: "error", Message: "m2", Host: "h"},
+		{Timestamp: now, Service: "api", Level: "info", Message: "m3", Host: "h"},
+	}); err != nil {
+		t.Fatalf("InsertBatch failed: %v", err)
+	}
+
+	logs, err := r.QueryLogs(ctx, models.LogFilter{})
+	if err != nil {
+		t.Fatalf("QueryLogs failed: %v", err)
+	}
+	var messages []string
+	for _, l := range logs {
+		messages = append(messages, l.Message)
+	}
+	slices.Sort(messages)
+	if !slices.Equal(messages, []string{"m1", "m2", "m3"}) {
+		t.Errorf("expected the 3 newest logs, got %v", messages)
+	}
+
+	// Filter options forget values only the evicted log had
+	options, err := r.GetFilterOptions(ctx)
+	if err != nil {
+		t.Fatalf("GetFilterOptions failed: %v", err)
+	}
+	if !slices.Equal(options.Services, []string{"api"}) {
+		t.Errorf("expected only api, got %v", options.Services)
+	}
+	if n, _ := r.CountLogs(ctx, models.LogFilter{Tag: "keep"}); n != 0 {
+		t.Errorf("expected the evicted log's tag to be gone, got %d", n)
+	}
+	if r.MaxLogs() != 3 {
+		t.Errorf("expected MaxLogs 3, got %d", r.MaxLogs())
+	}
+	if _, err := NewRing(0); err == nil {
+		t.Error("expected an error for a ring of no logs")
+	}
+}
+
+// TestRing_Concurrent tests concurrent inserts and queries.
+func TestRing_Concurrent(t *testing.T) {
+	r := newTestRing(t, 50)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				var err error
+				if i%2 == 0 {
+					err = r.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "m", Host: "h"})
+				} else {
+					_, err = r.QueryLogs(ctx, models.LogFilter{Service: "api"})
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n, _ := r.CountLogs(ctx, models.LogFilter{}); n != 50 {
+		t.Errorf("expected 50 logs kept, got %d", n)
+	}
+}
+
+// TestRing_MatchesSQLite tests that a ring answers queries as a SQLite
+// database holding the same logs does.
+func TestRing_MatchesSQLite(t *testing.T) {
+	ctx := context.Background()
+	sqlite := newTestDB(t)
+	r := newTestRing(t, 1000)
+
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var logs []models.Log
+	services := []string{"api", "web", "worker"}
+	levels := []string{"info", "ERROR", "warn", "debug"}
+	for i := range 60 {
+		l := models.Log{
+			// Repeated timestamps exercise the ID tie-breaks
+			Timestamp: base.Add(time.Duration(i/2) * time.Minute),
+			Service:   services[i%3],
+			Level:     levels[i%4],
+			Message:   []string{"Request done", "request FAILED", "cache miss"}[i%3],
+			Host:      []string{"web-1", "web-2", "db-1"}[i%3],
+			Metadata:  map[string]interface{}{"status": 200 + i%5*100, "user": []string{"ann", "bob"}[i%2], "http": map[string]interface{}{"ms": i}},
+		}
+		if i%7 == 0 {
+			l.Metadata = nil
+		}
+		if i%4 == 0 {
+			l.Stream = "audit"
+		}
+		logs = append(logs, l)
+	}
+	for _, s := range []Store{sqlite, r} {
+		if err := s.InsertBatch(ctx, logs); err != nil {
+			t.Fatalf("%s: InsertBatch failed: %v", s.Backend(), err)
+		}
+		if _, err := s.TagLogs(ctx, models.LogFilter{Level: "ERROR"}, "incident-1"); err != nil {
+			t.Fatalf("%s: TagLogs failed: %v", s.Backend(), err)
+		}
+	}
+
+	start, end := base.Add(5*time.Minute), base.Add(20*time.Minute)
+	filters := map[string]models.LogFilter{
+		"all":        {},
+		"service":    {Service: "api"},
+		"levels":     {Levels: []string{"error", "WARN"}},
+		"exclude":    {ExcludeLevels: []string{"DEBUG"}, ExcludeHosts: []string{"db-1"}, ExcludeSearch: []string{"miss"}},
+		"patterns":   {HostPatterns: []string{"web-*"}, ServicePatterns: []string{"w?b", "api"}},
+		"search":     {Search: "request"},
+		"regex":      {Search: "^Request|MISS$", SearchRegex: true},
+		"range":      {StartTime: &start, EndTime: &end},
+		"ascending":  {Ascending: true, Limit: 7},
+		"since":      {SinceID: 10, MaxID: 40},
+		"cursor":     {Cursor: &models.LogCursor{Timestamp: base.Add(10 * time.Minute), ID: 21}, Limit: 5},
+		"cursor asc": {Cursor: &models.LogCursor{Timestamp: base.Add(10 * time.Minute), ID: 21}, Ascending: true, Limit: 5},
+		"tag":        {Tag: "incident-1"},
+		"stream":     {Stream: "default"},
+		"meta":       {Meta: []models.MetaFilter{{Key: "user", Op: "=", Value: "ann"}}},
+		"meta not":   {Meta: []models.MetaFilter{{Key: "user", Op: "!=", Value: "ann"}}},
+		"numeric":    {Meta: []models.MetaFilter{{Key: "status", Op: ">=", Numeric: true, Number: 400}}},
+		"nested":     {Meta: []models.MetaFilter{{Key: "http.ms", Op: "!=", Numeric: true, Number: 3}}},
+	}
+	for name, filter := range filters {
+		t.Run(name, func(t *testing.T) {
+			compare(t, "QueryLogs", ids(sqlite.QueryLogs(ctx, filter)), ids(r.QueryLogs(ctx, filter)))
+			compare(t, "CountLogs", value(sqlite.CountLogs(ctx, filter)), value(r.CountLogs(ctx, filter)))
+			// SQLite's julianday arithmetic may put logs on a bucket boundary
+			// in either bucket, so the buckets start between logs
+			from, to := base.Add(-30*time.Second), base.Add(30*time.Minute-30*time.Second)
+			compare(t, "Histogram", value(sqlite.Histogram(ctx, filter, from, to, 6)), value(r.Histogram(ctx, filter, from, to, 6)))
+			compare(t, "CountLogsByGroup", value(sqlite.CountLogsByGroup(ctx, filter, "metadata.user", 10*time.Minute, 5)),
+				value(r.CountLogsByGroup(ctx, filter, "metadata.user", 10*time.Minute, 5)))
+			compare(t, "AggregateMetadata", value(sqlite.AggregateMetadata(ctx, filter, "status", "service", 0)),
+				value(r.AggregateMetadata(ctx, filter, "status", "service", 0)))
+			compare(t, "TopErrors", value(sqlite.TopErrors(ctx, filter, 100)), value(r.TopErrors(ctx, filter, 100)))
+		})
+	}
+
+	compare(t, "GetFilterOptions", value(sqlite.GetFilterOptions(ctx)), value(r.GetFilterOptions(ctx)))
+	compare(t, "SuggestFilterValues", value(sqlite.SuggestFilterValues(ctx, "host", "WEB", 10)),
+		value(r.SuggestFilterValues(ctx, "host", "WEB", 10)))
+	compare(t, "Availability", value(sqlite.Availability(ctx, models.LogFilter{}, []string{"error"}, base, 10*time.Minute, 3)),
+		value(r.Availability(ctx, models.LogFilter{}, []string{"error"}, base, 10*time.Minute, 3)))
+
+	l, _ := sqlite.GetLog(ctx, 30)
+	before, after, err := sqlite.LogContext(ctx, models.LogFilter{Service: "web"}, *l, 2, 2)
+	ringBefore, ringAfter, ringErr := r.LogContext(ctx, models.LogFilter{Service: "web"}, *l, 2, 2)
+	compare(t, "LogContext before", ids(before, err), ids(ringBefore, ringErr))
+	compare(t, "LogContext after", ids(after, err), ids(ringAfter, ringErr))
+
+	olderThan := time.Since(base.Add(15 * time.Minute))
+	policies := []TagPolicy{{Pattern: "incident-*", Keep: time.Since(base.Add(5 * time.Minute))}}
+	for _, s := range []Store{sqlite, r} {
+		s.SetStreamRetention(map[string]time.Duration{"audit": time.Since(base.Add(25 * time.Minute))})
+		s.SetTagPolicies(policies)
+	}
+	preview, err := sqlite.RetentionPreview(ctx, olderThan, policies)
+	ringPreview, ringErr := r.RetentionPreview(ctx, olderThan, policies)
+	ringPreview.Cutoff = preview.Cutoff
+	compare(t, "RetentionPreview", value(preview, err), value(ringPreview, ringErr))
+	compare(t, "DeleteOldLogs", value(sqlite.DeleteOldLogs(ctx, olderThan)), value(r.DeleteOldLogs(ctx, olderThan)))
+	compare(t, "QueryLogs after DeleteOldLogs", ids(sqlite.QueryLogs(ctx, models.LogFilter{})), ids(r.QueryLogs(ctx, models.LogFilter{})))
+}
+
+// ids returns the IDs of logs, or the error.
+func ids(logs []models.Log, err error) interface{} {
+	if err != nil {
+		return err.Error()
+	}
+	ids := []int64{}
+	for _, l := range logs {
+		ids = append(ids, l.ID)
+	}
+	return ids
+}
+
+// value returns v, or the error.
+func value[T any](v T, err error) interface{} {
+	if err != nil {
+		return err.Error()
+	}
+	return v
+}
+
+func compare(t *testing.T, what string, want, got interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("%s: SQLite returned %v, the ring %v", what, want, got)
+	}
+}
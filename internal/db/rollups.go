@@ -0,0 +1,383 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+)
+
+// Rollups are log counts per fixed-width time bucket and service, level,
+// host and stream, kept in log_rollups by RefreshRollups so statistics over
+// long ranges read one row per bucket and combination instead of every log.
+// Each bucket size is rolled up to log_rollup_state.rolled_until. Only
+// complete buckets are written, and the last rollupLookback is counted again
+// on every refresh to pick up late arrivals.
+
+const (
+	// rollupLookback is how late a log may arrive and still be counted.
+	rollupLookback = 10 * time.Minute
+	// rollupChunk is the range counted per transaction when catching up.
+	rollupChunk = 24 * time.Hour
+)
+
+// RollupConfig chooses the bucket sizes kept and how logs are counted.
+type RollupConfig struct {
+	// Sizes are whole minutes dividing a day evenly, e.g. 1m, 5m and 1h.
+	Sizes []time.Duration
+	// SampleRate counts only every SampleRate-th log by ID and scales the
+	// counts up, for volumes too high to count exactly. 0 or 1 counts
+	// every log.
+	SampleRate int
+}
+
+// Validate checks the bucket sizes and sample rate.
+func (c RollupConfig) Validate() error {
+	for _, size := range c.Sizes {
+		if size < time.Minute || size%time.Minute != 0 || rollupChunk%size != 0 {
+			return fmt.Errorf("rollup bucket %s must be whole minutes dividing a day evenly, e.g. 1m, 5m or 1h", size)
+		}
+	}
+	if c.SampleRate < 0 {
+		return fmt.Errorf("rollup sample rate must not be negative, got %d", c.SampleRate)
+	}
+	return nil
+}
+
+func (c RollupConfig) sampleRate() int {
+	return max(c.SampleRate, 1)
+}
+
+// SetRollups sets the rollups RefreshRollups maintains and queries read.
+func (p *policies) SetRollups(cfg RollupConfig) {
+	cfg.Sizes = slices.Clone(cfg.Sizes)
+	slices.Sort(cfg.Sizes)
+	cfg.Sizes = slices.Compact(cfg.Sizes)
+	p.policyMu.Lock()
+	p.rollups = cfg
+	p.policyMu.Unlock()
+}
+
+// Rollups returns the configured rollups.
+func (p *policies) Rollups() RollupConfig {
+	p.policyMu.RLock()
+	defer p.policyMu.RUnlock()
+	cfg := p.rollups
+	cfg.Sizes = slices.Clone(cfg.Sizes)
+	return cfg
+}
+
+// RefreshRollups counts the logs of every complete bucket up to now that
+// isn't rolled up yet, and recounts the last rollupLookback. A bucket size
+// rolled up with another sample rate, or not yet at all, is rebuilt from the
+// oldest log. Rollups of sizes no longer configured are dropped.
+func (db *DB) RefreshRollups(ctx context.Context, now time.Time) error {
+	ctx, span := db.startSpan(ctx, "RefreshRollups")
+	defer span.End()
+
+	cfg := db.Rollups()
+	keep := "0"
+	for _, size := range cfg.Sizes {
+		keep += ", " + strconv.Itoa(int(size/time.Second))
+	}
+	for _, table := range []string{"log_rollups", "log_rollup_state"} {
+		if _, err := db.conn.ExecContext(ctx, "DELETE FROM "+table+" WHERE bucket_seconds NOT IN ("+keep+")"); err != nil {
+			return err
+		}
+	}
+	for _, size := range cfg.Sizes {
+		if err := db.refreshRollup(ctx, size, cfg.sampleRate(), now); err != nil {
+			return fmt.Errorf("rollup %s: %w", size, err)
+		}
+	}
+	return nil
+}
+
+func (db *DB) refreshRollup(ctx context.Context, size time.Duration, rate int, now time.Time) error {
+	until := now.UTC().Truncate(size)
+	rolledUntil, stateRate, ok, err := db.rollupState(ctx, size)
+	if err != nil {
+		return err
+	}
+	rebuild := !ok || stateRate != rate
+	from := earliest(rolledUntil, until.Add(-rollupLookback).Truncate(size))
+	if rebuild {
+		var oldest time.Time
+		err := db.conn.QueryRowContext(ctx, "SELECT timestamp FROM logs ORDER BY timestamp LIMIT 1").Scan(&oldest)
+		switch {
+		case err == sql.ErrNoRows:
+			from = until
+		case err != nil:
+			return err
+		default:
+			from = earliest(oldest.UTC().Truncate(size), until)
+		}
+	}
+
+	for {
+		end := earliest(from.Add(rollupChunk), until)
+		if err := db.rollupChunk(ctx, size, rate, from, end, rebuild); err != nil {
+			return err
+		}
+		rebuild = false
+		if !end.Before(until) {
+			return nil
+		}
+		from = end
+	}
+}
+
+// rollupChunk replaces the rollups of size in [from, end) with fresh counts
+// and records them as rolled up to end. rebuild first drops every rollup of
+// size.
+func (db *DB) rollupChunk(ctx context.Context, size time.Duration, rate int, from, end time.Time, rebuild bool) error {
+	type row struct {
+		start                        time.Time
+		service, level, host, stream string
+		count                        int64
+	}
+	var counted []row
+	if from.Before(end) {
+		query := `SELECT ` + db.dialect.histogramBucket() + ` AS bucket, service, level, COALESCE(host, ''), stream, COUNT(*)
+			FROM logs WHERE timestamp >= ? AND timestamp < ?`
+		args := []interface{}{from, size.Seconds(), from, end}
+		if rate > 1 {
+			query += " AND id % ? = 0"
+			args = append(args, rate)
+		}
+		query += " GROUP BY bucket, service, level, COALESCE(host, ''), stream"
+		rows, err := db.conn.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		buckets := int64(end.Sub(from) / size)
+		for rows.Next() {
+			var r row
+			var bucket int64
+			if err := rows.Scan(&bucket, &r.service, &r.level, &r.host, &r.stream, &r.count); err != nil {
+				rows.Close()
+				return err
+			}
+			// Rounding may put a log at a bucket's edge just outside it
+			bucket = min(max(bucket, 0), buckets-1)
+			r.start = from.Add(time.Duration(bucket) * size)
+			r.count *= int64(rate)
+			counted = append(counted, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	seconds := int(size / time.Second)
+	if rebuild {
+		_, err = tx.ExecContext(ctx, "DELETE FROM log_rollups WHERE bucket_seconds = ?", seconds)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM log_rollups WHERE bucket_seconds = ? AND bucket_start >= ? AND bucket_start < ?",
+			seconds, from, end)
+	}
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO log_rollups (bucket_seconds, bucket_start, service, level, host, stream, count)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(bucket_seconds, bucket_start, service, level, host, stream) DO UPDATE SET
+			count = log_rollups.count + excluded.count`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range counted {
+		if _, err := stmt.ExecContext(ctx, seconds, r.start, r.service, r.level, r.host, r.stream, r.count); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO log_rollup_state (bucket_seconds, rolled_until, sample_rate) VALUES (?, ?, ?)
+		ON CONFLICT(bucket_seconds) DO UPDATE SET
+			rolled_until = excluded.rolled_until, sample_rate = excluded.sample_rate`,
+		seconds, end, rate); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rollupState returns how far rollups of size extend and their sample rate,
+// or false when there are none.
+func (db *DB) rollupState(ctx context.Context, size time.Duration) (time.Time, int, bool, error) {
+	var until time.Time
+	var rate int
+	err := db.conn.QueryRowContext(ctx, "SELECT rolled_until, sample_rate FROM log_rollup_state WHERE bucket_seconds = ?",
+		int(size/time.Second)).Scan(&until, &rate)
+	if err == sql.ErrNoRows {
+		return time.Time{}, 0, false, nil
+	}
+	if err != nil {
+		return time.Time{}, 0, false, err
+	}
+	return until.UTC(), rate, true, nil
+}
+
+// RollupFilter reports whether filter can be answered from rollups, which
+// only know the service, level, host and stream of logs.
+func RollupFilter(filter models.LogFilter) bool {
+	return filter.Search == "" && len(filter.ExcludeSearch) == 0 && len(filter.Meta) == 0 &&
+		filter.Tag == "" && filter.SinceID == 0
+}
+
+// rollupGroups are the columns LogStats may group by.
+var rollupGroups = []string{"service", "level", "host", "stream"}
+
+// LogStats returns the rolled up counts of logs matching filter per bucket
+// of size, from the bucket holding filter.StartTime until filter.EndTime or
+// the end of the rollups, grouped by groupBy (service, level, host, stream
+// or "" for none). Empty buckets are included.
+func (db *DB) LogStats(ctx context.Context, filter models.LogFilter, size time.Duration, groupBy string) (models.LogStats, error) {
+	ctx, span := db.startSpan(ctx, "LogStats")
+	defer span.End()
+
+	cfg := db.Rollups()
+	stats := models.LogStats{BucketSeconds: size.Seconds(), GroupBy: groupBy, Approximate: cfg.sampleRate() > 1,
+		Buckets: []models.StatsBucket{}}
+	if !slices.Contains(cfg.Sizes, size) {
+		return stats, fmt.Errorf("no rollups of %s", size)
+	}
+	if groupBy != "" && !slices.Contains(rollupGroups, groupBy) {
+		return stats, fmt.Errorf("can't group by %q; use %s", groupBy, strings.Join(rollupGroups, ", "))
+	}
+	if !RollupFilter(filter) || filter.StartTime == nil || filter.EndTime == nil {
+		return stats, fmt.Errorf("rollups need a time range and only filter by service, level, host and stream")
+	}
+
+	until, rate, ok, err := db.rollupState(ctx, size)
+	if err != nil || !ok {
+		return stats, err
+	}
+	stats.RolledUntil = &until
+	stats.Approximate = rate > 1
+	start := filter.StartTime.UTC().Truncate(size)
+	end := earliest(filter.EndTime.UTC(), until)
+	if !start.Before(end) {
+		return stats, nil
+	}
+
+	// PostgreSQL can't group by a constant, so only grouped queries name one
+	group, groupClause := "''", "bucket_start"
+	if groupBy != "" {
+		group, groupClause = groupBy, "bucket_start, "+groupBy
+	}
+	dims := filter
+	dims.StartTime, dims.EndTime = nil, nil
+	where, args := db.buildWhere(dims)
+	query := `SELECT bucket_start, ` + group + `, SUM(count) FROM log_rollups` + where +
+		` AND bucket_seconds = ? AND bucket_start >= ? AND bucket_start < ?
+		GROUP BY ` + groupClause
+	args = append(args, int(size/time.Second), start, end)
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	n := int((end.Sub(start) + size - 1) / size)
+	stats.Buckets = make([]models.StatsBucket, n)
+	for i := range stats.Buckets {
+		stats.Buckets[i].Start = start.Add(time.Duration(i) * size)
+	}
+	for rows.Next() {
+		var bucketStart time.Time
+		var value string
+		var count int64
+		if err := rows.Scan(&bucketStart, &value, &count); err != nil {
+			return stats, err
+		}
+		i := int(bucketStart.Sub(start) / size)
+		if i < 0 || i >= n {
+			continue
+		}
+		b := &stats.Buckets[i]
+		b.Count += count
+		if groupBy != "" {
+			if b.Groups == nil {
+				b.Groups = make(map[string]int64)
+			}
+			b.Groups[value] += count
+		}
+	}
+	return stats, rows.Err()
+}
+
+// rollupHistogram fills counts, buckets of width from start, from exact
+// rollups where they cover the range, and returns where they end (start
+// when they don't apply). The caller counts the rest from the logs.
+func (db *DB) rollupHistogram(ctx context.Context, filter models.LogFilter, start, end time.Time, width time.Duration, counts []int64) (time.Time, error) {
+	cfg := db.Rollups()
+	if cfg.sampleRate() > 1 || !RollupFilter(filter) || end.Sub(start)%width != 0 {
+		return start, nil
+	}
+	// The largest size the buckets are made of
+	var size time.Duration
+	for _, s := range cfg.Sizes {
+		if width%s == 0 && start.Truncate(s).Equal(start) {
+			size = s
+		}
+	}
+	if size == 0 {
+		return start, nil
+	}
+	until, rate, ok, err := db.rollupState(ctx, size)
+	if err != nil || !ok || rate > 1 {
+		return start, err
+	}
+	covered := earliest(until, end)
+	if !covered.After(start) {
+		return start, nil
+	}
+
+	dims := filter
+	dims.StartTime, dims.EndTime = nil, nil
+	where, args := db.buildWhere(dims)
+	query := `SELECT bucket_start, SUM(count) FROM log_rollups` + where +
+		` AND bucket_seconds = ? AND bucket_start >= ? AND bucket_start < ? GROUP BY bucket_start`
+	args = append(args, int(size/time.Second), start.UTC(), covered)
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return start, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bucketStart time.Time
+		var count int64
+		if err := rows.Scan(&bucketStart, &count); err != nil {
+			return start, err
+		}
+		if i := int(bucketStart.Sub(start) / width); i >= 0 && i < len(counts) {
+			counts[i] += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return start, err
+	}
+	return covered, nil
+}
+
+// earliest returns the earlier of a and b.
+func earliest(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
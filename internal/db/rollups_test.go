@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestRollups(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	db.SetRollups(RollupConfig{Sizes: []time.Duration{5 * time.Minute, time.Minute}})
+
+	now := time.Now().UTC()
+	base := now.Truncate(time.Hour).Add(-2 * time.Hour)
+	var logs []models.Log
+	for i := range 30 {
+		level := "INFO"
+		if i%3 == 0 {
+			level = "ERROR"
+		}
+		logs = append(logs, models.Log{Timestamp: base.Add(time.Duration(i) * 2 * time.Minute), Service: "api",
+			Level: level, Message: "m", Host: "web-1"})
+	}
+	logs = append(logs, models.Log{Timestamp: base.Add(time.Minute), Service: "worker", Level: "INFO", Message: "m"})
+	if err := db.InsertBatch(ctx, logs); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RefreshRollups(ctx, now); err != nil {
+		t.Fatalf("RefreshRollups failed: %v", err)
+	}
+
+	start, end := base, base.Add(time.Hour)
+	stats, err := db.LogStats(ctx, models.LogFilter{Service: "api", StartTime: &start, EndTime: &end}, 5*time.Minute, "level")
+	if err != nil {
+		t.Fatalf("LogStats failed: %v", err)
+	}
+	if len(stats.Buckets) != 12 || stats.Approximate || stats.RolledUntil == nil {
+		t.Fatalf("expected 12 exact buckets, got %+v", stats)
+	}
+	// Logs every 2 minutes: 0, 2, 4 in the first bucket, the first an error
+	if b := stats.Buckets[0]; !b.Start.Equal(base) || b.Count != 3 || b.Groups["ERROR"] != 1 || b.Groups["INFO"] != 2 {
+		t.Errorf("unexpected first bucket: %+v", b)
+	}
+	var total int64
+	for _, b := range stats.Buckets {
+		total += b.Count
+	}
+	if total != 30 {
+		t.Errorf("expected 30 api logs in the hour, got %d", total)
+	}
+
+	// Histograms over whole buckets read the rollups, which still count a
+	// log deleted since
+	if _, err := db.conn.Exec("DELETE FROM logs WHERE service = 'worker'"); err != nil {
+		t.Fatal(err)
+	}
+	rolled, err := db.Histogram(ctx, models.LogFilter{}, base, base.Add(90*time.Minute), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := db.Rollups()
+	db.SetRollups(RollupConfig{})
+	raw, err := db.Histogram(ctx, models.LogFilter{}, base, base.Add(90*time.Minute), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetRollups(cfg)
+	if rolled[0] != 9 || raw[0] != 8 || !slices.Equal(rolled[1:], raw[1:]) {
+		t.Errorf("expected the rolled up histogram %v to differ from the logs' %v by the deleted log", rolled, raw)
+	}
+
+	// A log arriving late is counted on the next refresh within the lookback
+	late := now.Add(-3 * time.Minute)
+	if err := db.InsertLog(ctx, &models.Log{Timestamp: late, Service: "api", Level: "WARN", Message: "late", Host: "web-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RefreshRollups(ctx, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	from, to := late.Add(-5*time.Minute), late.Add(time.Minute)
+	stats, _ = db.LogStats(ctx, models.LogFilter{Level: "WARN", StartTime: &from, EndTime: &to}, time.Minute, "")
+	var warns int64
+	for _, b := range stats.Buckets {
+		warns += b.Count
+	}
+	if warns != 1 {
+		t.Errorf("expected the late log in the rollups, got %+v", stats.Buckets)
+	}
+
+	// Sampling rebuilds the rollups with scaled counts
+	db.SetRollups(RollupConfig{Sizes: []time.Duration{time.Hour}, SampleRate: 4})
+	if err := db.RefreshRollups(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = db.LogStats(ctx, models.LogFilter{StartTime: &start, EndTime: &end}, time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats.Approximate || len(stats.Buckets) != 1 || stats.Buckets[0].Count%4 != 0 {
+		t.Errorf("expected one approximate bucket counted in fours, got %+v", stats)
+	}
+	var stale int
+	db.conn.QueryRow("SELECT COUNT(*) FROM log_rollups WHERE bucket_seconds != 3600").Scan(&stale)
+	if stale != 0 {
+		t.Errorf("expected rollups of unconfigured sizes to be dropped, %d left", stale)
+	}
+
+	if _, err := db.LogStats(ctx, models.LogFilter{StartTime: &start, EndTime: &end}, time.Minute, ""); err == nil {
+		t.Error("expected an error for a size without rollups")
+	}
+	if _, err := db.LogStats(ctx, models.LogFilter{Search: "m", StartTime: &start, EndTime: &end}, time.Hour, ""); err == nil {
+		t.Error("expected an error for a search filter")
+	}
+	if err := (RollupConfig{Sizes: []time.Duration{7 * time.Minute}}).Validate(); err == nil {
+		t.Error("expected an error for a size not dividing a day")
+	}
+}
@@ -0,0 +1,90 @@
+CREATE TABLE IF NOT EXISTS logs (
+    id Int64,
+    timestamp DateTime64(9, 'UTC'),
+    service LowCardinality(String),
+    level LowCardinality(String),
+    message String,
+    metadata String,
+    host LowCardinality(String),
+    stream LowCardinality(String),
+    created_at DateTime64(9, 'UTC'),
+    INDEX idx_logs_id id TYPE minmax GRANULARITY 1
+) ENGINE = MergeTree
+PARTITION BY toYYYYMMDD(timestamp)
+ORDER BY (service, timestamp, id);
+
+CREATE TABLE IF NOT EXISTS log_tags (
+    log_id Int64,
+    tag String,
+    log_timestamp DateTime64(9, 'UTC'),
+    tagged_at DateTime64(9, 'UTC')
+) ENGINE = ReplacingMergeTree
+ORDER BY (tag, log_id);
+
+CREATE TABLE IF NOT EXISTS tag_exports (
+    log_id Int64,
+    tag String,
+    log_timestamp DateTime64(9, 'UTC'),
+    exported_at DateTime64(9, 'UTC')
+) ENGINE = ReplacingMergeTree
+ORDER BY (tag, log_id);
+
+CREATE TABLE IF NOT EXISTS filter_values (
+    kind LowCardinality(String),
+    value String,
+    count SimpleAggregateFunction(sum, Int64),
+    last_seen SimpleAggregateFunction(max, DateTime64(9, 'UTC'))
+) ENGINE = AggregatingMergeTree
+ORDER BY (kind, value);
+
+CREATE TABLE IF NOT EXISTS sources (
+    host String,
+    service String,
+    logs SimpleAggregateFunction(sum, Int64),
+    last_log_at SimpleAggregateFunction(max, DateTime64(9, 'UTC')),
+    last_heartbeat_at SimpleAggregateFunction(max, DateTime64(9, 'UTC'))
+) ENGINE = AggregatingMergeTree
+ORDER BY (host, service);
+
+CREATE TABLE IF NOT EXISTS incidents (
+    id Int64,
+    name String,
+    services Array(String),
+    started_at DateTime64(9, 'UTC'),
+    ends_at DateTime64(9, 'UTC'),
+    keep_until DateTime64(9, 'UTC'),
+    version Int64
+) ENGINE = ReplacingMergeTree(version)
+ORDER BY id;
+
+CREATE TABLE IF NOT EXISTS user_prefs (
+    user String,
+    key String,
+    value String,
+    updated_at DateTime64(9, 'UTC')
+) ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (user, key);
+
+CREATE TABLE IF NOT EXISTS host_groups (
+    name String,
+    hosts Array(String),
+    updated_at DateTime64(9, 'UTC')
+) ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY name;
+
+CREATE TABLE IF NOT EXISTS redaction_vault (
+    token String,
+    rule String,
+    ciphertext String,
+    created_at DateTime64(9, 'UTC')
+) ENGINE = MergeTree
+ORDER BY token;
+
+CREATE TABLE IF NOT EXISTS vault_reveals (
+    id Int64,
+    revealed_at DateTime64(9, 'UTC'),
+    client String,
+    reason String,
+    tokens Array(String)
+) ENGINE = MergeTree
+ORDER BY id;
@@ -0,0 +1,140 @@
+-- schema_postgres.sql: the PostgreSQL equivalent of schema.sql
+CREATE TABLE IF NOT EXISTS logs (
+    id BIGSERIAL PRIMARY KEY,
+    timestamp TIMESTAMPTZ NOT NULL,
+    service VARCHAR(100) NOT NULL,
+    level VARCHAR(20) NOT NULL,
+    message TEXT NOT NULL,
+    metadata JSONB,
+    host VARCHAR(255),
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    stream VARCHAR(64) NOT NULL DEFAULT 'default'
+);
+
+-- Indexes for efficient querying
+CREATE INDEX IF NOT EXISTS idx_timestamp ON logs(timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_service ON logs(service);
+CREATE INDEX IF NOT EXISTS idx_level ON logs(level);
+CREATE INDEX IF NOT EXISTS idx_host ON logs(host);
+CREATE INDEX IF NOT EXISTS idx_service_timestamp ON logs(service, timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_level_timestamp ON logs(level, timestamp DESC);
+
+-- Materialized distinct values for the filter dropdowns, maintained at ingest
+CREATE TABLE IF NOT EXISTS filter_values (
+    kind VARCHAR(20) NOT NULL,
+    value VARCHAR(255) NOT NULL,
+    count BIGINT NOT NULL DEFAULT 0,
+    last_seen TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (kind, value)
+);
+
+-- Completed data migrations (backfills) so they run only once
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    name VARCHAR(100) PRIMARY KEY,
+    completed_at TIMESTAMPTZ NOT NULL
+);
+
+-- Periodically rebuilt report of metadata keys observed per service
+CREATE TABLE IF NOT EXISTS metadata_keys (
+    service VARCHAR(100) NOT NULL,
+    key TEXT NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    count BIGINT NOT NULL,
+    service_total BIGINT NOT NULL,
+    example_min TEXT,
+    example_max TEXT,
+    last_seen TIMESTAMPTZ NOT NULL,
+    generated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (service, key, type)
+);
+
+-- Tags attached to logs after ingest (e.g. by bulk tagging an incident window)
+CREATE TABLE IF NOT EXISTS log_tags (
+    log_id BIGINT NOT NULL,
+    tag VARCHAR(64) NOT NULL,
+    PRIMARY KEY (log_id, tag)
+);
+CREATE INDEX IF NOT EXISTS idx_log_tags_tag ON log_tags(tag);
+
+-- Tagged logs already exported by a tag policy
+CREATE TABLE IF NOT EXISTS tag_exports (
+    log_id BIGINT NOT NULL,
+    tag VARCHAR(64) NOT NULL,
+    exported_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (log_id, tag)
+);
+
+-- Per-user UI preferences (theme, default filters, column layouts)
+CREATE TABLE IF NOT EXISTS user_prefs (
+    user_id VARCHAR(100) NOT NULL,
+    key VARCHAR(64) NOT NULL,
+    value JSONB NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+-- Encrypted originals of values redacted at ingest, when the vault is enabled
+CREATE TABLE IF NOT EXISTS redaction_vault (
+    token VARCHAR(32) PRIMARY KEY,
+    rule VARCHAR(64) NOT NULL,
+    ciphertext BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_redaction_vault_created_at ON redaction_vault(created_at);
+
+-- Audit trail of vault reveals
+CREATE TABLE IF NOT EXISTS vault_reveals (
+    id BIGSERIAL PRIMARY KEY,
+    revealed_at TIMESTAMPTZ NOT NULL,
+    client VARCHAR(255) NOT NULL,
+    reason TEXT NOT NULL,
+    tokens TEXT NOT NULL
+);
+
+-- When logs and heartbeats last arrived per shipper host and service
+CREATE TABLE IF NOT EXISTS sources (
+    host VARCHAR(255) NOT NULL,
+    service VARCHAR(255) NOT NULL, -- '' for heartbeats naming no service
+    logs BIGINT NOT NULL DEFAULT 0,
+    last_log_at TIMESTAMPTZ,
+    last_heartbeat_at TIMESTAMPTZ,
+    PRIMARY KEY (host, service)
+);
+
+-- Host groups managed via the API (those in the config file aren't stored)
+CREATE TABLE IF NOT EXISTS host_groups (
+    name VARCHAR(64) PRIMARY KEY,
+    hosts TEXT NOT NULL, -- JSON array of host names and globs
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- Log counts per time bucket, service, level, host and stream, maintained by
+-- RefreshRollups. Counts are scaled up when sampled.
+CREATE TABLE IF NOT EXISTS log_rollups (
+    bucket_seconds INTEGER NOT NULL,
+    bucket_start TIMESTAMPTZ NOT NULL,
+    service VARCHAR(100) NOT NULL,
+    level VARCHAR(20) NOT NULL,
+    host VARCHAR(255) NOT NULL, -- '' for logs without a host
+    stream VARCHAR(64) NOT NULL,
+    count BIGINT NOT NULL,
+    PRIMARY KEY (bucket_seconds, bucket_start, service, level, host, stream)
+);
+
+-- How far each bucket size is rolled up, and the sample rate it was counted with
+CREATE TABLE IF NOT EXISTS log_rollup_state (
+    bucket_seconds INTEGER PRIMARY KEY,
+    rolled_until TIMESTAMPTZ NOT NULL,
+    sample_rate INTEGER NOT NULL
+);
+
+-- Incident mode windows: until ends_at the services' logs skip sampling and
+-- get raised rate limits, and logs from the window are kept until keep_until
+CREATE TABLE IF NOT EXISTS incidents (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    services TEXT NOT NULL, -- JSON array of service names
+    started_at TIMESTAMPTZ NOT NULL,
+    ends_at TIMESTAMPTZ NOT NULL,
+    keep_until TIMESTAMPTZ NOT NULL
+);
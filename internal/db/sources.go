@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"locog/internal/models"
+)
+
+// upsertSources records, within the insert transaction, that logs arrived
+// from each host and service in the batch.
+func (db *DB) upsertSources(ctx context.Context, tx *dbTx, logs []models.Log) error {
+	type key struct{ host, service string }
+	counts := make(map[key]int64)
+	for _, l := range logs {
+		counts[key{l.Host, l.Service}]++
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO sources (host, service, logs, last_log_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(host, service) DO UPDATE SET
+			logs = sources.logs + excluded.logs,
+			last_log_at = excluded.last_log_at`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	for k, n := range counts {
+		if _, err := stmt.ExecContext(ctx, k.host, k.service, n, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordHeartbeat notes a heartbeat from a shipper; service may be empty.
+func (db *DB) RecordHeartbeat(ctx context.Context, host, service string, at time.Time) error {
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO sources (host, service, last_heartbeat_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(host, service) DO UPDATE SET last_heartbeat_at = excluded.last_heartbeat_at`,
+		host, service, at.UTC())
+	return err
+}
+
+// Sources returns every known source, ordered by host and service. LastSeen
+// is the later of the last log and heartbeat; QuietSeconds is left to the
+// caller.
+func (db *DB) Sources(ctx context.Context) ([]models.Source, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT host, service, logs, last_log_at, last_heartbeat_at FROM sources ORDER BY host, service")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := []models.Source{}
+	for rows.Next() {
+		var src models.Source
+		var lastLog, lastHeartbeat sql.NullTime
+		if err := rows.Scan(&src.Host, &src.Service, &src.Logs, &lastLog, &lastHeartbeat); err != nil {
+			return nil, err
+		}
+		if lastLog.Valid {
+			src.LastLogAt = &lastLog.Time
+			src.LastSeen = lastLog.Time
+		}
+		if lastHeartbeat.Valid {
+			src.LastHeartbeatAt = &lastHeartbeat.Time
+			if lastHeartbeat.Time.After(src.LastSeen) {
+				src.LastSeen = lastHeartbeat.Time
+			}
+		}
+		sources = append(sources, src)
+	}
+	return sources, rows.Err()
+}
+
+// LastLogAt returns when logs from service on host were last ingested, an
+// empty service or host matching any, or false when none have been.
+func (db *DB) LastLogAt(ctx context.Context, service, host string) (time.Time, bool, error) {
+	var last time.Time
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT last_log_at FROM sources
+		WHERE last_log_at IS NOT NULL AND (? = '' OR service = ?) AND (? = '' OR host = ?)
+		ORDER BY last_log_at DESC LIMIT 1`,
+		service, service, host, host).Scan(&last)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return last, true, nil
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestSources(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	if err := db.InsertBatch(ctx, []models.Log{
+		{Timestamp: now, Service: "api", Level: "INFO", Message: "a", Host: "web-1"},
+		{Timestamp: now, Service: "api", Level: "INFO", Message: "b", Host: "web-1"},
+		{Timestamp: now, Service: "worker", Level: "INFO", Message: "c", Host: "web-1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertLog(ctx, &models.Log{Timestamp: now, Service: "api", Level: "INFO", Message: "d", Host: "web-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordHeartbeat(ctx, "web-2", "", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordHeartbeat(ctx, "web-1", "api", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// Silent for longer than retention
+	if err := db.RecordHeartbeat(ctx, "old", "", now.Add(-40*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := db.Sources(ctx)
+	if err != nil {
+		t.Fatalf("Sources failed: %v", err)
+	}
+	if len(sources) != 4 {
+		t.Fatalf("expected 4 sources, got %+v", sources)
+	}
+	api := sources[1]
+	if api.Host != "web-1" || api.Service != "api" || api.Logs != 3 || api.LastLogAt == nil ||
+		api.LastHeartbeatAt == nil || !api.LastSeen.Equal(*api.LastHeartbeatAt) {
+		t.Errorf("unexpected api source: %+v", api)
+	}
+	if worker := sources[2]; worker.Service != "worker" || worker.Logs != 1 || worker.LastHeartbeatAt != nil ||
+		!worker.LastSeen.Equal(*worker.LastLogAt) {
+		t.Errorf("unexpected worker source: %+v", worker)
+	}
+	if hb := sources[3]; hb.Host != "web-2" || hb.Service != "" || hb.Logs != 0 || hb.LastLogAt != nil {
+		t.Errorf("unexpected heartbeat source: %+v", hb)
+	}
+
+	if _, err := db.DeleteOldLogs(ctx, 30*24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	sources, _ = db.Sources(ctx)
+	if len(sources) != 3 || sources[0].Host != "web-1" {
+		t.Errorf("expected the long-silent source to be pruned, got %+v", sources)
+	}
+}
+
+func TestLastLogAt(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	if _, found, err := db.LastLogAt(ctx, "api", ""); err != nil || found {
+		t.Fatalf("expected no logs yet, got %v %v", found, err)
+	}
+	if err := db.InsertBatch(ctx, []models.Log{
+		{Timestamp: time.Now(), Service: "api", Level: "INFO", Message: "a", Host: "web-1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordHeartbeat(ctx, "web-2", "api", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		service, host string
+		found         bool
+	}{
+		{"api", "", true},
+		{"api", "web-1", true},
+		{"", "web-1", true},
+		{"api", "web-2", false}, // heartbeats aren't logs
+		{"worker", "", false},
+	}
+	for _, tt := range tests {
+		last, found, err := db.LastLogAt(ctx, tt.service, tt.host)
+		if err != nil {
+			t.Fatalf("LastLogAt(%q, %q) failed: %v", tt.service, tt.host, err)
+		}
+		if found != tt.found || found && time.Since(last) > time.Minute {
+			t.Errorf("LastLogAt(%q, %q) = %v, %v, want found=%v", tt.service, tt.host, last, found, tt.found)
+		}
+	}
+}
@@ -8,55 +8,278 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"strings"
 	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"locog/internal/models"
+	"locog/internal/tracing"
 )
 
 //go:embed schema.sql
 var schema string
 
-// filterCache caches filter options with a TTL
+// filterCache caches filter options. Entries are invalidated as soon as ingest
+// sees a service, level or host that is not in the seen set, so the TTL only
+// acts as a backstop for values disappearing through retention.
 type filterCache struct {
 	mu      sync.RWMutex
 	options models.FilterOptions
 	expires time.Time
+	seen    map[string]map[string]struct{} // column -> values seen at ingest
+
+	// generation is bumped on every invalidation so a refresh that raced with
+	// an insert doesn't cache results that predate it.
+	generation uint64
 }
 
-const filterCacheTTL = 30 * time.Second
+const filterCacheTTL = 5 * time.Minute
+
+// DefaultQueryLimit is the number of logs QueryLogs returns when the filter
+// sets no limit.
+const DefaultQueryLimit = 1000
+
+// maxSeenFilterValues bounds the seen set per column. Once a column exceeds it,
+// the set is reset, costing at most one extra DISTINCT refresh per new value.
+const maxSeenFilterValues = 10000
+
+// deleteChunkSize is the number of logs DeleteOldLogs deletes per statement.
+const deleteChunkSize = 10000
 
 type DB struct {
-	conn        *sql.DB
+	conn        *dbConn
+	dialect     dialect
 	filterCache filterCache
+	migrations  migrationTracker
+	maintenance maintenanceTracker
+
+	// indexed maps metadata keys to their generated columns
+	indexed map[string]string
+
+	derivedFields
+	policies
+
+	// encrypted is set for SQLite databases opened with an EncryptionKey
+	encrypted bool
+	// readOnly is set for databases opened with Options.ReadOnly
+	readOnly bool
+	// lock is held while a SQLite file is open for writing
+	lock *writerLock
+}
+
+// Options configures how the database is opened.
+type Options struct {
+	// BackgroundMigrations runs data migrations (backfills) in a background
+	// goroutine so the service can serve reads while they complete.
+	BackgroundMigrations bool
+
+	// IndexedMetadataKeys are metadata keys (e.g. request_id, trace_id) copied
+	// into indexed generated columns, so equality filters on them use an index
+	// instead of scanning the metadata of every log.
+	IndexedMetadataKeys []string
+
+	// ReadOnly opens an existing SQLite database without writing to it: no
+	// schema changes or migrations. It is for serving a backup snapshot.
+	ReadOnly bool
+
+	// EncryptionKey opens the SQLite database with SQLCipher, keyed with
+	// these 32 bytes. It requires a build linked against SQLCipher; see
+	// openEncrypted.
+	EncryptionKey []byte
+
+	// MaxOpenConns and MaxIdleConns size the connection pool; 0 uses
+	// defaultMaxOpenConns for both. Writes to SQLite are serialized whatever
+	// the pool size, so it bounds parallel reads.
+	MaxOpenConns int
+	MaxIdleConns int
 }
 
 func New(dbPath string) (*DB, error) {
+	return NewWithOptions(dbPath, Options{})
+}
+
+// NewWithOptions opens the SQLite database at dbPath. Unless it is opened
+// read-only, it takes the database's writer lock, failing with ErrInUse while
+// another process holds it.
+func NewWithOptions(dbPath string, opts Options) (*DB, error) {
+	if opts.ReadOnly || dbPath == ":memory:" {
+		return openSQLite(dbPath, opts)
+	}
+	lock, err := lockWriter(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	db, err := openSQLite(dbPath, opts)
+	if err != nil {
+		lock.release()
+		return nil, err
+	}
+	db.lock = lock
+	if err := db.enableIncrementalVacuum(context.Background()); err != nil {
+		// Cleanups still work, they just can't shrink the file
+		slog.Error("failed to enable incremental vacuum", "error", err)
+	}
+	return db, nil
+}
+
+// enableIncrementalVacuum converts a database created before auto_vacuum was
+// set, which only applies to new files, to auto_vacuum=incremental so
+// reclaimSpace can shrink it. That takes a VACUUM, rewriting the whole file
+// once; the pragma and the VACUUM must run on the same connection.
+func (db *DB) enableIncrementalVacuum(ctx context.Context) error {
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var mode int
+	if err := conn.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return err
+	}
+	if mode == 2 {
+		return nil
+	}
+	slog.Info("converting database to auto_vacuum=incremental, rewriting it once", "auto_vacuum", mode)
+	start := time.Now()
+	if _, err := conn.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+		return err
+	}
+	slog.Info("database converted to auto_vacuum=incremental", "duration_ms", time.Since(start).Milliseconds())
+	return nil
+}
+
+// openSQLite opens the SQLite database at dbPath, encrypted or read-only as
+// opts ask.
+func openSQLite(dbPath string, opts Options) (*DB, error) {
+	if len(opts.EncryptionKey) > 0 {
+		return openEncrypted(dbPath, opts)
+	}
+	if opts.ReadOnly {
+		// mode=ro needs a URI; WAL mode can't be set on a read-only file
+		return open(sqliteDriver, "file:"+dbPath+"?mode=ro&_query_only=true&_busy_timeout=5000&_cache_size=-64000", sqliteDialect{}, opts)
+	}
 	// Configure pragmas via DSN so they apply to ALL connections created by
 	// the pool, not just the first one. Without this, new pool connections
 	// default to busy_timeout=0 and fail immediately on lock contention.
-	dsn := dbPath + "?_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL&_cache_size=-64000"
+	// auto_vacuum only takes effect on a new file; enableIncrementalVacuum
+	// converts older ones.
+	dsn := dbPath + "?_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL&_cache_size=-64000&_auto_vacuum=incremental"
+	return open(sqliteDriver, dsn, sqliteDialect{}, opts)
+}
 
-	conn, err := sql.Open("sqlite3", dsn)
+// open connects with the given driver, initializes the schema and runs data
+// migrations.
+func open(driver, dsn string, d dialect, opts Options) (*DB, error) {
+	sqlDB, err := sql.Open(driver, dsn)
 	if err != nil {
 		return nil, err
 	}
+	return openDB(sqlDB, d, opts)
+}
+
+// openDB initializes the schema of an opened database and runs data
+// migrations.
+func openDB(sqlDB *sql.DB, d dialect, opts Options) (*DB, error) {
+	maxOpen, maxIdle := opts.MaxOpenConns, opts.MaxIdleConns
+	if maxOpen <= 0 {
+		maxOpen = defaultMaxOpenConns()
+	}
+	if maxIdle <= 0 {
+		maxIdle = maxOpen
+	}
+	sqlDB.SetMaxOpenConns(maxOpen)
+	sqlDB.SetMaxIdleConns(min(maxIdle, maxOpen))
+
+	conn := &dbConn{DB: sqlDB, dialect: d}
+	if d.name() == "sqlite" && !opts.ReadOnly {
+		conn.writer = newDBWriter()
+	}
+
+	indexed, err := indexedColumns(opts.IndexedMetadataKeys)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if opts.ReadOnly {
+		if err := conn.Ping(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return &DB{conn: conn, dialect: d, indexed: indexed, encrypted: len(opts.EncryptionKey) > 0, readOnly: true}, nil
+	}
 
 	// Initialize schema
 	if err := initSchema(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := indexMetadataKeys(conn, indexed); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	db := &DB{conn: conn, dialect: d, indexed: indexed, encrypted: len(opts.EncryptionKey) > 0}
+	if err := db.runMigrations(opts.BackgroundMigrations); err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	return &DB{conn: conn}, nil
+	return db, nil
 }
 
-func initSchema(conn *sql.DB) error {
-	_, err := conn.Exec(schema)
-	return err
+// DefaultStream is the logical stream of logs not routed anywhere else.
+const DefaultStream = "default"
+
+// columnUpgrades adds columns introduced after a database was created. The
+// schemas declare them for new databases; indexes on them are created
+// here since they can't exist before the column does.
+var columnUpgrades = []struct {
+	table, column, definition, index string
+}{
+	{"logs", "stream", "VARCHAR(64) NOT NULL DEFAULT 'default'",
+		"CREATE INDEX IF NOT EXISTS idx_stream_timestamp ON logs(stream, timestamp DESC)"},
+}
+
+func initSchema(conn *dbConn) error {
+	if _, err := conn.Exec(conn.dialect.schema()); err != nil {
+		return err
+	}
+
+	for _, u := range columnUpgrades {
+		var exists bool
+		err := conn.QueryRow(conn.dialect.columnExists(), u.table, u.column).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			slog.Info("adding column", "table", u.table, "column", u.column)
+			if _, err := conn.Exec("ALTER TABLE " + u.table + " ADD COLUMN " + u.column + " " + u.definition); err != nil {
+				return fmt.Errorf("add column %s.%s: %w", u.table, u.column, err)
+			}
+		}
+		if _, err := conn.Exec(u.index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamName returns the stream a log is stored in.
+func streamName(l *models.Log) string {
+	if l.Stream == "" {
+		return DefaultStream
+	}
+	return l.Stream
 }
 
 func (db *DB) InsertLog(ctx context.Context, log *models.Log) error {
+	ctx, span := db.startSpan(ctx, "InsertLog")
+	defer span.End()
+
 	var metadataJSON []byte
 	if log.Metadata != nil {
 		var err error
@@ -66,15 +289,40 @@ func (db *DB) InsertLog(ctx context.Context, log *models.Log) error {
 		}
 	}
 
-	_, err := db.conn.ExecContext(ctx, `
-		INSERT INTO logs (timestamp, service, level, message, metadata, host)
-		VALUES (?, ?, ?, ?, ?, ?)`,
-		log.Timestamp, log.Service, log.Level, log.Message, metadataJSON, log.Host,
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO logs (timestamp, service, level, message, metadata, host, stream)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		log.Timestamp, log.Service, log.Level, log.Message, db.dialect.jsonArg(metadataJSON), log.Host, streamName(log),
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	if err := db.upsertFilterValues(ctx, tx, []models.Log{*log}); err != nil {
+		return err
+	}
+	if err := db.upsertSources(ctx, tx, []models.Log{*log}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	db.noteFilterValues(*log)
+	return nil
 }
 
 func (db *DB) InsertBatch(ctx context.Context, logs []models.Log) error {
+	ctx, span := db.startSpan(ctx, "InsertBatch", tracing.Int("db.logs", len(logs)))
+	defer span.End()
+
 	tx, err := db.conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
@@ -82,8 +330,8 @@ func (db *DB) InsertBatch(ctx context.Context, logs []models.Log) error {
 	defer tx.Rollback()
 
 	stmt, err := tx.PrepareContext(ctx, `
-		INSERT INTO logs (timestamp, service, level, message, metadata, host)
-		VALUES (?, ?, ?, ?, ?, ?)`)
+		INSERT INTO logs (timestamp, service, level, message, metadata, host, stream)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
@@ -102,86 +350,507 @@ func (db *DB) InsertBatch(ctx context.Context, logs []models.Log) error {
 		}
 
 		_, err = stmt.ExecContext(ctx, logEntry.Timestamp, logEntry.Service, logEntry.Level,
-			logEntry.Message, metadataJSON, logEntry.Host)
+			logEntry.Message, db.dialect.jsonArg(metadataJSON), logEntry.Host, streamName(&logEntry))
 		if err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
+	if err := db.upsertFilterValues(ctx, tx, logs); err != nil {
+		return err
+	}
+	if err := db.upsertSources(ctx, tx, logs); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	db.noteFilterValues(logs...)
+	return nil
 }
 
-func (db *DB) QueryLogs(ctx context.Context, filter models.LogFilter) ([]models.Log, error) {
-	query := `SELECT id, timestamp, service, level, message, metadata, host, created_at
-              FROM logs WHERE 1=1`
+// upsertFilterValues updates the materialized filter values for the given logs
+// within the insert transaction.
+func (db *DB) upsertFilterValues(ctx context.Context, tx *dbTx, logs []models.Log) error {
+	type key struct{ kind, value string }
+	type entry struct {
+		count    int64
+		lastSeen time.Time
+	}
+
+	// Aggregate first so a batch costs one upsert per distinct value
+	entries := make(map[key]*entry)
+	for _, l := range logs {
+		for _, k := range [...]key{{"service", l.Service}, {"level", l.Level}, {"host", l.Host}} {
+			e, ok := entries[k]
+			if !ok {
+				e = &entry{}
+				entries[k] = e
+			}
+			e.count++
+			if l.Timestamp.After(e.lastSeen) {
+				e.lastSeen = l.Timestamp
+			}
+		}
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO filter_values (kind, value, count, last_seen)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(kind, value) DO UPDATE SET
+			count = filter_values.count + excluded.count,
+			last_seen = `+db.dialect.greatest("filter_values.last_seen", "excluded.last_seen"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for k, e := range entries {
+		if _, err := stmt.ExecContext(ctx, k.kind, k.value, e.count, e.lastSeen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// noteFilterValues records the filterable values of newly inserted logs and
+// invalidates the filter cache if any of them has not been seen before.
+func (db *DB) noteFilterValues(logs ...models.Log) {
+	db.filterCache.mu.Lock()
+	defer db.filterCache.mu.Unlock()
+
+	if db.filterCache.seen == nil {
+		db.filterCache.seen = make(map[string]map[string]struct{})
+	}
+
+	changed := false
+	for _, l := range logs {
+		for _, f := range [...]struct{ column, value string }{{"service", l.Service}, {"level", l.Level}, {"host", l.Host}} {
+			values := db.filterCache.seen[f.column]
+			if values == nil || len(values) >= maxSeenFilterValues {
+				values = make(map[string]struct{})
+				db.filterCache.seen[f.column] = values
+			}
+			if _, ok := values[f.value]; !ok {
+				values[f.value] = struct{}{}
+				changed = true
+			}
+		}
+	}
+
+	if changed {
+		db.filterCache.expires = time.Time{}
+		db.filterCache.generation++
+	}
+}
+
+// invalidateFilterCache forces the next GetFilterOptions call to hit the database.
+func (db *DB) invalidateFilterCache() {
+	db.filterCache.mu.Lock()
+	db.filterCache.expires = time.Time{}
+	db.filterCache.generation++
+	db.filterCache.mu.Unlock()
+}
+
+// patternsClause matches column against any of the globs. Plain names go in
+// an IN list, which can use the column's index.
+func (db *DB) patternsClause(column string, patterns []string) (string, []interface{}) {
+	var names, clauses []string
+	var args []interface{}
+	for _, p := range patterns {
+		if strings.ContainsAny(p, "*?") {
+			clauses = append(clauses, db.dialect.glob(column))
+			args = append(args, db.dialect.globPattern(p))
+		} else {
+			names = append(names, p)
+		}
+	}
+	if len(names) > 0 {
+		clauses = append(clauses, column+" IN (?"+strings.Repeat(", ?", len(names)-1)+")")
+		for _, n := range names {
+			args = append(args, n)
+		}
+	}
+	return "(" + strings.Join(clauses, " OR ") + ")", args
+}
+
+// buildWhere returns the WHERE clause (including the leading "WHERE") and its
+// arguments for the given filter.
+func (db *DB) buildWhere(filter models.LogFilter) (string, []interface{}) {
+	where := " WHERE 1=1"
 	args := []interface{}{}
 
 	if filter.Service != "" {
-		query += " AND service = ?"
+		where += " AND service = ?"
 		args = append(args, filter.Service)
 	}
 	if filter.Level != "" {
-		query += " AND level = ?"
+		where += " AND level = ?"
 		args = append(args, filter.Level)
 	}
+	if len(filter.Levels) > 0 {
+		where += " AND UPPER(level) IN (?" + strings.Repeat(", ?", len(filter.Levels)-1) + ")"
+		for _, l := range filter.Levels {
+			args = append(args, strings.ToUpper(l))
+		}
+	}
+	if len(filter.ExcludeLevels) > 0 {
+		where += " AND UPPER(level) NOT IN (?" + strings.Repeat(", ?", len(filter.ExcludeLevels)-1) + ")"
+		for _, l := range filter.ExcludeLevels {
+			args = append(args, strings.ToUpper(l))
+		}
+	}
 	if filter.Host != "" {
-		query += " AND host = ?"
+		where += " AND host = ?"
 		args = append(args, filter.Host)
 	}
+	if filter.Stream != "" {
+		where += " AND stream = ?"
+		args = append(args, filter.Stream)
+	}
+	if len(filter.ExcludeServices) > 0 {
+		where += " AND service NOT IN (?" + strings.Repeat(", ?", len(filter.ExcludeServices)-1) + ")"
+		for _, s := range filter.ExcludeServices {
+			args = append(args, s)
+		}
+	}
+	if len(filter.ExcludeHosts) > 0 {
+		where += " AND COALESCE(host, '') NOT IN (?" + strings.Repeat(", ?", len(filter.ExcludeHosts)-1) + ")"
+		for _, h := range filter.ExcludeHosts {
+			args = append(args, h)
+		}
+	}
+	if len(filter.HostPatterns) > 0 {
+		clause, patternArgs := db.patternsClause("host", filter.HostPatterns)
+		where += " AND " + clause
+		args = append(args, patternArgs...)
+	}
+	if len(filter.ServicePatterns) > 0 {
+		clause, patternArgs := db.patternsClause("service", filter.ServicePatterns)
+		where += " AND " + clause
+		args = append(args, patternArgs...)
+	}
+	for _, s := range filter.ExcludeSearch {
+		where += " AND NOT " + db.dialect.search("message")
+		args = append(args, "%"+s+"%")
+	}
+	if len(filter.ExcludeStreams) > 0 {
+		where += " AND stream NOT IN (?" + strings.Repeat(", ?", len(filter.ExcludeStreams)-1) + ")"
+		for _, name := range filter.ExcludeStreams {
+			args = append(args, name)
+		}
+	}
 	if filter.StartTime != nil {
-		query += " AND timestamp >= ?"
+		where += " AND timestamp >= ?"
 		args = append(args, filter.StartTime)
 	}
 	if filter.EndTime != nil {
-		query += " AND timestamp <= ?"
+		where += " AND timestamp <= ?"
 		args = append(args, filter.EndTime)
 	}
-	if filter.Search != "" {
-		query += " AND message LIKE ?"
+	if filter.Search != "" && filter.SearchRegex {
+		where += " AND " + db.dialect.regexp("message")
+		args = append(args, filter.Search)
+	} else if filter.Search != "" {
+		where += " AND " + db.dialect.search("message")
 		args = append(args, "%"+filter.Search+"%")
 	}
+	if filter.SinceID > 0 {
+		where += " AND id > ?"
+		args = append(args, filter.SinceID)
+	}
+	if filter.MaxID > 0 {
+		where += " AND id <= ?"
+		args = append(args, filter.MaxID)
+	}
+	if c := filter.Cursor; c != nil {
+		// Continue in the order eachLog sorts by
+		if filter.Ascending {
+			where += " AND (timestamp > ? OR (timestamp = ? AND id < ?))"
+		} else {
+			where += " AND (timestamp < ? OR (timestamp = ? AND id > ?))"
+		}
+		args = append(args, c.Timestamp, c.Timestamp, c.ID)
+	}
+	if filter.Tag != "" {
+		where += " AND id IN (SELECT log_id FROM log_tags WHERE tag = ?)"
+		args = append(args, filter.Tag)
+	}
+	for _, m := range filter.Meta {
+		var clause string
+		var clauseArgs []interface{}
+		if m.Derived {
+			clause, clauseArgs = db.derivedFilterClause(m)
+		} else {
+			clause, clauseArgs = db.metaFilterClause(m)
+		}
+		where += " AND " + clause
+		args = append(args, clauseArgs...)
+	}
 
-	query += " ORDER BY timestamp DESC"
+	return where, args
+}
+
+// metaJSONPath converts a dotted metadata key into a quoted SQLite JSON path,
+// e.g. http.status -> $."http"."status".
+func metaJSONPath(key string) string {
+	path := "$"
+	for _, part := range strings.Split(key, ".") {
+		path += `."` + strings.ReplaceAll(part, `"`, "") + `"`
+	}
+	return path
+}
+
+// metaNumericOps are the operators that may be interpolated into SQL.
+var metaNumericOps = map[string]bool{"=": true, "!=": true, ">": true, ">=": true, "<": true, "<=": true}
+
+// metaFilterClause builds the SQL condition for a metadata filter. Numeric
+// comparisons only match JSON numbers or strings that look like numbers, so
+// non-numeric text doesn't CAST to 0 and match by accident. Negated filters
+// also match logs where the key is absent.
+func (db *DB) metaFilterClause(m models.MetaFilter) (string, []interface{}) {
+	if !m.Numeric {
+		value, args := db.metaValue(m.Key)
+		if m.Op == "!=" {
+			return value + " IS DISTINCT FROM ?", append(args, m.Value)
+		}
+		return value + " = ?", append(args, m.Value)
+	}
+
+	path := db.dialect.metaPath(m.Key)
+
+	op := m.Op
+	if !metaNumericOps[op] {
+		return "1 = 0", nil
+	}
+	number, args := db.dialect.metaNumber(path)
+	if op == "!=" {
+		return number + " IS DISTINCT FROM ?", append(args, m.Number)
+	}
+	guard, guardArgs := db.dialect.numericGuard(path)
+	return guard + " AND " + number + " " + op + " ?", append(append(guardArgs, args...), m.Number)
+}
+
+// derivedFilterClause builds the SQL condition for a filter on a derived
+// field. Unknown fields match nothing.
+func (db *DB) derivedFilterClause(m models.MetaFilter) (string, []interface{}) {
+	expr, ok := db.derivedSQL(m.Key)
+	if !ok || !metaNumericOps[m.Op] {
+		return "1 = 0", nil
+	}
+
+	var value interface{} = m.Value
+	if m.Numeric {
+		value = m.Number
+	}
+	if m.Op == "!=" {
+		return expr + " IS DISTINCT FROM ?", []interface{}{value}
+	}
+	return expr + " " + m.Op + " ?", []interface{}{value}
+}
+
+func (db *DB) QueryLogs(ctx context.Context, filter models.LogFilter) ([]models.Log, error) {
+	ctx, span := db.startSpan(ctx, "QueryLogs")
+	defer span.End()
+
+	var logs []models.Log
+	err := db.eachLog(ctx, filter, func(log models.Log) error {
+		logs = append(logs, log)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(tracing.Int("db.rows", len(logs)))
+	return logs, nil
+}
+
+// EachLog calls fn with each log QueryLogs would return, in order, without
+// holding them all in memory. It stops at the first error fn returns.
+func (db *DB) EachLog(ctx context.Context, filter models.LogFilter, fn func(models.Log) error) error {
+	ctx, span := db.startSpan(ctx, "EachLog")
+	defer span.End()
+	return db.eachLog(ctx, filter, fn)
+}
+
+func (db *DB) eachLog(ctx context.Context, filter models.LogFilter, fn func(models.Log) error) error {
+	_, build := tracing.Start(ctx, "db.build_query", tracing.KindInternal)
+	where, args := db.buildWhere(filter)
+	query := db.selectLogs(filter.OmitMetadata) + where
+
+	if filter.SinceID > 0 {
+		// Tailing: oldest first so a limit never skips logs
+		query += " ORDER BY id ASC"
+	} else if filter.Ascending {
+		// IDs break ties so pages continuing from a cursor neither skip
+		// nor repeat logs. The timestamp indexes are descending with IDs
+		// ascending, so each order is an index scan without a sort.
+		query += " ORDER BY timestamp ASC, id DESC"
+	} else {
+		query += " ORDER BY timestamp DESC, id ASC"
+	}
 
 	limit := filter.Limit
 	if limit <= 0 {
-		limit = 1000 // Default limit
+		limit = DefaultQueryLimit
 	}
 	query += " LIMIT ?"
 	args = append(args, limit)
+	build.SetAttributes(tracing.String("db.query.text", query), tracing.Int("db.query.args", len(args)))
+	build.End()
+	return db.scanLogs(ctx, query, args, fn)
+}
+
+// selectLogs is the SELECT of full logs, as scanned by scanLogs, up to the
+// WHERE clause.
+func (db *DB) selectLogs(omitMetadata bool) string {
+	metadata := "metadata"
+	if omitMetadata {
+		metadata = "NULL"
+	}
+	return `SELECT id, timestamp, service, level, message, ` + metadata + `, host, created_at, stream,
+              ` + db.dialect.tagList() + `
+              FROM logs`
+}
+
+// scanLogs runs a query built on selectLogs and calls fn with each log.
+func (db *DB) scanLogs(ctx context.Context, query string, args []interface{}, fn func(models.Log) error) (err error) {
+	// SQLite mostly executes as rows are read, so the span covers both
+	ctx, span := tracing.Start(ctx, "db.execute", tracing.KindInternal)
+	n := 0
+	defer func() {
+		span.SetAttributes(tracing.Int("db.rows", n))
+		span.RecordError(err)
+		span.End()
+	}()
 
 	rows, err := db.conn.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var logs []models.Log
 	for rows.Next() {
+		n++
 		var log models.Log
 		var metadataJSON []byte
+		var tags sql.NullString
 
 		err := rows.Scan(&log.ID, &log.Timestamp, &log.Service, &log.Level,
-			&log.Message, &metadataJSON, &log.Host, &log.CreatedAt)
+			&log.Message, &metadataJSON, &log.Host, &log.CreatedAt, &log.Stream, &tags)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		if len(metadataJSON) > 0 {
 			json.Unmarshal(metadataJSON, &log.Metadata)
 		}
+		if tags.Valid {
+			log.Tags = strings.Split(tags.String, "\x1f")
+		}
 
-		logs = append(logs, log)
+		if err := fn(log); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// MaxLogID returns the highest log ID, or 0 when there are no logs.
+func (db *DB) MaxLogID(ctx context.Context) (int64, error) {
+	var id int64
+	err := db.conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM logs").Scan(&id)
+	return id, err
+}
+
+// CountLogs returns the number of logs matching filter, ignoring its limit.
+func (db *DB) CountLogs(ctx context.Context, filter models.LogFilter) (int64, error) {
+	ctx, span := db.startSpan(ctx, "CountLogs")
+	defer span.End()
+
+	where, args := db.buildWhere(filter)
+	var count int64
+	err := db.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM logs"+where, args...).Scan(&count)
+	return count, err
+}
+
+// CountLogsUpTo is CountLogs but stops counting at max, bounding the cost of
+// estimating very large result sets.
+func (db *DB) CountLogsUpTo(ctx context.Context, filter models.LogFilter, max int64) (int64, error) {
+	ctx, span := db.startSpan(ctx, "CountLogsUpTo")
+	defer span.End()
+
+	where, args := db.buildWhere(filter)
+	var count int64
+	err := db.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM (SELECT 1 FROM logs"+where+" LIMIT ?) AS matched",
+		append(args, max)...).Scan(&count)
+	return count, err
+}
+
+// Histogram counts logs matching filter in equal-width buckets between start
+// and end. The filter's own time range is ignored in favour of start/end.
+func (db *DB) Histogram(ctx context.Context, filter models.LogFilter, start, end time.Time, buckets int) ([]int64, error) {
+	ctx, span := db.startSpan(ctx, "Histogram", tracing.Int("db.buckets", buckets))
+	defer span.End()
+
+	if buckets <= 0 {
+		return nil, fmt.Errorf("buckets must be positive, got %d", buckets)
+	}
+	if !end.After(start) {
+		return make([]int64, buckets), nil
+	}
+
+	// Exact rollups count the buckets they cover; the logs only the rest
+	counts := make([]int64, buckets)
+	from, err := db.rollupHistogram(ctx, filter, start, end, end.Sub(start)/time.Duration(buckets), counts)
+	if err != nil {
+		return nil, err
+	}
+
+	filter.StartTime = &from
+	filter.EndTime = &end
+	where, args := db.buildWhere(filter)
+
+	width := end.Sub(start).Seconds() / float64(buckets)
+	query := `SELECT ` + db.dialect.histogramBucket() + ` AS bucket, COUNT(*)
+              FROM logs` + where + ` GROUP BY bucket`
+	args = append([]interface{}{start, width}, args...)
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket, count int64
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return nil, err
+		}
+		// The end bound is inclusive, so a log exactly at end lands one past the last bucket.
+		if bucket >= int64(buckets) {
+			bucket = int64(buckets) - 1
+		}
+		if bucket < 0 {
+			continue
+		}
+		counts[bucket] += count
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return logs, nil
+	return counts, nil
 }
 
 func (db *DB) GetFilterOptions(ctx context.Context) (models.FilterOptions, error) {
+	ctx, span := db.startSpan(ctx, "GetFilterOptions")
+	defer span.End()
+
 	// Check cache first
 	db.filterCache.mu.RLock()
 	if time.Now().Before(db.filterCache.expires) {
@@ -190,6 +859,7 @@ func (db *DB) GetFilterOptions(ctx context.Context) (models.FilterOptions, error
 		slog.Debug("filter options served from cache")
 		return options, nil
 	}
+	generation := db.filterCache.generation
 	db.filterCache.mu.RUnlock()
 
 	// Cache miss or expired - fetch from database
@@ -229,33 +899,57 @@ func (db *DB) GetFilterOptions(ctx context.Context) (models.FilterOptions, error
 	slog.Info("filter options fetched from database", "total_duration_ms", time.Since(totalStart).Milliseconds(),
 		"services", len(services), "levels", len(levels), "hosts", len(hosts))
 
-	// Update cache
+	// Update cache and seed the seen set so known values don't invalidate it
 	db.filterCache.mu.Lock()
-	db.filterCache.options = options
-	db.filterCache.expires = time.Now().Add(filterCacheTTL)
+	if db.filterCache.generation == generation {
+		db.filterCache.options = options
+		db.filterCache.expires = time.Now().Add(filterCacheTTL)
+	}
+	if db.filterCache.seen == nil {
+		db.filterCache.seen = make(map[string]map[string]struct{})
+	}
+	for column, values := range map[string][]string{"service": services, "level": levels, "host": hosts} {
+		if db.filterCache.seen[column] == nil {
+			db.filterCache.seen[column] = make(map[string]struct{})
+		}
+		for _, v := range values {
+			db.filterCache.seen[column][v] = struct{}{}
+		}
+	}
 	db.filterCache.mu.Unlock()
 
 	return options, nil
 }
 
-// allowedFilterColumns defines the only column names that can be used in getDistinctValues
-// to prevent SQL injection if the function is ever called with user input.
+// allowedFilterColumns defines the only column names that are materialized in
+// filter_values. The backfill interpolates them into SQL, so this allowlist
+// also guards against SQL injection.
 var allowedFilterColumns = map[string]bool{
 	"service": true,
 	"level":   true,
 	"host":    true,
 }
 
+// getDistinctValues returns the distinct values of column from the materialized
+// filter_values table rather than scanning logs.
 func (db *DB) getDistinctValues(ctx context.Context, column string) ([]string, error) {
-	// Validate column name against allowlist to prevent SQL injection
+	// Validate column name against allowlist
 	if !allowedFilterColumns[column] {
 		return nil, fmt.Errorf("invalid column name: %s", column)
 	}
 
-	// Limit to 100 values to keep dropdowns usable
-	query := fmt.Sprintf("SELECT DISTINCT %s FROM logs WHERE %s IS NOT NULL ORDER BY %s LIMIT 100",
-		column, column, column)
-	rows, err := db.conn.QueryContext(ctx, query)
+	// Limit to 100 values to keep dropdowns usable. Until the filter_values
+	// backfill has finished, fall back to scanning logs directly.
+	var rows *sql.Rows
+	var err error
+	if db.migrations.done(filterValuesMigration) {
+		rows, err = db.conn.QueryContext(ctx,
+			"SELECT value FROM filter_values WHERE kind = ? ORDER BY value LIMIT 100", column)
+	} else {
+		query := fmt.Sprintf("SELECT DISTINCT %s FROM logs WHERE %s IS NOT NULL ORDER BY %s LIMIT 100",
+			column, column, column)
+		rows, err = db.conn.QueryContext(ctx, query)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -277,15 +971,205 @@ func (db *DB) getDistinctValues(ctx context.Context, column string) ([]string, e
 	return values, nil
 }
 
+// SuggestFilterValues returns up to limit values of column starting with
+// prefix, compared case-insensitively, most frequent first. Like
+// getDistinctValues it reads filter_values once the backfill has finished.
+func (db *DB) SuggestFilterValues(ctx context.Context, column, prefix string, limit int) ([]string, error) {
+	ctx, span := db.startSpan(ctx, "SuggestFilterValues", tracing.String("db.column", column))
+	defer span.End()
+
+	if !allowedFilterColumns[column] {
+		return nil, fmt.Errorf("invalid column name: %s", column)
+	}
+
+	var rows *sql.Rows
+	var err error
+	if db.migrations.done(filterValuesMigration) {
+		rows, err = db.conn.QueryContext(ctx, "SELECT value FROM filter_values WHERE kind = ? AND "+
+			db.dialect.search("value")+" ORDER BY count DESC, value LIMIT ?", column, prefix+"%", limit)
+	} else {
+		query := fmt.Sprintf("SELECT DISTINCT %s FROM logs WHERE %s ORDER BY %s LIMIT ?",
+			column, db.dialect.search(column), column)
+		rows, err = db.conn.QueryContext(ctx, query, prefix+"%", limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var val string
+		if err := rows.Scan(&val); err != nil {
+			return nil, err
+		}
+		values = append(values, val)
+	}
+	return values, rows.Err()
+}
+
+// DeleteOldLogs deletes logs older than olderThan, or their stream's retention
+// (see SetStreamRetention), except those kept longer by a tag policy (see
+// SetTagPolicies) or an incident (see StartIncident).
 func (db *DB) DeleteOldLogs(ctx context.Context, olderThan time.Duration) (int64, error) {
-	cutoff := time.Now().Add(-olderThan)
-	result, err := db.conn.ExecContext(ctx, "DELETE FROM logs WHERE timestamp < ?", cutoff)
+	ctx, span := db.startSpan(ctx, "DeleteOldLogs")
+	defer span.End()
+
+	now := time.Now()
+	incidents, err := db.Incidents(ctx, now)
 	if err != nil {
 		return 0, err
 	}
-	return result.RowsAffected()
+	rule := db.buildRetentionRule(now, olderThan, db.StreamRetention(), db.TagPolicies(), db.ArchiveHolds(), incidents)
+	where, args := rule.deleteWhere()
+	deleted, err := db.deleteLogs(ctx, where, args, deleteChunkSize)
+	db.maintenance.deleted(deleted)
+	if err != nil {
+		return deleted, err
+	}
+
+	// Drop tags and export records of deleted logs
+	for _, table := range []string{"log_tags", "tag_exports"} {
+		if _, err := db.conn.ExecContext(ctx, "DELETE FROM "+table+
+			" WHERE NOT EXISTS (SELECT 1 FROM logs WHERE logs.id = "+table+".log_id)"); err != nil {
+			return deleted, err
+		}
+	}
+
+	// Vault entries outlive their logs until past the oldest retained log,
+	// since entries don't record which log references them
+	if _, err := db.conn.ExecContext(ctx, "DELETE FROM redaction_vault WHERE created_at < ?", rule.oldest.UTC()); err != nil {
+		return deleted, err
+	}
+
+	// Forget sources silent for longer than any log is kept
+	if _, err := db.conn.ExecContext(ctx, "DELETE FROM sources WHERE COALESCE(last_log_at, last_heartbeat_at) < ? AND COALESCE(last_heartbeat_at, last_log_at) < ?",
+		rule.oldest.UTC(), rule.oldest.UTC()); err != nil {
+		return deleted, err
+	}
+
+	if _, err := db.conn.ExecContext(ctx, "DELETE FROM log_rollups WHERE bucket_start < ?", rule.oldest.UTC()); err != nil {
+		return deleted, err
+	}
+
+	if err := db.deleteExpiredIncidents(ctx, now); err != nil {
+		return deleted, err
+	}
+
+	// Prune values that no longer have any logs within retention. Logs kept by
+	// a tag policy may be older than cutoff, so only prune past the oldest one.
+	pruned, err := db.conn.ExecContext(ctx, "DELETE FROM filter_values WHERE last_seen < ?", rule.oldest)
+	if err != nil {
+		return deleted, err
+	}
+	if n, _ := pruned.RowsAffected(); n > 0 {
+		slog.Info("pruned stale filter values", "count", n)
+	}
+
+	if deleted > 0 {
+		// Values may have disappeared entirely
+		db.invalidateFilterCache()
+		if err := db.reclaimSpace(ctx); err != nil {
+			return deleted, fmt.Errorf("reclaim space: %w", err)
+		}
+	}
+	return deleted, nil
+}
+
+// reclaimSpace runs the dialect's reclaimSpace statements holding the write
+// lock. Their rows are read to the end: PRAGMA incremental_vacuum frees a page
+// per step, so Exec, which steps once, would free only one.
+func (db *DB) reclaimSpace(ctx context.Context) error {
+	for _, stmt := range db.dialect.reclaimSpace() {
+		release, err := db.conn.writer.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		rows, err := db.conn.QueryContext(ctx, stmt)
+		if err != nil {
+			release()
+			return err
+		}
+		for rows.Next() {
+		}
+		err = rows.Err()
+		rows.Close()
+		release()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteLogs deletes the logs matching where at most chunk at a time, each
+// chunk in its own statement, so ingestion waits for one chunk rather than
+// the whole cleanup.
+func (db *DB) deleteLogs(ctx context.Context, where string, args []interface{}, chunk int) (int64, error) {
+	query := "DELETE FROM logs WHERE id IN (SELECT id FROM logs" + where + " LIMIT ?)"
+	args = append(args, chunk)
+	var deleted int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		result, err := db.conn.ExecContext(ctx, query, args...)
+		if err != nil {
+			return deleted, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+		if n < int64(chunk) {
+			return deleted, nil
+		}
+	}
+}
+
+// startSpan starts a span for a storage operation. Query errors within it are
+// recorded by dbConn.
+func (db *DB) startSpan(ctx context.Context, op string, attrs ...tracing.Attr) (context.Context, *tracing.Span) {
+	system := db.dialect.name()
+	if system == "postgres" {
+		system = "postgresql" // the OpenTelemetry name
+	}
+	return tracing.Start(ctx, "db."+op, tracing.KindClient, append([]tracing.Attr{tracing.String("db.system", system)}, attrs...)...)
+}
+
+// MaxLogs returns 0: the database keeps every log until retention deletes
+// it.
+func (db *DB) MaxLogs() int {
+	return 0
+}
+
+// Backend returns the storage backend: "sqlite" or "postgres".
+func (db *DB) Backend() string {
+	return db.dialect.name()
+}
+
+// ReadOnly reports whether the database was opened read-only.
+func (db *DB) ReadOnly() bool {
+	return db.readOnly
+}
+
+// Encrypted reports whether the database was opened with an encryption key.
+func (db *DB) Encrypted() bool {
+	return db.encrypted
+}
+
+// Version returns the version of the linked SQLite library or of the
+// PostgreSQL server.
+func (db *DB) Version(ctx context.Context) (string, error) {
+	var version string
+	err := db.conn.QueryRowContext(ctx, db.dialect.version()).Scan(&version)
+	return version, err
 }
 
+// Close waits for background migrations to stop and closes the database.
 func (db *DB) Close() error {
+	defer db.lock.release()
+	db.migrations.stop()
 	return db.conn.Close()
 }
@@ -2,16 +2,19 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"locog/internal/models"
 )
 
-// newTestDB creates an in-memory SQLite database for testing.
+// newTestDB creates an in-memory SQLite database for testing. Each
+// connection to ":memory:" opens a database of its own, so the pool holds one.
 func newTestDB(t *testing.T) *DB {
 	t.Helper()
-	db, err := New(":memory:")
+	db, err := NewWithOptions(":memory:", Options{MaxOpenConns: 1})
 	if err != nil {
 		t.Fatalf("failed to create test database: %v", err)
 	}
@@ -298,6 +301,67 @@ func TestQueryLogs_SearchFilter(t *testing.T) {
 	}
 }
 
+func TestQueryLogs_SearchRegex(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	for _, msg := range []string{"payment failed: E1042", "payment failed: E77", "retrying E1042x later"} {
+		db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "svc", Level: "error", Message: msg, Host: "h"})
+	}
+
+	logs, err := db.QueryLogs(ctx, models.LogFilter{Search: `\bE\d{4}\b`, SearchRegex: true})
+	if err != nil {
+		t.Fatalf("QueryLogs failed: %v", err)
+	}
+	if len(logs) != 1 || logs[0].Message != "payment failed: E1042" {
+		t.Errorf("expected only the four digit error code to match, got %+v", logs)
+	}
+
+	if _, err := db.QueryLogs(ctx, models.LogFilter{Search: "(", SearchRegex: true}); err == nil {
+		t.Error("expected an invalid pattern to fail")
+	}
+}
+
+func TestQueryLogs_ExcludeFilters(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	for _, l := range []models.Log{
+		{Service: "api", Level: "info", Message: "GET /healthz 200", Host: "web-1"},
+		{Service: "api", Level: "error", Message: "GET /orders 500", Host: "web-1"},
+		{Service: "api", Level: "debug", Message: "cache miss", Host: "web-2"},
+		{Service: "healthcheck", Level: "info", Message: "ok", Host: "web-2"},
+		{Service: "worker", Level: "info", Message: "job done"},
+	} {
+		l.Timestamp = time.Now()
+		db.InsertLog(ctx, &l)
+	}
+
+	tests := []struct {
+		name   string
+		filter models.LogFilter
+		want   int
+	}{
+		{"services", models.LogFilter{ExcludeServices: []string{"healthcheck", "worker"}}, 3},
+		{"levels", models.LogFilter{ExcludeLevels: []string{"DEBUG"}}, 4},
+		{"hosts", models.LogFilter{ExcludeHosts: []string{"web-2"}}, 3},
+		{"messages", models.LogFilter{ExcludeSearch: []string{"/healthz", "cache"}}, 3},
+		{"combined", models.LogFilter{Service: "api", ExcludeLevels: []string{"debug"}, ExcludeSearch: []string{"HEALTHZ"}}, 1},
+		{"host patterns", models.LogFilter{HostPatterns: []string{"web-?"}}, 4},
+		{"host names and patterns", models.LogFilter{HostPatterns: []string{"web-1", "db-*"}}, 2},
+		{"service patterns", models.LogFilter{ServicePatterns: []string{"health*", "worker"}}, 2},
+	}
+	for _, tc := range tests {
+		logs, err := db.QueryLogs(ctx, tc.filter)
+		if err != nil {
+			t.Fatalf("%s: QueryLogs failed: %v", tc.name, err)
+		}
+		if len(logs) != tc.want {
+			t.Errorf("%s: expected %d logs, got %d", tc.name, tc.want, len(logs))
+		}
+	}
+}
+
 func TestQueryLogs_CombinedFilters(t *testing.T) {
 	db := newTestDB(t)
 	ctx := context.Background()
@@ -423,23 +487,77 @@ func TestGetFilterOptions_Caching(t *testing.T) {
 	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "msg", Host: "host-1"})
 
 	// First call - should fetch from DB
-	options1, err := db.GetFilterOptions(ctx)
+	if _, err := db.GetFilterOptions(ctx); err != nil {
+		t.Fatalf("GetFilterOptions failed: %v", err)
+	}
+	expires := db.filterCache.expires
+
+	// Known values must not invalidate the cache
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "again", Host: "host-1"})
+	if !db.filterCache.expires.Equal(expires) {
+		t.Error("expected cache to remain valid after inserting known values")
+	}
+
+	// A new service should invalidate the cache and be visible immediately
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "worker", Level: "info", Message: "msg", Host: "host-1"})
+	options, err := db.GetFilterOptions(ctx)
 	if err != nil {
 		t.Fatalf("GetFilterOptions failed: %v", err)
 	}
+	if len(options.Services) != 2 {
+		t.Errorf("expected new service to be visible immediately, got %v", options.Services)
+	}
+}
 
-	// Insert another log
-	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "worker", Level: "error", Message: "msg", Host: "host-2"})
+func TestGetFilterOptions_BatchInvalidates(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "msg", Host: "host-1"})
+	if _, err := db.GetFilterOptions(ctx); err != nil {
+		t.Fatalf("GetFilterOptions failed: %v", err)
+	}
+
+	db.InsertBatch(ctx, []models.Log{
+		sampleLog("api", "info", "known"),
+		sampleLog("api", "fatal", "new level"),
+	})
 
-	// Second call within TTL - should return cached result
-	options2, err := db.GetFilterOptions(ctx)
+	options, err := db.GetFilterOptions(ctx)
 	if err != nil {
 		t.Fatalf("GetFilterOptions failed: %v", err)
 	}
+	if len(options.Levels) != 2 {
+		t.Errorf("expected new level to be visible immediately, got %v", options.Levels)
+	}
+}
+
+func TestSuggestFilterValues(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	db.InsertBatch(ctx, []models.Log{
+		sampleLog("payments", "info", "a"),
+		sampleLog("Payroll", "info", "b"),
+		sampleLog("Payroll", "info", "c"),
+		sampleLog("api", "info", "d"),
+	})
+
+	values, err := db.SuggestFilterValues(ctx, "service", "pay", 10)
+	if err != nil {
+		t.Fatalf("SuggestFilterValues failed: %v", err)
+	}
+	if len(values) != 2 || values[0] != "Payroll" || values[1] != "payments" {
+		t.Errorf("expected [Payroll payments] (most frequent first), got %v", values)
+	}
 
-	// Should have same values due to caching (new service not visible yet)
-	if len(options1.Services) != len(options2.Services) {
-		t.Log("Note: If this test fails, it might be due to cache timing - this is expected behavior")
+	values, _ = db.SuggestFilterValues(ctx, "service", "", 1)
+	if len(values) != 1 {
+		t.Errorf("expected limit to apply, got %v", values)
+	}
+
+	if _, err := db.SuggestFilterValues(ctx, "message", "a", 10); err == nil {
+		t.Error("expected error for invalid column name")
 	}
 }
 
@@ -546,7 +664,7 @@ func TestContextCancellation(t *testing.T) {
 }
 
 func TestClose(t *testing.T) {
-	db, err := New(":memory:")
+	db, err := NewWithOptions(":memory:", Options{MaxOpenConns: 1})
 	if err != nil {
 		t.Fatalf("failed to create database: %v", err)
 	}
@@ -564,3 +682,200 @@ func TestClose(t *testing.T) {
 		t.Error("expected error after closing database")
 	}
 }
+
+func TestHistogram(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(4 * time.Hour)
+
+	db.InsertLog(ctx, &models.Log{Timestamp: start.Add(10 * time.Minute), Service: "svc", Level: "info", Message: "a", Host: "h"})
+	db.InsertLog(ctx, &models.Log{Timestamp: start.Add(20 * time.Minute), Service: "svc", Level: "info", Message: "b", Host: "h"})
+	db.InsertLog(ctx, &models.Log{Timestamp: start.Add(150 * time.Minute), Service: "svc", Level: "error", Message: "c", Host: "h"})
+	db.InsertLog(ctx, &models.Log{Timestamp: end, Service: "svc", Level: "info", Message: "d", Host: "h"})
+	db.InsertLog(ctx, &models.Log{Timestamp: end.Add(time.Hour), Service: "svc", Level: "info", Message: "outside", Host: "h"})
+
+	counts, err := db.Histogram(ctx, models.LogFilter{}, start, end, 4)
+	if err != nil {
+		t.Fatalf("Histogram failed: %v", err)
+	}
+	expected := []int64{2, 0, 1, 1}
+	for i := range expected {
+		if counts[i] != expected[i] {
+			t.Fatalf("expected counts %v, got %v", expected, counts)
+		}
+	}
+
+	counts, err = db.Histogram(ctx, models.LogFilter{Level: "error"}, start, end, 4)
+	if err != nil {
+		t.Fatalf("Histogram failed: %v", err)
+	}
+	if counts[2] != 1 || counts[0] != 0 {
+		t.Errorf("expected level filter to apply, got %v", counts)
+	}
+}
+
+func TestHistogram_InvalidBuckets(t *testing.T) {
+	db := newTestDB(t)
+
+	now := time.Now()
+	if _, err := db.Histogram(context.Background(), models.LogFilter{}, now.Add(-time.Hour), now, 0); err == nil {
+		t.Error("expected error for zero buckets")
+	}
+}
+
+func TestFilterValues_MaintainedAtIngest(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "a", Host: "h1"})
+	db.InsertBatch(ctx, []models.Log{
+		sampleLog("api", "info", "b"),
+		sampleLog("worker", "error", "c"),
+	})
+
+	var count int64
+	err := db.conn.QueryRow("SELECT count FROM filter_values WHERE kind = 'service' AND value = 'api'").Scan(&count)
+	if err != nil {
+		t.Fatalf("failed to read filter_values: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("expected count 2 for service 'api', got %d", count)
+	}
+
+	var rows int
+	db.conn.QueryRow("SELECT COUNT(*) FROM filter_values").Scan(&rows)
+	// services api, worker; levels info, error; hosts h1, test-host
+	if rows != 6 {
+		t.Errorf("expected 6 filter values, got %d", rows)
+	}
+}
+
+func TestFilterValues_Backfill(t *testing.T) {
+	path := t.TempDir() + "/logs.db"
+	db, err := New(path)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx := context.Background()
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "a", Host: "h1"})
+
+	// Simulate a database created before filter_values existed
+	db.conn.Exec("DELETE FROM filter_values")
+	db.conn.Exec("DELETE FROM schema_migrations")
+	db.Close()
+
+	db, err = New(path)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer db.Close()
+
+	options, err := db.GetFilterOptions(ctx)
+	if err != nil {
+		t.Fatalf("GetFilterOptions failed: %v", err)
+	}
+	if len(options.Services) != 1 || options.Services[0] != "api" {
+		t.Errorf("expected backfilled service 'api', got %v", options.Services)
+	}
+}
+
+func TestDeleteOldLogs_PrunesFilterValues(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now().Add(-48 * time.Hour), Service: "retired", Level: "info", Message: "old", Host: "h"})
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "new", Host: "h"})
+
+	if _, err := db.DeleteOldLogs(ctx, 24*time.Hour); err != nil {
+		t.Fatalf("DeleteOldLogs failed: %v", err)
+	}
+
+	options, err := db.GetFilterOptions(ctx)
+	if err != nil {
+		t.Fatalf("GetFilterOptions failed: %v", err)
+	}
+	if len(options.Services) != 1 || options.Services[0] != "api" {
+		t.Errorf("expected stale service to be pruned, got %v", options.Services)
+	}
+}
+
+func TestQueryLogs_MetaFilters(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	insert := func(meta map[string]interface{}) {
+		db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "svc", Level: "info", Message: "m", Host: "h", Metadata: meta})
+	}
+	insert(map[string]interface{}{"status_code": 200, "region": "eu", "http": map[string]interface{}{"method": "GET"}})
+	insert(map[string]interface{}{"status_code": 503, "region": "us", "http": map[string]interface{}{"method": "POST"}})
+	insert(map[string]interface{}{"status_code": "500", "region": "eu"})
+	insert(map[string]interface{}{"status_code": "oops"})
+	insert(nil)
+
+	tests := []struct {
+		name     string
+		filter   models.MetaFilter
+		expected int
+	}{
+		{"numeric gte", models.MetaFilter{Key: "status_code", Op: ">=", Numeric: true, Number: 500}, 2},
+		{"numeric lt", models.MetaFilter{Key: "status_code", Op: "<", Numeric: true, Number: 500}, 1},
+		{"string eq", models.MetaFilter{Key: "region", Op: "=", Value: "eu"}, 2},
+		{"string neq includes missing", models.MetaFilter{Key: "region", Op: "!=", Value: "eu"}, 3},
+		{"nested path", models.MetaFilter{Key: "http.method", Op: "=", Value: "POST"}, 1},
+		{"invalid op matches nothing", models.MetaFilter{Key: "status_code", Op: "; DROP", Numeric: true}, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logs, err := db.QueryLogs(ctx, models.LogFilter{Meta: []models.MetaFilter{tc.filter}})
+			if err != nil {
+				t.Fatalf("QueryLogs failed: %v", err)
+			}
+			if len(logs) != tc.expected {
+				t.Errorf("expected %d logs, got %d", tc.expected, len(logs))
+			}
+		})
+	}
+}
+
+// TestNew_AddsStreamColumn tests that databases created before logical
+// streams are upgraded in place.
+func TestNew_AddsStreamColumn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Exec(`CREATE TABLE logs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME NOT NULL,
+		service VARCHAR(100) NOT NULL, level VARCHAR(20) NOT NULL, message TEXT NOT NULL,
+		metadata JSON, host VARCHAR(255), created_at DATETIME DEFAULT CURRENT_TIMESTAMP);
+		INSERT INTO logs (timestamp, service, level, message, host) VALUES (CURRENT_TIMESTAMP, 'api', 'INFO', 'old', 'h')`)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := New(path)
+	if err != nil {
+		t.Fatalf("failed to open old database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "audit", Level: "INFO", Message: "new", Stream: "audit"})
+
+	logs, err := db.QueryLogs(ctx, models.LogFilter{Stream: DefaultStream})
+	if err != nil {
+		t.Fatalf("QueryLogs failed: %v", err)
+	}
+	if len(logs) != 1 || logs[0].Message != "old" || logs[0].Stream != DefaultStream {
+		t.Errorf("expected existing log in the default stream, got %+v", logs)
+	}
+	logs, _ = db.QueryLogs(ctx, models.LogFilter{ExcludeStreams: []string{DefaultStream}})
+	if len(logs) != 1 || logs[0].Stream != "audit" {
+		t.Errorf("expected only the audit log, got %+v", logs)
+	}
+}
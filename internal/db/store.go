@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"locog/internal/models"
+)
+
+// Store is the storage the service runs on: a SQLite or PostgreSQL DB, a
+// ClickHouse database, or a Ring keeping the last logs in memory.
+type Store interface {
+	// Logs
+	InsertLog(ctx context.Context, log *models.Log) error
+	InsertBatch(ctx context.Context, logs []models.Log) error
+	QueryLogs(ctx context.Context, filter models.LogFilter) ([]models.Log, error)
+	EachLog(ctx context.Context, filter models.LogFilter, fn func(models.Log) error) error
+	GetLog(ctx context.Context, id int64) (*models.Log, error)
+	LogContext(ctx context.Context, filter models.LogFilter, l models.Log, before, after int) ([]models.Log, []models.Log, error)
+	MaxLogID(ctx context.Context) (int64, error)
+	CountLogs(ctx context.Context, filter models.LogFilter) (int64, error)
+	CountLogsUpTo(ctx context.Context, filter models.LogFilter, max int64) (int64, error)
+	EstimateLogs(ctx context.Context, filter models.LogFilter) (models.QueryEstimate, error)
+	GetFilterOptions(ctx context.Context) (models.FilterOptions, error)
+	SuggestFilterValues(ctx context.Context, column, prefix string, limit int) ([]string, error)
+
+	// Aggregates
+	Histogram(ctx context.Context, filter models.LogFilter, start, end time.Time, buckets int) ([]int64, error)
+	AggregateMetadata(ctx context.Context, filter models.LogFilter, field, groupBy string, bucket time.Duration) ([]models.MetricAggregate, error)
+	CountLogsByGroup(ctx context.Context, filter models.LogFilter, groupBy string, bucket time.Duration, limit int) ([]models.GroupCount, error)
+	Availability(ctx context.Context, filter models.LogFilter, errorLevels []string, start time.Time, width time.Duration, buckets int) ([]models.ServiceAvailability, error)
+	MessageTemplates(ctx context.Context, filter models.LogFilter, maxMessages int) ([]models.TemplateCount, error)
+	TopErrors(ctx context.Context, filter models.LogFilter, maxMessages int) ([]models.ErrorGroup, error)
+	SetRollups(cfg RollupConfig)
+	Rollups() RollupConfig
+	RefreshRollups(ctx context.Context, now time.Time) error
+	LogStats(ctx context.Context, filter models.LogFilter, size time.Duration, groupBy string) (models.LogStats, error)
+	SetDerivedFields(fields []DerivedField)
+	DerivedFields() []DerivedField
+	RefreshMetadataKeys(ctx context.Context, since time.Time) error
+	MetadataKeys(ctx context.Context, service string) (models.MetadataKeysReport, error)
+
+	// Tags and retention
+	TagLogs(ctx context.Context, filter models.LogFilter, tag string) (int64, error)
+	UntagLogs(ctx context.Context, filter models.LogFilter, tag string) (int64, error)
+	PendingTagExports(ctx context.Context, pattern string, limit int) ([]TagExport, error)
+	MarkTagsExported(ctx context.Context, exports []TagExport) error
+	TagExportCounts(ctx context.Context, pattern string) (map[string]TagExportCount, error)
+	SetTagPolicies(policies []TagPolicy)
+	TagPolicies() []TagPolicy
+	SetStreamRetention(retention map[string]time.Duration)
+	SetArchiveHolds(tags []string)
+	ArchiveHolds() []string
+	DeleteOldLogs(ctx context.Context, olderThan time.Duration) (int64, error)
+	RetentionPreview(ctx context.Context, olderThan time.Duration, policies []TagPolicy) (models.RetentionPreview, error)
+	DeleteOldRows(ctx context.Context, table string, olderThan time.Duration) (int64, error)
+	CountOldRows(ctx context.Context, table string, olderThan time.Duration) (int64, error)
+	StartIncident(ctx context.Context, inc *models.Incident) error
+	EndIncident(ctx context.Context, id int64, now time.Time) (models.Incident, bool, error)
+	Incidents(ctx context.Context, now time.Time) ([]models.Incident, error)
+
+	// Sources, preferences, host groups and the redaction vault
+	RecordHeartbeat(ctx context.Context, host, service string, at time.Time) error
+	Sources(ctx context.Context) ([]models.Source, error)
+	LastLogAt(ctx context.Context, service, host string) (time.Time, bool, error)
+	Prefs(ctx context.Context, user string) (map[string]json.RawMessage, error)
+	Pref(ctx context.Context, user, key string) (json.RawMessage, error)
+	SetPref(ctx context.Context, user, key string, value json.RawMessage) error
+	DeletePref(ctx context.Context, user, key string) (bool, error)
+	HostGroups(ctx context.Context) (map[string][]string, error)
+	SetHostGroup(ctx context.Context, name string, hosts []string) error
+	DeleteHostGroup(ctx context.Context, name string) (bool, error)
+	StoreVaultEntries(ctx context.Context, entries []VaultEntry) error
+	VaultEntries(ctx context.Context, tokens []string) ([]VaultEntry, error)
+	RecordVaultReveal(ctx context.Context, reveal models.VaultReveal) error
+	VaultReveals(ctx context.Context, limit int) ([]models.VaultReveal, error)
+
+	// Upkeep and status
+	MaintenanceDue(ctx context.Context) (bool, error)
+	Maintain(ctx context.Context, reason string) error
+	MaintenanceStatus() models.MaintenanceStatus
+	MigrationStatus() []models.MigrationStatus
+	CheckIntegrity(ctx context.Context, full bool) error
+	Backup(ctx context.Context, path string) error
+	ExportSQLite(ctx context.Context, path string, key []byte) error
+	Backend() string
+	Version(ctx context.Context) (string, error)
+	ReadOnly() bool
+	Encrypted() bool
+	MaxLogs() int
+	Close() error
+}
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*Ring)(nil)
+	_ Store = (*ClickHouse)(nil)
+)
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"context"
+
+	"locog/internal/models"
+)
+
+// TagLogs adds tag to every log matching filter (its limit is ignored) and
+// returns the number of logs newly tagged.
+func (db *DB) TagLogs(ctx context.Context, filter models.LogFilter, tag string) (int64, error) {
+	where, args := db.buildWhere(filter)
+	result, err := db.conn.ExecContext(ctx,
+		"INSERT INTO log_tags (log_id, tag) SELECT id, CAST(? AS TEXT) FROM logs"+where+" ON CONFLICT DO NOTHING",
+		append([]interface{}{tag}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// UntagLogs removes tag from every log matching filter and returns the number
+// of logs it was removed from.
+func (db *DB) UntagLogs(ctx context.Context, filter models.LogFilter, tag string) (int64, error) {
+	where, args := db.buildWhere(filter)
+	result, err := db.conn.ExecContext(ctx,
+		"DELETE FROM log_tags WHERE tag = ? AND log_id IN (SELECT id FROM logs"+where+")",
+		append([]interface{}{tag}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestTagLogs(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	db.InsertLog(ctx, &models.Log{Timestamp: now.Add(-2 * time.Hour), Service: "api", Level: "error", Message: "before", Host: "h"})
+	db.InsertLog(ctx, &models.Log{Timestamp: now.Add(-30 * time.Minute), Service: "api", Level: "error", Message: "during", Host: "h"})
+	db.InsertLog(ctx, &models.Log{Timestamp: now.Add(-20 * time.Minute), Service: "worker", Level: "error", Message: "during", Host: "h"})
+
+	start := now.Add(-time.Hour)
+	window := models.LogFilter{StartTime: &start}
+
+	n, err := db.TagLogs(ctx, window, "incident-142")
+	if err != nil {
+		t.Fatalf("TagLogs failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 logs tagged, got %d", n)
+	}
+
+	// Tagging again is a no-op
+	if n, _ := db.TagLogs(ctx, window, "incident-142"); n != 0 {
+		t.Errorf("expected re-tagging to add nothing, got %d", n)
+	}
+	db.TagLogs(ctx, models.LogFilter{Service: "api"}, "api")
+
+	logs, err := db.QueryLogs(ctx, models.LogFilter{Tag: "incident-142"})
+	if err != nil {
+		t.Fatalf("QueryLogs failed: %v", err)
+	}
+	if len(logs) != 2 {
+		t.Fatalf("expected 2 tagged logs, got %d", len(logs))
+	}
+	for _, l := range logs {
+		if l.Message != "during" {
+			t.Errorf("unexpected tagged log: %+v", l)
+		}
+		if l.Service == "api" && len(l.Tags) != 2 {
+			t.Errorf("expected api log to carry both tags, got %v", l.Tags)
+		}
+	}
+
+	n, err = db.UntagLogs(ctx, models.LogFilter{Service: "worker"}, "incident-142")
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 log untagged, got %d (%v)", n, err)
+	}
+	if logs, _ := db.QueryLogs(ctx, models.LogFilter{Tag: "incident-142"}); len(logs) != 1 {
+		t.Errorf("expected 1 tagged log after untagging, got %d", len(logs))
+	}
+}
+
+func TestDeleteOldLogs_RemovesTags(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	db.InsertLog(ctx, &models.Log{Timestamp: time.Now().Add(-48 * time.Hour), Service: "api", Level: "info", Message: "old", Host: "h"})
+	db.TagLogs(ctx, models.LogFilter{}, "keep-me")
+
+	if _, err := db.DeleteOldLogs(ctx, 24*time.Hour); err != nil {
+		t.Fatalf("DeleteOldLogs failed: %v", err)
+	}
+
+	var count int
+	db.conn.QueryRow("SELECT COUNT(*) FROM log_tags").Scan(&count)
+	if count != 0 {
+		t.Errorf("expected tags of deleted logs to be removed, got %d", count)
+	}
+}
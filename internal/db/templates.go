@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	"locog/internal/models"
+)
+
+var (
+	uuidPattern = regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`)
+	ipPattern   = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`)
+	hexPattern  = regexp.MustCompile(`\b(0x[0-9a-fA-F]+|[0-9a-fA-F]{6,})\b`)
+	numPattern  = regexp.MustCompile(`\b\d+(\.\d+)?(ms|us|ns|s|m|h|kb|mb|gb|b|%)?\b`)
+)
+
+// MessageTemplate reduces a log message to its template by replacing the
+// variable parts (UUIDs, IP addresses, hex IDs, numbers) with placeholders, e.g.
+// "user 42 logged in from 10.0.0.1" -> "user <num> logged in from <ip>".
+func MessageTemplate(message string) string {
+	message = uuidPattern.ReplaceAllString(message, "<uuid>")
+	message = ipPattern.ReplaceAllString(message, "<ip>")
+	message = hexPattern.ReplaceAllStringFunc(message, func(s string) string {
+		// Plain words ("facade") and plain numbers are not hex IDs
+		if strings.HasPrefix(s, "0x") || strings.ContainsAny(s, "0123456789") && strings.ContainsAny(s, "abcdefABCDEF") {
+			return "<hex>"
+		}
+		return s
+	})
+	return numPattern.ReplaceAllString(message, "<num>")
+}
+
+// MessageTemplates counts the messages matching filter by template, most
+// frequent first. Only the maxMessages most frequent distinct messages are
+// considered, which bounds the work on very large windows.
+func (db *DB) MessageTemplates(ctx context.Context, filter models.LogFilter, maxMessages int) ([]models.TemplateCount, error) {
+	where, args := db.buildWhere(filter)
+	query := `SELECT message, COUNT(*) AS n FROM logs` + where + ` GROUP BY message ORDER BY n DESC LIMIT ?`
+	args = append(args, maxMessages)
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []messageCount
+	for rows.Next() {
+		var c messageCount
+		if err := rows.Scan(&c.message, &c.count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return countTemplates(counts), nil
+}
+
+// messageCount is the number of logs sharing a message and, for TopErrors,
+// a version.
+type messageCount struct {
+	message string
+	version string
+	count   int64
+}
+
+// countTemplates adds up counts, most frequent first, by message template.
+func countTemplates(counts []messageCount) []models.TemplateCount {
+	byTemplate := make(map[string]*models.TemplateCount)
+	for _, c := range counts {
+		tmpl := MessageTemplate(c.message)
+		tc, ok := byTemplate[tmpl]
+		if !ok {
+			// Counts are most frequent first, so the example is the commonest message
+			tc = &models.TemplateCount{Template: tmpl, Example: c.message}
+			byTemplate[tmpl] = tc
+		}
+		tc.Count += c.count
+	}
+
+	templates := make([]models.TemplateCount, 0, len(byTemplate))
+	for _, tc := range byTemplate {
+		templates = append(templates, *tc)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].Count != templates[j].Count {
+			return templates[i].Count > templates[j].Count
+		}
+		return templates[i].Template < templates[j].Template
+	})
+	return templates
+}
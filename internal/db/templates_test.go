@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestMessageTemplate(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"user 42 logged in from 10.0.0.1", "user <num> logged in from <ip>"},
+		{"request 3f2504e0-4f89-11d3-9a0c-0305e82c3301 took 35ms", "request <uuid> took <num>"},
+		{"commit a1b2c3d4e5 deployed to facade", "commit <hex> deployed to facade"},
+		{"pointer 0xdeadbeef", "pointer <hex>"},
+		{"connection refused", "connection refused"},
+	}
+
+	for _, tt := range tests {
+		if got := MessageTemplate(tt.input); got != tt.want {
+			t.Errorf("MessageTemplate(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestMessageTemplates(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	for _, msg := range []string{"timeout after 30s", "timeout after 45s", "timeout after 30s", "cache miss"} {
+		db.InsertLog(ctx, &models.Log{Timestamp: time.Now(), Service: "api", Level: "error", Message: msg, Host: "h"})
+	}
+
+	templates, err := db.MessageTemplates(ctx, models.LogFilter{}, 100)
+	if err != nil {
+		t.Fatalf("MessageTemplates failed: %v", err)
+	}
+	if len(templates) != 2 {
+		t.Fatalf("expected 2 templates, got %+v", templates)
+	}
+	if templates[0].Template != "timeout after <num>" || templates[0].Count != 3 || templates[0].Example != "timeout after 30s" {
+		t.Errorf("unexpected top template: %+v", templates[0])
+	}
+}
+
+// TestTopErrors_VersionKeys tests that each version key is recognised and the
+// template ID is stable.
+func TestTopErrors_VersionKeys(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	for _, meta := range []map[string]interface{}{
+		{"service": map[string]interface{}{"version": "2.0"}},
+		{"release": "2.0"},
+		{"build": 2.0},
+	} {
+		l := sampleLog("api", "ERROR", "timeout after 30s")
+		l.Metadata = meta
+		db.InsertLog(ctx, &l)
+	}
+
+	groups, err := db.TopErrors(ctx, models.LogFilter{}, 100)
+	if err != nil {
+		t.Fatalf("TopErrors failed: %v", err)
+	}
+	if len(groups) != 1 || groups[0].ID != TemplateID("timeout after <num>") {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+	if v := groups[0].Versions; len(v) != 2 || v[0] != (models.VersionCount{Version: "2.0", Count: 2}) {
+		t.Errorf("unexpected versions: %+v", v)
+	}
+}
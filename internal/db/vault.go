@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+	"locog/internal/tracing"
+)
+
+// VaultEntry is the encrypted original of a value redacted at ingest,
+// referenced from the log by its token.
+type VaultEntry struct {
+	Token      string
+	Rule       string // redaction rule that matched
+	Ciphertext []byte
+	CreatedAt  time.Time
+}
+
+// StoreVaultEntries saves encrypted originals of redacted values. Entries are
+// pruned once older than any log retention keeps (see DeleteOldLogs).
+func (db *DB) StoreVaultEntries(ctx context.Context, entries []VaultEntry) error {
+	ctx, span := db.startSpan(ctx, "StoreVaultEntries", tracing.Int("db.entries", len(entries)))
+	defer span.End()
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO redaction_vault (token, rule, ciphertext, created_at) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range entries {
+		if _, err := stmt.ExecContext(ctx, e.Token, e.Rule, e.Ciphertext, e.CreatedAt.UTC()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// VaultEntries returns the stored entries for tokens; unknown tokens are
+// skipped.
+func (db *DB) VaultEntries(ctx context.Context, tokens []string) ([]VaultEntry, error) {
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(tokens))
+	for i, t := range tokens {
+		args[i] = t
+	}
+	rows, err := db.conn.QueryContext(ctx, "SELECT token, rule, ciphertext, created_at FROM redaction_vault WHERE token IN (?"+
+		strings.Repeat(", ?", len(tokens)-1)+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []VaultEntry
+	for rows.Next() {
+		var e VaultEntry
+		if err := rows.Scan(&e.Token, &e.Rule, &e.Ciphertext, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// RecordVaultReveal appends to the reveal audit trail.
+func (db *DB) RecordVaultReveal(ctx context.Context, reveal models.VaultReveal) error {
+	_, err := db.conn.ExecContext(ctx, "INSERT INTO vault_reveals (revealed_at, client, reason, tokens) VALUES (?, ?, ?, ?)",
+		reveal.RevealedAt.UTC(), reveal.Client, reveal.Reason, strings.Join(reveal.Tokens, ","))
+	return err
+}
+
+// VaultReveals returns the most recent reveals, newest first.
+func (db *DB) VaultReveals(ctx context.Context, limit int) ([]models.VaultReveal, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT id, revealed_at, client, reason, tokens FROM vault_reveals ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reveals := []models.VaultReveal{}
+	for rows.Next() {
+		var r models.VaultReveal
+		var tokens string
+		if err := rows.Scan(&r.ID, &r.RevealedAt, &r.Client, &r.Reason, &tokens); err != nil {
+			return nil, err
+		}
+		if tokens != "" {
+			r.Tokens = strings.Split(tokens, ",")
+		}
+		reveals = append(reveals, r)
+	}
+	return reveals, rows.Err()
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestVaultEntries(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	err := db.StoreVaultEntries(ctx, []VaultEntry{
+		{Token: "00000000000000aa", Rule: "email", Ciphertext: []byte{1, 2}, CreatedAt: time.Now()},
+		{Token: "00000000000000bb", Rule: "card", Ciphertext: []byte{3}, CreatedAt: time.Now().Add(-40 * 24 * time.Hour)},
+	})
+	if err != nil {
+		t.Fatalf("StoreVaultEntries failed: %v", err)
+	}
+
+	entries, err := db.VaultEntries(ctx, []string{"00000000000000aa", "00000000000000bb", "00000000000000cc"})
+	if err != nil {
+		t.Fatalf("VaultEntries failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+
+	// Entries older than retention are pruned with the logs
+	if _, err := db.DeleteOldLogs(ctx, 30*24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ = db.VaultEntries(ctx, []string{"00000000000000aa", "00000000000000bb"})
+	if len(entries) != 1 || entries[0].Token != "00000000000000aa" || entries[0].Rule != "email" || len(entries[0].Ciphertext) != 2 {
+		t.Errorf("expected only the recent entry to remain, got %+v", entries)
+	}
+
+	for _, reason := range []string{"first", "second"} {
+		if err := db.RecordVaultReveal(ctx, models.VaultReveal{RevealedAt: time.Now(), Client: "10.0.0.1", Reason: reason,
+			Tokens: []string{"00000000000000aa"}}); err != nil {
+			t.Fatalf("RecordVaultReveal failed: %v", err)
+		}
+	}
+	reveals, err := db.VaultReveals(ctx, 10)
+	if err != nil {
+		t.Fatalf("VaultReveals failed: %v", err)
+	}
+	if len(reveals) != 2 || reveals[0].Reason != "second" || reveals[0].Tokens[0] != "00000000000000aa" {
+		t.Errorf("unexpected reveals: %+v", reveals)
+	}
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"sync"
+)
+
+// SQLite allows one writer at a time. Concurrent writers wait in its busy
+// handler, polling the lock, and a deferred transaction that must upgrade
+// to a write lock fails with SQLITE_BUSY at once. dbWriter instead hands the
+// write lock to one statement or transaction at a time, in request order.
+type dbWriter struct {
+	requests chan writeRequest
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// writeRequest asks the writer for the write lock. The writer sends on
+// granted, then waits for release.
+type writeRequest struct {
+	ctx     context.Context
+	granted chan struct{}
+	release chan struct{}
+}
+
+func newDBWriter() *dbWriter {
+	w := &dbWriter{
+		requests: make(chan writeRequest),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// run grants the write lock to requests in the order they arrive.
+func (w *dbWriter) run() {
+	defer close(w.done)
+	for {
+		select {
+		case req := <-w.requests:
+			select {
+			case req.granted <- struct{}{}:
+				<-req.release
+			case <-req.ctx.Done():
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// acquire waits for the write lock, returning the function releasing it. A
+// nil writer doesn't serialize, returning a no-op release.
+func (w *dbWriter) acquire(ctx context.Context) (func(), error) {
+	if w == nil {
+		return func() {}, nil
+	}
+	req := writeRequest{ctx: ctx, granted: make(chan struct{}), release: make(chan struct{})}
+	select {
+	case w.requests <- req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-w.stop:
+		return nil, sql.ErrConnDone
+	}
+	select {
+	case <-req.granted:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() { once.Do(func() { close(req.release) }) }, nil
+}
+
+// close stops the writer once the current holder releases the lock.
+func (w *dbWriter) close() {
+	if w == nil {
+		return
+	}
+	close(w.stop)
+	<-w.done
+}
+
+// defaultMaxOpenConns bounds the connection pool unless Options sets it;
+// reads run in parallel, so it scales with the CPUs.
+func defaultMaxOpenConns() int {
+	return max(4, 2*runtime.GOMAXPROCS(0))
+}
@@ -0,0 +1,180 @@
+package logservice
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"locog/internal/db"
+	"locog/internal/models"
+)
+
+// accessConfig restricts which services callers may read, so teams sharing
+// an instance only see their own logs.
+type accessConfig struct {
+	// RoleHeader names a request header listing the caller's roles, comma
+	// separated, e.g. "X-Forwarded-Groups". It must be set by an
+	// authenticating proxy that strips it from client requests.
+	RoleHeader string `json:"role_header"`
+
+	// DenyUnmatched refuses reads from callers no policy matches; otherwise
+	// they read everything.
+	DenyUnmatched bool `json:"deny_unmatched"`
+
+	Policies []accessPolicyConfig `json:"policies"`
+}
+
+// accessPolicyConfig grants callers presenting one of its tokens, or having
+// one of its roles, read access to its services.
+type accessPolicyConfig struct {
+	Name     string   `json:"name"`
+	Tokens   []string `json:"tokens"`   // bearer tokens or token= parameters
+	Roles    []string `json:"roles"`    // values of role_header
+	Services []string `json:"services"` // globs, e.g. ["checkout-*", "payments"]
+
+	// MaxLiveStreams bounds the concurrent WebSocket live streams of
+	// callers matching this policy first; 0 is unlimited.
+	MaxLiveStreams int `json:"max_live_streams"`
+}
+
+// accessPolicies are the validated access policies.
+type accessPolicies struct {
+	roleHeader    string
+	denyUnmatched bool
+	policies      []accessPolicyConfig
+}
+
+// access validates the access policies, or returns nil when none are
+// configured.
+func (c *fileConfig) access() (*accessPolicies, error) {
+	if c.Access == nil || len(c.Access.Policies) == 0 {
+		return nil, nil
+	}
+	a := &accessPolicies{
+		roleHeader:    http.CanonicalHeaderKey(c.Access.RoleHeader),
+		denyUnmatched: c.Access.DenyUnmatched,
+		policies:      c.Access.Policies,
+	}
+	names := make(map[string]bool)
+	for _, p := range a.policies {
+		if p.Name == "" || names[p.Name] {
+			return nil, fmt.Errorf("access: policies need unique names, got %q", p.Name)
+		}
+		names[p.Name] = true
+		if p.Name == wsAdminTenant {
+			return nil, fmt.Errorf("access: policy name %s is reserved for admins' live streams", p.Name)
+		}
+		if len(p.Tokens) == 0 && len(p.Roles) == 0 {
+			return nil, fmt.Errorf("access: policy %s: needs tokens or roles", p.Name)
+		}
+		if len(p.Roles) > 0 && a.roleHeader == "" {
+			return nil, fmt.Errorf("access: policy %s: roles need role_header", p.Name)
+		}
+		if slices.Contains(p.Tokens, "") || slices.Contains(p.Roles, "") {
+			return nil, fmt.Errorf("access: policy %s: tokens and roles must not be empty", p.Name)
+		}
+		if len(p.Services) == 0 {
+			return nil, fmt.Errorf("access: policy %s: services lists nothing to read", p.Name)
+		}
+		if p.MaxLiveStreams < 0 {
+			return nil, fmt.Errorf("access: policy %s: max_live_streams must not be negative", p.Name)
+		}
+		for _, pattern := range p.Services {
+			if !db.ValidGlob(pattern) {
+				return nil, fmt.Errorf("access: policy %s: invalid service pattern %q", p.Name, pattern)
+			}
+		}
+	}
+	return a, nil
+}
+
+// matching returns the policies granting the request, in order.
+func (a *accessPolicies) matching(r *http.Request) []*accessPolicyConfig {
+	token := requestToken(r)
+	var roles []string
+	if a.roleHeader != "" {
+		for _, v := range r.Header.Values(a.roleHeader) {
+			for _, role := range strings.Split(v, ",") {
+				if role = strings.TrimSpace(role); role != "" {
+					roles = append(roles, role)
+				}
+			}
+		}
+	}
+
+	var matched []*accessPolicyConfig
+	for i, p := range a.policies {
+		if slices.ContainsFunc(p.Tokens, func(t string) bool { return subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 }) ||
+			slices.ContainsFunc(p.Roles, func(role string) bool { return slices.Contains(roles, role) }) {
+			matched = append(matched, &a.policies[i])
+		}
+	}
+	return matched
+}
+
+// policy returns the first policy granting the request, which names its
+// tenant, or nil.
+func (a *accessPolicies) policy(r *http.Request) *accessPolicyConfig {
+	if a == nil {
+		return nil
+	}
+	if matched := a.matching(r); len(matched) > 0 {
+		return matched[0]
+	}
+	return nil
+}
+
+// readableServices returns the service globs the request may read, nil for
+// all of them. ok is false when the request may read nothing.
+func (a *accessPolicies) readableServices(r *http.Request) (services []string, ok bool) {
+	if a == nil {
+		return nil, true
+	}
+	matched := a.matching(r)
+	for _, p := range matched {
+		for _, pattern := range p.Services {
+			if !slices.Contains(services, pattern) {
+				services = append(services, pattern)
+			}
+		}
+	}
+	if len(matched) == 0 {
+		return nil, !a.denyUnmatched
+	}
+	return services, true
+}
+
+// restrictFilter limits filter to what the request may read: streams it
+// lacks a read token for are left out and, under access policies, so are
+// services its policies don't grant. The admin token reads everything. When
+// the request may read nothing it writes a 403 and returns false.
+func (s *server) restrictFilter(w http.ResponseWriter, r *http.Request, filter *models.LogFilter) bool {
+	filter.ExcludeStreams = s.hiddenStreams(r)
+	if token := requestToken(r); s.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1 {
+		return true
+	}
+	services, ok := s.access.readableServices(r)
+	if !ok {
+		writeJSONError(w, http.StatusForbidden, "access_denied", "No access policy grants this request",
+			"present a token or role that an access policy in the config file grants")
+		return false
+	}
+	filter.ServicePatterns = services
+	return true
+}
+
+// readable reports whether a log passes the restrictions restrictFilter
+// added to filter.
+func readable(filter models.LogFilter, l *models.Log) bool {
+	return !slices.Contains(filter.ExcludeStreams, l.Stream) && serviceMatches(filter.ServicePatterns, l.Service)
+}
+
+// serviceMatches reports whether service matches any of the globs, as the
+// store's ServicePatterns filter does, or whether there are none.
+func serviceMatches(patterns []string, service string) bool {
+	return len(patterns) == 0 || slices.ContainsFunc(patterns, func(pattern string) bool {
+		return db.GlobMatch(pattern, service)
+	})
+}
@@ -0,0 +1,174 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestLoadConfig_Access tests access policy validation.
+func TestLoadConfig_Access(t *testing.T) {
+	for _, bad := range []string{
+		`{"access": {"policies": [{"tokens": ["k"], "services": ["a"]}]}}`,
+		`{"access": {"policies": [{"name": "a", "services": ["a"]}]}}`,
+		`{"access": {"policies": [{"name": "a", "roles": ["team-a"], "services": ["a"]}]}}`,
+		`{"access": {"policies": [{"name": "a", "tokens": [""], "services": ["a"]}]}}`,
+		`{"access": {"policies": [{"name": "a", "tokens": ["k"]}]}}`,
+		`{"access": {"policies": [{"name": "a", "tokens": ["k"], "services": ["a["]}]}}`,
+		`{"access": {"policies": [{"name": "a", "tokens": ["k"], "services": ["web-[0-9]"]}]}}`,
+		`{"access": {"policies": [{"name": "a", "tokens": ["k"], "services": [""]}]}}`,
+		`{"access": {"policies": [{"name": "(admin)", "tokens": ["k"], "services": ["a"]}]}}`,
+		`{"access": {"policies": [{"name": "a", "tokens": ["k"], "services": ["a"]}, {"name": "a", "tokens": ["j"], "services": ["b"]}]}}`,
+	} {
+		if _, err := loadConfig(writeTestConfig(t, bad)); err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+}
+
+// TestServiceMatches tests that policies match services as the stores'
+// ServicePatterns filters do: * crosses '/', and % and _ are literal.
+func TestServiceMatches(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, service string
+		want             bool
+	}{
+		{"checkout-*", "checkout-api", true},
+		{"checkout-*", "checkout/api", false},
+		{"team/*", "team/a/b", true},
+		{"a?c", "a/c", true},
+		{"100%*", "100%-off", true},
+		{"100%*", "1000", false},
+		{"a_b", "axb", false},
+	} {
+		if got := serviceMatches([]string{tc.pattern}, tc.service); got != tc.want {
+			t.Errorf("serviceMatches(%q, %q) = %v, want %v", tc.pattern, tc.service, got, tc.want)
+		}
+	}
+	if !serviceMatches(nil, "anything") {
+		t.Error("expected no patterns to match every service")
+	}
+}
+
+// TestAccessPolicies tests that queries, single logs, filter options, metadata
+// keys and the live stream only show the services a request's policies grant.
+func TestAccessPolicies(t *testing.T) {
+	cfg, err := loadConfig(writeTestConfig(t, `{"access": {
+		"role_header": "X-Forwarded-Groups",
+		"deny_unmatched": true,
+		"policies": [
+			{"name": "checkout", "tokens": ["team-a"], "roles": ["checkout"], "services": ["checkout-*"]},
+			{"name": "payments", "roles": ["payments"], "services": ["payments"]}
+		]
+	}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	srv := newTestServer(t)
+	srv.access, _ = cfg.access()
+	srv.adminToken = "admin"
+
+	for _, service := range []string{"checkout-api", "payments", "search"} {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: service, Level: "info", Message: "m", Host: "h"})
+	}
+	stored, _ := srv.db.QueryLogs(t.Context(), models.LogFilter{})
+	ids := make(map[string]int64)
+	for _, l := range stored {
+		ids[l.Service] = l.ID
+	}
+
+	handler, err := srv.routes()
+	if err != nil {
+		t.Fatalf("routes failed: %v", err)
+	}
+	query := func(header http.Header, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header = header
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	services := func(rr *httptest.ResponseRecorder) map[string]bool {
+		var logs []models.Log
+		json.NewDecoder(rr.Body).Decode(&logs)
+		got := make(map[string]bool)
+		for _, l := range logs {
+			got[l.Service] = true
+		}
+		return got
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   []string
+	}{
+		{"token", http.Header{"Authorization": {"Bearer team-a"}}, []string{"checkout-api"}},
+		{"roles", http.Header{"X-Forwarded-Groups": {"checkout, payments"}}, []string{"checkout-api", "payments"}},
+		{"admin", http.Header{"Authorization": {"Bearer admin"}}, []string{"checkout-api", "payments", "search"}},
+	}
+	for _, tc := range tests {
+		rr := query(tc.header, "/api/logs")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", tc.name, rr.Code, rr.Body.String())
+		}
+		got := services(rr)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+		for _, service := range tc.want {
+			if !got[service] {
+				t.Errorf("%s: expected %s, got %v", tc.name, service, got)
+			}
+		}
+	}
+
+	// Callers matching no policy are refused
+	if rr := query(http.Header{}, "/api/logs"); rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without a policy, got %d", rr.Code)
+	}
+
+	// Other services' logs are missing, and left out of the dropdowns
+	teamA := http.Header{"Authorization": {"Bearer team-a"}}
+	if rr := query(teamA, "/api/logs/"+strconv.FormatInt(ids["search"], 10)); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another service's log, got %d", rr.Code)
+	}
+	if rr := query(teamA, "/api/logs/"+strconv.FormatInt(ids["checkout-api"], 10)); rr.Code != http.StatusOK {
+		t.Errorf("expected 200 for a readable log, got %d", rr.Code)
+	}
+	var options models.FilterOptions
+	json.NewDecoder(query(teamA, "/api/filters").Body).Decode(&options)
+	if len(options.Services) != 1 || options.Services[0] != "checkout-api" {
+		t.Errorf("expected only checkout-api in the filter options, got %v", options.Services)
+	}
+
+	// So are their metadata keys
+	for _, service := range []string{"checkout-api", "payments", "search"} {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: service, Level: "info", Message: "m", Host: "h",
+			Metadata: map[string]interface{}{"k": 1}})
+	}
+	srv.refreshMetadataKeys(t.Context())
+	for path, want := range map[string]int{"/api/metadata/keys": 1, "/api/metadata/keys?service=search": 0} {
+		var report models.MetadataKeysReport
+		json.NewDecoder(query(teamA, path).Body).Decode(&report)
+		if len(report.Keys) != want || want > 0 && report.Keys[0].Service != "checkout-api" {
+			t.Errorf("%s: expected only checkout-api keys, got %+v", path, report.Keys)
+		}
+	}
+	if rr := query(http.Header{}, "/api/metadata/keys"); rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for metadata keys without a policy, got %d", rr.Code)
+	}
+
+	// The live stream applies the same restriction
+	logs := []models.Log{{Service: "checkout-api", Message: "a"}, {Service: "search", Message: "b"}}
+	var streamed []models.Log
+	json.Unmarshal(encodeStreamLogs(logs, "", nil, []string{"checkout-*"}, nil), &streamed)
+	if len(streamed) != 1 || streamed[0].Service != "checkout-api" {
+		t.Errorf("expected only checkout-api streamed, got %+v", streamed)
+	}
+}
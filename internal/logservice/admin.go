@@ -0,0 +1,83 @@
+package logservice
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin wraps an admin handler. When an admin token is configured the
+// request must carry it as a bearer token; otherwise admin endpoints are open,
+// matching the rest of the API.
+func (s *server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken != "" && !s.isAdmin(r) {
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized",
+				"Admin token required", "Send the admin token as 'Authorization: Bearer <token>'")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isAdmin reports whether the request carries the configured admin token.
+func (s *server) isAdmin(r *http.Request) bool {
+	if s.adminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
+
+func (s *server) handleMigrationStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.db.MigrationStatus())
+}
+
+// handleTagLogs adds (POST) or removes (DELETE) a tag on every log matching
+// the filter in the query string, e.g.
+// POST /api/admin/tags?tag=incident-142&start=...&end=...
+// The filter's own tag parameter is the tag being applied, not a constraint.
+func (s *server) handleTagLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, ok := s.parseLogFilter(w, r)
+	if !ok {
+		return
+	}
+	tag := filter.Tag
+	if tag == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing_tag", "Missing tag", "'tag' is required")
+		return
+	}
+	filter.Tag = ""
+
+	var (
+		n   int64
+		err error
+	)
+	if r.Method == http.MethodPost {
+		n, err = s.db.TagLogs(r.Context(), filter, tag)
+	} else {
+		n, err = s.db.UntagLogs(r.Context(), filter, tag)
+	}
+	if err != nil {
+		slog.Error("failed to update log tags", "tag", tag, "method", r.Method, "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("updated log tags", "tag", tag, "method", r.Method, "logs", n)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"tag": tag, "updated": n})
+}
@@ -0,0 +1,120 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestRequireAdmin tests admin token enforcement.
+func TestRequireAdmin(t *testing.T) {
+	srv := newTestServer(t)
+	handler := srv.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name     string
+		token    string
+		header   string
+		expected int
+	}{
+		{"no token configured", "", "", http.StatusOK},
+		{"missing header", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer nope", http.StatusUnauthorized},
+		{"correct token", "secret", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv.adminToken = tc.token
+			req := httptest.NewRequest(http.MethodGet, "/api/admin/migration-status", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			handler(rr, req)
+
+			if rr.Code != tc.expected {
+				t.Errorf("expected status %d, got %d", tc.expected, rr.Code)
+			}
+		})
+	}
+}
+
+// TestHandleMigrationStatus tests the migration status endpoint.
+func TestHandleMigrationStatus(t *testing.T) {
+	srv := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/migration-status", nil)
+	rr := httptest.NewRecorder()
+	srv.handleMigrationStatus(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	var statuses []models.MigrationStatus
+	if err := json.NewDecoder(rr.Body).Decode(&statuses); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(statuses) == 0 {
+		t.Fatal("expected at least one migration status")
+	}
+	for _, st := range statuses {
+		if st.State != "done" {
+			t.Errorf("expected migration %s done, got %s", st.Name, st.State)
+		}
+	}
+}
+
+// TestHandleTagLogs tests tagging and untagging logs by filter.
+func TestHandleTagLogs(t *testing.T) {
+	srv := newTestServer(t)
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "ERROR", Message: "boom", Host: "h"})
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "worker", Level: "INFO", Message: "ok", Host: "h"})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/tags?tag=incident-142&level=ERROR", nil)
+	rr := httptest.NewRecorder()
+	srv.handleTagLogs(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var result struct {
+		Updated int64 `json:"updated"`
+	}
+	json.NewDecoder(rr.Body).Decode(&result)
+	if result.Updated != 1 {
+		t.Errorf("expected 1 log tagged, got %d", result.Updated)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/logs?tag=incident-142", nil)
+	rr = httptest.NewRecorder()
+	srv.handleQueryLogs(rr, req)
+	var logs []models.Log
+	json.NewDecoder(rr.Body).Decode(&logs)
+	if len(logs) != 1 || logs[0].Message != "boom" || len(logs[0].Tags) != 1 {
+		t.Errorf("expected the tagged log with its tag, got %+v", logs)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/admin/tags?tag=incident-142", nil)
+	rr = httptest.NewRecorder()
+	srv.handleTagLogs(rr, req)
+	json.NewDecoder(rr.Body).Decode(&result)
+	if result.Updated != 1 {
+		t.Errorf("expected 1 log untagged, got %d", result.Updated)
+	}
+
+	for _, target := range []string{"/api/admin/tags", "/api/admin/tags?tag=bad%20tag"} {
+		req = httptest.NewRequest(http.MethodPost, target, nil)
+		rr = httptest.NewRecorder()
+		srv.handleTagLogs(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", target, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package logservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+)
+
+// maxAggregateBuckets bounds the number of time buckets a single aggregate
+// request may produce per group.
+const maxAggregateBuckets = 1000
+
+// validGroupBy reports whether groupBy names a groupable column, a metadata
+// key, a configured derived field, or "" for no grouping.
+func (s *server) validGroupBy(groupBy string) bool {
+	switch groupBy {
+	case "", "service", "level", "host":
+		return true
+	}
+	if key, ok := strings.CutPrefix(groupBy, "metadata."); ok {
+		return metaKeyPattern.MatchString(key)
+	}
+	if name, ok := strings.CutPrefix(groupBy, "derived."); ok {
+		return s.hasDerivedField(name)
+	}
+	return false
+}
+
+// parseGroupBy reads the group_by parameter, defaulting to service. "none"
+// disables grouping and meta.<key> is accepted for metadata.<key>.
+func (s *server) parseGroupBy(w http.ResponseWriter, r *http.Request) (string, bool) {
+	groupBy := "service"
+	if v, set := r.URL.Query()["group_by"]; set {
+		groupBy = v[0]
+		if groupBy == "none" {
+			groupBy = ""
+		}
+		if key, ok := strings.CutPrefix(groupBy, "meta."); ok {
+			groupBy = "metadata." + key
+		}
+	}
+	if !s.validGroupBy(groupBy) {
+		writeJSONError(w, http.StatusBadRequest, "invalid_group_by",
+			"Invalid group_by value",
+			fmt.Sprintf("'group_by' must be service, level, host, metadata.<key>, derived.<name> or none, got: %s", groupBy))
+		return "", false
+	}
+	return groupBy, true
+}
+
+// parseBucket reads the optional bucket parameter, rejecting buckets that
+// would split the filter's time range into more than maxAggregateBuckets.
+func parseBucket(w http.ResponseWriter, r *http.Request, filter models.LogFilter) (time.Duration, bool) {
+	v := r.URL.Query().Get("bucket")
+	if v == "" {
+		return 0, true
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < time.Second {
+		writeJSONError(w, http.StatusBadRequest, "invalid_bucket",
+			"Invalid bucket value",
+			fmt.Sprintf("'bucket' must be a duration of at least 1s (e.g. 5m), got: %s", v))
+		return 0, false
+	}
+	if filter.StartTime != nil {
+		end := time.Now()
+		if filter.EndTime != nil {
+			end = *filter.EndTime
+		}
+		if end.Sub(*filter.StartTime)/d > maxAggregateBuckets {
+			writeJSONError(w, http.StatusBadRequest, "invalid_bucket",
+				"Too many buckets",
+				fmt.Sprintf("the range would produce more than %d buckets; use a larger bucket", maxAggregateBuckets))
+			return 0, false
+		}
+	}
+	return d, true
+}
+
+// handleAggregate returns avg/min/max/percentiles of a numeric metadata field,
+// grouped by a log column and optionally by time bucket, e.g.
+// /api/aggregate?field=duration_ms&group_by=service&bucket=5m
+func (s *server) handleAggregate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, ok := s.parseLogFilter(w, r)
+	if !ok {
+		return
+	}
+
+	field := strings.TrimPrefix(r.URL.Query().Get("field"), "meta.")
+	if !metaKeyPattern.MatchString(field) {
+		writeJSONError(w, http.StatusBadRequest, "invalid_field",
+			"Invalid aggregate field",
+			fmt.Sprintf("'field' must be a metadata key such as duration_ms, got: %q", field))
+		return
+	}
+
+	groupBy, ok := s.parseGroupBy(w, r)
+	if !ok {
+		return
+	}
+	bucket, ok := parseBucket(w, r, filter)
+	if !ok {
+		return
+	}
+
+	start := time.Now()
+	results, err := s.db.AggregateMetadata(r.Context(), filter, field, groupBy, bucket)
+	if err != nil {
+		if clientGone(w, r) {
+			return
+		}
+		slog.Error("aggregate failed", "error", err, "field", field, "group_by", groupBy)
+		writeJSONError(w, http.StatusInternalServerError, "query_failed",
+			"Aggregate failed", "An internal error occurred while aggregating logs")
+		return
+	}
+	if results == nil {
+		results = []models.MetricAggregate{}
+	}
+
+	setQueryHeaders(w, start, len(results))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// defaultGroupLimit and maxGroupLimit bound the groups /api/logs/aggregate
+// returns.
+const (
+	defaultGroupLimit = 100
+	maxGroupLimit     = 1000
+)
+
+// handleAggregateLogs counts logs per group and optionally per time bucket,
+// e.g. errors by service over the last hour:
+// /api/logs/aggregate?group_by=service&level=ERROR&start=...&bucket=5m
+func (s *server) handleAggregateLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, ok := s.parseLogFilter(w, r)
+	if !ok {
+		return
+	}
+	groupBy, ok := s.parseGroupBy(w, r)
+	if !ok {
+		return
+	}
+	bucket, ok := parseBucket(w, r, filter)
+	if !ok {
+		return
+	}
+
+	limit := defaultGroupLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxGroupLimit {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+				"Invalid limit value",
+				fmt.Sprintf("'limit' must be between 1 and %d, got: %s", maxGroupLimit, v))
+			return
+		}
+		limit = n
+	}
+
+	start := time.Now()
+	counts, err := s.db.CountLogsByGroup(r.Context(), filter, groupBy, bucket, limit)
+	if err != nil {
+		if clientGone(w, r) {
+			return
+		}
+		slog.Error("aggregate logs failed", "error", err, "group_by", groupBy)
+		writeJSONError(w, http.StatusInternalServerError, "query_failed",
+			"Aggregate failed", "An internal error occurred while aggregating logs")
+		return
+	}
+	if counts == nil {
+		counts = []models.GroupCount{}
+	}
+
+	setQueryHeaders(w, start, len(counts))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(counts)
+}
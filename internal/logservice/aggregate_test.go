@@ -0,0 +1,127 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestHandleAggregate tests percentile aggregation over a metadata field.
+func TestHandleAggregate(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, d := range []int{100, 200, 300, 400} {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "info",
+			Message: "req", Host: "h", Metadata: map[string]interface{}{"duration_ms": d}})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/aggregate?field=meta.duration_ms&bucket=1h", nil)
+	rr := httptest.NewRecorder()
+	srv.handleAggregate(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var results []models.MetricAggregate
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 group, got %d", len(results))
+	}
+	if results[0].Group != "api" || results[0].Avg != 250 || results[0].Max != 400 || results[0].Bucket == nil {
+		t.Errorf("unexpected aggregate: %+v", results[0])
+	}
+}
+
+// TestHandleAggregate_InvalidParams tests validation of aggregate parameters.
+func TestHandleAggregate_InvalidParams(t *testing.T) {
+	srv := newTestServer(t)
+
+	tests := []string{
+		"",
+		"field=bad%20key",
+		"field=duration_ms&group_by=message",
+		"field=duration_ms&bucket=fast",
+		"field=duration_ms&bucket=500ms",
+		"field=duration_ms&bucket=1s&start=2025-01-01T00:00:00Z&end=2025-01-02T00:00:00Z",
+	}
+
+	for _, query := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/aggregate?"+query, nil)
+		rr := httptest.NewRecorder()
+		srv.handleAggregate(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status %d, got %d", query, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
+
+// TestHandleAggregateLogs tests counting logs by group.
+func TestHandleAggregateLogs(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, l := range []models.Log{
+		{Service: "api", Level: "ERROR", Metadata: map[string]interface{}{"region": "eu"}},
+		{Service: "api", Level: "ERROR", Metadata: map[string]interface{}{"region": "us"}},
+		{Service: "worker", Level: "ERROR", Metadata: map[string]interface{}{"region": "eu"}},
+		{Service: "worker", Level: "INFO"},
+	} {
+		l.Timestamp, l.Message, l.Host = time.Now(), "m", "h"
+		srv.db.InsertLog(t.Context(), &l)
+	}
+
+	tests := []struct {
+		query string
+		want  []models.GroupCount
+	}{
+		{"level=ERROR", []models.GroupCount{{Group: "api", Count: 2}, {Group: "worker", Count: 1}}},
+		{"group_by=meta.region&limit=1", []models.GroupCount{{Group: "eu", Count: 2}}},
+		{"group_by=none", []models.GroupCount{{Group: "", Count: 4}}},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/logs/aggregate?"+tc.query, nil)
+		rr := httptest.NewRecorder()
+		srv.handleAggregateLogs(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%q: expected status %d, got %d: %s", tc.query, http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var counts []models.GroupCount
+		if err := json.NewDecoder(rr.Body).Decode(&counts); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if !reflect.DeepEqual(counts, tc.want) {
+			t.Errorf("%q: expected %+v, got %+v", tc.query, tc.want, counts)
+		}
+		if got := rr.Header().Get(rowsHeader); got != strconv.Itoa(len(tc.want)) {
+			t.Errorf("%q: expected %s %d, got %q", tc.query, rowsHeader, len(tc.want), got)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/logs/aggregate?bucket=1h", nil)
+	rr := httptest.NewRecorder()
+	srv.handleAggregateLogs(rr, req)
+	var counts []models.GroupCount
+	json.NewDecoder(rr.Body).Decode(&counts)
+	if len(counts) == 0 || counts[0].Bucket == nil {
+		t.Errorf("expected bucketed counts, got %+v", counts)
+	}
+
+	for _, query := range []string{"group_by=message", "group_by=metadata.bad%20key", "limit=0", "limit=5000", "bucket=fast"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/logs/aggregate?"+query, nil)
+		rr := httptest.NewRecorder()
+		srv.handleAggregateLogs(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status %d, got %d", query, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package logservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"locog/internal/alerts"
+	"locog/internal/models"
+)
+
+const (
+	// backtestDefaultDays is the history a backtest replays by default
+	backtestDefaultDays = 7
+	// maxBacktestRuleSize bounds the rule posted to the backtest endpoint
+	maxBacktestRuleSize = 16 << 10
+)
+
+// handleAlerts returns the state of every configured alert rule, including
+// the watermark each was last evaluated against.
+func (s *server) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	statuses := []models.AlertStatus{}
+	if s.alerts != nil {
+		statuses = s.alerts.Status()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// handleAlertBacktest replays a proposed alert rule, posted in the config
+// file's alert_rules format, over the last days (default 7) of stored logs
+// and reports when it would have fired, e.g.
+// POST /api/alerts/backtest?days=14 {"query": "level=ERROR", "window": "5m", ...}
+func (s *server) handleAlertBacktest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxDays := max(1, int(s.retention.retention()/(24*time.Hour)))
+	days := backtestDefaultDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxDays {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid days value",
+				fmt.Sprintf("'days' must be an integer between 1 and %d, got: %s", maxDays, v))
+			return
+		}
+		days = n
+	}
+
+	var rc alertRuleConfig
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBacktestRuleSize)).Decode(&rc); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_rule", "Invalid alert rule", err.Error())
+		return
+	}
+	if rc.Name == "" {
+		rc.Name = "backtest"
+	}
+	rule, query, err := rc.rule()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_rule", "Invalid alert rule", err.Error())
+		return
+	}
+	if rule.Absent > 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid_rule", "Rule can't be backtested",
+			"absence rules go by when logs arrived, which isn't kept")
+		return
+	}
+	filter, ok := s.parseLogFilterValues(w, query)
+	if !ok || !s.restrictFilter(w, r, &filter) {
+		return
+	}
+	rule.Filter = filter
+
+	// Ending on a whole minute lets exact rollups serve the counts
+	end := time.Now().Truncate(time.Minute)
+	bt, err := alerts.Backtest(r.Context(), s.db, rule, end.Add(-time.Duration(days)*24*time.Hour), end)
+	if errors.Is(err, alerts.ErrBacktestResolution) {
+		writeJSONError(w, http.StatusBadRequest, "invalid_rule", "Rule can't be backtested", err.Error())
+		return
+	}
+	if err != nil {
+		slog.Error("alert backtest failed", "rule", rule.Name, "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bt)
+}
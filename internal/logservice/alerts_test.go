@@ -0,0 +1,56 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestHandleAlertBacktest tests replaying a proposed rule over stored logs.
+func TestHandleAlertBacktest(t *testing.T) {
+	srv := newTestServer(t)
+	burst := time.Now().Add(-48 * time.Hour)
+	for range 15 {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: burst, Service: "api", Level: "ERROR", Message: "boom", Host: "h"})
+	}
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: burst, Service: "api", Level: "INFO", Message: "fine", Host: "h"})
+
+	backtest := func(query, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		srv.handleAlertBacktest(rr, httptest.NewRequest(http.MethodPost, "/api/alerts/backtest"+query, strings.NewReader(body)))
+		return rr
+	}
+
+	rr := backtest("?days=3", `{"query": "level=ERROR", "window": "5m", "condition": ">", "threshold": 10}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var bt models.AlertBacktest
+	if err := json.NewDecoder(rr.Body).Decode(&bt); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if bt.Evaluations < 3*24*60-5 || len(bt.Firings) != 1 || bt.Firings[0].Peak != 15 {
+		t.Fatalf("expected one firing over three days of evaluations, got %d evaluations and %+v", bt.Evaluations, bt.Firings)
+	}
+	if f := bt.Firings[0]; f.Start.After(burst.Add(time.Minute)) || f.End.Before(burst.Add(4*time.Minute)) {
+		t.Errorf("expected the firing to cover the burst at %v, got %+v", burst, f)
+	}
+
+	for _, tc := range []struct{ query, body string }{
+		{"?days=90", `{"window": "5m", "condition": ">"}`},
+		{"", `{"window": "5m", "condition": "=="}`},
+		{"", `{"query": "level=ERROR&limit=5", "window": "5m", "condition": ">"}`},
+		{"", `{"window": "1500ms", "condition": ">"}`},
+		{"", `{"query": "service=api", "absent": "15m"}`},
+		{"", `not json`},
+	} {
+		if rr := backtest(tc.query, tc.body); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s %s, got %d", http.StatusBadRequest, tc.query, tc.body, rr.Code)
+		}
+	}
+}
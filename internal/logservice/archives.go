@@ -0,0 +1,122 @@
+package logservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+
+	"locog/internal/archive"
+	"locog/internal/models"
+)
+
+// verifyArchives verifies the archive of every tag covered by an export
+// policy against its manifest and checks that it holds at least as many logs
+// as are recorded as exported to it. Tags with exported logs but no archive
+// are reported as incomplete.
+func (s *server) verifyArchives(ctx context.Context) ([]models.ArchiveReport, error) {
+	reports := []models.ArchiveReport{}
+	for _, te := range s.tagExports {
+		counts, err := s.db.TagExportCounts(ctx, te.pattern)
+		if err != nil {
+			return nil, err
+		}
+		verified, err := archive.VerifyDir(te.dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		byTag := make(map[string][]models.ArchiveReport)
+		for _, r := range verified {
+			if match, _ := path.Match(te.pattern, r.Tag); match {
+				byTag[r.Tag] = append(byTag[r.Tag], r)
+			}
+		}
+		for tag, c := range counts {
+			if _, ok := byTag[tag]; ok {
+				continue
+			}
+			r := models.ArchiveReport{Tag: tag, Dir: te.dir, Status: archive.StatusOK}
+			if c.Exported > 0 {
+				archive.Fail(&r, archive.StatusIncomplete, "%d stored logs were exported to the archive, but it doesn't exist", c.Exported)
+			}
+			byTag[tag] = []models.ArchiveReport{r}
+		}
+
+		for tag, tagReports := range byTag {
+			c := counts[tag]
+			// A tag's logs may be split across an NDJSON and a text data
+			// file if the policy's format changed
+			var archived int64
+			countKnown := true
+			for _, r := range tagReports {
+				archived += r.Count
+				countKnown = countKnown && !(r.Legacy && r.Format == archive.FormatText)
+			}
+			for _, r := range tagReports {
+				if countKnown && c.Exported > archived && r.File != "" {
+					archive.Fail(&r, archive.StatusIncomplete, "%d stored logs were exported to the archive, which holds %d", c.Exported, archived)
+				}
+				r.Exported, r.Pending = &c.Exported, &c.Pending
+				r.Held = r.Status != archive.StatusOK || c.Pending > 0
+				reports = append(reports, r)
+			}
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		a, b := reports[i], reports[j]
+		if a.Dir != b.Dir {
+			return a.Dir < b.Dir
+		}
+		if a.Tag != b.Tag {
+			return a.Tag < b.Tag
+		}
+		return a.File < b.File
+	})
+	return reports, nil
+}
+
+// holdUnverifiedArchives verifies the archives and has cleanups keep the
+// logs of tags whose archive fails verification or still has logs pending
+// export, so no log is deleted before it is safely archived. If the archives
+// can't be verified, the previous holds stay.
+func (s *server) holdUnverifiedArchives(ctx context.Context) {
+	reports, err := s.verifyArchives(ctx)
+	if err != nil {
+		slog.Error("archive verification failed", "error", err)
+		return
+	}
+	held := []string{}
+	for _, r := range reports {
+		if !r.Held {
+			continue
+		}
+		held = append(held, r.Tag)
+		if r.Status != archive.StatusOK {
+			slog.Warn("archive failed verification; keeping its logs", "tag", r.Tag, "dir", r.Dir, "file", r.File,
+				"status", r.Status, "problems", r.Problems)
+		}
+	}
+	s.db.SetArchiveHolds(held)
+}
+
+// handleVerifyArchives verifies the archive of every tag covered by an export
+// policy and reports each, as cleanups do before deleting logs. Archives
+// are read in full to check their hashes.
+func (s *server) handleVerifyArchives(w http.ResponseWriter, r *http.Request) {
+	reports, err := s.verifyArchives(r.Context())
+	if err != nil {
+		if clientGone(w, r) {
+			return
+		}
+		slog.Error("archive verification failed", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
@@ -0,0 +1,79 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"locog/internal/archive"
+	"locog/internal/models"
+)
+
+// TestVerifyArchives tests verifying archives and that cleanups keep the logs
+// of a corrupted one.
+func TestVerifyArchives(t *testing.T) {
+	srv := newTestServer(t)
+	dir := t.TempDir()
+	srv.tagExports = []tagExport{{pattern: "incident-*", dir: dir}}
+
+	old := time.Now().Add(-40 * 24 * time.Hour)
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: old, Service: "api", Level: "ERROR", Message: "boom", Host: "h"})
+	srv.db.TagLogs(t.Context(), models.LogFilter{Level: "ERROR"}, "incident-1")
+	if err := srv.exportTaggedLogs(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func() []models.ArchiveReport {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		srv.handleVerifyArchives(rr, httptest.NewRequest(http.MethodGet, "/api/admin/archives/verify", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var reports []models.ArchiveReport
+		json.NewDecoder(rr.Body).Decode(&reports)
+		return reports
+	}
+	reports := verify()
+	if len(reports) != 1 || reports[0].Status != archive.StatusOK || reports[0].Count != 1 ||
+		*reports[0].Exported != 1 || *reports[0].Pending != 0 || reports[0].Held {
+		t.Fatalf("unexpected reports %+v", reports)
+	}
+
+	// A lost archive is incomplete, as is one shorter than the logs exported
+	// to it
+	path := filepath.Join(dir, "incident-1.ndjson")
+	data, _ := os.ReadFile(path)
+	os.Rename(path, path+".bak")
+	os.Rename(path+archive.ManifestSuffix, path+".manifest.bak")
+	if reports = verify(); len(reports) != 1 || reports[0].Status != archive.StatusIncomplete || !reports[0].Held {
+		t.Fatalf("expected a missing archive incomplete, got %+v", reports)
+	}
+	os.Rename(path+".bak", path)
+	os.Rename(path+".manifest.bak", path+archive.ManifestSuffix)
+
+	data[len(data)-3] ^= 1
+	os.WriteFile(path, data, 0o644)
+	srv.cleanup(t.Context())
+	if holds := srv.db.ArchiveHolds(); len(holds) != 1 || holds[0] != "incident-1" {
+		t.Fatalf("expected incident-1 held, got %v", holds)
+	}
+	if logs := queryAll(t, srv); len(logs) != 1 {
+		t.Fatalf("expected the held log kept, got %d logs", len(logs))
+	}
+
+	// Once the archive verifies again the log is deleted
+	data[len(data)-3] ^= 1
+	os.WriteFile(path, data, 0o644)
+	srv.cleanup(t.Context())
+	if holds := srv.db.ArchiveHolds(); len(holds) != 0 {
+		t.Errorf("expected no holds, got %v", holds)
+	}
+	if logs := queryAll(t, srv); len(logs) != 0 {
+		t.Errorf("expected the log deleted, got %d logs", len(logs))
+	}
+}
@@ -0,0 +1,171 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"locog/internal/models"
+)
+
+// latenessBuckets are the upper bounds of the arrival lateness histogram.
+var latenessBuckets = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute,
+	30 * time.Minute, time.Hour,
+}
+
+// arrivalStats tracks, per service, how late logs arrive relative to their own
+// timestamps and how often they arrive out of order. Only logs that carry a
+// timestamp are counted; those stamped at ingest are on time by definition.
+type arrivalStats struct {
+	mu       sync.Mutex
+	services map[string]*serviceArrival
+	since    time.Time
+}
+
+type serviceArrival struct {
+	count      int64
+	outOfOrder int64 // timestamp earlier than the newest already seen
+	future     int64 // timestamp ahead of the server clock
+	total      time.Duration
+	max        time.Duration
+	buckets    []int64 // len(latenessBuckets)+1, the last is overflow
+	newest     time.Time
+}
+
+func newArrivalStats() *arrivalStats {
+	return &arrivalStats{services: make(map[string]*serviceArrival), since: time.Now()}
+}
+
+// record notes the arrival of one log at the given time.
+func (a *arrivalStats) record(service string, timestamp, arrived time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sa, ok := a.services[service]
+	if !ok {
+		sa = &serviceArrival{buckets: make([]int64, len(latenessBuckets)+1)}
+		a.services[service] = sa
+	}
+
+	lateness := arrived.Sub(timestamp)
+	if lateness < 0 {
+		// Clock skew between sender and server
+		sa.future++
+		lateness = 0
+	}
+
+	sa.count++
+	sa.total += lateness
+	sa.max = max(sa.max, lateness)
+	sa.buckets[sort.Search(len(latenessBuckets), func(i int) bool { return lateness <= latenessBuckets[i] })]++
+
+	if timestamp.Before(sa.newest) {
+		sa.outOfOrder++
+	} else {
+		sa.newest = timestamp
+	}
+}
+
+// snapshot returns the statistics for every service, sorted by service name.
+func (a *arrivalStats) snapshot() []models.ArrivalStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	out := make([]models.ArrivalStats, 0, len(a.services))
+	for name, sa := range a.services {
+		st := models.ArrivalStats{
+			Service:            name,
+			Since:              a.since,
+			Count:              sa.count,
+			OutOfOrder:         sa.outOfOrder,
+			Future:             sa.future,
+			MaxLatenessSeconds: sa.max.Seconds(),
+			P50Seconds:         sa.percentile(0.50),
+			P95Seconds:         sa.percentile(0.95),
+			P99Seconds:         sa.percentile(0.99),
+			Buckets:            make([]models.LatenessBucket, len(sa.buckets)),
+		}
+		if sa.count > 0 {
+			st.AvgLatenessSeconds = sa.total.Seconds() / float64(sa.count)
+		}
+		for i, n := range sa.buckets {
+			st.Buckets[i].Count = n
+			if i < len(latenessBuckets) {
+				st.Buckets[i].LESeconds = latenessBuckets[i].Seconds()
+			} else {
+				st.Buckets[i].LESeconds = -1 // overflow
+			}
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Service < out[j].Service })
+	return out
+}
+
+// percentile estimates the lateness percentile in seconds as the upper bound
+// of the histogram bucket containing it, or the maximum seen for the overflow
+// bucket. Rounding up makes it a safe watermark delay.
+func (sa *serviceArrival) percentile(p float64) float64 {
+	if sa.count == 0 {
+		return 0
+	}
+	rank := int64(p*float64(sa.count) + 0.999999)
+	var seen int64
+	for i, n := range sa.buckets {
+		seen += n
+		if seen >= rank {
+			if i < len(latenessBuckets) {
+				return min(latenessBuckets[i], sa.max).Seconds()
+			}
+			break
+		}
+	}
+	return sa.max.Seconds()
+}
+
+// lateness returns the p99 arrival lateness of a service, or the highest p99
+// across services when service is empty. It reports false when no logs with
+// timestamps have been seen.
+func (a *arrivalStats) lateness(service string) (time.Duration, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if service != "" {
+		sa, ok := a.services[service]
+		if !ok || sa.count == 0 {
+			return 0, false
+		}
+		return time.Duration(sa.percentile(0.99) * float64(time.Second)), true
+	}
+
+	var worst float64
+	found := false
+	for _, sa := range a.services {
+		if sa.count > 0 {
+			worst = max(worst, sa.percentile(0.99))
+			found = true
+		}
+	}
+	return time.Duration(worst * float64(time.Second)), found
+}
+
+// handleArrivalStats reports per-service arrival lateness and out-of-order
+// statistics since the service started.
+func (s *server) handleArrivalStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats := []models.ArrivalStats{}
+	if s.arrival != nil {
+		stats = s.arrival.snapshot()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
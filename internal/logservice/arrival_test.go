@@ -0,0 +1,99 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestArrivalStats_Record tests lateness, out-of-order and clock skew tracking.
+func TestArrivalStats_Record(t *testing.T) {
+	a := newArrivalStats()
+	now := time.Now()
+
+	a.record("api", now.Add(-2*time.Second), now)
+	a.record("api", now.Add(-90*time.Second), now) // older than the newest seen
+	a.record("api", now.Add(time.Minute), now)     // sender clock ahead
+	a.record("api", now.Add(-3*time.Hour), now)    // beyond the last bucket
+
+	stats := a.snapshot()
+	if len(stats) != 1 {
+		t.Fatalf("expected 1 service, got %d", len(stats))
+	}
+	st := stats[0]
+	if st.Count != 4 || st.OutOfOrder != 2 || st.Future != 1 {
+		t.Errorf("unexpected counts: %+v", st)
+	}
+	if st.MaxLatenessSeconds != (3 * time.Hour).Seconds() {
+		t.Errorf("expected max lateness 3h, got %v", st.MaxLatenessSeconds)
+	}
+	if st.P50Seconds != 5 {
+		t.Errorf("expected p50 of 5s bucket, got %v", st.P50Seconds)
+	}
+	if st.P99Seconds != st.MaxLatenessSeconds {
+		t.Errorf("expected p99 in overflow bucket to report max, got %v", st.P99Seconds)
+	}
+	last := st.Buckets[len(st.Buckets)-1]
+	if last.LESeconds != -1 || last.Count != 1 {
+		t.Errorf("expected 1 log in overflow bucket, got %+v", last)
+	}
+}
+
+// TestHandleIngest_RecordsArrival tests that only logs with their own timestamp are tracked.
+func TestHandleIngest_RecordsArrival(t *testing.T) {
+	srv := newTestServer(t)
+	srv.arrival = newArrivalStats()
+
+	ts := time.Now().Add(-30 * time.Second).UTC().Format(time.RFC3339Nano)
+	body := `[{"timestamp":"` + ts + `","service":"api","level":"info","message":"late"},
+		{"service":"worker","level":"info","message":"no timestamp"}]`
+	req := httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(body))
+	req.RemoteAddr = "192.168.1.1:12345"
+	rr := httptest.NewRecorder()
+	srv.handleIngest(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/admin/arrival-stats", nil)
+	rr = httptest.NewRecorder()
+	srv.handleArrivalStats(rr, req)
+
+	var stats []models.ArrivalStats
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(stats) != 1 || stats[0].Service != "api" || stats[0].Count != 1 {
+		t.Fatalf("expected stats for api only, got %+v", stats)
+	}
+	if stats[0].MaxLatenessSeconds < 29 {
+		t.Errorf("expected ~30s lateness, got %v", stats[0].MaxLatenessSeconds)
+	}
+}
+
+// TestArrivalStats_Lateness tests the p99 lateness used for alert watermarks.
+func TestArrivalStats_Lateness(t *testing.T) {
+	a := newArrivalStats()
+	if _, ok := a.lateness(""); ok {
+		t.Error("expected no lateness before any logs")
+	}
+
+	now := time.Now()
+	a.record("api", now.Add(-3*time.Second), now)
+	a.record("worker", now.Add(-45*time.Second), now)
+
+	if d, ok := a.lateness("api"); !ok || d != 3*time.Second {
+		t.Errorf("expected api lateness 3s, got %v (%v)", d, ok)
+	}
+	if d, ok := a.lateness(""); !ok || d != 45*time.Second {
+		t.Errorf("expected worst lateness 45s across services, got %v (%v)", d, ok)
+	}
+	if _, ok := a.lateness("missing"); ok {
+		t.Error("expected no lateness for unknown service")
+	}
+}
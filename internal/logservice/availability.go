@@ -0,0 +1,97 @@
+package logservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"locog/internal/models"
+)
+
+// maxAvailabilityBuckets bounds the days or weeks in a report.
+const maxAvailabilityBuckets = 366
+
+// availabilityPeriods are the bucket widths of availability reports and how
+// many buckets a report covers by default.
+var availabilityPeriods = map[string]struct {
+	width        time.Duration
+	defaultCount int
+}{
+	"day":  {24 * time.Hour, 30},
+	"week": {7 * 24 * time.Hour, 12},
+}
+
+// handleAvailability reports the share of each service's logs below ERROR per
+// day or week, e.g. /api/availability?period=week&count=4, to back status
+// pages. Buckets are aligned to UTC midnight (Mondays for weeks) and the
+// last one is the current, partial period. The usual filters apply except
+// level, which availability is computed from.
+func (s *server) handleAvailability(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	periodName := q.Get("period")
+	if periodName == "" {
+		periodName = "day"
+	}
+	period, ok := availabilityPeriods[periodName]
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid period value",
+			fmt.Sprintf("'period' must be day or week, got: %s", periodName))
+		return
+	}
+	count := period.defaultCount
+	if v := q.Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAvailabilityBuckets {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid count value",
+				fmt.Sprintf("'count' must be an integer between 1 and %d, got: %s", maxAvailabilityBuckets, v))
+			return
+		}
+		count = n
+	}
+	for _, p := range []string{"level", "start", "end"} {
+		if q.Has(p) {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+				fmt.Sprintf("'%s' is not supported for availability", p), "Use period and count to choose the range")
+			return
+		}
+	}
+
+	filter, ok := s.parseLogFilter(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now().UTC()
+	current := now.Truncate(24 * time.Hour)
+	if periodName == "week" {
+		// Back to Monday; Sunday is day 0
+		current = current.AddDate(0, 0, -(int(current.Weekday())+6)%7)
+	}
+	start := current.Add(-time.Duration(count-1) * period.width)
+
+	services, err := s.db.Availability(r.Context(), filter, errorLevels(), start, period.width, count)
+	if err != nil {
+		slog.Error("failed to compute availability", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if services == nil {
+		services = []models.ServiceAvailability{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.AvailabilityReport{
+		Period:   periodName,
+		Start:    start,
+		End:      current.Add(period.width),
+		Services: services,
+	})
+}
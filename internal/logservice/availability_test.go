@@ -0,0 +1,60 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestHandleAvailability tests per-service availability by day.
+func TestHandleAvailability(t *testing.T) {
+	srv := newTestServer(t)
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	insert := func(at time.Time, service, level string) {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: at, Service: service, Level: level, Message: "m", Host: "h"})
+	}
+	yesterday := today.Add(-12 * time.Hour)
+	insert(yesterday, "api", "INFO")
+	insert(yesterday, "api", "INFO")
+	insert(yesterday, "api", "INFO")
+	insert(yesterday, "api", "ERROR")
+	insert(today.Add(time.Minute), "api", "FATAL")
+	insert(today.Add(time.Minute), "web", "info")
+
+	rr := httptest.NewRecorder()
+	srv.handleAvailability(rr, httptest.NewRequest(http.MethodGet, "/api/availability?count=3", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var report models.AvailabilityReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if report.Period != "day" || !report.Start.Equal(today.Add(-48*time.Hour)) || len(report.Services) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	api := report.Services[0]
+	if api.Service != "api" || api.Total != 5 || api.Errors != 2 || *api.Availability != 0.6 {
+		t.Errorf("unexpected api availability: %+v", api)
+	}
+	if len(api.Buckets) != 3 || api.Buckets[0].Availability != nil ||
+		*api.Buckets[1].Availability != 0.75 || *api.Buckets[2].Availability != 0 {
+		t.Errorf("unexpected api buckets: %+v", api.Buckets)
+	}
+	if web := report.Services[1]; *web.Availability != 1 {
+		t.Errorf("expected web fully available, got %+v", web)
+	}
+
+	for _, query := range []string{"period=month", "count=0", "level=ERROR"} {
+		rr := httptest.NewRecorder()
+		srv.handleAvailability(rr, httptest.NewRequest(http.MethodGet, "/api/availability?"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, query, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package logservice
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// handleBackup responds with a consistent copy of the SQLite database, taken
+// with SQLite's online backup API while ingest carries on. The copy is staged
+// in backupDir and removed once sent. It only reads, so it works on a
+// read-only database too. 409 backup_unsupported means the database isn't a
+// plain text SQLite one.
+func (s *server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.db.Backend() != "sqlite" || s.db.Encrypted() {
+		writeJSONError(w, http.StatusConflict, "backup_unsupported", "Backups are only taken of plain text SQLite databases",
+			"back up PostgreSQL with pg_dump and copy encrypted databases with -export-db")
+		return
+	}
+
+	dir, err := os.MkdirTemp(s.backupDir, "locog-backup-")
+	if err != nil {
+		slog.Error("backup failed", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "logs.db")
+	start := time.Now()
+	if err := s.db.Backup(r.Context(), path); err != nil {
+		if clientGone(w, r) {
+			return
+		}
+		slog.Error("backup failed", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		slog.Error("backup failed", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		slog.Error("backup failed", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("backup taken", "bytes", info.Size(), "duration", time.Since(start))
+
+	name := "locog-" + start.UTC().Format("20060102T150405Z") + ".db"
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	io.Copy(w, f)
+}
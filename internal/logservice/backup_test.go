@@ -0,0 +1,69 @@
+package logservice
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"locog/internal/db"
+	"locog/internal/models"
+)
+
+// TestHandleBackup tests downloading a backup of a file database.
+func TestHandleBackup(t *testing.T) {
+	srv := newTestServer(t)
+	database, err := db.New(filepath.Join(t.TempDir(), "logs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	srv.db = database
+	srv.adminToken = "admin"
+	srv.backupDir = t.TempDir()
+	handler, err := srv.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/ingest", bytes.NewReader(sampleLogJSON())))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("ingest: expected 201, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/admin/backup", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without the admin token, got %d", rr.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/backup", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/vnd.sqlite3" {
+		t.Errorf("unexpected content type %q", got)
+	}
+	if entries, _ := os.ReadDir(srv.backupDir); len(entries) != 0 {
+		t.Errorf("expected the staged copy to be removed, found %d entries", len(entries))
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := os.WriteFile(path, rr.Body.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := db.NewWithOptions(path, db.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open the backup: %v", err)
+	}
+	defer backup.Close()
+	if n, _ := backup.CountLogs(t.Context(), models.LogFilter{}); n != 1 {
+		t.Errorf("expected 1 log in the backup, got %d", n)
+	}
+}
@@ -0,0 +1,135 @@
+package logservice
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"locog/internal/models"
+	"locog/internal/tracing"
+)
+
+// version is set at build time with -ldflags "-X locog/internal/logservice.version=v1.2.3".
+var version = "dev"
+
+// buildCommit returns the VCS revision recorded by the Go toolchain, if any.
+func buildCommit() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
+
+// capabilities lists the optional subsystems and whether this deployment has
+// them enabled. Subsystems that don't exist in this build are reported as
+// disabled rather than omitted so clients can check for them uniformly.
+// storageVersion is the SQLite library or PostgreSQL server version.
+func (s *server) capabilities(storageVersion string) models.Capabilities {
+	var alertRules int
+	if s.alerts != nil {
+		alertRules = len(s.alerts.Status())
+	}
+	var peers int
+	if s.federation != nil {
+		peers = len(s.federation.peers)
+	}
+	var quotas int
+	if s.quotas != nil {
+		quotas = len(s.quotas.quotas)
+	}
+	var flushInterval time.Duration
+	if s.hub != nil {
+		flushInterval = s.hub.flushInterval
+	}
+
+	var sampleRatio float64
+	tracer := tracing.Default()
+	if tracer != nil {
+		sampleRatio = tracer.SampleRatio()
+	}
+
+	var sqliteVersion string
+	if s.db.Backend() == "sqlite" {
+		sqliteVersion = storageVersion
+	}
+	rollups := s.db.Rollups()
+
+	return models.Capabilities{
+		Version:       version,
+		Commit:        buildCommit(),
+		GoVersion:     runtime.Version(),
+		SQLiteVersion: sqliteVersion,
+		Subsystems: []models.Subsystem{
+			{Name: "storage", Enabled: true, Version: storageVersion, Details: map[string]interface{}{
+				"backend":   s.db.Backend(),
+				"encrypted": s.db.Encrypted(),
+				"read_only": s.db.ReadOnly(),
+				"max_logs":  s.db.MaxLogs(),
+				"degraded":  s.degraded,
+			}},
+			{Name: "auth", Enabled: s.adminToken != "", Details: map[string]interface{}{"scheme": "bearer", "scope": "admin"}},
+			{Name: "fts", Enabled: false},
+			{Name: "alerting", Enabled: s.alerts != nil, Details: map[string]interface{}{"rules": alertRules}},
+			{Name: "archives", Enabled: len(s.tagExports) > 0, Details: map[string]interface{}{
+				"policies": len(s.tagExports),
+				"held":     len(s.db.ArchiveHolds()),
+			}},
+			{Name: "transforms", Enabled: len(s.transforms) > 0, Details: map[string]interface{}{"scripts": len(s.transforms)}},
+			{Name: "quotas", Enabled: s.quotas != nil, Details: map[string]interface{}{"quotas": quotas}},
+			{Name: "federation", Enabled: s.federation != nil, Details: map[string]interface{}{"peers": peers}},
+			{Name: "live_stream", Enabled: s.hub != nil, Details: map[string]interface{}{
+				"transports":        []string{"websocket", "long_poll"},
+				"min_level":         s.liveMinLevel,
+				"flush_interval_ms": flushInterval.Milliseconds(),
+				"max_connections":   s.liveMaxConnections,
+			}},
+			{Name: "otlp", Enabled: true, Version: "v1", Details: map[string]interface{}{
+				"protocols": []string{"http/protobuf", "http/json", "grpc"},
+			}},
+			{Name: "sentry", Enabled: true, Version: "7", Details: map[string]interface{}{"key_required": s.sentryKey != ""}},
+			{Name: "github_webhooks", Enabled: true, Details: map[string]interface{}{"signed": s.githubSecret != ""}},
+			{Name: "syslog", Enabled: false},
+			{Name: "generator", Enabled: s.generator != nil},
+			{Name: "streams", Enabled: len(s.streams) > 0, Details: map[string]interface{}{"streams": len(s.streams)}},
+			{Name: "tracing", Enabled: tracer != nil, Details: map[string]interface{}{
+				"exporter":     "otlp/http+json",
+				"sample_ratio": sampleRatio,
+			}},
+			{Name: "rollups", Enabled: len(rollups.Sizes) > 0, Details: map[string]interface{}{
+				"buckets":     sizeNames(rollups.Sizes),
+				"approximate": rollups.SampleRate > 1,
+			}},
+			{Name: "redaction", Enabled: s.redactor != nil, Details: map[string]interface{}{
+				"vault":  s.redactor != nil && s.redactor.vault != nil,
+				"reveal": s.redactor != nil && s.redactor.vault != nil && s.vaultRevealToken != "",
+			}},
+		},
+	}
+}
+
+// handleCapabilities reports the build and enabled subsystems.
+func (s *server) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	storageVersion, err := s.db.Version(r.Context())
+	if err != nil {
+		slog.Error("failed to read storage version", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.capabilities(storageVersion))
+}
@@ -0,0 +1,42 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"locog/internal/models"
+)
+
+// TestHandleCapabilities tests that subsystems reflect the server's settings.
+func TestHandleCapabilities(t *testing.T) {
+	srv := newTestServer(t)
+	srv.adminToken = "secret"
+
+	req := httptest.NewRequest(http.MethodGet, "/api/capabilities", nil)
+	rr := httptest.NewRecorder()
+	srv.handleCapabilities(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	var caps models.Capabilities
+	if err := json.NewDecoder(rr.Body).Decode(&caps); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if caps.Version == "" || caps.SQLiteVersion == "" || caps.GoVersion == "" {
+		t.Errorf("expected build information, got %+v", caps)
+	}
+
+	enabled := make(map[string]bool)
+	for _, sub := range caps.Subsystems {
+		enabled[sub.Name] = sub.Enabled
+	}
+	want := map[string]bool{"auth": true, "alerting": false, "federation": false, "otlp": true, "syslog": false, "fts": false}
+	for name, on := range want {
+		if got, ok := enabled[name]; !ok || got != on {
+			t.Errorf("expected %s enabled=%v, got %v (listed %v)", name, on, got, ok)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package logservice
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// readBody reads a request body of at most maxBodySize bytes and decodes its
+// Content-Encoding. Shippers such as Vector compress batches by default, so
+// every ingest endpoint accepts gzip, deflate and zstd.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body or body too large")
+	}
+	return decompress(r.Header.Get("Content-Encoding"), body)
+}
+
+// decompress decodes data according to a Content-Encoding header value. The
+// decoded size is also limited to maxBodySize.
+func decompress(encoding string, data []byte) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return data, nil
+	case "gzip", "x-gzip":
+		return gunzip(data)
+	case "deflate":
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid deflate body")
+		}
+		defer zr.Close()
+		return readDecompressed(zr, "deflate")
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderMaxMemory(maxBodySize+1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd body")
+		}
+		defer zr.Close()
+		return readDecompressed(zr, "zstd")
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip body")
+	}
+	defer zr.Close()
+	return readDecompressed(zr, "gzip")
+}
+
+// readDecompressed reads a decompressing reader, refusing output larger than
+// maxBodySize so small compressed bodies cannot exhaust memory.
+func readDecompressed(r io.Reader, encoding string) ([]byte, error) {
+	out, err := io.ReadAll(io.LimitReader(r, maxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s body", encoding)
+	}
+	if len(out) > maxBodySize {
+		return nil, fmt.Errorf("decompressed body too large")
+	}
+	return out, nil
+}
+
+// compressMinSize is the smallest response compressResponse compresses;
+// smaller ones, e.g. errors, gain little and cost a frame header.
+const compressMinSize = 1024
+
+// compressResponse compresses h's responses with zstd or gzip, whichever the
+// client's Accept-Encoding prefers, zstd on a tie. JSON and CSV logs compress
+// 10-20x, which matters to clients on slow links downloading large query
+// results and exports. Partial (206), bodiless and already encoded responses
+// are sent as is.
+func compressResponse(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			h(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		h(cw, r)
+		// Not deferred: a handler aborting with a panic must leave the
+		// stream unterminated, so a cut export doesn't look complete
+		cw.close()
+	}
+}
+
+// negotiateEncoding returns the response encoding preferred by an
+// Accept-Encoding header value: "zstd", "gzip" or "" for none.
+func negotiateEncoding(accept string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch name {
+		case "*":
+			name = "zstd"
+		case "x-gzip":
+			name = "gzip"
+		}
+		if name != "zstd" && name != "gzip" || q <= 0 {
+			continue
+		}
+		if q > bestQ || q == bestQ && name == "zstd" {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers a response until it holds compressMinSize bytes or
+// is flushed, then sends it compressed, or as is when it ends up smaller.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buf      []byte
+	// started is set once the status is sent; enc is nil after that if the
+	// response isn't compressed
+	started bool
+	enc     io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.started {
+		if w.enc != nil {
+			return w.enc.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= compressMinSize {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// start sends the status, compressed if compress is set and the response
+// can be, followed by the buffered body.
+func (w *compressWriter) start(compress bool) error {
+	w.started = true
+	h := w.Header()
+	if compress && w.status != http.StatusPartialContent && w.status != http.StatusNoContent &&
+		w.status != http.StatusNotModified && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		if w.encoding == "zstd" {
+			zw, err := zstd.NewWriter(w.ResponseWriter, zstd.WithEncoderConcurrency(1))
+			if err != nil {
+				return err
+			}
+			w.enc = zw
+		} else {
+			w.enc = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// Flush sends what is buffered, compressing it: a handler flushing before
+// compressMinSize bytes is streaming a response that is likely to be large.
+func (w *compressWriter) Flush() {
+	if !w.started {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		if w.start(true) != nil {
+			return
+		}
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// close ends the response, sending a small one uncompressed.
+func (w *compressWriter) close() {
+	if !w.started {
+		if w.status == 0 {
+			return
+		}
+		w.start(false)
+	}
+	if w.enc != nil {
+		w.enc.Close()
+	}
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,150 @@
+package logservice
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"locog/internal/models"
+)
+
+func compressBody(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	switch encoding {
+	case "gzip":
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+	case "deflate":
+		zw := zlib.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+	case "zstd":
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw.Write(data)
+		zw.Close()
+	default:
+		t.Fatalf("unknown encoding %s", encoding)
+	}
+	return buf.Bytes()
+}
+
+// TestHandleIngest_Compressed tests that compressed batches are decoded.
+func TestHandleIngest_Compressed(t *testing.T) {
+	body := []byte(`[{"service":"svc1","level":"info","message":"msg1"},{"service":"svc2","level":"warn","message":"msg2"}]`)
+
+	for _, encoding := range []string{"gzip", "deflate", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			srv := newTestServer(t)
+			req := httptest.NewRequest(http.MethodPost, "/api/ingest", bytes.NewReader(compressBody(t, encoding, body)))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", encoding)
+
+			rr := httptest.NewRecorder()
+			srv.handleIngest(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+			}
+			if logs := queryAll(t, srv); len(logs) != 2 {
+				t.Errorf("expected 2 logs, got %d", len(logs))
+			}
+		})
+	}
+}
+
+// TestHandleIngest_BadEncoding tests corrupt, unknown and oversized bodies.
+func TestHandleIngest_BadEncoding(t *testing.T) {
+	srv := newTestServer(t)
+	bomb := compressBody(t, "zstd", bytes.Repeat([]byte(" "), maxBodySize+1))
+
+	for _, tc := range []struct {
+		encoding string
+		body     []byte
+	}{
+		{"gzip", []byte(`{"service":"svc"}`)},
+		{"zstd", []byte("not zstd")},
+		{"br", []byte(`{}`)},
+		{"zstd", bomb},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/ingest", bytes.NewReader(tc.body))
+		req.Header.Set("Content-Encoding", tc.encoding)
+		rr := httptest.NewRecorder()
+		srv.handleIngest(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", tc.encoding, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
+
+// TestCompressResponse tests compressing query results per Accept-Encoding.
+func TestCompressResponse(t *testing.T) {
+	srv := newTestServer(t)
+	var logs []models.Log
+	for i := range 50 {
+		logs = append(logs, models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: fmt.Sprintf("request %d served", i), Host: "web-1"})
+	}
+	if err := srv.db.InsertBatch(t.Context(), logs); err != nil {
+		t.Fatal(err)
+	}
+	handler, err := srv.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path, accept, encoding string
+	}{
+		{"/api/logs", "gzip, deflate", "gzip"},
+		{"/api/logs", "gzip, deflate, br, zstd", "zstd"},
+		{"/api/logs", "zstd;q=0.5, gzip", "gzip"},
+		{"/api/logs", "gzip;q=0, identity", ""},
+		{"/api/logs", "", ""},
+		// Too small to be worth compressing
+		{"/api/logs?limit=1", "gzip", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Header.Set("Accept-Encoding", tc.accept)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s %q: expected 200, got %d", tc.path, tc.accept, rr.Code)
+		}
+		if got := rr.Header().Get("Content-Encoding"); got != tc.encoding {
+			t.Errorf("%s %q: expected encoding %q, got %q", tc.path, tc.accept, tc.encoding, got)
+		}
+
+		var body io.Reader = rr.Body
+		switch tc.encoding {
+		case "gzip":
+			zr, err := gzip.NewReader(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = zr
+		case "zstd":
+			zr, err := zstd.NewReader(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer zr.Close()
+			body = zr
+		}
+		var got []models.Log
+		if err := json.NewDecoder(body).Decode(&got); err != nil {
+			t.Errorf("%s %q: invalid body: %v", tc.path, tc.accept, err)
+		}
+	}
+}
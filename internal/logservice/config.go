@@ -0,0 +1,518 @@
+package logservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"locog/internal/alerts"
+	"locog/internal/db"
+	"locog/internal/logtext"
+)
+
+// fileConfig is the optional JSON configuration file passed with -config. It
+// holds structured settings that don't fit comfortably in flags.
+type fileConfig struct {
+	// DerivedFields maps a field name to an expression evaluated at query
+	// time, e.g. {"status_class": "floor(meta.status_code / 100)"}.
+	DerivedFields map[string]string `json:"derived_fields"`
+
+	// AlertRules are threshold rules evaluated periodically.
+	AlertRules []alertRuleConfig `json:"alert_rules"`
+
+	// Notifiers are Slack and email channels alert rules notify, by name.
+	Notifiers map[string]notifierConfig `json:"notifiers"`
+
+	// PublicURL is the web UI's address, e.g. "https://logs.example.com",
+	// used to link notifications to the rule's query.
+	PublicURL string `json:"public_url"`
+
+	// TagPolicies override retention and enable archiving for tagged logs.
+	TagPolicies []tagPolicyConfig `json:"tag_policies"`
+
+	// Federation lists peer instances searched by federated queries.
+	Federation *federationConfig `json:"federation"`
+
+	// Labels are added to the metadata of every ingested log, e.g.
+	// {"env": "prod", "region": "eu"}.
+	Labels map[string]string `json:"labels"`
+
+	// LiveStream restricts what unprivileged live stream clients receive.
+	LiveStream *liveStreamConfig `json:"live_stream"`
+
+	// Streams route matching logs to logical streams with their own
+	// retention, sampling and read access. The first matching stream wins.
+	Streams []streamConfig `json:"streams"`
+
+	// IndexedMetadataKeys are copied into indexed columns at startup so
+	// filters on them are fast, e.g. ["request_id", "trace_id"].
+	IndexedMetadataKeys []string `json:"indexed_metadata_keys"`
+
+	// Redaction replaces sensitive values at ingest, optionally keeping the
+	// originals in an encrypted vault.
+	Redaction *redactionConfig `json:"redaction"`
+
+	// HostGroups name sets of hosts for host_group= filters, e.g.
+	// {"web": ["web-*"], "db": ["db-01", "db-02"]}.
+	HostGroups map[string][]string `json:"host_groups"`
+
+	// LevelNormalization rewrites ingested levels to canonical lower case
+	// names, e.g. "WARNING" to "warn".
+	LevelNormalization *levelNormalizationConfig `json:"level_normalization"`
+
+	// LevelInference guesses levels from the message for sources that send
+	// none, e.g. plain-text lines. The first matching entry applies.
+	LevelInference []levelInferenceConfig `json:"level_inference"`
+
+	// HostNaming names the host of logs sent without one, e.g. from the
+	// sender's address or a header.
+	HostNaming *hostNamingConfig `json:"host_naming"`
+
+	// Transforms run scripts on matching logs at ingest, in order, after
+	// the built-in processors, e.g. to map fields or drop noise.
+	Transforms []transformConfig `json:"transforms"`
+
+	// UnknownFieldKeys set the unknown field mode per API key, e.g. reject
+	// for first-party apps and metadata for a shipper, overriding
+	// -unknown-fields.
+	UnknownFieldKeys []unknownFieldKeyConfig `json:"unknown_field_keys"`
+
+	// Quotas limit the events per second and bytes per day senders
+	// presenting an API token, or services, may ingest.
+	Quotas []quotaConfig `json:"quotas"`
+
+	// RateLimits set the per-IP rate limits of ingest endpoints, by default
+	// 100 requests per second shared by all of them.
+	RateLimits *rateLimitConfig `json:"rate_limits"`
+
+	// Access restricts the services callers may read by token or role.
+	Access *accessConfig `json:"access"`
+
+	// ExpectedSources register the services, optionally per host, expected
+	// to ship logs and how many per window, for /api/admin/source-health.
+	ExpectedSources []expectedSourceConfig `json:"expected_sources"`
+
+	// TableRetention deletes rows of auxiliary tables older than a duration
+	// at each cleanup, e.g. {"vault_reveals": "365d"}. Tables not listed
+	// are kept.
+	TableRetention map[string]string `json:"table_retention"`
+
+	// Retention is how long logs are kept, e.g. "14d", and CleanupInterval
+	// how often the cleanup deleting older ones runs. Either overrides its
+	// flag, -retention or -cleanup-interval, when set.
+	Retention       string `json:"retention"`
+	CleanupInterval string `json:"cleanup_interval"`
+}
+
+// liveStreamConfig applies to /api/ws and /api/poll.
+type liveStreamConfig struct {
+	// MinLevel withholds less severe logs (e.g. "info" hides DEBUG and TRACE)
+	// from clients that don't present the admin token.
+	MinLevel string `json:"min_level"`
+
+	// MaxConnections bounds the concurrent WebSocket live streams of callers
+	// no access policy matches; 0 is unlimited. Admins aren't limited.
+	MaxConnections int `json:"max_connections"`
+}
+
+// liveMinLevel returns the validated minimum live stream level, or "".
+func (c *fileConfig) liveMinLevel() (string, error) {
+	if c.LiveStream == nil {
+		return "", nil
+	}
+	level, err := parseMinLevel(c.LiveStream.MinLevel)
+	if err != nil {
+		return "", fmt.Errorf("live_stream: %w", err)
+	}
+	if c.LiveStream.MaxConnections < 0 {
+		return "", fmt.Errorf("live_stream: max_connections must not be negative")
+	}
+	return level, nil
+}
+
+// federationConfig names this instance and its peers.
+type federationConfig struct {
+	Name    string       `json:"name"`    // source label for local results (default "local")
+	Timeout string       `json:"timeout"` // per-peer timeout (default 5s)
+	Peers   []peerConfig `json:"peers"`
+}
+
+type peerConfig struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// federation builds the federation client, or returns nil when no peers are
+// configured.
+func (c *fileConfig) federation() (*federation, error) {
+	if c.Federation == nil || len(c.Federation.Peers) == 0 {
+		return nil, nil
+	}
+
+	f := &federation{name: c.Federation.Name}
+	if f.name == "" {
+		f.name = "local"
+	}
+	timeout := defaultPeerTimeout
+	if c.Federation.Timeout != "" {
+		d, err := time.ParseDuration(c.Federation.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("federation: invalid timeout %q", c.Federation.Timeout)
+		}
+		timeout = d
+	}
+	f.client = &http.Client{Timeout: timeout}
+
+	names := map[string]bool{f.name: true}
+	for _, pc := range c.Federation.Peers {
+		if pc.Name == "" || names[pc.Name] {
+			return nil, fmt.Errorf("federation: peer names must be unique and non-empty, got %q", pc.Name)
+		}
+		names[pc.Name] = true
+		u, err := url.Parse(pc.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("federation: peer %s: invalid url %q", pc.Name, pc.URL)
+		}
+		f.peers = append(f.peers, peer{name: pc.Name, url: pc.URL, token: pc.Token})
+	}
+	return f, nil
+}
+
+// tagPolicyConfig applies to logs carrying a tag matching Tag.
+type tagPolicyConfig struct {
+	Tag       string `json:"tag"`        // glob, e.g. "incident-*"
+	Retention string `json:"retention"`  // e.g. "365d"; empty keeps the default
+	ExportDir string `json:"export_dir"` // optional directory for NDJSON exports
+
+	// ExportTemplate writes exports as text rendered with this template
+	// instead of NDJSON, e.g. "{{.Timestamp}} [{{.Level}}] {{.Message}}".
+	ExportTemplate string `json:"export_template"`
+}
+
+// exportTemplate parses the export template, or returns nil for NDJSON.
+func (pc tagPolicyConfig) exportTemplate() (*template.Template, error) {
+	if pc.ExportTemplate == "" {
+		return nil, nil
+	}
+	tmpl, err := logtext.Parse(pc.ExportTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("tag policy %s: invalid export template: %w", pc.Tag, err)
+	}
+	return tmpl, nil
+}
+
+// policy converts the configured tag policy.
+func (pc tagPolicyConfig) policy() (db.TagPolicy, error) {
+	p := db.TagPolicy{Pattern: pc.Tag}
+	if pc.Retention != "" {
+		keep, err := parseRetention(pc.Retention)
+		if err != nil {
+			return p, fmt.Errorf("tag policy %s: invalid retention: %w", pc.Tag, err)
+		}
+		p.Keep = keep
+	}
+	return p, p.Validate()
+}
+
+// parseRetention parses a duration that may also be given in days, e.g. "365d".
+func parseRetention(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// alertRuleConfig is an alert rule as written in the config file.
+type alertRuleConfig struct {
+	Name      string `json:"name"`
+	Query     string `json:"query"`     // filter as a query string, e.g. "service=api&level=ERROR"
+	Window    string `json:"window"`    // e.g. "5m"
+	Condition string `json:"condition"` // >, >=, <, <=
+	Threshold int64  `json:"threshold"`
+	Interval  string `json:"interval"` // default 1m
+	Delay     string `json:"delay"`    // watermark delay, or "auto" (default)
+
+	// Absent makes an absence rule, firing when no logs from the query's
+	// service and host have been ingested for this long, e.g. "15m". The
+	// query may only set those; window, condition, threshold and delay don't
+	// apply.
+	Absent string `json:"absent"`
+
+	// Notify names the notifiers told when the rule fires and, unless
+	// NotifyResolved is false, when it resolves.
+	Notify         []string `json:"notify"`
+	NotifyResolved *bool    `json:"notify_resolved"`
+}
+
+// loadConfig reads and validates a configuration file. An empty path yields
+// an empty configuration.
+func loadConfig(path string) (*fileConfig, error) {
+	cfg := &fileConfig{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	if _, err := cfg.derivedFields(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.notifiers(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.publicURL(); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, rc := range cfg.AlertRules {
+		if _, _, err := rc.rule(); err != nil {
+			return nil, err
+		}
+		if names[rc.Name] {
+			return nil, fmt.Errorf("alert rule %s: duplicate name", rc.Name)
+		}
+		names[rc.Name] = true
+		for _, name := range rc.Notify {
+			if _, ok := cfg.Notifiers[name]; !ok {
+				return nil, fmt.Errorf("alert rule %s: unknown notifier %q", rc.Name, name)
+			}
+		}
+	}
+	for _, pc := range cfg.TagPolicies {
+		if _, err := pc.policy(); err != nil {
+			return nil, err
+		}
+		if _, err := pc.exportTemplate(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := cfg.federation(); err != nil {
+		return nil, err
+	}
+	for key := range cfg.Labels {
+		if !metaKeyPattern.MatchString(key) || strings.Contains(key, ".") {
+			return nil, fmt.Errorf("invalid label key %q", key)
+		}
+	}
+	if _, err := cfg.liveMinLevel(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.streams(); err != nil {
+		return nil, err
+	}
+	if err := db.ValidateIndexedKeys(cfg.IndexedMetadataKeys); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.redactor(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.hostGroups(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.levelNormalizer(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.levelInference(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.hostNaming(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.transforms(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.unknownFieldKeys(); err != nil {
+		return nil, err
+	}
+	if _, _, _, err := cfg.rateLimits(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.quotas(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.tableRetention(); err != nil {
+		return nil, err
+	}
+	if _, _, err := cfg.retention(defaultRetentionPeriod, defaultCleanupInterval); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.access(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.expectedSources(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// derivedFields compiles the configured derived fields in name order.
+func (c *fileConfig) derivedFields() ([]db.DerivedField, error) {
+	names := make([]string, 0, len(c.DerivedFields))
+	for name := range c.DerivedFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make([]db.DerivedField, 0, len(names))
+	for _, name := range names {
+		f, err := db.CompileDerivedField(name, c.DerivedFields[name])
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// rule converts the configured rule, except for its filter, and returns the
+// filter's query parameters separately. Time range and limit parameters are
+// rejected since the engine sets the window itself.
+func (rc alertRuleConfig) rule() (alerts.Rule, url.Values, error) {
+	if rc.Absent != "" {
+		return rc.absenceRule()
+	}
+	r := alerts.Rule{
+		Name:      rc.Name,
+		Condition: rc.Condition,
+		Threshold: rc.Threshold,
+		Interval:  time.Minute,
+		AutoDelay: rc.Delay == "" || rc.Delay == "auto",
+	}
+
+	var err error
+	if r.Window, err = time.ParseDuration(rc.Window); err != nil {
+		return r, nil, fmt.Errorf("alert rule %s: invalid window: %w", rc.Name, err)
+	}
+	if rc.Interval != "" {
+		if r.Interval, err = time.ParseDuration(rc.Interval); err != nil {
+			return r, nil, fmt.Errorf("alert rule %s: invalid interval: %w", rc.Name, err)
+		}
+	}
+	if !r.AutoDelay {
+		if r.Delay, err = time.ParseDuration(rc.Delay); err != nil {
+			return r, nil, fmt.Errorf("alert rule %s: invalid delay: %w", rc.Name, err)
+		}
+	}
+	if err := r.Validate(); err != nil {
+		return r, nil, err
+	}
+
+	query, err := url.ParseQuery(rc.Query)
+	if err != nil {
+		return r, nil, fmt.Errorf("alert rule %s: invalid query: %w", rc.Name, err)
+	}
+	for _, p := range []string{"start", "end", "limit"} {
+		if query.Has(p) {
+			return r, nil, fmt.Errorf("alert rule %s: query must not set %q", rc.Name, p)
+		}
+	}
+	return r, query, nil
+}
+
+// absenceRule converts a configured absence rule.
+func (rc alertRuleConfig) absenceRule() (alerts.Rule, url.Values, error) {
+	var r alerts.Rule
+	if rc.Window != "" || rc.Condition != "" || rc.Threshold != 0 || rc.Delay != "" {
+		return r, nil, fmt.Errorf("alert rule %s: absence rules take no window, condition, threshold or delay", rc.Name)
+	}
+	absent, err := time.ParseDuration(rc.Absent)
+	if err != nil || absent < time.Second {
+		return r, nil, fmt.Errorf("alert rule %s: absent must be a duration of at least 1s, got %q", rc.Name, rc.Absent)
+	}
+	interval := time.Minute
+	if rc.Interval != "" {
+		if interval, err = time.ParseDuration(rc.Interval); err != nil {
+			return r, nil, fmt.Errorf("alert rule %s: invalid interval: %w", rc.Name, err)
+		}
+	}
+	query, err := url.ParseQuery(rc.Query)
+	if err != nil {
+		return r, nil, fmt.Errorf("alert rule %s: invalid query: %w", rc.Name, err)
+	}
+	for p := range query {
+		if p != "service" && p != "host" {
+			return r, nil, fmt.Errorf("alert rule %s: absence rule queries may only set service and host, not %q", rc.Name, p)
+		}
+	}
+	r = alerts.AbsenceRule(rc.Name, query.Get("service"), query.Get("host"), absent, interval)
+	if err := r.Validate(); err != nil {
+		return r, nil, err
+	}
+	return r, query, nil
+}
+
+// alertRules builds the configured alert rules, resolving their filters
+// against the server (e.g. derived fields) and their notifiers.
+func (s *server) alertRules(cfg *fileConfig) ([]alerts.Rule, error) {
+	notifiers, err := cfg.notifiers()
+	if err != nil {
+		return nil, err
+	}
+	publicURL, err := cfg.publicURL()
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]alerts.Rule, 0, len(cfg.AlertRules))
+	for _, rc := range cfg.AlertRules {
+		r, query, err := rc.rule()
+		if err != nil {
+			return nil, err
+		}
+		if r.Absent == 0 {
+			filter, apiErr := s.filterFromValues(query)
+			if apiErr != nil {
+				return nil, fmt.Errorf("alert rule %s: %s: %s", rc.Name, apiErr.Error, apiErr.Details)
+			}
+			r.Filter = filter
+		}
+		for _, name := range rc.Notify {
+			n, ok := notifiers[name]
+			if !ok {
+				return nil, fmt.Errorf("alert rule %s: unknown notifier %q", rc.Name, name)
+			}
+			r.Notifiers = append(r.Notifiers, n)
+		}
+		r.NotifyResolved = rc.NotifyResolved == nil || *rc.NotifyResolved
+		if publicURL != "" {
+			r.Link = publicURL + "/?" + query.Encode()
+		}
+		rules = append(rules, r)
+	}
+	sources, _ := cfg.expectedSources() // validated by loadConfig
+	return append(rules, sourceRules(sources, notifiers, publicURL)...), nil
+}
+
+// parseLabels parses a comma-separated list of key=value labels.
+func parseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !metaKeyPattern.MatchString(key) || strings.Contains(key, ".") {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", pair)
+		}
+		labels[key] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
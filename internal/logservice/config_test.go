@@ -0,0 +1,213 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"locog/internal/alerts"
+	"locog/internal/models"
+)
+
+// writeTestConfig writes a JSON config file and returns its path.
+func writeTestConfig(t testing.TB, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "locog.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+// TestLoadConfig_Empty tests that no config path yields an empty config.
+func TestLoadConfig_Empty(t *testing.T) {
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.DerivedFields) != 0 {
+		t.Errorf("expected no derived fields, got %v", cfg.DerivedFields)
+	}
+}
+
+// TestLoadConfig_InvalidDerivedField tests that bad expressions fail at load time.
+func TestLoadConfig_InvalidDerivedField(t *testing.T) {
+	path := writeTestConfig(t, `{"derived_fields": {"bad": "sleep(1)"}}`)
+	if _, err := loadConfig(path); err == nil {
+		t.Error("expected error for invalid derived field expression")
+	}
+
+	path = writeTestConfig(t, `{not json`)
+	if _, err := loadConfig(path); err == nil {
+		t.Error("expected error for malformed config")
+	}
+}
+
+// TestHandleQueryLogs_DerivedFilter tests filtering on a configured derived field.
+func TestHandleQueryLogs_DerivedFilter(t *testing.T) {
+	srv := newTestServer(t)
+
+	cfg, err := loadConfig(writeTestConfig(t, `{"derived_fields": {"status_class": "floor(meta.status_code / 100)"}}`))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	fields, _ := cfg.derivedFields()
+	srv.db.SetDerivedFields(fields)
+
+	for _, code := range []int{200, 500, 503} {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "info",
+			Message: "req", Host: "h", Metadata: map[string]interface{}{"status_code": code}})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/logs?derived=status_class=5", nil)
+	rr := httptest.NewRecorder()
+	srv.handleQueryLogs(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var logs []models.Log
+	json.NewDecoder(rr.Body).Decode(&logs)
+	if len(logs) != 2 {
+		t.Errorf("expected 2 logs with status_class 5, got %d", len(logs))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/logs?derived=unknown=5", nil)
+	rr = httptest.NewRecorder()
+	srv.handleQueryLogs(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for unknown derived field, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// TestLoadConfig_AlertRules tests alert rule parsing and validation.
+func TestLoadConfig_AlertRules(t *testing.T) {
+	path := writeTestConfig(t, `{"alert_rules": [
+		{"name": "api-errors", "query": "service=api&level=ERROR", "window": "5m", "condition": ">", "threshold": 10, "delay": "2m"},
+		{"name": "api-quiet", "query": "service=api", "window": "10m", "condition": "<", "threshold": 1}
+	]}`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+
+	srv := newTestServer(t)
+	rules, err := srv.alertRules(cfg)
+	if err != nil {
+		t.Fatalf("alertRules failed: %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d", len(rules))
+	}
+	if rules[0].Filter.Level != "ERROR" || rules[0].Delay != 2*time.Minute || rules[0].AutoDelay {
+		t.Errorf("unexpected first rule: %+v", rules[0])
+	}
+	if !rules[1].AutoDelay || rules[1].Interval != time.Minute {
+		t.Errorf("expected auto delay and default interval, got %+v", rules[1])
+	}
+
+	invalid := []string{
+		`{"alert_rules": [{"name": "r", "window": "5m", "condition": "=", "threshold": 1}]}`,
+		`{"alert_rules": [{"name": "r", "window": "soon", "condition": ">", "threshold": 1}]}`,
+		`{"alert_rules": [{"name": "r", "query": "start=2025-01-01T00:00:00Z", "window": "5m", "condition": ">"}]}`,
+		`{"alert_rules": [{"name": "r", "window": "5m", "condition": ">"}, {"name": "r", "window": "5m", "condition": ">"}]}`,
+	}
+	for _, content := range invalid {
+		if _, err := loadConfig(writeTestConfig(t, content)); err == nil {
+			t.Errorf("expected error for config %s", content)
+		}
+	}
+
+	// Filters are checked against the server when the rules are built
+	cfg, _ = loadConfig(writeTestConfig(t, `{"alert_rules": [{"name": "r", "query": "derived=missing=1", "window": "5m", "condition": ">"}]}`))
+	if _, err := srv.alertRules(cfg); err == nil {
+		t.Error("expected error for unknown derived field in rule query")
+	}
+}
+
+func TestLoadConfig_AbsenceRules(t *testing.T) {
+	cfg, err := loadConfig(writeTestConfig(t, `{"alert_rules": [
+		{"name": "api-silent", "query": "service=api&host=web-1", "absent": "15m"},
+		{"name": "anything", "absent": "1h", "interval": "5m"}
+	]}`))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	rules, err := newTestServer(t).alertRules(cfg)
+	if err != nil {
+		t.Fatalf("alertRules failed: %v", err)
+	}
+	r := rules[0]
+	if r.Absent != 15*time.Minute || r.Filter.Service != "api" || r.Filter.Host != "web-1" || r.Threshold != 900 || r.Condition != ">=" {
+		t.Errorf("unexpected absence rule: %+v", r)
+	}
+	if rules[1].Interval != 5*time.Minute || rules[1].Filter.Service != "" {
+		t.Errorf("unexpected second rule: %+v", rules[1])
+	}
+
+	for _, content := range []string{
+		`{"alert_rules": [{"name": "r", "absent": "soon"}]}`,
+		`{"alert_rules": [{"name": "r", "absent": "100ms"}]}`,
+		`{"alert_rules": [{"name": "r", "absent": "5m", "window": "5m"}]}`,
+		`{"alert_rules": [{"name": "r", "absent": "5m", "condition": "<"}]}`,
+		`{"alert_rules": [{"name": "r", "absent": "5m", "query": "level=ERROR"}]}`,
+	} {
+		if _, err := loadConfig(writeTestConfig(t, content)); err == nil {
+			t.Errorf("expected error for config %s", content)
+		}
+	}
+}
+
+func TestLoadConfig_Notifiers(t *testing.T) {
+	cfg, err := loadConfig(writeTestConfig(t, `{
+		"public_url": "https://logs.example.com/",
+		"notifiers": {
+			"ops-slack": {"type": "slack", "webhook_url": "https://hooks.slack.com/services/T/B/x"},
+			"bot": {"type": "slack", "token": "xoxb-1", "channel": "#alerts", "template": "{{.Rule}} is {{.State}}"},
+			"oncall": {"type": "email", "smtp_addr": "smtp.example.com:587", "from": "locog@example.com", "to": ["oncall@example.com"]}
+		},
+		"alert_rules": [
+			{"name": "api-errors", "query": "service=api&level=ERROR", "window": "5m", "condition": ">", "threshold": 10, "notify": ["ops-slack", "oncall"]},
+			{"name": "quiet", "window": "5m", "condition": "<", "threshold": 1, "notify": ["bot"], "notify_resolved": false}
+		]}`))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	rules, err := newTestServer(t).alertRules(cfg)
+	if err != nil {
+		t.Fatalf("alertRules failed: %v", err)
+	}
+	if len(rules[0].Notifiers) != 2 || !rules[0].NotifyResolved {
+		t.Errorf("expected two notifiers notified of resolution, got %+v", rules[0])
+	}
+	if _, ok := rules[0].Notifiers[1].(*alerts.EmailNotifier); !ok {
+		t.Errorf("expected the second notifier to send email, got %T", rules[0].Notifiers[1])
+	}
+	if want := "https://logs.example.com/?level=ERROR&service=api"; rules[0].Link != want {
+		t.Errorf("expected link %s, got %s", want, rules[0].Link)
+	}
+	if len(rules[1].Notifiers) != 1 || rules[1].NotifyResolved {
+		t.Errorf("expected one notifier without resolution, got %+v", rules[1])
+	}
+
+	invalid := []string{
+		`{"notifiers": {"n": {"type": "pager"}}}`,
+		`{"notifiers": {"n": {"type": "slack"}}}`,
+		`{"notifiers": {"n": {"type": "slack", "token": "xoxb-1"}}}`,
+		`{"notifiers": {"n": {"type": "slack", "webhook_url": "hooks.slack.com"}}}`,
+		`{"notifiers": {"n": {"type": "slack", "webhook_url": "https://h", "template": "{{.Rule"}}}`,
+		`{"notifiers": {"n": {"type": "email", "smtp_addr": "smtp.example.com", "from": "a@example.com", "to": ["b@example.com"]}}}`,
+		`{"notifiers": {"n": {"type": "email", "smtp_addr": "smtp.example.com:25", "from": "a@example.com"}}}`,
+		`{"public_url": "logs.example.com"}`,
+		`{"alert_rules": [{"name": "r", "window": "5m", "condition": ">", "notify": ["missing"]}]}`,
+	}
+	for _, content := range invalid {
+		if _, err := loadConfig(writeTestConfig(t, content)); err == nil {
+			t.Errorf("expected error for config %s", content)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package logservice
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"locog/internal/models"
+)
+
+// totalCountHeader carries the exact number of matching logs when /api/logs
+// is called with include_total=true.
+const totalCountHeader = "X-Total-Count"
+
+// totalTokenHeader carries the token under which /api/logs/count?token=
+// returns a total counted in the background for include_total=async.
+const totalTokenHeader = "X-Locog-Total-Token"
+
+// maxBackgroundTotals bounds the totals counted or kept at once; beyond it
+// include_total=async counts before responding.
+const maxBackgroundTotals = 1000
+
+// backgroundTotal is an exact total being counted after its first page was
+// returned.
+type backgroundTotal struct {
+	done    chan struct{} // closed once count and err are set
+	count   int64
+	err     error
+	expires time.Time
+}
+
+// backgroundTotals counts the totals of include_total=async queries after
+// their first page was returned, keeping perceived latency low on large
+// tables. Tokens are random, and totals are forgotten ttl after the query.
+type backgroundTotals struct {
+	ttl time.Duration
+	// closing is canceled by close, stopping the counts
+	closing context.Context
+	stop    context.CancelFunc
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	totals map[string]*backgroundTotal
+}
+
+func newBackgroundTotals(ttl time.Duration) *backgroundTotals {
+	closing, stop := context.WithCancel(context.Background())
+	return &backgroundTotals{ttl: ttl, closing: closing, stop: stop, totals: make(map[string]*backgroundTotal)}
+}
+
+// start runs count in the background, bounded by the ttl, and returns the
+// token to fetch its result with. It returns "" when background totals are
+// disabled, closed or too many are kept, for the caller to count itself.
+func (b *backgroundTotals) start(ctx context.Context, count func(context.Context) (int64, error)) string {
+	if b == nil {
+		return ""
+	}
+	now := time.Now()
+	b.mu.Lock()
+	for token, t := range b.totals {
+		if now.After(t.expires) {
+			delete(b.totals, token)
+		}
+	}
+	if len(b.totals) >= maxBackgroundTotals || b.closing.Err() != nil {
+		b.mu.Unlock()
+		return ""
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	token := hex.EncodeToString(id)
+	t := &backgroundTotal{done: make(chan struct{}), expires: now.Add(b.ttl)}
+	b.totals[token] = t
+	b.wg.Add(1)
+	b.mu.Unlock()
+
+	// The count outlives the request, but keeps its trace, until close
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), b.ttl)
+	stopCount := context.AfterFunc(b.closing, cancel)
+	go func() {
+		defer b.wg.Done()
+		defer stopCount()
+		defer cancel()
+		t.count, t.err = count(ctx)
+		close(t.done)
+	}()
+	return token
+}
+
+// close cancels the counts still running and waits for them to return,
+// before the database they query is closed.
+func (b *backgroundTotals) close() {
+	b.mu.Lock()
+	b.stop()
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+// get returns the total under token, or nil once it expired.
+func (b *backgroundTotals) get(token string) *backgroundTotal {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.totals[token]
+	if t == nil || time.Now().After(t.expires) {
+		return nil
+	}
+	return t
+}
+
+// handleCountLogs counts the logs matching the usual filters without reading
+// them, e.g. /api/logs/count?service=api&level=ERROR. An optional max stops
+// counting early on huge results, reporting capped when reached. With a
+// token from /api/logs?include_total=async it returns that query's total.
+func (s *server) handleCountLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		s.writeBackgroundTotal(w, token)
+		return
+	}
+
+	filter, ok := s.parseLogFilter(w, r)
+	if !ok {
+		return
+	}
+
+	var max int64
+	if v := r.URL.Query().Get("max"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid max value",
+				fmt.Sprintf("'max' must be a positive integer, got: %s", v))
+			return
+		}
+		max = n
+	}
+
+	ctx, cancel := s.searchContext(r, filter)
+	defer cancel()
+
+	var result models.LogCount
+	var err error
+	if max > 0 {
+		result.Count, err = s.db.CountLogsUpTo(ctx, filter, max)
+		result.Capped = result.Count >= max
+	} else {
+		result.Count, err = s.db.CountLogs(ctx, filter)
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		s.writeSearchTimeout(w)
+		return
+	}
+	if err != nil {
+		if clientGone(w, r) {
+			return
+		}
+		slog.Error("count failed", "error", err, "filter", filter)
+		writeJSONError(w, http.StatusInternalServerError, "query_failed",
+			"Query failed", "An internal error occurred while counting logs")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// writeBackgroundTotal writes the total counted under token, with 202 and
+// pending set while it is still being counted.
+func (s *server) writeBackgroundTotal(w http.ResponseWriter, token string) {
+	t := s.totals.get(token)
+	if t == nil {
+		writeJSONError(w, http.StatusNotFound, "total_not_found", "Total not found",
+			"the token is unknown or its total expired; query again with include_total=async")
+		return
+	}
+
+	var result models.LogCount
+	status := http.StatusOK
+	select {
+	case <-t.done:
+		if t.err != nil {
+			slog.Error("background count failed", "error", t.err)
+			writeJSONError(w, http.StatusInternalServerError, "query_failed",
+				"Query failed", "An internal error occurred while counting logs")
+			return
+		}
+		result.Count = t.count
+	default:
+		result.Pending = true
+		status = http.StatusAccepted
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
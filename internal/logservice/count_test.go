@@ -0,0 +1,142 @@
+package logservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestHandleCountLogs tests counting matching logs with and without a cap.
+func TestHandleCountLogs(t *testing.T) {
+	srv := newTestServer(t)
+	for i := range 5 {
+		level := "INFO"
+		if i < 3 {
+			level = "ERROR"
+		}
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: level, Message: "m", Host: "h"})
+	}
+
+	count := func(query string) (int, models.LogCount) {
+		rr := httptest.NewRecorder()
+		srv.handleCountLogs(rr, httptest.NewRequest(http.MethodGet, "/api/logs/count?"+query, nil))
+		var c models.LogCount
+		json.NewDecoder(rr.Body).Decode(&c)
+		return rr.Code, c
+	}
+
+	if code, c := count("level=ERROR"); code != http.StatusOK || c.Count != 3 || c.Capped {
+		t.Errorf("expected 3 uncapped, got %d %+v", code, c)
+	}
+	if _, c := count("max=2"); c.Count != 2 || !c.Capped {
+		t.Errorf("expected count capped at 2, got %+v", c)
+	}
+	if code, _ := count("max=0"); code != http.StatusBadRequest {
+		t.Errorf("expected status %d for invalid max, got %d", http.StatusBadRequest, code)
+	}
+}
+
+// TestHandleQueryLogs_IncludeTotal tests the exact total header on queries.
+func TestHandleQueryLogs_IncludeTotal(t *testing.T) {
+	srv := newTestServer(t)
+	for range 5 {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "INFO", Message: "m", Host: "h"})
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{"limit=2&include_total=true", "5"},
+		{"limit=10&include_total=true", "5"},
+		{"limit=2", ""},
+	} {
+		rr := httptest.NewRecorder()
+		srv.handleQueryLogs(rr, httptest.NewRequest(http.MethodGet, "/api/logs?"+tc.query, nil))
+		if got := rr.Header().Get(totalCountHeader); got != tc.want {
+			t.Errorf("%s: expected %s %q, got %q", tc.query, totalCountHeader, tc.want, got)
+		}
+	}
+}
+
+// TestHandleQueryLogs_AsyncTotal tests returning the first page before the
+// total, which is then fetched with its token.
+func TestHandleQueryLogs_AsyncTotal(t *testing.T) {
+	srv := newTestServer(t)
+	srv.totals = newBackgroundTotals(time.Minute)
+	for range 5 {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "INFO", Message: "m", Host: "h"})
+	}
+
+	// A result within the limit is its own total
+	rr := httptest.NewRecorder()
+	srv.handleQueryLogs(rr, httptest.NewRequest(http.MethodGet, "/api/logs?limit=10&include_total=async", nil))
+	if rr.Header().Get(totalCountHeader) != "5" || rr.Header().Get(totalTokenHeader) != "" {
+		t.Errorf("expected the total without a token, got headers %v", rr.Header())
+	}
+
+	rr = httptest.NewRecorder()
+	srv.handleQueryLogs(rr, httptest.NewRequest(http.MethodGet, "/api/logs?limit=2&include_total=async", nil))
+	token := rr.Header().Get(totalTokenHeader)
+	if rr.Code != http.StatusOK || token == "" || rr.Header().Get(totalCountHeader) != "" {
+		t.Fatalf("expected a total token, got %d %v", rr.Code, rr.Header())
+	}
+
+	var c models.LogCount
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rr = httptest.NewRecorder()
+		srv.handleCountLogs(rr, httptest.NewRequest(http.MethodGet, "/api/logs/count?token="+token, nil))
+		c = models.LogCount{}
+		json.NewDecoder(rr.Body).Decode(&c)
+		if rr.Code != http.StatusAccepted || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rr.Code != http.StatusOK || c.Count != 5 || c.Pending {
+		t.Errorf("expected a total of 5, got %d %+v", rr.Code, c)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.handleCountLogs(rr, httptest.NewRequest(http.MethodGet, "/api/logs/count?token=unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown token, got %d", rr.Code)
+	}
+
+	// Without background totals the count is made before responding
+	srv.totals = nil
+	rr = httptest.NewRecorder()
+	srv.handleQueryLogs(rr, httptest.NewRequest(http.MethodGet, "/api/logs?limit=2&include_total=async", nil))
+	if rr.Header().Get(totalCountHeader) != "5" || rr.Header().Get(totalTokenHeader) != "" {
+		t.Errorf("expected a synchronous total, got headers %v", rr.Header())
+	}
+}
+
+// TestBackgroundTotals_Close tests that close cancels the counts still
+// running and waits for them.
+func TestBackgroundTotals_Close(t *testing.T) {
+	totals := newBackgroundTotals(time.Minute)
+	token := totals.start(t.Context(), func(ctx context.Context) (int64, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	totals.close()
+	select {
+	case <-totals.get(token).done:
+	default:
+		t.Fatal("expected the count finished once close returns")
+	}
+	if err := totals.get(token).err; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the count canceled, got %v", err)
+	}
+	if token := totals.start(t.Context(), func(context.Context) (int64, error) { return 0, nil }); token != "" {
+		t.Errorf("expected no background count after close, got token %q", token)
+	}
+}
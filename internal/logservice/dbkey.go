@@ -0,0 +1,37 @@
+package logservice
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"locog/internal/db"
+)
+
+// readDBKey returns the database encryption key given hex-encoded in
+// keyHex, or in the file keyFile, or nil when neither is set.
+func readDBKey(keyHex, keyFile, flagName string) ([]byte, error) {
+	if keyHex != "" && keyFile != "" {
+		return nil, fmt.Errorf("use either -%s or -%s-file, not both", flagName, flagName)
+	}
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("-%s-file: %w", flagName, err)
+		}
+		keyHex = strings.TrimSpace(string(data))
+		if keyHex == "" {
+			return nil, fmt.Errorf("-%s-file: %s is empty", flagName, keyFile)
+		}
+	}
+	if keyHex == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(keyHex)
+	if err != nil || len(key) != db.EncryptionKeySize {
+		return nil, fmt.Errorf("-%s must be %d hex characters (%d bytes), e.g. from 'openssl rand -hex 32'",
+			flagName, 2*db.EncryptionKeySize, db.EncryptionKeySize)
+	}
+	return key, nil
+}
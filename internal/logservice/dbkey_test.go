@@ -0,0 +1,36 @@
+package logservice
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadDBKey(t *testing.T) {
+	keyHex := strings.Repeat("ab", 32)
+	keyFile := filepath.Join(t.TempDir(), "db.key")
+	if err := os.WriteFile(keyFile, []byte(keyHex+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if key, err := readDBKey("", "", "db-key"); err != nil || key != nil {
+		t.Errorf("expected no key, got %x, %v", key, err)
+	}
+	for _, tc := range []struct{ hex, file string }{{keyHex, ""}, {"", keyFile}} {
+		key, err := readDBKey(tc.hex, tc.file, "db-key")
+		if err != nil || len(key) != 32 || key[0] != 0xab {
+			t.Errorf("readDBKey(%q, %q) = %x, %v", tc.hex, tc.file, key, err)
+		}
+	}
+	for _, tc := range []struct{ hex, file string }{
+		{"abcd", ""},
+		{strings.Repeat("zz", 32), ""},
+		{keyHex, keyFile},
+		{"", filepath.Join(t.TempDir(), "missing.key")},
+	} {
+		if _, err := readDBKey(tc.hex, tc.file, "db-key"); err == nil {
+			t.Errorf("readDBKey(%q, %q): expected an error", tc.hex, tc.file)
+		}
+	}
+}
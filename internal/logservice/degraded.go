@@ -0,0 +1,111 @@
+package logservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"locog/internal/db"
+)
+
+// degradedState describes a service serving a backup snapshot read-only
+// because its database failed to open or verify, or serving the database
+// itself read-only because another process is writing to it.
+type degradedState struct {
+	Reason   string    `json:"reason"`
+	Snapshot string    `json:"snapshot"`
+	Since    time.Time `json:"since"`
+}
+
+// latestSnapshot resolves -fallback-snapshot: a database file, or a
+// directory whose most recently modified *.db file is used.
+func latestSnapshot(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return path, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+	var latest string
+	var latestTime time.Time
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !strings.HasSuffix(e.Name(), ".db") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if latest == "" || info.ModTime().After(latestTime) {
+			latest, latestTime = filepath.Join(path, e.Name()), info.ModTime()
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no *.db snapshot in %s", path)
+	}
+	return latest, nil
+}
+
+// openFallbackSnapshot opens the latest snapshot under path read-only,
+// recording cause as the reason the service is degraded.
+func openFallbackSnapshot(path string, opts db.Options, cause error) (db.Store, *degradedState, error) {
+	snapshot, err := latestSnapshot(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("-fallback-snapshot: %w", err)
+	}
+	opts.ReadOnly = true
+	database, err := db.NewWithOptions(snapshot, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open snapshot %s: %w", snapshot, err)
+	}
+	return database, &degradedState{Reason: cause.Error(), Snapshot: snapshot, Since: time.Now().UTC()}, nil
+}
+
+// openInUse opens the database at path read-only because another process
+// holds its writer lock.
+func openInUse(path string, opts db.Options, cause error) (db.Store, *degradedState, error) {
+	opts.ReadOnly = true
+	database, err := db.NewWithOptions(path, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open %s read-only: %w", path, err)
+	}
+	return database, &degradedState{Reason: cause.Error(), Snapshot: path, Since: time.Now().UTC()}, nil
+}
+
+// requireWritable rejects requests that would write while the service is
+// serving a read-only snapshot. GET and HEAD requests pass.
+func (s *server) requireWritable(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.degraded != nil && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSONError(w, http.StatusServiceUnavailable, "read_only",
+				"The service is read-only", s.degraded.Reason+"; see /readyz")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleReadyz reports readiness: 200 when serving the live database, 503
+// with the reason and snapshot while degraded, so load balancers and
+// monitoring notice even though queries are still answered.
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if s.degraded != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(struct {
+			Status string `json:"status"`
+			*degradedState
+		}{"degraded", s.degraded})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ready"})
+}
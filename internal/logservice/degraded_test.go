@@ -0,0 +1,74 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"logs-1.db", "logs-3.db", "logs-2.db", "notes.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(time.Duration(i) * time.Minute)
+		if name == "logs-3.db" {
+			mtime = now.Add(time.Hour)
+		}
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	if got, err := latestSnapshot(dir); err != nil || got != filepath.Join(dir, "logs-3.db") {
+		t.Errorf("expected the newest snapshot, got %q, %v", got, err)
+	}
+	file := filepath.Join(dir, "logs-1.db")
+	if got, err := latestSnapshot(file); err != nil || got != file {
+		t.Errorf("expected a file to be used as is, got %q, %v", got, err)
+	}
+	if _, err := latestSnapshot(t.TempDir()); err == nil {
+		t.Error("expected an error for a directory without snapshots")
+	}
+}
+
+func TestDegradedMode(t *testing.T) {
+	srv := newTestServer(t)
+	handler, err := srv.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(`[]`)))
+		return rr
+	}
+
+	if rr := do(http.MethodGet, "/readyz"); rr.Code != http.StatusOK {
+		t.Errorf("expected a healthy service to be ready, got %d", rr.Code)
+	}
+
+	srv.degraded = &degradedState{Reason: "quick_check failed: page 3 is never used", Snapshot: "/backups/logs.db", Since: time.Now()}
+	rr := do(http.MethodGet, "/readyz")
+	var ready map[string]interface{}
+	json.NewDecoder(rr.Body).Decode(&ready)
+	if rr.Code != http.StatusServiceUnavailable || ready["status"] != "degraded" || ready["snapshot"] != "/backups/logs.db" {
+		t.Errorf("expected a degraded readiness report, got %d %v", rr.Code, ready)
+	}
+
+	if rr := do(http.MethodPost, "/api/ingest"); rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), "read_only") {
+		t.Errorf("expected ingest to be refused, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodGet, "/api/logs"); rr.Code != http.StatusOK {
+		t.Errorf("expected queries to be served, got %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/health"); rr.Code != http.StatusOK {
+		t.Errorf("expected the liveness check to pass, got %d", rr.Code)
+	}
+}
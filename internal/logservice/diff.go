@@ -0,0 +1,121 @@
+package logservice
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+)
+
+const (
+	// diffDefaultWindow is used when no start time is given
+	diffDefaultWindow = time.Hour
+	// diffMaxMessages bounds the distinct messages templated per side
+	diffMaxMessages = 10000
+	// diffDefaultLimit is the default number of templates returned per side
+	diffDefaultLimit = 100
+)
+
+// diffSideValues returns the filter parameters for one side of a diff: the
+// shared parameters overridden by those carrying the side's prefix ("a." or
+// "b."). The time window is always shared.
+func diffSideValues(query url.Values, prefix string) url.Values {
+	values := url.Values{}
+	for key, vals := range query {
+		if !strings.HasPrefix(key, "a.") && !strings.HasPrefix(key, "b.") {
+			values[key] = vals
+		}
+	}
+	for key, vals := range query {
+		if name, ok := strings.CutPrefix(key, prefix); ok && name != "start" && name != "end" {
+			values[name] = vals
+		}
+	}
+	return values
+}
+
+// handleDiff compares two filters over the same window and returns the message
+// templates present in only one of them, e.g.
+// /api/diff?service=api&level=ERROR&a.host=web-1&b.host=web-2
+func (s *server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filterA, ok := s.parseLogFilterValues(w, diffSideValues(query, "a."))
+	if !ok {
+		return
+	}
+	filterB, ok := s.parseLogFilterValues(w, diffSideValues(query, "b."))
+	if !ok {
+		return
+	}
+
+	end := time.Now()
+	if filterA.EndTime != nil {
+		end = *filterA.EndTime
+	}
+	start := end.Add(-diffDefaultWindow)
+	if filterA.StartTime != nil {
+		start = *filterA.StartTime
+	}
+	filterA.StartTime, filterA.EndTime = &start, &end
+	filterB.StartTime, filterB.EndTime = &start, &end
+	if !s.restrictFilter(w, r, &filterA) {
+		return
+	}
+	filterB.ExcludeStreams, filterB.ServicePatterns = filterA.ExcludeStreams, filterA.ServicePatterns
+
+	limit := filterA.Limit
+	if limit <= 0 {
+		limit = diffDefaultLimit
+	}
+
+	templatesA, err := s.db.MessageTemplates(r.Context(), filterA, diffMaxMessages)
+	if err != nil {
+		slog.Error("failed to compute diff templates", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	templatesB, err := s.db.MessageTemplates(r.Context(), filterB, diffMaxMessages)
+	if err != nil {
+		slog.Error("failed to compute diff templates", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	diff := models.FilterDiff{Start: start, End: end}
+	diff.OnlyA, diff.Shared = templatesOnlyIn(templatesA, templatesB, limit)
+	diff.OnlyB, _ = templatesOnlyIn(templatesB, templatesA, limit)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+// templatesOnlyIn returns up to limit templates of a that do not occur in b,
+// and the number of templates the two have in common.
+func templatesOnlyIn(a, b []models.TemplateCount, limit int) ([]models.TemplateCount, int) {
+	inB := make(map[string]bool, len(b))
+	for _, tc := range b {
+		inB[tc.Template] = true
+	}
+
+	only := []models.TemplateCount{}
+	shared := 0
+	for _, tc := range a {
+		if inB[tc.Template] {
+			shared++
+			continue
+		}
+		if len(only) < limit {
+			only = append(only, tc)
+		}
+	}
+	return only, shared
+}
@@ -0,0 +1,59 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestHandleDiff tests comparing the templates of two hosts.
+func TestHandleDiff(t *testing.T) {
+	srv := newTestServer(t)
+
+	insert := func(host, message string) {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now().Add(-time.Minute), Service: "api",
+			Level: "ERROR", Message: message, Host: host})
+	}
+	insert("web-1", "request 1 failed")
+	insert("web-2", "request 2 failed")
+	insert("web-2", "disk full on /dev/sda1")
+	insert("web-1", "cache warmed")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/diff?service=api&a.host=web-1&b.host=web-2", nil)
+	rr := httptest.NewRecorder()
+	srv.handleDiff(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var diff models.FilterDiff
+	if err := json.NewDecoder(rr.Body).Decode(&diff); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if diff.Shared != 1 {
+		t.Errorf("expected 1 shared template, got %d", diff.Shared)
+	}
+	if len(diff.OnlyA) != 1 || diff.OnlyA[0].Example != "cache warmed" {
+		t.Errorf("unexpected only_a: %+v", diff.OnlyA)
+	}
+	if len(diff.OnlyB) != 1 || diff.OnlyB[0].Example != "disk full on /dev/sda1" {
+		t.Errorf("unexpected only_b: %+v", diff.OnlyB)
+	}
+}
+
+// TestHandleDiff_InvalidSide tests that errors in a prefixed parameter are reported.
+func TestHandleDiff_InvalidSide(t *testing.T) {
+	srv := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/diff?a.meta=bad", nil)
+	rr := httptest.NewRecorder()
+	srv.handleDiff(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -0,0 +1,259 @@
+package logservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+)
+
+// elasticCompatVersion is the Elasticsearch version reported to clients,
+// which check it when connecting.
+const elasticCompatVersion = "8.17.0"
+
+// elasticAction is a bulk action line, e.g. {"index":{"_index":"app"}}.
+type elasticAction map[string]struct {
+	Index string `json:"_index"`
+	ID    string `json:"_id"`
+}
+
+// elasticItem is the result of one bulk action.
+type elasticItem struct {
+	Index  string        `json:"_index"`
+	ID     string        `json:"_id,omitempty"`
+	Status int           `json:"status"`
+	Result string        `json:"result,omitempty"`
+	Error  *elasticCause `json:"error,omitempty"`
+}
+
+type elasticCause struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// writeElasticError writes an error in Elasticsearch's format.
+func writeElasticError(w http.ResponseWriter, status int, errType, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  elasticCause{Type: errType, Reason: reason},
+		"status": status,
+	})
+}
+
+// handleElasticInfo answers the cluster info request Elasticsearch clients
+// such as Filebeat send when connecting to /es.
+func (s *server) handleElasticInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":         "locog",
+		"cluster_name": "locog",
+		"version": map[string]string{
+			"number":                             elasticCompatVersion,
+			"build_flavor":                       "default",
+			"minimum_wire_compatibility_version": "7.17.0",
+		},
+		"tagline": "You Know, for Search",
+	})
+}
+
+// handleElasticBulk accepts the index and create actions of the
+// Elasticsearch bulk API (POST /es/_bulk or /es/<index>/_bulk), mapping
+// each document to a log with elasticLog. Other actions, and documents that
+// aren't valid logs, fail individually as Elasticsearch reports them.
+func (s *server) handleElasticBulk(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+
+	ip := getClientIP(r)
+	if limited := s.checkRateLimit(endpointElastic, ip); limited != nil {
+		limited.setHeaders(w)
+		writeElasticError(w, http.StatusTooManyRequests, "es_rejected_execution_exception", limited.Details)
+		return
+	}
+
+	body, err := readBody(w, r)
+	if err != nil {
+		writeElasticError(w, http.StatusBadRequest, "parse_exception", err.Error())
+		return
+	}
+
+	hosts := s.hostNaming.forRequest(r)
+	var items []map[string]elasticItem
+	var logs []models.Log
+	rest := body
+	for len(rest) > 0 {
+		var line []byte
+		line, rest, _ = bytes.Cut(rest, []byte("\n"))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var action elasticAction
+		if err := json.Unmarshal(line, &action); err != nil || len(action) != 1 {
+			writeElasticError(w, http.StatusBadRequest, "illegal_argument_exception",
+				fmt.Sprintf("malformed action/metadata line [%d]", len(items)+1))
+			return
+		}
+		var name string
+		for k := range action {
+			name = k
+		}
+		meta := action[name]
+		item := elasticItem{Index: meta.Index, ID: meta.ID}
+		if item.Index == "" {
+			item.Index = r.PathValue("index")
+		}
+
+		switch name {
+		case "index", "create":
+			var source []byte
+			source, rest, _ = bytes.Cut(rest, []byte("\n"))
+			var doc map[string]interface{}
+			if err := json.Unmarshal(source, &doc); err != nil {
+				item.Status = http.StatusBadRequest
+				item.Error = &elasticCause{"document_parsing_exception", "failed to parse document: " + err.Error()}
+				break
+			}
+			l := elasticLog(doc, item.Index)
+			hosts.name(&l)
+			s.levelInference.infer(&l)
+			if l.Level == "" {
+				l.Level = "info"
+			}
+			if err := validateLog(&l); err != nil {
+				item.Status = http.StatusBadRequest
+				item.Error = &elasticCause{"document_parsing_exception", err.Error()}
+				break
+			}
+			item.Status, item.Result = http.StatusCreated, "created"
+			logs = append(logs, l)
+		case "update":
+			_, rest, _ = bytes.Cut(rest, []byte("\n")) // the update's document
+			fallthrough
+		case "delete":
+			item.Status = http.StatusBadRequest
+			item.Error = &elasticCause{"action_request_validation_exception", "locog only accepts index and create actions"}
+		default:
+			writeElasticError(w, http.StatusBadRequest, "illegal_argument_exception",
+				fmt.Sprintf("unknown bulk action [%s]", name))
+			return
+		}
+		items = append(items, map[string]elasticItem{name: item})
+	}
+
+	if len(logs) > 0 {
+		refund, limited := s.checkQuotas(r, logs)
+		if limited != nil {
+			limited.setHeaders(w)
+			writeElasticError(w, http.StatusTooManyRequests, "es_rejected_execution_exception", limited.Details)
+			return
+		}
+		if err := s.storeLogs(r.Context(), logs, ip); err != nil {
+			refund()
+			writeElasticError(w, http.StatusServiceUnavailable, "unavailable_shards_exception", "Internal error")
+			return
+		}
+	}
+	failed := len(items) - len(logs)
+	if failed > 0 {
+		slog.Warn("rejected bulk documents", "sender", ip, "rejected", failed, "total", len(items))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"took":   time.Since(start).Milliseconds(),
+		"errors": failed > 0,
+		"items":  items,
+	})
+}
+
+// elasticLog maps a bulk document to a log, reading ECS fields (@timestamp,
+// message, log.level, service.name, host.name) and common alternatives, as
+// dotted keys or nested objects. The service falls back to the index; a
+// missing level is left empty for the caller to infer or default to info.
+// Other fields become metadata, with the index as es_index.
+func elasticLog(doc map[string]interface{}, index string) models.Log {
+	var l models.Log
+	switch ts := popField(doc, "@timestamp", "timestamp").(type) {
+	case string:
+		l.Timestamp, _ = time.Parse(time.RFC3339Nano, ts)
+	case float64:
+		l.Timestamp = time.UnixMilli(int64(ts)).UTC() // epoch_millis
+	}
+	l.Message = fieldString(popField(doc, "message", "msg"))
+	l.Level = fieldString(popField(doc, "log.level", "level", "severity"))
+	l.Service = fieldString(popField(doc, "service.name"))
+	if service, ok := doc["service"].(string); ok {
+		delete(doc, "service")
+		if l.Service == "" {
+			l.Service = service
+		}
+	}
+	if l.Service == "" {
+		l.Service = index
+	}
+	if l.Service == "" {
+		l.Service = "elasticsearch"
+	}
+	l.Host = fieldString(popField(doc, "host.name", "host.hostname", "hostname"))
+	if host, ok := doc["host"].(string); ok {
+		delete(doc, "host")
+		if l.Host == "" {
+			l.Host = host
+		}
+	}
+
+	if index != "" {
+		doc["es_index"] = index
+	}
+	if len(doc) > 0 {
+		l.Metadata = doc
+	}
+	return l
+}
+
+// popField removes and returns the first of keys present in doc, each a
+// top-level key or a dotted path into nested objects. Objects left empty
+// are removed too.
+func popField(doc map[string]interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		if v, ok := doc[key]; ok {
+			delete(doc, key)
+			return v
+		}
+		parent, name, ok := strings.Cut(key, ".")
+		if !ok {
+			continue
+		}
+		nested, isMap := doc[parent].(map[string]interface{})
+		if !isMap {
+			continue
+		}
+		if v := popField(nested, name); v != nil {
+			if len(nested) == 0 {
+				delete(doc, parent)
+			}
+			return v
+		}
+	}
+	return nil
+}
+
+// fieldString returns a field as text: strings as is, other values as JSON.
+func fieldString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
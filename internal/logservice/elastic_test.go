@@ -0,0 +1,98 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHandleElasticBulk tests that bulk documents are mapped to logs and
+// that failures are reported per item.
+func TestHandleElasticBulk(t *testing.T) {
+	srv := newTestServer(t)
+	handler, err := srv.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := strings.Join([]string{
+		`{"index":{}}`,
+		`{"@timestamp":"2025-01-15T12:00:00.123Z","message":"payment declined","log":{"level":"warn","logger":"billing"},"service":{"name":"checkout","version":"1.2"},"host":{"name":"web-1"},"order_id":42}`,
+		`{"create":{"_index":"nginx","_id":"a1"}}`,
+		`{"message":"GET /health 200","host":"lb-1","timestamp":1736942400000}`,
+		`{"index":{}}`,
+		`{"level":"error"}`,
+		`{"delete":{"_id":"a1"}}`,
+		`{"update":{"_id":"a1"}}`,
+		`{"doc":{"message":"changed"}}`,
+		`{"index":{}}`,
+		`not json`,
+	}, "\n") + "\n"
+	req := httptest.NewRequest(http.MethodPost, "/es/apps/_bulk", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Errors bool                     `json:"errors"`
+		Items  []map[string]elasticItem `json:"items"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	var statuses []int
+	for _, item := range resp.Items {
+		for _, result := range item {
+			statuses = append(statuses, result.Status)
+		}
+	}
+	if !resp.Errors || len(statuses) != 6 || statuses[0] != 201 || statuses[1] != 201 ||
+		statuses[2] != 400 || statuses[3] != 400 || statuses[4] != 400 || statuses[5] != 400 {
+		t.Fatalf("unexpected items %+v", resp.Items)
+	}
+	if item := resp.Items[1]["create"]; item.Index != "nginx" || item.ID != "a1" {
+		t.Errorf("expected the create action's index and id, got %+v", item)
+	}
+
+	logs := queryAll(t, srv)
+	if len(logs) != 2 {
+		t.Fatalf("expected 2 logs, got %d", len(logs))
+	}
+	byService := map[string]int{}
+	for i, l := range logs {
+		byService[l.Service] = i
+	}
+	l := logs[byService["checkout"]]
+	if l.Level != "warn" || l.Host != "web-1" || l.Message != "payment declined" ||
+		!l.Timestamp.Equal(time.Date(2025, 1, 15, 12, 0, 0, 123e6, time.UTC)) {
+		t.Errorf("unexpected ECS log %+v", l)
+	}
+	logMeta, _ := l.Metadata["log"].(map[string]interface{})
+	serviceMeta, _ := l.Metadata["service"].(map[string]interface{})
+	if l.Metadata["order_id"] != float64(42) || logMeta["logger"] != "billing" || serviceMeta["version"] != "1.2" ||
+		l.Metadata["es_index"] != "apps" {
+		t.Errorf("unexpected metadata %v", l.Metadata)
+	}
+	l = logs[byService["nginx"]]
+	if l.Level != "info" || l.Host != "lb-1" || !l.Timestamp.Equal(time.UnixMilli(1736942400000)) {
+		t.Errorf("expected the index as service and level info, got %+v", l)
+	}
+
+	// Clients check the cluster info when connecting
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/es/", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"number":"`+elasticCompatVersion+`"`) ||
+		rr.Header().Get("X-Elastic-Product") != "Elasticsearch" {
+		t.Errorf("unexpected cluster info %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/es/_bulk", strings.NewReader(`{"frobnicate":{}}`+"\n")))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an unknown action, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -0,0 +1,71 @@
+package logservice
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"locog/internal/models"
+)
+
+const (
+	// topErrorsDefaultWindow is used when no start time is given
+	topErrorsDefaultWindow = 24 * time.Hour
+	// topErrorsMaxMessages bounds the distinct messages templated
+	topErrorsMaxMessages = 10000
+	// topErrorsDefaultLimit is the default number of templates returned
+	topErrorsDefaultLimit = 20
+)
+
+// errorLevels returns the known levels at least as severe as ERROR.
+func errorLevels() []string {
+	return levelsAtLeast("ERROR")
+}
+
+// handleTopErrors lists the most frequent error message templates with their
+// occurrences per version, e.g. /api/top-errors?service=api&start=... A
+// template only seen in the latest version was introduced by that release.
+// Errors are logs at ERROR or above unless level or min_level is given.
+func (s *server) handleTopErrors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, ok := s.parseLogFilter(w, r)
+	if !ok {
+		return
+	}
+	if filter.Level == "" && len(filter.Levels) == 0 {
+		filter.Levels = errorLevels()
+	}
+
+	end := time.Now()
+	if filter.EndTime != nil {
+		end = *filter.EndTime
+	}
+	start := end.Add(-topErrorsDefaultWindow)
+	if filter.StartTime != nil {
+		start = *filter.StartTime
+	}
+	filter.StartTime, filter.EndTime = &start, &end
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = topErrorsDefaultLimit
+	}
+
+	groups, err := s.db.TopErrors(r.Context(), filter, topErrorsMaxMessages)
+	if err != nil {
+		slog.Error("failed to compute top errors", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if len(groups) > limit {
+		groups = groups[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.TopErrors{Start: start, End: end, Errors: groups})
+}
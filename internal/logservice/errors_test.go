@@ -0,0 +1,57 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestHandleTopErrors tests grouping errors by template and version.
+func TestHandleTopErrors(t *testing.T) {
+	srv := newTestServer(t)
+
+	insert := func(level, message, version string) {
+		l := models.Log{Timestamp: time.Now().Add(-time.Minute), Service: "api", Level: level, Message: message, Host: "h"}
+		if version != "" {
+			l.Metadata = map[string]interface{}{"version": version}
+		}
+		srv.db.InsertLog(t.Context(), &l)
+	}
+	insert("ERROR", "order 1 failed", "1.4.0")
+	insert("ERROR", "order 2 failed", "1.4.0")
+	insert("ERROR", "order 3 failed", "1.5.0")
+	insert("FATAL", "nil pointer in checkout", "1.5.0")
+	insert("ERROR", "order 4 failed", "")
+	insert("INFO", "order 5 placed", "1.5.0")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/top-errors?service=api", nil)
+	rr := httptest.NewRecorder()
+	srv.handleTopErrors(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var top models.TopErrors
+	if err := json.NewDecoder(rr.Body).Decode(&top); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(top.Errors) != 2 {
+		t.Fatalf("expected 2 error templates, got %+v", top.Errors)
+	}
+
+	orders := top.Errors[0]
+	if orders.Template != "order <num> failed" || orders.Count != 4 || len(orders.ID) != 16 {
+		t.Errorf("unexpected first group: %+v", orders)
+	}
+	want := []models.VersionCount{{Version: "1.4.0", Count: 2}, {Version: "1.5.0", Count: 1}}
+	if len(orders.Versions) != 2 || orders.Versions[0] != want[0] || orders.Versions[1] != want[1] {
+		t.Errorf("expected versions %+v, got %+v", want, orders.Versions)
+	}
+	if v := top.Errors[1].Versions; len(v) != 1 || v[0].Version != "1.5.0" {
+		t.Errorf("expected the checkout error only in 1.5.0, got %+v", v)
+	}
+}
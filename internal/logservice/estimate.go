@@ -0,0 +1,32 @@
+package logservice
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// handleEstimate estimates the cost of a /api/logs query without running it,
+// e.g. /api/estimate?service=api&search=timeout, so clients can warn before
+// a query scans millions of logs. It accepts the same filters as /api/logs.
+func (s *server) handleEstimate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, ok := s.parseLogFilter(w, r)
+	if !ok {
+		return
+	}
+
+	est, err := s.db.EstimateLogs(r.Context(), filter)
+	if err != nil {
+		slog.Error("failed to estimate query", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(est)
+}
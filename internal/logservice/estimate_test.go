@@ -0,0 +1,41 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestHandleEstimate tests estimating a query from its filters.
+func TestHandleEstimate(t *testing.T) {
+	srv := newTestServer(t)
+	for _, service := range []string{"api", "api", "api", "web"} {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: service, Level: "INFO", Message: "m", Host: "h"})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/estimate?service=api&search=timeout", nil)
+	rr := httptest.NewRecorder()
+	srv.handleEstimate(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var est models.QueryEstimate
+	if err := json.NewDecoder(rr.Body).Decode(&est); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if est.TotalRows != 4 || est.EstimatedRows != 3 || len(est.Unestimated) != 1 || len(est.Plan) == 0 {
+		t.Errorf("unexpected estimate: %+v", est)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/estimate?start=yesterday", nil)
+	rr = httptest.NewRecorder()
+	srv.handleEstimate(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid filter, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
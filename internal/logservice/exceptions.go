@@ -0,0 +1,279 @@
+package logservice
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"locog/internal/models"
+)
+
+const (
+	// maxExceptionFrames bounds the stack frames kept in metadata, innermost
+	// first, as for Sentry events.
+	maxExceptionFrames = sentryMaxFrames
+	// maxCollapsedTraceBytes stops merging trace lines into a message that
+	// has grown this long.
+	maxCollapsedTraceBytes = 64 << 10
+)
+
+// Runtimes whose traces are recognized
+const (
+	runtimeGo     = "go"
+	runtimePython = "python"
+	runtimeJava   = "java"
+)
+
+var (
+	goPanicStart   = regexp.MustCompile(`^(panic|fatal error): (.*)$`)
+	goGoroutine    = regexp.MustCompile(`^goroutine \d+ \[.*\]:$`)
+	goFunction     = regexp.MustCompile(`^[\w./*()\[\]\-]+\(.*\)$`)
+	goFileLine     = regexp.MustCompile(`^\s+(.+):(\d+)(?: \+0x[0-9a-f]+)?$`)
+	goTraceLine    = regexp.MustCompile(`^(\s|\[signal |created by |exit status \d+$)`)
+	pythonStart    = regexp.MustCompile(`^Traceback \(most recent call last\):$`)
+	pythonFrame    = regexp.MustCompile(`^\s+File "(.+)", line (\d+), in (.+)$`)
+	pythonRaised   = regexp.MustCompile(`^([A-Za-z_][\w.]*)(?:: (.*))?$`)
+	pythonChained  = regexp.MustCompile(`^(During handling of the above exception|The above exception was the direct cause)`)
+	javaHeader     = regexp.MustCompile(`^(?:Exception in thread "[^"]*" )?([A-Za-z_$][\w$]*(?:\.[A-Za-z_$][\w$]*)*)(?:: (.*))?$`)
+	javaFrame      = regexp.MustCompile(`^\s+at (.+?)\((.*)\)$`)
+	javaTraceLine  = regexp.MustCompile(`^(\s+at |\s*\.\.\. \d+ (more|common frames omitted)$|\s*Caused by: |\s*Suppressed: )`)
+	javaLikelyType = regexp.MustCompile(`(Exception|Error|Throwable)$`)
+)
+
+// traceState follows a trace being sent one line per log, to tell which of
+// the logs after it continue it.
+type traceState struct {
+	runtime string
+	// raised is set once a Python trace has named its exception, after
+	// which only a chained traceback continues it
+	raised bool
+}
+
+// startTrace returns the state of a trace starting with message, or nil
+// when message doesn't start one.
+func startTrace(message string) *traceState {
+	lines := strings.Split(message, "\n")
+	var t *traceState
+	switch {
+	case goPanicStart.MatchString(lines[0]):
+		t = &traceState{runtime: runtimeGo}
+	case pythonStart.MatchString(lines[0]):
+		t = &traceState{runtime: runtimePython}
+	case javaHeader.MatchString(lines[0]) && javaLikelyType.MatchString(javaHeader.FindStringSubmatch(lines[0])[1]):
+		t = &traceState{runtime: runtimeJava}
+	default:
+		return nil
+	}
+	for _, line := range lines[1:] {
+		t.continues(line)
+	}
+	return t
+}
+
+// continues reports whether line belongs to the trace.
+func (t *traceState) continues(line string) bool {
+	switch t.runtime {
+	case runtimeGo:
+		return line == "" || goTraceLine.MatchString(line) || goGoroutine.MatchString(line) ||
+			goPanicStart.MatchString(line) || goFunction.MatchString(line)
+	case runtimePython:
+		switch {
+		case pythonChained.MatchString(line) || pythonStart.MatchString(line):
+			t.raised = false
+			return true
+		case t.raised:
+			return false
+		case line == "" || line[0] == ' ' || line[0] == '\t':
+			return true
+		case pythonRaised.MatchString(line):
+			t.raised = true
+			return true
+		}
+		return false
+	case runtimeJava:
+		return javaTraceLine.MatchString(line)
+	}
+	return false
+}
+
+// collapseTraces merges traces sent one line per log, e.g. by shippers
+// reading stderr, back into one log: lines continuing a trace are appended
+// to the previous log of the same service and host within the batch.
+func collapseTraces(logs []models.Log) []models.Log {
+	out := logs[:0]
+	var trace *traceState
+	for _, l := range logs {
+		if trace != nil {
+			last := &out[len(out)-1]
+			if l.Service == last.Service && l.Host == last.Host &&
+				len(last.Message) < maxCollapsedTraceBytes && trace.continues(l.Message) {
+				last.Message += "\n" + l.Message
+				continue
+			}
+		}
+		out = append(out, l)
+		trace = startTrace(l.Message)
+	}
+	return out
+}
+
+// parseException structures a Go panic, Python traceback or Java exception
+// in message as metadata like that of Sentry events: the exception's type,
+// value, module and innermost frames. It returns nil for other messages.
+func parseException(message string) map[string]interface{} {
+	if !strings.Contains(message, "\n") {
+		return nil
+	}
+	lines := strings.Split(message, "\n")
+	switch {
+	case goPanicStart.MatchString(lines[0]):
+		return parseGoPanic(lines)
+	case strings.Contains(message, "Traceback (most recent call last):"):
+		return parsePythonTraceback(lines)
+	default:
+		return parseJavaException(lines)
+	}
+}
+
+// parseGoPanic reads the panic value and the panicking goroutine's stack.
+func parseGoPanic(lines []string) map[string]interface{} {
+	m := goPanicStart.FindStringSubmatch(lines[0])
+	exception := map[string]interface{}{"runtime": runtimeGo, "type": m[1], "value": m[2]}
+	if value, ok := strings.CutPrefix(m[2], "runtime error: "); ok {
+		exception["type"], exception["value"] = "runtime error", value
+	}
+
+	var stack []string
+	inGoroutine := false
+frames:
+	for i := 1; i < len(lines) && len(stack) < maxExceptionFrames; i++ {
+		switch {
+		case goGoroutine.MatchString(lines[i]):
+			if inGoroutine {
+				break frames // only the first goroutine panicked
+			}
+			inGoroutine = true
+		case !inGoroutine:
+		case lines[i] == "":
+			break frames
+		case goFunction.MatchString(lines[i]) && i+1 < len(lines):
+			loc := goFileLine.FindStringSubmatch(lines[i+1])
+			if loc == nil {
+				continue
+			}
+			stack = append(stack, goFunctionName(lines[i])+" ("+loc[1]+":"+loc[2]+")")
+			i++
+		}
+	}
+	if len(stack) > 0 {
+		exception["stacktrace"] = stack
+	}
+	return exception
+}
+
+// goFunctionName drops the arguments from a stack trace's function line,
+// e.g. "main.(*T).run(0xc000012345)" to "main.(*T).run".
+func goFunctionName(line string) string {
+	if i := strings.LastIndex(line, "("); i > 0 && strings.HasSuffix(line, ")") {
+		return line[:i]
+	}
+	return line
+}
+
+// parsePythonTraceback reads the last traceback, whose exception was raised
+// last, with its frames innermost first.
+func parsePythonTraceback(lines []string) map[string]interface{} {
+	start := 0
+	for i, line := range lines {
+		if pythonStart.MatchString(line) {
+			start = i
+		}
+	}
+
+	var stack []string
+	var exception map[string]interface{}
+	for _, line := range lines[start+1:] {
+		if m := pythonFrame.FindStringSubmatch(line); m != nil {
+			stack = append(stack, m[3]+" ("+m[1]+":"+m[2]+")")
+			continue
+		}
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if m := pythonRaised.FindStringSubmatch(line); m != nil {
+			exception = map[string]interface{}{"runtime": runtimePython}
+			setExceptionType(exception, m[1])
+			exception["value"] = m[2]
+			break
+		}
+	}
+	if exception == nil {
+		return nil
+	}
+	if len(stack) > 0 {
+		// Python prints the innermost frame last
+		slices.Reverse(stack)
+		exception["stacktrace"] = stack[:min(len(stack), maxExceptionFrames)]
+	}
+	return exception
+}
+
+// parseJavaException reads the outermost exception, the header before the
+// first "at" frame, and its frames.
+func parseJavaException(lines []string) map[string]interface{} {
+	for i := 1; i < len(lines); i++ {
+		if !javaFrame.MatchString(lines[i]) {
+			continue
+		}
+		m := javaHeader.FindStringSubmatch(lines[i-1])
+		if m == nil {
+			return nil
+		}
+		exception := map[string]interface{}{"runtime": runtimeJava, "value": m[2]}
+		setExceptionType(exception, m[1])
+
+		var stack []string
+		for _, line := range lines[i:] {
+			f := javaFrame.FindStringSubmatch(line)
+			if f == nil || len(stack) == maxExceptionFrames {
+				break
+			}
+			stack = append(stack, f[1]+" ("+f[2]+")")
+		}
+		exception["stacktrace"] = stack
+		return exception
+	}
+	return nil
+}
+
+// setExceptionType splits a qualified class name into the exception's type
+// and module, e.g. "java.lang.NullPointerException".
+func setExceptionType(exception map[string]interface{}, name string) {
+	if i := strings.LastIndex(name, "."); i > 0 {
+		exception["module"] = name[:i]
+		name = name[i+1:]
+	}
+	exception["type"] = name
+}
+
+// structureExceptions collapses traces sent a line at a time and adds the
+// parsed exception to the metadata of logs carrying one, unless they
+// already have exception metadata (e.g. from Sentry).
+func structureExceptions(logs []models.Log) []models.Log {
+	logs = collapseTraces(logs)
+	forEachLog(len(logs), func(i int) {
+		l := &logs[i]
+		if _, ok := l.Metadata["exception"]; ok {
+			return
+		}
+		exception := parseException(l.Message)
+		if exception == nil {
+			return
+		}
+		if l.Metadata == nil {
+			l.Metadata = make(map[string]interface{})
+		}
+		l.Metadata["exception"] = exception
+	})
+	return logs
+}
@@ -0,0 +1,157 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"locog/internal/models"
+)
+
+const (
+	goPanicTrace = `panic: runtime error: invalid memory address or nil pointer dereference
+[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x4a1b2c]
+
+goroutine 7 [running]:
+main.(*Server).handle(0x0, {0x5d2f60, 0xc000010000})
+	/app/server.go:42 +0x1c
+main.main()
+	/app/main.go:10 +0x25
+
+goroutine 1 [chan receive]:
+main.wait()
+	/app/main.go:20 +0x10
+exit status 2`
+
+	pythonTrace = `Traceback (most recent call last):
+  File "/app/worker.py", line 10, in <module>
+    main()
+  File "/app/worker.py", line 5, in main
+    fetch()
+  File "/usr/lib/python3/site-packages/requests/models.py", line 1021, in raise_for_status
+    raise HTTPError(http_error_msg, response=self)
+requests.exceptions.HTTPError: 503 Server Error`
+
+	javaTrace = `Request failed
+java.lang.NullPointerException: Cannot invoke "String.length()" because "s" is null
+	at com.example.App.run(App.java:12)
+	at com.example.App.main(App.java:5)
+Caused by: java.io.IOException: closed
+	at com.example.Io.read(Io.java:3)
+	... 2 more`
+)
+
+// TestParseException tests structuring traces of each runtime.
+func TestParseException(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    map[string]interface{}
+	}{
+		{"go", goPanicTrace, map[string]interface{}{
+			"runtime": "go", "type": "runtime error", "value": "invalid memory address or nil pointer dereference",
+			"stacktrace": []string{"main.(*Server).handle (/app/server.go:42)", "main.main (/app/main.go:10)"},
+		}},
+		{"python", pythonTrace, map[string]interface{}{
+			"runtime": "python", "type": "HTTPError", "module": "requests.exceptions", "value": "503 Server Error",
+			"stacktrace": []string{
+				"raise_for_status (/usr/lib/python3/site-packages/requests/models.py:1021)",
+				"main (/app/worker.py:5)",
+				"<module> (/app/worker.py:10)",
+			},
+		}},
+		{"java", javaTrace, map[string]interface{}{
+			"runtime": "java", "type": "NullPointerException", "module": "java.lang",
+			"value":      `Cannot invoke "String.length()" because "s" is null`,
+			"stacktrace": []string{"com.example.App.run (App.java:12)", "com.example.App.main (App.java:5)"},
+		}},
+		{"plain", "request failed\nretrying", nil},
+		{"one line", "panic: boom", nil},
+	}
+	for _, tc := range tests {
+		got := parseException(tc.message)
+		if tc.want == nil {
+			if got != nil {
+				t.Errorf("%s: expected nil, got %v", tc.name, got)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+// TestCollapseTraces tests merging traces sent a line per log.
+func TestCollapseTraces(t *testing.T) {
+	var logs []models.Log
+	add := func(service, message string) {
+		for _, line := range strings.Split(message, "\n") {
+			if strings.TrimSpace(line) != "" {
+				logs = append(logs, models.Log{Service: service, Host: "h", Message: line})
+			}
+		}
+	}
+	add("api", "starting")
+	add("api", goPanicTrace)
+	add("worker", pythonTrace)
+	add("worker", "restarting")
+	add("app", strings.TrimPrefix(javaTrace, "Request failed\n"))
+	add("other", "\tat not.a.Trace(Other.java:1)")
+
+	got := collapseTraces(logs)
+	var messages []string
+	for _, l := range got {
+		messages = append(messages, l.Message)
+	}
+	want := []string{
+		"starting",
+		strings.ReplaceAll(goPanicTrace, "\n\n", "\n"),
+		pythonTrace,
+		"restarting",
+		strings.TrimPrefix(javaTrace, "Request failed\n"),
+		"\tat not.a.Trace(Other.java:1)",
+	}
+	if !reflect.DeepEqual(messages, want) {
+		t.Errorf("expected %q, got %q", want, messages)
+	}
+}
+
+// TestIngest_ParseExceptions tests that ingested traces can be filtered by
+// exception type.
+func TestIngest_ParseExceptions(t *testing.T) {
+	srv := newTestServer(t)
+	srv.parseExceptions = true
+	handler, err := srv.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var batch []map[string]string
+	for _, line := range strings.Split(javaTrace, "\n") {
+		batch = append(batch, map[string]string{"service": "app", "host": "h", "level": "error", "message": line})
+	}
+	body, _ := json.Marshal(batch)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(string(body))))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("ingest failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	query := url.Values{"meta": {"exception.type=NullPointerException"}}
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/logs?"+query.Encode(), nil))
+	var logs []models.Log
+	json.NewDecoder(rr.Body).Decode(&logs)
+	// The line before the exception is a log of its own
+	if len(logs) != 1 || logs[0].Message != strings.TrimPrefix(javaTrace, "Request failed\n") {
+		t.Fatalf("expected the trace as one log, got %+v", logs)
+	}
+	if stack, _ := logs[0].Metadata["exception"].(map[string]interface{})["stacktrace"].([]interface{}); len(stack) != 2 {
+		t.Errorf("expected two frames, got %v", logs[0].Metadata["exception"])
+	}
+}
@@ -0,0 +1,281 @@
+package logservice
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"locog/internal/logtext"
+	"locog/internal/models"
+)
+
+const (
+	// maxExportRows caps /api/logs/export, which otherwise has no limit
+	maxExportRows = 1000000
+	// exportFlushRows is how many rows an export writes between flushes
+	exportFlushRows = 1000
+)
+
+// parseOutputFormat reads the format and template query parameters, returning
+// the template for format=text or nil for JSON.
+func parseOutputFormat(w http.ResponseWriter, r *http.Request) (*template.Template, bool) {
+	q := r.URL.Query()
+	switch format := q.Get("format"); format {
+	case "", "json":
+		if q.Has("template") {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+				"Templates require format=text", "")
+			return nil, false
+		}
+		return nil, true
+	case "text":
+		tmpl, err := logtext.Parse(q.Get("template"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+				"Invalid template", err.Error())
+			return nil, false
+		}
+		return tmpl, true
+	default:
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+			"Invalid format value", fmt.Sprintf("'format' must be json or text, got: %s", format))
+		return nil, false
+	}
+}
+
+// exportRequest is a validated export of the logs matching filter.
+type exportRequest struct {
+	filter models.LogFilter
+	fields []string // nil exports whole logs as NDJSON
+	format string   // csv or ndjson
+	limit  int
+}
+
+// parseExport reads an export request from the /api/logs filters plus
+// format=csv or format=ndjson, optional fields= and snapshot=, writing the
+// error response when it is invalid.
+func (s *server) parseExport(w http.ResponseWriter, r *http.Request) (exportRequest, bool) {
+	filter, ok := s.parseLogFilter(w, r)
+	if !ok {
+		return exportRequest{}, false
+	}
+	fields, apiErr := parseProjection(&filter, r.URL.Query())
+	if apiErr != nil {
+		writeJSONError(w, http.StatusBadRequest, apiErr.Code, apiErr.Error, apiErr.Details)
+		return exportRequest{}, false
+	}
+	format := r.URL.Query().Get("format")
+	if format != "csv" && format != "ndjson" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid format value",
+			fmt.Sprintf("'format' must be csv or ndjson, got: %q", format))
+		return exportRequest{}, false
+	}
+	if filter.Limit > maxExportRows {
+		writeJSONError(w, http.StatusBadRequest, "invalid_limit", "Invalid limit value",
+			fmt.Sprintf("exports are limited to %d rows", maxExportRows))
+		return exportRequest{}, false
+	}
+	limit := filter.Limit
+	if limit == 0 {
+		limit = maxExportRows
+	}
+	if fields == nil && format == "csv" {
+		fields = exportColumns
+	}
+	if !s.pinSnapshot(w, r, &filter) {
+		return exportRequest{}, false
+	}
+	return exportRequest{filter: filter, fields: fields, format: format, limit: limit}, true
+}
+
+// probe returns the filter to read the export with: one extra row tells a
+// complete export from a truncated one.
+func (req exportRequest) probe() models.LogFilter {
+	probe := req.filter
+	probe.Limit = req.limit + 1
+	probe.OmitMetadata = req.fields != nil && !slices.Contains(req.fields, "metadata")
+	return probe
+}
+
+// exportWriter writes an export's rows to w.
+type exportWriter struct {
+	csvw   *csv.Writer
+	enc    *json.Encoder
+	fields []string
+}
+
+func newExportWriter(w io.Writer, req exportRequest) *exportWriter {
+	e := &exportWriter{enc: json.NewEncoder(w), fields: req.fields}
+	if req.format == "csv" {
+		e.csvw = csv.NewWriter(w)
+	}
+	return e
+}
+
+// header writes the CSV header row; NDJSON has none.
+func (e *exportWriter) header() error {
+	if e.csvw == nil {
+		return nil
+	}
+	return e.csvw.Write(e.fields)
+}
+
+func (e *exportWriter) write(l models.Log) error {
+	switch {
+	case e.csvw != nil:
+		return e.csvw.Write(csvRecord(l, e.fields))
+	case e.fields != nil:
+		return e.enc.Encode(projectLogs([]models.Log{l}, e.fields)[0])
+	default:
+		return e.enc.Encode(l)
+	}
+}
+
+// flush writes buffered CSV rows.
+func (e *exportWriter) flush() error {
+	if e.csvw == nil {
+		return nil
+	}
+	e.csvw.Flush()
+	return e.csvw.Error()
+}
+
+// handleExportLogs streams the logs matching the /api/logs filters as
+// format=csv or format=ndjson, e.g. to pull an incident window into a
+// spreadsheet. Unlike /api/logs it has no default limit, only maxExportRows;
+// when that cuts the export the X-Locog-Truncated trailer is "true". Large
+// exports are better run as jobs under /api/exports.
+func (s *server) handleExportLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, ok := s.parseExport(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := s.searchContext(r, req.filter)
+	defer cancel()
+
+	// Headers wait for the first row so query errors can still be reported
+	out := newExportWriter(w, req)
+	rc := http.NewResponseController(w)
+	started := false
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="logs.%s"`, req.format))
+		w.Header().Set("Trailer", truncatedHeader)
+		w.Header().Set("Content-Type", exportContentTypes[req.format])
+		return out.header()
+	}
+
+	rows := 0
+	err := s.db.EachLog(ctx, req.probe(), func(l models.Log) error {
+		if rows == req.limit {
+			w.Header().Set(truncatedHeader, "true")
+			return nil
+		}
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		rows++
+
+		err := out.write(l)
+		if err == nil && rows%exportFlushRows == 0 {
+			err = out.flush()
+			rc.Flush()
+		}
+		return err
+	})
+	if err != nil {
+		if !started {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				s.writeSearchTimeout(w)
+				return
+			}
+			if clientGone(w, r) {
+				return
+			}
+			slog.Error("export failed", "error", err, "filter", req.filter)
+			writeJSONError(w, http.StatusInternalServerError, "query_failed",
+				"Query failed", "An internal error occurred while exporting logs")
+			return
+		}
+		// The status is sent; cutting the response short marks the failure
+		if r.Context().Err() != nil {
+			slog.Debug("client disconnected, export cancelled", "rows", rows)
+			return
+		}
+		slog.Error("export interrupted", "error", err, "rows", rows)
+		panic(http.ErrAbortHandler)
+	}
+	if !started {
+		start()
+	}
+	out.flush()
+}
+
+// exportContentTypes are the Content-Type of each export format.
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+}
+
+// exportColumns are the CSV columns exported when fields= isn't given.
+var exportColumns = []string{"id", "timestamp", "service", "level", "host", "message", "metadata", "tags", "stream"}
+
+// csvRecord formats a log's fields as CSV values: times as RFC 3339,
+// metadata as JSON and tags comma-separated. Values a spreadsheet would run
+// as a formula are prefixed with a quote.
+func csvRecord(l models.Log, fields []string) []string {
+	record := make([]string, len(fields))
+	for i, field := range fields {
+		var v string
+		switch field {
+		case "id":
+			v = strconv.FormatInt(l.ID, 10)
+		case "timestamp":
+			v = l.Timestamp.Format(time.RFC3339Nano)
+		case "service":
+			v = l.Service
+		case "level":
+			v = l.Level
+		case "message":
+			v = l.Message
+		case "metadata":
+			if len(l.Metadata) > 0 {
+				data, _ := json.Marshal(l.Metadata)
+				v = string(data)
+			}
+		case "host":
+			v = l.Host
+		case "created_at":
+			v = l.CreatedAt.Format(time.RFC3339Nano)
+		case "tags":
+			v = strings.Join(l.Tags, ",")
+		case "source":
+			v = l.Source
+		case "stream":
+			v = l.Stream
+		}
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+			v = "'" + v
+		}
+		record[i] = v
+	}
+	return record
+}
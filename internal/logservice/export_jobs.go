@@ -0,0 +1,392 @@
+package logservice
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"locog/internal/db"
+	"locog/internal/models"
+)
+
+const (
+	// maxQueuedExports bounds the export jobs waiting for a worker
+	maxQueuedExports = 100
+	// exportJobTimeout bounds how long one export job runs
+	exportJobTimeout = time.Hour
+	// exportFilePrefix names export files, so those left by a previous run
+	// can be told apart in a shared directory
+	exportFilePrefix = "locog-export-"
+)
+
+// Export job states
+const (
+	exportQueued    = "queued"
+	exportRunning   = "running"
+	exportDone      = "done"
+	exportFailed    = "failed"
+	exportCancelled = "cancelled"
+)
+
+// exportJob is an export run in the background. Its ID is random, so only
+// whoever started the job can follow and download it.
+type exportJob struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Format      string     `json:"format"`
+	Rows        int        `json:"rows"` // written so far
+	Limit       int        `json:"limit"`
+	SnapshotID  int64      `json:"snapshot_id,omitempty"` // max_id pinning the export with snapshot=true
+	Truncated   bool       `json:"truncated,omitempty"`
+	Bytes       int64      `json:"bytes,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"` // when the job and its file are deleted
+	DownloadURL string     `json:"download_url,omitempty"`
+
+	req    exportRequest
+	path   string
+	cancel context.CancelFunc
+}
+
+// exportQueue runs export jobs on a pool of workers. Each job writes to a
+// file in dir that can be downloaded until ttl after it finishes. Jobs are
+// kept in memory and forgotten on restart.
+type exportQueue struct {
+	db      db.Store
+	dir     string
+	ttl     time.Duration
+	workers int
+	pending chan *exportJob
+
+	mu   sync.Mutex
+	jobs map[string]*exportJob
+}
+
+// newExportQueue creates dir if needed and deletes the files a previous run
+// left in it.
+func newExportQueue(database db.Store, dir string, workers int, ttl time.Duration) (*exportQueue, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create export directory: %w", err)
+	}
+	stale, _ := filepath.Glob(filepath.Join(dir, exportFilePrefix+"*"))
+	for _, path := range stale {
+		os.Remove(path)
+	}
+	return &exportQueue{
+		db:      database,
+		dir:     dir,
+		ttl:     ttl,
+		workers: workers,
+		pending: make(chan *exportJob, maxQueuedExports),
+		jobs:    make(map[string]*exportJob),
+	}, nil
+}
+
+// run works through queued jobs and deletes expired ones until ctx is done,
+// then waits for the running jobs, which stop with ctx.
+func (q *exportQueue) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for range q.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-q.pending:
+					q.export(ctx, job)
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			q.expire(now)
+		}
+	}
+}
+
+// submit queues a job for req. It returns nil when the queue is full.
+func (q *exportQueue) submit(req exportRequest) *exportJob {
+	id := make([]byte, 16)
+	rand.Read(id)
+	job := &exportJob{
+		ID:         hex.EncodeToString(id),
+		Status:     exportQueued,
+		Format:     req.format,
+		Limit:      req.limit,
+		SnapshotID: req.filter.MaxID,
+		CreatedAt:  time.Now().UTC(),
+		req:        req,
+	}
+	job.path = filepath.Join(q.dir, exportFilePrefix+job.ID+"."+req.format)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.pending <- job:
+	default:
+		return nil
+	}
+	q.jobs[job.ID] = job
+	return job
+}
+
+// get returns a copy of a job.
+func (q *exportQueue) get(id string) (exportJob, bool) {
+	if q == nil {
+		return exportJob{}, false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return exportJob{}, false
+	}
+	return job.view(), true
+}
+
+// view copies the job for reading outside the queue's lock.
+func (job *exportJob) view() exportJob {
+	v := *job
+	if v.Status == exportDone {
+		v.DownloadURL = "/api/exports/" + v.ID + "/download"
+	}
+	return v
+}
+
+// remove cancels a job that is still queued or running, deletes its file and
+// forgets it.
+func (q *exportQueue) remove(id string) (exportJob, bool) {
+	if q == nil {
+		return exportJob{}, false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return exportJob{}, false
+	}
+	delete(q.jobs, id)
+	switch job.Status {
+	case exportQueued:
+		job.Status = exportCancelled
+	case exportRunning:
+		// The worker deletes the file once the export stops
+		job.Status = exportCancelled
+		job.cancel()
+	default:
+		os.Remove(job.path)
+	}
+	return job.view(), true
+}
+
+// expire deletes the jobs, and their files, that expired by now.
+func (q *exportQueue) expire(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, job := range q.jobs {
+		if job.ExpiresAt != nil && !now.Before(*job.ExpiresAt) {
+			os.Remove(job.path)
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// export runs a queued job.
+func (q *exportQueue) export(ctx context.Context, job *exportJob) {
+	ctx, cancel := context.WithTimeout(ctx, exportJobTimeout)
+	defer cancel()
+
+	q.mu.Lock()
+	if job.Status != exportQueued {
+		q.mu.Unlock()
+		return
+	}
+	started := time.Now().UTC()
+	job.Status, job.StartedAt, job.cancel = exportRunning, &started, cancel
+	q.mu.Unlock()
+
+	size, err := q.write(ctx, job)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	finished := time.Now().UTC()
+	expires := finished.Add(q.ttl)
+	job.FinishedAt, job.ExpiresAt = &finished, &expires
+	switch {
+	case job.Status == exportCancelled:
+		os.Remove(job.path)
+	case err != nil:
+		os.Remove(job.path)
+		job.Status = exportFailed
+		job.Error = "An internal error occurred while exporting logs"
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			job.Error = fmt.Sprintf("the export took longer than %s; narrow the time range or add filters", exportJobTimeout)
+		}
+		slog.Error("export job failed", "id", job.ID, "error", err, "rows", job.Rows, "filter", job.req.filter)
+	default:
+		job.Status, job.Bytes = exportDone, size
+		slog.Info("export job finished", "id", job.ID, "rows", job.Rows, "bytes", size,
+			"duration", finished.Sub(started))
+	}
+}
+
+// write exports the job's logs to its file, updating its row count as it
+// goes, and returns the file's size.
+func (q *exportQueue) write(ctx context.Context, job *exportJob) (int64, error) {
+	f, err := os.OpenFile(job.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	buf := bufio.NewWriter(f)
+	out := newExportWriter(buf, job.req)
+	if err := out.header(); err != nil {
+		return 0, err
+	}
+
+	rows, truncated := 0, false
+	progress := func() {
+		q.mu.Lock()
+		job.Rows, job.Truncated = rows, truncated
+		q.mu.Unlock()
+	}
+	err = q.db.EachLog(ctx, job.req.probe(), func(l models.Log) error {
+		if rows == job.req.limit {
+			truncated = true
+			return nil
+		}
+		rows++
+		if err := out.write(l); err != nil {
+			return err
+		}
+		if rows%exportFlushRows == 0 {
+			progress()
+		}
+		return nil
+	})
+	progress()
+	if err != nil {
+		return 0, err
+	}
+	if err := out.flush(); err != nil {
+		return 0, err
+	}
+	if err := buf.Flush(); err != nil {
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), f.Close()
+}
+
+// handleExports starts an export job: POST /api/exports takes the
+// parameters of /api/logs/export and answers 202 with the job. A worker
+// writes the export to a file, so large exports don't hold a request open
+// for as long as they run; GET /api/exports/{id} reports its progress and
+// GET /api/exports/{id}/download fetches the result once done.
+func (s *server) handleExports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.exports == nil {
+		writeJSONError(w, http.StatusNotFound, "exports_disabled", "Export jobs are disabled",
+			"start the service with -export-workers above 0")
+		return
+	}
+
+	req, ok := s.parseExport(w, r)
+	if !ok {
+		return
+	}
+	job := s.exports.submit(req)
+	if job == nil {
+		w.Header().Set("Retry-After", "60")
+		writeJSONError(w, http.StatusServiceUnavailable, "export_queue_full", "Too many queued exports",
+			fmt.Sprintf("at most %d exports may wait for a worker; retry later", maxQueuedExports))
+		return
+	}
+	view, _ := s.exports.get(job.ID)
+	w.Header().Set("Location", "/api/exports/"+job.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(view)
+}
+
+// handleExportJob reports on an export job (GET) or cancels and deletes it
+// (DELETE).
+func (s *server) handleExportJob(w http.ResponseWriter, r *http.Request) {
+	var job exportJob
+	var ok bool
+	switch r.Method {
+	case http.MethodGet:
+		job, ok = s.exports.get(r.PathValue("id"))
+	case http.MethodDelete:
+		job, ok = s.exports.remove(r.PathValue("id"))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Export not found",
+			"exports are deleted once they expire")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleExportDownload serves a finished export job's file.
+func (s *server) handleExportDownload(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.exports.get(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Export not found",
+			"exports are deleted once they expire")
+		return
+	}
+	if job.Status != exportDone {
+		writeJSONError(w, http.StatusConflict, "export_not_ready", "Export is not done",
+			fmt.Sprintf("the export is %s", job.Status))
+		return
+	}
+
+	f, err := os.Open(job.path)
+	if err != nil {
+		// Deleted between the lookup and now
+		writeJSONError(w, http.StatusNotFound, "not_found", "Export not found",
+			"exports are deleted once they expire")
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="logs.%s"`, job.Format))
+	w.Header().Set("Content-Type", exportContentTypes[job.Format])
+	if job.Truncated {
+		w.Header().Set(truncatedHeader, "true")
+	}
+	http.ServeContent(w, r, "", *job.FinishedAt, f)
+}
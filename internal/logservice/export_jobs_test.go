@@ -0,0 +1,88 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestExportJobs tests running an export in the background, following its
+// progress, downloading the result and its expiry.
+func TestExportJobs(t *testing.T) {
+	srv := newTestServer(t)
+	exports, err := newExportQueue(srv.db, t.TempDir(), 1, time.Hour)
+	if err != nil {
+		t.Fatalf("newExportQueue failed: %v", err)
+	}
+	go exports.run(t.Context())
+	srv.exports = exports
+	ts := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, service := range []string{"api", "api", "web"} {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: ts.Add(time.Duration(i) * time.Second),
+			Service: service, Level: "INFO", Message: "m", Host: "h"})
+	}
+
+	handler, err := srv.routes()
+	if err != nil {
+		t.Fatalf("routes failed: %v", err)
+	}
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	if rr := do(http.MethodPost, "/api/exports?format=xml"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid format, got %d", rr.Code)
+	}
+	rr := do(http.MethodPost, "/api/exports?format=ndjson&service=api&fields=service,message")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var job exportJob
+	json.NewDecoder(rr.Body).Decode(&job)
+	if job.ID == "" || rr.Header().Get("Location") != "/api/exports/"+job.ID {
+		t.Fatalf("unexpected job %+v, location %q", job, rr.Header().Get("Location"))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status != exportDone {
+		if job.Status == exportFailed || time.Now().After(deadline) {
+			t.Fatalf("export didn't finish: %+v", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+		json.NewDecoder(do(http.MethodGet, "/api/exports/"+job.ID).Body).Decode(&job)
+	}
+	if job.Rows != 2 || job.Truncated || job.Bytes == 0 || job.DownloadURL != "/api/exports/"+job.ID+"/download" {
+		t.Errorf("unexpected finished job %+v", job)
+	}
+
+	rr = do(http.MethodGet, job.DownloadURL)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("unexpected download %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	want := `{"message":"m","service":"api"}` + "\n" + `{"message":"m","service":"api"}` + "\n"
+	if rr.Body.String() != want {
+		t.Errorf("expected %q, got %q", want, rr.Body.String())
+	}
+
+	// Finished jobs are deleted with their files once they expire
+	exports.expire(job.ExpiresAt.Add(time.Second))
+	if rr := do(http.MethodGet, job.DownloadURL); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after expiry, got %d", rr.Code)
+	}
+
+	// Deleting a job cancels it
+	rr = do(http.MethodPost, "/api/exports?format=csv")
+	json.NewDecoder(rr.Body).Decode(&job)
+	if rr := do(http.MethodDelete, "/api/exports/"+job.ID); rr.Code != http.StatusOK {
+		t.Errorf("expected 200 deleting a job, got %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/api/exports/"+job.ID); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted job, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,144 @@
+package logservice
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestHandleQueryLogs_TextFormat tests rendering query results with templates.
+func TestHandleQueryLogs_TextFormat(t *testing.T) {
+	srv := newTestServer(t)
+	ts := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: ts, Service: "api", Level: "INFO", Message: "started", Host: "h"})
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: ts.Add(time.Second), Service: "api", Level: "ERROR", Message: "boom", Host: "h",
+		Metadata: map[string]interface{}{"http": map[string]interface{}{"status": 503}}})
+
+	query := func(params url.Values) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		srv.handleQueryLogs(rr, httptest.NewRequest(http.MethodGet, "/api/logs?"+params.Encode(), nil))
+		return rr
+	}
+
+	rr := query(url.Values{"format": {"text"}})
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected response %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	want := "2025-03-01T12:00:00.000Z [INFO] api: started\n2025-03-01T12:00:01.000Z [ERROR] api: boom\n"
+	if rr.Body.String() != want {
+		t.Errorf("expected oldest first in the default format:\n%s\ngot:\n%s", want, rr.Body.String())
+	}
+
+	rr = query(url.Values{"format": {"text"}, "level": {"ERROR"},
+		"template": {`{{.Timestamp.Format "Jan _2 15:04:05"}} {{lower .Level}} {{.Message}} status={{meta . "http.status"}} user={{meta . "user"}}`}})
+	if want := "Mar  1 12:00:01 error boom status=503 user=\n"; rr.Body.String() != want {
+		t.Errorf("expected %q, got %q", want, rr.Body.String())
+	}
+
+	for _, params := range []url.Values{
+		{"format": {"xml"}},
+		{"template": {"{{.Message}}"}},
+		{"format": {"text"}, "template": {"{{.Message"}},
+		{"format": {"text"}, "include_sparkline": {"true"}},
+	} {
+		if rr := query(params); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %v, got %d", http.StatusBadRequest, params, rr.Code)
+		}
+	}
+}
+
+// TestExportTaggedLogs_Template tests text exports rendered with a template.
+func TestExportTaggedLogs_Template(t *testing.T) {
+	srv := newTestServer(t)
+	dir := t.TempDir()
+	tmpl, err := tagPolicyConfig{Tag: "incident-*", ExportTemplate: "[{{.Level}}] {{.Message}}"}.exportTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.tagExports = []tagExport{{pattern: "incident-*", dir: dir, template: tmpl}}
+
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "ERROR", Message: "boom", Host: "h"})
+	srv.db.TagLogs(t.Context(), models.LogFilter{Level: "ERROR"}, "incident-7")
+	if err := srv.exportTaggedLogs(t.Context()); err != nil {
+		t.Fatalf("exportTaggedLogs failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "incident-7.log"))
+	if err != nil || string(data) != "[ERROR] boom\n" {
+		t.Errorf("unexpected export %q (%v)", data, err)
+	}
+}
+
+// TestHandleExportLogs tests streaming matching logs as CSV and NDJSON.
+func TestHandleExportLogs(t *testing.T) {
+	srv := newTestServer(t)
+	ts := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: ts, Service: "api", Level: "INFO", Message: "started", Host: "h"})
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: ts.Add(time.Second), Service: "api", Level: "ERROR", Message: "=cmd()", Host: "h",
+		Metadata: map[string]interface{}{"status": 503}})
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: ts.Add(2 * time.Second), Service: "web", Level: "INFO", Message: "other", Host: "h"})
+
+	query := func(params url.Values) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		srv.handleExportLogs(rr, httptest.NewRequest(http.MethodGet, "/api/logs/export?"+params.Encode(), nil))
+		return rr
+	}
+
+	rr := query(url.Values{"format": {"csv"}, "service": {"api"}, "order": {"asc"}})
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("unexpected response %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(records) != 3 || strings.Join(records[0], ",") != strings.Join(exportColumns, ",") {
+		t.Fatalf("expected a header and 2 rows, got %v", records)
+	}
+	if records[1][5] != "started" || records[2][5] != "'=cmd()" || records[2][6] != `{"status":503}` {
+		t.Errorf("unexpected rows %v", records[1:])
+	}
+
+	rr = query(url.Values{"format": {"ndjson"}, "fields": {"message"}, "limit": {"2"}})
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 2 || lines[0] != `{"message":"other"}` {
+		t.Errorf("expected 2 projected lines, newest first, got %q", lines)
+	}
+	if rr.Header().Get(truncatedHeader) != "true" {
+		t.Errorf("expected the %s trailer", truncatedHeader)
+	}
+
+	rr = query(url.Values{"format": {"ndjson"}})
+	var l models.Log
+	if err := json.NewDecoder(rr.Body).Decode(&l); err != nil || l.Message != "other" {
+		t.Errorf("expected full logs, got %+v, %v", l, err)
+	}
+	if rr.Header().Get(truncatedHeader) != "" {
+		t.Errorf("expected no %s trailer for a complete export", truncatedHeader)
+	}
+
+	rr = query(url.Values{"format": {"csv"}, "service": {"none"}})
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != strings.Join(exportColumns, ",") {
+		t.Errorf("expected only the header for an empty export, got %q", rr.Body.String())
+	}
+
+	for _, params := range []url.Values{
+		{},
+		{"format": {"json"}},
+		{"format": {"csv"}, "limit": {"1000001"}},
+		{"format": {"csv"}, "fields": {"bogus"}},
+	} {
+		if rr := query(params); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %v, got %d", http.StatusBadRequest, params, rr.Code)
+		}
+	}
+}
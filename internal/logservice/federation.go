@@ -0,0 +1,156 @@
+package logservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"locog/internal/models"
+	"locog/internal/tracing"
+)
+
+// federatedErrorsHeader lists peers that failed during a federated query.
+const federatedErrorsHeader = "X-Locog-Federation-Errors"
+
+// defaultPeerTimeout bounds each peer request unless configured otherwise.
+const defaultPeerTimeout = 5 * time.Second
+
+// peer is another locog instance queried by federated requests.
+type peer struct {
+	name  string
+	url   string // base URL, e.g. http://staging:5081
+	token string // optional bearer token
+}
+
+// federation fans queries out to peer instances.
+type federation struct {
+	name   string // source label for this instance's own results
+	peers  []peer
+	client *http.Client
+}
+
+// peerResult is the outcome of querying one peer.
+type peerResult struct {
+	peer       string
+	logs       []models.Log
+	truncation resultTruncation
+	err        error
+}
+
+// queryPeers runs the query against every peer concurrently.
+func (f *federation) queryPeers(ctx context.Context, query url.Values) []peerResult {
+	results := make([]peerResult, len(f.peers))
+	var wg sync.WaitGroup
+	for i, p := range f.peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			peerCtx, span := tracing.Start(ctx, "federation.query", tracing.KindClient, tracing.String("peer", p.name))
+			logs, truncation, err := f.queryPeer(peerCtx, p, query)
+			span.RecordError(err)
+			span.End()
+			results[i] = peerResult{peer: p.name, logs: logs, truncation: truncation, err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (f *federation) queryPeer(ctx context.Context, p peer, query url.Values) ([]models.Log, resultTruncation, error) {
+	var truncation resultTruncation
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimSuffix(p.url, "/")+"/api/logs?"+query.Encode(), nil)
+	if err != nil {
+		return nil, truncation, err
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, truncation, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, truncation, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var logs []models.Log
+	if err := json.NewDecoder(resp.Body).Decode(&logs); err != nil {
+		return nil, truncation, fmt.Errorf("decode response: %w", err)
+	}
+
+	truncation.truncated = resp.Header.Get(truncatedHeader) == "true"
+	truncation.estimate, _ = strconv.ParseInt(resp.Header.Get(totalEstimateHeader), 10, 64)
+	return logs, truncation, nil
+}
+
+// federate merges local logs with those of every peer, labelling each log with
+// its source, newest first (oldest first when filter.Ascending) and cut to
+// limit. Peers answer with their own token, so their logs are held to the
+// services filter lets the request read. Failed peers are reported in a
+// response header rather than failing the whole query. The returned
+// truncation covers the peers and the merge; the caller adds its own.
+func (s *server) federate(w http.ResponseWriter, r *http.Request, local []models.Log, limit int, filter models.LogFilter) ([]models.Log, resultTruncation) {
+	ascending := filter.Ascending
+	query := r.URL.Query()
+	query.Del("federated")
+	// Merging needs timestamps; fields are projected after the merge
+	query.Del("fields")
+
+	merged := make([]models.Log, 0, len(local))
+	for _, l := range local {
+		l.Source = s.federation.name
+		merged = append(merged, l)
+	}
+
+	var truncation resultTruncation
+	var failed []string
+	for _, res := range s.federation.queryPeers(r.Context(), query) {
+		if res.err != nil {
+			slog.Warn("federated peer query failed", "peer", res.peer, "error", res.err)
+			failed = append(failed, res.peer)
+			continue
+		}
+		for _, l := range res.logs {
+			if !serviceMatches(filter.ServicePatterns, l.Service) {
+				continue
+			}
+			l.Source = res.peer
+			merged = append(merged, l)
+		}
+		if res.truncation.truncated {
+			truncation.merge(res.truncation)
+		} else {
+			truncation.estimate += int64(len(res.logs))
+		}
+	}
+	if len(failed) > 0 {
+		w.Header().Set(federatedErrorsHeader, strings.Join(failed, ", "))
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		if ascending {
+			return merged[i].Timestamp.Before(merged[j].Timestamp)
+		}
+		return merged[i].Timestamp.After(merged[j].Timestamp)
+	})
+	if len(merged) > limit {
+		merged = merged[:limit]
+		truncation.truncated = true
+	}
+	return merged, truncation
+}
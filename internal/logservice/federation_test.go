@@ -0,0 +1,111 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestHandleQueryLogs_Federated tests merging local and peer results.
+func TestHandleQueryLogs_Federated(t *testing.T) {
+	now := time.Now()
+
+	var gotQuery string
+	staging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		if r.Header.Get("Authorization") != "Bearer peer-token" {
+			t.Errorf("expected peer token to be sent")
+		}
+		json.NewEncoder(w).Encode([]models.Log{
+			{ID: 1, Timestamp: now.Add(-time.Second), Service: "api", Level: "ERROR", Message: "staging error", Host: "s1"},
+		})
+	}))
+	defer staging.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	srv := newTestServer(t)
+	srv.federation = &federation{
+		name: "prod",
+		peers: []peer{
+			{name: "staging", url: staging.URL, token: "peer-token"},
+			{name: "edge", url: broken.URL},
+		},
+		client: staging.Client(),
+	}
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: now, Service: "api", Level: "ERROR", Message: "prod error", Host: "p1"})
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: now.Add(-time.Minute), Service: "api", Level: "ERROR", Message: "older prod error", Host: "p1"})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/logs?level=ERROR&federated=true&limit=2", nil)
+	rr := httptest.NewRecorder()
+	srv.handleQueryLogs(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if gotQuery != "level=ERROR&limit=2" {
+		t.Errorf("expected peer query without federated, got %q", gotQuery)
+	}
+	if got := rr.Header().Get(federatedErrorsHeader); got != "edge" {
+		t.Errorf("expected failed peer in header, got %q", got)
+	}
+	if rr.Header().Get(truncatedHeader) != "true" || rr.Header().Get(totalEstimateHeader) != "3" {
+		t.Errorf("expected merged result marked truncated with estimate 3, got %v", rr.Header())
+	}
+
+	var logs []models.Log
+	json.NewDecoder(rr.Body).Decode(&logs)
+	if len(logs) != 2 {
+		t.Fatalf("expected merged results cut to limit 2, got %d", len(logs))
+	}
+	if logs[0].Source != "prod" || logs[0].Message != "prod error" {
+		t.Errorf("expected newest local log first, got %+v", logs[0])
+	}
+	if logs[1].Source != "staging" || logs[1].Message != "staging error" {
+		t.Errorf("expected staging log second, got %+v", logs[1])
+	}
+}
+
+// TestHandleQueryLogs_FederationDisabled tests federated queries without peers.
+func TestHandleQueryLogs_FederationDisabled(t *testing.T) {
+	srv := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/logs?federated=true", nil)
+	rr := httptest.NewRecorder()
+	srv.handleQueryLogs(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// TestFederationConfig tests peer validation.
+func TestFederationConfig(t *testing.T) {
+	cfg := &fileConfig{Federation: &federationConfig{Peers: []peerConfig{{Name: "staging", URL: "http://staging:5081"}}}}
+	f, err := cfg.federation()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.name != "local" || len(f.peers) != 1 || f.client.Timeout != defaultPeerTimeout {
+		t.Errorf("unexpected federation: %+v", f)
+	}
+
+	invalid := []federationConfig{
+		{Peers: []peerConfig{{Name: "", URL: "http://a"}}},
+		{Peers: []peerConfig{{Name: "a", URL: "ftp://a"}}},
+		{Peers: []peerConfig{{Name: "a", URL: "http://a"}, {Name: "a", URL: "http://b"}}},
+		{Timeout: "soon", Peers: []peerConfig{{Name: "a", URL: "http://a"}}},
+	}
+	for _, fc := range invalid {
+		cfg := &fileConfig{Federation: &fc}
+		if _, err := cfg.federation(); err == nil {
+			t.Errorf("expected error for %+v", fc)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package logservice
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"locog/internal/models"
+)
+
+// logFields are the fields fields= can select, as named in the JSON output.
+var logFields = []string{"id", "timestamp", "service", "level", "message", "metadata", "host", "created_at", "tags", "source", "stream"}
+
+// parseProjection reads the order and fields parameters shared by /api/logs
+// and its export, setting filter.Ascending and returning the fields.
+func parseProjection(filter *models.LogFilter, query url.Values) ([]string, *apiError) {
+	switch order := query.Get("order"); order {
+	case "", "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		return nil, filterError("invalid_parameter", "Invalid order value",
+			fmt.Sprintf("'order' must be asc or desc, got: %s", order))
+	}
+	return parseFields(query.Get("fields"))
+}
+
+// parseFields parses a comma-separated fields= projection, e.g.
+// "id,timestamp,message". An empty value selects every field and returns nil.
+func parseFields(v string) ([]string, *apiError) {
+	var fields []string
+	for _, field := range strings.Split(v, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || slices.Contains(fields, field) {
+			continue
+		}
+		if !slices.Contains(logFields, field) {
+			return nil, filterError("invalid_parameter", "Invalid fields value",
+				fmt.Sprintf("unknown field %q; use %s", field, strings.Join(logFields, ", ")))
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// projectLogs returns logs with only the given fields. Empty optional fields
+// are left out, as in the full output.
+func projectLogs(logs []models.Log, fields []string) []map[string]interface{} {
+	projected := make([]map[string]interface{}, 0, len(logs))
+	for _, l := range logs {
+		m := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			switch field {
+			case "id":
+				m[field] = l.ID
+			case "timestamp":
+				m[field] = l.Timestamp
+			case "service":
+				m[field] = l.Service
+			case "level":
+				m[field] = l.Level
+			case "message":
+				m[field] = l.Message
+			case "metadata":
+				if len(l.Metadata) > 0 {
+					m[field] = l.Metadata
+				}
+			case "host":
+				m[field] = l.Host
+			case "created_at":
+				m[field] = l.CreatedAt
+			case "tags":
+				if len(l.Tags) > 0 {
+					m[field] = l.Tags
+				}
+			case "source":
+				if l.Source != "" {
+					m[field] = l.Source
+				}
+			case "stream":
+				if l.Stream != "" {
+					m[field] = l.Stream
+				}
+			}
+		}
+		projected = append(projected, m)
+	}
+	return projected
+}
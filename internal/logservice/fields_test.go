@@ -0,0 +1,67 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestHandleQueryLogs_OrderAndFields(t *testing.T) {
+	srv := newTestServer(t)
+
+	base := time.Now().Add(-time.Hour)
+	for i, msg := range []string{"first", "second", "third"} {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: base.Add(time.Duration(i) * time.Minute), Service: "api",
+			Level: "INFO", Message: msg, Host: "h", Metadata: map[string]interface{}{"blob": "large"}})
+	}
+
+	query := func(params string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/logs?"+params, nil)
+		rr := httptest.NewRecorder()
+		srv.handleQueryLogs(rr, req)
+		return rr
+	}
+
+	rr := query("order=asc&limit=2")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var logs []models.Log
+	json.NewDecoder(rr.Body).Decode(&logs)
+	if len(logs) != 2 || logs[0].Message != "first" || logs[1].Message != "second" {
+		t.Errorf("expected the oldest logs first, got %+v", logs)
+	}
+
+	rr = query("order=desc&fields=id,message")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var projected []map[string]interface{}
+	json.NewDecoder(rr.Body).Decode(&projected)
+	if len(projected) != 3 || projected[0]["message"] != "third" {
+		t.Fatalf("expected the newest log first, got %v", projected)
+	}
+	if len(projected[0]) != 2 || projected[0]["id"] == nil {
+		t.Errorf("expected only id and message, got %v", projected[0])
+	}
+
+	rr = query("fields=message,metadata&include_sparkline=true")
+	var resp struct {
+		Logs      []map[string]interface{} `json:"logs"`
+		Sparkline *models.Sparkline        `json:"sparkline"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if len(resp.Logs) != 3 || resp.Logs[0]["metadata"] == nil || resp.Sparkline == nil {
+		t.Errorf("expected projected logs with metadata and a sparkline, got %+v", resp)
+	}
+
+	for _, params := range []string{"order=newest", "fields=id,bogus", "fields=id&format=text"} {
+		if rr := query(params); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", params, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package logservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+)
+
+// parseLogFilter builds a LogFilter from the common query parameters (service,
+// level, host and their negations service!=, level!= and host!=, min_level,
+// host_group, search, search_regex, regex, exclude, tag, stream, limit, start,
+// end, max_id, cursor, meta, derived and the q= query language, see query.go), leaving out
+// streams and services the request may not read (see restrictFilter). On
+// invalid input it writes a JSON error response and returns false.
+func (s *server) parseLogFilter(w http.ResponseWriter, r *http.Request) (models.LogFilter, bool) {
+	filter, ok := s.parseLogFilterValues(w, r.URL.Query())
+	if !ok {
+		return filter, false
+	}
+	return filter, s.restrictFilter(w, r, &filter)
+}
+
+// parseLogFilterValues is parseLogFilter over an explicit set of parameters.
+func (s *server) parseLogFilterValues(w http.ResponseWriter, query url.Values) (models.LogFilter, bool) {
+	filter, apiErr := s.filterFromValues(query)
+	if apiErr != nil {
+		writeJSONError(w, http.StatusBadRequest, apiErr.Code, apiErr.Error, apiErr.Details)
+		return filter, false
+	}
+	return filter, true
+}
+
+// filterError describes invalid filter input.
+func filterError(code, message, details string) *apiError {
+	return &apiError{Code: code, Error: message, Details: details}
+}
+
+// filterFromValues builds a LogFilter from query parameters, returning the
+// error to report for invalid input. Besides the HTTP handlers it is used for
+// filters embedded as query strings in the config file.
+func (s *server) filterFromValues(query url.Values) (models.LogFilter, *apiError) {
+	filter := models.LogFilter{
+		Service: query.Get("service"),
+		Level:   query.Get("level"),
+		Host:    query.Get("host"),
+		Search:  query.Get("search"),
+		Tag:     query.Get("tag"),
+		Stream:  query.Get("stream"),
+	}
+
+	// Negated filters arrive as service!=healthcheck, i.e. parameter "service!"
+	filter.ExcludeServices = nonEmpty(query["service!"])
+	filter.ExcludeLevels = nonEmpty(query["level!"])
+	// Stored levels are normalized when configured, so normalize queried ones
+	filter.Level = s.levels.normalize(filter.Level)
+	for i, level := range filter.ExcludeLevels {
+		filter.ExcludeLevels[i] = s.levels.normalize(level)
+	}
+	filter.ExcludeHosts = nonEmpty(query["host!"])
+	filter.ExcludeSearch = nonEmpty(query["exclude"])
+	if apiErr := s.levelFilter(&filter, query); apiErr != nil {
+		return filter, apiErr
+	}
+	if name := query.Get("host_group"); name != "" {
+		if apiErr := s.hostGroupFilter(&filter, name); apiErr != nil {
+			return filter, apiErr
+		}
+	}
+
+	if v := query.Get("search_regex"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, filterError("invalid_parameter", "Invalid search_regex value",
+				fmt.Sprintf("'search_regex' must be true or false, got: %s", v))
+		}
+		filter.SearchRegex = b
+	}
+	if pattern, set := query["regex"]; set {
+		if filter.Search != "" {
+			return filter, filterError("invalid_parameter", "Conflicting search parameters",
+				"use either 'regex' or 'search', not both")
+		}
+		filter.Search, filter.SearchRegex = pattern[0], true
+	}
+	if filter.SearchRegex {
+		if len(filter.Search) > maxSearchRegexLength {
+			return filter, filterError("invalid_search_regex", "Invalid regular expression",
+				fmt.Sprintf("the pattern is longer than %d bytes", maxSearchRegexLength))
+		}
+		if _, err := regexp.Compile(filter.Search); err != nil {
+			return filter, filterError("invalid_search_regex", "Invalid regular expression", err.Error())
+		}
+	}
+
+	if filter.Tag != "" && !tagPattern.MatchString(filter.Tag) {
+		return filter, filterError("invalid_tag", "Invalid tag",
+			fmt.Sprintf("'tag' must be 1-64 letters, digits or _ . : -, got: %q", filter.Tag))
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			slog.Warn("invalid limit", "limit", limitStr, "error", err)
+			return filter, filterError("invalid_limit",
+				"Invalid limit value",
+				fmt.Sprintf("'limit' must be a positive integer, got: %s", limitStr))
+		}
+		if limit < 0 {
+			slog.Warn("negative limit", "limit", limit)
+			return filter, filterError("invalid_limit",
+				"Invalid limit value", "limit must not be negative")
+		}
+		filter.Limit = limit
+	}
+
+	if v := query.Get("max_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 0 {
+			return filter, filterError("invalid_parameter", "Invalid max_id value",
+				fmt.Sprintf("'max_id' must be a non-negative integer, got: %s", v))
+		}
+		filter.MaxID = id
+	}
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := parseCursor(v)
+		if err != nil {
+			return filter, filterError("invalid_parameter", "Invalid cursor value",
+				fmt.Sprintf("'cursor' must be a %s header value, got: %s", nextCursorHeader, v))
+		}
+		filter.Cursor = cursor
+	}
+
+	if start := query.Get("start"); start != "" {
+		t, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			slog.Warn("invalid start date", "start", start, "error", err)
+			return filter, filterError("invalid_date",
+				"Invalid start date format",
+				fmt.Sprintf("'start' must be RFC3339 (e.g. 2025-01-15T00:00:00Z), got: %s", start))
+		}
+		filter.StartTime = &t
+	}
+
+	if end := query.Get("end"); end != "" {
+		t, err := time.Parse(time.RFC3339, end)
+		if err != nil {
+			slog.Warn("invalid end date", "end", end, "error", err)
+			return filter, filterError("invalid_date",
+				"Invalid end date format",
+				fmt.Sprintf("'end' must be RFC3339 (e.g. 2025-01-15T23:59:59Z), got: %s", end))
+		}
+		filter.EndTime = &t
+	}
+
+	for _, expr := range query["meta"] {
+		m, err := parseMetaFilter(expr)
+		if err != nil {
+			slog.Warn("invalid metadata filter", "meta", expr, "error", err)
+			return filter, filterError("invalid_meta_filter",
+				"Invalid metadata filter", err.Error())
+		}
+		filter.Meta = append(filter.Meta, m)
+	}
+
+	for _, expr := range query["derived"] {
+		m, err := parseMetaFilter(expr)
+		if err == nil && !s.hasDerivedField(m.Key) {
+			err = fmt.Errorf("unknown derived field %q", m.Key)
+		}
+		if err != nil {
+			slog.Warn("invalid derived field filter", "derived", expr, "error", err)
+			return filter, filterError("invalid_derived_filter",
+				"Invalid derived field filter", err.Error())
+		}
+		m.Derived = true
+		filter.Meta = append(filter.Meta, m)
+	}
+
+	if q := query.Get("q"); q != "" {
+		if apiErr := s.applyQuery(&filter, q); apiErr != nil {
+			return filter, apiErr
+		}
+	}
+
+	if filter.StartTime != nil && filter.EndTime != nil && filter.StartTime.After(*filter.EndTime) {
+		slog.Warn("start date after end date",
+			"start", filter.StartTime.Format(time.RFC3339),
+			"end", filter.EndTime.Format(time.RFC3339))
+		return filter, filterError("date_range_invalid",
+			"Start date must be before end date",
+			fmt.Sprintf("start (%s) is after end (%s)",
+				filter.StartTime.Format(time.RFC3339), filter.EndTime.Format(time.RFC3339)))
+	}
+
+	return filter, nil
+}
+
+// Headers of paginated reads
+const (
+	// nextCursorHeader carries the cursor= continuing a truncated result
+	// after its last log.
+	nextCursorHeader = "X-Locog-Next-Cursor"
+	// snapshotIDHeader carries the max_id pinning a read with snapshot=true.
+	snapshotIDHeader = "X-Locog-Snapshot-ID"
+)
+
+// formatCursor returns the cursor= of the page after l, its timestamp in
+// Unix nanoseconds and its ID, e.g. "1736935800000000000_42".
+func formatCursor(l models.Log) string {
+	return strconv.FormatInt(l.Timestamp.UnixNano(), 10) + "_" + strconv.FormatInt(l.ID, 10)
+}
+
+// parseCursor parses a cursor written by formatCursor.
+func parseCursor(v string) (*models.LogCursor, error) {
+	ts, id, ok := strings.Cut(v, "_")
+	if !ok {
+		return nil, fmt.Errorf("missing ID")
+	}
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	c := &models.LogCursor{Timestamp: time.Unix(0, nanos).UTC()}
+	if c.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// pinSnapshot handles snapshot=true, which pins filter to the logs stored
+// now unless max_id already pins it. Logs ingested while the result is read,
+// page after page or during a long export, then neither shift pages nor
+// join the result. The boundary is reported in snapshotIDHeader for later
+// pages to pass as max_id. On error it writes the response and returns false.
+func (s *server) pinSnapshot(w http.ResponseWriter, r *http.Request, filter *models.LogFilter) bool {
+	v := r.URL.Query().Get("snapshot")
+	if v == "" {
+		return true
+	}
+	pin, err := strconv.ParseBool(v)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid snapshot value",
+			fmt.Sprintf("'snapshot' must be true or false, got: %s", v))
+		return false
+	}
+	if !pin {
+		return true
+	}
+	if filter.MaxID == 0 {
+		if filter.MaxID, err = s.db.MaxLogID(r.Context()); err != nil {
+			if clientGone(w, r) {
+				return false
+			}
+			slog.Error("failed to read the snapshot boundary", "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "query_failed",
+				"Query failed", "An internal error occurred while querying logs")
+			return false
+		}
+	}
+	w.Header().Set(snapshotIDHeader, strconv.FormatInt(filter.MaxID, 10))
+	return true
+}
+
+// nonEmpty returns values without empty strings, or nil.
+func nonEmpty(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// maxSearchRegexLength bounds regular expression searches.
+const maxSearchRegexLength = 512
+
+// searchContext bounds the queries of a request searching by regular
+// expression, which can't use an index, by s.regexTimeout.
+func (s *server) searchContext(r *http.Request, filter models.LogFilter) (context.Context, context.CancelFunc) {
+	if filter.SearchRegex && filter.Search != "" && s.regexTimeout > 0 {
+		return context.WithTimeout(r.Context(), s.regexTimeout)
+	}
+	return r.Context(), func() {}
+}
+
+// writeSearchTimeout reports a regular expression search that ran past
+// s.regexTimeout.
+func (s *server) writeSearchTimeout(w http.ResponseWriter) {
+	writeJSONError(w, http.StatusGatewayTimeout, "search_timeout", "Search timed out",
+		fmt.Sprintf("the regular expression search took longer than %s; narrow the time range or add filters", s.regexTimeout))
+}
+
+// statusClientClosedRequest is recorded, as by nginx, for requests whose
+// client disconnected before the response was written.
+const statusClientClosedRequest = 499
+
+// clientGone reports whether r's client closed the connection, which
+// cancels r's context and with it the queries serving it. The cancelled
+// query isn't a failure, so handlers stop without logging or reporting one.
+func clientGone(w http.ResponseWriter, r *http.Request) bool {
+	if !errors.Is(r.Context().Err(), context.Canceled) {
+		return false
+	}
+	slog.Debug("client disconnected, query cancelled", "path", r.URL.Path)
+	w.WriteHeader(statusClientClosedRequest)
+	return true
+}
+
+// hasDerivedField reports whether a derived field with the given name is configured.
+func (s *server) hasDerivedField(name string) bool {
+	for _, f := range s.db.DerivedFields() {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// tagPattern restricts log tags, e.g. incident-142.
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9_.:\-]{1,64}$`)
+
+// metaKeyPattern restricts metadata keys in filters to dotted identifiers.
+var metaKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_\-]+(\.[A-Za-z0-9_\-]+)*$`)
+
+// parseMetaFilter parses a metadata filter expression such as
+// "duration_ms>500", "meta.status_code>=500" or "region=eu".
+func parseMetaFilter(expr string) (models.MetaFilter, error) {
+	expr = strings.TrimSpace(expr)
+
+	opStart, opEnd := -1, -1
+	for i := 0; i < len(expr); i++ {
+		switch expr[i] {
+		case '!', '<', '>', '=':
+			opStart, opEnd = i, i+1
+			if i+1 < len(expr) && expr[i+1] == '=' {
+				opEnd = i + 2
+			}
+		}
+		if opStart != -1 {
+			break
+		}
+	}
+	if opStart <= 0 {
+		return models.MetaFilter{}, fmt.Errorf("expected <key><op><value> with op one of =, !=, >, >=, <, <=, got: %s", expr)
+	}
+
+	key := strings.TrimPrefix(strings.TrimSpace(expr[:opStart]), "meta.")
+	op := expr[opStart:opEnd]
+	value := strings.TrimSpace(expr[opEnd:])
+
+	if op == "!" {
+		return models.MetaFilter{}, fmt.Errorf("invalid operator '!' in: %s", expr)
+	}
+	if !metaKeyPattern.MatchString(key) {
+		return models.MetaFilter{}, fmt.Errorf("invalid metadata key %q", key)
+	}
+	if value == "" {
+		return models.MetaFilter{}, fmt.Errorf("missing value in: %s", expr)
+	}
+
+	f := models.MetaFilter{Key: key, Op: op, Value: value}
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		f.Numeric = true
+		f.Number = n
+	} else if op != "=" && op != "!=" {
+		return models.MetaFilter{}, fmt.Errorf("operator %s requires a numeric value, got: %s", op, value)
+	}
+	return f, nil
+}
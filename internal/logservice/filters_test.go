@@ -0,0 +1,124 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestParseMetaFilter tests parsing of metadata filter expressions.
+func TestParseMetaFilter(t *testing.T) {
+	tests := []struct {
+		expr    string
+		key     string
+		op      string
+		numeric bool
+		number  float64
+		wantErr bool
+	}{
+		{expr: "duration_ms>500", key: "duration_ms", op: ">", numeric: true, number: 500},
+		{expr: "meta.status_code>=500", key: "status_code", op: ">=", numeric: true, number: 500},
+		{expr: "latency<=0.25", key: "latency", op: "<=", numeric: true, number: 0.25},
+		{expr: "http.status!=200", key: "http.status", op: "!=", numeric: true, number: 200},
+		{expr: "region=eu", key: "region", op: "=", numeric: false},
+		{expr: "region!=eu", key: "region", op: "!=", numeric: false},
+		{expr: "duration_ms>slow", wantErr: true},
+		{expr: ">500", wantErr: true},
+		{expr: "duration_ms", wantErr: true},
+		{expr: "duration_ms>", wantErr: true},
+		{expr: "bad key>1", wantErr: true},
+		{expr: "x!1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := parseMetaFilter(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMetaFilter(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if f.Key != tt.key || f.Op != tt.op || f.Numeric != tt.numeric || f.Number != tt.number {
+				t.Errorf("parseMetaFilter(%q) = %+v", tt.expr, f)
+			}
+		})
+	}
+}
+
+// TestHandleQueryLogs_MetaFilter tests numeric metadata filters on the query API.
+func TestHandleQueryLogs_MetaFilter(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, d := range []interface{}{120, 800, "950", "n/a"} {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "info",
+			Message: "request", Host: "h", Metadata: map[string]interface{}{"duration_ms": d}})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/logs?meta=duration_ms>500", nil)
+	rr := httptest.NewRecorder()
+	srv.handleQueryLogs(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var logs []models.Log
+	json.NewDecoder(rr.Body).Decode(&logs)
+	if len(logs) != 2 {
+		t.Errorf("expected 2 logs with duration_ms > 500, got %d", len(logs))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/logs?meta=duration_ms>fast", nil)
+	rr = httptest.NewRecorder()
+	srv.handleQueryLogs(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for invalid filter, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// TestHandleQueryLogs_MinLevel tests filtering by minimum severity, including
+// level aliases.
+func TestHandleQueryLogs_MinLevel(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, level := range []string{"debug", "INFO", "warning", "WARN", "err", "error", "fatal", "audit"} {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: level,
+			Message: "m", Host: "h"})
+	}
+
+	tests := []struct {
+		minLevel string
+		want     int
+	}{
+		{"warn", 5},
+		{"WARNING", 5},
+		{"err", 3},
+		{"fatal", 1},
+		{"trace", 7}, // unknown levels have no severity
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/logs?min_level="+tt.minLevel, nil)
+		rr := httptest.NewRecorder()
+		srv.handleQueryLogs(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("min_level=%s: expected status %d, got %d: %s", tt.minLevel, http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var logs []models.Log
+		json.NewDecoder(rr.Body).Decode(&logs)
+		if len(logs) != tt.want {
+			t.Errorf("min_level=%s: expected %d logs, got %d", tt.minLevel, tt.want, len(logs))
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/logs?min_level=loud", nil)
+	rr := httptest.NewRecorder()
+	srv.handleQueryLogs(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for unknown level, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
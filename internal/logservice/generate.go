@@ -0,0 +1,273 @@
+package logservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"locog/internal/models"
+)
+
+const (
+	// generateTick is how often a batch of synthetic logs is stored
+	generateTick = 100 * time.Millisecond
+	// maxGenerateRate and maxGenerateDuration bound a single run
+	maxGenerateRate     = 10000
+	maxGenerateDuration = time.Hour
+)
+
+// generator produces synthetic logs through the normal ingest pipeline for
+// demos, UI work and testing alert rules. One run is active at a time.
+type generator struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	run    *generateRun
+	// closed is set by close, after which no run starts
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// generateRun describes an active run.
+type generateRun struct {
+	Service  string    `json:"service"`
+	Rate     int       `json:"rate"`
+	Duration string    `json:"duration"`
+	Started  time.Time `json:"started"`
+	Expected int64     `json:"expected"`
+}
+
+// syntheticMessages are message templates; %d is replaced with a number.
+var syntheticMessages = []struct {
+	level   string
+	weight  int
+	message string
+}{
+	{"DEBUG", 15, "cache lookup for key user:%d"},
+	{"INFO", 40, "request completed in %dms"},
+	{"INFO", 20, "user %d logged in"},
+	{"INFO", 10, "processed batch of %d items"},
+	{"WARN", 10, "slow query took %dms"},
+	{"ERROR", 4, "upstream returned status %d"},
+	{"ERROR", 1, "failed to connect to database after %d retries"},
+}
+
+var syntheticHosts = []string{"demo-1", "demo-2", "demo-3"}
+
+// syntheticLog returns one random log for service.
+func syntheticLog(service string, rng *rand.Rand) models.Log {
+	total := 0
+	for _, m := range syntheticMessages {
+		total += m.weight
+	}
+	pick := rng.IntN(total)
+	tmpl := syntheticMessages[0]
+	for _, m := range syntheticMessages {
+		if pick < m.weight {
+			tmpl = m
+			break
+		}
+		pick -= m.weight
+	}
+
+	status := 200
+	switch tmpl.level {
+	case "WARN":
+		status = 429
+	case "ERROR":
+		status = 500 + rng.IntN(4)
+	}
+	return models.Log{
+		Service: service,
+		Level:   tmpl.level,
+		Message: fmt.Sprintf(tmpl.message, 1+rng.IntN(999)),
+		Host:    syntheticHosts[rng.IntN(len(syntheticHosts))],
+		Metadata: map[string]interface{}{
+			"synthetic":   true,
+			"duration_ms": rng.IntN(2000),
+			"status_code": status,
+			"user_id":     1 + rng.IntN(500),
+		},
+	}
+}
+
+// start begins a run unless one is already active, returning the active
+// one, or the generator is closed, returning nil.
+func (g *generator) start(s *server, service string, rate int, duration time.Duration) (*generateRun, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.run != nil || g.closed {
+		return g.run, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	run := &generateRun{
+		Service:  service,
+		Rate:     rate,
+		Duration: duration.String(),
+		Started:  time.Now(),
+		Expected: int64(float64(rate) * duration.Seconds()),
+	}
+	g.cancel, g.run = cancel, run
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer g.finish(run)
+		generated := s.generateLogs(ctx, service, rate)
+		slog.Info("synthetic log generation finished", "service", service, "logs", generated)
+	}()
+	return run, true
+}
+
+// finish clears the run if it is still the active one.
+func (g *generator) finish(run *generateRun) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.run == run {
+		g.cancel()
+		g.cancel, g.run = nil, nil
+	}
+}
+
+// close stops the active run and waits for it to return, so no logs are
+// stored once the service shuts down.
+func (g *generator) close() {
+	g.mu.Lock()
+	g.closed = true
+	if g.run != nil {
+		g.cancel()
+	}
+	g.mu.Unlock()
+	g.wg.Wait()
+}
+
+// active reports whether a run is in progress.
+func (g *generator) active() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.run != nil
+}
+
+// stop cancels the active run, reporting whether there was one.
+func (g *generator) stop() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.run == nil {
+		return false
+	}
+	g.cancel()
+	return true
+}
+
+// generateLogs stores rate logs per second until ctx is done and returns how
+// many were stored.
+func (s *server) generateLogs(ctx context.Context, service string, rate int) int64 {
+	rng := rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), 0))
+	ticker := time.NewTicker(generateTick)
+	defer ticker.Stop()
+
+	var generated int64
+	var owed float64 // fractional logs carried between ticks
+	perTick := float64(rate) * generateTick.Seconds()
+	for {
+		select {
+		case <-ctx.Done():
+			return generated
+		case <-ticker.C:
+		}
+
+		owed += perTick
+		n := int(owed)
+		owed -= float64(n)
+		if n == 0 {
+			continue
+		}
+
+		logs := make([]models.Log, n)
+		for i := range logs {
+			logs[i] = syntheticLog(service, rng)
+		}
+		if err := s.storeLogs(ctx, logs, "generator"); err != nil {
+			if ctx.Err() == nil {
+				slog.Error("synthetic log generation failed", "error", err)
+			}
+			return generated
+		}
+		generated += int64(n)
+	}
+}
+
+// handleGenerate starts (POST) or stops (DELETE) synthetic log generation,
+// e.g. POST /api/admin/generate?rate=100&duration=60s&service=demo. It is
+// only available when the service runs with -enable-generator.
+func (s *server) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	if s.generator == nil {
+		writeJSONError(w, http.StatusNotFound, "generator_disabled",
+			"Synthetic log generation is disabled", "Start the service with -enable-generator")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodDelete:
+		if !s.generator.stop() {
+			writeJSONError(w, http.StatusNotFound, "not_running", "No generation is running", "")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	service := query.Get("service")
+	if service == "" {
+		service = "demo"
+	}
+
+	rate := 100
+	if v := query.Get("rate"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxGenerateRate {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid rate value",
+				fmt.Sprintf("'rate' must be logs per second between 1 and %d, got: %s", maxGenerateRate, v))
+			return
+		}
+		rate = n
+	}
+
+	duration := time.Minute
+	if v := query.Get("duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxGenerateDuration {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid duration value",
+				fmt.Sprintf("'duration' must be a positive duration up to %s, got: %s", maxGenerateDuration, v))
+			return
+		}
+		duration = d
+	}
+
+	run, started := s.generator.start(s, service, rate, duration)
+	if run == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "shutting_down", "The service is shutting down", "")
+		return
+	}
+	if !started {
+		writeJSONError(w, http.StatusConflict, "already_running", "Generation is already running",
+			fmt.Sprintf("Generating %d logs/s for service %s; DELETE to stop it", run.Rate, run.Service))
+		return
+	}
+	slog.Info("synthetic log generation started", "service", service, "rate", rate, "duration", duration)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
+}
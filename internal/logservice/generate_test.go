@@ -0,0 +1,114 @@
+package logservice
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestHandleGenerate_Disabled tests that the endpoint is off by default.
+func TestHandleGenerate_Disabled(t *testing.T) {
+	srv := newTestServer(t)
+	rr := httptest.NewRecorder()
+	srv.handleGenerate(rr, httptest.NewRequest(http.MethodPost, "/api/admin/generate", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// TestHandleGenerate_Validation tests parameter bounds.
+func TestHandleGenerate_Validation(t *testing.T) {
+	srv := newTestServer(t)
+	srv.generator = &generator{}
+
+	for _, query := range []string{"rate=0", "rate=abc", "rate=100000", "duration=-1s", "duration=2h", "duration=soon"} {
+		rr := httptest.NewRecorder()
+		srv.handleGenerate(rr, httptest.NewRequest(http.MethodPost, "/api/admin/generate?"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
+
+// TestHandleGenerate_Run tests that a run stores logs through the pipeline,
+// rejects a concurrent run and can be stopped.
+func TestHandleGenerate_Run(t *testing.T) {
+	srv := newTestServer(t)
+	srv.generator = &generator{}
+	srv.labels = map[string]string{"env": "demo"}
+
+	rr := httptest.NewRecorder()
+	srv.handleGenerate(rr, httptest.NewRequest(http.MethodPost, "/api/admin/generate?rate=100&duration=10s&service=demo", nil))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	srv.handleGenerate(rr, httptest.NewRequest(http.MethodPost, "/api/admin/generate", nil))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected status %d for concurrent run, got %d", http.StatusConflict, rr.Code)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(queryAll(t, srv)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.handleGenerate(rr, httptest.NewRequest(http.MethodDelete, "/api/admin/generate", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status %d on stop, got %d", http.StatusNoContent, rr.Code)
+	}
+	for srv.generator.active() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	logs := queryAll(t, srv)
+	if len(logs) == 0 {
+		t.Fatal("expected synthetic logs to be stored")
+	}
+	l := logs[0]
+	if l.Service != "demo" || l.Metadata["synthetic"] != true || l.Metadata["env"] != "demo" {
+		t.Errorf("unexpected log: %+v", l)
+	}
+}
+
+// TestGenerator_Close tests that closing the generator stops the active run
+// before returning and refuses new ones.
+func TestGenerator_Close(t *testing.T) {
+	srv := newTestServer(t)
+	srv.generator = &generator{}
+	if _, started := srv.generator.start(srv, "demo", 100, time.Hour); !started {
+		t.Fatal("expected the run to start")
+	}
+	srv.generator.close()
+	if srv.generator.active() {
+		t.Error("expected the run stopped once close returns")
+	}
+
+	rr := httptest.NewRecorder()
+	srv.handleGenerate(rr, httptest.NewRequest(http.MethodPost, "/api/admin/generate", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d after close, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
+
+// TestSyntheticLog tests that generated logs are valid.
+func TestSyntheticLog(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	levels := make(map[string]bool)
+	for range 1000 {
+		l := syntheticLog("demo", rng)
+		if err := validateLog(&l); err != nil {
+			t.Fatalf("invalid synthetic log %+v: %v", l, err)
+		}
+		levels[l.Level] = true
+	}
+	for _, level := range []string{"DEBUG", "INFO", "WARN", "ERROR"} {
+		if !levels[level] {
+			t.Errorf("expected some %s logs", level)
+		}
+	}
+}
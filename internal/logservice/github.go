@@ -0,0 +1,279 @@
+package logservice
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+)
+
+// ciService is the service name of logs created from CI webhooks.
+const ciService = "ci"
+
+// githubWorkflowJobEvent is the subset of a workflow_job webhook payload
+// turned into a log.
+type githubWorkflowJobEvent struct {
+	Action      string `json:"action"` // queued, waiting, in_progress, completed
+	WorkflowJob struct {
+		ID           int64      `json:"id"`
+		RunID        int64      `json:"run_id"`
+		RunAttempt   int        `json:"run_attempt"`
+		Name         string     `json:"name"`
+		WorkflowName string     `json:"workflow_name"`
+		HeadBranch   string     `json:"head_branch"`
+		HeadSHA      string     `json:"head_sha"`
+		Status       string     `json:"status"`
+		Conclusion   string     `json:"conclusion"`
+		HTMLURL      string     `json:"html_url"`
+		CreatedAt    time.Time  `json:"created_at"`
+		StartedAt    *time.Time `json:"started_at"`
+		CompletedAt  *time.Time `json:"completed_at"`
+		RunnerName   string     `json:"runner_name"`
+		Labels       []string   `json:"labels"`
+		Steps        []struct {
+			Name       string `json:"name"`
+			Number     int    `json:"number"`
+			Conclusion string `json:"conclusion"`
+		} `json:"steps"`
+	} `json:"workflow_job"`
+	Repository githubRepository `json:"repository"`
+	Sender     githubUser       `json:"sender"`
+}
+
+// githubWorkflowRunEvent is the subset of a workflow_run webhook payload
+// turned into a log.
+type githubWorkflowRunEvent struct {
+	Action      string `json:"action"` // requested, in_progress, completed
+	WorkflowRun struct {
+		ID         int64      `json:"id"`
+		Name       string     `json:"name"`
+		RunNumber  int64      `json:"run_number"`
+		RunAttempt int        `json:"run_attempt"`
+		Event      string     `json:"event"`
+		HeadBranch string     `json:"head_branch"`
+		HeadSHA    string     `json:"head_sha"`
+		Status     string     `json:"status"`
+		Conclusion string     `json:"conclusion"`
+		HTMLURL    string     `json:"html_url"`
+		CreatedAt  time.Time  `json:"created_at"`
+		UpdatedAt  time.Time  `json:"updated_at"`
+		Actor      githubUser `json:"actor"`
+	} `json:"workflow_run"`
+	Repository githubRepository `json:"repository"`
+	Sender     githubUser       `json:"sender"`
+}
+
+type githubRepository struct {
+	FullName string `json:"full_name"`
+}
+
+type githubUser struct {
+	Login string `json:"login"`
+}
+
+// ciLevel maps a GitHub conclusion to a log level so failures stand out.
+func ciLevel(conclusion string) string {
+	switch conclusion {
+	case "failure", "timed_out", "startup_failure":
+		return "ERROR"
+	case "cancelled", "action_required", "stale":
+		return "WARN"
+	}
+	return "INFO"
+}
+
+// validGitHubSignature checks the X-Hub-Signature-256 header, an HMAC-SHA256
+// of the body keyed with the webhook secret.
+func validGitHubSignature(secret string, body []byte, header string) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// handleGitHubWebhook converts GitHub Actions webhooks (workflow_job and
+// workflow_run events) into logs with service=ci. When a webhook secret is
+// configured, deliveries must carry a valid signature. Other events are
+// acknowledged and ignored.
+func (s *server) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ip := getClientIP(r)
+	if limited := s.checkRateLimit(endpointGitHub, ip); limited != nil {
+		writeRateLimited(w, limited)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read body or body too large", http.StatusBadRequest)
+		return
+	}
+
+	if s.githubSecret != "" && !validGitHubSignature(s.githubSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+		slog.Warn("rejected GitHub webhook with invalid signature", "sender", ip)
+		writeJSONError(w, http.StatusUnauthorized, "invalid_signature",
+			"Invalid webhook signature", "X-Hub-Signature-256 must match the configured webhook secret")
+		return
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	var l models.Log
+	switch event {
+	case "workflow_job":
+		l, err = workflowJobLog(body)
+	case "workflow_run":
+		l, err = workflowRunLog(body)
+	default:
+		// ping and events unrelated to CI
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		slog.Warn("failed to decode GitHub webhook", "sender", ip, "event", event, "reason", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	l.Metadata["event"] = event
+	if delivery := r.Header.Get("X-GitHub-Delivery"); delivery != "" {
+		l.Metadata["delivery_id"] = delivery
+	}
+
+	logs := []models.Log{l}
+	s.nameHosts(r, logs)
+	refund, limited := s.checkQuotas(r, logs)
+	if limited != nil {
+		writeRateLimited(w, limited)
+		return
+	}
+	if err := s.storeLogs(r.Context(), logs, ip); err != nil {
+		refund()
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func workflowJobLog(body []byte) (models.Log, error) {
+	var ev githubWorkflowJobEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return models.Log{}, fmt.Errorf("invalid JSON")
+	}
+	job := ev.WorkflowJob
+
+	state := ev.Action
+	timestamp := job.CreatedAt
+	switch {
+	case ev.Action == "completed" && job.CompletedAt != nil:
+		state = "completed with " + job.Conclusion
+		timestamp = *job.CompletedAt
+	case ev.Action == "in_progress" && job.StartedAt != nil:
+		timestamp = *job.StartedAt
+	}
+
+	metadata := map[string]interface{}{
+		"repository":  ev.Repository.FullName,
+		"workflow":    job.WorkflowName,
+		"job":         job.Name,
+		"job_id":      job.ID,
+		"run_id":      job.RunID,
+		"run_attempt": job.RunAttempt,
+		"branch":      job.HeadBranch,
+		"sha":         job.HeadSHA,
+		"action":      ev.Action,
+		"status":      job.Status,
+		"url":         job.HTMLURL,
+		"sender":      ev.Sender.Login,
+	}
+	if job.Conclusion != "" {
+		metadata["conclusion"] = job.Conclusion
+	}
+	if len(job.Labels) > 0 {
+		metadata["runner_labels"] = job.Labels
+	}
+	var failed []string
+	for _, step := range job.Steps {
+		if step.Conclusion == "failure" {
+			failed = append(failed, step.Name)
+		}
+	}
+	if len(failed) > 0 {
+		metadata["failed_steps"] = failed
+	}
+
+	host := job.RunnerName
+	if host == "" {
+		host = "github"
+	}
+	return models.Log{
+		Timestamp: timestamp,
+		Service:   ciService,
+		Level:     ciLevel(job.Conclusion),
+		Message:   fmt.Sprintf("Job %q %s (%s / %s on %s)", job.Name, state, ev.Repository.FullName, job.WorkflowName, job.HeadBranch),
+		Metadata:  metadata,
+		Host:      host,
+	}, nil
+}
+
+func workflowRunLog(body []byte) (models.Log, error) {
+	var ev githubWorkflowRunEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return models.Log{}, fmt.Errorf("invalid JSON")
+	}
+	run := ev.WorkflowRun
+
+	state := ev.Action
+	timestamp := run.CreatedAt
+	if ev.Action == "completed" {
+		state = "completed with " + run.Conclusion
+		timestamp = run.UpdatedAt
+	}
+
+	metadata := map[string]interface{}{
+		"repository":    ev.Repository.FullName,
+		"workflow":      run.Name,
+		"run_id":        run.ID,
+		"run_number":    run.RunNumber,
+		"run_attempt":   run.RunAttempt,
+		"trigger_event": run.Event,
+		"branch":        run.HeadBranch,
+		"sha":           run.HeadSHA,
+		"action":        ev.Action,
+		"status":        run.Status,
+		"url":           run.HTMLURL,
+		"actor":         run.Actor.Login,
+		"sender":        ev.Sender.Login,
+	}
+	if run.Conclusion != "" {
+		metadata["conclusion"] = run.Conclusion
+	}
+
+	return models.Log{
+		Timestamp: timestamp,
+		Service:   ciService,
+		Level:     ciLevel(run.Conclusion),
+		Message:   fmt.Sprintf("Workflow %q #%d %s (%s on %s)", run.Name, run.RunNumber, state, ev.Repository.FullName, run.HeadBranch),
+		Metadata:  metadata,
+		Host:      "github",
+	}, nil
+}
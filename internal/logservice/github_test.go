@@ -0,0 +1,128 @@
+package logservice
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const workflowJobPayload = `{
+  "action": "completed",
+  "workflow_job": {
+    "id": 29679449, "run_id": 10420906, "run_attempt": 1,
+    "name": "test", "workflow_name": "CI",
+    "head_branch": "main", "head_sha": "f83a356",
+    "status": "completed", "conclusion": "failure",
+    "html_url": "https://github.com/acme/shop/actions/runs/10420906/job/29679449",
+    "created_at": "2025-01-15T12:00:00Z",
+    "started_at": "2025-01-15T12:00:05Z",
+    "completed_at": "2025-01-15T12:03:00Z",
+    "runner_name": "runner-7", "labels": ["ubuntu-latest"],
+    "steps": [
+      {"name": "Checkout", "number": 1, "conclusion": "success"},
+      {"name": "Run tests", "number": 2, "conclusion": "failure"}
+    ]
+  },
+  "repository": {"full_name": "acme/shop"},
+  "sender": {"login": "octocat"}
+}`
+
+func signGitHub(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func githubRequest(event, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/ingest/github", strings.NewReader(body))
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-GitHub-Delivery", "72d3162e")
+	return req
+}
+
+// TestHandleGitHubWebhook_WorkflowJob tests that a failed job becomes an ERROR log.
+func TestHandleGitHubWebhook_WorkflowJob(t *testing.T) {
+	srv := newTestServer(t)
+	rr := httptest.NewRecorder()
+	srv.handleGitHubWebhook(rr, githubRequest("workflow_job", workflowJobPayload))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	logs := queryAll(t, srv)
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(logs))
+	}
+	l := logs[0]
+	if l.Service != "ci" || l.Level != "ERROR" || l.Host != "runner-7" {
+		t.Errorf("unexpected log: %+v", l)
+	}
+	if !strings.Contains(l.Message, `Job "test" completed with failure`) {
+		t.Errorf("unexpected message: %q", l.Message)
+	}
+	if l.Timestamp.Format("15:04:05") != "12:03:00" {
+		t.Errorf("expected completion time, got %v", l.Timestamp)
+	}
+	if l.Metadata["repository"] != "acme/shop" || l.Metadata["delivery_id"] != "72d3162e" {
+		t.Errorf("unexpected metadata: %v", l.Metadata)
+	}
+	steps, _ := l.Metadata["failed_steps"].([]interface{})
+	if len(steps) != 1 || steps[0] != "Run tests" {
+		t.Errorf("expected failed step, got %v", l.Metadata["failed_steps"])
+	}
+}
+
+// TestHandleGitHubWebhook_WorkflowRun tests workflow run events.
+func TestHandleGitHubWebhook_WorkflowRun(t *testing.T) {
+	srv := newTestServer(t)
+	body := `{"action": "completed", "workflow_run": {"id": 1, "name": "Deploy", "run_number": 42,
+		"head_branch": "main", "conclusion": "success", "updated_at": "2025-01-15T12:05:00Z"},
+		"repository": {"full_name": "acme/shop"}}`
+	rr := httptest.NewRecorder()
+	srv.handleGitHubWebhook(rr, githubRequest("workflow_run", body))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, rr.Code)
+	}
+	logs := queryAll(t, srv)
+	if len(logs) != 1 || logs[0].Level != "INFO" || !strings.Contains(logs[0].Message, `"Deploy" #42 completed with success`) {
+		t.Errorf("unexpected logs: %+v", logs)
+	}
+}
+
+// TestHandleGitHubWebhook_Signature tests signature verification and ignored events.
+func TestHandleGitHubWebhook_Signature(t *testing.T) {
+	srv := newTestServer(t)
+	srv.githubSecret = "hook-secret"
+
+	req := githubRequest("workflow_job", workflowJobPayload)
+	req.Header.Set("X-Hub-Signature-256", signGitHub("wrong", workflowJobPayload))
+	rr := httptest.NewRecorder()
+	srv.handleGitHubWebhook(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d for bad signature, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	req = githubRequest("workflow_job", workflowJobPayload)
+	req.Header.Set("X-Hub-Signature-256", signGitHub("hook-secret", workflowJobPayload))
+	rr = httptest.NewRecorder()
+	srv.handleGitHubWebhook(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected status %d for valid signature, got %d", http.StatusCreated, rr.Code)
+	}
+
+	req = githubRequest("ping", `{"zen": "Keep it logically awesome."}`)
+	req.Header.Set("X-Hub-Signature-256", signGitHub("hook-secret", `{"zen": "Keep it logically awesome."}`))
+	rr = httptest.NewRecorder()
+	srv.handleGitHubWebhook(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("expected status %d for ping, got %d", http.StatusAccepted, rr.Code)
+	}
+	if logs := queryAll(t, srv); len(logs) != 1 {
+		t.Errorf("expected only the signed job to be stored, got %d logs", len(logs))
+	}
+}
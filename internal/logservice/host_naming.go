@@ -0,0 +1,231 @@
+package logservice
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"locog/internal/models"
+)
+
+// hostNamingConfig names the host of logs ingested without one, so they
+// don't all collect under an empty host.
+type hostNamingConfig struct {
+	// APIKeys maps a name to a key senders present as X-Api-Key or a bearer
+	// token, e.g. {"edge-proxy": "k3y..."}, for the api_key method.
+	APIKeys map[string]string `json:"api_keys"`
+
+	// Sources choose the methods per service; the first matching entry
+	// applies.
+	Sources []hostSourceConfig `json:"sources"`
+}
+
+type hostSourceConfig struct {
+	Service string `json:"service"` // glob, e.g. "legacy-*"; empty matches every service
+
+	// From lists the methods tried in order until one yields a name: "ip"
+	// (the sender's address), "reverse_dns" (its PTR record), "api_key" (the
+	// name of the key presented) or "header:<name>" (a request header).
+	From []string `json:"from"`
+}
+
+const (
+	hostFromIP         = "ip"
+	hostFromReverseDNS = "reverse_dns"
+	hostFromAPIKey     = "api_key"
+	hostFromHeader     = "header:"
+)
+
+const (
+	// reverseDNSTimeout bounds each PTR lookup, which blocks ingest.
+	reverseDNSTimeout = 2 * time.Second
+	// reverseDNSTTL is how long answers, missing names included, are cached.
+	reverseDNSTTL = 10 * time.Minute
+	// reverseDNSCacheSize bounds the cache; it is emptied when full.
+	reverseDNSCacheSize = 10000
+)
+
+// hostSource is a validated hostSourceConfig.
+type hostSource struct {
+	service string
+	from    []string
+}
+
+// hostNaming sets the host of ingested logs sent without one.
+type hostNaming struct {
+	keys    map[string]string // name -> key
+	sources []hostSource
+
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+	dnsMu      sync.Mutex
+	dns        map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	name    string
+	expires time.Time
+}
+
+// hostNaming builds the configured host naming, or returns nil when no
+// sources are configured.
+func (c *fileConfig) hostNaming() (*hostNaming, error) {
+	if c.HostNaming == nil || len(c.HostNaming.Sources) == 0 {
+		return nil, nil
+	}
+	hn := &hostNaming{
+		keys:       c.HostNaming.APIKeys,
+		lookupAddr: net.DefaultResolver.LookupAddr,
+		dns:        make(map[string]dnsEntry),
+	}
+	for name, key := range hn.keys {
+		if name == "" || len(name) > maxSourceNameLength || key == "" {
+			return nil, fmt.Errorf("host_naming: api key %q needs a name of at most %d characters and a key", name, maxSourceNameLength)
+		}
+	}
+	for i, sc := range c.HostNaming.Sources {
+		if _, err := path.Match(sc.Service, ""); err != nil {
+			return nil, fmt.Errorf("host_naming: sources[%d]: invalid glob %q", i, sc.Service)
+		}
+		if len(sc.From) == 0 {
+			return nil, fmt.Errorf("host_naming: sources[%d]: from lists no methods", i)
+		}
+		for _, method := range sc.From {
+			switch {
+			case method == hostFromIP, method == hostFromReverseDNS:
+			case method == hostFromAPIKey:
+				if len(hn.keys) == 0 {
+					return nil, fmt.Errorf("host_naming: sources[%d]: api_key needs api_keys", i)
+				}
+			case strings.HasPrefix(method, hostFromHeader) && len(method) > len(hostFromHeader):
+			default:
+				return nil, fmt.Errorf("host_naming: sources[%d]: unknown method %q; use ip, reverse_dns, api_key or header:<name>", i, method)
+			}
+		}
+		hn.sources = append(hn.sources, hostSource{service: sc.Service, from: sc.From})
+	}
+	return hn, nil
+}
+
+// requestHosts names hosts for the logs of one request, resolving each
+// method at most once.
+type requestHosts struct {
+	hn       *hostNaming
+	r        *http.Request
+	resolved map[string]string
+}
+
+// forRequest returns the host namer for logs sent in r. A nil hostNaming
+// returns a nil namer, which leaves hosts unchanged.
+func (hn *hostNaming) forRequest(r *http.Request) *requestHosts {
+	if hn == nil {
+		return nil
+	}
+	return &requestHosts{hn: hn, r: r, resolved: make(map[string]string)}
+}
+
+// name sets the host of a log without one from the first source matching
+// its service. Not safe for concurrent use.
+func (rh *requestHosts) name(l *models.Log) {
+	if rh == nil || strings.TrimSpace(l.Host) != "" {
+		return
+	}
+	for _, src := range rh.hn.sources {
+		if ok, _ := path.Match(src.service, l.Service); src.service != "" && !ok {
+			continue
+		}
+		for _, method := range src.from {
+			if host := rh.resolve(method); host != "" {
+				l.Host = host
+				return
+			}
+		}
+		return
+	}
+}
+
+// resolve returns the host method yields for the request, or "".
+func (rh *requestHosts) resolve(method string) string {
+	if host, ok := rh.resolved[method]; ok {
+		return host
+	}
+	var host string
+	switch {
+	case method == hostFromIP:
+		host = getClientIP(rh.r)
+	case method == hostFromReverseDNS:
+		host = rh.hn.reverseDNS(rh.r.Context(), getClientIP(rh.r))
+	case method == hostFromAPIKey:
+		host = rh.hn.keyName(requestAPIKey(rh.r))
+	case strings.HasPrefix(method, hostFromHeader):
+		host = strings.TrimSpace(rh.r.Header.Get(strings.TrimPrefix(method, hostFromHeader)))
+	}
+	if len(host) > maxSourceNameLength {
+		host = ""
+	}
+	rh.resolved[method] = host
+	return host
+}
+
+// requestAPIKey returns the key presented as X-Api-Key or a bearer token.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// keyName returns the name of key, or "" when it isn't configured.
+func (hn *hostNaming) keyName(key string) string {
+	if key == "" {
+		return ""
+	}
+	for name, k := range hn.keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			return name
+		}
+	}
+	return ""
+}
+
+// reverseDNS returns the first PTR name of ip without the trailing dot, or
+// "" when there is none, caching the answer.
+func (hn *hostNaming) reverseDNS(ctx context.Context, ip string) string {
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	now := time.Now()
+	hn.dnsMu.Lock()
+	entry, ok := hn.dns[ip]
+	hn.dnsMu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.name
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, reverseDNSTimeout)
+	defer cancel()
+	names, err := hn.lookupAddr(ctx, ip)
+	if err != nil && ctx.Err() != nil {
+		return "" // timed out or canceled; try again next time
+	}
+	var name string
+	if len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+
+	hn.dnsMu.Lock()
+	if len(hn.dns) >= reverseDNSCacheSize {
+		clear(hn.dns)
+	}
+	hn.dns[ip] = dnsEntry{name: name, expires: now.Add(reverseDNSTTL)}
+	hn.dnsMu.Unlock()
+	return name
+}
@@ -0,0 +1,116 @@
+package logservice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"locog/internal/models"
+)
+
+// TestHostNaming tests the naming methods, their order and per-service
+// sources.
+func TestHostNaming(t *testing.T) {
+	cfg, err := loadConfig(writeTestConfig(t, `{"host_naming": {
+		"api_keys": {"edge-proxy": "s3cret"},
+		"sources": [
+			{"service": "legacy-*", "from": ["header:X-Hostname", "reverse_dns", "ip"]},
+			{"service": "edge", "from": ["api_key"]},
+			{"from": ["ip"]}
+		]
+	}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hn, _ := cfg.hostNaming()
+	lookups := 0
+	hn.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookups++
+		if addr == "192.0.2.7" {
+			return []string{"app-7.example.com."}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	tests := []struct {
+		service, host string
+		remote        string
+		header        http.Header
+		want          string
+	}{
+		{"legacy-app", "", "192.0.2.7:1234", http.Header{"X-Hostname": {"box-1"}}, "box-1"},
+		{"legacy-app", "", "192.0.2.7:1234", nil, "app-7.example.com"},
+		{"legacy-app", "", "192.0.2.8:1234", nil, "192.0.2.8"},
+		{"legacy-app", "given", "192.0.2.7:1234", nil, "given"},
+		{"edge", "", "192.0.2.9:1234", http.Header{"X-Api-Key": {"s3cret"}}, "edge-proxy"},
+		{"edge", "", "192.0.2.9:1234", http.Header{"Authorization": {"Bearer s3cret"}}, "edge-proxy"},
+		{"edge", "", "192.0.2.9:1234", http.Header{"X-Api-Key": {"wrong"}}, ""}, // only api_key is tried
+		{"api", "", "192.0.2.10:1234", nil, "192.0.2.10"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/ingest", nil)
+		r.RemoteAddr = tt.remote
+		for k, v := range tt.header {
+			r.Header[k] = v
+		}
+		l := models.Log{Service: tt.service, Host: tt.host}
+		hn.forRequest(r).name(&l)
+		if l.Host != tt.want {
+			t.Errorf("%s from %s %v: host %q, want %q", tt.service, tt.remote, tt.header, l.Host, tt.want)
+		}
+	}
+	if lookups != 2 {
+		t.Errorf("expected reverse lookups to be cached, got %d lookups", lookups)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/ingest", nil)
+	r.RemoteAddr = "192.0.2.8:1234"
+	if hn.forRequest(r).name(&models.Log{Service: "legacy-app"}); lookups != 2 {
+		t.Errorf("expected missing names to be cached, got %d lookups", lookups)
+	}
+
+	var disabled *hostNaming
+	l := models.Log{Service: "api"}
+	if disabled.forRequest(r).name(&l); l.Host != "" {
+		t.Errorf("expected no host without host naming, got %q", l.Host)
+	}
+
+	for _, bad := range []string{
+		`{"host_naming": {"sources": [{"from": ["mac"]}]}}`,
+		`{"host_naming": {"sources": [{"from": []}]}}`,
+		`{"host_naming": {"sources": [{"from": ["header:"]}]}}`,
+		`{"host_naming": {"sources": [{"from": ["api_key"]}]}}`,
+		`{"host_naming": {"sources": [{"service": "[a-", "from": ["ip"]}]}}`,
+		`{"host_naming": {"api_keys": {"": "k"}, "sources": [{"from": ["api_key"]}]}}`,
+	} {
+		if _, err := loadConfig(writeTestConfig(t, bad)); err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+}
+
+// TestHostNaming_Ingest tests that ingested logs without a host are named.
+func TestHostNaming_Ingest(t *testing.T) {
+	srv := newTestServer(t)
+	srv.hostNaming, _ = (&fileConfig{HostNaming: &hostNamingConfig{
+		Sources: []hostSourceConfig{{From: []string{"header:X-Hostname", "ip"}}},
+	}}).hostNaming()
+
+	body := `[{"service":"api","level":"info","message":"a"},{"service":"api","level":"info","message":"b","host":"web-1"}]`
+	req := httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(body))
+	req.Header.Set("X-Hostname", "shipper-3")
+	rr := httptest.NewRecorder()
+	srv.handleIngest(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	hosts := map[string]string{}
+	for _, l := range queryAll(t, srv) {
+		hosts[l.Message] = l.Host
+	}
+	if hosts["a"] != "shipper-3" || hosts["b"] != "web-1" {
+		t.Errorf("expected hosts shipper-3 and web-1, got %v", hosts)
+	}
+}
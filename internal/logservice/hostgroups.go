@@ -0,0 +1,198 @@
+package logservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"locog/internal/db"
+	"locog/internal/models"
+)
+
+// maxHostGroupHosts bounds the hosts and patterns in one group.
+const maxHostGroupHosts = 1000
+
+var hostGroupNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// hostGroups names sets of hosts so queries can filter a whole tier with
+// host_group=web. Members are host names or globs using * and ?, e.g.
+// "web-*". Groups from the config file are fixed; the others are managed via
+// /api/admin/host-groups and stored in the database.
+type hostGroups struct {
+	mu      sync.RWMutex
+	fixed   map[string][]string
+	managed map[string][]string
+}
+
+// hostGroup is a group as listed and accepted by the API.
+type hostGroup struct {
+	Name   string   `json:"name"`
+	Hosts  []string `json:"hosts"`
+	Source string   `json:"source,omitempty"` // "config" or "api"
+}
+
+// validateHostGroup checks a group's name and members.
+func validateHostGroup(name string, hosts []string) error {
+	if !hostGroupNamePattern.MatchString(name) {
+		return fmt.Errorf("host group %q: names are 1-64 lower case letters, digits, '_' or '-'", name)
+	}
+	if len(hosts) == 0 || len(hosts) > maxHostGroupHosts {
+		return fmt.Errorf("host group %s: must list between 1 and %d hosts", name, maxHostGroupHosts)
+	}
+	for _, h := range hosts {
+		// SQLite's GLOB classes have no PostgreSQL equivalent
+		if h == "" || len(h) > 255 || strings.ContainsAny(h, "[]") {
+			return fmt.Errorf("host group %s: invalid host %q; use names or globs with * and ?", name, h)
+		}
+	}
+	return nil
+}
+
+// hostGroups validates the configured host groups.
+func (c *fileConfig) hostGroups() (map[string][]string, error) {
+	for name, hosts := range c.HostGroups {
+		if err := validateHostGroup(name, hosts); err != nil {
+			return nil, err
+		}
+	}
+	return c.HostGroups, nil
+}
+
+// newHostGroups combines the configured groups with those stored in the
+// database. A stored group shadowed by a configured one is ignored.
+func newHostGroups(ctx context.Context, fixed map[string][]string, database db.Store) (*hostGroups, error) {
+	managed, err := database.HostGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for name := range managed {
+		if _, ok := fixed[name]; ok {
+			slog.Warn("stored host group is shadowed by the config file", "group", name)
+			delete(managed, name)
+		}
+	}
+	return &hostGroups{fixed: fixed, managed: managed}, nil
+}
+
+// hosts returns a group's members, if it exists.
+func (g *hostGroups) hosts(name string) ([]string, bool) {
+	if g == nil {
+		return nil, false
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if hosts, ok := g.fixed[name]; ok {
+		return hosts, true
+	}
+	hosts, ok := g.managed[name]
+	return hosts, ok
+}
+
+// list returns every group, sorted by name.
+func (g *hostGroups) list() []hostGroup {
+	groups := []hostGroup{}
+	if g == nil {
+		return groups
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for name, hosts := range g.fixed {
+		groups = append(groups, hostGroup{Name: name, Hosts: hosts, Source: "config"})
+	}
+	for name, hosts := range g.managed {
+		groups = append(groups, hostGroup{Name: name, Hosts: hosts, Source: "api"})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// names returns the group names, sorted.
+func (g *hostGroups) names() []string {
+	var names []string
+	for _, group := range g.list() {
+		names = append(names, group.Name)
+	}
+	return names
+}
+
+// hostGroupFilter sets the filter's host patterns from host_group.
+func (s *server) hostGroupFilter(filter *models.LogFilter, name string) *apiError {
+	hosts, ok := s.hostGroups.hosts(name)
+	if !ok {
+		return filterError("invalid_host_group", "Unknown host group",
+			fmt.Sprintf("no host group named %q; see /api/filters", name))
+	}
+	filter.HostPatterns = hosts
+	return nil
+}
+
+// handleHostGroups manages host groups: GET /api/admin/host-groups lists
+// them, PUT /api/admin/host-groups/{name} with {"hosts": [...]} creates or
+// replaces one and DELETE removes it. Groups from the config file can't be
+// changed.
+func (s *server) handleHostGroups(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.hostGroups.list())
+		return
+	}
+
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	g := s.hostGroups
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.fixed[name]; ok {
+		writeJSONError(w, http.StatusConflict, "host_group_fixed", "Host group is defined in the config file",
+			"Edit host_groups in the config file to change it")
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		deleted, err := s.db.DeleteHostGroup(r.Context(), name)
+		if err != nil {
+			slog.Error("failed to delete host group", "group", name, "error", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			writeJSONError(w, http.StatusNotFound, "not_found", "Host group not found", "")
+			return
+		}
+		delete(g.managed, name)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var group hostGroup
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 256<<10)).Decode(&group); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_body", "Invalid request body", err.Error())
+		return
+	}
+	if err := validateHostGroup(name, group.Hosts); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_host_group", "Invalid host group", err.Error())
+		return
+	}
+	if err := s.db.SetHostGroup(r.Context(), name, group.Hosts); err != nil {
+		slog.Error("failed to store host group", "group", name, "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	g.managed[name] = group.Hosts
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hostGroup{Name: name, Hosts: group.Hosts, Source: "api"})
+}
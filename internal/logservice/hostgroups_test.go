@@ -0,0 +1,113 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestLoadConfig_HostGroups tests host group validation.
+func TestLoadConfig_HostGroups(t *testing.T) {
+	cfg, err := loadConfig(writeTestConfig(t, `{"host_groups": {"web": ["web-*"], "db": ["db-01", "db-02"]}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if groups, _ := cfg.hostGroups(); len(groups) != 2 || len(groups["db"]) != 2 {
+		t.Errorf("unexpected host groups: %v", groups)
+	}
+
+	for _, bad := range []string{
+		`{"host_groups": {"Web": ["web-*"]}}`,
+		`{"host_groups": {"web": []}}`,
+		`{"host_groups": {"web": [""]}}`,
+		`{"host_groups": {"web": ["web-[0-9]"]}}`,
+	} {
+		if _, err := loadConfig(writeTestConfig(t, bad)); err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+}
+
+// TestHostGroups tests managing host groups via the API and filtering by
+// them.
+func TestHostGroups(t *testing.T) {
+	srv := newTestServer(t)
+	if err := srv.db.SetHostGroup(t.Context(), "web", []string{"shadowed"}); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := newHostGroups(t.Context(), map[string][]string{"web": {"web-*"}}, srv.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.hostGroups = groups
+
+	for _, host := range []string{"web-01", "web-02", "db-01", "db-02", "cache-01"} {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "INFO", Message: "m", Host: host})
+	}
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if name, ok := strings.CutPrefix(target, "/api/admin/host-groups/"); ok {
+			req.SetPathValue("name", name)
+		}
+		rr := httptest.NewRecorder()
+		srv.handleHostGroups(rr, req)
+		return rr
+	}
+
+	if rr := do(http.MethodPut, "/api/admin/host-groups/web", `{"hosts": ["x"]}`); rr.Code != http.StatusConflict {
+		t.Errorf("expected a config group to be fixed, got %d", rr.Code)
+	}
+	if rr := do(http.MethodPut, "/api/admin/host-groups/db", `{"hosts": ["db-[12]"]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected glob classes to be rejected, got %d", rr.Code)
+	}
+	if rr := do(http.MethodPut, "/api/admin/host-groups/db", `{"hosts": ["db-01", "db-02"]}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected the group to be stored, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var listed []hostGroup
+	json.NewDecoder(do(http.MethodGet, "/api/admin/host-groups", "").Body).Decode(&listed)
+	if len(listed) != 2 || listed[0].Name != "db" || listed[0].Source != "api" || listed[1].Source != "config" || listed[1].Hosts[0] != "web-*" {
+		t.Errorf("unexpected groups: %+v", listed)
+	}
+
+	query := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		srv.handleQueryLogs(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		return rr
+	}
+	for group, want := range map[string]int{"web": 2, "db": 2} {
+		rr := query("/api/logs?host_group=" + group)
+		var logs []models.Log
+		json.NewDecoder(rr.Body).Decode(&logs)
+		if len(logs) != want {
+			t.Errorf("host_group=%s: expected %d logs, got %d", group, want, len(logs))
+		}
+	}
+	if rr := query("/api/logs?host_group=nope"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown group to be rejected, got %d", rr.Code)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.handleGetFilters(rr, httptest.NewRequest(http.MethodGet, "/api/filters", nil))
+	var options models.FilterOptions
+	json.NewDecoder(rr.Body).Decode(&options)
+	if strings.Join(options.HostGroups, ",") != "db,web" {
+		t.Errorf("expected the groups in the filter options, got %v", options.HostGroups)
+	}
+
+	if rr := do(http.MethodDelete, "/api/admin/host-groups/db", ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected the group to be deleted, got %d", rr.Code)
+	}
+	if rr := do(http.MethodDelete, "/api/admin/host-groups/db", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected a missing group to be reported, got %d", rr.Code)
+	}
+	if stored, _ := srv.db.HostGroups(t.Context()); len(stored) != 1 || stored["web"][0] != "shadowed" {
+		t.Errorf("expected only the shadowed group to remain stored, got %v", stored)
+	}
+}
@@ -0,0 +1,236 @@
+package logservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"locog/internal/db"
+	"locog/internal/models"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultIncidentDuration and maxIncidentDuration bound how long incident
+	// mode lasts before reverting on its own.
+	defaultIncidentDuration = time.Hour
+	maxIncidentDuration     = 24 * time.Hour
+	// defaultIncidentRetention is how long logs sent during an incident are
+	// kept after it ends.
+	defaultIncidentRetention = 90 * 24 * time.Hour
+	// incidentRateFactor raises the per-IP ingest rate limit for requests
+	// carrying logs of an incident's services.
+	incidentRateFactor = 10
+)
+
+// incidentMode holds the incidents in effect for ingest. Ended incidents
+// only keep logs, which DeleteOldLogs reads from the database.
+type incidentMode struct {
+	// limiter is the raised per-IP rate limit
+	limiter *ipRateLimiter
+
+	mu        sync.RWMutex
+	incidents []models.Incident
+}
+
+// newIncidentMode loads the incidents from the database. r and burst are the
+// normal per-IP rate limit.
+func newIncidentMode(ctx context.Context, database db.Store, r rate.Limit, burst int) (*incidentMode, error) {
+	m := &incidentMode{limiter: newIPRateLimiter(r*incidentRateFactor, burst*incidentRateFactor)}
+	return m, m.load(ctx, database)
+}
+
+// load replaces the incidents with those stored that have yet to end.
+func (m *incidentMode) load(ctx context.Context, database db.Store) error {
+	now := time.Now()
+	incidents, err := database.Incidents(ctx, now)
+	if err != nil {
+		return err
+	}
+	incidents = slices.DeleteFunc(incidents, func(inc models.Incident) bool { return !inc.EndsAt.After(now) })
+	m.mu.Lock()
+	m.incidents = incidents
+	m.mu.Unlock()
+	return nil
+}
+
+// active reports whether any incident is in effect at now.
+func (m *incidentMode) active(now time.Time) bool {
+	return m.covers("", now)
+}
+
+// covers reports whether an incident including service is in effect at now;
+// any incident covers "".
+func (m *incidentMode) covers(service string, now time.Time) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, inc := range m.incidents {
+		if incidentActive(inc, now) && (service == "" || slices.Contains(inc.Services, service)) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOverLimit reports whether a request over the normal rate limit is
+// accepted: it carries logs of an incident's services and is within the
+// raised limit.
+func (m *incidentMode) allowOverLimit(ip string, logs []models.Log) bool {
+	now := time.Now()
+	for i := range logs {
+		if m.covers(logs[i].Service, now) {
+			return m.limiter.getLimiter(ip).Allow()
+		}
+	}
+	return false
+}
+
+func incidentActive(inc models.Incident, now time.Time) bool {
+	return !now.Before(inc.StartedAt) && now.Before(inc.EndsAt)
+}
+
+// incidentRequest starts an incident. Duration and Retention default to
+// defaultIncidentDuration and defaultIncidentRetention.
+type incidentRequest struct {
+	Name      string   `json:"name"`
+	Services  []string `json:"services"`
+	Duration  string   `json:"duration"`  // e.g. "2h", at most maxIncidentDuration
+	Retention string   `json:"retention"` // e.g. "90d" after the incident ends
+}
+
+// incident validates the request and returns the incident starting at now.
+func (req incidentRequest) incident(now time.Time) (models.Incident, error) {
+	inc := models.Incident{Name: req.Name, StartedAt: now}
+	if req.Name == "" || len(req.Name) > 100 {
+		return inc, fmt.Errorf("name must be 1-100 characters")
+	}
+	if len(req.Services) == 0 {
+		return inc, fmt.Errorf("services must name at least one service")
+	}
+	for _, service := range req.Services {
+		if service == "" || len(service) > 100 {
+			return inc, fmt.Errorf("invalid service %q", service)
+		}
+		if !slices.Contains(inc.Services, service) {
+			inc.Services = append(inc.Services, service)
+		}
+	}
+
+	duration := defaultIncidentDuration
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 || d > maxIncidentDuration {
+			return inc, fmt.Errorf("duration must be positive and at most %s, got %q", maxIncidentDuration, req.Duration)
+		}
+		duration = d
+	}
+	retention := defaultIncidentRetention
+	if req.Retention != "" {
+		keep, err := parseRetention(req.Retention)
+		if err != nil || keep <= 0 {
+			return inc, fmt.Errorf("invalid retention %q", req.Retention)
+		}
+		retention = keep
+	}
+	inc.EndsAt = now.Add(duration)
+	inc.KeepUntil = inc.EndsAt.Add(retention)
+	return inc, nil
+}
+
+// handleIncidents manages incident mode: GET /api/admin/incidents lists the
+// incidents still keeping logs, POST starts one from an incidentRequest and
+// DELETE /api/admin/incidents/{id} ends one early. While an incident is
+// active its services' logs are not sampled and may exceed the per-IP rate
+// limit incidentRateFactor times; its logs are kept for its retention after
+// it ends.
+func (s *server) handleIncidents(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	if idParam := r.PathValue("id"); idParam != "" {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.ParseInt(idParam, 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, "not_found", "Incident not found", "")
+			return
+		}
+		inc, ok, err := s.db.EndIncident(r.Context(), id, now)
+		if err != nil {
+			slog.Error("failed to end incident", "id", id, "error", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "not_found", "Incident not found", "")
+			return
+		}
+		s.reloadIncidents(r.Context())
+		slog.Info("incident ended", "id", inc.ID, "name", inc.Name)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(inc)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		incidents, err := s.db.Incidents(r.Context(), now)
+		if err != nil {
+			slog.Error("failed to list incidents", "error", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		for i := range incidents {
+			incidents[i].Active = incidentActive(incidents[i], now)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(incidents)
+
+	case http.MethodPost:
+		var req incidentRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_body", "Invalid request body", err.Error())
+			return
+		}
+		inc, err := req.incident(now)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_incident", "Invalid incident", err.Error())
+			return
+		}
+		if err := s.db.StartIncident(r.Context(), &inc); err != nil {
+			slog.Error("failed to start incident", "name", inc.Name, "error", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		s.reloadIncidents(r.Context())
+		slog.Info("incident started", "id", inc.ID, "name", inc.Name, "services", inc.Services,
+			"ends_at", inc.EndsAt, "keep_until", inc.KeepUntil)
+		inc.Active = true
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(inc)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// reloadIncidents refreshes the incidents in effect after a change.
+func (s *server) reloadIncidents(ctx context.Context) {
+	if s.incidents == nil {
+		return
+	}
+	if err := s.incidents.load(ctx, s.db); err != nil {
+		slog.Error("failed to reload incidents", "error", err)
+	}
+}
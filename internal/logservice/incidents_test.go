@@ -0,0 +1,109 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+
+	"golang.org/x/time/rate"
+)
+
+// TestHandleIncidents tests starting, listing and ending incidents and how
+// they lift sampling and rate limits.
+func TestHandleIncidents(t *testing.T) {
+	srv := newTestServer(t)
+	// Every request is over the normal limit; incidents allow ten
+	srv.limiter = newIPRateLimiter(0, 0)
+	incidents, err := newIncidentMode(t.Context(), srv.db, rate.Limit(1), 1)
+	if err != nil {
+		t.Fatalf("newIncidentMode failed: %v", err)
+	}
+	srv.incidents = incidents
+	srv.streams = []logStream{{name: "sampled", service: "*", sampleRate: 1e-9}}
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if id, ok := strings.CutPrefix(path, "/api/admin/incidents/"); ok {
+			req.SetPathValue("id", id)
+		}
+		rr := httptest.NewRecorder()
+		srv.handleIncidents(rr, req)
+		return rr
+	}
+	ingest := func(service string) int {
+		body := `{"service": "` + service + `", "level": "info", "message": "m", "host": "h"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		srv.handleIngest(rr, req)
+		return rr.Code
+	}
+
+	if code := ingest("api"); code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 without an incident, got %d", code)
+	}
+
+	for _, bad := range []string{
+		`{"services": ["api"]}`,
+		`{"name": "outage", "services": []}`,
+		`{"name": "outage", "services": ["api"], "duration": "48h"}`,
+		`{"name": "outage", "services": ["api"], "retention": "forever"}`,
+	} {
+		if rr := do(http.MethodPost, "/api/admin/incidents", bad); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %s, got %d", bad, rr.Code)
+		}
+	}
+
+	rr := do(http.MethodPost, "/api/admin/incidents", `{"name": "outage", "services": ["api", "api"], "duration": "2h", "retention": "7d"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var inc models.Incident
+	json.NewDecoder(rr.Body).Decode(&inc)
+	if !inc.Active || len(inc.Services) != 1 || inc.EndsAt.Sub(inc.StartedAt) != 2*time.Hour ||
+		inc.KeepUntil.Sub(inc.EndsAt) != 7*24*time.Hour {
+		t.Errorf("unexpected incident %+v", inc)
+	}
+
+	// The incident's service is neither sampled nor limited; others still are
+	if code := ingest("api"); code != http.StatusCreated {
+		t.Errorf("expected 201 for the incident's service, got %d", code)
+	}
+	if code := ingest("web"); code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 for another service, got %d", code)
+	}
+	if logs, _ := srv.db.QueryLogs(t.Context(), models.LogFilter{}); len(logs) != 1 || logs[0].Service != "api" {
+		t.Errorf("expected the incident's log stored unsampled, got %+v", logs)
+	}
+
+	rr = do(http.MethodGet, "/api/admin/incidents", "")
+	var listed []models.Incident
+	json.NewDecoder(rr.Body).Decode(&listed)
+	if len(listed) != 1 || listed[0].ID != inc.ID || !listed[0].Active {
+		t.Errorf("expected the active incident listed, got %s", rr.Body.String())
+	}
+
+	if rr := do(http.MethodDelete, "/api/admin/incidents/999", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown incident, got %d", rr.Code)
+	}
+	rr = do(http.MethodDelete, "/api/admin/incidents/"+strconv.FormatInt(inc.ID, 10), "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if code := ingest("api"); code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 after the incident ended, got %d", code)
+	}
+
+	// Ended incidents are listed while they keep logs
+	rr = do(http.MethodGet, "/api/admin/incidents", "")
+	listed = nil
+	json.NewDecoder(rr.Body).Decode(&listed)
+	if len(listed) != 1 || listed[0].Active {
+		t.Errorf("expected the ended incident listed inactive, got %s", rr.Body.String())
+	}
+}
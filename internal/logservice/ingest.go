@@ -0,0 +1,229 @@
+package logservice
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"locog/internal/models"
+)
+
+// unknownFieldMode controls how ingest treats JSON fields that are not part of
+// the log schema.
+type unknownFieldMode string
+
+const (
+	// unknownFieldsIgnore silently drops unknown fields (the default).
+	unknownFieldsIgnore unknownFieldMode = "ignore"
+	// unknownFieldsReject fails the request when an unknown field is present.
+	// Intended for first-party apps where schema drift should be caught early.
+	unknownFieldsReject unknownFieldMode = "reject"
+	// unknownFieldsMetadata moves unknown fields into the log's metadata.
+	// Intended for third-party shippers that add their own top-level fields.
+	unknownFieldsMetadata unknownFieldMode = "metadata"
+)
+
+// unknownFieldsHeader lets a sender ask for reject for a single request. It
+// can't loosen the mode, so senders can't opt out of strict mode.
+const unknownFieldsHeader = "X-Locog-Unknown-Fields"
+
+// unknownFieldKeyConfig sets the unknown field mode of senders presenting Key
+// as X-Api-Key or a bearer token, overriding -unknown-fields.
+type unknownFieldKeyConfig struct {
+	Key  string `json:"key"`
+	Mode string `json:"mode"` // ignore, reject or metadata
+}
+
+// unknownFieldKeys validates the per-key unknown field modes, by key.
+func (c *fileConfig) unknownFieldKeys() (map[string]unknownFieldMode, error) {
+	if len(c.UnknownFieldKeys) == 0 {
+		return nil, nil
+	}
+	modes := make(map[string]unknownFieldMode)
+	for i, kc := range c.UnknownFieldKeys {
+		if kc.Key == "" {
+			return nil, fmt.Errorf("unknown_field_keys[%d]: missing key", i)
+		}
+		if _, ok := modes[kc.Key]; ok {
+			return nil, fmt.Errorf("unknown_field_keys[%d]: duplicate key", i)
+		}
+		if kc.Mode == "" {
+			return nil, fmt.Errorf("unknown_field_keys[%d]: missing mode", i)
+		}
+		mode, err := parseUnknownFieldMode(kc.Mode)
+		if err != nil {
+			return nil, fmt.Errorf("unknown_field_keys[%d]: %w", i, err)
+		}
+		modes[kc.Key] = mode
+	}
+	return modes, nil
+}
+
+func parseUnknownFieldMode(s string) (unknownFieldMode, error) {
+	switch mode := unknownFieldMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "", unknownFieldsIgnore:
+		return unknownFieldsIgnore, nil
+	case unknownFieldsReject, unknownFieldsMetadata:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid unknown field mode %q (must be ignore, reject or metadata)", s)
+	}
+}
+
+// ingestUnknownFieldMode resolves the unknown field mode for a request: the
+// mode configured for the API key presented, else the server-wide setting.
+// The per-request header is only honored when it asks for reject.
+func (s *server) ingestUnknownFieldMode(r *http.Request) (unknownFieldMode, error) {
+	mode := s.unknownFields
+	if key := requestAPIKey(r); key != "" {
+		for k, m := range s.unknownFieldKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+				mode = m
+				break
+			}
+		}
+	}
+	if h := r.Header.Get(unknownFieldsHeader); h != "" {
+		requested, err := parseUnknownFieldMode(h)
+		if err != nil {
+			return "", err
+		}
+		if requested == unknownFieldsReject {
+			mode = requested
+		}
+	}
+	return parseUnknownFieldMode(string(mode))
+}
+
+// knownLogFields are the top-level JSON keys of models.Log.
+var knownLogFields = map[string]bool{
+	"id":         true,
+	"timestamp":  true,
+	"service":    true,
+	"level":      true,
+	"message":    true,
+	"metadata":   true,
+	"host":       true,
+	"created_at": true,
+}
+
+// decodeLogs parses an ingest body containing either a single log object or an
+// array of log objects, applying the given unknown field mode.
+func decodeLogs(body []byte, mode unknownFieldMode) ([]models.Log, error) {
+	trimmed := bytes.TrimSpace(body)
+
+	var raws []json.RawMessage
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &raws); err != nil {
+			return nil, fmt.Errorf("invalid JSON")
+		}
+	} else {
+		raws = []json.RawMessage{trimmed}
+	}
+
+	logs := make([]models.Log, 0, len(raws))
+	for i, raw := range raws {
+		l, err := decodeLog(raw, mode)
+		if err != nil {
+			if len(raws) > 1 {
+				return nil, fmt.Errorf("log %d: %w", i, err)
+			}
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
+
+func decodeLog(raw json.RawMessage, mode unknownFieldMode) (models.Log, error) {
+	var l models.Log
+
+	switch mode {
+	case unknownFieldsReject:
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&l); err != nil {
+			if strings.HasPrefix(err.Error(), "json: unknown field") {
+				return l, fmt.Errorf("unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+			}
+			return l, fmt.Errorf("invalid JSON")
+		}
+
+	case unknownFieldsMetadata:
+		if err := json.Unmarshal(raw, &l); err != nil {
+			return l, fmt.Errorf("invalid JSON")
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return l, fmt.Errorf("invalid JSON")
+		}
+		for key, value := range fields {
+			if knownLogFields[key] {
+				continue
+			}
+			var v interface{}
+			if err := json.Unmarshal(value, &v); err != nil {
+				return l, fmt.Errorf("invalid JSON")
+			}
+			if l.Metadata == nil {
+				l.Metadata = make(map[string]interface{})
+			}
+			// Explicit metadata wins over a promoted top-level field.
+			if _, exists := l.Metadata[key]; !exists {
+				l.Metadata[key] = v
+			}
+		}
+
+	default:
+		if err := json.Unmarshal(raw, &l); err != nil {
+			return l, fmt.Errorf("invalid JSON")
+		}
+	}
+
+	return l, nil
+}
+
+// parallelIngestThreshold is the batch size from which ingest validates and
+// normalizes logs on a worker pool; smaller batches aren't worth the
+// goroutines. Workers take ingestChunk logs at a time.
+const (
+	parallelIngestThreshold = 512
+	ingestChunk             = 64
+)
+
+// forEachLog calls fn for every index in [0, n), on up to GOMAXPROCS workers
+// for large batches. fn must only touch the log at its index.
+func forEachLog(n int, fn func(i int)) {
+	workers := min(runtime.GOMAXPROCS(0), (n+ingestChunk-1)/ingestChunk)
+	if n < parallelIngestThreshold || workers < 2 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				start := int(next.Add(ingestChunk)) - ingestChunk
+				if start >= n {
+					return
+				}
+				for i := start; i < min(start+ingestChunk, n); i++ {
+					fn(i)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,226 @@
+package logservice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestDecodeLogs_IgnoreUnknownFields tests that unknown fields are dropped by default.
+func TestDecodeLogs_IgnoreUnknownFields(t *testing.T) {
+	body := []byte(`{"service":"api","level":"info","message":"hi","container_id":"abc"}`)
+
+	logs, err := decodeLogs(body, unknownFieldsIgnore)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(logs))
+	}
+	if logs[0].Metadata != nil {
+		t.Errorf("expected no metadata, got %v", logs[0].Metadata)
+	}
+}
+
+// TestDecodeLogs_RejectUnknownFields tests strict mode rejects unknown fields.
+func TestDecodeLogs_RejectUnknownFields(t *testing.T) {
+	body := []byte(`[{"service":"api","level":"info","message":"ok"},{"service":"api","level":"info","message":"hi","container_id":"abc"}]`)
+
+	_, err := decodeLogs(body, unknownFieldsReject)
+	if err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+	if !strings.Contains(err.Error(), "container_id") || !strings.Contains(err.Error(), "log 1") {
+		t.Errorf("expected error to name the field and entry, got: %v", err)
+	}
+}
+
+// TestDecodeLogs_MetadataUnknownFields tests lenient mode maps unknown fields into metadata.
+func TestDecodeLogs_MetadataUnknownFields(t *testing.T) {
+	body := []byte(`{"service":"api","level":"info","message":"hi","container_id":"abc","user_id":7,"metadata":{"user_id":42}}`)
+
+	logs, err := decodeLogs(body, unknownFieldsMetadata)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if logs[0].Metadata["container_id"] != "abc" {
+		t.Errorf("expected container_id in metadata, got %v", logs[0].Metadata)
+	}
+	// Explicit metadata takes precedence over promoted fields
+	if logs[0].Metadata["user_id"] != float64(42) {
+		t.Errorf("expected explicit metadata user_id 42, got %v", logs[0].Metadata["user_id"])
+	}
+}
+
+// TestParseUnknownFieldMode tests parsing of the mode flag/header values.
+func TestParseUnknownFieldMode(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    unknownFieldMode
+		wantErr bool
+	}{
+		{"", unknownFieldsIgnore, false},
+		{"ignore", unknownFieldsIgnore, false},
+		{"REJECT", unknownFieldsReject, false},
+		{" metadata ", unknownFieldsMetadata, false},
+		{"strict", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseUnknownFieldMode(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseUnknownFieldMode(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("parseUnknownFieldMode(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+// TestHandleIngest_UnknownFieldsHeaderOverride tests the per-request header tightens the server mode.
+func TestHandleIngest_UnknownFieldsHeaderOverride(t *testing.T) {
+	srv := newTestServer(t)
+	srv.unknownFields = unknownFieldsMetadata
+
+	body := `{"service":"api","level":"info","message":"hi","extra":"x"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(body))
+	req.Header.Set(unknownFieldsHeader, "reject")
+	req.RemoteAddr = "192.168.1.1:12345"
+	rr := httptest.NewRecorder()
+	srv.handleIngest(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d with reject header, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(body))
+	req.RemoteAddr = "192.168.1.1:12345"
+	rr = httptest.NewRecorder()
+	srv.handleIngest(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	logs, _ := srv.db.QueryLogs(req.Context(), models.LogFilter{})
+	if len(logs) != 1 || logs[0].Metadata["extra"] != "x" {
+		t.Errorf("expected extra field stored in metadata, got %+v", logs)
+	}
+}
+
+// TestHandleIngest_UnknownFieldKeys tests per-key unknown field modes, and
+// that the header can't loosen the mode.
+func TestHandleIngest_UnknownFieldKeys(t *testing.T) {
+	for _, bad := range []string{
+		`{"unknown_field_keys": [{"mode": "reject"}]}`,
+		`{"unknown_field_keys": [{"key": "k", "mode": "keep"}]}`,
+		`{"unknown_field_keys": [{"key": "k"}]}`,
+		`{"unknown_field_keys": [{"key": "k", "mode": "reject"}, {"key": "k", "mode": "metadata"}]}`,
+	} {
+		if _, err := loadConfig(writeTestConfig(t, bad)); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+	cfg, err := loadConfig(writeTestConfig(t, `{"unknown_field_keys": [{"key": "shipper-key", "mode": "metadata"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t)
+	srv.unknownFields = unknownFieldsReject
+	srv.unknownFieldKeys, _ = cfg.unknownFieldKeys()
+
+	ingest := func(header http.Header) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/ingest",
+			strings.NewReader(`{"service":"api","level":"info","message":"hi","extra":"x"}`))
+		req.Header = header
+		rr := httptest.NewRecorder()
+		srv.handleIngest(rr, req)
+		return rr.Code
+	}
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"server mode", http.Header{}, http.StatusBadRequest},
+		{"header loosening", http.Header{"X-Locog-Unknown-Fields": {"ignore"}}, http.StatusBadRequest},
+		{"other key", http.Header{"X-Api-Key": {"other"}}, http.StatusBadRequest},
+		{"key mode", http.Header{"X-Api-Key": {"shipper-key"}}, http.StatusCreated},
+		{"header tightening key mode", http.Header{"X-Api-Key": {"shipper-key"}, "X-Locog-Unknown-Fields": {"reject"}}, http.StatusBadRequest},
+	} {
+		if code := ingest(tc.header); code != tc.want {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.want, code)
+		}
+	}
+	if logs := queryAll(t, srv); len(logs) != 1 || logs[0].Metadata["extra"] != "x" {
+		t.Errorf("expected the key's log stored with extra in metadata, got %+v", logs)
+	}
+}
+
+// TestForEachLog tests that every index is visited once, serially or on the
+// worker pool.
+func TestForEachLog(t *testing.T) {
+	for _, n := range []int{0, 10, parallelIngestThreshold, 5000} {
+		var visits atomic.Int64
+		seen := make([]int, n)
+		forEachLog(n, func(i int) {
+			seen[i]++
+			visits.Add(1)
+		})
+		if visits.Load() != int64(n) || slices.ContainsFunc(seen, func(c int) bool { return c != 1 }) {
+			t.Errorf("n=%d: expected each index visited once, got %d visits", n, visits.Load())
+		}
+	}
+}
+
+// TestHandleIngest_LargeBatchFirstInvalid tests that the first invalid entry
+// of a batch validated concurrently is the one reported.
+func TestHandleIngest_LargeBatchFirstInvalid(t *testing.T) {
+	srv := newTestServer(t)
+
+	entries := make([]string, 2000)
+	for i := range entries {
+		entries[i] = `{"service":"api","level":"info","message":"m","host":"h"}`
+	}
+	entries[700] = `{"service":"","level":"info","message":"m","host":"h"}`
+	entries[1500] = `{"service":"api","level":"info","message":"","host":"h"}`
+	body := "[" + strings.Join(entries, ",") + "]"
+
+	rr := httptest.NewRecorder()
+	srv.handleIngest(rr, httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(body)))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "service") {
+		t.Errorf("expected the missing service to be reported, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// BenchmarkPrepareLogs measures normalizing a large batch with redaction
+// enabled, e.g. go test -bench PrepareLogs -cpu 1,4 ./internal/logservice
+func BenchmarkPrepareLogs(b *testing.B) {
+	cfg, err := loadConfig(writeTestConfig(b, redactionConfigJSON))
+	if err != nil {
+		b.Fatal(err)
+	}
+	srv := &server{metadataLimits: metadataLimits{MaxKeys: 200, MaxDepth: 8, MaxBytes: 64 << 10}}
+	srv.redactor, _ = cfg.redactor()
+
+	logs := make([]models.Log, 10000)
+	for b.Loop() {
+		b.StopTimer()
+		for i := range logs {
+			logs[i] = models.Log{Service: "api", Level: "INFO", Host: "h",
+				Message:  "login by user" + strconv.Itoa(i) + "@example.com from 10.0.0.1 with card 4111-1111-1111-1111",
+				Metadata: map[string]interface{}{"password": "hunter2", "path": "/login", "user": map[string]interface{}{"id": i}}}
+		}
+		b.StartTimer()
+		if _, _, err := srv.prepareLogs(logs, time.Now(), "bench"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
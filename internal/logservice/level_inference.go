@@ -0,0 +1,129 @@
+package logservice
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"locog/internal/models"
+)
+
+// levelInferenceConfig guesses the level of logs from matching sources that
+// arrive without one, such as plain-text lines, from their message.
+type levelInferenceConfig struct {
+	Service string `json:"service"` // glob, e.g. "legacy-*"; empty matches every service
+	Host    string `json:"host"`    // glob; empty matches every host
+
+	// Rules replace the built-in rules; the earliest match in the message
+	// wins, ties going to the earlier rule.
+	Rules []levelRuleConfig `json:"rules"`
+
+	// Default is the level when no rule matches (default "info").
+	Default string `json:"default"`
+}
+
+type levelRuleConfig struct {
+	Pattern string `json:"pattern"` // regular expression matched against the message
+	Level   string `json:"level"`
+}
+
+// levelRule sets level on messages matching pattern.
+type levelRule struct {
+	pattern *regexp.Regexp
+	level   string
+}
+
+// builtinLevelRules recognize level words as shippers write them, e.g.
+// "ERROR", "[warn]", "level=debug", and Go panics and Java exceptions.
+var builtinLevelRules = []levelRule{
+	{regexp.MustCompile(`(?i)\b(fatal|crit|critical|emerg|emergency|alert)\b|\bpanic:`), "fatal"},
+	{regexp.MustCompile(`(?i)\b(error|err|severe)\b|\b[\w.]*Exception\b|^Traceback\b`), "error"},
+	{regexp.MustCompile(`(?i)\b(warn|warning)\b`), "warn"},
+	{regexp.MustCompile(`(?i)\b(info|notice)\b`), "info"},
+	{regexp.MustCompile(`(?i)\b(debug|dbg)\b`), "debug"},
+	{regexp.MustCompile(`(?i)\btrace\b`), "trace"},
+}
+
+// levelInferrer infers levels for one configured source.
+type levelInferrer struct {
+	service  string
+	host     string
+	rules    []levelRule
+	fallback string
+}
+
+// levelInference holds the configured sources in order; the first that
+// matches a log infers its level.
+type levelInference struct {
+	sources []levelInferrer
+}
+
+// levelInference builds the configured inference, or returns nil when no
+// sources are configured.
+func (c *fileConfig) levelInference() (*levelInference, error) {
+	if len(c.LevelInference) == 0 {
+		return nil, nil
+	}
+	li := &levelInference{}
+	for i, sc := range c.LevelInference {
+		for _, glob := range []string{sc.Service, sc.Host} {
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("level_inference[%d]: invalid glob %q", i, glob)
+			}
+		}
+		src := levelInferrer{service: sc.Service, host: sc.Host, rules: builtinLevelRules, fallback: sc.Default}
+		if src.fallback == "" {
+			src.fallback = "info"
+		}
+		if len(src.fallback) > maxLevelLength {
+			return nil, fmt.Errorf("level_inference[%d]: default level %q is longer than %d characters", i, sc.Default, maxLevelLength)
+		}
+		if len(sc.Rules) > 0 {
+			src.rules = nil
+		}
+		for _, rc := range sc.Rules {
+			if strings.TrimSpace(rc.Level) == "" || len(rc.Level) > maxLevelLength {
+				return nil, fmt.Errorf("level_inference[%d]: invalid level %q", i, rc.Level)
+			}
+			re, err := regexp.Compile(rc.Pattern)
+			if err != nil || rc.Pattern == "" {
+				return nil, fmt.Errorf("level_inference[%d]: invalid pattern %q", i, rc.Pattern)
+			}
+			src.rules = append(src.rules, levelRule{pattern: re, level: rc.Level})
+		}
+		li.sources = append(li.sources, src)
+	}
+	return li, nil
+}
+
+// infer sets the level of a log without one when a configured source
+// matches it. A nil inference and logs with a level are left unchanged.
+func (li *levelInference) infer(l *models.Log) {
+	if li == nil || strings.TrimSpace(l.Level) != "" {
+		return
+	}
+	for _, src := range li.sources {
+		if ok, _ := path.Match(src.service, l.Service); src.service != "" && !ok {
+			continue
+		}
+		if ok, _ := path.Match(src.host, l.Host); src.host != "" && !ok {
+			continue
+		}
+		l.Level = src.level(l.Message)
+		return
+	}
+}
+
+// level returns the level of the rule matching earliest in message, or the
+// fallback.
+func (src levelInferrer) level(message string) string {
+	level, first := src.fallback, -1
+	for _, rule := range src.rules {
+		loc := rule.pattern.FindStringIndex(message)
+		if loc != nil && (first < 0 || loc[0] < first) {
+			level, first = rule.level, loc[0]
+		}
+	}
+	return level
+}
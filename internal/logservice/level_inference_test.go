@@ -0,0 +1,99 @@
+package logservice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"locog/internal/models"
+)
+
+// TestLevelInference tests the built-in rules, custom rules, defaults and
+// source matching.
+func TestLevelInference(t *testing.T) {
+	cfg, err := loadConfig(writeTestConfig(t, `{"level_inference": [
+		{"service": "billing", "rules": [{"pattern": "DECLINED", "level": "warn"}], "default": "debug"},
+		{"service": "legacy-*"},
+		{"host": "router-*", "default": "notice"}
+	]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	li, _ := cfg.levelInference()
+
+	tests := []struct {
+		service, host, level, message string
+		want                          string
+	}{
+		{"legacy-app", "h", "", "2025-01-15 10:00:00 ERROR connection refused", "error"},
+		{"legacy-app", "h", "", "[warn] disk almost full", "warn"},
+		{"legacy-app", "h", "", "panic: runtime error: index out of range", "fatal"},
+		{"legacy-app", "h", "", "java.lang.NullPointerException at Foo.bar", "error"},
+		{"legacy-app", "h", "", "level=debug msg=tick", "debug"},
+		{"legacy-app", "h", "", "INFO retrying after error", "info"}, // earliest match wins
+		{"legacy-app", "h", "", "user logged in", "info"},
+		{"legacy-app", "h", "", "reading stderr", "info"},
+		{"legacy-app", "h", "ERROR", "warn", "ERROR"}, // levels sent are kept
+		{"billing", "h", "", "ERROR card DECLINED", "warn"},
+		{"billing", "h", "", "charge ok", "debug"},
+		{"api", "router-1", "", "link up", "notice"},
+		{"api", "web-1", "", "ERROR boom", ""}, // no source matches
+	}
+	for _, tt := range tests {
+		l := models.Log{Service: tt.service, Host: tt.host, Level: tt.level, Message: tt.message}
+		li.infer(&l)
+		if l.Level != tt.want {
+			t.Errorf("infer(%s, %q) = %q, want %q", tt.service, tt.message, l.Level, tt.want)
+		}
+	}
+
+	var disabled *levelInference
+	l := models.Log{Service: "api", Message: "ERROR boom"}
+	if disabled.infer(&l); l.Level != "" {
+		t.Errorf("expected a nil inference to keep the level empty, got %q", l.Level)
+	}
+
+	for _, bad := range []string{
+		`{"level_inference": [{"service": "[a-"}]}`,
+		`{"level_inference": [{"rules": [{"pattern": "(", "level": "error"}]}]}`,
+		`{"level_inference": [{"rules": [{"pattern": "x", "level": ""}]}]}`,
+		`{"level_inference": [{"rules": [{"pattern": "", "level": "error"}]}]}`,
+	} {
+		if _, err := loadConfig(writeTestConfig(t, bad)); err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+}
+
+// TestLevelInference_Ingest tests that logs without a level are accepted
+// from sources with inference and still rejected from others.
+func TestLevelInference_Ingest(t *testing.T) {
+	srv := newTestServer(t)
+	srv.levelInference, _ = (&fileConfig{LevelInference: []levelInferenceConfig{{Service: "legacy"}}}).levelInference()
+
+	body := `[{"service":"legacy","message":"WARNING: low memory","host":"h"},{"service":"legacy","message":"started","host":"h"}]`
+	rr := httptest.NewRecorder()
+	srv.handleIngest(rr, httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	logs, err := srv.db.QueryLogs(t.Context(), models.LogFilter{Service: "legacy", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels := map[string]string{}
+	for _, l := range logs {
+		levels[l.Message] = l.Level
+	}
+	if len(logs) != 2 || levels["WARNING: low memory"] != "warn" || levels["started"] != "info" {
+		t.Errorf("expected inferred levels warn and info, got %+v", logs)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.handleIngest(rr, httptest.NewRequest(http.MethodPost, "/api/ingest",
+		strings.NewReader(`{"service":"api","message":"ERROR boom","host":"h"}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a log without a level from another service, got %d", rr.Code)
+	}
+}
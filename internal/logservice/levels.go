@@ -0,0 +1,204 @@
+package logservice
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"locog/internal/models"
+)
+
+// maxLevelLength is the size of the level column.
+const maxLevelLength = 20
+
+// canonicalLevels are the levels stored when level normalization is enabled.
+var canonicalLevels = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+// builtinLevelAliases maps common spellings, in lower case, and the numeric
+// syslog severities 0-7 to canonical levels.
+var builtinLevelAliases = map[string]string{
+	"trace":         "trace",
+	"debug":         "debug",
+	"dbg":           "debug",
+	"info":          "info",
+	"information":   "info",
+	"informational": "info",
+	"notice":        "info",
+	"warn":          "warn",
+	"warning":       "warn",
+	"error":         "error",
+	"err":           "error",
+	"fatal":         "fatal",
+	"crit":          "fatal",
+	"critical":      "fatal",
+	"panic":         "fatal",
+	"alert":         "fatal",
+	"emerg":         "fatal",
+	"emergency":     "fatal",
+	"0":             "fatal", // emergency
+	"1":             "fatal", // alert
+	"2":             "fatal", // critical
+	"3":             "error",
+	"4":             "warn",
+	"5":             "info", // notice
+	"6":             "info",
+	"7":             "debug",
+}
+
+// levelNormalizer rewrites ingested levels to canonical lower case names so
+// "ERROR", "Error" and "err" are stored, and listed in /api/filters, as one
+// level. Levels it doesn't know are lower-cased.
+type levelNormalizer struct {
+	aliases map[string]string
+}
+
+// levelNormalizationConfig enables level normalization at ingest.
+type levelNormalizationConfig struct {
+	// Mappings add or override aliases, matched case-insensitively, e.g.
+	// {"sev1": "fatal", "audit": "info"}. Targets must be canonical levels.
+	Mappings map[string]string `json:"mappings"`
+}
+
+// levelNormalizer builds the configured normalizer, or returns nil when
+// level normalization is not enabled.
+func (c *fileConfig) levelNormalizer() (*levelNormalizer, error) {
+	if c.LevelNormalization == nil {
+		return nil, nil
+	}
+	n := &levelNormalizer{aliases: make(map[string]string, len(builtinLevelAliases))}
+	for alias, level := range builtinLevelAliases {
+		n.aliases[alias] = level
+	}
+	for alias, level := range c.LevelNormalization.Mappings {
+		key := strings.ToLower(strings.TrimSpace(alias))
+		if key == "" || len(key) > maxLevelLength {
+			return nil, fmt.Errorf("level_normalization: invalid level %q", alias)
+		}
+		target := strings.ToLower(level)
+		if !slices.Contains(canonicalLevels, target) {
+			return nil, fmt.Errorf("level_normalization: %s maps to %q; use one of %s",
+				alias, level, strings.Join(canonicalLevels, ", "))
+		}
+		n.aliases[key] = target
+	}
+	return n, nil
+}
+
+// normalize returns the canonical form of level. A nil normalizer returns
+// level unchanged.
+func (n *levelNormalizer) normalize(level string) string {
+	if n == nil || level == "" {
+		return level
+	}
+	key := strings.ToLower(strings.TrimSpace(level))
+	if canonical, ok := n.aliases[key]; ok {
+		return canonical
+	}
+	return key
+}
+
+// levelFilter applies the level parameters that select several levels:
+// repeated or comma-separated level= values, min_level=, and the ranges
+// level>=warn and level<=info (parameters "level>" and "level<"), which
+// compare by severity. A single level= stays an exact match.
+func (s *server) levelFilter(filter *models.LogFilter, query url.Values) *apiError {
+	var listed []string
+	for _, v := range query["level"] {
+		for _, level := range strings.Split(v, ",") {
+			if level = strings.TrimSpace(level); level != "" {
+				listed = append(listed, s.levels.normalize(level))
+			}
+		}
+	}
+	if len(listed) > 1 {
+		filter.Level = ""
+		var levels []string
+		for _, level := range listed {
+			levels = append(levels, levelVariants(level)...)
+		}
+		slices.Sort(levels)
+		filter.Levels = slices.Compact(levels)
+	}
+
+	// level>=warn arrives as parameter "level>" with value "warn"
+	for _, param := range []struct{ name, shown, op string }{
+		{"min_level", "min_level", ">="},
+		{"level>", "level>=", ">="},
+		{"level<", "level<=", "<="},
+	} {
+		v := query.Get(param.name)
+		if v == "" {
+			continue
+		}
+		levels, err := levelsInRange(param.op, v)
+		if err == nil {
+			err = narrowLevels(filter, levels)
+		}
+		if err != nil {
+			return filterError("invalid_parameter", "Invalid level filter", fmt.Sprintf("'%s': %v", param.shown, err))
+		}
+	}
+	return nil
+}
+
+// levelVariants returns level with the known spellings of the same
+// canonical level, e.g. ERR and ERROR for "error". Unknown levels are
+// returned alone.
+func levelVariants(level string) []string {
+	canonical, err := parseMinLevel(level)
+	if err != nil || canonical == "" {
+		return []string{level}
+	}
+	var variants []string
+	for name := range levelRanks {
+		if c, ok := levelAliases[name]; name == canonical || ok && c == canonical {
+			variants = append(variants, name)
+		}
+	}
+	slices.Sort(variants)
+	return variants
+}
+
+// levelsInRange returns the known levels, aliases included, that compare to
+// level by severity as op (>, >=, < or <=) says, sorted.
+func levelsInRange(op, level string) ([]string, error) {
+	canonical, err := parseMinLevel(level)
+	if err != nil || canonical == "" {
+		return nil, fmt.Errorf("unknown level %q; use trace, debug, info, warn, error or fatal", level)
+	}
+	rank := levelRanks[canonical]
+	var levels []string
+	for name, r := range levelRanks {
+		if (op == ">" && r > rank) || (op == ">=" && r >= rank) ||
+			(op == "<" && r < rank) || (op == "<=" && r <= rank) {
+			levels = append(levels, name)
+		}
+	}
+	slices.Sort(levels)
+	return levels, nil
+}
+
+// narrowLevels restricts filter.Levels to levels, so several level
+// conditions must all hold.
+func narrowLevels(filter *models.LogFilter, levels []string) error {
+	if len(filter.Levels) > 0 {
+		var kept []string
+		for _, level := range levels {
+			if containsFold(filter.Levels, level) {
+				kept = append(kept, level)
+			}
+		}
+		if len(kept) == 0 {
+			return fmt.Errorf("the level conditions leave no level to match")
+		}
+		levels = kept
+	}
+	filter.Levels = levels
+	return nil
+}
+
+// containsFold reports whether values contains s, ignoring case.
+func containsFold(values []string, s string) bool {
+	return slices.ContainsFunc(values, func(v string) bool { return strings.EqualFold(v, s) })
+}
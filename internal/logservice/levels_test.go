@@ -0,0 +1,144 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestLevelNormalizer tests built-in aliases, syslog severities and mapping
+// rules.
+func TestLevelNormalizer(t *testing.T) {
+	cfg, err := loadConfig(writeTestConfig(t, `{"level_normalization": {"mappings": {"SEV1": "FATAL", "warning": "error"}}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n, _ := cfg.levelNormalizer()
+
+	for in, want := range map[string]string{
+		"ERROR":    "error",
+		"Err":      "error",
+		" Info ":   "info",
+		"critical": "fatal",
+		"4":        "warn",
+		"3":        "error",
+		"sev1":     "fatal",
+		"Warning":  "error", // mappings override built-in aliases
+		"AUDIT":    "audit",
+		"":         "",
+	} {
+		if got := n.normalize(in); got != want {
+			t.Errorf("normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	var disabled *levelNormalizer
+	if got := disabled.normalize("ERROR"); got != "ERROR" {
+		t.Errorf("expected a nil normalizer to keep levels, got %q", got)
+	}
+
+	for _, bad := range []string{
+		`{"level_normalization": {"mappings": {"sev1": "severe"}}}`,
+		`{"level_normalization": {"mappings": {"": "info"}}}`,
+	} {
+		if _, err := loadConfig(writeTestConfig(t, bad)); err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+}
+
+// TestLevelNormalization_IngestAndQuery tests that differently spelled levels
+// are stored as one and that queries are normalized the same way.
+func TestLevelNormalization_IngestAndQuery(t *testing.T) {
+	srv := newTestServer(t)
+	srv.levels, _ = (&fileConfig{LevelNormalization: &levelNormalizationConfig{}}).levelNormalizer()
+
+	var logs []models.Log
+	for _, level := range []string{"ERROR", "error", "Error", "err", "3"} {
+		logs = append(logs, models.Log{Timestamp: time.Now(), Service: "api", Level: level, Message: "m", Host: "h"})
+	}
+	if err := srv.storeLogs(t.Context(), logs, "test"); err != nil {
+		t.Fatalf("storeLogs failed: %v", err)
+	}
+
+	options, err := srv.db.GetFilterOptions(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options.Levels) != 1 || options.Levels[0] != "error" {
+		t.Errorf("expected only level 'error', got %v", options.Levels)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/logs?level=ERROR", nil)
+	rr := httptest.NewRecorder()
+	srv.handleQueryLogs(rr, req)
+	var got []models.Log
+	json.NewDecoder(rr.Body).Decode(&got)
+	if len(got) != 5 {
+		t.Errorf("expected level=ERROR to match all 5 logs, got %d", len(got))
+	}
+}
+
+// TestLevelFilter tests level lists and severity ranges in query parameters.
+func TestLevelFilter(t *testing.T) {
+	srv := &server{}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"level=error,fatal", []string{"CRIT", "CRITICAL", "ERR", "ERROR", "FATAL", "PANIC"}},
+		{"level=warn&level=audit", []string{"WARN", "WARNING", "audit"}},
+		{"level>=error", []string{"CRIT", "CRITICAL", "ERR", "ERROR", "FATAL", "PANIC"}},
+		{"level<=debug", []string{"DEBUG", "TRACE"}},
+		{"level>=info&level<=warning", []string{"INFO", "NOTICE", "WARN", "WARNING"}},
+		{"min_level=warn&level=info,warn,error", []string{"ERR", "ERROR", "WARN", "WARNING"}},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		filter, apiErr := srv.filterFromValues(query)
+		if apiErr != nil {
+			t.Errorf("%s: unexpected error: %+v", tt.query, apiErr)
+			continue
+		}
+		if filter.Level != "" || strings.Join(filter.Levels, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: expected levels %v, got %q %v", tt.query, tt.want, filter.Level, filter.Levels)
+		}
+	}
+
+	// A single level stays an exact match
+	filter, _ := srv.filterFromValues(url.Values{"level": {"ERROR"}})
+	if filter.Level != "ERROR" || filter.Levels != nil {
+		t.Errorf("expected an exact level, got %q %v", filter.Level, filter.Levels)
+	}
+
+	for _, bad := range []string{"level>=loud", "level>=error&level<=warn", "min_level=fatal&level=debug,info"} {
+		query, _ := url.ParseQuery(bad)
+		if _, apiErr := srv.filterFromValues(query); apiErr == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+// TestAlertRules_LevelRange tests that alert rules accept severity ranges.
+func TestAlertRules_LevelRange(t *testing.T) {
+	cfg, err := loadConfig(writeTestConfig(t, `{"alert_rules": [
+		{"name": "api-errors", "query": "service=api&level>=error", "window": "5m", "condition": ">", "threshold": 10}
+	]}`))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	rules, err := newTestServer(t).alertRules(cfg)
+	if err != nil {
+		t.Fatalf("alertRules failed: %v", err)
+	}
+	if !containsFold(rules[0].Filter.Levels, "panic") || containsFold(rules[0].Filter.Levels, "warn") {
+		t.Errorf("expected ERROR and above, got %v", rules[0].Filter.Levels)
+	}
+}
@@ -0,0 +1,179 @@
+package logservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// lifecycle runs the service's long-lived goroutines: the HTTP server, the
+// WebSocket hub and the background loops. It stops them in the reverse of
+// the order they were started, so the HTTP server drains its requests before
+// the hub delivering what they broadcast stops, and the hub before the loops.
+type lifecycle struct {
+	tasks []*task
+	// failed receives the first error of a task returning on its own
+	failed chan error
+}
+
+// task is a goroutine run by a lifecycle.
+type task struct {
+	name   string
+	cancel context.CancelFunc
+	// stop, if set, asks run to return before its context is canceled, e.g.
+	// http.Server.Shutdown
+	stop func(ctx context.Context) error
+	done chan struct{}
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{failed: make(chan error, 1)}
+}
+
+// start runs fn in a goroutine with a context canceled by shutdown. If fn
+// returns an error before then, wait returns it.
+func (l *lifecycle) start(name string, fn func(ctx context.Context) error, stop func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &task{name: name, cancel: cancel, stop: stop, done: make(chan struct{})}
+	l.tasks = append(l.tasks, t)
+	go func() {
+		defer close(t.done)
+		if err := fn(ctx); err != nil && ctx.Err() == nil {
+			select {
+			case l.failed <- fmt.Errorf("%s: %w", name, err):
+			default:
+			}
+		}
+	}()
+}
+
+// startHTTP runs httpServer with serve, e.g. its ListenAndServe, stopping it
+// with Shutdown.
+func (l *lifecycle) startHTTP(httpServer *http.Server, serve func() error) {
+	l.start("http server", func(context.Context) error {
+		if err := serve(); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}, httpServer.Shutdown)
+}
+
+// wait blocks until ctx is done, returning nil, or a task fails, returning
+// its error.
+func (l *lifecycle) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-l.failed:
+		return err
+	}
+}
+
+// shutdown stops the tasks, last started first: each is asked to stop if it
+// can be, its context canceled, and waited for. It gives up once ctx is
+// done, leaving the remaining tasks canceled but running.
+func (l *lifecycle) shutdown(ctx context.Context) error {
+	var errs []error
+	for i := len(l.tasks) - 1; i >= 0; i-- {
+		t := l.tasks[i]
+		if t.stop != nil {
+			if err := t.stop(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", t.name, err))
+			}
+		}
+		t.cancel()
+		select {
+		case <-t.done:
+			slog.Debug("stopped", "task", t.name)
+		case <-ctx.Done():
+			for _, rest := range l.tasks[:i] {
+				rest.cancel()
+			}
+			return errors.Join(append(errs, fmt.Errorf("%s: %w", t.name, ctx.Err()))...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// start registers the server's background loops and WebSocket hub with lc,
+// and stops generator runs and background totals on shutdown. Exports only
+// read, so they run against a snapshot too; alerting, uploads, cleanup,
+// maintenance, the metadata keys report and rollups only run on a writable
+// database.
+func (s *server) start(lc *lifecycle) {
+	if s.exports != nil {
+		lc.start("exports", func(ctx context.Context) error {
+			s.exports.run(ctx)
+			return nil
+		}, nil)
+	}
+	if s.degraded == nil {
+		if s.alerts != nil {
+			lc.start("alerts", func(ctx context.Context) error {
+				s.alerts.Run(ctx)
+				return nil
+			}, nil)
+		}
+		if s.uploads != nil {
+			lc.start("uploads", func(ctx context.Context) error {
+				s.uploads.run(ctx, s)
+				return nil
+			}, nil)
+		}
+		if s.retention != nil {
+			// Delete logs past retention every cleanup interval
+			lc.start("cleanup", func(ctx context.Context) error {
+				s.cleanupRoutine(ctx)
+				return nil
+			}, nil)
+		}
+		if s.maintain != nil {
+			lc.start("maintenance", func(ctx context.Context) error {
+				s.maintenanceRoutine(ctx)
+				return nil
+			}, nil)
+		}
+		if s.metadataKeysInterval > 0 {
+			lc.start("metadata keys", func(ctx context.Context) error {
+				s.metadataKeysRoutine(ctx, s.metadataKeysInterval)
+				return nil
+			}, nil)
+		}
+		if s.rollupInterval > 0 && len(s.db.Rollups().Sizes) > 0 {
+			lc.start("rollups", func(ctx context.Context) error {
+				s.rollupRoutine(ctx, s.rollupInterval)
+				return nil
+			}, nil)
+		}
+	}
+	if s.limiterIdleTTL > 0 {
+		lc.start("rate limiters", func(ctx context.Context) error {
+			s.rateLimitRoutine(ctx, s.limiterIdleTTL)
+			return nil
+		}, nil)
+	}
+	if s.hub != nil {
+		lc.start("websocket hub", func(ctx context.Context) error {
+			s.hub.run(ctx)
+			return nil
+		}, nil)
+	}
+	// Stopped before the hub and loops, and waited for so the database can
+	// be closed
+	if s.generator != nil {
+		lc.start("generator", func(ctx context.Context) error {
+			<-ctx.Done()
+			s.generator.close()
+			return nil
+		}, nil)
+	}
+	if s.totals != nil {
+		lc.start("background totals", func(ctx context.Context) error {
+			<-ctx.Done()
+			s.totals.close()
+			return nil
+		}, nil)
+	}
+}
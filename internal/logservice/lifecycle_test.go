@@ -0,0 +1,99 @@
+package logservice
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"locog/internal/db"
+)
+
+// TestLifecycle tests stopping tasks in reverse order and reporting a task
+// that fails.
+func TestLifecycle(t *testing.T) {
+	lc := newLifecycle()
+	var mu sync.Mutex
+	var stopped []string
+	for _, name := range []string{"a", "b", "c"} {
+		lc.start(name, func(ctx context.Context) error {
+			<-ctx.Done()
+			mu.Lock()
+			stopped = append(stopped, name)
+			mu.Unlock()
+			return nil
+		}, nil)
+	}
+	lc.start("failing", func(context.Context) error { return errors.New("boom") }, nil)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	if err := lc.wait(ctx); err == nil || err.Error() != "failing: boom" {
+		t.Fatalf("expected the failure, got %v", err)
+	}
+	if err := lc.shutdown(ctx); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if !slices.Equal(stopped, []string{"c", "b", "a"}) {
+		t.Errorf("expected tasks stopped last first, got %v", stopped)
+	}
+}
+
+// TestLifecycle_Server tests starting and stopping a full server.
+func TestLifecycle_Server(t *testing.T) {
+	srv := newTestServer(t)
+	// The loops and requests run concurrently, which a ring allows
+	database, err := db.NewRing(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	srv.db = database
+	srv.hub = newWSHub()
+	srv.retention = newRetentionSchedule(defaultRetentionPeriod, defaultCleanupInterval)
+	srv.metadataKeysInterval = time.Hour
+	handler, err := srv.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := &http.Server{Handler: handler}
+
+	lc := newLifecycle()
+	srv.start(lc)
+	lc.startHTTP(httpServer, func() error { return httpServer.Serve(listener) })
+
+	url := "http://" + listener.Addr().String() + "/api/ingest"
+	resp, err := http.Post(url, "application/json", bytes.NewReader(sampleLogJSON()))
+	if err != nil {
+		t.Fatalf("ingest failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	if err := lc.shutdown(ctx); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	for _, task := range lc.tasks {
+		select {
+		case <-task.done:
+		default:
+			t.Errorf("task %s still running", task.name)
+		}
+	}
+	if _, err := http.Post(url, "application/json", bytes.NewReader(sampleLogJSON())); err == nil {
+		t.Error("expected the server to be closed")
+	}
+}
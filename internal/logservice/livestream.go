@@ -0,0 +1,105 @@
+package logservice
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// levelRanks orders common log levels by severity. Levels not listed are
+// never withheld from live streams since their severity is unknown.
+var levelRanks = map[string]int{
+	"TRACE":    0,
+	"DEBUG":    1,
+	"INFO":     2,
+	"NOTICE":   2,
+	"WARN":     3,
+	"WARNING":  3,
+	"ERR":      4,
+	"ERROR":    4,
+	"CRIT":     5,
+	"CRITICAL": 5,
+	"FATAL":    5,
+	"PANIC":    5,
+}
+
+// levelAliases maps alternative spellings to the canonical levels TRACE,
+// DEBUG, INFO, WARN, ERROR and FATAL.
+var levelAliases = map[string]string{
+	"NOTICE":   "INFO",
+	"WARNING":  "WARN",
+	"ERR":      "ERROR",
+	"CRIT":     "FATAL",
+	"CRITICAL": "FATAL",
+	"PANIC":    "FATAL",
+}
+
+// parseMinLevel validates a minimum level, returning its canonical upper case
+// name, e.g. "warning" becomes "WARN". An empty string disables the minimum.
+func parseMinLevel(s string) (string, error) {
+	level := strings.ToUpper(strings.TrimSpace(s))
+	if level == "" {
+		return "", nil
+	}
+	if _, ok := levelRanks[level]; !ok {
+		return "", fmt.Errorf("invalid minimum level %q", s)
+	}
+	if canonical, ok := levelAliases[level]; ok {
+		level = canonical
+	}
+	return level, nil
+}
+
+// levelsAtLeast returns the known levels, aliases included, at least as
+// severe as min, sorted.
+func levelsAtLeast(min string) []string {
+	var levels []string
+	for level, r := range levelRanks {
+		if r >= levelRanks[min] {
+			levels = append(levels, level)
+		}
+	}
+	sort.Strings(levels)
+	return levels
+}
+
+// levelsBelow returns the known levels less severe than min.
+func levelsBelow(min string) []string {
+	rank, ok := levelRanks[min]
+	if !ok {
+		return nil
+	}
+	var below []string
+	for level, r := range levelRanks {
+		if r < rank {
+			below = append(below, level)
+		}
+	}
+	return below
+}
+
+// belowLevel reports whether a log level is less severe than min.
+func belowLevel(level, min string) bool {
+	if min == "" {
+		return false
+	}
+	r, ok := levelRanks[strings.ToUpper(level)]
+	return ok && r < levelRanks[min]
+}
+
+// streamMinLevel returns the minimum level enforced on a live stream request,
+// or "" when there is none. Clients presenting the admin token receive every
+// level. Browsers can't set headers on WebSocket connections, so live streams
+// also accept the token as a 'token' query parameter.
+func (s *server) streamMinLevel(r *http.Request) string {
+	if s.liveMinLevel == "" || s.isAdmin(r) {
+		return ""
+	}
+	if token := r.URL.Query().Get("token"); s.adminToken != "" && token != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1 {
+		return ""
+	}
+	return s.liveMinLevel
+}
@@ -0,0 +1,174 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestBelowLevel tests level ordering, including unknown levels.
+func TestBelowLevel(t *testing.T) {
+	tests := []struct {
+		level, min string
+		want       bool
+	}{
+		{"debug", "INFO", true},
+		{"TRACE", "INFO", true},
+		{"INFO", "INFO", false},
+		{"warning", "INFO", false},
+		{"AUDIT", "INFO", false}, // unknown levels are never withheld
+		{"DEBUG", "", false},
+	}
+	for _, tt := range tests {
+		if got := belowLevel(tt.level, tt.min); got != tt.want {
+			t.Errorf("belowLevel(%q, %q) = %v, want %v", tt.level, tt.min, got, tt.want)
+		}
+	}
+}
+
+// TestParseMinLevel tests that aliases normalize to canonical levels.
+func TestParseMinLevel(t *testing.T) {
+	for in, want := range map[string]string{
+		"":         "",
+		"info":     "INFO",
+		"Warning":  "WARN",
+		"err":      "ERROR",
+		"critical": "FATAL",
+	} {
+		got, err := parseMinLevel(in)
+		if err != nil || got != want {
+			t.Errorf("parseMinLevel(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := parseMinLevel("loud"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+// TestLoadConfig_LiveStream tests validation of the minimum live stream level.
+func TestLoadConfig_LiveStream(t *testing.T) {
+	cfg, err := loadConfig(writeTestConfig(t, `{"live_stream": {"min_level": "info"}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if level, _ := cfg.liveMinLevel(); level != "INFO" {
+		t.Errorf("expected INFO, got %q", level)
+	}
+
+	if _, err := loadConfig(writeTestConfig(t, `{"live_stream": {"min_level": "chatty"}}`)); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+// TestStreamMinLevel tests that the admin token lifts the minimum level.
+func TestStreamMinLevel(t *testing.T) {
+	srv := &server{liveMinLevel: "INFO", adminToken: "secret"}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/ws", nil)
+	if got := srv.streamMinLevel(req); got != "INFO" {
+		t.Errorf("expected INFO for anonymous client, got %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/ws", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	if got := srv.streamMinLevel(req); got != "" {
+		t.Errorf("expected no minimum with bearer token, got %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/ws?token=secret", nil)
+	if got := srv.streamMinLevel(req); got != "" {
+		t.Errorf("expected no minimum with token parameter, got %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/ws?token=wrong", nil)
+	if got := srv.streamMinLevel(req); got != "INFO" {
+		t.Errorf("expected INFO with wrong token, got %q", got)
+	}
+}
+
+// TestWebSocket_MinLevel tests that anonymous clients don't receive debug logs
+// while privileged clients do.
+func TestWebSocket_MinLevel(t *testing.T) {
+	srv := newTestServerWithHub(t)
+	srv.liveMinLevel = "INFO"
+	srv.adminToken = "secret"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ws", srv.handleWebSocket)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws"
+	public, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer public.Close()
+	privileged, _, err := websocket.DefaultDialer.Dial(wsURL+"?token=secret", nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer privileged.Close()
+
+	time.Sleep(50 * time.Millisecond)
+
+	srv.hub.broadcastLogs([]models.Log{{Service: "svc", Level: "DEBUG", Message: "verbose"}})
+	srv.hub.broadcastLogs([]models.Log{
+		{Service: "svc", Level: "DEBUG", Message: "verbose"},
+		{Service: "svc", Level: "ERROR", Message: "boom"},
+	})
+
+	read := func(conn *websocket.Conn) []models.Log {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read message: %v", err)
+		}
+		var logs []models.Log
+		json.Unmarshal(message, &logs)
+		return logs
+	}
+
+	// The debug-only batch is skipped entirely for the public client
+	if logs := read(public); len(logs) != 1 || logs[0].Level != "ERROR" {
+		t.Errorf("expected only the error log, got %+v", logs)
+	}
+	if logs := read(privileged); len(logs) != 1 || logs[0].Level != "DEBUG" {
+		t.Errorf("expected the debug-only batch, got %+v", logs)
+	}
+	if logs := read(privileged); len(logs) != 2 {
+		t.Errorf("expected both logs, got %+v", logs)
+	}
+}
+
+// TestHandlePoll_MinLevel tests that polling enforces the minimum level.
+func TestHandlePoll_MinLevel(t *testing.T) {
+	srv := newTestServer(t)
+	srv.liveMinLevel = "INFO"
+	srv.adminToken = "secret"
+	for _, level := range []string{"INFO", "debug", "INFO", "ERROR"} {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: level, Message: "m", Host: "h"})
+	}
+
+	rr := httptest.NewRecorder()
+	srv.handlePoll(rr, httptest.NewRequest(http.MethodGet, "/api/poll?since_id=1&wait=0s", nil))
+	if resp := decodePoll(t, rr); len(resp.Logs) != 2 || resp.LastID != 4 {
+		t.Errorf("expected the info and error logs, got %+v", resp)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/poll?since_id=1&wait=0s", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	srv.handlePoll(rr, req)
+	if resp := decodePoll(t, rr); len(resp.Logs) != 3 {
+		t.Errorf("expected all 3 logs for the admin, got %+v", resp.Logs)
+	}
+}
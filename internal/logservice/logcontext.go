@@ -0,0 +1,106 @@
+package logservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"locog/internal/models"
+)
+
+const (
+	// defaultContextLogs is the number of neighbors returned on each side
+	defaultContextLogs = 20
+	// maxContextLogs bounds before and after
+	maxContextLogs = 500
+)
+
+// handleGetLog returns one log by ID: GET /api/logs/{id}.
+func (s *server) handleGetLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	l, ok := s.lookupLog(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l)
+}
+
+// handleLogContext returns a log with the logs just before and after it from
+// the same service and host, e.g. GET /api/logs/{id}/context?before=20&after=20,
+// to see what led up to an error.
+func (s *server) handleLogContext(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	counts := map[string]int{"before": defaultContextLogs, "after": defaultContextLogs}
+	for name := range counts {
+		if v := r.URL.Query().Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > maxContextLogs {
+				writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid "+name+" value",
+					fmt.Sprintf("'%s' must be between 0 and %d, got: %s", name, maxContextLogs, v))
+				return
+			}
+			counts[name] = n
+		}
+	}
+
+	l, ok := s.lookupLog(w, r)
+	if !ok {
+		return
+	}
+	filter := models.LogFilter{Service: l.Service, Host: l.Host, ExcludeStreams: s.hiddenStreams(r)}
+	before, after, err := s.db.LogContext(r.Context(), filter, *l, counts["before"], counts["after"])
+	if err != nil {
+		if clientGone(w, r) {
+			return
+		}
+		slog.Error("log context query failed", "error", err, "id", l.ID)
+		writeJSONError(w, http.StatusInternalServerError, "query_failed",
+			"Query failed", "An internal error occurred while querying logs")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.LogContext{Log: *l, Before: before, After: after})
+}
+
+// lookupLog fetches the log named by the {id} path value, writing the error
+// response when it is invalid, missing or in a stream or service the request
+// may not read.
+func (s *server) lookupLog(w http.ResponseWriter, r *http.Request) (*models.Log, bool) {
+	var access models.LogFilter
+	if !s.restrictFilter(w, r, &access) {
+		return nil, false
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid log ID",
+			fmt.Sprintf("log IDs are positive integers, got: %s", r.PathValue("id")))
+		return nil, false
+	}
+	l, err := s.db.GetLog(r.Context(), id)
+	if err != nil {
+		if clientGone(w, r) {
+			return nil, false
+		}
+		slog.Error("failed to get log", "error", err, "id", id)
+		writeJSONError(w, http.StatusInternalServerError, "query_failed",
+			"Query failed", "An internal error occurred while querying logs")
+		return nil, false
+	}
+	// Logs the request may not read are reported as missing, like in queries
+	if l == nil || !readable(access, l) {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Log not found", "")
+		return nil, false
+	}
+	return l, true
+}
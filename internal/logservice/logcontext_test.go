@@ -0,0 +1,65 @@
+package logservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestHandleLogContext(t *testing.T) {
+	srv := newTestServer(t)
+	handler, err := srv.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: base.Add(time.Duration(i) * time.Second), Service: "api", Level: "INFO",
+			Message: fmt.Sprintf("m%d", i), Host: "h"})
+	}
+	// IDs follow insertion order
+	ids := []int64{1, 2, 3, 4, 5}
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	rr := get(fmt.Sprintf("/api/logs/%d", ids[2]))
+	var l models.Log
+	json.NewDecoder(rr.Body).Decode(&l)
+	if rr.Code != http.StatusOK || l.Message != "m2" {
+		t.Fatalf("expected log m2, got %d %+v", rr.Code, l)
+	}
+
+	rr = get(fmt.Sprintf("/api/logs/%d/context?before=1", ids[2]))
+	var lc models.LogContext
+	json.NewDecoder(rr.Body).Decode(&lc)
+	if rr.Code != http.StatusOK || lc.Log.ID != ids[2] || len(lc.Before) != 1 || lc.Before[0].Message != "m1" ||
+		len(lc.After) != 2 || lc.After[0].Message != "m3" {
+		t.Errorf("unexpected context %d %+v", rr.Code, lc)
+	}
+
+	// Other /api/logs routes still take precedence
+	if rr := get("/api/logs/count"); rr.Code != http.StatusOK {
+		t.Errorf("expected /api/logs/count to still work, got %d", rr.Code)
+	}
+
+	for path, want := range map[string]int{
+		"/api/logs/999999":         http.StatusNotFound,
+		"/api/logs/abc":            http.StatusBadRequest,
+		"/api/logs/999999/context": http.StatusNotFound,
+		fmt.Sprintf("/api/logs/%d/context?after=501", ids[0]): http.StatusBadRequest,
+		fmt.Sprintf("/api/logs/%d/context?before=-1", ids[0]): http.StatusBadRequest,
+	} {
+		if rr := get(path); rr.Code != want {
+			t.Errorf("%s: expected status %d, got %d", path, want, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,1529 @@
+package logservice
+
+import (
+	"context"
+	"crypto/tls"
+	"embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"locog/internal/alerts"
+	"locog/internal/db"
+	"locog/internal/logtext"
+	"locog/internal/models"
+	"locog/internal/tracing"
+)
+
+//go:embed static/*
+var staticFiles embed.FS
+
+// server holds the application dependencies
+type server struct {
+	db      db.Store
+	limiter *ipRateLimiter
+	hub     *wsHub
+
+	// endpointLimiters are the limiters of endpoints with their own rate
+	// limit; others use limiter
+	endpointLimiters map[string]*ipRateLimiter
+	// limiterIdleTTL is how long idle client addresses keep their rate
+	// limiters; zero keeps them
+	limiterIdleTTL time.Duration
+
+	// unknownFields is the default handling of unknown JSON fields on ingest,
+	// and unknownFieldKeys the handling for senders presenting a key
+	unknownFields    unknownFieldMode
+	unknownFieldKeys map[string]unknownFieldMode
+
+	// adminToken protects /api/admin endpoints when set
+	adminToken string
+
+	// requireClientCerts makes shipper endpoints require a verified TLS
+	// client certificate (-tls-client-ca)
+	requireClientCerts bool
+
+	// metadataLimits bounds metadata size and shape at ingest
+	metadataLimits metadataLimits
+
+	// arrival tracks how late logs arrive; nil disables tracking
+	arrival *arrivalStats
+
+	// alerts evaluates the configured alert rules; nil when there are none
+	alerts *alerts.Engine
+
+	// tagExports archives tagged logs during cleanup
+	tagExports []tagExport
+
+	// federation queries peer instances; nil when none are configured
+	federation *federation
+
+	// labels are added to the metadata of every ingested log
+	labels map[string]string
+
+	// liveMinLevel withholds less severe logs from unprivileged live streams
+	liveMinLevel string
+	// liveMaxConnections bounds the WebSocket live streams of callers no
+	// access policy matches; 0 is unlimited
+	liveMaxConnections int
+
+	// githubSecret verifies GitHub webhook signatures when set
+	githubSecret string
+
+	// sentryKey is the DSN public key required by the Sentry intake when set
+	sentryKey string
+
+	// generator produces synthetic logs; nil unless -enable-generator is set
+	generator *generator
+
+	// streams route logs to logical streams; empty stores everything in the
+	// default stream
+	streams []logStream
+
+	// exports runs export jobs in the background; nil when disabled
+	exports *exportQueue
+
+	// uploads imports uploaded log files in the background; nil when
+	// disabled
+	uploads *uploadQueue
+
+	// totals counts include_total=async totals in the background; nil counts
+	// them before responding
+	totals *backgroundTotals
+
+	// incidents lift sampling and rate limits for services during an
+	// incident; nil while serving a snapshot
+	incidents *incidentMode
+
+	// parseExceptions structures Go panics, Python tracebacks and Java
+	// exceptions at ingest
+	parseExceptions bool
+
+	// redactor replaces sensitive values at ingest; nil when not configured
+	redactor *redactor
+
+	// regexTimeout bounds /api/logs queries searching by regular expression;
+	// zero disables the limit
+	regexTimeout time.Duration
+
+	// vaultRevealToken is required to reveal vaulted values. It is separate
+	// from the admin token so reveal access can be granted on its own.
+	vaultRevealToken string
+
+	// hostGroups name sets of hosts for host_group= filters
+	hostGroups *hostGroups
+
+	// access restricts the services callers may read; nil when not
+	// configured
+	access *accessPolicies
+
+	// expectedSources are the sources /api/admin/source-health checks
+	expectedSources []expectedSource
+
+	// levels normalizes ingested and queried levels; nil when not configured
+	levels *levelNormalizer
+
+	// levelInference sets the level of ingested logs sent without one; nil
+	// when not configured
+	levelInference *levelInference
+
+	// transforms run configured scripts on ingested logs, in order
+	transforms []*logTransform
+
+	// quotas limit what API tokens and services may ingest; nil when not
+	// configured
+	quotas *ingestQuotas
+
+	// hostNaming sets the host of ingested logs sent without one; nil when
+	// not configured
+	hostNaming *hostNaming
+
+	// tableRetention is how long rows of auxiliary tables are kept; tables
+	// not listed are kept
+	tableRetention map[string]time.Duration
+	// cleanups records what retention cleanups deleted per table
+	cleanups *cleanupStats
+
+	// retention is how long logs are kept and how often they are cleaned up
+	retention *retentionSchedule
+	// cleanupMu is held while a cleanup runs, scheduled or requested
+	cleanupMu sync.Mutex
+
+	// maintain queues database maintenance runs, with their reason, for
+	// maintenanceRoutine; nil on a read-only database
+	maintain chan string
+
+	// backupDir is where /api/admin/backup stages its copy; empty uses the
+	// system temporary directory
+	backupDir string
+
+	// metadataKeysInterval and rollupInterval are how often start's loops
+	// rebuild the metadata keys report and the rollups; zero doesn't
+	metadataKeysInterval time.Duration
+	rollupInterval       time.Duration
+
+	// degraded is set while serving a read-only snapshot in place of a
+	// database that failed to open or verify; nil when healthy
+	degraded *degradedState
+}
+
+func getClientIP(r *http.Request) string {
+	// Check X-Forwarded-For header first (for proxies)
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		// Take the first IP in the list
+		if idx := strings.Index(xff, ","); idx != -1 {
+			return strings.TrimSpace(xff[:idx])
+		}
+		return strings.TrimSpace(xff)
+	}
+	// Fall back to RemoteAddr
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// Service is a log service configured from command line flags: its
+// storage, HTTP handler and background tasks. Main serves it on -addr;
+// locogtest serves its Handler with an httptest.Server.
+type Service struct {
+	srv      *server
+	database db.Store
+	handler  http.Handler
+	lc       *lifecycle
+	tracer   *tracing.Tracer
+
+	// addr and tlsConfig are where and how Main serves the handler
+	addr      string
+	tlsConfig *tls.Config
+	// acmeHandler, with -tls-acme-domains, answers ACME HTTP-01 challenges
+	// on acmeHTTPAddr
+	acmeHandler  http.Handler
+	acmeHTTPAddr string
+}
+
+// usageError is an invalid flag or configuration. Main prints it and exits
+// with status 2, as for flags that don't parse.
+type usageError struct{ error }
+
+func usagef(format string, args ...interface{}) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// errBadFlags is returned by New for flags that don't parse, once the flag
+// set has printed the error and usage.
+var errBadFlags = errors.New("invalid flags")
+
+// New configures a service from command line flags, given without the
+// program name, and opens its storage. Invalid flags and configuration are
+// reported as a usageError. After -help or a one-shot mode (-self-test,
+// -restore or -export-db) has run, New returns a nil Service and error.
+func New(args []string) (svc *Service, err error) {
+	fs := flag.NewFlagSet("logservice", flag.ContinueOnError)
+	dbPath := fs.String("db", "logs.db", "Path to SQLite database")
+	dbKey := fs.String("db-key", os.Getenv("LOCOG_DB_KEY"), "Hex-encoded 32-byte key encrypting the SQLite database; requires a SQLCipher build (default $LOCOG_DB_KEY)")
+	dbKeyFile := fs.String("db-key-file", "", "File holding the hex-encoded -db-key")
+	exportDB := fs.String("export-db", "", "Copy the SQLite database to this new file, encrypted with -export-db-key-file (plain text without it), then exit")
+	exportDBKeyFile := fs.String("export-db-key-file", "", "File holding the hex-encoded key for -export-db")
+	restore := fs.String("restore", "", "Replace the SQLite database with this backup, e.g. from /api/admin/backup, after checking it, then exit; the service must be stopped")
+	backupDir := fs.String("backup-dir", "", "Directory /api/admin/backup stages its copy in (default the system temporary directory)")
+	integrityCheck := fs.String("integrity-check", "off", "Verify the SQLite database at startup: off, quick (PRAGMA quick_check) or full (PRAGMA integrity_check)")
+	dbInUse := fs.String("db-in-use", "fail", "When another process has the SQLite database open for writing: fail, or read-only to serve it without ingest")
+	fallbackSnapshot := fs.String("fallback-snapshot", "", "Backup database file, or directory of *.db snapshots (newest wins), served read-only when the database fails to open or verify")
+	storage := fs.String("storage", "sqlite", "Storage backend: sqlite, postgres, clickhouse, or memory for the last -memory-max-logs logs, kept only in memory")
+	memoryMaxLogs := fs.Int("memory-max-logs", 100000, "Logs kept by -storage=memory, oldest deleted first")
+	dsn := fs.String("dsn", os.Getenv("LOCOG_DSN"), "Connection string for -storage=postgres or clickhouse, e.g. postgres://locog@localhost/locog?sslmode=disable or clickhouse://locog@localhost:9000/locog (default $LOCOG_DSN)")
+	addr := fs.String("addr", ":5081", "HTTP service address")
+	tlsCert := fs.String("tls-cert", "", "PEM certificate (chain) to serve HTTPS with; reloaded when the file changes")
+	tlsKey := fs.String("tls-key", "", "PEM private key of -tls-cert")
+	tlsClientCA := fs.String("tls-client-ca", "", "PEM CA certificates; shipper endpoints then require a client certificate signed by one (requires -tls-cert or -tls-acme-domains)")
+	tlsACMEDomains := fs.String("tls-acme-domains", "", "Comma-separated domains to serve HTTPS for with certificates issued and renewed automatically by an ACME CA (Let's Encrypt unless -tls-acme-directory is set)")
+	tlsACMECache := fs.String("tls-acme-cache", "acme-cache", "Directory ACME account keys and certificates are kept in")
+	tlsACMEEmail := fs.String("tls-acme-email", "", "Contact email registered with the ACME CA, for expiry and problem notices")
+	tlsACMEDirectory := fs.String("tls-acme-directory", "", "ACME directory URL, e.g. Let's Encrypt staging's for testing (default Let's Encrypt)")
+	tlsACMEHTTPAddr := fs.String("tls-acme-http-addr", ":80", "Plain HTTP address answering ACME HTTP-01 challenges and redirecting to HTTPS; empty leaves only TLS-ALPN-01, which needs -addr on port 443")
+	configPath := fs.String("config", "", "Path to an optional JSON configuration file")
+	unknownFields := fs.String("unknown-fields", "ignore", "Handling of unknown JSON fields on ingest: ignore, reject or metadata")
+	dbMaxOpenConns := fs.Int("db-max-open-conns", 0, "Maximum open database connections (0 = twice the CPUs, at least 4)")
+	dbMaxIdleConns := fs.Int("db-max-idle-conns", 0, "Maximum idle database connections kept open (0 = -db-max-open-conns)")
+	backgroundMigrations := fs.Bool("background-migrations", false, "Run startup data migrations in the background while serving requests")
+	metaMaxKeys := fs.Int("metadata-max-keys", 200, "Maximum top-level metadata keys per log (0 = unlimited)")
+	metaMaxDepth := fs.Int("metadata-max-depth", 8, "Maximum metadata nesting depth; deeper values are stored as JSON strings (0 = unlimited)")
+	metaMaxBytes := fs.Int("metadata-max-bytes", 64<<10, "Maximum serialized metadata size per log in bytes (0 = unlimited)")
+	metadataReportInterval := fs.Duration("metadata-report-interval", time.Hour, "How often to rebuild the metadata keys report (0 = disabled)")
+	rollupBuckets := fs.String("rollup-buckets", "", "Comma-separated bucket sizes of the log count rollups behind /api/stats, e.g. 1m,5m,1h (empty = disabled)")
+	rollupMode := fs.String("rollup-mode", "exact", "How rollups count logs: exact, or approximate to count a sample for very high volumes")
+	rollupSampleRate := fs.Int("rollup-sample-rate", 100, "With -rollup-mode=approximate, count one in this many logs and scale up")
+	rollupInterval := fs.Duration("rollup-interval", time.Minute, "How often rollups are brought up to date")
+	labelsFlag := fs.String("labels", "", "Comma-separated key=value labels added to every ingested log's metadata, e.g. env=prod,region=eu")
+	adminToken := fs.String("admin-token", os.Getenv("LOCOG_ADMIN_TOKEN"), "Bearer token required for /api/admin endpoints (default $LOCOG_ADMIN_TOKEN)")
+	githubSecret := fs.String("github-webhook-secret", os.Getenv("LOCOG_GITHUB_WEBHOOK_SECRET"), "Secret used to verify GitHub webhook signatures (default $LOCOG_GITHUB_WEBHOOK_SECRET)")
+	sentryKey := fs.String("sentry-key", os.Getenv("LOCOG_SENTRY_KEY"), "Public key Sentry SDKs must use in their DSN (default $LOCOG_SENTRY_KEY; empty accepts any key)")
+	enableGenerator := fs.Bool("enable-generator", false, "Enable /api/admin/generate for producing synthetic logs")
+	wsFlushInterval := fs.Duration("ws-flush-interval", 50*time.Millisecond, "Coalesce live stream broadcasts within this interval into one WebSocket message (0 = send every batch immediately)")
+	regexTimeout := fs.Duration("regex-search-timeout", 10*time.Second, "Maximum duration of /api/logs queries searching by regular expression (0 = unlimited)")
+	vaultKey := fs.String("vault-key", os.Getenv("LOCOG_VAULT_KEY"), "Hex-encoded 32-byte key encrypting redacted values when redaction.vault is enabled (default $LOCOG_VAULT_KEY)")
+	vaultRevealToken := fs.String("vault-reveal-token", os.Getenv("LOCOG_VAULT_REVEAL_TOKEN"), "Bearer token required to reveal vaulted values; empty disables reveals (default $LOCOG_VAULT_REVEAL_TOKEN)")
+	tracesEndpoint := fs.String("otlp-traces-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"), "OTLP/HTTP URL receiving locog's own trace spans, e.g. http://localhost:4318/v1/traces; empty disables tracing (default $OTEL_EXPORTER_OTLP_TRACES_ENDPOINT)")
+	traceSampleRatio := fs.Float64("trace-sample-ratio", 1, "Fraction of new traces recorded with -otlp-traces-endpoint; requests with a traceparent header follow the caller's decision")
+	exportDir := fs.String("export-dir", filepath.Join(os.TempDir(), "locog-exports"), "Directory export jobs write their files to")
+	exportWorkers := fs.Int("export-workers", 2, "Export jobs run at once under /api/exports (0 = export jobs disabled)")
+	retention := fs.String("retention", "30d", "How long logs are kept before cleanup deletes them, e.g. 14d or 72h (overridden by retention in -config)")
+	cleanupInterval := fs.Duration("cleanup-interval", defaultCleanupInterval, "How often logs past -retention are deleted (overridden by cleanup_interval in -config)")
+	uploadDir := fs.String("upload-dir", filepath.Join(os.TempDir(), "locog-uploads"), "Directory uploaded log files wait in until imported")
+	uploadMaxBytes := fs.Int64("upload-max-bytes", 100<<20, "Largest log file /api/uploads accepts (0 = uploads disabled)")
+	exportTTL := fs.Duration("export-ttl", time.Hour, "How long a finished export job's file can be downloaded")
+	totalTTL := fs.Duration("background-total-ttl", 5*time.Minute, "How long totals counted in the background for /api/logs?include_total=async can be fetched (0 = count before responding)")
+	parseExceptions := fs.Bool("parse-exceptions", false, "Merge Go panics, Python tracebacks and Java exceptions sent a line per log into one log, and store their type and frames under meta.exception")
+	selfTest := fs.Bool("self-test", false, "Exercise ingest, query, WebSocket, cleanup and alerting on a temporary database, then exit (non-zero on failure)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, nil
+		}
+		return nil, errBadFlags
+	}
+
+	unknownMode, err := parseUnknownFieldMode(*unknownFields)
+	if err != nil {
+		return nil, usageError{err}
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return nil, usageError{err}
+	}
+
+	// Labels from the flag override those from the config file
+	flagLabels, err := parseLabels(*labelsFlag)
+	if err != nil {
+		return nil, usageError{err}
+	}
+	labels := make(map[string]string)
+	for k, v := range cfg.Labels {
+		labels[k] = v
+	}
+	for k, v := range flagLabels {
+		labels[k] = v
+	}
+
+	rd, _ := cfg.redactor() // validated by loadConfig
+	if rd != nil && cfg.Redaction.Vault {
+		if rd.vault, err = newVaultCipher(*vaultKey); err != nil {
+			return nil, usagef("redaction.vault %w", err)
+		}
+	}
+	if *vaultRevealToken != "" && *vaultRevealToken == *adminToken {
+		return nil, usagef("-vault-reveal-token must differ from -admin-token")
+	}
+
+	metaLimits := metadataLimits{MaxKeys: *metaMaxKeys, MaxDepth: *metaMaxDepth, MaxBytes: *metaMaxBytes}
+	if err := metaLimits.validate(); err != nil {
+		return nil, usageError{err}
+	}
+
+	if *dbMaxOpenConns < 0 || *dbMaxIdleConns < 0 {
+		return nil, usagef("-db-max-open-conns and -db-max-idle-conns must not be negative")
+	}
+
+	rollups, err := parseRollupConfig(*rollupBuckets, *rollupMode, *rollupSampleRate)
+	if err != nil {
+		return nil, usageError{err}
+	}
+	if *rollupInterval <= 0 {
+		return nil, usagef("-rollup-interval must be positive")
+	}
+
+	if *traceSampleRatio < 0 || *traceSampleRatio > 1 {
+		return nil, usagef("-trace-sample-ratio must be between 0 and 1")
+	}
+
+	dbEncryptionKey, err := readDBKey(*dbKey, *dbKeyFile, "db-key")
+	if err != nil {
+		return nil, usageError{err}
+	}
+	exportKey, err := readDBKey("", *exportDBKeyFile, "export-db-key")
+	if err != nil {
+		return nil, usageError{err}
+	}
+
+	if *integrityCheck != "off" && *integrityCheck != "quick" && *integrityCheck != "full" {
+		return nil, usagef("invalid -integrity-check %q: must be off, quick or full", *integrityCheck)
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return nil, usagef("-tls-cert and -tls-key must be set together")
+	}
+	domains := acmeDomains(*tlsACMEDomains)
+	if len(domains) > 0 && *tlsCert != "" {
+		return nil, usagef("-tls-acme-domains and -tls-cert are mutually exclusive")
+	}
+	if *tlsClientCA != "" && *tlsCert == "" && len(domains) == 0 {
+		return nil, usagef("-tls-client-ca requires -tls-cert and -tls-key, or -tls-acme-domains")
+	}
+	if *dbInUse != "fail" && *dbInUse != "read-only" {
+		return nil, usagef("invalid -db-in-use %q: must be fail or read-only", *dbInUse)
+	}
+
+	derived, _ := cfg.derivedFields() // validated by loadConfig
+	switch *storage {
+	case "sqlite":
+	case "postgres", "clickhouse":
+		if *dsn == "" {
+			return nil, usagef("-storage=%s requires -dsn", *storage)
+		}
+		if dbEncryptionKey != nil || *exportDB != "" || *fallbackSnapshot != "" || *restore != "" {
+			return nil, usagef("-db-key, -export-db, -fallback-snapshot and -restore are only supported with -storage=sqlite")
+		}
+		if len(derived) > 0 {
+			return nil, usagef("derived fields are only supported with -storage=sqlite")
+		}
+	case "memory":
+		if *memoryMaxLogs < 1 {
+			return nil, usagef("-memory-max-logs must be at least 1")
+		}
+		if dbEncryptionKey != nil || *exportDB != "" || *fallbackSnapshot != "" || *restore != "" {
+			return nil, usagef("-db-key, -export-db, -fallback-snapshot and -restore are only supported with -storage=sqlite")
+		}
+		if len(derived) > 0 {
+			return nil, usagef("derived fields are only supported with -storage=sqlite")
+		}
+	default:
+		return nil, usagef("invalid -storage %q: must be sqlite, postgres, clickhouse or memory", *storage)
+	}
+
+	if *selfTest {
+		if err := runSelfTest(cfg); err != nil {
+			return nil, fmt.Errorf("self-test failed: %w", err)
+		}
+		slog.Info("self-test passed")
+		return nil, nil
+	}
+
+	if *restore != "" {
+		if dbEncryptionKey != nil {
+			return nil, usagef("-restore is not supported for encrypted databases; restore a plain backup, then encrypt it with -export-db")
+		}
+		if err := db.Restore(context.Background(), *restore, *dbPath); err != nil {
+			return nil, fmt.Errorf("failed to restore database %s from %s: %w", *dbPath, *restore, err)
+		}
+		slog.Info("database restored", "backup", *restore, "db", *dbPath)
+		return nil, nil
+	}
+
+	opts := db.Options{
+		BackgroundMigrations: *backgroundMigrations,
+		IndexedMetadataKeys:  cfg.IndexedMetadataKeys,
+		EncryptionKey:        dbEncryptionKey,
+		MaxOpenConns:         *dbMaxOpenConns,
+		MaxIdleConns:         *dbMaxIdleConns,
+	}
+	var database db.Store
+	var degraded *degradedState
+	switch *storage {
+	case "postgres":
+		database, err = db.NewPostgres(*dsn, opts)
+	case "clickhouse":
+		database, err = db.NewClickHouse(*dsn, opts)
+	case "memory":
+		database, err = db.NewRing(*memoryMaxLogs)
+		slog.Warn("storing logs in memory only; they are lost on exit", "max_logs", *memoryMaxLogs)
+	default:
+		database, err = db.NewWithOptions(*dbPath, opts)
+		if errors.Is(err, db.ErrInUse) && *dbInUse == "read-only" && *exportDB == "" {
+			database, degraded, err = openInUse(*dbPath, opts, err)
+			if err == nil {
+				slog.Warn("another process is writing to the database; serving it read-only", "db", *dbPath)
+			}
+		}
+	}
+	if err == nil && *integrityCheck != "off" {
+		if err = database.CheckIntegrity(context.Background(), *integrityCheck == "full"); err != nil {
+			database.Close()
+		}
+	}
+	// Another writer doesn't make the database unusable, so it isn't a
+	// reason to serve an older snapshot
+	if err != nil && *fallbackSnapshot != "" && *exportDB == "" && !errors.Is(err, db.ErrInUse) {
+		slog.Error("database failed to open or verify; serving the fallback snapshot read-only", "error", err)
+		database, degraded, err = openFallbackSnapshot(*fallbackSnapshot, opts, err)
+		if err == nil {
+			slog.Warn("serving a read-only snapshot; ingest is disabled", "snapshot", degraded.Snapshot)
+		}
+	}
+	if errors.Is(err, db.ErrInUse) {
+		return nil, fmt.Errorf("another process, e.g. a second locog, is writing to the database; stop it or start with -db-in-use=read-only: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	// The service closes the database on Shutdown
+	defer func() {
+		if svc == nil {
+			database.Close()
+		}
+	}()
+
+	if *exportDB != "" {
+		if err := database.ExportSQLite(context.Background(), *exportDB, exportKey); err != nil {
+			return nil, fmt.Errorf("failed to export database to %s: %w", *exportDB, err)
+		}
+		slog.Info("database exported", "path", *exportDB, "encrypted", exportKey != nil)
+		return nil, nil
+	}
+
+	database.SetDerivedFields(derived)
+
+	var policies []db.TagPolicy
+	var tagExports []tagExport
+	for _, pc := range cfg.TagPolicies {
+		p, _ := pc.policy() // validated by loadConfig
+		policies = append(policies, p)
+		if pc.ExportDir != "" {
+			tmpl, _ := pc.exportTemplate() // validated by loadConfig
+			tagExports = append(tagExports, tagExport{pattern: pc.Tag, dir: pc.ExportDir, template: tmpl})
+		}
+	}
+	database.SetTagPolicies(policies)
+
+	fed, _ := cfg.federation()            // validated by loadConfig
+	liveMinLevel, _ := cfg.liveMinLevel() // validated by loadConfig
+	streams, _ := cfg.streams()           // validated by loadConfig
+	levels, _ := cfg.levelNormalizer()    // validated by loadConfig
+	inference, _ := cfg.levelInference()  // validated by loadConfig
+	hostNaming, _ := cfg.hostNaming()     // validated by loadConfig
+	transforms, _ := cfg.transforms()     // validated by loadConfig
+	quotas, _ := cfg.quotas()             // validated by loadConfig
+	keyModes, _ := cfg.unknownFieldKeys() // validated by loadConfig
+	var liveMaxConnections int
+	if cfg.LiveStream != nil {
+		liveMaxConnections = cfg.LiveStream.MaxConnections
+	}
+	database.SetStreamRetention(streamRetention(streams))
+	database.SetRollups(rollups)
+
+	tableRetention, _ := cfg.tableRetention()   // validated by loadConfig
+	access, _ := cfg.access()                   // validated by loadConfig
+	expectedSources, _ := cfg.expectedSources() // validated by loadConfig
+
+	retentionPeriod, err := parseRetention(*retention)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -retention: %w", err)
+	}
+	retentionPeriod, *cleanupInterval, err = cfg.retention(retentionPeriod, *cleanupInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retention schedule: %w", err)
+	}
+
+	var tracer *tracing.Tracer
+	if *tracesEndpoint != "" {
+		tracer, err = tracing.NewTracer(tracing.Config{
+			Endpoint:       *tracesEndpoint,
+			SampleRatio:    *traceSampleRatio,
+			ServiceName:    "locog",
+			ServiceVersion: version,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to start tracing: %w", err)
+		}
+		tracing.SetDefault(tracer)
+		slog.Info("tracing enabled", "endpoint", *tracesEndpoint, "sample_ratio", *traceSampleRatio)
+	}
+
+	fixedGroups, _ := cfg.hostGroups() // validated by loadConfig
+	groups, err := newHostGroups(context.Background(), fixedGroups, database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load host groups: %w", err)
+	}
+
+	// Per-IP rate limits, 100 requests/sec with a burst of 100 by default
+	limiter, endpointLimiters, limiterIdleTTL, _ := cfg.rateLimits() // validated by loadConfig
+	ingestLimiter := limiter
+	if l, ok := endpointLimiters[endpointIngest]; ok {
+		ingestLimiter = l
+	}
+
+	// A snapshot can't start incidents and may predate their table
+	var incidents *incidentMode
+	if degraded == nil {
+		incidents, err = newIncidentMode(context.Background(), database, ingestLimiter.rate, ingestLimiter.burst)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load incidents: %w", err)
+		}
+	}
+
+	hub := newWSHub()
+	hub.flushInterval = *wsFlushInterval
+
+	srv := &server{
+		db:            database,
+		limiter:       limiter,
+		hub:           hub,
+		unknownFields: unknownMode,
+		adminToken:    *adminToken,
+
+		unknownFieldKeys: keyModes,
+
+		arrival:      newArrivalStats(),
+		tagExports:   tagExports,
+		federation:   fed,
+		labels:       labels,
+		liveMinLevel: liveMinLevel,
+		githubSecret: *githubSecret,
+		sentryKey:    *sentryKey,
+		streams:      streams,
+		incidents:    incidents,
+		redactor:     rd,
+		regexTimeout: *regexTimeout,
+		hostGroups:   groups,
+		access:       access,
+		levels:       levels,
+		degraded:     degraded,
+
+		expectedSources:    expectedSources,
+		liveMaxConnections: liveMaxConnections,
+
+		endpointLimiters: endpointLimiters,
+		limiterIdleTTL:   limiterIdleTTL,
+
+		metadataLimits: metaLimits,
+		levelInference: inference,
+		hostNaming:     hostNaming,
+		transforms:     transforms,
+		quotas:         quotas,
+
+		tableRetention: tableRetention,
+		cleanups:       newCleanupStats(),
+		retention:      newRetentionSchedule(retentionPeriod, *cleanupInterval),
+		backupDir:      *backupDir,
+
+		vaultRevealToken: *vaultRevealToken,
+		parseExceptions:  *parseExceptions,
+
+		metadataKeysInterval: *metadataReportInterval,
+		rollupInterval:       *rollupInterval,
+	}
+	if *enableGenerator {
+		srv.generator = &generator{}
+	}
+	// Exports only read, so they run against a snapshot too
+	if *exportWorkers > 0 {
+		srv.exports, err = newExportQueue(database, *exportDir, *exportWorkers, *exportTTL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up export jobs: %w", err)
+		}
+	}
+	if *uploadMaxBytes > 0 && degraded == nil {
+		srv.uploads, err = newUploadQueue(*uploadDir, *uploadMaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up uploads: %w", err)
+		}
+	}
+	if degraded == nil {
+		srv.maintain = make(chan string, 1)
+	}
+	if *totalTTL > 0 {
+		srv.totals = newBackgroundTotals(*totalTTL)
+	}
+
+	rules, err := srv.alertRules(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alert rules: %w", err)
+	}
+	// A snapshot gets no new logs to alert on and can't be written
+	if len(rules) > 0 && degraded == nil {
+		srv.alerts = alerts.NewEngine(database, rules, srv.arrival.lateness)
+		slog.Info("alert engine started", "rules", len(rules))
+	}
+
+	srv.requireClientCerts = *tlsClientCA != ""
+	handler, err := srv.routes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create static file system: %w", err)
+	}
+	svc = &Service{srv: srv, database: database, handler: handler, lc: newLifecycle(), tracer: tracer, addr: *addr}
+	if *tlsCert != "" {
+		if svc.tlsConfig, err = tlsConfig(*tlsCert, *tlsKey, *tlsClientCA); err != nil {
+			return nil, fmt.Errorf("failed to set up TLS: %w", err)
+		}
+	}
+	if len(domains) > 0 {
+		opts := acmeOptions{domains: domains, cacheDir: *tlsACMECache, email: *tlsACMEEmail, directoryURL: *tlsACMEDirectory}
+		if svc.tlsConfig, svc.acmeHandler, err = acmeTLSConfig(opts, *addr, *tlsClientCA); err != nil {
+			return nil, fmt.Errorf("failed to set up TLS: %w", err)
+		}
+		svc.acmeHTTPAddr = *tlsACMEHTTPAddr
+	}
+	return svc, nil
+}
+
+// Handler returns the service's HTTP handler.
+func (svc *Service) Handler() http.Handler {
+	return svc.handler
+}
+
+// Start runs the service's background loops and WebSocket hub until
+// Shutdown.
+func (svc *Service) Start() {
+	svc.srv.start(svc.lc)
+}
+
+// Shutdown stops what the service runs, last started first, flushes its
+// trace spans and closes its storage.
+func (svc *Service) Shutdown(ctx context.Context) error {
+	err := svc.lc.shutdown(ctx)
+	if svc.tracer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := svc.tracer.Shutdown(ctx); err != nil {
+			slog.Warn("failed to export remaining spans", "error", err)
+		}
+		cancel()
+	}
+	svc.database.Close()
+	return err
+}
+
+// Main runs the service configured by the command line flags args until
+// SIGINT or SIGTERM, and returns the process's exit status.
+func Main(args []string) int {
+	// Initialize structured JSON logger
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	svc, err := New(args)
+	var usage usageError
+	switch {
+	case errors.Is(err, errBadFlags):
+		return 2
+	case errors.As(err, &usage):
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	case err != nil:
+		slog.Error("failed to start", "error", err)
+		return 1
+	case svc == nil:
+		return 0
+	}
+
+	httpServer := &http.Server{
+		Addr:    svc.addr,
+		Handler: svc.handler,
+	}
+	// Accept cleartext HTTP/2 (h2c) alongside HTTP/1 for OTLP/gRPC exporters,
+	// and HTTP/2 over TLS
+	httpServer.Protocols = new(http.Protocols)
+	httpServer.Protocols.SetHTTP1(true)
+	httpServer.Protocols.SetUnencryptedHTTP2(true)
+	serve := httpServer.ListenAndServe
+	if svc.tlsConfig != nil {
+		httpServer.Protocols.SetHTTP2(true)
+		httpServer.TLSConfig = svc.tlsConfig
+		// The certificate comes from TLSConfig.GetCertificate
+		serve = func() error { return httpServer.ListenAndServeTLS("", "") }
+	}
+
+	// The HTTP server starts last so it stops first. Shutdown waits for
+	// in-flight requests but not for hijacked WebSocket connections, so the
+	// hub stops afterwards: it delivers the logs those requests broadcast,
+	// then closes its clients.
+	svc.Start()
+	if svc.acmeHTTPAddr != "" {
+		challenges := &http.Server{Addr: svc.acmeHTTPAddr, Handler: svc.acmeHandler}
+		slog.Info("serving ACME HTTP-01 challenges", "addr", svc.acmeHTTPAddr)
+		svc.lc.startHTTP(challenges, challenges.ListenAndServe)
+	}
+	slog.Info("log service starting", "addr", svc.addr, "version", version, "tls", svc.tlsConfig != nil, "client_certs", svc.srv.requireClientCerts)
+	svc.lc.startHTTP(httpServer, serve)
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	runErr := svc.lc.wait(signals)
+	stopSignals()
+	if runErr != nil {
+		slog.Error("service failed", "error", runErr)
+	}
+
+	slog.Info("shutting down gracefully")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := svc.Shutdown(ctx); err != nil {
+		slog.Error("shutdown error", "error", err)
+	}
+	cancel()
+	if runErr != nil {
+		return 1
+	}
+	slog.Info("server stopped")
+	return 0
+}
+
+// routes returns the service's HTTP handler.
+func (s *server) routes() (http.Handler, error) {
+	mux := http.NewServeMux()
+
+	// Ingestion endpoint (used by Vector)
+	mux.HandleFunc("/api/ingest", s.requireClientCert(s.requireWritable(s.handleIngest)))
+
+	// Shipper liveness, for sources with nothing to send
+	mux.HandleFunc("/api/heartbeat", s.requireClientCert(s.requireWritable(s.handleHeartbeat)))
+
+	// CI webhooks (GitHub Actions)
+	mux.HandleFunc("/api/ingest/github", s.requireWritable(s.handleGitHubWebhook))
+
+	// Sentry SDK intake (DSN http://<key>@host:port/<project>). POST only, so
+	// the wildcard project doesn't overlap the GET /api/logs/{id}/... routes.
+	mux.HandleFunc("POST /api/{project}/store/", s.requireWritable(s.handleSentry))
+	mux.HandleFunc("POST /api/{project}/envelope/", s.requireWritable(s.handleSentry))
+
+	// Elasticsearch bulk API subset, for shippers such as Filebeat
+	mux.HandleFunc("GET /es/{$}", s.handleElasticInfo)
+	mux.HandleFunc("POST /es/_bulk", s.requireClientCert(s.requireWritable(s.handleElasticBulk)))
+	mux.HandleFunc("POST /es/{index}/_bulk", s.requireClientCert(s.requireWritable(s.handleElasticBulk)))
+
+	// OpenTelemetry logs receivers (OTLP/HTTP and OTLP/gRPC)
+	mux.HandleFunc("/v1/logs", s.requireClientCert(s.requireWritable(s.handleOTLPLogs)))
+	mux.HandleFunc(otlpGRPCExportPath, s.requireClientCert(s.requireWritable(s.handleOTLPGRPC)))
+
+	// WebSocket endpoint for real-time log streaming
+	mux.HandleFunc("/api/ws", s.handleWebSocket)
+
+	// Long-poll fallback for clients that can't use WebSockets
+	mux.HandleFunc("/api/poll", s.handlePoll)
+
+	// Query endpoints (used by Web UI)
+	mux.HandleFunc("/api/logs", compressResponse(s.handleQueryLogs))
+	mux.HandleFunc("/api/logs/count", s.handleCountLogs)
+	mux.HandleFunc("/api/logs/export", compressResponse(s.handleExportLogs))
+	mux.HandleFunc("/api/exports", s.handleExports)
+	mux.HandleFunc("/api/exports/{id}", s.handleExportJob)
+	mux.HandleFunc("/api/uploads", s.requireWritable(s.handleUploads))
+	mux.HandleFunc("GET /api/uploads/{id}", s.handleUploadJob)
+	mux.HandleFunc("GET /api/exports/{id}/download", compressResponse(s.handleExportDownload))
+	mux.HandleFunc("/api/logs/{id}", s.handleGetLog)
+	mux.HandleFunc("GET /api/logs/{id}/context", s.handleLogContext)
+	mux.HandleFunc("/api/filters", s.handleGetFilters)
+	mux.HandleFunc("/api/suggest", s.handleSuggest)
+	mux.HandleFunc("/api/aggregate", s.handleAggregate)
+	mux.HandleFunc("/api/logs/aggregate", s.handleAggregateLogs)
+	mux.HandleFunc("/api/metadata/keys", s.handleMetadataKeys)
+	mux.HandleFunc("/api/diff", s.handleDiff)
+	mux.HandleFunc("/api/top-errors", s.handleTopErrors)
+	mux.HandleFunc("/api/availability", s.handleAvailability)
+	mux.HandleFunc("/api/stats", compressResponse(s.handleStats))
+	mux.HandleFunc("GET /api/stats/quotas", s.handleQuotaStats)
+	mux.HandleFunc("/api/estimate", s.handleEstimate)
+	mux.HandleFunc("/api/alerts", s.handleAlerts)
+	mux.HandleFunc("/api/alerts/backtest", s.handleAlertBacktest)
+	mux.HandleFunc("/api/capabilities", s.handleCapabilities)
+	mux.HandleFunc("/api/prefs", s.handlePrefs)
+	mux.HandleFunc("/api/prefs/{key}", s.requireWritable(s.handlePrefs))
+	mux.HandleFunc("/api/vault/reveal", s.requireWritable(s.handleVaultReveal))
+
+	// Admin endpoints
+	mux.HandleFunc("/api/admin/migration-status", s.requireAdmin(s.handleMigrationStatus))
+	mux.HandleFunc("/api/admin/arrival-stats", s.requireAdmin(s.handleArrivalStats))
+	mux.HandleFunc("/api/admin/tags", s.requireAdmin(s.requireWritable(s.handleTagLogs)))
+	mux.HandleFunc("/api/admin/generate", s.requireAdmin(s.requireWritable(s.handleGenerate)))
+	mux.HandleFunc("/api/admin/retention", s.requireAdmin(s.handleRetention))
+	mux.HandleFunc("/api/admin/retention/preview", s.requireAdmin(s.handleRetentionPreview))
+	mux.HandleFunc("/api/admin/retention/stats", s.requireAdmin(s.handleRetentionStats))
+	mux.HandleFunc("/api/admin/cleanup", s.requireAdmin(s.requireWritable(s.handleCleanup)))
+	mux.HandleFunc("/api/admin/cleanup/preview", s.requireAdmin(s.handleCleanupPreview))
+	mux.HandleFunc("/api/admin/backup", s.requireAdmin(s.handleBackup))
+	mux.HandleFunc("GET /api/admin/transforms", s.requireAdmin(s.handleTransforms))
+	mux.HandleFunc("POST /api/admin/transforms/test", s.requireAdmin(s.handleTransformTest))
+	mux.HandleFunc("GET /api/admin/archives/verify", s.requireAdmin(s.handleVerifyArchives))
+	mux.HandleFunc("GET /api/admin/maintenance", s.requireAdmin(s.handleMaintenanceStatus))
+	mux.HandleFunc("POST /api/admin/maintenance", s.requireAdmin(s.requireWritable(s.handleMaintenance)))
+	mux.HandleFunc("GET /api/admin/live-streams", s.requireAdmin(s.handleLiveStreams))
+	mux.HandleFunc("/api/admin/vault/reveals", s.requireAdmin(s.handleVaultReveals))
+	mux.HandleFunc("/api/admin/sources", s.requireAdmin(s.handleSources))
+	mux.HandleFunc("/api/admin/source-health", s.requireAdmin(s.handleSourceHealth))
+	mux.HandleFunc("/api/admin/host-groups", s.requireAdmin(s.handleHostGroups))
+	mux.HandleFunc("/api/admin/host-groups/{name}", s.requireAdmin(s.requireWritable(s.handleHostGroups)))
+	mux.HandleFunc("/api/admin/incidents", s.requireAdmin(s.requireWritable(s.handleIncidents)))
+	mux.HandleFunc("/api/admin/incidents/{id}", s.requireAdmin(s.requireWritable(s.handleIncidents)))
+
+	// Health check
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+	// Readiness: 503 while serving a read-only snapshot
+	mux.HandleFunc("/readyz", s.handleReadyz)
+
+	// Serve embedded static files (Web UI)
+	staticFS, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		return nil, err
+	}
+	mux.Handle("/", http.FileServer(http.FS(staticFS)))
+
+	return corsMiddleware(traceMiddleware(mux)), nil
+}
+
+// corsMiddleware adds CORS headers to responses
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxBodySize is the maximum allowed request body size (10MB)
+const maxBodySize = 10 << 20
+
+func (s *server) handleIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check rate limit. During an incident, requests over it are read to see
+	// whether they carry logs of the incident's services.
+	ip := getClientIP(r)
+	limited := s.checkRateLimit(endpointIngest, ip)
+	if limited != nil && !s.incidents.active(time.Now()) {
+		writeRateLimited(w, limited)
+		return
+	}
+
+	// Read the body, limited in size and decompressed per Content-Encoding
+	bodyBytes, err := readBody(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mode, err := s.ingestUnknownFieldMode(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Support both single log and batch
+	logs, err := decodeLogs(bodyBytes, mode)
+	if err != nil {
+		slog.Warn("failed to decode ingest body", "sender", ip, "mode", mode, "reason", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Validate required fields, reporting the first invalid entry. Logs
+	// without a host or level get one first where host naming or level
+	// inference is configured.
+	s.nameHosts(r, logs)
+	invalid := make([]error, len(logs))
+	forEachLog(len(logs), func(i int) {
+		s.levelInference.infer(&logs[i])
+		invalid[i] = validateLog(&logs[i])
+	})
+	for i, err := range invalid {
+		if err != nil {
+			// Marshal the invalid log entry for debugging (truncate if too large)
+			logJSON, _ := json.Marshal(logs[i])
+			logBody := string(logJSON)
+			if len(logBody) > 500 {
+				logBody = logBody[:500] + "... (truncated)"
+			}
+
+			slog.Warn("invalid log entry",
+				"sender", ip,
+				"index", i,
+				"total_logs", len(logs),
+				"reason", err.Error(),
+				"log_body", logBody,
+			)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if limited != nil && !s.incidents.allowOverLimit(ip, logs) {
+		writeRateLimited(w, limited)
+		return
+	}
+	refund, limited := s.checkQuotas(r, logs)
+	if limited != nil {
+		writeRateLimited(w, limited)
+		return
+	}
+
+	if err := s.storeLogs(r.Context(), logs, ip); err != nil {
+		refund()
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// storeLogs sets defaults on validated logs, normalizes their metadata, stores
+// them and notifies live clients. It is shared by every ingest protocol.
+func (s *server) storeLogs(ctx context.Context, logs []models.Log, sender string) error {
+	if s.parseExceptions {
+		logs = structureExceptions(logs)
+	}
+	// Normalize levels first so streams route on the canonical ones, and
+	// transforms see them
+	if s.levels != nil {
+		for i := range logs {
+			logs[i].Level = s.levels.normalize(logs[i].Level)
+		}
+	}
+	logs = s.transformLogs(logs)
+	logs = s.routeLogs(logs)
+	if len(logs) == 0 {
+		return nil
+	}
+
+	ctx, span := tracing.Start(ctx, "ingest.store", tracing.KindInternal,
+		tracing.Int("ingest.logs", len(logs)), tracing.String("ingest.sender", sender))
+	defer span.End()
+
+	received := time.Now()
+	_, prepareSpan := tracing.Start(ctx, "ingest.prepare", tracing.KindInternal)
+	stamped, vaulted, err := s.prepareLogs(logs, received, sender)
+	prepareSpan.RecordError(err)
+	prepareSpan.End()
+	if err != nil {
+		return err
+	}
+
+	// Vault originals before the logs referencing them
+	if len(vaulted) > 0 {
+		if err := s.db.StoreVaultEntries(ctx, vaulted); err != nil {
+			slog.Error("failed to store vault entries", "error", err, "count", len(vaulted))
+			return err
+		}
+	}
+
+	// Batch insert for better performance
+	if len(logs) > 1 {
+		if err := s.db.InsertBatch(ctx, logs); err != nil {
+			slog.Error("failed to insert batch", "error", err, "count", len(logs))
+			return err
+		}
+	} else if len(logs) == 1 {
+		if err := s.db.InsertLog(ctx, &logs[0]); err != nil {
+			slog.Error("failed to insert log", "error", err)
+			return err
+		}
+	}
+
+	// Uploaded files are old logs read now; their lag says nothing about
+	// how late their sources ship
+	if s.arrival != nil && sender != uploadSender {
+		for i := range logs {
+			if stamped[i] {
+				s.arrival.record(logs[i].Service, logs[i].Timestamp, received)
+			}
+		}
+	}
+
+	// Broadcast new logs to WebSocket clients
+	if s.hub != nil {
+		_, broadcastSpan := tracing.Start(ctx, "ws.broadcast", tracing.KindInternal)
+		s.hub.broadcastLogs(logs)
+		broadcastSpan.End()
+	}
+	return nil
+}
+
+// prepareLogs redacts each log, sets its timestamp if missing, normalizes its
+// metadata and applies labels, on a worker pool for large batches. It reports
+// which logs carried their own timestamp and returns the vault entries of
+// redacted values.
+func (s *server) prepareLogs(logs []models.Log, received time.Time, sender string) ([]bool, []db.VaultEntry, error) {
+	stamped := make([]bool, len(logs))
+	vaulted := make([][]db.VaultEntry, len(logs))
+	failed := make([]error, len(logs))
+	forEachLog(len(logs), func(i int) {
+		// Redact first so originals never reach storage or live clients
+		if s.redactor != nil {
+			if vaulted[i], failed[i] = s.redactor.redact(&logs[i], received); failed[i] != nil {
+				return
+			}
+		}
+
+		// Set timestamp if not provided
+		if logs[i].Timestamp.IsZero() {
+			logs[i].Timestamp = received
+		} else {
+			stamped[i] = true
+		}
+
+		var truncated bool
+		logs[i].Metadata, truncated = s.metadataLimits.normalize(logs[i].Metadata)
+		if truncated {
+			slog.Debug("metadata exceeded limits and was normalized",
+				"sender", sender, "service", logs[i].Service, "marker", logs[i].Metadata[truncatedMarkerKey])
+		}
+
+		// Labels are applied after the limits so they are never dropped
+		s.applyLabels(&logs[i])
+	})
+
+	var entries []db.VaultEntry
+	for i, err := range failed {
+		if err != nil {
+			slog.Error("failed to redact log", "error", err)
+			return nil, nil, err
+		}
+		entries = append(entries, vaulted[i]...)
+	}
+	return stamped, entries, nil
+}
+
+// nameHosts sets the host of the logs sent in r without one, when host
+// naming is configured.
+func (s *server) nameHosts(r *http.Request, logs []models.Log) {
+	hosts := s.hostNaming.forRequest(r)
+	for i := range logs {
+		hosts.name(&logs[i])
+	}
+}
+
+// apiError is a structured JSON error response for API endpoints.
+type apiError struct {
+	Error   string `json:"error"`
+	Code    string `json:"code"`
+	Details string `json:"details,omitempty"`
+}
+
+func writeJSONError(w http.ResponseWriter, status int, code, message, details string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Error: message, Code: code, Details: details})
+}
+
+func (s *server) handleQueryLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, ok := s.parseLogFilter(w, r)
+	if !ok {
+		return
+	}
+
+	fields, apiErr := parseProjection(&filter, r.URL.Query())
+	if apiErr != nil {
+		writeJSONError(w, http.StatusBadRequest, apiErr.Code, apiErr.Error, apiErr.Details)
+		return
+	}
+
+	includeSparkline := false
+	if v := r.URL.Query().Get("include_sparkline"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+				"Invalid include_sparkline value",
+				fmt.Sprintf("'include_sparkline' must be true or false, got: %s", v))
+			return
+		}
+		includeSparkline = b
+	}
+
+	sparklineBuckets := defaultSparklineBuckets
+	if v := r.URL.Query().Get("sparkline_buckets"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSparklineBuckets {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+				"Invalid sparkline_buckets value",
+				fmt.Sprintf("'sparkline_buckets' must be an integer between 1 and %d, got: %s", maxSparklineBuckets, v))
+			return
+		}
+		sparklineBuckets = n
+	}
+
+	// include_total=async returns the first page before counting the total
+	includeTotal, asyncTotal := false, false
+	if v := r.URL.Query().Get("include_total"); v == "async" {
+		includeTotal, asyncTotal = true, true
+	} else if v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+				"Invalid include_total value",
+				fmt.Sprintf("'include_total' must be true, false or async, got: %s", v))
+			return
+		}
+		includeTotal = b
+	}
+
+	federated := false
+	if v := r.URL.Query().Get("federated"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+				"Invalid federated value",
+				fmt.Sprintf("'federated' must be true or false, got: %s", v))
+			return
+		}
+		federated = b
+	}
+	if federated && s.federation == nil {
+		writeJSONError(w, http.StatusBadRequest, "federation_disabled",
+			"Federation is not configured", "Add peers under 'federation' in the config file")
+		return
+	}
+	if federated && includeSparkline {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+			"Sparklines are not supported for federated queries", "")
+		return
+	}
+	if federated && includeTotal {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+			"Totals are not supported for federated queries", "")
+		return
+	}
+	// Log IDs are those of one instance
+	if federated && (filter.MaxID > 0 || filter.Cursor != nil || r.URL.Query().Has("snapshot")) {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+			"Snapshots and cursors are not supported for federated queries", "")
+		return
+	}
+	if !s.pinSnapshot(w, r, &filter) {
+		return
+	}
+
+	textTmpl, ok := parseOutputFormat(w, r)
+	if !ok {
+		return
+	}
+	if textTmpl != nil && includeSparkline {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+			"Sparklines are not supported for text output", "")
+		return
+	}
+	if textTmpl != nil && fields != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+			"Fields are not supported for text output", "Select fields with 'template' instead")
+		return
+	}
+
+	// Warn when query falls outside the retention window
+	retentionPeriod := s.retention.retention()
+	retentionCutoff := time.Now().Add(-retentionPeriod)
+	if filter.EndTime != nil && filter.EndTime.Before(retentionCutoff) {
+		w.Header().Set("X-Locog-Warning", fmt.Sprintf(
+			"Query end date is beyond the %s retention window. Logs older than %[1]s are automatically deleted.",
+			formatRetention(retentionPeriod)))
+		slog.Info("query entirely outside retention window",
+			"end", filter.EndTime.Format(time.RFC3339),
+			"retention_cutoff", retentionCutoff.Format(time.RFC3339))
+	} else if filter.StartTime != nil && filter.StartTime.Before(retentionCutoff) {
+		w.Header().Set("X-Locog-Warning", fmt.Sprintf(
+			"Query start date is beyond the %s retention window. Results will only include logs from %s onwards.",
+			formatRetention(retentionPeriod), retentionCutoff.Format("2006-01-02")))
+		slog.Info("query partially outside retention window",
+			"start", filter.StartTime.Format(time.RFC3339),
+			"retention_cutoff", retentionCutoff.Format(time.RFC3339))
+	}
+
+	// Fetch one extra row to tell a complete result from a truncated one
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = db.DefaultQueryLimit
+	}
+	ctx, cancel := s.searchContext(r, filter)
+	defer cancel()
+
+	start := time.Now()
+	probe := filter
+	probe.Limit = limit + 1
+	probe.OmitMetadata = fields != nil && !slices.Contains(fields, "metadata")
+	logs, err := s.db.QueryLogs(ctx, probe)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		s.writeSearchTimeout(w)
+		return
+	}
+	if err != nil {
+		if clientGone(w, r) {
+			return
+		}
+		slog.Error("query failed", "error", err, "filter", filter)
+		writeJSONError(w, http.StatusInternalServerError, "query_failed",
+			"Query failed", "An internal error occurred while querying logs")
+		return
+	}
+
+	truncation := resultTruncation{estimate: int64(len(logs))}
+	totalToken := ""
+	if len(logs) > limit {
+		logs = logs[:limit]
+		if !federated {
+			w.Header().Set(nextCursorHeader, formatCursor(logs[limit-1]))
+		}
+		if asyncTotal {
+			countFilter := filter
+			totalToken = s.totals.start(r.Context(), func(ctx context.Context) (int64, error) {
+				return s.db.CountLogs(ctx, countFilter)
+			})
+		}
+		var total int64
+		if includeTotal && totalToken == "" {
+			total, err = s.db.CountLogs(ctx, filter)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				s.writeSearchTimeout(w)
+				return
+			}
+			if err != nil {
+				if clientGone(w, r) {
+					return
+				}
+				slog.Error("count failed", "error", err, "filter", filter)
+				writeJSONError(w, http.StatusInternalServerError, "query_failed",
+					"Query failed", "An internal error occurred while counting logs")
+				return
+			}
+		} else if total, err = s.db.CountLogsUpTo(ctx, filter, maxTotalEstimate); err != nil {
+			if clientGone(w, r) {
+				return
+			}
+			slog.Warn("failed to estimate result total", "error", err)
+		}
+		truncation = resultTruncation{truncated: true, estimate: total}
+	}
+	if totalToken != "" {
+		w.Header().Set(totalTokenHeader, totalToken)
+	} else if includeTotal {
+		// A result within the limit is its own total
+		w.Header().Set(totalCountHeader, strconv.FormatInt(truncation.estimate, 10))
+	}
+
+	if federated {
+		var peerTruncation resultTruncation
+		logs, peerTruncation = s.federate(w, r, logs, limit, filter)
+		truncation.merge(peerTruncation)
+	}
+	truncation.setHeaders(w)
+
+	var sparkline *models.Sparkline
+	if includeSparkline {
+		sparkline, err = s.buildSparkline(r.Context(), filter, logs, sparklineBuckets)
+		if err != nil {
+			if clientGone(w, r) {
+				return
+			}
+			slog.Error("sparkline query failed", "error", err, "filter", filter)
+			writeJSONError(w, http.StatusInternalServerError, "query_failed",
+				"Query failed", "An internal error occurred while building the sparkline")
+			return
+		}
+	}
+	setQueryHeaders(w, start, len(logs))
+
+	// Large results take a while to encode and send; time that apart
+	_, encode := tracing.Start(r.Context(), "query.encode", tracing.KindInternal, tracing.Int("query.logs", len(logs)))
+	defer encode.End()
+
+	if textTmpl != nil {
+		encode.SetAttributes(tracing.String("query.format", "text"))
+		// Oldest first, like a log file
+		if filter.SinceID == 0 && !filter.Ascending {
+			slices.Reverse(logs)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := logtext.Write(w, textTmpl, logs); err != nil {
+			slog.Warn("failed to render text logs", "error", err)
+		}
+		return
+	}
+
+	encode.SetAttributes(tracing.String("query.format", "json"))
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case !includeSparkline && fields != nil:
+		json.NewEncoder(w).Encode(projectLogs(logs, fields))
+	case !includeSparkline:
+		json.NewEncoder(w).Encode(logs)
+	case fields != nil:
+		json.NewEncoder(w).Encode(struct {
+			Logs      []map[string]interface{} `json:"logs"`
+			Sparkline *models.Sparkline        `json:"sparkline,omitempty"`
+		}{projectLogs(logs, fields), sparkline})
+	default:
+		if logs == nil {
+			logs = []models.Log{}
+		}
+		json.NewEncoder(w).Encode(models.QueryResponse{Logs: logs, Sparkline: sparkline})
+	}
+}
+
+const (
+	defaultSparklineBuckets = 30
+	maxSparklineBuckets     = 200
+)
+
+// buildSparkline computes a histogram over the queried range. Open-ended ranges
+// are bounded by the oldest returned log and the current time.
+func (s *server) buildSparkline(ctx context.Context, filter models.LogFilter, logs []models.Log, buckets int) (*models.Sparkline, error) {
+	end := time.Now()
+	if filter.EndTime != nil {
+		end = *filter.EndTime
+	}
+	start := end
+	if filter.StartTime != nil {
+		start = *filter.StartTime
+	} else if len(logs) > 0 {
+		// Results are ordered newest first unless ascending
+		start = logs[len(logs)-1].Timestamp
+		if filter.Ascending {
+			start = logs[0].Timestamp
+		}
+	}
+
+	counts, err := s.db.Histogram(ctx, filter, start, end, buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Sparkline{
+		Start:         start,
+		End:           end,
+		BucketSeconds: end.Sub(start).Seconds() / float64(buckets),
+		Counts:        counts,
+	}, nil
+}
+
+func (s *server) handleGetFilters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var access models.LogFilter
+	if !s.restrictFilter(w, r, &access) {
+		return
+	}
+
+	start := time.Now()
+	options, err := s.db.GetFilterOptions(r.Context())
+	duration := time.Since(start)
+	if err != nil {
+		slog.Error("failed to get filter options", "error", err, "duration_ms", duration.Milliseconds())
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if duration > 500*time.Millisecond {
+		slog.Warn("slow filter options response", "duration_ms", duration.Milliseconds())
+	}
+
+	for _, f := range s.db.DerivedFields() {
+		options.DerivedFields = append(options.DerivedFields, f.Name)
+	}
+	sort.Strings(options.DerivedFields)
+	options.HostGroups = s.hostGroups.names()
+	// Only list the services the request may read
+	if len(access.ServicePatterns) > 0 {
+		services := []string{}
+		for _, service := range options.Services {
+			if serviceMatches(access.ServicePatterns, service) {
+				services = append(services, service)
+			}
+		}
+		options.Services = services
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(options)
+}
+
+// cleanupRoutine runs the cleanup at startup and then every cleanup interval
+// until ctx is canceled.
+func (s *server) cleanupRoutine(ctx context.Context) {
+	// Run cleanup immediately on startup
+	s.runCleanup(ctx)
+
+	_, interval := s.retention.get()
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		s.retention.schedule(time.Now().Add(interval))
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			s.runCleanup(ctx)
+		case <-s.retention.changed:
+		}
+		_, interval = s.retention.get()
+		timer.Reset(interval)
+	}
+}
+
+// runCleanup archives tagged logs and deletes logs and auxiliary table rows
+// past retention, waiting for a cleanup already running to finish first.
+func (s *server) runCleanup(ctx context.Context) {
+	s.cleanupMu.Lock()
+	defer s.cleanupMu.Unlock()
+	s.cleanup(ctx)
+}
+
+// cleanup is runCleanup holding cleanupMu.
+func (s *server) cleanup(ctx context.Context) {
+	// Use a timeout context for cleanup operations
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	// Archive tagged logs before retention can remove them, and keep those
+	// whose archive isn't complete and intact
+	if len(s.tagExports) > 0 {
+		if err := s.exportTaggedLogs(ctx); err != nil {
+			slog.Error("tagged log export failed", "error", err)
+		}
+		s.holdUnverifiedArchives(ctx)
+	}
+
+	// Delete logs past retention, except those kept by tag policies
+	retention := s.retention.retention()
+	start := time.Now()
+	slog.Info("starting log cleanup", "retention", formatRetention(retention))
+	deleted, err := s.db.DeleteOldLogs(ctx, retention)
+	duration := time.Since(start)
+	s.cleanups.record("logs", retention, start, deleted, duration, err)
+	if err != nil {
+		slog.Error("cleanup failed", "error", err, "duration_ms", duration.Milliseconds())
+	} else {
+		slog.Info("log cleanup completed", "deleted", deleted, "duration_ms", duration.Milliseconds())
+		s.scheduleMaintenanceIfDue(ctx)
+	}
+
+	s.cleanupTables(ctx)
+}
+
+func validateLog(l *models.Log) error {
+	if strings.TrimSpace(l.Service) == "" {
+		return fmt.Errorf("missing required field: service")
+	}
+	if strings.TrimSpace(l.Level) == "" {
+		return fmt.Errorf("missing required field: level")
+	}
+	if strings.TrimSpace(l.Message) == "" {
+		return fmt.Errorf("missing required field: message")
+	}
+	return nil
+}
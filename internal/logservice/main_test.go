@@ -1,10 +1,13 @@
-package main
+package logservice
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -14,10 +17,11 @@ import (
 	"locog/internal/models"
 )
 
-// newTestDB creates an in-memory SQLite database for testing.
+// newTestDB creates an in-memory SQLite database for testing. Each
+// connection to ":memory:" opens a database of its own, so the pool holds one.
 func newTestDB(t *testing.T) *db.DB {
 	t.Helper()
-	database, err := db.New(":memory:")
+	database, err := db.NewWithOptions(":memory:", db.Options{MaxOpenConns: 1})
 	if err != nil {
 		t.Fatalf("failed to create test database: %v", err)
 	}
@@ -331,6 +335,27 @@ func TestHandleIngest_RateLimit(t *testing.T) {
 	if rr2.Code != http.StatusTooManyRequests {
 		t.Errorf("second request: expected status %d (rate limited), got %d", http.StatusTooManyRequests, rr2.Code)
 	}
+
+	// The response says when to retry and which limit was hit
+	if got := rr2.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("expected Retry-After: 1, got %q", got)
+	}
+	if h := rr2.Header(); h.Get("X-RateLimit-Limit") != "1" || h.Get("X-RateLimit-Remaining") != "0" || h.Get("X-RateLimit-Reset") == "" {
+		t.Errorf("unexpected X-RateLimit headers %v", h)
+	}
+	var limited rateLimited
+	if err := json.NewDecoder(rr2.Body).Decode(&limited); err != nil {
+		t.Fatalf("failed to decode 429 body: %v", err)
+	}
+	if limited.Code != "rate_limited" || limited.Scope != "ip" || limited.Client != "192.168.1.1" ||
+		limited.Limit != 1 || limited.Burst != 1 || limited.RetryAfterSeconds != 1 || limited.ResetAt.IsZero() {
+		t.Errorf("unexpected 429 body %+v", limited)
+	}
+
+	// A rejected request doesn't hold on to the next token
+	if delay, ok := srv.limiter.reserve("192.168.1.1", time.Now().Add(1100*time.Millisecond)); !ok {
+		t.Errorf("expected a token after a second, still waiting %s", delay)
+	}
 }
 
 // TestHandleIngest_WithMetadata tests ingesting logs with metadata.
@@ -465,6 +490,150 @@ func TestHandleQueryLogs_SearchFilter(t *testing.T) {
 	}
 }
 
+// TestHandleQueryLogs_SearchRegex tests regular expression search.
+func TestHandleQueryLogs_SearchRegex(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, msg := range []string{"upstream error ERR-502", "upstream error ERR-5021", "user logged in"} {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "error", Message: msg, Host: "h"})
+	}
+
+	for _, query := range []string{"search=ERR-5%5Cd%7B2%7D%24&search_regex=true", "regex=ERR-5%5Cd%7B2%7D%24"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/logs?"+query, nil)
+		rr := httptest.NewRecorder()
+		srv.handleQueryLogs(rr, req)
+
+		var logs []models.Log
+		json.NewDecoder(rr.Body).Decode(&logs)
+		if rr.Code != http.StatusOK || len(logs) != 1 || logs[0].Message != "upstream error ERR-502" {
+			t.Errorf("%q: expected 1 log, got %d: %s", query, rr.Code, rr.Body.String())
+		}
+	}
+
+	for _, query := range []string{"regex=%28", "search_regex=maybe", "regex=a&search=b", "regex=" + strings.Repeat("a", 600)} {
+		req := httptest.NewRequest(http.MethodGet, "/api/logs?"+query, nil)
+		rr := httptest.NewRecorder()
+		srv.handleQueryLogs(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status %d, got %d", query, http.StatusBadRequest, rr.Code)
+		}
+	}
+
+	// A search past the timeout is reported as such
+	srv.regexTimeout = time.Nanosecond
+	req := httptest.NewRequest(http.MethodGet, "/api/logs?regex=ERR", nil)
+	rr := httptest.NewRecorder()
+	srv.handleQueryLogs(rr, req)
+	if rr.Code != http.StatusGatewayTimeout || !strings.Contains(rr.Body.String(), "search_timeout") {
+		t.Errorf("expected a search timeout, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// TestHandleQueryLogs_ClientGone tests that queries whose client
+// disconnected are cancelled without being reported as failures.
+func TestHandleQueryLogs_ClientGone(t *testing.T) {
+	srv := newTestServer(t)
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "INFO", Message: "m", Host: "h"})
+	handler, err := srv.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/api/logs", "/api/logs/count", "/api/logs/export?format=ndjson", "/api/logs/aggregate?group_by=service"} {
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+		if rr.Code != statusClientClosedRequest || rr.Body.Len() != 0 {
+			t.Errorf("%s: expected %d without a body, got %d: %s", path, statusClientClosedRequest, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+// TestHandleQueryLogs_SnapshotPages tests paging through a result pinned
+// to a snapshot while logs keep arriving.
+func TestHandleQueryLogs_SnapshotPages(t *testing.T) {
+	srv := newTestServer(t)
+	ts := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	insert := func(offset time.Duration, message string) {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: ts.Add(offset), Service: "api", Level: "INFO", Message: message, Host: "h"})
+	}
+	// Logs sharing a timestamp must neither be skipped nor repeated
+	for i, offset := range []time.Duration{0, time.Second, time.Second, time.Second, 2 * time.Second} {
+		insert(offset, "m"+strconv.Itoa(i))
+	}
+
+	var got []string
+	query := "limit=2&snapshot=true"
+	for page := 0; page < 5; page++ {
+		rr := httptest.NewRecorder()
+		srv.handleQueryLogs(rr, httptest.NewRequest(http.MethodGet, "/api/logs?"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("page %d: %d %s", page, rr.Code, rr.Body.String())
+		}
+		var logs []models.Log
+		json.NewDecoder(rr.Body).Decode(&logs)
+		for _, l := range logs {
+			got = append(got, l.Message)
+		}
+		// Logs arriving meanwhile, even with older timestamps, stay out
+		insert(time.Duration(page)*time.Second, "late")
+
+		cursor := rr.Header().Get(nextCursorHeader)
+		if cursor == "" {
+			break
+		}
+		if page == 0 {
+			query = "limit=2&max_id=" + rr.Header().Get(snapshotIDHeader)
+		}
+		query = strings.Split(query, "&cursor=")[0] + "&cursor=" + cursor
+	}
+	want := []string{"m4", "m1", "m2", "m3", "m0"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	for _, query := range []string{"cursor=abc", "cursor=1_x", "max_id=-1", "snapshot=maybe"} {
+		rr := httptest.NewRecorder()
+		srv.handleQueryLogs(rr, httptest.NewRequest(http.MethodGet, "/api/logs?"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rr.Code)
+		}
+	}
+
+	// Exports pin to the snapshot the same way
+	rr := httptest.NewRecorder()
+	srv.handleExportLogs(rr, httptest.NewRequest(http.MethodGet, "/api/logs/export?format=ndjson&max_id=2", nil))
+	if lines := strings.Count(rr.Body.String(), "\n"); lines != 2 {
+		t.Errorf("expected 2 exported logs, got %d: %s", lines, rr.Body.String())
+	}
+}
+
+// TestHandleQueryLogs_ExcludeFilters tests negated filters.
+func TestHandleQueryLogs_ExcludeFilters(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, l := range []models.Log{
+		{Service: "api", Level: "info", Message: "GET /healthz"},
+		{Service: "api", Level: "DEBUG", Message: "cache miss"},
+		{Service: "api", Level: "error", Message: "GET /orders failed"},
+		{Service: "healthcheck", Level: "info", Message: "ok"},
+	} {
+		l.Timestamp, l.Host = time.Now(), "h"
+		srv.db.InsertLog(t.Context(), &l)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/logs?service!=healthcheck&level!=debug&exclude=/healthz", nil)
+	rr := httptest.NewRecorder()
+	srv.handleQueryLogs(rr, req)
+
+	var logs []models.Log
+	json.NewDecoder(rr.Body).Decode(&logs)
+	if len(logs) != 1 || logs[0].Message != "GET /orders failed" {
+		t.Errorf("expected only the failed order, got %+v", logs)
+	}
+}
+
 // TestHandleQueryLogs_TimeFilters tests time range filtering.
 func TestHandleQueryLogs_TimeFilters(t *testing.T) {
 	srv := newTestServer(t)
@@ -761,6 +930,72 @@ func TestIPRateLimiter_Allow(t *testing.T) {
 	}
 }
 
+// TestIPRateLimiter_EvictIdle tests dropping the limiters of idle addresses.
+func TestIPRateLimiter_EvictIdle(t *testing.T) {
+	// A token every 100 seconds
+	limiter := newIPRateLimiter(rate.Limit(0.01), 2)
+	limiter.getLimiter("192.168.1.1")
+	limiter.getLimiter("192.168.1.2").Allow()
+
+	now := time.Now()
+	if n := limiter.evictIdle(now, time.Minute); n != 0 {
+		t.Errorf("expected no recently used limiter evicted, got %d", n)
+	}
+	// The first address is idle; the second still has a token to regain
+	if n := limiter.evictIdle(now.Add(90*time.Second), time.Minute); n != 1 || limiter.size() != 1 {
+		t.Errorf("expected only the full limiter evicted, got %d evicted, %d left", n, limiter.size())
+	}
+	if n := limiter.evictIdle(now.Add(2*time.Minute), time.Minute); n != 1 || limiter.size() != 0 {
+		t.Errorf("expected the refilled limiter evicted, got %d evicted, %d left", n, limiter.size())
+	}
+}
+
+// TestRateLimits tests per endpoint rate limits from the config file.
+func TestRateLimits(t *testing.T) {
+	for _, bad := range []string{
+		`{"rate_limits": {"rate": -1}}`,
+		`{"rate_limits": {"idle_ttl": "0s"}}`,
+		`{"rate_limits": {"endpoints": {"query": {"rate": 10}}}}`,
+		`{"rate_limits": {"endpoints": {"otlp": {"burst": 10}}}}`,
+	} {
+		if _, err := loadConfig(writeTestConfig(t, bad)); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+
+	cfg, err := loadConfig(writeTestConfig(t, `{"rate_limits": {"rate": 2.5, "idle_ttl": "1h", "endpoints": {"heartbeat": {"rate": 1, "burst": 1}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t)
+	var idleTTL time.Duration
+	srv.limiter, srv.endpointLimiters, idleTTL, _ = cfg.rateLimits()
+	if srv.limiter.rate != 2.5 || srv.limiter.burst != 3 || idleTTL != time.Hour {
+		t.Errorf("unexpected default limit %g/%d, idle TTL %s", float64(srv.limiter.rate), srv.limiter.burst, idleTTL)
+	}
+
+	heartbeat := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/heartbeat?source=vector", nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		rr := httptest.NewRecorder()
+		srv.handleHeartbeat(rr, req)
+		return rr
+	}
+	if rr := heartbeat(); rr.Code == http.StatusTooManyRequests {
+		t.Fatalf("expected the first heartbeat accepted, got %d", rr.Code)
+	}
+	rr := heartbeat()
+	var limited rateLimited
+	json.NewDecoder(rr.Body).Decode(&limited)
+	if rr.Code != http.StatusTooManyRequests || limited.Endpoint != "heartbeat" || limited.Limit != 1 {
+		t.Errorf("expected the heartbeat limit, got %d %+v", rr.Code, limited)
+	}
+	// Other endpoints still have their tokens
+	if limited := srv.checkRateLimit(endpointIngest, "192.168.1.1"); limited != nil {
+		t.Errorf("expected ingest within its limit, got %+v", limited)
+	}
+}
+
 // TestHandleQueryLogs_InvalidStartDate tests that an invalid start date returns a 400 JSON error.
 func TestHandleQueryLogs_InvalidStartDate(t *testing.T) {
 	srv := newTestServer(t)
@@ -912,3 +1147,127 @@ func TestHandleQueryLogs_NoWarningInRetentionWindow(t *testing.T) {
 		t.Errorf("expected no X-Locog-Warning header for query within retention window, got: %s", warning)
 	}
 }
+
+// TestHandleQueryLogs_Sparkline tests that include_sparkline wraps logs with a histogram.
+func TestHandleQueryLogs_Sparkline(t *testing.T) {
+	srv := newTestServer(t)
+
+	end := time.Now().UTC().Truncate(time.Second)
+	start := end.Add(-time.Hour)
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: start.Add(5 * time.Minute), Service: "api", Level: "info", Message: "early", Host: "h"})
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: end.Add(-5 * time.Minute), Service: "api", Level: "info", Message: "late", Host: "h"})
+
+	url := "/api/logs?include_sparkline=true&sparkline_buckets=6&start=" + start.Format(time.RFC3339) + "&end=" + end.Format(time.RFC3339)
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	rr := httptest.NewRecorder()
+	srv.handleQueryLogs(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var resp models.QueryResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Logs) != 2 {
+		t.Errorf("expected 2 logs, got %d", len(resp.Logs))
+	}
+	if resp.Sparkline == nil || len(resp.Sparkline.Counts) != 6 {
+		t.Fatalf("expected sparkline with 6 buckets, got %+v", resp.Sparkline)
+	}
+	if resp.Sparkline.Counts[0] != 1 || resp.Sparkline.Counts[5] != 1 {
+		t.Errorf("expected logs in first and last buckets, got %v", resp.Sparkline.Counts)
+	}
+}
+
+// TestHandleQueryLogs_InvalidSparklineParams tests validation of sparkline parameters.
+func TestHandleQueryLogs_InvalidSparklineParams(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, query := range []string{"include_sparkline=maybe", "include_sparkline=true&sparkline_buckets=0", "include_sparkline=true&sparkline_buckets=1000"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/logs?"+query, nil)
+		rr := httptest.NewRecorder()
+		srv.handleQueryLogs(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
+
+// TestHandleQueryLogs_TruncatedHeaders tests that hitting the limit is signalled.
+func TestHandleQueryLogs_TruncatedHeaders(t *testing.T) {
+	srv := newTestServer(t)
+	for i := 0; i < 5; i++ {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "m", Host: "h"})
+	}
+
+	tests := []struct {
+		limit     string
+		truncated string
+		estimate  string
+		count     int
+	}{
+		{"3", "true", "5", 3},
+		{"5", "", "", 5},
+		{"10", "", "", 5},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/logs?limit="+tt.limit, nil)
+		rr := httptest.NewRecorder()
+		srv.handleQueryLogs(rr, req)
+
+		if got := rr.Header().Get(truncatedHeader); got != tt.truncated {
+			t.Errorf("limit %s: expected %s %q, got %q", tt.limit, truncatedHeader, tt.truncated, got)
+		}
+		if got := rr.Header().Get(totalEstimateHeader); got != tt.estimate {
+			t.Errorf("limit %s: expected %s %q, got %q", tt.limit, totalEstimateHeader, tt.estimate, got)
+		}
+		var logs []models.Log
+		json.NewDecoder(rr.Body).Decode(&logs)
+		if len(logs) != tt.count {
+			t.Errorf("limit %s: expected %d logs, got %d", tt.limit, tt.count, len(logs))
+		}
+		if got := rr.Header().Get(rowsHeader); got != strconv.Itoa(tt.count) {
+			t.Errorf("limit %s: expected %s %d, got %q", tt.limit, rowsHeader, tt.count, got)
+		}
+		if ms, err := strconv.ParseFloat(rr.Header().Get(queryMsHeader), 64); err != nil || ms < 0 {
+			t.Errorf("limit %s: expected a duration in %s, got %q", tt.limit, queryMsHeader, rr.Header().Get(queryMsHeader))
+		}
+	}
+}
+
+// TestNew tests building a service from command line flags.
+func TestNew(t *testing.T) {
+	if _, err := New([]string{"-no-such-flag"}); !errors.Is(err, errBadFlags) {
+		t.Errorf("expected errBadFlags, got %v", err)
+	}
+	var usage usageError
+	for _, args := range [][]string{
+		{"-storage", "nowhere"},
+		{"-tls-acme-domains", "logs.example.com", "-tls-cert", "tls.crt", "-tls-key", "tls.key"},
+	} {
+		if _, err := New(args); !errors.As(err, &usage) {
+			t.Errorf("%v: expected a usage error, got %v", args, err)
+		}
+	}
+	if svc, err := New([]string{"-h"}); svc != nil || err != nil {
+		t.Errorf("expected no service for -h, got %v, %v", svc, err)
+	}
+
+	svc, err := New([]string{"-storage", "memory", "-memory-max-logs", "10", "-export-dir", t.TempDir(), "-upload-dir", t.TempDir()})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	svc.Start()
+	w := httptest.NewRecorder()
+	svc.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/ingest", bytes.NewReader(sampleLogJSON())))
+	if w.Code != http.StatusCreated {
+		t.Errorf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := svc.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package logservice
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// scheduleMaintenance asks maintenanceRoutine to run database maintenance,
+// unless a run is already waiting. It reports whether the run was queued.
+func (s *server) scheduleMaintenance(reason string) bool {
+	select {
+	case s.maintain <- reason:
+		return true
+	default:
+		return false
+	}
+}
+
+// scheduleMaintenanceIfDue schedules maintenance once cleanups have deleted
+// a large share of the logs, whose indexes then hold mostly emptied pages.
+func (s *server) scheduleMaintenanceIfDue(ctx context.Context) {
+	due, err := s.db.MaintenanceDue(ctx)
+	if err != nil {
+		slog.Error("failed to check whether maintenance is due", "error", err)
+		return
+	}
+	if due {
+		s.scheduleMaintenance("deletes")
+	}
+}
+
+// maintenanceRoutine runs scheduled database maintenance until ctx is done,
+// apart from the cleanup so a long reindex doesn't hold it up.
+func (s *server) maintenanceRoutine(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case reason := <-s.maintain:
+			if err := s.db.Maintain(ctx, reason); err != nil && ctx.Err() == nil {
+				slog.Error("database maintenance failed", "error", err)
+			}
+		}
+	}
+}
+
+// handleMaintenanceStatus reports the progress of database maintenance, or
+// the outcome of the last run.
+func (s *server) handleMaintenanceStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.db.MaintenanceStatus())
+}
+
+// handleMaintenance schedules database maintenance now, e.g. after deleting
+// logs by hand, answering 202 with the status to poll. 409
+// maintenance_running means a run is already under way or waiting.
+func (s *server) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	if s.maintain == nil {
+		writeJSONError(w, http.StatusNotFound, "maintenance_disabled", "Maintenance is not running",
+			"maintenance only runs on a writable database")
+		return
+	}
+	if s.db.MaintenanceStatus().State == "running" || !s.scheduleMaintenance("requested") {
+		writeJSONError(w, http.StatusConflict, "maintenance_running", "Maintenance is already running",
+			"see GET /api/admin/maintenance for its progress")
+		return
+	}
+	slog.Info("database maintenance requested")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(s.db.MaintenanceStatus())
+}
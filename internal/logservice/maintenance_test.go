@@ -0,0 +1,55 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestHandleMaintenance tests requesting maintenance and following it.
+func TestHandleMaintenance(t *testing.T) {
+	srv := newTestServer(t)
+	handler, err := srv.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(method string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, "/api/admin/maintenance", nil))
+		return rr
+	}
+
+	if rr := request(http.MethodPost); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without maintenance, got %d", rr.Code)
+	}
+
+	srv.maintain = make(chan string, 1)
+	if rr := request(http.MethodPost); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	// One run waits at a time
+	if rr := request(http.MethodPost); rr.Code != http.StatusConflict {
+		t.Errorf("expected 409, got %d", rr.Code)
+	}
+
+	go srv.maintenanceRoutine(t.Context())
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var st models.MaintenanceStatus
+		json.NewDecoder(request(http.MethodGet).Body).Decode(&st)
+		if st.State == "done" {
+			if st.Reason != "requested" || st.Runs != 1 {
+				t.Errorf("unexpected status %+v", st)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("maintenance not done: %+v", st)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,167 @@
+package logservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"locog/internal/models"
+)
+
+// metadataLimits bounds the shape and size of log metadata accepted at ingest.
+// A zero value for any limit disables it.
+type metadataLimits struct {
+	MaxKeys  int // maximum number of top-level keys
+	MaxDepth int // maximum nesting depth of objects/arrays
+	MaxBytes int // maximum serialized size in bytes
+}
+
+// truncatedMarkerKey is added to metadata that was altered to fit the limits.
+const truncatedMarkerKey = "_truncated"
+
+// markerBytes is the room MaxBytes keeps for the truncated marker, and
+// minMetadataBytes the smallest MaxBytes leaving room for metadata besides.
+const (
+	markerBytes      = 128
+	minMetadataBytes = 1024
+)
+
+func (l metadataLimits) enabled() bool {
+	return l.MaxKeys > 0 || l.MaxDepth > 0 || l.MaxBytes > 0
+}
+
+// validate rejects negative limits, and byte limits too small to keep any
+// metadata once the marker is added.
+func (l metadataLimits) validate() error {
+	if l.MaxKeys < 0 || l.MaxDepth < 0 || l.MaxBytes < 0 {
+		return fmt.Errorf("-metadata-max-keys, -metadata-max-depth and -metadata-max-bytes must not be negative")
+	}
+	if l.MaxBytes > 0 && l.MaxBytes < minMetadataBytes {
+		return fmt.Errorf("-metadata-max-bytes must be 0 or at least %d, got %d", minMetadataBytes, l.MaxBytes)
+	}
+	return nil
+}
+
+// normalize enforces the limits on metadata. Nesting beyond MaxDepth is
+// flattened into a JSON string, keys beyond MaxKeys or the MaxBytes budget are
+// dropped, and a marker describing what happened is added. It reports whether
+// the metadata was changed.
+func (l metadataLimits) normalize(metadata map[string]interface{}) (map[string]interface{}, bool) {
+	if metadata == nil || !l.enabled() {
+		return metadata, false
+	}
+
+	marker := map[string]interface{}{}
+
+	if l.MaxDepth > 0 {
+		flattened := 0
+		// The metadata object itself is depth 1
+		for k, v := range metadata {
+			metadata[k] = flattenDeep(v, 2, l.MaxDepth, &flattened)
+		}
+		if flattened > 0 {
+			marker["flattened_values"] = flattened
+		}
+	}
+
+	// Deterministic key order so the same input keeps the same keys
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if l.MaxKeys > 0 && len(keys) > l.MaxKeys {
+		for _, k := range keys[l.MaxKeys:] {
+			delete(metadata, k)
+		}
+		marker["dropped_keys"] = len(keys) - l.MaxKeys
+		keys = keys[:l.MaxKeys]
+	}
+
+	if l.MaxBytes > 0 {
+		if data, err := json.Marshal(metadata); err == nil && len(data) > l.MaxBytes {
+			// Keep keys in order while they fit, leaving room for the marker
+			budget := l.MaxBytes - markerBytes
+			kept := make(map[string]interface{})
+			size := 2 // {}
+			dropped := 0
+			for _, k := range keys {
+				entry, _ := json.Marshal(map[string]interface{}{k: metadata[k]})
+				entrySize := len(entry) - 1 // minus braces, plus comma
+				if size+entrySize > budget {
+					dropped++
+					continue
+				}
+				kept[k] = metadata[k]
+				size += entrySize
+			}
+			metadata = kept
+			marker["original_bytes"] = len(data)
+			if dropped > 0 {
+				if n, ok := marker["dropped_keys"].(int); ok {
+					dropped += n
+				}
+				marker["dropped_keys"] = dropped
+			}
+		}
+	}
+
+	if len(marker) == 0 {
+		return metadata, false
+	}
+	metadata[truncatedMarkerKey] = marker
+	return metadata, true
+}
+
+// flattenDeep replaces objects and arrays nested deeper than maxDepth with
+// their JSON encoding, counting how many values were flattened.
+func flattenDeep(v interface{}, depth, maxDepth int, flattened *int) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if depth > maxDepth {
+			*flattened++
+			return jsonString(val)
+		}
+		for k, child := range val {
+			val[k] = flattenDeep(child, depth+1, maxDepth, flattened)
+		}
+		return val
+	case []interface{}:
+		if depth > maxDepth {
+			*flattened++
+			return jsonString(val)
+		}
+		for i, child := range val {
+			val[i] = flattenDeep(child, depth+1, maxDepth, flattened)
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+func jsonString(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// applyLabels adds the instance labels to a log's metadata. Values already
+// present win, so logs forwarded from another instance keep their origin's
+// labels.
+func (s *server) applyLabels(l *models.Log) {
+	if len(s.labels) == 0 {
+		return
+	}
+	if l.Metadata == nil {
+		l.Metadata = make(map[string]interface{}, len(s.labels))
+	}
+	for k, v := range s.labels {
+		if _, exists := l.Metadata[k]; !exists {
+			l.Metadata[k] = v
+		}
+	}
+}
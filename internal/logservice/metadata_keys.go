@@ -0,0 +1,77 @@
+package logservice
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"locog/internal/models"
+)
+
+// metadataKeysWindow is how far back the metadata keys report looks.
+const metadataKeysWindow = 24 * time.Hour
+
+// metadataKeysRoutine rebuilds the metadata keys report on startup and then
+// every interval until ctx is canceled.
+func (s *server) metadataKeysRoutine(ctx context.Context, interval time.Duration) {
+	s.refreshMetadataKeys(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.refreshMetadataKeys(ctx)
+		}
+	}
+}
+
+func (s *server) refreshMetadataKeys(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	err := s.db.RefreshMetadataKeys(ctx, start.Add(-metadataKeysWindow))
+	duration := time.Since(start)
+	if err != nil {
+		slog.Error("metadata keys report failed", "error", err, "duration_ms", duration.Milliseconds())
+		return
+	}
+	slog.Info("metadata keys report refreshed", "duration_ms", duration.Milliseconds())
+}
+
+// handleMetadataKeys returns the metadata keys observed per service with their
+// frequency, JSON types and example values, e.g. /api/metadata/keys?service=api.
+// Only the services the request may read are reported.
+func (s *server) handleMetadataKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var access models.LogFilter
+	if !s.restrictFilter(w, r, &access) {
+		return
+	}
+
+	report, err := s.db.MetadataKeys(r.Context(), r.URL.Query().Get("service"))
+	if err != nil {
+		slog.Error("failed to read metadata keys report", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	keys := report.Keys[:0]
+	for _, k := range report.Keys {
+		if readable(access, &models.Log{Service: k.Service}) {
+			keys = append(keys, k)
+		}
+	}
+	report.Keys = keys
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,40 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestHandleMetadataKeys tests the metadata keys report endpoint.
+func TestHandleMetadataKeys(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "req", Host: "h",
+		Metadata: map[string]interface{}{"status": 200}})
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "worker", Level: "info", Message: "job", Host: "h",
+		Metadata: map[string]interface{}{"job_id": 7}})
+	srv.refreshMetadataKeys(t.Context())
+
+	req := httptest.NewRequest(http.MethodGet, "/api/metadata/keys?service=api", nil)
+	rr := httptest.NewRecorder()
+	srv.handleMetadataKeys(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	var report models.MetadataKeysReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if report.GeneratedAt == nil {
+		t.Error("expected generated_at to be set")
+	}
+	if len(report.Keys) != 1 || report.Keys[0].Key != "status" || report.Keys[0].Frequency != 1 {
+		t.Errorf("expected only the api status key, got %+v", report.Keys)
+	}
+}
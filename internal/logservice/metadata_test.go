@@ -0,0 +1,169 @@
+package logservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"locog/internal/models"
+)
+
+// TestMetadataLimits_Disabled tests that zero limits leave metadata untouched.
+func TestMetadataLimits_Disabled(t *testing.T) {
+	meta := map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": 1}}}
+
+	got, changed := metadataLimits{}.normalize(meta)
+	if changed {
+		t.Error("expected metadata to be unchanged")
+	}
+	if _, ok := got["a"].(map[string]interface{}); !ok {
+		t.Errorf("expected nested object to be kept, got %v", got)
+	}
+}
+
+// TestMetadataLimits_MaxDepth tests that deep nesting is flattened into JSON strings.
+func TestMetadataLimits_MaxDepth(t *testing.T) {
+	meta := map[string]interface{}{
+		"shallow": "x",
+		"nested":  map[string]interface{}{"inner": map[string]interface{}{"deep": 1}},
+	}
+
+	got, changed := metadataLimits{MaxDepth: 2}.normalize(meta)
+	if !changed {
+		t.Fatal("expected metadata to be changed")
+	}
+	nested := got["nested"].(map[string]interface{})
+	if nested["inner"] != `{"deep":1}` {
+		t.Errorf("expected inner object flattened to JSON, got %v", nested["inner"])
+	}
+	marker := got[truncatedMarkerKey].(map[string]interface{})
+	if marker["flattened_values"] != 1 {
+		t.Errorf("expected 1 flattened value in marker, got %v", marker)
+	}
+}
+
+// TestMetadataLimits_MaxKeys tests that excess keys are dropped deterministically.
+func TestMetadataLimits_MaxKeys(t *testing.T) {
+	meta := map[string]interface{}{"d": 4, "a": 1, "c": 3, "b": 2}
+
+	got, changed := metadataLimits{MaxKeys: 2}.normalize(meta)
+	if !changed {
+		t.Fatal("expected metadata to be changed")
+	}
+	if got["a"] != 1 || got["b"] != 2 || got["c"] != nil || got["d"] != nil {
+		t.Errorf("expected keys a and b to be kept, got %v", got)
+	}
+	if got[truncatedMarkerKey].(map[string]interface{})["dropped_keys"] != 2 {
+		t.Errorf("expected 2 dropped keys, got %v", got[truncatedMarkerKey])
+	}
+}
+
+// TestMetadataLimits_MaxBytes tests that oversized metadata is cut down to the byte budget.
+func TestMetadataLimits_MaxBytes(t *testing.T) {
+	meta := map[string]interface{}{}
+	for i := 0; i < 50; i++ {
+		meta[fmt.Sprintf("key%02d", i)] = strings.Repeat("x", 100)
+	}
+
+	limits := metadataLimits{MaxBytes: 1024}
+	got, changed := limits.normalize(meta)
+	if !changed {
+		t.Fatal("expected metadata to be changed")
+	}
+	data, _ := json.Marshal(got)
+	if len(data) > limits.MaxBytes {
+		t.Errorf("expected serialized metadata <= %d bytes, got %d", limits.MaxBytes, len(data))
+	}
+	if got["key00"] == nil {
+		t.Error("expected the first keys to be kept")
+	}
+}
+
+// TestMetadataLimits_Validate tests that byte limits leaving no room for
+// metadata beside the marker are rejected.
+func TestMetadataLimits_Validate(t *testing.T) {
+	for _, tc := range []struct {
+		limits metadataLimits
+		ok     bool
+	}{
+		{metadataLimits{}, true},
+		{metadataLimits{MaxKeys: 200, MaxDepth: 8, MaxBytes: 64 << 10}, true},
+		{metadataLimits{MaxBytes: minMetadataBytes}, true},
+		{metadataLimits{MaxBytes: 100}, false},
+		{metadataLimits{MaxBytes: minMetadataBytes - 1}, false},
+		{metadataLimits{MaxKeys: -1}, false},
+	} {
+		if err := tc.limits.validate(); (err == nil) != tc.ok {
+			t.Errorf("validate(%+v) = %v, expected ok: %v", tc.limits, err, tc.ok)
+		}
+	}
+}
+
+// TestHandleIngest_MetadataLimits tests that ingest stores normalized metadata.
+func TestHandleIngest_MetadataLimits(t *testing.T) {
+	srv := newTestServer(t)
+	srv.metadataLimits = metadataLimits{MaxKeys: 1}
+
+	body := `{"service":"api","level":"info","message":"hi","metadata":{"a":1,"b":2}}`
+	req := httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(body))
+	req.RemoteAddr = "192.168.1.1:12345"
+	rr := httptest.NewRecorder()
+	srv.handleIngest(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	logs, _ := srv.db.QueryLogs(req.Context(), models.LogFilter{})
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(logs))
+	}
+	if _, ok := logs[0].Metadata["b"]; ok {
+		t.Errorf("expected key 'b' to be dropped, got %v", logs[0].Metadata)
+	}
+	if _, ok := logs[0].Metadata[truncatedMarkerKey]; !ok {
+		t.Errorf("expected truncation marker, got %v", logs[0].Metadata)
+	}
+}
+
+// TestHandleIngest_Labels tests that instance labels are added without overriding sent values.
+func TestHandleIngest_Labels(t *testing.T) {
+	srv := newTestServer(t)
+	srv.labels = map[string]string{"env": "prod", "region": "eu"}
+
+	body := `[{"service":"api","level":"info","message":"local"},
+		{"service":"api","level":"info","message":"forwarded","metadata":{"env":"staging"}}]`
+	req := httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(body))
+	req.RemoteAddr = "192.168.1.1:12345"
+	rr := httptest.NewRecorder()
+	srv.handleIngest(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	logs, _ := srv.db.QueryLogs(t.Context(), models.LogFilter{
+		Meta: []models.MetaFilter{{Key: "env", Op: "=", Value: "prod"}},
+	})
+	if len(logs) != 1 || logs[0].Message != "local" || logs[0].Metadata["region"] != "eu" {
+		t.Errorf("expected only the local log labelled env=prod, got %+v", logs)
+	}
+}
+
+// TestParseLabels tests parsing of the -labels flag.
+func TestParseLabels(t *testing.T) {
+	labels, err := parseLabels(" env=prod, region = eu ,")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(labels) != 2 || labels["env"] != "prod" || labels["region"] != "eu" {
+		t.Errorf("unexpected labels: %v", labels)
+	}
+	for _, bad := range []string{"env", "=prod", "a.b=c", "bad key=v"} {
+		if _, err := parseLabels(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
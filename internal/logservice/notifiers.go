@@ -0,0 +1,100 @@
+package logservice
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"locog/internal/alerts"
+)
+
+// notifierConfig is a notification channel alert rules name in notify.
+type notifierConfig struct {
+	Type string `json:"type"` // slack or email
+
+	// Slack: an incoming webhook, or a bot token and channel.
+	WebhookURL string `json:"webhook_url"`
+	Token      string `json:"token"`
+	Channel    string `json:"channel"`
+
+	// Email over SMTP, e.g. "smtp.example.com:587". Username and password
+	// authenticate with PLAIN, which requires STARTTLS unless the server is
+	// local.
+	SMTPAddr string   `json:"smtp_addr"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+
+	// Template is a Go text/template rendering the message from the
+	// notification's .Rule, .State, .Value, .Condition, .Threshold, .Window,
+	// .Start, .End and .Link. The first line is the email subject.
+	Template string `json:"template"`
+}
+
+// notifiers builds the configured notification channels by name.
+func (c *fileConfig) notifiers() (map[string]alerts.Notifier, error) {
+	out := make(map[string]alerts.Notifier, len(c.Notifiers))
+	for name, nc := range c.Notifiers {
+		n, err := nc.notifier()
+		if err != nil {
+			return nil, fmt.Errorf("notifier %s: %w", name, err)
+		}
+		out[name] = n
+	}
+	return out, nil
+}
+
+func (nc notifierConfig) notifier() (alerts.Notifier, error) {
+	tmpl, err := alerts.ParseTemplate(nc.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	switch nc.Type {
+	case "slack":
+		switch {
+		case nc.WebhookURL != "" && nc.Token != "":
+			return nil, fmt.Errorf("set either webhook_url or token, not both")
+		case nc.WebhookURL != "":
+			if u, err := url.Parse(nc.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("invalid webhook_url %q", nc.WebhookURL)
+			}
+		case nc.Token == "" || nc.Channel == "":
+			return nil, fmt.Errorf("slack needs a webhook_url, or a token and channel")
+		}
+		return &alerts.SlackNotifier{WebhookURL: nc.WebhookURL, Token: nc.Token, Channel: nc.Channel, Template: tmpl}, nil
+	case "email":
+		if _, _, err := net.SplitHostPort(nc.SMTPAddr); err != nil {
+			return nil, fmt.Errorf("invalid smtp_addr %q, expected host:port", nc.SMTPAddr)
+		}
+		if _, err := mail.ParseAddress(nc.From); err != nil {
+			return nil, fmt.Errorf("invalid from address %q", nc.From)
+		}
+		if len(nc.To) == 0 {
+			return nil, fmt.Errorf("email needs to addresses")
+		}
+		for _, to := range nc.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				return nil, fmt.Errorf("invalid to address %q", to)
+			}
+		}
+		return &alerts.EmailNotifier{Addr: nc.SMTPAddr, Username: nc.Username, Password: nc.Password,
+			From: nc.From, To: nc.To, Template: tmpl}, nil
+	}
+	return nil, fmt.Errorf("unknown type %q; use slack or email", nc.Type)
+}
+
+// publicURL returns the configured address of the web UI without a trailing
+// slash, or "" when unset.
+func (c *fileConfig) publicURL() (string, error) {
+	if c.PublicURL == "" {
+		return "", nil
+	}
+	u, err := url.Parse(c.PublicURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" {
+		return "", fmt.Errorf("invalid public_url %q, expected e.g. https://logs.example.com", c.PublicURL)
+	}
+	return strings.TrimSuffix(c.PublicURL, "/"), nil
+}
@@ -0,0 +1,222 @@
+package logservice
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"locog/internal/models"
+	"locog/internal/otlp"
+)
+
+// otlpGRPCExportPath is the gRPC method OTLP exporters call to send logs.
+const otlpGRPCExportPath = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+
+// gRPC status codes used by the OTLP/gRPC receiver.
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcUnavailable       = 14
+)
+
+// otlpResult is the outcome of storing an OTLP export request.
+type otlpResult struct {
+	rejected int64
+	message  string // why records were rejected
+	// limited is set when the records exceeded a quota; none were stored
+	limited *rateLimited
+}
+
+// ingestOTLP converts a decoded export request into logs and stores them.
+// Records that fail validation are rejected individually, as OTLP partial
+// success allows, rather than failing the whole request.
+func (s *server) ingestOTLP(r *http.Request, req *otlp.Request, sender string) (otlpResult, error) {
+	var result otlpResult
+	all := req.Logs()
+	s.nameHosts(r, all)
+	logs := make([]models.Log, 0, len(all))
+	invalid := make([]error, len(all))
+	forEachLog(len(all), func(i int) { invalid[i] = validateLog(&all[i]) })
+	for i, err := range invalid {
+		if err != nil {
+			if result.rejected == 0 {
+				result.message = err.Error()
+			}
+			result.rejected++
+			continue
+		}
+		logs = append(logs, all[i])
+	}
+	if result.rejected > 0 {
+		slog.Warn("rejected OTLP log records", "sender", sender, "rejected", result.rejected,
+			"total_logs", len(all), "reason", result.message)
+	}
+
+	var refund func()
+	if refund, result.limited = s.checkQuotas(r, logs); result.limited != nil {
+		return result, nil
+	}
+	if err := s.storeLogs(r.Context(), logs, sender); err != nil {
+		refund()
+		return result, err
+	}
+	return result, nil
+}
+
+// handleOTLPLogs implements the OTLP/HTTP logs receiver (POST /v1/logs) for
+// both protobuf and JSON encoded requests. Responses use the request's
+// encoding.
+func (s *server) handleOTLPLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+	fail := func(status int, code int32, message string) {
+		if isJSON {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "message": message})
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(status)
+		w.Write(otlp.EncodeStatus(code, message))
+	}
+
+	ip := getClientIP(r)
+	if limited := s.checkRateLimit(endpointOTLP, ip); limited != nil {
+		// OTLP exporters honor Retry-After on 429
+		limited.setHeaders(w)
+		fail(http.StatusTooManyRequests, grpcResourceExhausted, limited.Details)
+		return
+	}
+
+	body, err := readBody(w, r)
+	if err != nil {
+		fail(http.StatusBadRequest, grpcInvalidArgument, err.Error())
+		return
+	}
+
+	var req *otlp.Request
+	if isJSON {
+		req, err = otlp.DecodeJSON(body)
+	} else {
+		req, err = otlp.DecodeProto(body)
+	}
+	if err != nil {
+		slog.Warn("failed to decode OTLP request", "sender", ip, "reason", err.Error())
+		fail(http.StatusBadRequest, grpcInvalidArgument, err.Error())
+		return
+	}
+
+	result, err := s.ingestOTLP(r, req, ip)
+	if err != nil {
+		fail(http.StatusServiceUnavailable, grpcUnavailable, "Internal error")
+		return
+	}
+	if result.limited != nil {
+		result.limited.setHeaders(w)
+		fail(http.StatusTooManyRequests, grpcResourceExhausted, result.limited.Details)
+		return
+	}
+
+	if isJSON {
+		resp := map[string]interface{}{}
+		if result.rejected > 0 {
+			resp["partialSuccess"] = map[string]interface{}{
+				"rejectedLogRecords": strconv.FormatInt(result.rejected, 10),
+				"errorMessage":       result.message,
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(otlp.EncodeResponse(result.rejected, result.message))
+}
+
+// handleOTLPGRPC implements the OTLP/gRPC logs receiver. gRPC runs over
+// HTTP/2, which the main listener accepts in cleartext (h2c), so exporters
+// can point at the same address with TLS disabled.
+func (s *server) handleOTLPGRPC(w http.ResponseWriter, r *http.Request) {
+	fail := func(code int, message string) {
+		// Trailers-only response: the status travels in the headers
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", strconv.Itoa(code))
+		w.Header().Set("Grpc-Message", url.PathEscape(message))
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "Unsupported media type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	ip := getClientIP(r)
+	if limited := s.checkRateLimit(endpointOTLP, ip); limited != nil {
+		fail(grpcResourceExhausted, limited.Details)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		fail(grpcResourceExhausted, "message too large")
+		return
+	}
+
+	// A unary call carries one length-prefixed message
+	if len(body) < 5 || uint32(len(body)-5) != binary.BigEndian.Uint32(body[1:5]) {
+		fail(grpcInvalidArgument, "malformed gRPC message")
+		return
+	}
+	msg := body[5:]
+	if body[0] == 1 {
+		if r.Header.Get("Grpc-Encoding") != "gzip" {
+			fail(grpcUnimplemented, "unsupported grpc-encoding "+r.Header.Get("Grpc-Encoding"))
+			return
+		}
+		if msg, err = gunzip(msg); err != nil {
+			fail(grpcInvalidArgument, err.Error())
+			return
+		}
+	}
+
+	req, err := otlp.DecodeProto(msg)
+	if err != nil {
+		slog.Warn("failed to decode OTLP request", "sender", ip, "reason", err.Error())
+		fail(grpcInvalidArgument, err.Error())
+		return
+	}
+
+	result, err := s.ingestOTLP(r, req, ip)
+	if err != nil {
+		fail(grpcUnavailable, "Internal error")
+		return
+	}
+	if result.limited != nil {
+		fail(grpcResourceExhausted, result.limited.Details)
+		return
+	}
+
+	resp := otlp.EncodeResponse(result.rejected, result.message)
+	frame := make([]byte, 5, 5+len(resp))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(resp)))
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(frame, resp...))
+	w.Header().Set("Grpc-Status", strconv.Itoa(grpcOK))
+}
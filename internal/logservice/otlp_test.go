@@ -0,0 +1,144 @@
+package logservice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"locog/internal/models"
+)
+
+// pb encodes a length-delimited protobuf field.
+func pb(field int, value []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// otlpProtoRequest builds an ExportLogsServiceRequest with one log per message.
+func otlpProtoRequest(service string, messages ...string) []byte {
+	attr := pb(1, append(pb(1, []byte("service.name")), pb(2, pb(1, []byte(service)))...))
+	var scopeLogs []byte
+	for _, m := range messages {
+		scopeLogs = append(scopeLogs, pb(2, pb(5, pb(1, []byte(m))))...)
+	}
+	return pb(1, append(pb(1, attr), pb(2, scopeLogs)...))
+}
+
+func queryAll(t *testing.T, srv *server) []models.Log {
+	t.Helper()
+	logs, err := srv.db.QueryLogs(t.Context(), models.LogFilter{})
+	if err != nil {
+		t.Fatalf("QueryLogs failed: %v", err)
+	}
+	return logs
+}
+
+func TestHandleOTLPLogs_JSON(t *testing.T) {
+	srv := newTestServer(t)
+	body := `{"resourceLogs":[{"resource":{"attributes":[
+		{"key":"service.name","value":{"stringValue":"checkout"}},
+		{"key":"host.name","value":{"stringValue":"web-1"}}]},
+		"scopeLogs":[{"logRecords":[
+			{"severityText":"ERROR","body":{"stringValue":"payment failed"},
+			 "attributes":[{"key":"http.status_code","value":{"intValue":"502"}}]},
+			{"severityNumber":9}]}]}]}`
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/logs", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	srv.handleOTLPLogs(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		PartialSuccess struct {
+			RejectedLogRecords string `json:"rejectedLogRecords"`
+		} `json:"partialSuccess"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.PartialSuccess.RejectedLogRecords != "1" {
+		t.Errorf("expected the record without a body to be rejected, got %+v", resp)
+	}
+
+	logs := queryAll(t, srv)
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 stored log, got %d", len(logs))
+	}
+	l := logs[0]
+	if l.Service != "checkout" || l.Host != "web-1" || l.Level != "ERROR" || l.Message != "payment failed" {
+		t.Errorf("unexpected log: %+v", l)
+	}
+	if l.Timestamp.IsZero() {
+		t.Error("expected timestamp to default to receive time")
+	}
+
+	filtered, err := srv.db.QueryLogs(t.Context(), models.LogFilter{
+		Meta: []models.MetaFilter{{Key: "http.status_code", Op: ">=", Numeric: true, Number: 500}},
+	})
+	if err != nil || len(filtered) != 1 {
+		t.Errorf("expected dotted attribute to be filterable, got %d logs (err %v)", len(filtered), err)
+	}
+}
+
+func TestHandleOTLPLogs_Protobuf(t *testing.T) {
+	srv := newTestServer(t)
+	req := httptest.NewRequest(http.MethodPost, "/v1/logs", bytes.NewReader(otlpProtoRequest("auth", "one", "two")))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	rr := httptest.NewRecorder()
+	srv.handleOTLPLogs(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-protobuf" {
+		t.Errorf("expected protobuf response, got %q", ct)
+	}
+	if logs := queryAll(t, srv); len(logs) != 2 || logs[0].Service != "auth" {
+		t.Errorf("expected 2 auth logs, got %+v", logs)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/logs", strings.NewReader("\x0a\xff"))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	rr = httptest.NewRecorder()
+	srv.handleOTLPLogs(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for malformed protobuf, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestHandleOTLPGRPC(t *testing.T) {
+	srv := newTestServer(t)
+	msg := otlpProtoRequest("billing", "invoice sent")
+	frame := make([]byte, 5)
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+
+	req := httptest.NewRequest(http.MethodPost, otlpGRPCExportPath, bytes.NewReader(append(frame, msg...)))
+	req.Header.Set("Content-Type", "application/grpc")
+	rr := httptest.NewRecorder()
+	srv.handleOTLPGRPC(rr, req)
+
+	res := rr.Result()
+	if status := res.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Fatalf("expected grpc-status 0, got %q (headers %v)", status, res.Header)
+	}
+	if got := rr.Body.Bytes(); len(got) != 5 {
+		t.Errorf("expected an empty response message, got %x", got)
+	}
+	if logs := queryAll(t, srv); len(logs) != 1 || logs[0].Message != "invoice sent" {
+		t.Errorf("expected the exported log to be stored, got %+v", logs)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, otlpGRPCExportPath, bytes.NewReader([]byte{0, 0, 0, 0, 9}))
+	req.Header.Set("Content-Type", "application/grpc")
+	rr = httptest.NewRecorder()
+	srv.handleOTLPGRPC(rr, req)
+	if status := rr.Header().Get("Grpc-Status"); status != "3" {
+		t.Errorf("expected grpc-status 3 for a malformed frame, got %q", status)
+	}
+}
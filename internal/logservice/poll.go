@@ -0,0 +1,106 @@
+package logservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"locog/internal/models"
+)
+
+const (
+	// defaultPollWait is how long a poll waits for new logs by default
+	defaultPollWait = 30 * time.Second
+	// maxPollWait stays below common proxy idle timeouts
+	maxPollWait = 60 * time.Second
+)
+
+// handlePoll is a long-polling live tail for clients that can't use
+// WebSockets: /api/poll?since_id=N&wait=30s holds the request until logs
+// newer than N match the filter or the wait elapses. The response's last_id
+// is passed as since_id in the next request; without since_id (or with 0) the
+// tail starts at the newest log.
+func (s *server) handlePoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, ok := s.parseLogFilter(w, r)
+	if !ok {
+		return
+	}
+
+	wait := defaultPollWait
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > maxPollWait {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+				"Invalid wait value",
+				fmt.Sprintf("'wait' must be a duration between 0s and %s, got: %s", maxPollWait, v))
+			return
+		}
+		wait = d
+	}
+
+	if v := r.URL.Query().Get("since_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+				"Invalid since_id value",
+				fmt.Sprintf("'since_id' must be a non-negative integer, got: %s", v))
+			return
+		}
+		filter.SinceID = id
+	}
+	filter.ExcludeLevels = append(filter.ExcludeLevels, levelsBelow(s.streamMinLevel(r))...)
+
+	if filter.SinceID == 0 {
+		id, err := s.db.MaxLogID(r.Context())
+		if err != nil {
+			slog.Error("poll failed", "error", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		filter.SinceID = id
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		// Subscribe before querying so logs arriving in between aren't missed
+		var changed <-chan struct{}
+		if s.hub != nil {
+			changed = s.hub.changed()
+		}
+
+		logs, err := s.db.QueryLogs(r.Context(), filter)
+		if err != nil {
+			slog.Error("poll query failed", "error", err, "filter", filter)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		if len(logs) > 0 {
+			writePollResponse(w, logs, logs[len(logs)-1].ID)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			writePollResponse(w, []models.Log{}, filter.SinceID)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writePollResponse(w http.ResponseWriter, logs []models.Log, lastID int64) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.PollResponse{Logs: logs, LastID: lastID})
+}
@@ -0,0 +1,101 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func decodePoll(t *testing.T, rr *httptest.ResponseRecorder) models.PollResponse {
+	t.Helper()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var resp models.PollResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return resp
+}
+
+// TestHandlePoll_ReturnsNewerLogs tests that existing newer logs are returned immediately, oldest first.
+func TestHandlePoll_ReturnsNewerLogs(t *testing.T) {
+	srv := newTestServer(t)
+	for _, msg := range []string{"one", "two", "three"} {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: msg, Host: "h"})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/poll?since_id=1&wait=0s", nil)
+	rr := httptest.NewRecorder()
+	srv.handlePoll(rr, req)
+
+	resp := decodePoll(t, rr)
+	if len(resp.Logs) != 2 || resp.Logs[0].Message != "two" || resp.LastID != 3 {
+		t.Errorf("expected logs two and three with last_id 3, got %+v", resp)
+	}
+}
+
+// TestHandlePoll_WakesOnIngest tests that a waiting poll returns when matching logs are ingested.
+func TestHandlePoll_WakesOnIngest(t *testing.T) {
+	srv := newTestServer(t)
+	srv.hub = newWSHub()
+	go srv.hub.run(t.Context())
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "old", Host: "h"})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "/api/poll?service=api&wait=10s", nil)
+		rr := httptest.NewRecorder()
+		srv.handlePoll(rr, req)
+		done <- rr
+	}()
+
+	ingest := func(service string) {
+		body := `{"service":"` + service + `","level":"info","message":"new ` + service + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(body))
+		req.RemoteAddr = "192.168.1.1:12345"
+		srv.handleIngest(httptest.NewRecorder(), req)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	ingest("worker") // doesn't match, poll keeps waiting
+	time.Sleep(50 * time.Millisecond)
+	ingest("api")
+
+	select {
+	case rr := <-done:
+		resp := decodePoll(t, rr)
+		if len(resp.Logs) != 1 || resp.Logs[0].Message != "new api" {
+			t.Errorf("expected only the new api log, got %+v", resp.Logs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("poll did not return after a matching ingest")
+	}
+}
+
+// TestHandlePoll_Timeout tests that an empty response with the same cursor is returned after the wait.
+func TestHandlePoll_Timeout(t *testing.T) {
+	srv := newTestServer(t)
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "info", Message: "old", Host: "h"})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/poll?wait=20ms", nil)
+	rr := httptest.NewRecorder()
+	srv.handlePoll(rr, req)
+
+	resp := decodePoll(t, rr)
+	if len(resp.Logs) != 0 || resp.LastID != 1 {
+		t.Errorf("expected empty response starting at the newest log, got %+v", resp)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/poll?wait=5m", nil)
+	rr = httptest.NewRecorder()
+	srv.handlePoll(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for excessive wait, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
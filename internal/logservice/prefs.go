@@ -0,0 +1,120 @@
+package logservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+)
+
+const (
+	// prefsUserHeader names the user whose preferences are read and written.
+	// Until locog has user accounts it is supplied by the client (or a proxy
+	// in front of locog) and defaults to a single shared user.
+	prefsUserHeader  = "X-Locog-User"
+	defaultPrefsUser = "default"
+
+	// maxPrefSize bounds a single preference value in bytes
+	maxPrefSize = 64 << 10
+)
+
+var prefKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// prefsUser returns the user preferences apply to.
+func prefsUser(r *http.Request) (string, error) {
+	user := r.Header.Get(prefsUserHeader)
+	if user == "" {
+		return defaultPrefsUser, nil
+	}
+	if len(user) > 100 {
+		return "", fmt.Errorf("%s must be at most 100 characters", prefsUserHeader)
+	}
+	return user, nil
+}
+
+// handlePrefs serves the per-user preference store used by the UI:
+// GET /api/prefs returns all preferences as an object, and
+// GET/PUT/DELETE /api/prefs/{key} read, replace or remove one. Values are
+// arbitrary JSON.
+func (s *server) handlePrefs(w http.ResponseWriter, r *http.Request) {
+	user, err := prefsUser(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_user", "Invalid user", err.Error())
+		return
+	}
+
+	key := r.PathValue("key")
+	if key == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		prefs, err := s.db.Prefs(r.Context(), user)
+		if err != nil {
+			slog.Error("failed to read preferences", "user", user, "error", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(prefs)
+		return
+	}
+
+	if !prefKeyPattern.MatchString(key) {
+		writeJSONError(w, http.StatusBadRequest, "invalid_key", "Invalid preference key",
+			"Keys are 1-64 letters, digits, '_', '.' or '-'")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		value, err := s.db.Pref(r.Context(), user, key)
+		if err != nil {
+			slog.Error("failed to read preference", "user", user, "key", key, "error", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		if value == nil {
+			writeJSONError(w, http.StatusNotFound, "not_found", "Preference not set", "")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(value)
+
+	case http.MethodPut:
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPrefSize))
+		if err != nil {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "too_large", "Preference too large",
+				fmt.Sprintf("Values are limited to %d bytes", maxPrefSize))
+			return
+		}
+		if !json.Valid(body) {
+			writeJSONError(w, http.StatusBadRequest, "invalid_json", "Preference value must be JSON", "")
+			return
+		}
+		if err := s.db.SetPref(r.Context(), user, key, body); err != nil {
+			slog.Error("failed to store preference", "user", user, "key", key, "error", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		existed, err := s.db.DeletePref(r.Context(), user, key)
+		if err != nil {
+			slog.Error("failed to delete preference", "user", user, "key", key, "error", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		if !existed {
+			writeJSONError(w, http.StatusNotFound, "not_found", "Preference not set", "")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
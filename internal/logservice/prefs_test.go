@@ -0,0 +1,85 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func prefsRequest(method, key, body, user string) *http.Request {
+	path := "/api/prefs"
+	if key != "" {
+		path += "/" + url.PathEscape(key)
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.SetPathValue("key", key)
+	if user != "" {
+		req.Header.Set(prefsUserHeader, user)
+	}
+	return req
+}
+
+// TestHandlePrefs tests storing, listing and deleting preferences per user.
+func TestHandlePrefs(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, tc := range []struct{ key, value, user string }{
+		{"theme", `"dark"`, ""},
+		{"columns", `["timestamp","level","message"]`, ""},
+		{"theme", `"light"`, "alice"},
+	} {
+		rr := httptest.NewRecorder()
+		srv.handlePrefs(rr, prefsRequest(http.MethodPut, tc.key, tc.value, tc.user))
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("PUT %s: expected status %d, got %d: %s", tc.key, http.StatusNoContent, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	srv.handlePrefs(rr, prefsRequest(http.MethodGet, "", "", ""))
+	var prefs map[string]json.RawMessage
+	json.NewDecoder(rr.Body).Decode(&prefs)
+	if len(prefs) != 2 || string(prefs["theme"]) != `"dark"` {
+		t.Errorf("unexpected default user prefs: %s", prefs)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.handlePrefs(rr, prefsRequest(http.MethodGet, "theme", "", "alice"))
+	if rr.Code != http.StatusOK || rr.Body.String() != `"light"` {
+		t.Errorf("unexpected pref for alice: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	srv.handlePrefs(rr, prefsRequest(http.MethodDelete, "theme", "", ""))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status %d on delete, got %d", http.StatusNoContent, rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	srv.handlePrefs(rr, prefsRequest(http.MethodGet, "theme", "", ""))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d after delete, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// TestHandlePrefs_Invalid tests key and value validation.
+func TestHandlePrefs_Invalid(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, tc := range []struct {
+		key, value string
+		status     int
+	}{
+		{"bad key!", `1`, http.StatusBadRequest},
+		{"theme", `not json`, http.StatusBadRequest},
+		{"theme", `"` + strings.Repeat("x", maxPrefSize) + `"`, http.StatusRequestEntityTooLarge},
+	} {
+		rr := httptest.NewRecorder()
+		srv.handlePrefs(rr, prefsRequest(http.MethodPut, tc.key, tc.value, ""))
+		if rr.Code != tc.status {
+			t.Errorf("PUT %q: expected status %d, got %d", tc.key, tc.status, rr.Code)
+		}
+	}
+}
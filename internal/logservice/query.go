@@ -0,0 +1,520 @@
+package logservice
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"locog/internal/db"
+	"locog/internal/models"
+)
+
+// maxQueryLength bounds q= queries.
+const maxQueryLength = 4096
+
+// The q= query language combines the individual filter parameters into one
+// expression, loosely after LogQL:
+//
+//	{service="api", host!="web-03"} |= "timeout" != "retry" | level>=warn | json request_id="abc"
+//
+// A query starts with field matchers, optionally in braces and separated by
+// commas: service, level, host, host_group, stream and tag with = (and != for
+// service, level and host); level also takes >, >=, < and <=. Stages follow:
+// |= "text" keeps messages containing text, != "text" drops them, |~ "re"
+// matches a regular expression, | <matcher> adds a matcher and
+// | json key op value[, ...] filters metadata like meta=. Values are quoted
+// strings or bare words. Everything is ANDed, also with the other parameters.
+
+type queryTokenKind int
+
+const (
+	queryEOF queryTokenKind = iota
+	queryWord
+	queryString
+	queryOp
+)
+
+type queryToken struct {
+	kind queryTokenKind
+	text string
+	pos  int
+}
+
+// queryOps lists the operators, longer ones first so "|=" isn't read as "|".
+var queryOps = []string{"|=", "|~", "!=", "!~", "=~", ">=", "<=", "|", "=", ">", "<", "{", "}", ","}
+
+func isQueryWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.IndexByte("_.-:*?/", c) >= 0
+}
+
+// tokenizeQuery splits a query into words, strings and operators. Strings
+// are Go string literals: double quoted with escapes, or back-quoted raw.
+func tokenizeQuery(q string) ([]queryToken, error) {
+	var tokens []queryToken
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '`':
+			end := i + 1
+			for end < len(q) && q[end] != c {
+				if c == '"' && q[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(q) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			s, err := strconv.Unquote(q[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d", i)
+			}
+			tokens = append(tokens, queryToken{kind: queryString, text: s, pos: i})
+			i = end + 1
+		case isQueryWordByte(c):
+			end := i
+			for end < len(q) && isQueryWordByte(q[end]) {
+				end++
+			}
+			tokens = append(tokens, queryToken{kind: queryWord, text: q[i:end], pos: i})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range queryOps {
+				if strings.HasPrefix(q[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+			tokens = append(tokens, queryToken{kind: queryOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, queryToken{kind: queryEOF, pos: len(q)}), nil
+}
+
+// queryParser applies a tokenized query to a filter.
+type queryParser struct {
+	s      *server
+	filter *models.LogFilter
+	tokens []queryToken
+	next   int
+}
+
+func (p *queryParser) peek() queryToken { return p.tokens[p.next] }
+
+func (p *queryParser) take() queryToken {
+	t := p.tokens[p.next]
+	if t.kind != queryEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *queryParser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == queryOp && t.text == op
+}
+
+// describe names a token for error messages.
+func describe(t queryToken) string {
+	if t.kind == queryEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("%q at offset %d", t.text, t.pos)
+}
+
+// value reads a quoted string or bare word.
+func (p *queryParser) value() (string, error) {
+	t := p.take()
+	if t.kind != queryString && t.kind != queryWord {
+		return "", fmt.Errorf("expected a value, got %s", describe(t))
+	}
+	return t.text, nil
+}
+
+// str reads a quoted string.
+func (p *queryParser) str() (string, error) {
+	t := p.take()
+	if t.kind != queryString {
+		return "", fmt.Errorf("expected a quoted string, got %s", describe(t))
+	}
+	return t.text, nil
+}
+
+// parse reads the whole query.
+func (p *queryParser) parse() error {
+	if p.isOp("{") {
+		p.take()
+		for !p.isOp("}") {
+			if err := p.matcher(); err != nil {
+				return err
+			}
+			if p.isOp(",") {
+				p.take()
+			} else if !p.isOp("}") {
+				return fmt.Errorf("expected , or }, got %s", describe(p.peek()))
+			}
+		}
+		p.take()
+	} else {
+		for p.peek().kind == queryWord {
+			if err := p.matcher(); err != nil {
+				return err
+			}
+			if p.isOp(",") {
+				p.take()
+			}
+		}
+	}
+
+	for p.peek().kind != queryEOF {
+		t := p.take()
+		if t.kind != queryOp {
+			return fmt.Errorf("expected |=, !=, |~ or |, got %s", describe(t))
+		}
+		var err error
+		switch t.text {
+		case "|=", "|~":
+			err = p.lineFilter(t.text == "|~")
+		case "!=":
+			var text string
+			if text, err = p.str(); err == nil {
+				p.filter.ExcludeSearch = append(p.filter.ExcludeSearch, text)
+			}
+		case "|":
+			if w := p.peek(); w.kind == queryWord && w.text == "json" {
+				p.take()
+				err = p.jsonFilters()
+			} else {
+				err = p.matcher()
+			}
+		case "!~":
+			err = fmt.Errorf("!~ at offset %d is not supported; use != with a substring", t.pos)
+		default:
+			err = fmt.Errorf("expected |=, !=, |~ or |, got %s", describe(t))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lineFilter reads the string of a |= or |~ stage. LogFilter holds one
+// search, so a query has at most one of them.
+func (p *queryParser) lineFilter(regex bool) error {
+	text, err := p.str()
+	if err != nil {
+		return err
+	}
+	if p.filter.Search != "" {
+		return fmt.Errorf("only one |= or |~ stage is supported, also with 'search' or 'regex'")
+	}
+	if regex {
+		if len(text) > maxSearchRegexLength {
+			return fmt.Errorf("the |~ pattern is longer than %d bytes", maxSearchRegexLength)
+		}
+		if _, err := regexp.Compile(text); err != nil {
+			return fmt.Errorf("invalid |~ pattern: %v", err)
+		}
+	}
+	p.filter.Search, p.filter.SearchRegex = text, regex
+	return nil
+}
+
+// jsonFilters reads the metadata comparisons of a | json stage.
+func (p *queryParser) jsonFilters() error {
+	for {
+		key := p.take()
+		if key.kind != queryWord {
+			return fmt.Errorf("expected a metadata key, got %s", describe(key))
+		}
+		op := p.take()
+		if op.kind != queryOp || !slices.Contains([]string{"=", "!=", ">", ">=", "<", "<="}, op.text) {
+			return fmt.Errorf("expected =, !=, >, >=, < or <= after %s, got %s", key.text, describe(op))
+		}
+		value, err := p.value()
+		if err != nil {
+			return err
+		}
+		m, err := parseMetaFilter(key.text + op.text + value)
+		if err != nil {
+			return err
+		}
+		p.filter.Meta = append(p.filter.Meta, m)
+		if !p.isOp(",") {
+			return nil
+		}
+		p.take()
+	}
+}
+
+// matcher reads one field comparison.
+func (p *queryParser) matcher() error {
+	field := p.take()
+	if field.kind != queryWord {
+		return fmt.Errorf("expected a field, got %s", describe(field))
+	}
+	opToken := p.take()
+	if opToken.kind != queryOp {
+		return fmt.Errorf("expected an operator after %s, got %s", field.text, describe(opToken))
+	}
+	op := opToken.text
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	f := p.filter
+
+	switch field.text {
+	case "service", "host":
+		single, exclude := &f.Service, &f.ExcludeServices
+		if field.text == "host" {
+			single, exclude = &f.Host, &f.ExcludeHosts
+		}
+		switch op {
+		case "=":
+			return setOnce(single, value, field.text)
+		case "!=":
+			*exclude = append(*exclude, value)
+			return nil
+		}
+	case "level":
+		switch op {
+		case "=":
+			return setOnce(&f.Level, p.s.levels.normalize(value), field.text)
+		case "!=":
+			f.ExcludeLevels = append(f.ExcludeLevels, p.s.levels.normalize(value))
+			return nil
+		case ">", ">=", "<", "<=":
+			return p.levelRange(op, value)
+		}
+	case "host_group":
+		if op == "=" {
+			if len(f.HostPatterns) > 0 {
+				return fmt.Errorf("host_group is set more than once")
+			}
+			if apiErr := p.s.hostGroupFilter(f, value); apiErr != nil {
+				return fmt.Errorf("%s", apiErr.Details)
+			}
+			return nil
+		}
+	case "stream":
+		if op == "=" {
+			return setOnce(&f.Stream, value, field.text)
+		}
+	case "tag":
+		if op == "=" {
+			if !tagPattern.MatchString(value) {
+				return fmt.Errorf("invalid tag %q", value)
+			}
+			return setOnce(&f.Tag, value, field.text)
+		}
+	default:
+		return fmt.Errorf("unknown field %q at offset %d; use service, level, host, host_group, stream or tag, or | json for metadata",
+			field.text, field.pos)
+	}
+	return fmt.Errorf("%s does not support %s at offset %d", field.text, op, opToken.pos)
+}
+
+// setOnce sets a single-valued filter field, rejecting a different value set
+// earlier in the query or by another parameter.
+func setOnce(dst *string, value, field string) error {
+	if *dst != "" && *dst != value {
+		return fmt.Errorf("%s is set more than once", field)
+	}
+	*dst = value
+	return nil
+}
+
+// levelRange narrows the filter's levels to the known levels comparing to
+// level as op says, e.g. >= WARN.
+func (p *queryParser) levelRange(op, level string) error {
+	levels, err := levelsInRange(op, level)
+	if err != nil {
+		return err
+	}
+	return narrowLevels(p.filter, levels)
+}
+
+// applyQuery adds the conditions of a q= query to filter.
+func (s *server) applyQuery(filter *models.LogFilter, q string) *apiError {
+	if len(q) > maxQueryLength {
+		return filterError("invalid_query", "Invalid query",
+			fmt.Sprintf("'q' must be at most %d bytes", maxQueryLength))
+	}
+	tokens, err := tokenizeQuery(q)
+	if err == nil {
+		err = (&queryParser{s: s, filter: filter, tokens: tokens}).parse()
+	}
+	if err != nil {
+		return filterError("invalid_query", "Invalid query", err.Error())
+	}
+	return nil
+}
+
+// liveQuery matches logs streamed over the WebSocket against filter
+// parameters and a q= query, mirroring buildWhere for the fields they set.
+type liveQuery struct {
+	query  string
+	filter models.LogFilter
+	re     *regexp.Regexp
+}
+
+// liveQueryParams are the live stream parameters that filter logs.
+var liveQueryParams = []string{
+	"q", "service", "service!", "host", "host!", "search", "exclude",
+	"level", "level!", "min_level", "level>", "level<",
+}
+
+// newLiveQuery compiles the filter parameters and q= query of a live stream
+// request, or of a WebSocket subscription, returning nil when there are none.
+func (s *server) newLiveQuery(query url.Values) (*liveQuery, *apiError) {
+	params := url.Values{}
+	for _, name := range liveQueryParams {
+		if v := nonEmpty(query[name]); v != nil {
+			params[name] = v
+		}
+	}
+	if len(params) == 0 {
+		return nil, nil
+	}
+
+	lq := &liveQuery{query: params.Encode()}
+	lq.filter.Service = params.Get("service")
+	lq.filter.Host = params.Get("host")
+	lq.filter.Search = params.Get("search")
+	lq.filter.ExcludeServices = params["service!"]
+	lq.filter.ExcludeHosts = params["host!"]
+	lq.filter.ExcludeSearch = params["exclude"]
+	lq.filter.Level = s.levels.normalize(params.Get("level"))
+	for _, level := range params["level!"] {
+		lq.filter.ExcludeLevels = append(lq.filter.ExcludeLevels, s.levels.normalize(level))
+	}
+	if apiErr := s.levelFilter(&lq.filter, params); apiErr != nil {
+		return nil, apiErr
+	}
+	if q := params.Get("q"); q != "" {
+		if apiErr := s.applyQuery(&lq.filter, q); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	if lq.filter.SearchRegex {
+		lq.re = regexp.MustCompile(lq.filter.Search) // validated by applyQuery
+	}
+	return lq, nil
+}
+
+// matches reports whether a log satisfies the query. A nil query matches
+// every log.
+func (lq *liveQuery) matches(l *models.Log) bool {
+	if lq == nil {
+		return true
+	}
+	f := &lq.filter
+	if (f.Service != "" && l.Service != f.Service) || slices.Contains(f.ExcludeServices, l.Service) {
+		return false
+	}
+	if (f.Host != "" && l.Host != f.Host) || slices.Contains(f.ExcludeHosts, l.Host) {
+		return false
+	}
+	if f.Level != "" && l.Level != f.Level {
+		return false
+	}
+	if len(f.Levels) > 0 && !containsFold(f.Levels, l.Level) || containsFold(f.ExcludeLevels, l.Level) {
+		return false
+	}
+	if len(f.HostPatterns) > 0 && !slices.ContainsFunc(f.HostPatterns, func(pattern string) bool {
+		ok, _ := path.Match(pattern, l.Host)
+		return ok
+	}) {
+		return false
+	}
+	if f.Stream != "" && f.Stream != l.Stream && !(f.Stream == db.DefaultStream && l.Stream == "") {
+		return false
+	}
+	if f.Tag != "" && !slices.Contains(l.Tags, f.Tag) {
+		return false
+	}
+	message := strings.ToLower(l.Message)
+	if lq.re != nil && !lq.re.MatchString(l.Message) ||
+		lq.re == nil && f.Search != "" && !strings.Contains(message, strings.ToLower(f.Search)) {
+		return false
+	}
+	for _, s := range f.ExcludeSearch {
+		if strings.Contains(message, strings.ToLower(s)) {
+			return false
+		}
+	}
+	for _, m := range f.Meta {
+		if !metaMatches(l.Metadata, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// metaMatches applies a metadata filter to a log's metadata as
+// metaFilterClause does in SQL: negated filters match absent keys, and
+// numeric ones only numbers or numeric strings.
+func metaMatches(metadata map[string]interface{}, m models.MetaFilter) bool {
+	var v interface{} = metadata
+	for _, part := range strings.Split(m.Key, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			v = nil
+			break
+		}
+		v = obj[part]
+	}
+	if v == nil {
+		return m.Op == "!="
+	}
+
+	if !m.Numeric {
+		s, ok := v.(string)
+		if !ok {
+			s = fmt.Sprint(v)
+		}
+		return (s == m.Value) == (m.Op == "=")
+	}
+	var n float64
+	switch x := v.(type) {
+	case float64:
+		n = x
+	case string:
+		parsed, err := strconv.ParseFloat(x, 64)
+		if err != nil {
+			return m.Op == "!="
+		}
+		n = parsed
+	default:
+		return m.Op == "!="
+	}
+	switch m.Op {
+	case "=":
+		return n == m.Number
+	case "!=":
+		return n != m.Number
+	case ">":
+		return n > m.Number
+	case ">=":
+		return n >= m.Number
+	case "<":
+		return n < m.Number
+	case "<=":
+		return n <= m.Number
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestApplyQuery tests how queries translate into filters.
+func TestApplyQuery(t *testing.T) {
+	srv := &server{hostGroups: &hostGroups{fixed: map[string][]string{"web": {"web-*"}}}}
+
+	var f models.LogFilter
+	q := `{service="api", host!="web-03"} |= "timeout" != "retry" != ` + "`health`" +
+		` | level>=warn | json request_id="abc", duration_ms>500 | host_group=web`
+	if apiErr := srv.applyQuery(&f, q); apiErr != nil {
+		t.Fatalf("unexpected error: %+v", apiErr)
+	}
+	if f.Service != "api" || len(f.ExcludeHosts) != 1 || f.ExcludeHosts[0] != "web-03" {
+		t.Errorf("unexpected matchers: %+v", f)
+	}
+	if f.Search != "timeout" || f.SearchRegex || strings.Join(f.ExcludeSearch, ",") != "retry,health" {
+		t.Errorf("unexpected line filters: %+v", f)
+	}
+	if strings.Join(f.Levels, ",") != strings.Join(levelsAtLeast("WARN"), ",") {
+		t.Errorf("expected levels at or above WARN, got %v", f.Levels)
+	}
+	if len(f.Meta) != 2 || f.Meta[0].Key != "request_id" || f.Meta[1].Op != ">" || !f.Meta[1].Numeric {
+		t.Errorf("unexpected metadata filters: %+v", f.Meta)
+	}
+	if len(f.HostPatterns) != 1 {
+		t.Errorf("expected the host group's patterns, got %v", f.HostPatterns)
+	}
+
+	// Braces are optional and level ranges intersect
+	f = models.LogFilter{}
+	if apiErr := srv.applyQuery(&f, `service=api level>=info | level<error |~ "E\\d+"`); apiErr != nil {
+		t.Fatalf("unexpected error: %+v", apiErr)
+	}
+	if f.Service != "api" || f.Search != `E\d+` || !f.SearchRegex {
+		t.Errorf("unexpected filter: %+v", f)
+	}
+	for _, level := range f.Levels {
+		if r := levelRanks[level]; r < levelRanks["INFO"] || r >= levelRanks["ERROR"] {
+			t.Errorf("unexpected level %s in %v", level, f.Levels)
+		}
+	}
+
+	for _, bad := range []string{
+		`service="api`,
+		`{service="api"`,
+		`service~"api"`,
+		`colour="red"`,
+		`service>"api"`,
+		`host_group=nope`,
+		`|= "a" |= "b"`,
+		`|~ "("`,
+		`!~ "a"`,
+		`level>=loud`,
+		`level>error | level<warn`,
+		`service=a | service=b`,
+		`| json =1`,
+		`| json n>fast`,
+		`tag="bad tag"`,
+	} {
+		var f models.LogFilter
+		if apiErr := srv.applyQuery(&f, bad); apiErr == nil || apiErr.Code != "invalid_query" {
+			t.Errorf("expected invalid_query for %s, got %+v", bad, apiErr)
+		}
+	}
+}
+
+// TestLiveQueryMatches tests in-memory matching of live logs.
+func TestLiveQueryMatches(t *testing.T) {
+	srv := &server{hostGroups: &hostGroups{fixed: map[string][]string{"web": {"web-*"}}}}
+	lq, apiErr := srv.newLiveQuery(url.Values{"q": {`{service="api", host_group="web"} |~ "(?i)time ?out" != "retry" | level>=warn | json code>=500, region="eu"`}})
+	if apiErr != nil {
+		t.Fatalf("unexpected error: %+v", apiErr)
+	}
+
+	match := models.Log{Service: "api", Host: "web-01", Level: "error", Message: "Timeout calling db",
+		Metadata: map[string]interface{}{"code": float64(503), "region": "eu"}}
+	if !lq.matches(&match) {
+		t.Error("expected the log to match")
+	}
+	for name, change := range map[string]func(l *models.Log){
+		"service": func(l *models.Log) { l.Service = "worker" },
+		"host":    func(l *models.Log) { l.Host = "db-01" },
+		"level":   func(l *models.Log) { l.Level = "INFO" },
+		"search":  func(l *models.Log) { l.Message = "connection refused" },
+		"exclude": func(l *models.Log) { l.Message = "timeout, will RETRY" },
+		"meta":    func(l *models.Log) { l.Metadata = map[string]interface{}{"code": "404", "region": "eu"} },
+		"absent":  func(l *models.Log) { l.Metadata = nil },
+	} {
+		l := match
+		change(&l)
+		if lq.matches(&l) {
+			t.Errorf("%s: expected the log not to match", name)
+		}
+	}
+
+	var none *liveQuery
+	if !none.matches(&match) {
+		t.Error("expected a nil query to match every log")
+	}
+}
+
+// TestHandleQueryLogs_Query tests q= on /api/logs.
+func TestHandleQueryLogs_Query(t *testing.T) {
+	srv := newTestServer(t)
+	for _, l := range []models.Log{
+		{Service: "api", Level: "ERROR", Message: "timeout calling db", Metadata: map[string]interface{}{"request_id": "abc"}},
+		{Service: "api", Level: "INFO", Message: "timeout calling db", Metadata: map[string]interface{}{"request_id": "abc"}},
+		{Service: "api", Level: "WARN", Message: "timeout calling db", Metadata: map[string]interface{}{"request_id": "xyz"}},
+		{Service: "web", Level: "ERROR", Message: "timeout calling db", Metadata: map[string]interface{}{"request_id": "abc"}},
+	} {
+		l.Timestamp, l.Host = time.Now(), "h"
+		srv.db.InsertLog(t.Context(), &l)
+	}
+
+	q := url.Values{"q": {`service="api" |= "timeout" | level>=warn | json request_id="abc"`}}
+	req := httptest.NewRequest(http.MethodGet, "/api/logs?"+q.Encode(), nil)
+	rr := httptest.NewRecorder()
+	srv.handleQueryLogs(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var logs []models.Log
+	json.NewDecoder(rr.Body).Decode(&logs)
+	if len(logs) != 1 || logs[0].Level != "ERROR" || logs[0].Service != "api" {
+		t.Errorf("expected the api error, got %+v", logs)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/logs?q="+url.QueryEscape(`service=`), nil)
+	rr = httptest.NewRecorder()
+	srv.handleQueryLogs(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid_query") {
+		t.Errorf("expected invalid_query, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// TestWebSocket_Query tests that q= limits what a live stream receives.
+func TestWebSocket_Query(t *testing.T) {
+	srv := newTestServerWithHub(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ws", srv.handleWebSocket)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws?q=" + url.QueryEscape(`service="api" | level>=warn`)
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	srv.hub.broadcastLogs([]models.Log{{Service: "web", Level: "ERROR", Message: "other service"}})
+	srv.hub.broadcastLogs([]models.Log{
+		{Service: "api", Level: "INFO", Message: "quiet"},
+		{Service: "api", Level: "WARNING", Message: "loud"},
+	})
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+	var logs []models.Log
+	json.Unmarshal(message, &logs)
+	if len(logs) != 1 || logs[0].Message != "loud" {
+		t.Errorf("expected only the api warning, got %+v", logs)
+	}
+
+	// Level parameters filter the stream like q=
+	levelURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws?level%3E=error"
+	levelConn, _, err := websocket.DefaultDialer.Dial(levelURL, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer levelConn.Close()
+	time.Sleep(50 * time.Millisecond)
+	srv.hub.broadcastLogs([]models.Log{{Service: "api", Level: "WARN", Message: "warn"}, {Service: "web", Level: "ERR", Message: "err"}})
+	levelConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, message, err = levelConn.ReadMessage(); err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+	logs = nil
+	json.Unmarshal(message, &logs)
+	if len(logs) != 1 || logs[0].Message != "err" {
+		t.Errorf("expected only the error, got %+v", logs)
+	}
+
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL+url.QueryEscape(" |="), nil); err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid query, got %v", http.StatusBadRequest, err)
+	}
+}
@@ -0,0 +1,407 @@
+package logservice
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"locog/internal/db"
+	"locog/internal/models"
+
+	"golang.org/x/time/rate"
+)
+
+// Scopes of ingest quotas, beside rateLimitScopeIP.
+const (
+	quotaScopeToken   = "token"
+	quotaScopeService = "service"
+)
+
+// Units of the limit a rateLimited reports for a quota.
+const (
+	quotaUnitEvents = "events/s"
+	quotaUnitBytes  = "bytes/day"
+)
+
+// quotaConfig limits what senders presenting an API token, or each service
+// matching a glob, may ingest, e.g. {"name": "team-a", "token": "k3y...",
+// "events_per_second": 500, "bytes_per_day": 10000000000}.
+type quotaConfig struct {
+	Name string `json:"name"`
+	// Token is the API key senders present as X-Api-Key or a bearer token;
+	// all their logs count against the quota.
+	Token string `json:"token"`
+	// Services are globs; each matching service has its own allowance.
+	// Logs count against the first quota listing their service.
+	Services []string `json:"services"`
+
+	EventsPerSecond float64 `json:"events_per_second"` // 0 is unlimited
+	Burst           int     `json:"burst"`             // default: events_per_second rounded up
+	BytesPerDay     int64   `json:"bytes_per_day"`     // UTC days; 0 is unlimited
+}
+
+// ingestQuota is a validated quota. events is keyed like usage.
+type ingestQuota struct {
+	name        string
+	token       string
+	services    []string
+	events      *ipRateLimiter // nil when events aren't limited
+	bytesPerDay int64
+}
+
+// quotaUsage is what one key, the quota's token or a service, ingested on
+// day.
+type quotaUsage struct {
+	day                     string
+	events, bytes, rejected int64
+}
+
+// ingestQuotas are the configured quotas and their usage today.
+type ingestQuotas struct {
+	quotas []*ingestQuota
+
+	mu    sync.Mutex
+	usage map[string]*quotaUsage // by quota name and key, see usageKey
+}
+
+// quotas validates the ingest quotas, returning nil when none are
+// configured.
+func (c *fileConfig) quotas() (*ingestQuotas, error) {
+	if len(c.Quotas) == 0 {
+		return nil, nil
+	}
+	q := &ingestQuotas{usage: make(map[string]*quotaUsage)}
+	names := make(map[string]bool)
+	for i, qc := range c.Quotas {
+		if qc.Name == "" || names[qc.Name] {
+			return nil, fmt.Errorf("quotas[%d]: quotas need unique names, got %q", i, qc.Name)
+		}
+		names[qc.Name] = true
+		if (qc.Token == "") == (len(qc.Services) == 0) {
+			return nil, fmt.Errorf("quota %s: needs either a token or services", qc.Name)
+		}
+		for _, pattern := range qc.Services {
+			if !db.ValidGlob(pattern) {
+				return nil, fmt.Errorf("quota %s: invalid service pattern %q", qc.Name, pattern)
+			}
+		}
+		if qc.EventsPerSecond < 0 || qc.Burst < 0 || qc.BytesPerDay < 0 {
+			return nil, fmt.Errorf("quota %s: limits must not be negative", qc.Name)
+		}
+		if qc.EventsPerSecond == 0 && qc.BytesPerDay == 0 {
+			return nil, fmt.Errorf("quota %s: needs events_per_second or bytes_per_day", qc.Name)
+		}
+		quota := &ingestQuota{name: qc.Name, token: qc.Token, services: qc.Services, bytesPerDay: qc.BytesPerDay}
+		if qc.EventsPerSecond > 0 {
+			burst := qc.Burst
+			if burst == 0 {
+				burst = int(math.Ceil(qc.EventsPerSecond))
+			}
+			quota.events = newIPRateLimiter(rate.Limit(qc.EventsPerSecond), burst)
+		}
+		q.quotas = append(q.quotas, quota)
+	}
+	return q, nil
+}
+
+// usageKey identifies a key's usage of a quota.
+func usageKey(quota, key string) string {
+	return quota + "\x00" + key
+}
+
+// quotaCharge is what a request takes from one key's allowance.
+type quotaCharge struct {
+	quota         *ingestQuota
+	scope, key    string // key is the service, or "" for the token
+	events, bytes int64
+}
+
+// charges groups logs by the quota allowances they count against: the
+// quota of token, the API key the sender presented if any, and for each log
+// the first quota listing its service.
+func (q *ingestQuotas) charges(token string, logs []models.Log) []*quotaCharge {
+	var charges []*quotaCharge
+	sizes := make([]int64, len(logs))
+	forEachLog(len(logs), func(i int) { sizes[i] = logBytes(&logs[i]) })
+
+	if token != "" {
+		for _, quota := range q.quotas {
+			if quota.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(quota.token)) == 1 {
+				c := &quotaCharge{quota: quota, scope: quotaScopeToken, events: int64(len(logs))}
+				for _, n := range sizes {
+					c.bytes += n
+				}
+				charges = append(charges, c)
+				break
+			}
+		}
+	}
+
+	byService := make(map[string]*quotaCharge)
+	for i := range logs {
+		service := logs[i].Service
+		c, ok := byService[service]
+		if !ok {
+			for _, quota := range q.quotas {
+				if len(quota.services) > 0 && serviceMatches(quota.services, service) {
+					c = &quotaCharge{quota: quota, scope: quotaScopeService, key: service}
+					charges = append(charges, c)
+					break
+				}
+			}
+			byService[service] = c
+		}
+		if c != nil {
+			c.events++
+			c.bytes += sizes[i]
+		}
+	}
+	return charges
+}
+
+// logBytes is the size a log counts against byte quotas: its fields and
+// metadata as JSON.
+func logBytes(l *models.Log) int64 {
+	n := len(l.Service) + len(l.Level) + len(l.Message) + len(l.Host)
+	if len(l.Metadata) > 0 {
+		data, _ := json.Marshal(l.Metadata)
+		n += len(data)
+	}
+	return int64(n)
+}
+
+// usageLocked returns key's usage of quota today, resetting it on a new
+// day. The caller must hold mu.
+func (q *ingestQuotas) usageLocked(quota *ingestQuota, key, day string) *quotaUsage {
+	k := usageKey(quota.name, key)
+	u, ok := q.usage[k]
+	if !ok || u.day != day {
+		u = &quotaUsage{day: day}
+		q.usage[k] = u
+	}
+	return u
+}
+
+// usedLocked returns key's usage of quota today without recording the key,
+// so requests that are turned away add no entries. The caller must hold mu.
+func (q *ingestQuotas) usedLocked(quota *ingestQuota, key, day string) quotaUsage {
+	if u, ok := q.usage[usageKey(quota.name, key)]; ok && u.day == day {
+		return *u
+	}
+	return quotaUsage{day: day}
+}
+
+// prune forgets the usage of days before now's, returning how many entries
+// it deleted. Usage is only reported for today, and service quotas add an
+// entry for each service that sends logs.
+func (q *ingestQuotas) prune(now time.Time) int {
+	if q == nil {
+		return 0
+	}
+	day := now.UTC().Format(time.DateOnly)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for k, u := range q.usage {
+		if u.day != day {
+			delete(q.usage, k)
+			n++
+		}
+	}
+	return n
+}
+
+// noRefund is the refund of logs that were charged nothing.
+func noRefund() {}
+
+// admit charges logs sent with token to the quotas they count against, all
+// or none. When one is exceeded it returns what the 429 response should
+// report; otherwise it returns refund, which gives the bytes and events
+// charged today back when the logs could not be stored.
+func (q *ingestQuotas) admit(token string, logs []models.Log, client string, now time.Time) (refund func(), limited *rateLimited) {
+	if q == nil || len(logs) == 0 {
+		return noRefund, nil
+	}
+	charges := q.charges(token, logs)
+	if len(charges) == 0 {
+		return noRefund, nil
+	}
+	now = now.UTC()
+	day := now.Format(time.DateOnly)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var reserved []*rate.Reservation
+	reject := func(c *quotaCharge, limited *rateLimited) *rateLimited {
+		for _, res := range reserved {
+			res.CancelAt(now)
+		}
+		// Token quotas are reported whether used or not; services are only
+		// counted once they have logs stored today
+		if c.scope == quotaScopeToken {
+			q.usageLocked(c.quota, c.key, day).rejected++
+		} else if u, ok := q.usage[usageKey(c.quota.name, c.key)]; ok && u.day == day {
+			u.rejected++
+		}
+		limited.Code = "quota_exceeded"
+		limited.Error = "Quota exceeded"
+		limited.Scope, limited.Client, limited.Quota, limited.Service = c.scope, client, c.quota.name, c.key
+		limited.RetryAfterSeconds = int(math.Ceil(limited.ResetAt.Sub(now).Seconds()))
+		return limited
+	}
+	for _, c := range charges {
+		u := q.usedLocked(c.quota, c.key, day)
+		if limit := c.quota.bytesPerDay; limit > 0 && u.bytes+c.bytes > limit {
+			return nil, reject(c, &rateLimited{
+				apiError: apiError{Details: fmt.Sprintf("%s has used %d of %d bytes today; this request needs %d more; the quota resets at 00:00 UTC",
+					c.describe(), u.bytes, limit, c.bytes)},
+				Limit: float64(limit), Unit: quotaUnitBytes, Used: u.bytes, Requested: c.bytes,
+				ResetAt: now.Truncate(24 * time.Hour).Add(24 * time.Hour),
+			})
+		}
+		if c.quota.events == nil {
+			continue
+		}
+		events := c.quota.events
+		res := events.getLimiter(c.key).ReserveN(now, int(c.events))
+		limited := &rateLimited{
+			Limit: float64(events.rate), Unit: quotaUnitEvents, Burst: events.burst, Requested: c.events,
+		}
+		if !res.OK() {
+			limited.Details = fmt.Sprintf("%s allows at most %d events per request (burst), got %d; send smaller batches",
+				c.describe(), events.burst, c.events)
+			limited.ResetAt = now.Add(time.Hour)
+			return nil, reject(c, limited)
+		}
+		if delay := res.DelayFrom(now); delay > 0 {
+			res.CancelAt(now)
+			limited.Details = fmt.Sprintf("%s allows %g events per second (burst %d); retry after %s",
+				c.describe(), float64(events.rate), events.burst, delay.Round(time.Millisecond))
+			limited.ResetAt = now.Add(delay)
+			return nil, reject(c, limited)
+		}
+		reserved = append(reserved, res)
+	}
+	for _, c := range charges {
+		u := q.usageLocked(c.quota, c.key, day)
+		u.events += c.events
+		u.bytes += c.bytes
+	}
+	return func() {
+		// The events per second allowance refills by itself; today's usage
+		// is given back unless the day changed since
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for _, c := range charges {
+			if u, ok := q.usage[usageKey(c.quota.name, c.key)]; ok && u.day == day {
+				u.events -= c.events
+				u.bytes -= c.bytes
+			}
+		}
+	}, nil
+}
+
+// describe names the allowance a charge is taken from in messages.
+func (c *quotaCharge) describe() string {
+	if c.scope == quotaScopeService {
+		return fmt.Sprintf("quota %s for service %s", c.quota.name, c.key)
+	}
+	return "quota " + c.quota.name
+}
+
+// checkQuotas charges ingested logs to the quotas of the request's token and
+// their services. Like checkRateLimit, it returns what a 429 response should
+// report when one is exceeded, and nothing is stored. Otherwise callers
+// must call refund if storing the logs fails.
+func (s *server) checkQuotas(r *http.Request, logs []models.Log) (refund func(), limited *rateLimited) {
+	return s.quotas.admit(requestAPIKey(r), logs, getClientIP(r), time.Now())
+}
+
+// limiters returns the events limiters of the quotas, for eviction.
+func (q *ingestQuotas) limiters() []*ipRateLimiter {
+	if q == nil {
+		return nil
+	}
+	var limiters []*ipRateLimiter
+	for _, quota := range q.quotas {
+		if quota.events != nil {
+			limiters = append(limiters, quota.events)
+		}
+	}
+	return limiters
+}
+
+// stats reports today's usage of each quota: every token quota, and the
+// services that sent logs today of service quotas. include filters the
+// reported entries.
+func (q *ingestQuotas) stats(now time.Time, include func(quota *ingestQuota, service string) bool) []models.QuotaUsage {
+	if q == nil {
+		return []models.QuotaUsage{}
+	}
+	now = now.UTC()
+	day := now.Format(time.DateOnly)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := []models.QuotaUsage{}
+	add := func(quota *ingestQuota, scope, key string, u *quotaUsage) {
+		st := models.QuotaUsage{Quota: quota.name, Scope: scope, Service: key, Day: day,
+			BytesPerDay: quota.bytesPerDay, EventsToday: u.events, BytesToday: u.bytes, RejectedToday: u.rejected}
+		if quota.events != nil {
+			st.EventsPerSecond, st.Burst = float64(quota.events.rate), quota.events.burst
+			// Peek without making the key look active
+			available := float64(quota.events.burst)
+			if v, ok := quota.events.limiters.Load(key); ok {
+				available = math.Floor(v.(*ipLimiter).TokensAt(now))
+			}
+			st.EventsAvailable = &available
+		}
+		stats = append(stats, st)
+	}
+	for _, quota := range q.quotas {
+		if quota.token != "" {
+			if include(quota, "") {
+				add(quota, quotaScopeToken, "", q.usageLocked(quota, "", day))
+			}
+			continue
+		}
+		prefix := usageKey(quota.name, "")
+		var services []string
+		for k, u := range q.usage {
+			if service, ok := strings.CutPrefix(k, prefix); ok && u.day == day && include(quota, service) {
+				services = append(services, service)
+			}
+		}
+		slices.Sort(services)
+		for _, service := range services {
+			add(quota, quotaScopeService, service, q.usage[usageKey(quota.name, service)])
+		}
+	}
+	return stats
+}
+
+// handleQuotaStats reports today's quota usage. Service quotas are listed
+// for the services the request may read, token quotas for admins and
+// callers presenting the token.
+func (s *server) handleQuotaStats(w http.ResponseWriter, r *http.Request) {
+	var access models.LogFilter
+	if !s.restrictFilter(w, r, &access) {
+		return
+	}
+	admin := s.adminToken == "" || s.isAdmin(r)
+	token := requestAPIKey(r)
+	stats := s.quotas.stats(time.Now(), func(quota *ingestQuota, service string) bool {
+		if quota.token != "" {
+			return admin || subtle.ConstantTimeCompare([]byte(token), []byte(quota.token)) == 1
+		}
+		return readable(access, &models.Log{Service: service})
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,148 @@
+package logservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/db"
+	"locog/internal/models"
+)
+
+// TestQuotas tests token and service quotas at ingest and their usage
+// report.
+func TestQuotas(t *testing.T) {
+	for _, bad := range []string{
+		`{"quotas": [{"token": "k", "events_per_second": 1}]}`,
+		`{"quotas": [{"name": "a", "events_per_second": 1}]}`,
+		`{"quotas": [{"name": "a", "token": "k", "services": ["api"], "events_per_second": 1}]}`,
+		`{"quotas": [{"name": "a", "token": "k"}]}`,
+		`{"quotas": [{"name": "a", "services": ["["], "bytes_per_day": 1}]}`,
+		`{"quotas": [{"name": "a", "token": "k", "bytes_per_day": -1}]}`,
+	} {
+		if _, err := loadConfig(writeTestConfig(t, bad)); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+
+	cfg, err := loadConfig(writeTestConfig(t, `{"quotas": [
+		{"name": "team-a", "token": "team-a-key", "events_per_second": 0.001, "burst": 3},
+		{"name": "noisy", "services": ["noisy-*"], "bytes_per_day": 100}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t)
+	srv.quotas, _ = cfg.quotas()
+
+	ingest := func(key string, logs ...string) (*httptest.ResponseRecorder, rateLimited) {
+		body := "[" + strings.Join(logs, ",") + "]"
+		req := httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		rr := httptest.NewRecorder()
+		srv.handleIngest(rr, req)
+		var limited rateLimited
+		if rr.Code == http.StatusTooManyRequests {
+			json.NewDecoder(rr.Body).Decode(&limited)
+		}
+		return rr, limited
+	}
+	log := func(service, message string) string {
+		return fmt.Sprintf(`{"service": %q, "level": "info", "message": %q, "host": "h"}`, service, message)
+	}
+
+	// Token quotas take every event of the request
+	if rr, _ := ingest("team-a-key", log("api", "a"), log("api", "b")); rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rr.Code)
+	}
+	rr, limited := ingest("team-a-key", log("api", "c"), log("api", "d"))
+	if rr.Code != http.StatusTooManyRequests || limited.Code != "quota_exceeded" || limited.Scope != "token" ||
+		limited.Quota != "team-a" || limited.Unit != "events/s" || limited.Requested != 2 || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected the token quota exceeded, got %d %+v", rr.Code, limited)
+	}
+	if _, limited = ingest("team-a-key", log("api", "1"), log("api", "2"), log("api", "3"), log("api", "4")); !strings.Contains(limited.Details, "smaller batches") {
+		t.Errorf("expected a batch over the burst refused, got %+v", limited)
+	}
+	// The refused requests took nothing
+	if rr, _ := ingest("team-a-key", log("api", "e")); rr.Code != http.StatusCreated {
+		t.Errorf("expected the last event accepted, got %d", rr.Code)
+	}
+
+	// Each service has its own daily bytes, and a request over one quota
+	// stores nothing
+	message := strings.Repeat("x", 70)
+	if rr, _ := ingest("", log("noisy-a", message)); rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rr.Code)
+	}
+	rr, limited = ingest("", log("api", "mixed"), log("noisy-a", message))
+	if rr.Code != http.StatusTooManyRequests || limited.Scope != "service" || limited.Service != "noisy-a" ||
+		limited.Unit != "bytes/day" || limited.Used != 82 || limited.Limit != 100 || rr.Header().Get("X-RateLimit-Remaining") != "18" {
+		t.Errorf("expected noisy-a's quota exceeded, got %d %+v", rr.Code, limited)
+	}
+	if rr, _ := ingest("", log("noisy-b", message)); rr.Code != http.StatusCreated {
+		t.Errorf("expected noisy-b within its own quota, got %d", rr.Code)
+	}
+	if logs := queryAll(t, srv); len(logs) != 5 {
+		t.Errorf("expected 5 logs stored, got %d", len(logs))
+	}
+
+	stats := func(key string) []models.QuotaUsage {
+		req := httptest.NewRequest(http.MethodGet, "/api/stats/quotas", nil)
+		req.Header.Set("X-Api-Key", key)
+		rr := httptest.NewRecorder()
+		srv.handleQuotaStats(rr, req)
+		var usage []models.QuotaUsage
+		json.NewDecoder(rr.Body).Decode(&usage)
+		return usage
+	}
+	usage := stats("")
+	if len(usage) != 3 || usage[0].Quota != "team-a" || usage[0].EventsToday != 3 || usage[0].RejectedToday != 2 ||
+		usage[0].EventsAvailable == nil || *usage[0].EventsAvailable != 0 ||
+		usage[1].Service != "noisy-a" || usage[1].BytesToday != 82 || usage[1].RejectedToday != 1 || usage[2].Service != "noisy-b" {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	// With an admin token, token quotas are only shown to their senders
+	srv.adminToken = "admin"
+	if usage := stats(""); len(usage) != 2 {
+		t.Errorf("expected only service quotas, got %+v", usage)
+	}
+	if usage := stats("team-a-key"); len(usage) != 3 {
+		t.Errorf("expected the sender's token quota, got %+v", usage)
+	}
+
+	// Services that are turned away are not recorded, and past days'
+	// usage is pruned
+	if rr, _ := ingest("", log("noisy-c", strings.Repeat("x", 200))); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected noisy-c over its quota, got %d", rr.Code)
+	}
+	if n := len(srv.quotas.usage); n != 3 {
+		t.Errorf("expected usage of team-a, noisy-a and noisy-b, got %d entries", n)
+	}
+
+	// Logs that fail to be stored are given back
+	closed, err := db.NewWithOptions(":memory:", db.Options{MaxOpenConns: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	srv.db = closed
+	if rr, _ := ingest("", log("noisy-b", "lost")); rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 from a closed database, got %d", rr.Code)
+	}
+	if u := srv.quotas.usage[usageKey("noisy", "noisy-b")]; u.events != 1 || u.bytes != 82 {
+		t.Errorf("expected noisy-b's usage refunded, got %+v", u)
+	}
+	if n := srv.quotas.prune(time.Now()); n != 0 {
+		t.Errorf("expected today's usage kept, pruned %d", n)
+	}
+	if n := srv.quotas.prune(time.Now().Add(24 * time.Hour)); n != 3 || len(srv.quotas.usage) != 0 {
+		t.Errorf("expected yesterday's usage pruned, pruned %d, left %d", n, len(srv.quotas.usage))
+	}
+}
@@ -0,0 +1,303 @@
+package logservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitScopeIP is the scope of limits applying per client address.
+const rateLimitScopeIP = "ip"
+
+// The ingest endpoints, as named in rate_limits.endpoints.
+const (
+	endpointIngest    = "ingest"    // /api/ingest
+	endpointHeartbeat = "heartbeat" // /api/heartbeat
+	endpointOTLP      = "otlp"      // /v1/logs and OTLP/gRPC
+	endpointElastic   = "elastic"   // /es/_bulk
+	endpointSentry    = "sentry"    // Sentry store and envelope intake
+	endpointGitHub    = "github"    // /api/ingest/github
+	endpointUploads   = "uploads"   // /api/uploads
+)
+
+var rateLimitEndpoints = []string{endpointIngest, endpointHeartbeat, endpointOTLP, endpointElastic, endpointSentry, endpointGitHub, endpointUploads}
+
+const (
+	// defaultRateLimit and defaultRateBurst are the per-IP limit of ingest
+	// endpoints unless rate_limits sets another.
+	defaultRateLimit = 100
+	defaultRateBurst = 100
+	// defaultRateLimitIdleTTL is how long an idle client address keeps its
+	// limiter.
+	defaultRateLimitIdleTTL = 10 * time.Minute
+)
+
+// rateLimitConfig sets the per-IP limits of ingest endpoints, e.g.
+// {"rate": 200, "burst": 400, "endpoints": {"otlp": {"rate": 1000}}}.
+type rateLimitConfig struct {
+	Rate  float64 `json:"rate"`  // requests per second (default 100)
+	Burst int     `json:"burst"` // default: rate rounded up
+	// IdleTTL is how long a client address that sent nothing keeps its
+	// limiter, e.g. "30m" (default 10m).
+	IdleTTL string `json:"idle_ttl"`
+	// Endpoints give endpoints their own limit instead of sharing the
+	// default one.
+	Endpoints map[string]endpointRateLimit `json:"endpoints"`
+}
+
+type endpointRateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// rateLimits returns the default per-IP limiter, the limiters of endpoints
+// with their own limit and how long idle client addresses are kept.
+func (c *fileConfig) rateLimits() (*ipRateLimiter, map[string]*ipRateLimiter, time.Duration, error) {
+	rc := c.RateLimits
+	if rc == nil {
+		return newIPRateLimiter(defaultRateLimit, defaultRateBurst), nil, defaultRateLimitIdleTTL, nil
+	}
+	limit := func(name string, r float64, burst int) (*ipRateLimiter, error) {
+		if r <= 0 || burst < 0 {
+			return nil, fmt.Errorf("rate_limits%s: rate must be positive and burst not negative", name)
+		}
+		if burst == 0 {
+			burst = int(math.Ceil(r))
+		}
+		return newIPRateLimiter(rate.Limit(r), burst), nil
+	}
+
+	r, burst := rc.Rate, rc.Burst
+	if r == 0 {
+		r = defaultRateLimit
+		if burst == 0 {
+			burst = defaultRateBurst
+		}
+	}
+	limiter, err := limit("", r, burst)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	idleTTL := defaultRateLimitIdleTTL
+	if rc.IdleTTL != "" {
+		if idleTTL, err = time.ParseDuration(rc.IdleTTL); err != nil || idleTTL <= 0 {
+			return nil, nil, 0, fmt.Errorf("rate_limits: invalid idle_ttl %q", rc.IdleTTL)
+		}
+	}
+	var endpoints map[string]*ipRateLimiter
+	for name, ec := range rc.Endpoints {
+		if !slices.Contains(rateLimitEndpoints, name) {
+			return nil, nil, 0, fmt.Errorf("rate_limits: unknown endpoint %q (expected one of %v)", name, rateLimitEndpoints)
+		}
+		l, err := limit(".endpoints."+name, ec.Rate, ec.Burst)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if endpoints == nil {
+			endpoints = make(map[string]*ipRateLimiter)
+		}
+		endpoints[name] = l
+	}
+	return limiter, endpoints, idleTTL, nil
+}
+
+// ipRateLimiter implements per-IP rate limiting. Limiters of addresses that
+// have been idle for a while are dropped by evictIdle.
+type ipRateLimiter struct {
+	limiters sync.Map // map[string]*ipLimiter
+	rate     rate.Limit
+	burst    int
+}
+
+// ipLimiter is the limiter of one address and when it was last used.
+type ipLimiter struct {
+	*rate.Limiter
+	lastSeen atomic.Int64 // Unix nanoseconds
+}
+
+func newIPRateLimiter(r rate.Limit, burst int) *ipRateLimiter {
+	return &ipRateLimiter{
+		rate:  r,
+		burst: burst,
+	}
+}
+
+func (l *ipRateLimiter) getLimiter(ip string) *rate.Limiter {
+	v, ok := l.limiters.Load(ip)
+	if !ok {
+		v, _ = l.limiters.LoadOrStore(ip, &ipLimiter{Limiter: rate.NewLimiter(l.rate, l.burst)})
+	}
+	entry := v.(*ipLimiter)
+	entry.lastSeen.Store(time.Now().UnixNano())
+	return entry.Limiter
+}
+
+// evictIdle drops the limiters of addresses unused for ttl whose bucket has
+// refilled, so a new limiter behaves the same. It returns how many it
+// dropped.
+func (l *ipRateLimiter) evictIdle(now time.Time, ttl time.Duration) int {
+	evicted := 0
+	cutoff := now.Add(-ttl).UnixNano()
+	l.limiters.Range(func(key, v any) bool {
+		entry := v.(*ipLimiter)
+		if entry.lastSeen.Load() < cutoff && entry.TokensAt(now) >= float64(l.burst) &&
+			l.limiters.CompareAndDelete(key, entry) {
+			evicted++
+		}
+		return true
+	})
+	return evicted
+}
+
+// size returns how many addresses have a limiter.
+func (l *ipRateLimiter) size() int {
+	n := 0
+	l.limiters.Range(func(any, any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// rateLimiter returns the limiter of endpoint.
+func (s *server) rateLimiter(endpoint string) *ipRateLimiter {
+	if l, ok := s.endpointLimiters[endpoint]; ok {
+		return l
+	}
+	return s.limiter
+}
+
+// rateLimitRoutine evicts idle client addresses from the rate limiters, and
+// past days from the quota usage, until ctx is canceled.
+func (s *server) rateLimitRoutine(ctx context.Context, idleTTL time.Duration) {
+	ticker := time.NewTicker(idleTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			limiters := []*ipRateLimiter{s.limiter}
+			for _, l := range s.endpointLimiters {
+				limiters = append(limiters, l)
+			}
+			if s.incidents != nil {
+				limiters = append(limiters, s.incidents.limiter)
+			}
+			limiters = append(limiters, s.quotas.limiters()...)
+			evicted, remaining := 0, 0
+			for _, l := range limiters {
+				evicted += l.evictIdle(now, idleTTL)
+				remaining += l.size()
+			}
+			if evicted > 0 {
+				slog.Debug("evicted idle rate limiters", "evicted", evicted, "remaining", remaining)
+			}
+			if pruned := s.quotas.prune(now); pruned > 0 {
+				slog.Debug("pruned quota usage of past days", "entries", pruned)
+			}
+		}
+	}
+}
+
+// rateLimited describes a rejected ingest request, for 429 bodies.
+type rateLimited struct {
+	apiError
+	Scope             string    `json:"scope"` // what the limit applies to: "ip"
+	Client            string    `json:"client"`
+	Endpoint          string    `json:"endpoint,omitempty"` // set when the endpoint has its own limit
+	Quota             string    `json:"quota,omitempty"`    // the quota exceeded, for scopes token and service
+	Service           string    `json:"service,omitempty"`  // the service, for scope service
+	Limit             float64   `json:"limit"`              // requests per second
+	Burst             int       `json:"burst"`
+	RetryAfterSeconds int       `json:"retry_after_seconds"`
+	ResetAt           time.Time `json:"reset_at"` // when a request will next be accepted
+
+	// Quotas limit events per second or bytes per day rather than
+	// requests per second: Unit says which Limit counts, Used is what was
+	// used of a daily allowance and Requested what the request needed.
+	Unit      string `json:"unit,omitempty"`
+	Used      int64  `json:"used,omitempty"`
+	Requested int64  `json:"requested,omitempty"`
+}
+
+// reserve takes a token for key, or returns how long until one is available.
+func (l *ipRateLimiter) reserve(key string, now time.Time) (time.Duration, bool) {
+	res := l.getLimiter(key).ReserveN(now, 1)
+	if !res.OK() {
+		// A zero burst never admits a request; ask for the longest sane wait
+		return time.Hour, false
+	}
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return delay, false
+	}
+	return 0, true
+}
+
+// checkRateLimit takes a token from the client's per-IP limit of endpoint.
+// When it is exhausted it returns what the 429 response should report;
+// protocols with their own error format set the headers with setHeaders and
+// write the rest as they can.
+func (s *server) checkRateLimit(endpoint, ip string) *rateLimited {
+	now := time.Now()
+	limiter := s.rateLimiter(endpoint)
+	delay, ok := limiter.reserve(ip, now)
+	if ok {
+		return nil
+	}
+	retryAfter := int(math.Ceil(delay.Seconds()))
+	limited := &rateLimited{
+		apiError: apiError{
+			Error: "Rate limit exceeded",
+			Code:  "rate_limited",
+			Details: fmt.Sprintf("at most %g requests per second (burst %d) per client address; retry after %ds",
+				float64(limiter.rate), limiter.burst, retryAfter),
+		},
+		Scope:             rateLimitScopeIP,
+		Client:            ip,
+		Limit:             float64(limiter.rate),
+		Burst:             limiter.burst,
+		RetryAfterSeconds: retryAfter,
+		ResetAt:           now.Add(delay).UTC(),
+	}
+	if limiter != s.limiter {
+		limited.Endpoint = endpoint
+	}
+	return limited
+}
+
+// setHeaders tells the client when to retry, with Retry-After in seconds as
+// shippers such as Vector expect, and describes the limit with the
+// X-RateLimit headers: the burst (or a daily byte quota), what is left of it
+// and the Unix time a request will next be accepted.
+func (l *rateLimited) setHeaders(w http.ResponseWriter) {
+	limit, remaining := int64(l.Burst), int64(0)
+	if l.Unit == quotaUnitBytes {
+		limit, remaining = int64(l.Limit), max(int64(l.Limit)-l.Used, 0)
+	}
+	h := w.Header()
+	h.Set("Retry-After", strconv.Itoa(l.RetryAfterSeconds))
+	h.Set("X-RateLimit-Limit", strconv.FormatInt(limit, 10))
+	h.Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(float64(l.ResetAt.UnixNano())/1e9)), 10))
+}
+
+// writeRateLimited writes a JSON 429 response.
+func writeRateLimited(w http.ResponseWriter, limited *rateLimited) {
+	limited.setHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(limited)
+}
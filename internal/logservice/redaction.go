@@ -0,0 +1,254 @@
+package logservice
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"locog/internal/db"
+	"locog/internal/models"
+)
+
+var redactionRuleNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// redactedPattern matches the placeholder left in place of a redacted value:
+// [REDACTED:<rule>] or, with the vault, [REDACTED:<rule>:<token>].
+var redactedPattern = regexp.MustCompile(`^\[REDACTED:[^\]:]+(:[0-9a-f]{16})?\]$`)
+
+// vaultTokenPattern matches the vault tokens in placeholders.
+var vaultTokenPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// redactionConfig replaces sensitive values at ingest, before logs are stored
+// or streamed.
+type redactionConfig struct {
+	// Rules replace matches in messages and string metadata values.
+	Rules []redactionRuleConfig `json:"rules"`
+
+	// MetadataKeys have their values replaced whole, e.g. ["password",
+	// "http.authorization"]. Matching is case-insensitive.
+	MetadataKeys []string `json:"metadata_keys"`
+
+	// Vault keeps the originals encrypted with -vault-key so they can be
+	// revealed later with -vault-reveal-token; otherwise they are destroyed.
+	Vault bool `json:"vault"`
+}
+
+type redactionRuleConfig struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"` // regular expression, e.g. "[\\w.+-]+@[\\w-]+\\.[\\w.]+"
+}
+
+type redactionRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// redactor applies the configured redaction to logs.
+type redactor struct {
+	rules []redactionRule
+	keys  [][]string // lower case key paths
+	// vault encrypts originals; nil destroys them
+	vault cipher.AEAD
+}
+
+// redactor validates the redaction config, or returns nil when there is none.
+// The vault cipher is set separately from -vault-key.
+func (c *fileConfig) redactor() (*redactor, error) {
+	if c.Redaction == nil || len(c.Redaction.Rules) == 0 && len(c.Redaction.MetadataKeys) == 0 {
+		return nil, nil
+	}
+	rd := &redactor{}
+	names := make(map[string]bool)
+	for _, rc := range c.Redaction.Rules {
+		if !redactionRuleNamePattern.MatchString(rc.Name) || names[rc.Name] {
+			return nil, fmt.Errorf("redaction rule %q: names must be unique, 1-64 lower case letters, digits, '_' or '-'", rc.Name)
+		}
+		names[rc.Name] = true
+		re, err := regexp.Compile(rc.Pattern)
+		if err != nil || rc.Pattern == "" {
+			return nil, fmt.Errorf("redaction rule %s: invalid pattern %q", rc.Name, rc.Pattern)
+		}
+		rd.rules = append(rd.rules, redactionRule{name: rc.Name, re: re})
+	}
+	for _, key := range c.Redaction.MetadataKeys {
+		if !metaKeyPattern.MatchString(key) || len(key) > 64 {
+			return nil, fmt.Errorf("redaction: invalid metadata key %q", key)
+		}
+		rd.keys = append(rd.keys, strings.Split(strings.ToLower(key), "."))
+	}
+	return rd, nil
+}
+
+// newVaultCipher creates the vault cipher from a hex-encoded 32-byte key.
+func newVaultCipher(key string) (cipher.AEAD, error) {
+	raw, err := hex.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("-vault-key must be 64 hex characters (32 bytes), e.g. from 'openssl rand -hex 32'")
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// redact replaces sensitive values in l and returns the vault entries holding
+// the originals, if the vault is enabled. Metadata keys are redacted before
+// patterns, and the placeholders they leave are not matched again.
+func (rd *redactor) redact(l *models.Log, now time.Time) ([]db.VaultEntry, error) {
+	var entries []db.VaultEntry
+	for _, path := range rd.keys {
+		if err := rd.redactKey(l.Metadata, path, strings.Join(path, "."), now, &entries); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if l.Message, err = rd.redactString(l.Message, now, &entries); err != nil {
+		return nil, err
+	}
+	if len(rd.rules) > 0 && l.Metadata != nil {
+		for k, v := range l.Metadata {
+			if l.Metadata[k], err = rd.redactValue(v, now, &entries); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entries, nil
+}
+
+// redactKey replaces the value at a key path, matching keys case-insensitively.
+// The placeholder names the full key.
+func (rd *redactor) redactKey(m map[string]interface{}, path []string, key string, now time.Time, entries *[]db.VaultEntry) error {
+	for k, v := range m {
+		if strings.ToLower(k) != path[0] {
+			continue
+		}
+		if len(path) > 1 {
+			if nested, ok := v.(map[string]interface{}); ok {
+				if err := rd.redactKey(nested, path[1:], key, now, entries); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if s, ok := v.(string); ok && redactedPattern.MatchString(s) {
+			continue
+		}
+		original, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if m[k], err = rd.placeholder(key, original, now, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redactValue applies the rules to the strings within a metadata value.
+func (rd *redactor) redactValue(v interface{}, now time.Time, entries *[]db.VaultEntry) (interface{}, error) {
+	var err error
+	switch v := v.(type) {
+	case string:
+		return rd.redactString(v, now, entries)
+	case map[string]interface{}:
+		for k, nested := range v {
+			if v[k], err = rd.redactValue(nested, now, entries); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, nested := range v {
+			if v[i], err = rd.redactValue(nested, now, entries); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// redactString replaces rule matches in s. Matches are found on the original
+// string, so one rule never matches another's placeholder; where matches
+// overlap the earliest, then longest, wins.
+func (rd *redactor) redactString(s string, now time.Time, entries *[]db.VaultEntry) (string, error) {
+	if len(rd.rules) == 0 || redactedPattern.MatchString(s) {
+		return s, nil
+	}
+	type match struct {
+		start, end int
+		rule       string
+	}
+	var matches []match
+	for _, rule := range rd.rules {
+		for _, loc := range rule.re.FindAllStringIndex(s, -1) {
+			if loc[1] > loc[0] {
+				matches = append(matches, match{loc[0], loc[1], rule.name})
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return s, nil
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].start != matches[j].start {
+			return matches[i].start < matches[j].start
+		}
+		return matches[i].end > matches[j].end
+	})
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		if m.start < last {
+			continue
+		}
+		original, _ := json.Marshal(s[m.start:m.end])
+		placeholder, err := rd.placeholder(m.rule, original, now, entries)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s[last:m.start])
+		b.WriteString(placeholder)
+		last = m.end
+	}
+	b.WriteString(s[last:])
+	return b.String(), nil
+}
+
+// placeholder returns the text replacing a redacted value, storing the
+// original (as JSON) in a new vault entry when the vault is enabled.
+func (rd *redactor) placeholder(rule string, original []byte, now time.Time, entries *[]db.VaultEntry) (string, error) {
+	if rd.vault == nil {
+		return "[REDACTED:" + rule + "]", nil
+	}
+	id := make([]byte, 8)
+	nonce := make([]byte, rd.vault.NonceSize())
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(id)
+	// The token is authenticated with the value so entries can't be swapped
+	ciphertext := rd.vault.Seal(nonce, nonce, original, []byte(token))
+	*entries = append(*entries, db.VaultEntry{Token: token, Rule: rule, Ciphertext: ciphertext, CreatedAt: now})
+	return "[REDACTED:" + rule + ":" + token + "]", nil
+}
+
+// open decrypts a vault entry, returning the original value as JSON.
+func (rd *redactor) open(e db.VaultEntry) (json.RawMessage, error) {
+	size := rd.vault.NonceSize()
+	if len(e.Ciphertext) < size {
+		return nil, fmt.Errorf("vault entry %s is truncated", e.Token)
+	}
+	return rd.vault.Open(nil, e.Ciphertext[:size], e.Ciphertext[size:], []byte(e.Token))
+}
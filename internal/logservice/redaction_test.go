@@ -0,0 +1,157 @@
+package logservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"locog/internal/models"
+)
+
+const redactionConfigJSON = `{"redaction": {
+	"rules": [
+		{"name": "email", "pattern": "[\\w.+-]+@[\\w-]+\\.[\\w.]+"},
+		{"name": "card", "pattern": "\\b\\d{4}(-\\d{4}){3}\\b"}
+	],
+	"metadata_keys": ["password", "http.Authorization"],
+	"vault": true
+}}`
+
+const testVaultKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// TestLoadConfig_Redaction tests redaction rule validation.
+func TestLoadConfig_Redaction(t *testing.T) {
+	cfg, err := loadConfig(writeTestConfig(t, redactionConfigJSON))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rd, _ := cfg.redactor()
+	if rd == nil || len(rd.rules) != 2 || strings.Join(rd.keys[1], ".") != "http.authorization" {
+		t.Errorf("unexpected redactor: %+v", rd)
+	}
+
+	for _, bad := range []string{
+		`{"redaction": {"rules": [{"name": "Email", "pattern": "@"}]}}`,
+		`{"redaction": {"rules": [{"name": "a", "pattern": "x"}, {"name": "a", "pattern": "y"}]}}`,
+		`{"redaction": {"rules": [{"name": "a", "pattern": "("}]}}`,
+		`{"redaction": {"rules": [{"name": "a", "pattern": ""}]}}`,
+		`{"redaction": {"metadata_keys": ["bad key"]}}`,
+	} {
+		if _, err := loadConfig(writeTestConfig(t, bad)); err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+
+	if _, err := newVaultCipher("abc"); err == nil {
+		t.Error("expected a short vault key to be rejected")
+	}
+}
+
+// TestRedact tests that patterns and metadata keys are replaced, without the
+// vault leaving only the rule name.
+func TestRedact(t *testing.T) {
+	cfg, _ := loadConfig(writeTestConfig(t, redactionConfigJSON))
+	rd, _ := cfg.redactor()
+
+	l := models.Log{
+		Message: "login by ann@example.com with card 4111-1111-1111-1111",
+		Metadata: map[string]interface{}{
+			"password": "hunter2",
+			"http":     map[string]interface{}{"authorization": "Bearer x", "path": "/login"},
+			"emails":   []interface{}{"bob@example.com", 3},
+		},
+	}
+	entries, err := rd.redact(&l, l.Timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no vault entries without the vault, got %d", len(entries))
+	}
+	if l.Message != "login by [REDACTED:email] with card [REDACTED:card]" {
+		t.Errorf("unexpected message: %q", l.Message)
+	}
+	httpMeta := l.Metadata["http"].(map[string]interface{})
+	if l.Metadata["password"] != "[REDACTED:password]" || httpMeta["authorization"] != "[REDACTED:http.authorization]" ||
+		httpMeta["path"] != "/login" || l.Metadata["emails"].([]interface{})[0] != "[REDACTED:email]" {
+		t.Errorf("unexpected metadata: %v", l.Metadata)
+	}
+}
+
+// TestVaultReveal tests ingesting with the vault, revealing the originals
+// with the reveal token and auditing the reveal.
+func TestVaultReveal(t *testing.T) {
+	srv := newTestServer(t)
+	cfg, _ := loadConfig(writeTestConfig(t, redactionConfigJSON))
+	srv.redactor, _ = cfg.redactor()
+	srv.redactor.vault, _ = newVaultCipher(testVaultKey)
+	srv.adminToken = "admin"
+	srv.vaultRevealToken = "revealer"
+
+	body := `{"service": "auth", "level": "INFO", "host": "h", "message": "login by ann@example.com",
+		"metadata": {"password": {"plain": "hunter2"}}}`
+	rr := httptest.NewRecorder()
+	srv.handleIngest(rr, httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("ingest failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	logs, _ := srv.db.QueryLogs(t.Context(), models.LogFilter{})
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(logs))
+	}
+	placeholder := regexp.MustCompile(`\[REDACTED:email:([0-9a-f]{16})\]`).FindStringSubmatch(logs[0].Message)
+	if placeholder == nil || strings.Contains(logs[0].Message, "ann@") {
+		t.Fatalf("expected a vault placeholder, got %q", logs[0].Message)
+	}
+	emailToken := placeholder[1]
+	passwordToken := strings.TrimSuffix(strings.TrimPrefix(logs[0].Metadata["password"].(string), "[REDACTED:password:"), "]")
+
+	reveal := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/vault/reveal", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		srv.handleVaultReveal(rr, req)
+		return rr
+	}
+	reqBody, _ := json.Marshal(vaultRevealRequest{
+		Tokens: []string{emailToken, passwordToken, "0000000000000000"},
+		Reason: "INC-42 account takeover",
+	})
+
+	// The admin token doesn't grant reveals
+	if rr := reveal("admin", string(reqBody)); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the admin token to be refused, got %d", rr.Code)
+	}
+	if rr := reveal("revealer", `{"tokens": ["`+emailToken+`"]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected a reveal without a reason to be refused, got %d", rr.Code)
+	}
+
+	rr = reveal("revealer", string(reqBody))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("reveal failed: %d %s", rr.Code, rr.Body.String())
+	}
+	var resp vaultRevealResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if string(resp.Values[emailToken].Value) != `"ann@example.com"` || resp.Values[emailToken].Rule != "email" {
+		t.Errorf("unexpected email reveal: %+v", resp.Values[emailToken])
+	}
+	if !bytes.Equal(resp.Values[passwordToken].Value, []byte(`{"plain":"hunter2"}`)) {
+		t.Errorf("unexpected password reveal: %s", resp.Values[passwordToken].Value)
+	}
+	if len(resp.Missing) != 1 || resp.Missing[0] != "0000000000000000" {
+		t.Errorf("expected the unknown token to be missing, got %v", resp.Missing)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.handleVaultReveals(rr, httptest.NewRequest(http.MethodGet, "/api/admin/vault/reveals", nil))
+	var reveals []models.VaultReveal
+	json.NewDecoder(rr.Body).Decode(&reveals)
+	if len(reveals) != 1 || reveals[0].Reason != "INC-42 account takeover" || len(reveals[0].Tokens) != 3 {
+		t.Errorf("expected one audited reveal, got %+v", reveals)
+	}
+}
@@ -0,0 +1,504 @@
+package logservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"locog/internal/archive"
+	"locog/internal/db"
+	"locog/internal/models"
+)
+
+// tagExportBatch is the number of logs exported per query.
+const tagExportBatch = 1000
+
+// tagExport archives logs whose tags match pattern to dir.
+type tagExport struct {
+	pattern string
+	dir     string
+	// template renders text exports; nil writes NDJSON
+	template *template.Template
+}
+
+// exportTaggedLogs appends logs carrying a tag covered by an export policy to
+// the tag's archive in the policy's directory, <tag>.ndjson with one JSON log
+// per line or <tag>.log rendered with the policy's template, each batch as a
+// chunk recorded in the archive's manifest. Each log is exported once per
+// tag; it is marked as exported only after its chunk has been recorded. A
+// failing policy doesn't hold up the others.
+func (s *server) exportTaggedLogs(ctx context.Context) error {
+	var errs []error
+	for _, te := range s.tagExports {
+		if err := s.exportPolicy(ctx, te); err != nil {
+			errs = append(errs, fmt.Errorf("export %s: %w", te.pattern, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// exportPolicy exports the pending logs of one export policy.
+func (s *server) exportPolicy(ctx context.Context, te tagExport) error {
+	// Archives written before they had manifests get one, so they can be
+	// verified
+	if adopted, err := archive.Adopt(te.dir, te.pattern); err != nil {
+		return err
+	} else if adopted > 0 {
+		slog.Info("wrote manifests of existing archives", "pattern", te.pattern, "dir", te.dir, "count", adopted)
+	}
+
+	total := 0
+	for {
+		pending, err := s.db.PendingTagExports(ctx, te.pattern, tagExportBatch)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			break
+		}
+		if err := writeTagExports(te.dir, te.template, pending); err != nil {
+			return err
+		}
+		if err := s.db.MarkTagsExported(ctx, pending); err != nil {
+			return err
+		}
+		total += len(pending)
+	}
+	if total > 0 {
+		slog.Info("exported tagged logs", "pattern", te.pattern, "dir", te.dir, "count", total)
+	}
+	return nil
+}
+
+func writeTagExports(dir string, tmpl *template.Template, exports []db.TagExport) error {
+	byTag := make(map[string][]models.Log)
+	for _, e := range exports {
+		byTag[e.Tag] = append(byTag[e.Tag], e.Log)
+	}
+	for tag, logs := range byTag {
+		if err := archive.Append(dir, tag, tmpl, logs); err != nil {
+			return fmt.Errorf("export tag %s: %w", tag, err)
+		}
+	}
+	return nil
+}
+
+const (
+	// defaultRetentionPeriod is how long logs are kept unless configured.
+	defaultRetentionPeriod = 30 * 24 * time.Hour
+	// defaultCleanupInterval is how often logs past retention are deleted
+	// unless configured.
+	defaultCleanupInterval = 24 * time.Hour
+	// minRetentionPeriod and minCleanupInterval keep a typo such as "30m"
+	// for "30d" from emptying the database, or cleanups from running back
+	// to back.
+	minRetentionPeriod = time.Hour
+	minCleanupInterval = time.Minute
+)
+
+// retention returns the configured log retention and cleanup interval,
+// falling back to period and interval, e.g. from flags, where unset.
+func (c *fileConfig) retention(period, interval time.Duration) (time.Duration, time.Duration, error) {
+	var err error
+	if c.Retention != "" {
+		if period, err = parseRetention(c.Retention); err != nil {
+			return 0, 0, fmt.Errorf("retention: invalid duration %q", c.Retention)
+		}
+	}
+	if c.CleanupInterval != "" {
+		if interval, err = parseRetention(c.CleanupInterval); err != nil {
+			return 0, 0, fmt.Errorf("cleanup_interval: invalid duration %q", c.CleanupInterval)
+		}
+	}
+	if err := validateRetention(period, interval); err != nil {
+		return 0, 0, err
+	}
+	return period, interval, nil
+}
+
+// validateRetention checks a log retention and cleanup interval.
+func validateRetention(period, interval time.Duration) error {
+	if period < minRetentionPeriod {
+		return fmt.Errorf("retention must be at least %s, got %s", minRetentionPeriod, formatRetention(period))
+	}
+	if interval < minCleanupInterval {
+		return fmt.Errorf("cleanup interval must be at least %s, got %s", minCleanupInterval, formatRetention(interval))
+	}
+	return nil
+}
+
+// retentionSchedule is how long logs are kept and how often cleanupRoutine
+// deletes older ones. /api/admin/retention changes it at runtime, until the
+// next restart. A nil retentionSchedule has the defaults.
+type retentionSchedule struct {
+	mu       sync.Mutex
+	period   time.Duration
+	interval time.Duration
+	next     time.Time // of the next cleanup, once scheduled
+
+	// changed wakes cleanupRoutine to reschedule
+	changed chan struct{}
+}
+
+func newRetentionSchedule(period, interval time.Duration) *retentionSchedule {
+	return &retentionSchedule{period: period, interval: interval, changed: make(chan struct{}, 1)}
+}
+
+// get returns the log retention and the cleanup interval.
+func (rs *retentionSchedule) get() (period, interval time.Duration) {
+	if rs == nil {
+		return defaultRetentionPeriod, defaultCleanupInterval
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.period, rs.interval
+}
+
+// retention returns how long logs are kept.
+func (rs *retentionSchedule) retention() time.Duration {
+	period, _ := rs.get()
+	return period
+}
+
+// set changes the schedule, restarting the wait for the next cleanup.
+func (rs *retentionSchedule) set(period, interval time.Duration) {
+	rs.mu.Lock()
+	rs.period, rs.interval = period, interval
+	rs.mu.Unlock()
+	select {
+	case rs.changed <- struct{}{}:
+	default:
+	}
+}
+
+// schedule records when the next cleanup runs.
+func (rs *retentionSchedule) schedule(next time.Time) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.next = next
+}
+
+// view reports the schedule.
+func (rs *retentionSchedule) view() models.RetentionSchedule {
+	period, interval := rs.get()
+	v := models.RetentionSchedule{Retention: formatRetention(period), CleanupInterval: formatRetention(interval)}
+	if rs != nil {
+		rs.mu.Lock()
+		if !rs.next.IsZero() {
+			next := rs.next.UTC()
+			v.NextCleanup = &next
+		}
+		rs.mu.Unlock()
+	}
+	return v
+}
+
+// handleRetention reports the log retention and cleanup schedule on GET and
+// changes either on PUT, e.g. {"retention": "14d", "cleanup_interval": "6h"},
+// until the next restart. The next cleanup runs one interval after a change.
+func (s *server) handleRetention(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if s.degraded != nil {
+			writeJSONError(w, http.StatusConflict, "cleanup_disabled", "Cleanup is not running",
+				"the retention of a read-only database can't be changed")
+			return
+		}
+		var req models.RetentionSchedule
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON", err.Error())
+			return
+		}
+		period, interval := s.retention.get()
+		var err error
+		if req.Retention != "" {
+			if period, err = parseRetention(req.Retention); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid retention value",
+					fmt.Sprintf("'retention' must be a duration such as 14d or 72h, got: %s", req.Retention))
+				return
+			}
+		}
+		if req.CleanupInterval != "" {
+			if interval, err = parseRetention(req.CleanupInterval); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid cleanup_interval value",
+					fmt.Sprintf("'cleanup_interval' must be a duration such as 6h or 1d, got: %s", req.CleanupInterval))
+				return
+			}
+		}
+		if err := validateRetention(period, interval); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid retention schedule", err.Error())
+			return
+		}
+		s.retention.set(period, interval)
+		slog.Info("retention schedule changed", "retention", formatRetention(period), "cleanup_interval", formatRetention(interval))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.retention.view())
+}
+
+// tableRetention validates the configured auxiliary table retention.
+func (c *fileConfig) tableRetention() (map[string]time.Duration, error) {
+	retention := make(map[string]time.Duration, len(c.TableRetention))
+	for table, v := range c.TableRetention {
+		if !slices.Contains(db.RetainedTables(), table) {
+			return nil, fmt.Errorf("table_retention: unknown table %q; use %s", table, strings.Join(db.RetainedTables(), ", "))
+		}
+		keep, err := parseRetention(v)
+		if err != nil || keep <= 0 {
+			return nil, fmt.Errorf("table_retention: %s: invalid retention %q", table, v)
+		}
+		retention[table] = keep
+	}
+	return retention, nil
+}
+
+// cleanupTables deletes expired rows of the auxiliary tables with a
+// configured retention, in name order.
+func (s *server) cleanupTables(ctx context.Context) {
+	tables := make([]string, 0, len(s.tableRetention))
+	for table := range s.tableRetention {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		keep := s.tableRetention[table]
+		start := time.Now()
+		deleted, err := s.db.DeleteOldRows(ctx, table, keep)
+		duration := time.Since(start)
+		s.cleanups.record(table, keep, start, deleted, duration, err)
+		if err != nil {
+			slog.Error("table cleanup failed", "table", table, "error", err, "duration_ms", duration.Milliseconds())
+		} else {
+			slog.Info("table cleanup completed", "table", table, "deleted", deleted, "duration_ms", duration.Milliseconds())
+		}
+	}
+}
+
+// cleanupStats records the retention cleanups of each table since startup.
+type cleanupStats struct {
+	mu     sync.Mutex
+	tables map[string]*models.TableCleanup
+}
+
+func newCleanupStats() *cleanupStats {
+	return &cleanupStats{tables: make(map[string]*models.TableCleanup)}
+}
+
+// record adds a cleanup of table. A nil cleanupStats records nothing.
+func (c *cleanupStats) record(table string, keep time.Duration, at time.Time, deleted int64, duration time.Duration, err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.tables[table]
+	if !ok {
+		t = &models.TableCleanup{Table: table}
+		c.tables[table] = t
+	}
+	t.Retention = formatRetention(keep)
+	t.LastRun = at.UTC()
+	t.LastDeleted = deleted
+	t.LastDurationMs = duration.Milliseconds()
+	t.LastError = ""
+	if err != nil {
+		t.LastError = err.Error()
+	}
+	t.TotalDeleted += deleted
+	t.Runs++
+}
+
+// list returns the statistics of every table cleaned so far, by name.
+func (c *cleanupStats) list() []models.TableCleanup {
+	stats := []models.TableCleanup{}
+	if c == nil {
+		return stats
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range c.tables {
+		stats = append(stats, *t)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Table < stats[j].Table })
+	return stats
+}
+
+// formatRetention prints whole days as "30d", like the config file.
+func formatRetention(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+// handleRetentionStats reports, per table, what retention cleanups deleted
+// since startup: the logs table and the auxiliary tables in
+// table_retention.
+func (s *server) handleRetentionStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.cleanups.list())
+}
+
+// handleCleanup runs a cleanup now, without waiting for the next scheduled
+// one, e.g. to free disk space, and reports each table's cleanup statistics
+// as /api/admin/retention/stats does. A cleanup outlives a client giving up
+// on the response. 409 cleanup_running means one is already under way.
+func (s *server) handleCleanup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.cleanupMu.TryLock() {
+		writeJSONError(w, http.StatusConflict, "cleanup_running", "A cleanup is already running",
+			"see /api/admin/retention/stats once it finishes")
+		return
+	}
+	defer s.cleanupMu.Unlock()
+
+	slog.Info("cleanup requested")
+	s.cleanup(context.WithoutCancel(r.Context()))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.cleanups.list())
+}
+
+// handleCleanupPreview reports how many logs per service, and rows per
+// auxiliary table in table_retention, a cleanup run now would delete under
+// the current retention. Nothing is deleted.
+func (s *server) handleCleanupPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	logs, err := s.db.RetentionPreview(r.Context(), s.retention.retention(), s.db.TagPolicies())
+	if err != nil {
+		if clientGone(w, r) {
+			return
+		}
+		slog.Error("cleanup preview failed", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	preview := models.CleanupPreview{Cutoff: logs.Cutoff, Total: logs.Total,
+		Services: []models.CleanupCount{}, Tables: []models.CleanupCount{}}
+	services := make(map[string]int64)
+	for _, g := range logs.Groups {
+		services[g.Service] += g.Count
+	}
+	for service, count := range services {
+		preview.Services = append(preview.Services, models.CleanupCount{Name: service, Count: count})
+	}
+	sort.Slice(preview.Services, func(i, j int) bool {
+		a, b := preview.Services[i], preview.Services[j]
+		return a.Count > b.Count || (a.Count == b.Count && a.Name < b.Name)
+	})
+
+	tables := make([]string, 0, len(s.tableRetention))
+	for table := range s.tableRetention {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		count, err := s.db.CountOldRows(r.Context(), table, s.tableRetention[table])
+		if err != nil {
+			if clientGone(w, r) {
+				return
+			}
+			slog.Error("cleanup preview failed", "table", table, "error", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		preview.Tables = append(preview.Tables, models.CleanupCount{Name: table, Count: count})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+// parsePreviewPolicies parses tag_policy parameters of the form
+// "<pattern>:<retention>", e.g. "incident-*:365d". The single value "none"
+// previews without any tag policies.
+func parsePreviewPolicies(values []string) ([]db.TagPolicy, error) {
+	if len(values) == 1 && values[0] == "none" {
+		return nil, nil
+	}
+	policies := make([]db.TagPolicy, 0, len(values))
+	for _, v := range values {
+		i := strings.LastIndex(v, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("tag policy %q must be <pattern>:<retention>", v)
+		}
+		keep, err := parseRetention(v[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("tag policy %q: invalid retention: %w", v, err)
+		}
+		p := db.TagPolicy{Pattern: v[:i], Keep: keep}
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// handleRetentionPreview reports how many logs per service and level the next
+// cleanup would delete. The retention and tag_policy parameters evaluate a
+// hypothetical policy instead of the current one, e.g.
+// ?retention=14d&tag_policy=incident-*:365d. Nothing is deleted.
+func (s *server) handleRetentionPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	retention := s.retention.retention()
+	if v := query.Get("retention"); v != "" {
+		d, err := parseRetention(v)
+		if err != nil || d <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid retention value",
+				fmt.Sprintf("'retention' must be a positive duration such as 14d or 72h, got: %s", v))
+			return
+		}
+		retention = d
+	}
+
+	policies := s.db.TagPolicies()
+	if values := query["tag_policy"]; len(values) > 0 {
+		var err error
+		if policies, err = parsePreviewPolicies(values); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid tag_policy value", err.Error())
+			return
+		}
+	}
+
+	preview, err := s.db.RetentionPreview(r.Context(), retention, policies)
+	if err != nil {
+		slog.Error("retention preview failed", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
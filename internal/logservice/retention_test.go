@@ -0,0 +1,242 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/db"
+	"locog/internal/models"
+)
+
+// TestExportTaggedLogs tests that tagged logs are archived once per tag.
+func TestExportTaggedLogs(t *testing.T) {
+	srv := newTestServer(t)
+	dir := t.TempDir()
+	srv.tagExports = []tagExport{{pattern: "incident-*", dir: dir}}
+
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "ERROR", Message: "boom", Host: "h"})
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now(), Service: "api", Level: "INFO", Message: "fine", Host: "h"})
+	srv.db.TagLogs(t.Context(), models.LogFilter{Level: "ERROR"}, "incident-142")
+
+	for range 2 {
+		if err := srv.exportTaggedLogs(t.Context()); err != nil {
+			t.Fatalf("exportTaggedLogs failed: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "incident-142.ndjson"))
+	if err != nil {
+		t.Fatalf("expected export file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"message":"boom"`) {
+		t.Errorf("expected the tagged log exported exactly once, got %q", data)
+	}
+}
+
+// TestParseRetention tests durations with a day suffix.
+func TestParseRetention(t *testing.T) {
+	if d, err := parseRetention("365d"); err != nil || d != 365*24*time.Hour {
+		t.Errorf("parseRetention(365d) = %v, %v", d, err)
+	}
+	if d, err := parseRetention("36h"); err != nil || d != 36*time.Hour {
+		t.Errorf("parseRetention(36h) = %v, %v", d, err)
+	}
+	if _, err := parseRetention("xd"); err == nil {
+		t.Error("expected error for invalid days")
+	}
+}
+
+// TestHandleRetentionPreview tests current and hypothetical policies.
+func TestHandleRetentionPreview(t *testing.T) {
+	srv := newTestServer(t)
+	old := time.Now().Add(-40 * 24 * time.Hour)
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: old, Service: "api", Level: "ERROR", Message: "boom", Host: "h"})
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: old, Service: "api", Level: "INFO", Message: "fine", Host: "h"})
+	srv.db.InsertLog(t.Context(), &models.Log{Timestamp: time.Now().Add(-time.Hour), Service: "api", Level: "INFO", Message: "new", Host: "h"})
+	srv.db.TagLogs(t.Context(), models.LogFilter{Level: "ERROR"}, "incident-142")
+	srv.db.SetTagPolicies([]db.TagPolicy{{Pattern: "incident-*", Keep: 365 * 24 * time.Hour}})
+
+	preview := func(query string) (int, models.RetentionPreview) {
+		rr := httptest.NewRecorder()
+		srv.handleRetentionPreview(rr, httptest.NewRequest(http.MethodGet, "/api/admin/retention/preview?"+query, nil))
+		var p models.RetentionPreview
+		json.NewDecoder(rr.Body).Decode(&p)
+		return rr.Code, p
+	}
+
+	if code, p := preview(""); code != http.StatusOK || p.Total != 1 || p.KeptByTags != 1 {
+		t.Errorf("unexpected preview under current policy: %d %+v", code, p)
+	}
+	if _, p := preview("tag_policy=none"); p.Total != 2 || p.KeptByTags != 0 {
+		t.Errorf("unexpected preview without tag policies: %+v", p)
+	}
+	if _, p := preview("retention=30m&tag_policy=incident-*:365d"); p.Total != 2 || p.KeptByTags != 1 {
+		t.Errorf("unexpected preview with shorter retention: %+v", p)
+	}
+	for _, query := range []string{"retention=soon", "retention=0d", "tag_policy=incident", "tag_policy=a[b]:1d"} {
+		if code, _ := preview(query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, code)
+		}
+	}
+	if logs := queryAll(t, srv); len(logs) != 3 {
+		t.Errorf("expected preview not to delete logs, got %d remaining", len(logs))
+	}
+}
+
+// TestCleanup_TableRetention tests that configured auxiliary table retention
+// is applied by cleanup and reported per table.
+func TestCleanup_TableRetention(t *testing.T) {
+	for _, cfg := range []string{
+		`{"table_retention": {"logs": "30d"}}`,
+		`{"table_retention": {"vault_reveals": "soon"}}`,
+		`{"table_retention": {"vault_reveals": "0d"}}`,
+	} {
+		if _, err := loadConfig(writeTestConfig(t, cfg)); err == nil {
+			t.Errorf("expected %s to be rejected", cfg)
+		}
+	}
+	cfg, err := loadConfig(writeTestConfig(t, `{"table_retention": {"vault_reveals": "90d"}}`))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+
+	srv := newTestServer(t)
+	srv.tableRetention, _ = cfg.tableRetention()
+	srv.cleanups = newCleanupStats()
+	for _, age := range []time.Duration{100 * 24 * time.Hour, time.Hour} {
+		srv.db.RecordVaultReveal(t.Context(), models.VaultReveal{RevealedAt: time.Now().Add(-age), Client: "c",
+			Reason: "r", Tokens: []string{"t"}})
+	}
+
+	srv.runCleanup(t.Context())
+	srv.runCleanup(t.Context())
+
+	rr := httptest.NewRecorder()
+	srv.handleRetentionStats(rr, httptest.NewRequest(http.MethodGet, "/api/admin/retention/stats", nil))
+	var stats []models.TableCleanup
+	json.NewDecoder(rr.Body).Decode(&stats)
+	if len(stats) != 2 || stats[0].Table != "logs" || stats[1].Table != "vault_reveals" {
+		t.Fatalf("expected stats for logs and vault_reveals, got %+v", stats)
+	}
+	reveals := stats[1]
+	if reveals.Retention != "90d" || reveals.Runs != 2 || reveals.TotalDeleted != 1 || reveals.LastDeleted != 0 {
+		t.Errorf("unexpected vault_reveals stats %+v", reveals)
+	}
+}
+
+// TestHandleRetention tests configuring the log retention and changing it at
+// runtime, which the cleanup and query warnings follow.
+func TestHandleRetention(t *testing.T) {
+	for _, bad := range []string{`{"retention": "30m"}`, `{"retention": "soon"}`, `{"cleanup_interval": "1s"}`} {
+		if _, err := loadConfig(writeTestConfig(t, bad)); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+	cfg, err := loadConfig(writeTestConfig(t, `{"retention": "14d"}`))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	period, interval, _ := cfg.retention(defaultRetentionPeriod, 6*time.Hour)
+	if period != 14*24*time.Hour || interval != 6*time.Hour {
+		t.Fatalf("expected 14d every 6h, got %s every %s", period, interval)
+	}
+
+	srv := newTestServer(t)
+	srv.retention = newRetentionSchedule(period, interval)
+	now := time.Now()
+	for _, age := range []time.Duration{10 * 24 * time.Hour, time.Hour} {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: now.Add(-age), Service: "api", Level: "INFO", Message: "m", Host: "h"})
+	}
+
+	put := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		srv.handleRetention(rr, httptest.NewRequest(http.MethodPut, "/api/admin/retention", strings.NewReader(body)))
+		return rr
+	}
+	for _, bad := range []string{`{"retention": "1m"}`, `{"cleanup_interval": "never"}`, `{`} {
+		if rr := put(bad); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", bad, rr.Code)
+		}
+	}
+	rr := put(`{"retention": "7d"}`)
+	var schedule models.RetentionSchedule
+	json.NewDecoder(rr.Body).Decode(&schedule)
+	if rr.Code != http.StatusOK || schedule.Retention != "7d" || schedule.CleanupInterval != "6h0m0s" {
+		t.Fatalf("unexpected schedule %d %+v", rr.Code, schedule)
+	}
+
+	// Queries before the new retention are warned about
+	rr = httptest.NewRecorder()
+	start := now.Add(-10 * 24 * time.Hour).Format(time.RFC3339)
+	srv.handleQueryLogs(rr, httptest.NewRequest(http.MethodGet, "/api/logs?start="+start, nil))
+	if warning := rr.Header().Get("X-Locog-Warning"); !strings.Contains(warning, "7d retention window") {
+		t.Errorf("expected a warning about the 7d retention, got %q", warning)
+	}
+
+	srv.runCleanup(t.Context())
+	logs, _ := srv.db.QueryLogs(t.Context(), models.LogFilter{})
+	if len(logs) != 1 {
+		t.Errorf("expected the cleanup to keep 1 log, got %d", len(logs))
+	}
+}
+
+// TestHandleCleanup tests previewing a cleanup per service and table, then
+// running it on request.
+func TestHandleCleanup(t *testing.T) {
+	srv := newTestServer(t)
+	srv.tableRetention = map[string]time.Duration{"vault_reveals": 90 * 24 * time.Hour}
+	srv.cleanups = newCleanupStats()
+	now := time.Now()
+	for _, l := range []struct {
+		service string
+		age     time.Duration
+	}{{"api", 40}, {"api", 40}, {"web", 40}, {"web", 1}} {
+		srv.db.InsertLog(t.Context(), &models.Log{Timestamp: now.Add(-l.age * 24 * time.Hour), Service: l.service,
+			Level: "INFO", Message: "m", Host: "h"})
+	}
+	srv.db.RecordVaultReveal(t.Context(), models.VaultReveal{RevealedAt: now.Add(-100 * 24 * time.Hour), Client: "c",
+		Reason: "r", Tokens: []string{"t"}})
+	handler, err := srv.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/admin/cleanup/preview", nil))
+	var preview models.CleanupPreview
+	json.NewDecoder(rr.Body).Decode(&preview)
+	want := models.CleanupPreview{Cutoff: preview.Cutoff, Total: 3,
+		Services: []models.CleanupCount{{Name: "api", Count: 2}, {Name: "web", Count: 1}},
+		Tables:   []models.CleanupCount{{Name: "vault_reveals", Count: 1}}}
+	if !reflect.DeepEqual(preview, want) {
+		t.Fatalf("expected %+v, got %+v", want, preview)
+	}
+
+	// A cleanup already running isn't started twice
+	srv.cleanupMu.Lock()
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/admin/cleanup", nil))
+	srv.cleanupMu.Unlock()
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected 409 while a cleanup runs, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/admin/cleanup", nil))
+	var stats []models.TableCleanup
+	json.NewDecoder(rr.Body).Decode(&stats)
+	if rr.Code != http.StatusOK || len(stats) != 2 || stats[0].LastDeleted != 3 || stats[1].LastDeleted != 1 {
+		t.Fatalf("unexpected cleanup %d %+v", rr.Code, stats)
+	}
+	if n, _ := srv.db.CountLogs(t.Context(), models.LogFilter{}); n != 1 {
+		t.Errorf("expected 1 log left, got %d", n)
+	}
+}
@@ -0,0 +1,169 @@
+package logservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"locog/internal/db"
+)
+
+const (
+	// maxStatsBuckets bounds the buckets /api/stats returns.
+	maxStatsBuckets = 10000
+	// defaultStatsRange is the range /api/stats covers without start.
+	defaultStatsRange = 24 * time.Hour
+)
+
+// parseRollupConfig builds the rollup configuration from the -rollup-*
+// flags. buckets is a comma-separated list of sizes, e.g. "1m,5m,1h"; mode is
+// exact or approximate, which counts one in sampleRate logs.
+func parseRollupConfig(buckets, mode string, sampleRate int) (db.RollupConfig, error) {
+	var cfg db.RollupConfig
+	for _, v := range strings.Split(buckets, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		size, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid -rollup-buckets size %q", v)
+		}
+		cfg.Sizes = append(cfg.Sizes, size)
+	}
+	switch mode {
+	case "exact":
+	case "approximate":
+		if sampleRate < 2 {
+			return cfg, fmt.Errorf("-rollup-sample-rate must be at least 2 for approximate rollups, got %d", sampleRate)
+		}
+		cfg.SampleRate = sampleRate
+	default:
+		return cfg, fmt.Errorf("invalid -rollup-mode %q: must be exact or approximate", mode)
+	}
+	return cfg, cfg.Validate()
+}
+
+// rollupRoutine keeps the rollups up to date until ctx is canceled.
+func (s *server) rollupRoutine(ctx context.Context, interval time.Duration) {
+	s.refreshRollups(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.refreshRollups(ctx)
+		}
+	}
+}
+
+func (s *server) refreshRollups(ctx context.Context) {
+	// Catching up on a large database takes a while at first
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	err := s.db.RefreshRollups(ctx, start)
+	duration := time.Since(start)
+	if err != nil {
+		slog.Error("rollup refresh failed", "error", err, "duration_ms", duration.Milliseconds())
+		return
+	}
+	slog.Debug("rollups refreshed", "duration_ms", duration.Milliseconds())
+}
+
+// handleStats returns log counts per time bucket from the rollups, e.g.
+// /api/stats?bucket=5m&group_by=level&service=api&start=...
+// bucket defaults to the smallest rollup size and the range to the last day.
+// Only service, level, host, host_group and stream filters apply.
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sizes := s.db.Rollups().Sizes
+	if len(sizes) == 0 {
+		writeJSONError(w, http.StatusNotFound, "rollups_disabled", "Rollups are disabled",
+			"start the service with -rollup-buckets, e.g. -rollup-buckets 1m,5m,1h")
+		return
+	}
+
+	filter, ok := s.parseLogFilter(w, r)
+	if !ok {
+		return
+	}
+	if !db.RollupFilter(filter) {
+		writeJSONError(w, http.StatusBadRequest, "invalid_filter", "Unsupported filter",
+			"stats only filter by service, level, host, host_group and stream")
+		return
+	}
+
+	q := r.URL.Query()
+	size := sizes[0]
+	if v := q.Get("bucket"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || !slices.Contains(sizes, d) {
+			writeJSONError(w, http.StatusBadRequest, "invalid_bucket", "Invalid bucket value",
+				fmt.Sprintf("'bucket' must be a rollup size (%s), got: %s", strings.Join(sizeNames(sizes), ", "), v))
+			return
+		}
+		size = d
+	}
+	groupBy := q.Get("group_by")
+	switch groupBy {
+	case "", "service", "level", "host", "stream":
+	default:
+		writeJSONError(w, http.StatusBadRequest, "invalid_group_by", "Invalid group_by value",
+			fmt.Sprintf("'group_by' must be service, level, host or stream, got: %s", groupBy))
+		return
+	}
+
+	end := time.Now()
+	if filter.EndTime != nil {
+		end = *filter.EndTime
+	}
+	if filter.StartTime == nil {
+		start := end.Add(-defaultStatsRange)
+		filter.StartTime = &start
+	}
+	filter.EndTime = &end
+	if end.Sub(*filter.StartTime)/size > maxStatsBuckets {
+		writeJSONError(w, http.StatusBadRequest, "invalid_bucket", "Too many buckets",
+			fmt.Sprintf("the range would produce more than %d buckets; use a larger bucket", maxStatsBuckets))
+		return
+	}
+
+	start := time.Now()
+	stats, err := s.db.LogStats(r.Context(), filter, size, groupBy)
+	if err != nil {
+		if clientGone(w, r) {
+			return
+		}
+		slog.Error("stats query failed", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "query_failed",
+			"Stats query failed", "An internal error occurred while reading the rollups")
+		return
+	}
+
+	setQueryHeaders(w, start, len(stats.Buckets))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// sizeNames formats rollup sizes, e.g. ["1m0s", "5m0s"].
+func sizeNames(sizes []time.Duration) []string {
+	names := make([]string, len(sizes))
+	for i, size := range sizes {
+		names[i] = size.String()
+	}
+	return names
+}
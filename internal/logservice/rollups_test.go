@@ -0,0 +1,77 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+func TestHandleStats(t *testing.T) {
+	srv := newTestServer(t)
+	stats := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		srv.handleStats(rr, httptest.NewRequest(http.MethodGet, "/api/stats"+query, nil))
+		return rr
+	}
+	if rr := stats(""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without rollups, got %d", rr.Code)
+	}
+
+	cfg, err := parseRollupConfig("1m, 1h", "exact", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.db.SetRollups(cfg)
+	now := time.Now().UTC()
+	base := now.Truncate(time.Hour).Add(-time.Hour)
+	for i, level := range []string{"ERROR", "INFO", "INFO"} {
+		if err := srv.db.InsertLog(t.Context(), &models.Log{Timestamp: base.Add(time.Duration(i) * time.Minute),
+			Service: "api", Level: level, Message: "m", Host: "h"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := srv.db.RefreshRollups(t.Context(), now); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := stats("?bucket=1h&group_by=level&service=api&start=" + base.Format(time.RFC3339))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var got models.LogStats
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Buckets) != 1 || got.Buckets[0].Count != 3 || got.Buckets[0].Groups["INFO"] != 2 || got.Approximate {
+		t.Errorf("unexpected stats: %+v", got)
+	}
+	if rr.Header().Get(rowsHeader) != "1" {
+		t.Errorf("expected the bucket count in %s, got %q", rowsHeader, rr.Header().Get(rowsHeader))
+	}
+
+	// The default bucket is the smallest size, over the last day
+	rr = stats("")
+	json.NewDecoder(rr.Body).Decode(&got)
+	if rr.Code != http.StatusOK || got.BucketSeconds != 60 || len(got.Buckets) < 60 {
+		t.Errorf("expected minute buckets over the last day, got %d: %+v buckets of %vs", rr.Code, len(got.Buckets), got.BucketSeconds)
+	}
+
+	for _, query := range []string{"?bucket=5m", "?group_by=message", "?search=boom", "?bucket=1m&start=2020-01-01T00:00:00Z"} {
+		if rr := stats(query); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %s, got %d", query, rr.Code)
+		}
+	}
+
+	for _, bad := range [][2]string{{"7m", "exact"}, {"soon", "exact"}, {"1m", "sampled"}} {
+		if _, err := parseRollupConfig(bad[0], bad[1], 100); err == nil {
+			t.Errorf("expected error for -rollup-buckets %s -rollup-mode %s", bad[0], bad[1])
+		}
+	}
+	if _, err := parseRollupConfig("1m", "approximate", 1); err == nil {
+		t.Error("expected error for approximate rollups without sampling")
+	}
+}
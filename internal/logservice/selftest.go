@@ -0,0 +1,237 @@
+package logservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"locog/internal/alerts"
+	"locog/internal/db"
+	"locog/internal/models"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// selfTestService is the service of the logs the self-test ingests
+	selfTestService = "locog-self-test"
+	// selfTestLogs is the number of recent logs ingested
+	selfTestLogs = 20
+	// selfTestTimeout bounds each step that waits on the service
+	selfTestTimeout = 5 * time.Second
+)
+
+// runSelfTest starts the service on a temporary database and a loopback port,
+// then pushes synthetic logs through ingest, query, the WebSocket stream,
+// cleanup and, when cfg defines alert rules, alerting. It returns the first
+// failure. Streams are not applied since sampling would make counts vary.
+func runSelfTest(cfg *fileConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	dir, err := os.MkdirTemp("", "locog-self-test-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	database, err := db.New(filepath.Join(dir, "self-test.db"))
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer database.Close()
+	derived, _ := cfg.derivedFields() // validated by loadConfig
+	database.SetDerivedFields(derived)
+
+	levels, _ := cfg.levelNormalizer()   // validated by loadConfig
+	inference, _ := cfg.levelInference() // validated by loadConfig
+	hostNaming, _ := cfg.hostNaming()    // validated by loadConfig
+	fixedGroups, _ := cfg.hostGroups()   // validated by loadConfig
+	groups, err := newHostGroups(ctx, fixedGroups, database)
+	if err != nil {
+		return err
+	}
+
+	hub := newWSHub()
+	srv := &server{
+		db:         database,
+		limiter:    newIPRateLimiter(rate.Limit(100), 100),
+		hub:        hub,
+		arrival:    newArrivalStats(),
+		labels:     cfg.Labels,
+		hostGroups: groups,
+		levels:     levels,
+
+		levelInference: inference,
+		hostNaming:     hostNaming,
+	}
+
+	rules, err := srv.alertRules(cfg)
+	if err != nil {
+		return err
+	}
+	if len(rules) > 0 {
+		srv.alerts = alerts.NewEngine(database, rules, srv.arrival.lateness)
+	}
+
+	handler, err := srv.routes()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	httpServer := &http.Server{Handler: handler}
+	lc := newLifecycle()
+	srv.start(lc)
+	lc.startHTTP(httpServer, func() error { return httpServer.Serve(listener) })
+	defer func() {
+		stopCtx, stop := context.WithTimeout(context.Background(), selfTestTimeout)
+		defer stop()
+		lc.shutdown(stopCtx)
+	}()
+	base := "http://" + listener.Addr().String()
+
+	step := func(name string, fn func() error) error {
+		start := time.Now()
+		if err := fn(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		slog.Info("self-test step passed", "step", name, "duration_ms", time.Since(start).Milliseconds())
+		return nil
+	}
+
+	var ws *websocket.Conn
+	if err := step("websocket connect", func() error {
+		ws, _, err = websocket.DefaultDialer.DialContext(ctx, "ws"+strings.TrimPrefix(base, "http")+"/api/ws?v=1", nil)
+		return err
+	}); err != nil {
+		return err
+	}
+	defer ws.Close()
+	// Registration is asynchronous; give the hub a moment
+	time.Sleep(100 * time.Millisecond)
+
+	rng := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	logs := make([]models.Log, 0, selfTestLogs+1)
+	for range selfTestLogs {
+		l := syntheticLog(selfTestService, rng)
+		l.Timestamp = time.Now()
+		logs = append(logs, l)
+	}
+	expired := syntheticLog(selfTestService, rng)
+	expired.Timestamp = time.Now().Add(-2 * defaultRetentionPeriod)
+	logs = append(logs, expired)
+
+	if err := step("ingest", func() error {
+		body, err := json.Marshal(logs)
+		if err != nil {
+			return err
+		}
+		resp, err := http.Post(base+"/api/ingest", "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := step("websocket stream", func() error {
+		ws.SetReadDeadline(time.Now().Add(selfTestTimeout))
+		received := 0
+		for received < len(logs) {
+			var envelope struct {
+				Type string       `json:"type"`
+				Data []models.Log `json:"data"`
+			}
+			if err := ws.ReadJSON(&envelope); err != nil {
+				return fmt.Errorf("received %d of %d logs: %w", received, len(logs), err)
+			}
+			if envelope.Type == wsTypeLogs {
+				received += len(envelope.Data)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	countLogs := func() (int, error) {
+		resp, err := http.Get(base + "/api/logs?service=" + selfTestService)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		var found []models.Log
+		if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+			return 0, err
+		}
+		return len(found), nil
+	}
+
+	if err := step("query", func() error {
+		n, err := countLogs()
+		if err == nil && n != len(logs) {
+			err = fmt.Errorf("expected %d logs, got %d", len(logs), n)
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if err := step("cleanup", func() error {
+		deleted, err := database.DeleteOldLogs(ctx, defaultRetentionPeriod)
+		if err != nil {
+			return err
+		}
+		if deleted != 1 {
+			return fmt.Errorf("expected 1 expired log deleted, got %d", deleted)
+		}
+		n, err := countLogs()
+		if err == nil && n != selfTestLogs {
+			err = fmt.Errorf("expected %d logs after cleanup, got %d", selfTestLogs, n)
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if srv.alerts == nil {
+		slog.Info("self-test step skipped", "step", "alerting", "reason", "no alert rules configured")
+		return nil
+	}
+	return step("alerting", func() error {
+		srv.alerts.EvaluateAll(ctx, time.Now())
+		if n := len(srv.alerts.Status()); n != len(rules) {
+			return fmt.Errorf("expected %d rules evaluated, got %d", len(rules), n)
+		}
+		resp, err := http.Get(base + "/api/alerts")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return nil
+	})
+}
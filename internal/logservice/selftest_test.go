@@ -0,0 +1,16 @@
+package logservice
+
+import "testing"
+
+// TestRunSelfTest tests that the self-test passes against this build.
+func TestRunSelfTest(t *testing.T) {
+	cfg, err := loadConfig(writeTestConfig(t, `{"alert_rules": [
+		{"name": "errors", "query": "level=ERROR", "window": "5m", "condition": ">", "threshold": 50}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runSelfTest(cfg); err != nil {
+		t.Fatalf("self-test failed: %v", err)
+	}
+}
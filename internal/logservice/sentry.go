@@ -0,0 +1,367 @@
+package logservice
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+)
+
+// sentryMaxFrames bounds the stack frames kept in metadata, innermost first.
+const sentryMaxFrames = 10
+
+// sentryEvent is the subset of a Sentry error event turned into a log.
+type sentryEvent struct {
+	EventID     string          `json:"event_id"`
+	Timestamp   json.RawMessage `json:"timestamp"` // RFC 3339 string or Unix seconds
+	Level       string          `json:"level"`
+	Platform    string          `json:"platform"`
+	Logger      string          `json:"logger"`
+	ServerName  string          `json:"server_name"`
+	Release     string          `json:"release"`
+	Environment string          `json:"environment"`
+	Transaction string          `json:"transaction"`
+	Message     json.RawMessage `json:"message"` // string or {"formatted": ...}
+	LogEntry    *struct {
+		Formatted string `json:"formatted"`
+		Message   string `json:"message"`
+	} `json:"logentry"`
+	Exception *struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception"`
+	Tags json.RawMessage `json:"tags"` // object or list of [key, value] pairs
+	User *struct {
+		ID string `json:"id"`
+	} `json:"user"`
+	Request *struct {
+		URL    string `json:"url"`
+		Method string `json:"method"`
+	} `json:"request"`
+	SDK *struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"sdk"`
+}
+
+type sentryException struct {
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	Module    string `json:"module"`
+	Mechanism *struct {
+		Type    string `json:"type"`
+		Handled *bool  `json:"handled"`
+	} `json:"mechanism"`
+	Stacktrace *struct {
+		Frames []struct {
+			Function string `json:"function"`
+			Module   string `json:"module"`
+			Filename string `json:"filename"`
+			Lineno   int    `json:"lineno"`
+		} `json:"frames"`
+	} `json:"stacktrace"`
+}
+
+// sentryKey extracts the public key from the X-Sentry-Auth header or the
+// sentry_key query parameter used by browser SDKs.
+func sentryKey(r *http.Request) string {
+	if key := r.URL.Query().Get("sentry_key"); key != "" {
+		return key
+	}
+	auth, ok := strings.CutPrefix(r.Header.Get("X-Sentry-Auth"), "Sentry ")
+	if !ok {
+		return ""
+	}
+	for _, part := range strings.Split(auth, ",") {
+		if key, ok := strings.CutPrefix(strings.TrimSpace(part), "sentry_key="); ok {
+			return key
+		}
+	}
+	return ""
+}
+
+// handleSentry accepts events from Sentry SDKs on the legacy store endpoint
+// (/api/<project>/store/) and the envelope endpoint (/api/<project>/envelope/)
+// so a DSN such as http://<key>@locog:5081/<project> can point at locog. Only
+// error events are kept; sessions, transactions and attachments are ignored.
+func (s *server) handleSentry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ip := getClientIP(r)
+	if limited := s.checkRateLimit(endpointSentry, ip); limited != nil {
+		writeRateLimited(w, limited)
+		return
+	}
+
+	if s.sentryKey != "" && subtle.ConstantTimeCompare([]byte(sentryKey(r)), []byte(s.sentryKey)) != 1 {
+		writeJSONError(w, http.StatusUnauthorized, "invalid_sentry_key",
+			"Invalid Sentry key", "The DSN's public key must match -sentry-key")
+		return
+	}
+
+	body, err := readBody(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var events [][]byte
+	if strings.HasSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/envelope") {
+		events, err = sentryEnvelopeEvents(body)
+	} else {
+		events = [][]byte{body}
+	}
+	if err != nil {
+		slog.Warn("failed to decode Sentry envelope", "sender", ip, "reason", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	project := r.PathValue("project")
+	logs := make([]models.Log, 0, len(events))
+	var lastID string
+	for _, raw := range events {
+		var ev sentryEvent
+		if err := json.Unmarshal(raw, &ev); err != nil {
+			slog.Warn("failed to decode Sentry event", "sender", ip, "reason", err.Error())
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+		logs = append(logs, ev.log(project))
+		lastID = ev.EventID
+	}
+	s.nameHosts(r, logs)
+
+	refund, limited := s.checkQuotas(r, logs)
+	if limited != nil {
+		writeRateLimited(w, limited)
+		return
+	}
+	if err := s.storeLogs(r.Context(), logs, ip); err != nil {
+		refund()
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"id": lastID})
+}
+
+// sentryEnvelopeEvents returns the event payloads of an envelope: a header
+// line followed by items, each an item header line and a payload that is
+// either the given length in bytes or runs to the end of the line.
+func sentryEnvelopeEvents(body []byte) ([][]byte, error) {
+	_, rest, _ := bytes.Cut(body, []byte("\n")) // envelope header
+
+	var events [][]byte
+	for len(rest) > 0 {
+		var line []byte
+		line, rest, _ = bytes.Cut(rest, []byte("\n"))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var item struct {
+			Type   string `json:"type"`
+			Length *int   `json:"length"`
+		}
+		if err := json.Unmarshal(line, &item); err != nil {
+			return nil, fmt.Errorf("invalid envelope item header")
+		}
+
+		var payload []byte
+		if item.Length != nil {
+			if *item.Length < 0 || *item.Length > len(rest) {
+				return nil, fmt.Errorf("envelope item length exceeds body")
+			}
+			payload, rest = rest[:*item.Length], bytes.TrimPrefix(rest[*item.Length:], []byte("\n"))
+		} else {
+			payload, rest, _ = bytes.Cut(rest, []byte("\n"))
+		}
+
+		if item.Type == "event" {
+			events = append(events, payload)
+		}
+	}
+	return events, nil
+}
+
+// log converts an event into a log. The service is the event's "service"
+// tag, falling back to sentry-<project>.
+func (ev *sentryEvent) log(project string) models.Log {
+	tags := ev.tags()
+	metadata := map[string]interface{}{
+		"sentry_project": project,
+		"event_id":       ev.EventID,
+	}
+	for key, value := range map[string]string{
+		"platform":    ev.Platform,
+		"logger":      ev.Logger,
+		"release":     ev.Release,
+		"environment": ev.Environment,
+		"transaction": ev.Transaction,
+	} {
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+	if len(tags) > 0 {
+		metadata["tags"] = tags
+	}
+	if ev.User != nil && ev.User.ID != "" {
+		metadata["user_id"] = ev.User.ID
+	}
+	if ev.Request != nil && ev.Request.URL != "" {
+		metadata["request"] = map[string]interface{}{"url": ev.Request.URL, "method": ev.Request.Method}
+	}
+	if ev.SDK != nil {
+		metadata["sdk"] = ev.SDK.Name + "/" + ev.SDK.Version
+	}
+
+	message := ev.message()
+	if ex := ev.exception(); ex != nil {
+		exception := map[string]interface{}{"type": ex.Type, "value": ex.Value}
+		if ex.Module != "" {
+			exception["module"] = ex.Module
+		}
+		if ex.Mechanism != nil {
+			exception["mechanism"] = ex.Mechanism.Type
+			if ex.Mechanism.Handled != nil {
+				exception["handled"] = *ex.Mechanism.Handled
+			}
+		}
+		if ex.Stacktrace != nil {
+			frames := ex.Stacktrace.Frames
+			var stack []string
+			// Sentry lists frames outermost first
+			for i := len(frames) - 1; i >= 0 && len(stack) < sentryMaxFrames; i-- {
+				f := frames[i]
+				file := f.Filename
+				if file == "" {
+					file = f.Module
+				}
+				stack = append(stack, fmt.Sprintf("%s (%s:%d)", f.Function, file, f.Lineno))
+			}
+			exception["stacktrace"] = stack
+		}
+		metadata["exception"] = exception
+
+		summary := ex.Type
+		if ex.Value != "" {
+			summary += ": " + ex.Value
+		}
+		if message == "" {
+			message = summary
+		}
+	}
+	if message == "" {
+		message = "Sentry event " + ev.EventID
+	}
+
+	service := tags["service"]
+	if service == "" {
+		service = "sentry-" + project
+	}
+	host := ev.ServerName
+	if host == "" {
+		host = "sentry"
+	}
+
+	return models.Log{
+		Timestamp: ev.timestamp(),
+		Service:   service,
+		Level:     sentryLevel(ev.Level),
+		Message:   message,
+		Metadata:  metadata,
+		Host:      host,
+	}
+}
+
+// exception returns the outermost exception, which Sentry lists last.
+func (ev *sentryEvent) exception() *sentryException {
+	if ev.Exception == nil || len(ev.Exception.Values) == 0 {
+		return nil
+	}
+	return &ev.Exception.Values[len(ev.Exception.Values)-1]
+}
+
+func (ev *sentryEvent) message() string {
+	if ev.LogEntry != nil {
+		if ev.LogEntry.Formatted != "" {
+			return ev.LogEntry.Formatted
+		}
+		return ev.LogEntry.Message
+	}
+	var s string
+	if json.Unmarshal(ev.Message, &s) == nil {
+		return s
+	}
+	var m struct {
+		Formatted string `json:"formatted"`
+		Message   string `json:"message"`
+	}
+	if json.Unmarshal(ev.Message, &m) == nil {
+		if m.Formatted != "" {
+			return m.Formatted
+		}
+		return m.Message
+	}
+	return ""
+}
+
+// tags accepts both tag encodings SDKs use.
+func (ev *sentryEvent) tags() map[string]string {
+	tags := make(map[string]string)
+	if json.Unmarshal(ev.Tags, &tags) == nil {
+		return tags
+	}
+	var pairs [][2]string
+	if json.Unmarshal(ev.Tags, &pairs) == nil {
+		for _, p := range pairs {
+			tags[p[0]] = p[1]
+		}
+	}
+	return tags
+}
+
+// timestamp returns the event time, or zero to use the receive time.
+func (ev *sentryEvent) timestamp() time.Time {
+	var s string
+	if json.Unmarshal(ev.Timestamp, &s) == nil {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t
+		}
+		// Python SDKs omit the zone
+		if t, err := time.Parse("2006-01-02T15:04:05.999999", s); err == nil {
+			return t
+		}
+		return time.Time{}
+	}
+	if secs, err := strconv.ParseFloat(string(ev.Timestamp), 64); err == nil && secs > 0 {
+		return time.Unix(0, int64(secs*float64(time.Second))).UTC()
+	}
+	return time.Time{}
+}
+
+// sentryLevel maps Sentry levels to locog's, defaulting to ERROR as Sentry does.
+func sentryLevel(level string) string {
+	switch strings.ToLower(level) {
+	case "fatal":
+		return "FATAL"
+	case "warning":
+		return "WARN"
+	case "info", "log":
+		return "INFO"
+	case "debug":
+		return "DEBUG"
+	}
+	return "ERROR"
+}
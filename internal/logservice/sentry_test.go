@@ -0,0 +1,97 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const sentryErrorEvent = `{"event_id":"fc6d8c0c43fc4630ad850ee518f1b9d0","timestamp":"2025-01-15T12:00:00Z",
+"platform":"python","server_name":"web-1","environment":"prod","tags":{"service":"checkout"},
+"exception":{"values":[{"type":"ValueError","value":"bad amount","mechanism":{"type":"generic","handled":false},
+"stacktrace":{"frames":[
+  {"function":"main","filename":"app.py","lineno":10},
+  {"function":"charge","filename":"billing.py","lineno":42}]}}]}}`
+
+func sentryRequest(path, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.SetPathValue("project", "7")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_key=public123, sentry_client=sentry.python/2.0")
+	return req
+}
+
+// TestHandleSentry_Store tests the legacy store endpoint.
+func TestHandleSentry_Store(t *testing.T) {
+	srv := newTestServer(t)
+	rr := httptest.NewRecorder()
+	srv.handleSentry(rr, sentryRequest("/api/7/store/", sentryErrorEvent))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var resp map[string]string
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp["id"] != "fc6d8c0c43fc4630ad850ee518f1b9d0" {
+		t.Errorf("expected event id in response, got %v", resp)
+	}
+
+	logs := queryAll(t, srv)
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(logs))
+	}
+	l := logs[0]
+	if l.Service != "checkout" || l.Level != "ERROR" || l.Host != "web-1" || l.Message != "ValueError: bad amount" {
+		t.Errorf("unexpected log: %+v", l)
+	}
+	ex, _ := l.Metadata["exception"].(map[string]interface{})
+	stack, _ := ex["stacktrace"].([]interface{})
+	if ex["handled"] != false || len(stack) != 2 || stack[0] != "charge (billing.py:42)" {
+		t.Errorf("unexpected exception metadata: %v", ex)
+	}
+}
+
+// TestHandleSentry_Envelope tests that only event items of an envelope are stored.
+func TestHandleSentry_Envelope(t *testing.T) {
+	srv := newTestServer(t)
+	event := `{"event_id":"abc","level":"warning","message":"disk almost full"}`
+	envelope := `{"event_id":"abc","sent_at":"2025-01-15T12:00:00Z"}` + "\n" +
+		`{"type":"session"}` + "\n" + `{"sid":"1","status":"ok"}` + "\n" +
+		`{"type":"event","length":` + strconv.Itoa(len(event)) + `}` + "\n" + event + "\n"
+
+	rr := httptest.NewRecorder()
+	srv.handleSentry(rr, sentryRequest("/api/7/envelope/", envelope))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	logs := queryAll(t, srv)
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(logs))
+	}
+	if logs[0].Service != "sentry-7" || logs[0].Level != "WARN" || logs[0].Message != "disk almost full" {
+		t.Errorf("unexpected log: %+v", logs[0])
+	}
+}
+
+// TestHandleSentry_Key tests that a configured key is enforced.
+func TestHandleSentry_Key(t *testing.T) {
+	srv := newTestServer(t)
+	srv.sentryKey = "other"
+
+	rr := httptest.NewRecorder()
+	srv.handleSentry(rr, sentryRequest("/api/7/store/", sentryErrorEvent))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	req := sentryRequest("/api/7/store/?sentry_key=other", sentryErrorEvent)
+	req.Header.Del("X-Sentry-Auth")
+	rr = httptest.NewRecorder()
+	srv.handleSentry(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d with key in query, got %d", http.StatusOK, rr.Code)
+	}
+}
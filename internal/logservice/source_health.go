@@ -0,0 +1,216 @@
+package logservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"locog/internal/alerts"
+	"locog/internal/models"
+)
+
+// defaultExpectedSourceWindow is the window an expected source's volume is
+// counted over unless configured.
+const defaultExpectedSourceWindow = time.Hour
+
+// Source health states
+const (
+	sourceOK     = "ok"
+	sourceSilent = "silent"
+	sourceLow    = "low"
+	sourceHigh   = "high"
+)
+
+// expectedSourceConfig registers a service, optionally on one host, with the
+// number of logs it is expected to send per window.
+type expectedSourceConfig struct {
+	Service string `json:"service"`
+	Host    string `json:"host"`   // empty for any host
+	Window  string `json:"window"` // default 1h
+	Min     *int64 `json:"min"`    // default 1, so silence is a deviation
+	Max     int64  `json:"max"`    // 0 for no upper bound
+
+	// Notify names the notifiers told when the volume leaves the range and,
+	// unless NotifyResolved is false, when it returns.
+	Notify         []string `json:"notify"`
+	NotifyResolved *bool    `json:"notify_resolved"`
+}
+
+// expectedSource is a validated expectedSourceConfig.
+type expectedSource struct {
+	service, host  string
+	window         time.Duration
+	min, max       int64
+	notify         []string
+	notifyResolved bool
+}
+
+// expectedSources validates the registered sources.
+func (c *fileConfig) expectedSources() ([]expectedSource, error) {
+	sources := make([]expectedSource, 0, len(c.ExpectedSources))
+	seen := make(map[[2]string]bool)
+	for _, sc := range c.ExpectedSources {
+		name := sourceName(sc.Service, sc.Host)
+		if sc.Service == "" {
+			return nil, fmt.Errorf("expected source: missing service")
+		}
+		if seen[[2]string{sc.Service, sc.Host}] {
+			return nil, fmt.Errorf("expected source %s: registered twice", name)
+		}
+		seen[[2]string{sc.Service, sc.Host}] = true
+
+		src := expectedSource{service: sc.Service, host: sc.Host, window: defaultExpectedSourceWindow, min: 1, max: sc.Max,
+			notify: sc.Notify, notifyResolved: sc.NotifyResolved == nil || *sc.NotifyResolved}
+		if sc.Window != "" {
+			d, err := time.ParseDuration(sc.Window)
+			if err != nil || d < time.Minute {
+				return nil, fmt.Errorf("expected source %s: window must be a duration of at least 1m, got %q", name, sc.Window)
+			}
+			src.window = d
+		}
+		if sc.Min != nil {
+			src.min = *sc.Min
+		}
+		if src.min < 0 || src.max < 0 || (src.max > 0 && src.max < src.min) {
+			return nil, fmt.Errorf("expected source %s: need 0 <= min <= max, got min %d, max %d", name, src.min, src.max)
+		}
+		if src.min == 0 && src.max == 0 {
+			return nil, fmt.Errorf("expected source %s: min 0 without max expects nothing", name)
+		}
+		for _, n := range sc.Notify {
+			if _, ok := c.Notifiers[n]; !ok {
+				return nil, fmt.Errorf("expected source %s: unknown notifier %q", name, n)
+			}
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+// sourceName names a service on a host, e.g. "api@web-1".
+func sourceName(service, host string) string {
+	if host == "" {
+		return service
+	}
+	return service + "@" + host
+}
+
+// sourceRules returns alert rules notifying when registered sources with
+// notifiers leave their expected volume.
+func sourceRules(sources []expectedSource, notifiers map[string]alerts.Notifier, publicURL string) []alerts.Rule {
+	var rules []alerts.Rule
+	for _, src := range sources {
+		if len(src.notify) == 0 {
+			continue
+		}
+		base := alerts.Rule{
+			Filter:         models.LogFilter{Service: src.service, Host: src.host},
+			Window:         src.window,
+			Interval:       time.Minute,
+			AutoDelay:      true,
+			NotifyResolved: src.notifyResolved,
+		}
+		for _, n := range src.notify {
+			base.Notifiers = append(base.Notifiers, notifiers[n])
+		}
+		if publicURL != "" {
+			query := url.Values{"service": {src.service}}
+			if src.host != "" {
+				query.Set("host", src.host)
+			}
+			base.Link = publicURL + "/?" + query.Encode()
+		}
+		name := "source " + sourceName(src.service, src.host)
+		if src.min > 0 {
+			low := base
+			low.Name, low.Condition, low.Threshold = name+" low volume", "<", src.min
+			rules = append(rules, low)
+		}
+		if src.max > 0 {
+			high := base
+			high.Name, high.Condition, high.Threshold = name+" high volume", ">", src.max
+			rules = append(rules, high)
+		}
+	}
+	return rules
+}
+
+// sourceHealth counts the source's logs in the window ending at now and
+// compares them with its range.
+func (s *server) sourceHealth(r *http.Request, src expectedSource, now time.Time) (models.SourceHealth, error) {
+	h := models.SourceHealth{
+		Service:       src.service,
+		Host:          src.host,
+		WindowSeconds: src.window.Seconds(),
+		Min:           src.min,
+		Max:           src.max,
+	}
+	start := now.Add(-src.window)
+	count, err := s.db.CountLogs(r.Context(), models.LogFilter{Service: src.service, Host: src.host, StartTime: &start, EndTime: &now})
+	if err != nil {
+		return h, err
+	}
+	h.Count = count
+	last, ok, err := s.db.LastLogAt(r.Context(), src.service, src.host)
+	if err != nil {
+		return h, err
+	}
+	if ok {
+		h.LastLogAt = &last
+	}
+
+	switch {
+	case count == 0 && src.min > 0:
+		h.Status = sourceSilent
+	case count < src.min:
+		h.Status = sourceLow
+	case src.max > 0 && count > src.max:
+		h.Status = sourceHigh
+	default:
+		h.Status = sourceOK
+	}
+	return h, nil
+}
+
+// handleSourceHealth reports how the registered sources' log volume compares
+// with what they are expected to send, deviations first: silent sources,
+// then those sending too few or too many logs.
+func (s *server) handleSourceHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	report := struct {
+		CheckedAt time.Time             `json:"checked_at"`
+		Healthy   bool                  `json:"healthy"`
+		Sources   []models.SourceHealth `json:"sources"`
+	}{CheckedAt: now.UTC(), Healthy: true, Sources: []models.SourceHealth{}}
+
+	var ok []models.SourceHealth
+	for _, src := range s.expectedSources {
+		h, err := s.sourceHealth(r, src, now)
+		if err != nil {
+			slog.Error("failed to check source health", "error", err, "service", src.service, "host", src.host)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		if h.Status == sourceOK {
+			ok = append(ok, h)
+			continue
+		}
+		report.Healthy = false
+		report.Sources = append(report.Sources, h)
+	}
+	order := map[string]int{sourceSilent: 0, sourceLow: 1, sourceHigh: 2}
+	slices.SortStableFunc(report.Sources, func(a, b models.SourceHealth) int { return order[a.Status] - order[b.Status] })
+	report.Sources = append(report.Sources, ok...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,101 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestLoadConfig_ExpectedSources tests registered source validation.
+func TestLoadConfig_ExpectedSources(t *testing.T) {
+	for _, bad := range []string{
+		`{"expected_sources": [{"host": "h"}]}`,
+		`{"expected_sources": [{"service": "api"}, {"service": "api"}]}`,
+		`{"expected_sources": [{"service": "api", "window": "10s"}]}`,
+		`{"expected_sources": [{"service": "api", "min": 10, "max": 5}]}`,
+		`{"expected_sources": [{"service": "api", "min": 0}]}`,
+		`{"expected_sources": [{"service": "api", "notify": ["ops"]}]}`,
+	} {
+		if _, err := loadConfig(writeTestConfig(t, bad)); err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+}
+
+// TestHandleSourceHealth tests the report of registered sources' volume and
+// the alert rules notifying about deviations.
+func TestHandleSourceHealth(t *testing.T) {
+	cfg, err := loadConfig(writeTestConfig(t, `{
+		"notifiers": {"ops": {"type": "slack", "webhook_url": "https://hooks.slack.com/services/T/B/x"}},
+		"expected_sources": [
+			{"service": "api", "host": "web-1", "min": 1, "max": 2, "notify": ["ops"]},
+			{"service": "api", "host": "web-2"},
+			{"service": "worker", "window": "10m", "min": 3},
+			{"service": "cron", "min": 0, "max": 10}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	srv := newTestServer(t)
+	srv.expectedSources, _ = cfg.expectedSources()
+
+	now := time.Now()
+	for _, l := range []models.Log{
+		{Service: "api", Host: "web-1"}, {Service: "api", Host: "web-1"}, {Service: "api", Host: "web-1"},
+		{Service: "worker", Host: "w-1"}, {Service: "worker", Host: "w-2"},
+		// Outside the worker's 10m window
+		{Service: "worker", Host: "w-1", Timestamp: now.Add(-time.Hour)},
+	} {
+		if l.Timestamp.IsZero() {
+			l.Timestamp = now.Add(-time.Minute)
+		}
+		l.Level, l.Message = "info", "m"
+		srv.db.InsertLog(t.Context(), &l)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.handleSourceHealth(rr, httptest.NewRequest(http.MethodGet, "/api/admin/source-health", nil))
+	var report struct {
+		Healthy bool                  `json:"healthy"`
+		Sources []models.SourceHealth `json:"sources"`
+	}
+	json.NewDecoder(rr.Body).Decode(&report)
+	want := []struct {
+		name   string
+		status string
+		count  int64
+	}{
+		{"api@web-2", sourceSilent, 0},
+		{"worker", sourceLow, 2},
+		{"api@web-1", sourceHigh, 3},
+		{"cron", sourceOK, 0},
+	}
+	if report.Healthy || len(report.Sources) != len(want) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	for i, w := range want {
+		got := report.Sources[i]
+		if sourceName(got.Service, got.Host) != w.name || got.Status != w.status || got.Count != w.count {
+			t.Errorf("source %d: expected %s %s with %d logs, got %+v", i, w.name, w.status, w.count, got)
+		}
+	}
+	if report.Sources[2].LastLogAt == nil {
+		t.Errorf("expected the last log time of api@web-1")
+	}
+
+	// Only sources with notifiers get alert rules, one per bound
+	rules, err := srv.alertRules(cfg)
+	if err != nil {
+		t.Fatalf("alertRules failed: %v", err)
+	}
+	if len(rules) != 2 || rules[0].Name != "source api@web-1 low volume" || rules[0].Condition != "<" || rules[0].Threshold != 1 ||
+		rules[1].Name != "source api@web-1 high volume" || rules[1].Condition != ">" || rules[1].Threshold != 2 ||
+		rules[1].Filter.Host != "web-1" || len(rules[1].Notifiers) != 1 {
+		t.Errorf("unexpected rules %+v", rules)
+	}
+}
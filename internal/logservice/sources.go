@@ -0,0 +1,88 @@
+package logservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// maxSourceNameLength bounds heartbeat source and service names, as the
+// columns they are stored in.
+const maxSourceNameLength = 255
+
+// handleHeartbeat records that a shipper is alive even when it has nothing
+// to send, e.g. POST /api/heartbeat?source=hostA&service=vector. The source
+// is the host its logs carry.
+func (s *server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ip := getClientIP(r)
+	if limited := s.checkRateLimit(endpointHeartbeat, ip); limited != nil {
+		writeRateLimited(w, limited)
+		return
+	}
+
+	q := r.URL.Query()
+	source, service := q.Get("source"), q.Get("service")
+	if source == "" || len(source) > maxSourceNameLength || len(service) > maxSourceNameLength {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid source",
+			fmt.Sprintf("'source' is required; 'source' and 'service' are at most %d characters", maxSourceNameLength))
+		return
+	}
+
+	if err := s.db.RecordHeartbeat(r.Context(), source, service, time.Now()); err != nil {
+		slog.Error("failed to record heartbeat", "error", err, "source", source)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSources lists the hosts and services that have shipped logs or
+// heartbeats, quietest first. quiet=<duration> keeps only those silent for
+// at least that long.
+func (s *server) handleSources(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var quiet time.Duration
+	if v := r.URL.Query().Get("quiet"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid quiet value",
+				fmt.Sprintf("'quiet' must be a non-negative duration like 15m, got: %s", v))
+			return
+		}
+		quiet = d
+	}
+
+	sources, err := s.db.Sources(r.Context())
+	if err != nil {
+		slog.Error("failed to list sources", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	kept := sources[:0]
+	for _, src := range sources {
+		silent := now.Sub(src.LastSeen)
+		if silent < quiet {
+			continue
+		}
+		src.QuietSeconds = max(silent, 0).Seconds()
+		kept = append(kept, src)
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].LastSeen.Before(kept[j].LastSeen) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(kept)
+}
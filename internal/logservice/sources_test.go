@@ -0,0 +1,72 @@
+package logservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+)
+
+// TestHandleHeartbeat tests recording heartbeats and listing sources,
+// quietest first.
+func TestHandleHeartbeat(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, target := range []string{"/api/heartbeat", "/api/heartbeat?source=" + string(bytes.Repeat([]byte("h"), 256))} {
+		rr := httptest.NewRecorder()
+		srv.handleHeartbeat(rr, httptest.NewRequest(http.MethodPost, target, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", target[:20], http.StatusBadRequest, rr.Code)
+		}
+	}
+
+	if err := srv.db.RecordHeartbeat(t.Context(), "gone", "", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	srv.handleHeartbeat(rr, httptest.NewRequest(http.MethodPost, "/api/heartbeat?source=web-1&service=vector", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	srv.handleIngest(rr, httptest.NewRequest(http.MethodPost, "/api/ingest", bytes.NewReader(sampleLogJSON())))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("ingest failed: %d", rr.Code)
+	}
+
+	list := func(query string) []models.Source {
+		rr := httptest.NewRecorder()
+		srv.handleSources(rr, httptest.NewRequest(http.MethodGet, "/api/admin/sources"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", query, http.StatusOK, rr.Code)
+		}
+		var sources []models.Source
+		json.NewDecoder(rr.Body).Decode(&sources)
+		return sources
+	}
+
+	sources := list("")
+	if len(sources) != 3 || sources[0].Host != "gone" || sources[0].QuietSeconds < 3599 {
+		t.Fatalf("expected the quietest source first, got %+v", sources)
+	}
+	for _, src := range sources[1:] {
+		if src.Host == "test-host" && (src.Service != "test-service" || src.Logs != 1) ||
+			src.Host == "web-1" && (src.Service != "vector" || src.LastHeartbeatAt == nil) {
+			t.Errorf("unexpected source: %+v", src)
+		}
+	}
+
+	if sources := list("?quiet=30m"); len(sources) != 1 || sources[0].Host != "gone" {
+		t.Errorf("expected only the silent source, got %+v", sources)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.handleSources(rr, httptest.NewRequest(http.MethodGet, "/api/admin/sources?quiet=soon", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid quiet, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
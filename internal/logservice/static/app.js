@@ -3,14 +3,51 @@ let wsReconnectTimeout = null;
 let currentLogs = [];
 
 // Theme management
-function initTheme() {
-    const savedTheme = localStorage.getItem('theme') || 'auto';
-    applyTheme(savedTheme);
-    updateThemeMenuSelection(savedTheme);
+// Preferences are stored server-side (/api/prefs) so they follow the user
+// across browsers; localStorage is kept as a cache to avoid a flash on load.
+async function loadPref(key) {
+    try {
+        const response = await fetch(`/api/prefs/${encodeURIComponent(key)}`);
+        if (!response.ok) return null;
+        return await response.json();
+    } catch (e) {
+        return null;
+    }
+}
+
+function savePref(key, value) {
+    localStorage.setItem(key, JSON.stringify(value));
+    fetch(`/api/prefs/${encodeURIComponent(key)}`, {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(value)
+    }).catch(error => console.error(`Failed to save preference ${key}:`, error));
+}
+
+function cachedPref(key) {
+    try {
+        return JSON.parse(localStorage.getItem(key));
+    } catch (e) {
+        // values written before preferences moved server-side are plain strings
+        return localStorage.getItem(key);
+    }
+}
+
+async function initTheme() {
+    const cachedTheme = cachedPref('theme') || 'auto';
+    applyTheme(cachedTheme);
+    updateThemeMenuSelection(cachedTheme);
+
+    const savedTheme = await loadPref('theme');
+    if (savedTheme && savedTheme !== cachedTheme) {
+        localStorage.setItem('theme', JSON.stringify(savedTheme));
+        applyTheme(savedTheme);
+        updateThemeMenuSelection(savedTheme);
+    }
 }
 
 function setTheme(theme) {
-    localStorage.setItem('theme', theme);
+    savePref('theme', theme);
     applyTheme(theme);
     updateThemeMenuSelection(theme);
     toggleThemeMenu();
@@ -150,6 +187,7 @@ function populateSelect(id, values) {
 }
 
 async function loadLogs() {
+    subscribeWebSocket();
     const params = new URLSearchParams();
 
     const service = document.getElementById('service').value;
@@ -200,6 +238,7 @@ async function loadLogs() {
         }
 
         const logs = await response.json();
+        showQueryStats(response);
 
         currentLogs = logs || [];
         displayLogs(currentLogs);
@@ -210,6 +249,15 @@ async function loadLogs() {
     }
 }
 
+// showQueryStats shows the row count and duration the server reports for a
+// query.
+function showQueryStats(response) {
+    const rows = response.headers.get('X-Locog-Rows');
+    const ms = response.headers.get('X-Locog-Query-Ms');
+    const el = document.getElementById('queryStats');
+    el.textContent = rows !== null && ms !== null ? `${rows} logs in ${ms} ms` : '';
+}
+
 function getLogLevelIcon(level) {
     const iconMap = {
         'ERROR': 'x-octagon',
@@ -360,18 +408,26 @@ function connectWebSocket() {
     }
 
     const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-    const wsUrl = protocol + '//' + window.location.host + '/api/ws';
+    const wsUrl = protocol + '//' + window.location.host + '/api/ws?v=1';
 
     ws = new WebSocket(wsUrl);
 
     ws.onopen = function() {
         console.log('WebSocket connected');
         updateWsStatus(true);
+        subscribeWebSocket();
     };
 
     ws.onmessage = function(event) {
         try {
-            const newLogs = JSON.parse(event.data);
+            // Framed messages (v=1); other message types are ignored
+            const message = JSON.parse(event.data);
+            if (message.type === 'error') {
+                console.error('WebSocket subscription rejected:', message.data);
+                return;
+            }
+            if (message.type !== 'logs') return;
+            const newLogs = message.data;
             if (!Array.isArray(newLogs) || newLogs.length === 0) return;
 
             // Check if any new logs match current filters
@@ -401,6 +457,18 @@ function connectWebSocket() {
     };
 }
 
+// Ask the server to stream only logs matching the current filters; the date
+// range is still checked by matchesCurrentFilters.
+function subscribeWebSocket() {
+    if (!ws || ws.readyState !== WebSocket.OPEN) return;
+    const filters = {};
+    for (const name of ['service', 'level', 'host', 'search']) {
+        const value = document.getElementById(name).value;
+        if (value) filters[name] = value;
+    }
+    ws.send(JSON.stringify({ type: 'subscribe', filters: filters }));
+}
+
 function matchesCurrentFilters(log) {
     const service = document.getElementById('service').value;
     const level = document.getElementById('level').value;
@@ -433,8 +501,87 @@ function updateWsStatus(connected) {
     indicator.title = connected ? 'WebSocket connected - receiving real-time updates' : 'WebSocket disconnected - reconnecting...';
 }
 
+// Apply filters from the page URL, e.g. an alert notification's link:
+// ?service=api&level=ERROR&start=2025-01-01T11:55:00Z&end=...
+function applyUrlFilters() {
+    const params = new URLSearchParams(window.location.search);
+    ['service', 'level', 'host'].forEach(id => {
+        const value = params.get(id);
+        if (!value) return;
+        const select = document.getElementById(id);
+        if (![...select.options].some(option => option.value === value)) {
+            const option = document.createElement('option');
+            option.value = value;
+            option.textContent = value;
+            select.appendChild(option);
+        }
+        select.value = value;
+    });
+    if (params.get('search')) {
+        document.getElementById('search').value = params.get('search');
+    }
+    // The date inputs hold whole days
+    if (params.get('start')) {
+        document.getElementById('startTime').value = params.get('start').slice(0, 10);
+    }
+    if (params.get('end')) {
+        document.getElementById('endTime').value = params.get('end').slice(0, 10);
+    }
+    updateMobileFilterSummary();
+}
+
+// Import a log file dropped onto the page through /api/uploads, then show
+// its logs. Plain text lines take the service asked for here.
+document.addEventListener('dragover', e => {
+    if (!e.dataTransfer.types.includes('Files')) return;
+    e.preventDefault();
+    document.body.classList.add('drop-target');
+});
+document.addEventListener('dragleave', e => {
+    if (e.relatedTarget === null) document.body.classList.remove('drop-target');
+});
+document.addEventListener('drop', e => {
+    if (!e.dataTransfer.files.length) return;
+    e.preventDefault();
+    document.body.classList.remove('drop-target');
+    uploadFile(e.dataTransfer.files[0]);
+});
+
+async function uploadFile(file) {
+    const service = prompt(`Service for the logs in ${file.name} without one`, file.name.replace(/\.[^.]*$/, ''));
+    if (service === null) return;
+    const form = new FormData();
+    form.append('service', service);
+    form.append('file', file);
+    showWarningBanner(`Uploading ${file.name}...`);
+    try {
+        const response = await fetch('/api/uploads', { method: 'POST', body: form });
+        let job = await response.json();
+        if (!response.ok) {
+            showWarningBanner(`Upload failed: ${job.error}${job.details ? ' (' + job.details + ')' : ''}`);
+            return;
+        }
+        while (job.status === 'queued' || job.status === 'running') {
+            showWarningBanner(`Importing ${file.name}: ${job.imported} logs so far...`);
+            await new Promise(resolve => setTimeout(resolve, 1000));
+            job = await (await fetch('/api/uploads/' + job.id)).json();
+        }
+        if (job.status === 'failed') {
+            showWarningBanner(`Import of ${file.name} failed after ${job.imported} logs: ${job.error}`);
+        } else {
+            const skipped = job.skipped ? `, skipped ${job.skipped} lines (${job.errors[0]})` : '';
+            showWarningBanner(`Imported ${job.imported} logs from ${file.name}${skipped}`);
+        }
+        loadFilterOptions();
+        loadLogs();
+    } catch (error) {
+        showWarningBanner(`Upload failed: ${error.message}`);
+    }
+}
+
 // Initial load
 initTheme();
+applyUrlFilters();
 loadFilterOptions();
 loadLogs();
 connectWebSocket();
@@ -0,0 +1,180 @@
+package logservice
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"locog/internal/db"
+	"locog/internal/models"
+)
+
+var streamNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// streamConfig routes matching logs to a logical stream with its own
+// retention, sampling and read access. Logs matching no stream go to the
+// "default" stream.
+type streamConfig struct {
+	Name string `json:"name"`
+
+	// Match conditions; all that are set must hold.
+	Service string   `json:"service"` // glob, e.g. "audit-*"
+	Levels  []string `json:"levels"`  // e.g. ["DEBUG", "TRACE"]
+	Match   string   `json:"match"`   // case-insensitive substring of the message
+
+	Retention  string   `json:"retention"`   // e.g. "365d"; empty keeps the default
+	SampleRate *float64 `json:"sample_rate"` // fraction of matching logs stored; default 1
+	ReadToken  string   `json:"read_token"`  // when set, reading the stream requires it or the admin token
+}
+
+// logStream is a validated stream routing rule.
+type logStream struct {
+	name       string
+	service    string
+	levels     []string // upper case
+	match      string   // lower case
+	retention  time.Duration
+	sampleRate float64
+	readToken  string
+}
+
+// stream validates the configured stream.
+func (sc streamConfig) stream() (logStream, error) {
+	st := logStream{
+		name:       sc.Name,
+		service:    sc.Service,
+		match:      strings.ToLower(sc.Match),
+		sampleRate: 1,
+		readToken:  sc.ReadToken,
+	}
+	if !streamNamePattern.MatchString(sc.Name) || sc.Name == db.DefaultStream {
+		return st, fmt.Errorf("stream %q: name must be 1-64 lower case letters, digits, '_' or '-' and not %q",
+			sc.Name, db.DefaultStream)
+	}
+	if _, err := path.Match(sc.Service, ""); err != nil {
+		return st, fmt.Errorf("stream %s: invalid service pattern %q", sc.Name, sc.Service)
+	}
+	for _, level := range sc.Levels {
+		st.levels = append(st.levels, strings.ToUpper(level))
+	}
+	if sc.Retention != "" {
+		keep, err := parseRetention(sc.Retention)
+		if err != nil || keep <= 0 {
+			return st, fmt.Errorf("stream %s: invalid retention %q", sc.Name, sc.Retention)
+		}
+		st.retention = keep
+	}
+	if sc.SampleRate != nil {
+		if *sc.SampleRate <= 0 || *sc.SampleRate > 1 {
+			return st, fmt.Errorf("stream %s: sample_rate must be in (0, 1]", sc.Name)
+		}
+		st.sampleRate = *sc.SampleRate
+	}
+	return st, nil
+}
+
+// matches reports whether a log is routed to the stream.
+func (st *logStream) matches(l *models.Log) bool {
+	if st.service != "" {
+		if ok, _ := path.Match(st.service, l.Service); !ok {
+			return false
+		}
+	}
+	if len(st.levels) > 0 && !slices.Contains(st.levels, strings.ToUpper(l.Level)) {
+		return false
+	}
+	return st.match == "" || strings.Contains(strings.ToLower(l.Message), st.match)
+}
+
+// streams validates the configured streams in order.
+func (c *fileConfig) streams() ([]logStream, error) {
+	streams := make([]logStream, 0, len(c.Streams))
+	names := make(map[string]bool)
+	for _, sc := range c.Streams {
+		st, err := sc.stream()
+		if err != nil {
+			return nil, err
+		}
+		if names[st.name] {
+			return nil, fmt.Errorf("stream %s: duplicate name", st.name)
+		}
+		names[st.name] = true
+		streams = append(streams, st)
+	}
+	return streams, nil
+}
+
+// streamRetention returns the retention of streams that override the default.
+func streamRetention(streams []logStream) map[string]time.Duration {
+	retention := make(map[string]time.Duration)
+	for _, st := range streams {
+		if st.retention > 0 {
+			retention[st.name] = st.retention
+		}
+	}
+	return retention
+}
+
+// streamFor returns the first stream a log matches, or nil for the default
+// stream.
+func (s *server) streamFor(l *models.Log) *logStream {
+	for i := range s.streams {
+		if s.streams[i].matches(l) {
+			return &s.streams[i]
+		}
+	}
+	return nil
+}
+
+// routeLogs assigns each log to its stream and drops the logs a stream
+// samples out, unless an incident covers their service.
+func (s *server) routeLogs(logs []models.Log) []models.Log {
+	if len(s.streams) == 0 {
+		return logs
+	}
+	now := time.Now()
+	kept := logs[:0]
+	for _, l := range logs {
+		st := s.streamFor(&l)
+		if st == nil {
+			l.Stream = db.DefaultStream
+		} else if st.sampleRate < 1 && rand.Float64() >= st.sampleRate && !s.incidents.covers(l.Service, now) {
+			continue
+		} else {
+			l.Stream = st.name
+		}
+		kept = append(kept, l)
+	}
+	return kept
+}
+
+// requestToken returns the bearer token, or the 'token' query parameter that
+// browsers use where they can't set headers.
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// hiddenStreams returns the streams the request may not read: those with a
+// read token it doesn't present, unless it presents the admin token.
+func (s *server) hiddenStreams(r *http.Request) []string {
+	token := requestToken(r)
+	if s.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1 {
+		return nil
+	}
+	var hidden []string
+	for _, st := range s.streams {
+		if st.readToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(st.readToken)) != 1 {
+			hidden = append(hidden, st.name)
+		}
+	}
+	return hidden
+}
@@ -0,0 +1,135 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"locog/internal/models"
+)
+
+const streamsConfig = `{"streams": [
+	{"name": "audit", "service": "audit*", "retention": "365d", "read_token": "auditor"},
+	{"name": "debug", "levels": ["debug", "trace"], "retention": "3d", "sample_rate": 0.5},
+	{"name": "payments", "match": "PAYMENT"}
+]}`
+
+// TestLoadConfig_Streams tests stream validation.
+func TestLoadConfig_Streams(t *testing.T) {
+	cfg, err := loadConfig(writeTestConfig(t, streamsConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	streams, _ := cfg.streams()
+	if len(streams) != 3 || streams[1].levels[0] != "DEBUG" || streams[1].sampleRate != 0.5 {
+		t.Errorf("unexpected streams: %+v", streams)
+	}
+	retention := streamRetention(streams)
+	if len(retention) != 2 || retention["audit"].Hours() != 365*24 {
+		t.Errorf("unexpected retention: %v", retention)
+	}
+
+	for _, bad := range []string{
+		`{"streams": [{"name": "default"}]}`,
+		`{"streams": [{"name": "Audit"}]}`,
+		`{"streams": [{"name": "a"}, {"name": "a"}]}`,
+		`{"streams": [{"name": "a", "service": "[x"}]}`,
+		`{"streams": [{"name": "a", "retention": "forever"}]}`,
+		`{"streams": [{"name": "a", "sample_rate": 0}]}`,
+		`{"streams": [{"name": "a", "sample_rate": 1.5}]}`,
+	} {
+		if _, err := loadConfig(writeTestConfig(t, bad)); err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+}
+
+func newTestServerWithStreams(t *testing.T) *server {
+	t.Helper()
+	srv := newTestServer(t)
+	cfg, err := loadConfig(writeTestConfig(t, streamsConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.streams, _ = cfg.streams()
+	srv.adminToken = "admin"
+	return srv
+}
+
+// TestRouteLogs tests that the first matching stream wins and sampling drops
+// a share of matching logs.
+func TestRouteLogs(t *testing.T) {
+	srv := newTestServerWithStreams(t)
+
+	logs := srv.routeLogs([]models.Log{
+		{Service: "audit-log", Level: "DEBUG", Message: "user deleted"},
+		{Service: "api", Level: "INFO", Message: "Payment accepted"},
+		{Service: "api", Level: "INFO", Message: "request done"},
+	})
+	want := []string{"audit", "payments", "default"}
+	if len(logs) != len(want) {
+		t.Fatalf("expected %d logs, got %+v", len(want), logs)
+	}
+	for i, l := range logs {
+		if l.Stream != want[i] {
+			t.Errorf("log %d: expected stream %s, got %s", i, want[i], l.Stream)
+		}
+	}
+
+	debug := make([]models.Log, 1000)
+	for i := range debug {
+		debug[i] = models.Log{Service: "api", Level: "debug", Message: "cache miss"}
+	}
+	if n := len(srv.routeLogs(debug)); n < 400 || n > 600 {
+		t.Errorf("expected about half of debug logs to be kept, got %d", n)
+	}
+}
+
+// TestHandleQueryLogs_StreamAccess tests that streams with a read token are
+// hidden from requests without it.
+func TestHandleQueryLogs_StreamAccess(t *testing.T) {
+	srv := newTestServerWithStreams(t)
+	srv.storeLogs(t.Context(), []models.Log{
+		{Service: "audit", Level: "INFO", Message: "user deleted", Host: "h"},
+		{Service: "api", Level: "INFO", Message: "request done", Host: "h"},
+	}, "test")
+
+	query := func(url, token string) []models.Log {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		srv.handleQueryLogs(rr, req)
+		var logs []models.Log
+		json.NewDecoder(rr.Body).Decode(&logs)
+		return logs
+	}
+
+	if logs := query("/api/logs", ""); len(logs) != 1 || logs[0].Stream != "default" {
+		t.Errorf("expected only the default stream anonymously, got %+v", logs)
+	}
+	if logs := query("/api/logs", "auditor"); len(logs) != 2 {
+		t.Errorf("expected both logs with the stream token, got %d", len(logs))
+	}
+	if logs := query("/api/logs?stream=audit", "admin"); len(logs) != 1 || logs[0].Message != "user deleted" {
+		t.Errorf("expected the audit log with the admin token, got %+v", logs)
+	}
+	if logs := query("/api/logs?stream=audit&token=wrong", ""); len(logs) != 0 {
+		t.Errorf("expected no audit logs with a wrong token, got %+v", logs)
+	}
+}
+
+// TestEncodeStreamLogs_HiddenStreams tests live stream filtering by stream.
+func TestEncodeStreamLogs_HiddenStreams(t *testing.T) {
+	logs := []models.Log{{Message: "a", Stream: "audit"}, {Message: "b", Stream: "default"}}
+	var got []models.Log
+	json.Unmarshal(encodeStreamLogs(logs, "", []string{"audit"}, nil, nil), &got)
+	if len(got) != 1 || got[0].Message != "b" {
+		t.Errorf("expected only the default stream log, got %+v", got)
+	}
+	if encodeStreamLogs(logs[:1], "", []string{"audit"}, nil, nil) != nil {
+		t.Error("expected nothing to send when every log is hidden")
+	}
+}
@@ -0,0 +1,85 @@
+package logservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"locog/internal/models"
+)
+
+const (
+	// suggestDefaultLimit is the number of suggestions returned by default
+	suggestDefaultLimit = 10
+	// suggestMaxLimit bounds the suggestions returned
+	suggestMaxLimit = 100
+	// suggestMaxPrefix bounds the prefix length, as filter values are at
+	// most 255 characters
+	suggestMaxPrefix = 255
+)
+
+// handleSuggest completes filter values for type-ahead, e.g.
+// /api/suggest?field=service&prefix=pay. field is service, level, host or
+// host_group; values starting with prefix (any case) come most frequent
+// first, up to limit. Services the request may not read are left out.
+func (s *server) handleSuggest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var access models.LogFilter
+	if !s.restrictFilter(w, r, &access) {
+		return
+	}
+
+	q := r.URL.Query()
+	field, prefix := q.Get("field"), q.Get("prefix")
+	if field != "service" && field != "level" && field != "host" && field != "host_group" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid field",
+			fmt.Sprintf("'field' must be service, level, host or host_group, got: %q", field))
+		return
+	}
+	if len(prefix) > suggestMaxPrefix {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid prefix",
+			fmt.Sprintf("'prefix' must be at most %d characters", suggestMaxPrefix))
+		return
+	}
+	limit := suggestDefaultLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > suggestMaxLimit {
+			writeJSONError(w, http.StatusBadRequest, "invalid_limit", "Invalid limit value",
+				fmt.Sprintf("'limit' must be between 1 and %d, got: %s", suggestMaxLimit, v))
+			return
+		}
+		limit = n
+	}
+
+	var values []string
+	if field == "host_group" {
+		values = []string{}
+		for _, name := range s.hostGroups.names() {
+			if len(values) < limit && strings.HasPrefix(name, strings.ToLower(prefix)) {
+				values = append(values, name)
+			}
+		}
+	} else {
+		var err error
+		values, err = s.db.SuggestFilterValues(r.Context(), field, prefix, limit)
+		if err != nil {
+			slog.Error("failed to suggest filter values", "error", err, "field", field)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		if field == "service" {
+			values = slices.DeleteFunc(values, func(v string) bool { return !serviceMatches(access.ServicePatterns, v) })
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(values)
+}
@@ -0,0 +1,52 @@
+package logservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"locog/internal/models"
+)
+
+// TestHandleSuggest tests prefix completion of filter values and host groups.
+func TestHandleSuggest(t *testing.T) {
+	srv := newTestServer(t)
+	srv.hostGroups = &hostGroups{fixed: map[string][]string{"web": {"web-*"}, "db": {"db-01"}}}
+	srv.db.InsertBatch(t.Context(), []models.Log{
+		{Service: "payments", Level: "info", Message: "m", Host: "h"},
+		{Service: "api", Level: "info", Message: "m", Host: "h"},
+	})
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"field=service&prefix=PAY", []string{"payments"}},
+		{"field=service&prefix=x", []string{}},
+		{"field=host_group&prefix=w", []string{"web"}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/suggest?"+tt.query, nil)
+		rr := httptest.NewRecorder()
+		srv.handleSuggest(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d: %s", tt.query, http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var got []string
+		json.NewDecoder(rr.Body).Decode(&got)
+		if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+			t.Errorf("%s: expected %v, got %v", tt.query, tt.want, got)
+		}
+	}
+
+	for _, query := range []string{"field=message&prefix=a", "field=service&limit=0", "field=service&limit=101"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/suggest?"+query, nil)
+		rr := httptest.NewRecorder()
+		srv.handleSuggest(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package logservice
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certCheckInterval is how often the certificate files are checked for a
+// renewal.
+const certCheckInterval = time.Minute
+
+// certReloader serves the certificate in certFile and keyFile, loading it
+// again once the files change, so a certificate renewed by certbot or
+// another ACME client is picked up without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.load(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// load reads the key pair and records when the newer of its files changed.
+func (cr *certReloader) load() error {
+	modTime, err := cr.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	cr.cert, cr.modTime = &cert, modTime
+	return nil
+}
+
+func (cr *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// getCertificate is tls.Config.GetCertificate. A renewal that fails to load,
+// e.g. as the files are half written, keeps the current certificate until
+// the next check.
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if now := time.Now(); now.Sub(cr.checked) >= certCheckInterval {
+		cr.checked = now
+		if modTime, err := cr.filesModTime(); err == nil && !modTime.Equal(cr.modTime) {
+			if err := cr.load(); err != nil {
+				slog.Warn("failed to reload TLS certificate; keeping the current one", "error", err)
+			} else {
+				slog.Info("TLS certificate reloaded", "cert", cr.certFile)
+			}
+		}
+	}
+	return cr.cert, nil
+}
+
+// tlsConfig returns the server's TLS configuration for certFile and keyFile.
+// With clientCAFile, clients may present a certificate signed by one of its
+// CAs, which requireClientCert then demands on the shipper endpoints; other
+// endpoints, such as the web UI, don't ask browsers for one.
+func tlsConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.getCertificate,
+	}
+	if err := verifyClientCerts(cfg, clientCAFile); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// verifyClientCerts lets clients present a certificate signed by one of the
+// CAs in clientCAFile, if set.
+func verifyClientCerts(cfg *tls.Config, clientCAFile string) error {
+	if clientCAFile == "" {
+		return nil
+	}
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return errors.New("no PEM certificates in " + clientCAFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return nil
+}
+
+// acmeOptions configures certificates issued by an ACME CA (-tls-acme-*).
+type acmeOptions struct {
+	domains []string
+	// cacheDir keeps the account key and certificates across restarts, so
+	// they aren't issued again each time
+	cacheDir string
+	email    string
+	// directoryURL is the CA's directory; empty uses Let's Encrypt
+	directoryURL string
+}
+
+// acmeDomains splits the comma-separated -tls-acme-domains.
+func acmeDomains(list string) []string {
+	var domains []string
+	for _, d := range strings.Split(list, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// acmeTLSConfig returns the server's TLS configuration with certificates
+// obtained and renewed by autocert for opts.domains, and the handler for
+// plain HTTP requests that answers HTTP-01 challenges and redirects
+// everything else to HTTPS on addr. TLS-ALPN-01 challenges are answered by
+// the TLS configuration itself, which works when addr is port 443. Client
+// certificates are verified as by tlsConfig.
+func acmeTLSConfig(opts acmeOptions, addr, clientCAFile string) (*tls.Config, http.Handler, error) {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(opts.domains...),
+		Cache:      autocert.DirCache(opts.cacheDir),
+		Email:      opts.email,
+	}
+	if opts.directoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: opts.directoryURL}
+	}
+	cfg := m.TLSConfig()
+	cfg.MinVersion = tls.VersionTLS12
+	if err := verifyClientCerts(cfg, clientCAFile); err != nil {
+		return nil, nil, err
+	}
+	return cfg, m.HTTPHandler(redirectHTTPS(addr)), nil
+}
+
+// redirectHTTPS redirects requests to the same host and path over HTTPS on
+// the port of addr.
+func redirectHTTPS(addr string) http.Handler {
+	_, port, _ := net.SplitHostPort(addr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusFound)
+	})
+}
+
+// requireClientCert rejects requests without a verified client certificate
+// when -tls-client-ca is set. It guards the endpoints shippers send logs
+// to; webhooks and SDKs, which can't present certificates, authenticate
+// their own way.
+func (s *server) requireClientCert(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.requireClientCerts && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			writeJSONError(w, http.StatusUnauthorized, "client_certificate_required",
+				"A client certificate is required", "present a certificate signed by a CA in -tls-client-ca")
+			return
+		}
+		next(w, r)
+	}
+}
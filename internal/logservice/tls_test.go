@@ -0,0 +1,196 @@
+package logservice
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and key signed by parent, or self-signed when
+// parent is nil.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write saves the certificate and key as PEM files in dir.
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+// TestTLSClientCerts tests requiring client certificates on the shipper
+// endpoints only.
+func TestTLSClientCerts(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil, true)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "locog", ca, false).write(t, dir, "server")
+	cfg, err := tlsConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newTestServer(t)
+	srv.requireClientCerts = true
+	handler, err := srv.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// httptest's TLS servers bring their own certificate
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := &http.Server{Handler: handler, ErrorLog: log.New(io.Discard, "", 0)}
+	go httpServer.Serve(tls.NewListener(listener, cfg))
+	defer httpServer.Close()
+	url := "https://" + listener.Addr().String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+	shipper := newTestCert(t, "shipper", ca, false)
+	withCert := client(tls.Certificate{Certificate: [][]byte{shipper.der}, PrivateKey: shipper.key})
+	stranger := newTestCert(t, "stranger", nil, false)
+	withUnknownCert := client(tls.Certificate{Certificate: [][]byte{stranger.der}, PrivateKey: stranger.key})
+
+	for _, tc := range []struct {
+		name   string
+		client *http.Client
+		status int
+	}{
+		{"no certificate", client(), http.StatusUnauthorized},
+		// Go clients only send a certificate signed by a CA the server names
+		{"untrusted certificate", withUnknownCert, http.StatusUnauthorized},
+		{"trusted certificate", withCert, http.StatusCreated},
+	} {
+		resp, err := tc.client.Post(url+"/api/ingest", "application/json", bytes.NewReader(sampleLogJSON()))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.status, resp.StatusCode)
+		}
+	}
+
+	// Queries don't need a certificate
+	resp, err := client().Get(url + "/api/logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 for a query, got %d", resp.StatusCode)
+	}
+}
+
+// TestCertReloader tests picking up a renewed certificate.
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, "old", nil, false).write(t, dir, "server")
+	cr, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed := newTestCert(t, "new", nil, false)
+	renewed.write(t, dir, "server")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	cert, _ := cr.getCertificate(nil)
+	if cert.Leaf.Subject.CommonName != "new" {
+		t.Errorf("expected the renewed certificate, got %s", cert.Leaf.Subject.CommonName)
+	}
+}
+
+// TestACMETLSConfig tests the configuration serving certificates from an
+// ACME CA, short of issuing one.
+func TestACMETLSConfig(t *testing.T) {
+	if got := acmeDomains(" logs.example.com, ,ingest.example.com"); len(got) != 2 || got[1] != "ingest.example.com" {
+		t.Errorf("unexpected domains: %q", got)
+	}
+
+	dir := t.TempDir()
+	caFile, _ := newTestCert(t, "ca", nil, true).write(t, dir, "ca")
+	opts := acmeOptions{domains: []string{"logs.example.com"}, cacheDir: filepath.Join(dir, "acme")}
+	cfg, challenges, err := acmeTLSConfig(opts, ":5081", caFile)
+	if err != nil {
+		t.Fatalf("acmeTLSConfig failed: %v", err)
+	}
+	if !slices.Contains(cfg.NextProtos, "acme-tls/1") || cfg.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Errorf("expected TLS-ALPN-01 and client certificates, got %v and %v", cfg.NextProtos, cfg.ClientAuth)
+	}
+	// Other names are refused before asking the CA
+	if _, err := cfg.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"}); err == nil {
+		t.Error("expected no certificate for a domain not in -tls-acme-domains")
+	}
+
+	w := httptest.NewRecorder()
+	challenges.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://logs.example.com/api/logs?service=api", nil))
+	if got := w.Header().Get("Location"); w.Code != http.StatusFound || got != "https://logs.example.com:5081/api/logs?service=api" {
+		t.Errorf("expected a redirect to HTTPS on 5081, got %d %q", w.Code, got)
+	}
+	w = httptest.NewRecorder()
+	redirectHTTPS(":443").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://logs.example.com:80/", nil))
+	if got := w.Header().Get("Location"); got != "https://logs.example.com/" {
+		t.Errorf("expected a redirect without a port, got %q", got)
+	}
+}
@@ -0,0 +1,85 @@
+package logservice
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"locog/internal/tracing"
+)
+
+// traceMiddleware records a server span for every request, continuing the
+// caller's trace when it sends a traceparent header. Spans are named after
+// the matched route, e.g. "GET /api/logs", so they group in the backend;
+// responses with a 5xx status mark the span as failed.
+func traceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tracing.Default() == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), r.Method, tracing.KindServer,
+			tracing.String("http.request.method", r.Method), tracing.String("url.path", r.URL.Path))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(ctx)
+		next.ServeHTTP(rec, r)
+
+		// The mux sets the pattern on the request it dispatched
+		if r.Pattern != "" {
+			span.SetName(r.Method + " " + r.Pattern)
+			span.SetAttributes(tracing.String("http.route", r.Pattern))
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttributes(tracing.Int("http.response.status_code", rec.status))
+		if rec.status >= 500 {
+			span.SetError(strconv.Itoa(rec.status) + " " + http.StatusText(rec.status))
+		}
+	})
+}
+
+// statusRecorder captures the response status for traceMiddleware.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Hijack supports WebSocket upgrades, whose span ends with the handshake.
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,130 @@
+package logservice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"locog/internal/tracing"
+
+	"github.com/gorilla/websocket"
+)
+
+// exportedSpan is the part of an OTLP/JSON span the tests check.
+type exportedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Status       *struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+// TestTraceMiddleware tests that requests are traced from the HTTP handler
+// through ingestion and storage, continuing the caller's trace.
+func TestTraceMiddleware(t *testing.T) {
+	var mu sync.Mutex
+	var spans []exportedSpan
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []exportedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+
+	tracer, err := tracing.NewTracer(tracing.Config{Endpoint: collector.URL, SampleRatio: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracing.SetDefault(tracer)
+	defer tracing.SetDefault(nil)
+
+	srv := newTestServer(t)
+	srv.hub = newWSHub()
+	handler, err := srv.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(`[`+string(sampleLogJSON())+`,`+string(sampleLogJSON())+`]`))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("ingest failed: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/logs?service=test-service", nil))
+
+	// WebSocket upgrades hijack the connection through the middleware
+	go srv.hub.run(t.Context())
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/api/ws", nil)
+	if err != nil {
+		t.Fatalf("websocket upgrade failed: %v", err)
+	}
+	conn.Close()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	byName := make(map[string]exportedSpan)
+	for _, s := range spans {
+		byName[s.Name] = s
+	}
+	ingest, store := byName["POST /api/ingest"], byName["ingest.store"]
+	if ingest.TraceID != traceID || ingest.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("expected the ingest span to continue the caller's trace, got %+v", ingest)
+	}
+	if store.ParentSpanID != ingest.SpanID {
+		t.Errorf("expected ingest.store under the request span, got %+v", store)
+	}
+	for _, name := range []string{"ingest.prepare", "db.InsertBatch", "ws.broadcast"} {
+		if s, ok := byName[name]; !ok || s.ParentSpanID != store.SpanID || s.TraceID != traceID {
+			t.Errorf("expected %s under ingest.store, got %+v", name, s)
+		}
+	}
+
+	query, dbQuery := byName["GET /api/logs"], byName["db.QueryLogs"]
+	if query.TraceID == "" || query.TraceID == traceID || query.ParentSpanID != "" {
+		t.Errorf("expected the query to start a new trace, got %+v", query)
+	}
+	if dbQuery.ParentSpanID != query.SpanID || dbQuery.Status != nil {
+		t.Errorf("expected db.QueryLogs under the request span, got %+v", dbQuery)
+	}
+	// The query breaks down into building, executing and encoding it
+	if s := byName["db.build_query"]; s.ParentSpanID != dbQuery.SpanID {
+		t.Errorf("expected db.build_query under db.QueryLogs, got %+v", s)
+	}
+	if s := byName["db.execute"]; s.ParentSpanID != dbQuery.SpanID || s.Status != nil {
+		t.Errorf("expected db.execute under db.QueryLogs, got %+v", s)
+	}
+	if s := byName["query.encode"]; s.ParentSpanID != query.SpanID {
+		t.Errorf("expected query.encode under the request span, got %+v", s)
+	}
+	if _, ok := byName["GET /api/ws"]; !ok {
+		t.Error("expected a span for the WebSocket handshake")
+	}
+}
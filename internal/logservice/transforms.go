@@ -0,0 +1,218 @@
+package logservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"locog/internal/models"
+	"locog/internal/transform"
+)
+
+// The configurable per log limits of transforms are capped, so a typo can't
+// let one log hold up ingest.
+const (
+	maxTransformSteps   = 1000000
+	maxTransformBytes   = 64 << 20
+	maxTransformTimeout = time.Second
+)
+
+// transformConfig runs a script on matching logs at ingest, e.g.
+// {"name": "status-level", "service": "nginx-*",
+// "script": "if meta.status >= 500 { set level = 'error' }"}.
+type transformConfig struct {
+	Name    string `json:"name"`
+	Service string `json:"service"` // glob; empty matches every service
+	Script  string `json:"script"`
+
+	// Per log limits; zero uses transform.DefaultLimits
+	MaxSteps int    `json:"max_steps"`
+	MaxBytes int    `json:"max_bytes"`
+	Timeout  string `json:"timeout"` // e.g. "10ms"
+
+	// OnError is what happens to a log the script fails on: "keep" stores
+	// it unchanged (default), "drop" discards it.
+	OnError string `json:"on_error"`
+}
+
+// logTransform is a compiled transform and what it did since startup.
+type logTransform struct {
+	name        string
+	service     string
+	script      *transform.Script
+	limits      transform.Limits
+	dropOnError bool
+
+	applied, dropped, failed, limited atomic.Int64
+
+	mu          sync.Mutex
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// transforms validates the configured transforms in order, returning nil
+// when none are configured.
+func (c *fileConfig) transforms() ([]*logTransform, error) {
+	if len(c.Transforms) == 0 {
+		return nil, nil
+	}
+	transforms := make([]*logTransform, 0, len(c.Transforms))
+	names := make(map[string]bool)
+	for i, tc := range c.Transforms {
+		if tc.Name == "" {
+			return nil, fmt.Errorf("transforms[%d]: missing name", i)
+		}
+		if names[tc.Name] {
+			return nil, fmt.Errorf("transform %s: duplicate name", tc.Name)
+		}
+		names[tc.Name] = true
+		if _, err := path.Match(tc.Service, ""); err != nil {
+			return nil, fmt.Errorf("transform %s: invalid service pattern %q", tc.Name, tc.Service)
+		}
+		script, err := transform.Compile(tc.Script)
+		if err != nil {
+			return nil, fmt.Errorf("transform %s: %w", tc.Name, err)
+		}
+		t := &logTransform{name: tc.Name, service: tc.Service, script: script,
+			limits: transform.Limits{MaxSteps: tc.MaxSteps, MaxBytes: tc.MaxBytes}}
+		if tc.MaxSteps < 0 || tc.MaxSteps > maxTransformSteps {
+			return nil, fmt.Errorf("transform %s: max_steps must be between 0 and %d", tc.Name, maxTransformSteps)
+		}
+		if tc.MaxBytes < 0 || tc.MaxBytes > maxTransformBytes {
+			return nil, fmt.Errorf("transform %s: max_bytes must be between 0 and %d", tc.Name, maxTransformBytes)
+		}
+		if tc.Timeout != "" {
+			if t.limits.Timeout, err = time.ParseDuration(tc.Timeout); err != nil || t.limits.Timeout <= 0 || t.limits.Timeout > maxTransformTimeout {
+				return nil, fmt.Errorf("transform %s: timeout must be a duration up to %s, got %q", tc.Name, maxTransformTimeout, tc.Timeout)
+			}
+		}
+		switch tc.OnError {
+		case "", "keep":
+		case "drop":
+			t.dropOnError = true
+		default:
+			return nil, fmt.Errorf("transform %s: on_error must be keep or drop, got %q", tc.Name, tc.OnError)
+		}
+		transforms = append(transforms, t)
+	}
+	return transforms, nil
+}
+
+// run runs the transform on l if it matches, reporting whether l is
+// dropped.
+func (t *logTransform) run(l *models.Log) bool {
+	if ok, _ := path.Match(t.service, l.Service); t.service != "" && !ok {
+		return false
+	}
+	t.applied.Add(1)
+	result, err := t.script.Run(l, t.limits)
+	if err != nil {
+		t.failed.Add(1)
+		if errors.Is(err, transform.ErrLimit) {
+			t.limited.Add(1)
+		}
+		t.mu.Lock()
+		t.lastError, t.lastErrorAt = err.Error(), time.Now().UTC()
+		t.mu.Unlock()
+		if t.dropOnError {
+			t.dropped.Add(1)
+		}
+		return t.dropOnError
+	}
+	if result.Drop {
+		t.dropped.Add(1)
+	}
+	return result.Drop
+}
+
+// stats reports what the transform did since startup.
+func (t *logTransform) stats() models.TransformStats {
+	st := models.TransformStats{Name: t.name, Service: t.service, Applied: t.applied.Load(),
+		Dropped: t.dropped.Load(), Failed: t.failed.Load(), LimitErrors: t.limited.Load()}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if st.LastError = t.lastError; st.LastError != "" {
+		at := t.lastErrorAt
+		st.LastErrorAt = &at
+	}
+	return st
+}
+
+// transformLogs runs the configured transforms, in order, on each log and
+// returns the logs none of them dropped. A transform failing on a log
+// leaves it as it was.
+func (s *server) transformLogs(logs []models.Log) []models.Log {
+	if len(s.transforms) == 0 {
+		return logs
+	}
+	dropped := make([]bool, len(logs))
+	forEachLog(len(logs), func(i int) {
+		for _, t := range s.transforms {
+			if dropped[i] = t.run(&logs[i]); dropped[i] {
+				return
+			}
+		}
+	})
+	kept := logs[:0]
+	for i := range logs {
+		if !dropped[i] {
+			kept = append(kept, logs[i])
+		}
+	}
+	return kept
+}
+
+// handleTransforms reports what each configured transform did since startup.
+func (s *server) handleTransforms(w http.ResponseWriter, r *http.Request) {
+	stats := make([]models.TransformStats, 0, len(s.transforms))
+	for _, t := range s.transforms {
+		stats = append(stats, t.stats())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// transformTestRequest is the body of /api/admin/transforms/test.
+type transformTestRequest struct {
+	Script   string     `json:"script"`
+	Log      models.Log `json:"log"`
+	MaxSteps int        `json:"max_steps"`
+	MaxBytes int        `json:"max_bytes"`
+}
+
+// handleTransformTest runs a script on a sample log without storing it, to
+// try a transform before configuring it. A script that doesn't compile is a
+// 400; one failing on the log reports the error.
+func (s *server) handleTransformTest(w http.ResponseWriter, r *http.Request) {
+	var req transformTestRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON", err.Error())
+		return
+	}
+	if req.MaxSteps < 0 || req.MaxSteps > maxTransformSteps || req.MaxBytes < 0 || req.MaxBytes > maxTransformBytes {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid limits",
+			fmt.Sprintf("max_steps must be at most %d and max_bytes at most %d", maxTransformSteps, maxTransformBytes))
+		return
+	}
+	script, err := transform.Compile(req.Script)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_script", "Invalid script", err.Error())
+		return
+	}
+
+	l := req.Log
+	result, err := script.Run(&l, transform.Limits{MaxSteps: req.MaxSteps, MaxBytes: req.MaxBytes})
+	resp := models.TransformTest{Dropped: result.Drop, Steps: result.Steps, Bytes: result.Bytes}
+	if err != nil {
+		resp.Error = err.Error()
+	} else if !result.Drop {
+		resp.Log = &l
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,95 @@
+package logservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"locog/internal/models"
+)
+
+// TestTransformLogs tests running configured transforms at ingest.
+func TestTransformLogs(t *testing.T) {
+	for _, bad := range []string{
+		`{"transforms": [{"script": "drop"}]}`,
+		`{"transforms": [{"name": "a", "script": "set x = 1"}]}`,
+		`{"transforms": [{"name": "a", "script": "drop", "on_error": "panic"}]}`,
+		`{"transforms": [{"name": "a", "script": "drop", "timeout": "1h"}]}`,
+		`{"transforms": [{"name": "a", "script": "drop"}, {"name": "a", "script": "drop"}]}`,
+	} {
+		if _, err := loadConfig(writeTestConfig(t, bad)); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+
+	cfg, err := loadConfig(writeTestConfig(t, `{"transforms": [
+		{"name": "health", "service": "nginx", "script": "if contains(message, '/health') { drop }"},
+		{"name": "status", "script": "if meta.status >= 500 { set level = 'error' }; set meta.team = 'web'"},
+		{"name": "strict", "script": "set meta.big = message + message", "max_bytes": 16}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t)
+	srv.transforms, _ = cfg.transforms()
+
+	body := `[
+		{"service": "nginx", "level": "info", "message": "GET /health", "host": "h"},
+		{"service": "nginx", "level": "info", "message": "GET /checkout", "host": "h", "metadata": {"status": 502}},
+		{"service": "api", "level": "info", "message": "GET /health", "host": "h"}
+	]`
+	rr := httptest.NewRecorder()
+	srv.handleIngest(rr, httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	stored := make(map[string]models.Log)
+	for _, l := range queryAll(t, srv) {
+		stored[l.Service] = l
+	}
+	if len(stored) != 2 || stored["nginx"].Message != "GET /checkout" {
+		t.Fatalf("expected the nginx health check dropped, got %+v", stored)
+	}
+	if l := stored["nginx"]; l.Level != "error" || l.Metadata["team"] != "web" || l.Metadata["big"] != nil {
+		t.Errorf("unexpected transformed log %+v", l)
+	}
+	if l := stored["api"]; l.Level != "info" || l.Metadata["team"] != "web" {
+		t.Errorf("unexpected transformed log %+v", l)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.handleTransforms(rr, httptest.NewRequest(http.MethodGet, "/api/admin/transforms", nil))
+	var stats []models.TransformStats
+	json.NewDecoder(rr.Body).Decode(&stats)
+	if len(stats) != 3 || stats[0].Applied != 2 || stats[0].Dropped != 1 || stats[2].Failed != 2 || stats[2].LimitErrors != 2 || stats[2].LastError == "" {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+// TestHandleTransformTest tests trying a script on a sample log.
+func TestHandleTransformTest(t *testing.T) {
+	srv := newTestServer(t)
+	test := func(body string) (int, models.TransformTest) {
+		rr := httptest.NewRecorder()
+		srv.handleTransformTest(rr, httptest.NewRequest(http.MethodPost, "/api/admin/transforms/test", bytes.NewReader([]byte(body))))
+		var result models.TransformTest
+		json.NewDecoder(rr.Body).Decode(&result)
+		return rr.Code, result
+	}
+
+	code, result := test(`{"script": "set level = upper(level)", "log": {"service": "api", "level": "warn", "message": "m"}}`)
+	if code != http.StatusOK || result.Log == nil || result.Log.Level != "WARN" || result.Steps == 0 {
+		t.Errorf("unexpected result %d %+v", code, result)
+	}
+	code, result = test(`{"script": "set message = message * 2", "log": {"service": "api", "level": "warn", "message": "m"}}`)
+	if code != http.StatusOK || result.Log != nil || result.Error == "" {
+		t.Errorf("expected the run's error, got %d %+v", code, result)
+	}
+	if code, _ = test(`{"script": "set", "log": {}}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid script, got %d", code)
+	}
+}
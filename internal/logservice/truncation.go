@@ -0,0 +1,59 @@
+package logservice
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// truncatedHeader is set to "true" when a query returned fewer logs than
+	// matched because of its limit.
+	truncatedHeader = "X-Locog-Truncated"
+	// totalEstimateHeader carries the number of matching logs, counted up to
+	// maxTotalEstimate, alongside truncatedHeader.
+	totalEstimateHeader = "X-Locog-Total-Estimate"
+)
+
+// maxTotalEstimate caps the count behind totalEstimateHeader so estimating a
+// huge result stays cheap; a value equal to it means "at least".
+const maxTotalEstimate = 100000
+
+// resultTruncation describes whether a query result was cut by its limit.
+type resultTruncation struct {
+	truncated bool
+	estimate  int64 // matching logs, 0 when unknown
+}
+
+// merge combines truncation from another source of the same result, e.g. a
+// federated peer. Estimates add up.
+func (t *resultTruncation) merge(other resultTruncation) {
+	t.truncated = t.truncated || other.truncated
+	t.estimate += other.estimate
+}
+
+func (t resultTruncation) setHeaders(w http.ResponseWriter) {
+	if !t.truncated {
+		return
+	}
+	w.Header().Set(truncatedHeader, "true")
+	if t.estimate > 0 {
+		w.Header().Set(totalEstimateHeader, strconv.FormatInt(t.estimate, 10))
+	}
+}
+
+const (
+	// queryMsHeader carries the time a query endpoint spent reading the
+	// database for its response, in milliseconds.
+	queryMsHeader = "X-Locog-Query-Ms"
+	// rowsHeader carries the number of logs, or aggregate rows, returned.
+	rowsHeader = "X-Locog-Rows"
+)
+
+// setQueryHeaders reports how long a query has taken since start and how
+// many rows it returned, so clients can show query performance.
+func setQueryHeaders(w http.ResponseWriter, start time.Time, rows int) {
+	ms := float64(time.Since(start).Microseconds()) / 1000
+	w.Header().Set(queryMsHeader, strconv.FormatFloat(ms, 'f', 1, 64))
+	w.Header().Set(rowsHeader, strconv.Itoa(rows))
+}
@@ -0,0 +1,471 @@
+package logservice
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"locog/internal/models"
+)
+
+const (
+	// maxQueuedUploads bounds the uploads waiting to be imported
+	maxQueuedUploads = 10
+	// uploadBatchSize is how many lines are stored at a time
+	uploadBatchSize = 1000
+	// maxUploadLine bounds one line of an uploaded file
+	maxUploadLine = 1 << 20
+	// maxUploadErrors is how many skipped lines a job reports the reason of
+	maxUploadErrors = 10
+	// uploadTTL is how long a finished upload job can be looked up
+	uploadTTL = time.Hour
+	// uploadFilePrefix names uploaded files, so those left by a previous run
+	// can be told apart in a shared directory
+	uploadFilePrefix = "locog-upload-"
+	// uploadSender is the sender of uploaded logs in storeLogs
+	uploadSender = "upload"
+)
+
+// Upload job states
+const (
+	uploadQueued  = "queued"
+	uploadRunning = "running"
+	uploadDone    = "done"
+	uploadFailed  = "failed"
+)
+
+// uploadFormats are the formats of uploaded files: NDJSON, a JSON log per
+// line, or plain text, a message per line. auto picks NDJSON when the first
+// line starts with '{'.
+var uploadFormats = map[string]bool{"auto": true, "ndjson": true, "text": true}
+
+// uploadLevels gives uploaded lines without a level, and not covered by
+// level_inference, one from the built-in rules, as files dropped in for a
+// look rarely carry levels the way shippers send them.
+var uploadLevels = levelInferrer{rules: builtinLevelRules, fallback: "info"}
+
+// uploadJob imports an uploaded log file in the background. Its ID is
+// random, so only whoever uploaded the file can follow the job.
+type uploadJob struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Filename   string     `json:"filename"`
+	Format     string     `json:"format"`
+	Service    string     `json:"service,omitempty"` // for lines without one
+	Host       string     `json:"host,omitempty"`    // for lines without one
+	Bytes      int64      `json:"bytes"`
+	Lines      int        `json:"lines"` // read so far, blank ones included
+	Imported   int        `json:"imported"`
+	Skipped    int        `json:"skipped"`
+	Errors     []string   `json:"errors,omitempty"` // why the first skipped lines were
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // when the job is forgotten
+
+	path string
+	// token and client are the uploader's API key and address, as the
+	// imported logs count against their quotas
+	token, client string
+}
+
+// uploadQueue imports uploaded files one at a time, in the order they were
+// uploaded. Files wait in dir until imported, then are deleted. Jobs are
+// kept in memory and forgotten on restart.
+type uploadQueue struct {
+	dir      string
+	maxBytes int64
+	pending  chan *uploadJob
+
+	mu   sync.Mutex
+	jobs map[string]*uploadJob
+}
+
+// newUploadQueue creates dir if needed and deletes the files a previous run
+// left in it.
+func newUploadQueue(dir string, maxBytes int64) (*uploadQueue, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create upload directory: %w", err)
+	}
+	stale, _ := filepath.Glob(filepath.Join(dir, uploadFilePrefix+"*"))
+	for _, path := range stale {
+		os.Remove(path)
+	}
+	return &uploadQueue{
+		dir:      dir,
+		maxBytes: maxBytes,
+		pending:  make(chan *uploadJob, maxQueuedUploads),
+		jobs:     make(map[string]*uploadJob),
+	}, nil
+}
+
+// run imports queued uploads through s's ingest pipeline and forgets expired
+// jobs until ctx is done.
+func (q *uploadQueue) run(ctx context.Context, s *server) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-q.pending:
+			q.process(ctx, s, job)
+		case now := <-ticker.C:
+			q.expire(now)
+		}
+	}
+}
+
+// newJob returns a job for an upload, with the path its file is saved to.
+func (q *uploadQueue) newJob(filename, format, service, host string) *uploadJob {
+	id := make([]byte, 16)
+	rand.Read(id)
+	job := &uploadJob{
+		ID:        hex.EncodeToString(id),
+		Status:    uploadQueued,
+		Filename:  filename,
+		Format:    format,
+		Service:   service,
+		Host:      host,
+		CreatedAt: time.Now().UTC(),
+	}
+	job.path = filepath.Join(q.dir, uploadFilePrefix+job.ID)
+	return job
+}
+
+// submit queues a job whose file is saved. It returns false when the queue
+// is full.
+func (q *uploadQueue) submit(job *uploadJob) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.pending <- job:
+	default:
+		return false
+	}
+	q.jobs[job.ID] = job
+	return true
+}
+
+// get returns a copy of a job.
+func (q *uploadQueue) get(id string) (uploadJob, bool) {
+	if q == nil {
+		return uploadJob{}, false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return uploadJob{}, false
+	}
+	v := *job
+	v.Errors = append([]string(nil), job.Errors...)
+	return v, true
+}
+
+// expire forgets the jobs that expired by now.
+func (q *uploadQueue) expire(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, job := range q.jobs {
+		if job.ExpiresAt != nil && !now.Before(*job.ExpiresAt) {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// process imports a queued job's file and deletes it.
+func (q *uploadQueue) process(ctx context.Context, s *server, job *uploadJob) {
+	defer os.Remove(job.path)
+	q.mu.Lock()
+	started := time.Now().UTC()
+	job.Status, job.StartedAt = uploadRunning, &started
+	q.mu.Unlock()
+
+	err := q.importFile(ctx, s, job)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	finished := time.Now().UTC()
+	expires := finished.Add(uploadTTL)
+	job.FinishedAt, job.ExpiresAt = &finished, &expires
+	if err != nil {
+		job.Status, job.Error = uploadFailed, err.Error()
+		slog.Error("upload import failed", "id", job.ID, "file", job.Filename, "error", err, "imported", job.Imported)
+		return
+	}
+	job.Status = uploadDone
+	slog.Info("upload imported", "id", job.ID, "file", job.Filename, "imported", job.Imported,
+		"skipped", job.Skipped, "duration", finished.Sub(started))
+}
+
+// importFile parses the job's file a line at a time and stores its logs in
+// batches, updating the job as it goes. Lines that don't parse or validate
+// are skipped; errors reading the file, a batch exceeding the uploader's
+// quotas or errors storing logs fail the job, keeping what was stored
+// before.
+func (q *uploadQueue) importFile(ctx context.Context, s *server, job *uploadJob) error {
+	f, err := os.Open(job.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), maxUploadLine)
+
+	format := job.Format
+	lines, imported, skipped := 0, 0, 0
+	var errs []string
+	batch := make([]models.Log, 0, uploadBatchSize)
+	flush := func() error {
+		if len(batch) > 0 {
+			refund, limited := s.quotas.admit(job.token, batch, job.client, time.Now())
+			if limited != nil {
+				return fmt.Errorf("quota exceeded: %s", limited.Details)
+			}
+			if err := s.storeLogs(ctx, batch, uploadSender); err != nil {
+				refund()
+				return err
+			}
+			imported += len(batch)
+			batch = batch[:0]
+		}
+		q.mu.Lock()
+		job.Lines, job.Imported, job.Skipped, job.Errors = lines, imported, skipped, errs
+		q.mu.Unlock()
+		return nil
+	}
+
+	for scanner.Scan() {
+		lines++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if format == "auto" {
+			format = "text"
+			if line[0] == '{' {
+				format = "ndjson"
+			}
+			q.mu.Lock()
+			job.Format = format
+			q.mu.Unlock()
+		}
+
+		l, err := parseUploadLine(line, format)
+		if err == nil {
+			if strings.TrimSpace(l.Service) == "" {
+				l.Service = job.Service
+			}
+			if strings.TrimSpace(l.Host) == "" {
+				l.Host = job.Host
+			}
+			s.levelInference.infer(&l)
+			if strings.TrimSpace(l.Level) == "" {
+				l.Level = uploadLevels.level(l.Message)
+			}
+			err = validateLog(&l)
+		}
+		if err != nil {
+			skipped++
+			if len(errs) < maxUploadErrors {
+				errs = append(errs, fmt.Sprintf("line %d: %v", lines, err))
+			}
+			continue
+		}
+		batch = append(batch, l)
+		if len(batch) == uploadBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		flush()
+		if errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("line %d is longer than %d bytes", lines+1, maxUploadLine)
+		}
+		return err
+	}
+	return flush()
+}
+
+// parseUploadLine parses a non-blank line of an uploaded file. NDJSON lines
+// keep unknown fields as metadata; a plain text line is the message, after a
+// leading RFC 3339 timestamp if there is one.
+func parseUploadLine(line []byte, format string) (models.Log, error) {
+	if format == "ndjson" {
+		return decodeLog(json.RawMessage(line), unknownFieldsMetadata)
+	}
+	var l models.Log
+	message := string(line)
+	if first, rest, ok := strings.Cut(message, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, first); err == nil {
+			l.Timestamp, message = t, strings.TrimSpace(rest)
+		}
+	}
+	l.Message = message
+	return l, nil
+}
+
+// handleUploads imports a log file sent as multipart/form-data, e.g. dropped
+// into the web UI: POST /api/uploads with the file in the "file" field and
+// optional "service" and "host" fields (or query parameters) for lines
+// without them, and "format" (auto, ndjson or text). It answers 202 with the
+// job once the file is saved; GET /api/uploads/{id} reports its progress.
+func (s *server) handleUploads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ip := getClientIP(r)
+	if limited := s.checkRateLimit(endpointUploads, ip); limited != nil {
+		writeRateLimited(w, limited)
+		return
+	}
+	if s.uploads == nil {
+		writeJSONError(w, http.StatusNotFound, "uploads_disabled", "Uploads are disabled",
+			"start the service with -upload-max-bytes above 0")
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "multipart/form-data" {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "invalid_content_type", "Uploads must be multipart/form-data",
+			"send the file in a form field named file")
+		return
+	}
+
+	// Form fields win over query parameters; the file is saved as it is read
+	query := r.URL.Query()
+	fields := map[string]string{"service": query.Get("service"), "host": query.Get("host"), "format": query.Get("format")}
+	// The limit leaves room for the multipart framing and form fields
+	r.Body = http.MaxBytesReader(w, r.Body, s.uploads.maxBytes+64<<10)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_upload", "Invalid multipart body", err.Error())
+		return
+	}
+	var job *uploadJob
+	defer func() {
+		// Removed unless queued, which takes the file over
+		if job != nil {
+			if _, queued := s.uploads.get(job.ID); !queued {
+				os.Remove(job.path)
+			}
+		}
+	}()
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.writeUploadError(w, err)
+			return
+		}
+		switch name := part.FormName(); {
+		case name == "file" && job == nil:
+			job = s.uploads.newJob(filepath.Base(part.FileName()), "", "", "")
+			if job.Bytes, err = saveUpload(part, job.path, s.uploads.maxBytes); err != nil {
+				s.writeUploadError(w, err)
+				return
+			}
+		case name == "service" || name == "host" || name == "format":
+			value, err := io.ReadAll(io.LimitReader(part, 1024))
+			if err != nil {
+				s.writeUploadError(w, err)
+				return
+			}
+			fields[name] = strings.TrimSpace(string(value))
+		}
+	}
+	if job == nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_upload", "No file uploaded",
+			"send the file in a form field named file")
+		return
+	}
+	if fields["format"] == "" {
+		fields["format"] = "auto"
+	}
+	if !uploadFormats[fields["format"]] {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid format value",
+			fmt.Sprintf("'format' must be auto, ndjson or text, got: %s", fields["format"]))
+		return
+	}
+	job.Format, job.Service, job.Host = fields["format"], fields["service"], fields["host"]
+	job.token, job.client = requestAPIKey(r), ip
+	if job.Service == "" && job.Format == "text" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Missing service",
+			"plain text lines carry no service; set the service field")
+		return
+	}
+
+	if !s.uploads.submit(job) {
+		w.Header().Set("Retry-After", "60")
+		writeJSONError(w, http.StatusServiceUnavailable, "upload_queue_full", "Too many queued uploads",
+			fmt.Sprintf("at most %d uploads may wait to be imported; retry later", maxQueuedUploads))
+		return
+	}
+	view, _ := s.uploads.get(job.ID)
+	w.Header().Set("Location", "/api/uploads/"+job.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(view)
+}
+
+// writeUploadError reports a failure reading or saving an upload.
+func (s *server) writeUploadError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || errors.Is(err, errUploadTooLarge) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "upload_too_large", "Upload too large",
+			fmt.Sprintf("files may be at most %d bytes", s.uploads.maxBytes))
+		return
+	}
+	slog.Warn("failed to read upload", "error", err)
+	writeJSONError(w, http.StatusBadRequest, "invalid_upload", "Invalid upload", err.Error())
+}
+
+var errUploadTooLarge = errors.New("upload too large")
+
+// saveUpload writes an uploaded file of at most maxBytes to a new file at
+// path and returns its size.
+func saveUpload(r io.Reader, path string, maxBytes int64) (int64, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, maxBytes+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > maxBytes {
+		err = errUploadTooLarge
+	}
+	return n, err
+}
+
+// handleUploadJob reports on an upload job.
+func (s *server) handleUploadJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.uploads.get(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Upload not found",
+			"upload jobs are forgotten an hour after they finish")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
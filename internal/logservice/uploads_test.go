@@ -0,0 +1,144 @@
+package logservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/db"
+	"locog/internal/models"
+)
+
+// newUploadRequest returns a multipart upload of content with the given form
+// fields.
+func newUploadRequest(t *testing.T, filename, content string, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, value := range fields {
+		mw.WriteField(name, value)
+	}
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(content))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/uploads", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+// TestHandleUploads tests importing plain text and NDJSON files.
+func TestHandleUploads(t *testing.T) {
+	srv := newTestServer(t)
+	// The import runs on its own goroutine, which a ring allows
+	database, err := db.NewRing(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	srv.db = database
+	if srv.uploads, err = newUploadQueue(t.TempDir(), 1024); err != nil {
+		t.Fatal(err)
+	}
+	go srv.uploads.run(t.Context(), srv)
+	handler, err := srv.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(req *http.Request) uploadJob {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var job uploadJob
+		json.NewDecoder(rr.Body).Decode(&job)
+		deadline := time.Now().Add(5 * time.Second)
+		for job.Status == uploadQueued || job.Status == uploadRunning {
+			if time.Now().After(deadline) {
+				t.Fatalf("upload still %s", job.Status)
+			}
+			time.Sleep(10 * time.Millisecond)
+			rr = httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/uploads/"+job.ID, nil))
+			job = uploadJob{}
+			json.NewDecoder(rr.Body).Decode(&job)
+		}
+		return job
+	}
+
+	text := "2025-01-02T03:04:05Z ERROR payment failed\n\nretrying in 5s\n"
+	job := upload(newUploadRequest(t, "app.log", text, map[string]string{"service": "legacy", "host": "web-1"}))
+	if job.Status != uploadDone || job.Format != "text" || job.Imported != 2 || job.Lines != 3 {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	logs, _ := srv.db.QueryLogs(t.Context(), models.LogFilter{Service: "legacy", Ascending: true})
+	if len(logs) != 2 {
+		t.Fatalf("expected 2 logs, got %d", len(logs))
+	}
+	if l := logs[0]; l.Message != "ERROR payment failed" || l.Level != "error" || l.Host != "web-1" ||
+		!l.Timestamp.Equal(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("unexpected first log %+v", l)
+	}
+	if logs[1].Level != "info" {
+		t.Errorf("expected the default level, got %q", logs[1].Level)
+	}
+
+	ndjson := `{"service": "api", "level": "warn", "message": "slow", "request_id": "r1"}
+not json
+{"level": "info", "message": "no service"}
+`
+	job = upload(newUploadRequest(t, "app.ndjson", ndjson, nil))
+	if job.Status != uploadDone || job.Format != "ndjson" || job.Imported != 1 || job.Skipped != 2 || len(job.Errors) != 2 {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	if !strings.HasPrefix(job.Errors[0], "line 2:") {
+		t.Errorf("unexpected errors %q", job.Errors)
+	}
+	logs, _ = srv.db.QueryLogs(t.Context(), models.LogFilter{Service: "api"})
+	if len(logs) != 1 || logs[0].Metadata["request_id"] != "r1" {
+		t.Errorf("expected unknown fields in metadata, got %+v", logs)
+	}
+
+	// Imported logs count against the uploader's quotas; a batch exceeding
+	// one fails the job
+	cfg, err := loadConfig(writeTestConfig(t, `{"quotas": [{"name": "team-a", "token": "team-a-key", "bytes_per_day": 30}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.quotas, _ = cfg.quotas()
+	req := newUploadRequest(t, "app.log", "first line\nsecond line\n", map[string]string{"service": "legacy"})
+	req.Header.Set("X-Api-Key", "team-a-key")
+	if job := upload(req); job.Status != uploadFailed || job.Imported != 0 || !strings.HasPrefix(job.Error, "quota exceeded: quota team-a") {
+		t.Errorf("expected the upload over team-a's quota to fail, got %+v", job)
+	}
+	if job := upload(newUploadRequest(t, "app.log", "first line\nsecond line\n", map[string]string{"service": "legacy"})); job.Status != uploadDone {
+		t.Errorf("expected an upload without the token imported, got %+v", job)
+	}
+	srv.quotas = nil
+
+	for _, tc := range []struct {
+		req    *http.Request
+		status int
+	}{
+		{newUploadRequest(t, "big.log", strings.Repeat("x", 2048), map[string]string{"service": "legacy"}), http.StatusRequestEntityTooLarge},
+		{newUploadRequest(t, "app.log", "line", map[string]string{"format": "text"}), http.StatusBadRequest},
+		{newUploadRequest(t, "app.log", "line", map[string]string{"format": "xml"}), http.StatusBadRequest},
+		{httptest.NewRequest(http.MethodPost, "/api/uploads", strings.NewReader("line")), http.StatusUnsupportedMediaType},
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, tc.req)
+		if rr.Code != tc.status {
+			t.Errorf("expected %d, got %d: %s", tc.status, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package logservice
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+)
+
+// maxRevealTokens bounds the values revealed by one request, and
+// maxRevealReasonLength the recorded justification.
+const (
+	maxRevealTokens       = 100
+	maxRevealReasonLength = 500
+)
+
+// vaultRevealRequest is the body of POST /api/vault/reveal.
+type vaultRevealRequest struct {
+	Tokens []string `json:"tokens"` // from [REDACTED:<rule>:<token>] placeholders
+	Reason string   `json:"reason"` // recorded in the audit trail
+}
+
+// vaultRevealResponse maps each found token to its original value.
+type vaultRevealResponse struct {
+	Values  map[string]revealedValue `json:"values"`
+	Missing []string                 `json:"missing"` // unknown or expired tokens
+}
+
+type revealedValue struct {
+	Rule  string          `json:"rule"`
+	Value json.RawMessage `json:"value"`
+}
+
+// handleVaultReveal decrypts vaulted values. It requires the vault reveal
+// token, which the admin token does not substitute for, and records every
+// request in the audit trail before revealing anything.
+func (s *server) handleVaultReveal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.redactor == nil || s.redactor.vault == nil || s.vaultRevealToken == "" {
+		writeJSONError(w, http.StatusNotFound, "vault_disabled",
+			"The redaction vault is not enabled", "Set redaction.vault in the config file, -vault-key and -vault-reveal-token")
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.vaultRevealToken)) != 1 {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized",
+			"Vault reveal token required", "Send the vault reveal token as 'Authorization: Bearer <token>'")
+		return
+	}
+
+	var req vaultRevealRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_body", "Invalid request body", err.Error())
+		return
+	}
+	if len(req.Tokens) == 0 || len(req.Tokens) > maxRevealTokens {
+		writeJSONError(w, http.StatusBadRequest, "invalid_tokens", "Invalid tokens",
+			fmt.Sprintf("'tokens' must list between 1 and %d vault tokens", maxRevealTokens))
+		return
+	}
+	for _, t := range req.Tokens {
+		if !vaultTokenPattern.MatchString(t) {
+			writeJSONError(w, http.StatusBadRequest, "invalid_tokens", "Invalid tokens",
+				fmt.Sprintf("vault tokens are 16 hex characters, got: %q", t))
+			return
+		}
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || len(req.Reason) > maxRevealReasonLength {
+		writeJSONError(w, http.StatusBadRequest, "invalid_reason", "Invalid reason",
+			fmt.Sprintf("'reason' is required and at most %d characters", maxRevealReasonLength))
+		return
+	}
+
+	reveal := models.VaultReveal{RevealedAt: time.Now(), Client: getClientIP(r), Reason: req.Reason, Tokens: req.Tokens}
+	if err := s.db.RecordVaultReveal(r.Context(), reveal); err != nil {
+		slog.Error("failed to record vault reveal", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("vault reveal", "client", reveal.Client, "tokens", len(req.Tokens), "reason", req.Reason)
+
+	entries, err := s.db.VaultEntries(r.Context(), req.Tokens)
+	if err != nil {
+		slog.Error("failed to read vault entries", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	resp := vaultRevealResponse{Values: make(map[string]revealedValue, len(entries)), Missing: []string{}}
+	for _, e := range entries {
+		value, err := s.redactor.open(e)
+		if err != nil {
+			// Most likely encrypted under a different -vault-key
+			slog.Warn("failed to decrypt vault entry", "token", e.Token, "error", err)
+			continue
+		}
+		resp.Values[e.Token] = revealedValue{Rule: e.Rule, Value: value}
+	}
+	for _, t := range req.Tokens {
+		if _, ok := resp.Values[t]; !ok {
+			resp.Missing = append(resp.Missing, t)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleVaultReveals lists the reveal audit trail, newest first.
+func (s *server) handleVaultReveals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter",
+				"Invalid limit value", fmt.Sprintf("'limit' must be between 1 and 1000, got: %s", v))
+			return
+		}
+		limit = n
+	}
+
+	reveals, err := s.db.VaultReveals(r.Context(), limit)
+	if err != nil {
+		slog.Error("failed to list vault reveals", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reveals)
+}
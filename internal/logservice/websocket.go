@@ -0,0 +1,750 @@
+package logservice
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"locog/internal/models"
+	"locog/internal/tracing"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true // Allow all origins (matches existing CORS policy)
+	},
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// wsClient represents a single WebSocket connection.
+type wsClient struct {
+	hub    *wsHub
+	tenant *wsTenant
+	conn   *websocket.Conn
+	send   chan []byte
+
+	// minLevel withholds less severe logs from this client; "" sends all
+	minLevel string
+	// hiddenStreams are streams this client may not read
+	hiddenStreams []string
+	// services are globs of the services this client may read; nil reads
+	// all of them
+	services []string
+	// protocol is the message framing version the client asked for; 0 sends
+	// bare arrays of logs
+	protocol int
+	// query limits the logs sent to those matching the filter parameters
+	// and q=, set on connect and replaced by subscribe messages; nil sends all
+	query *liveQuery
+	// parseQuery compiles a subscription's filters
+	parseQuery func(url.Values) (*liveQuery, *apiError)
+	// dropped is set, under the hub's mu, once the hub has closed send
+	dropped bool
+}
+
+// view identifies the messages a client receives, so clients that see the
+// same logs in the same framing share one encoded message.
+func (c *wsClient) view() string {
+	var q string
+	if c.query != nil {
+		q = c.query.query
+	}
+	return fmt.Sprintf("%s|%s|%s|%d|%s", c.minLevel, strings.Join(c.hiddenStreams, ","), strings.Join(c.services, ","), c.protocol, q)
+}
+
+// wsProtocolVersion is the latest live stream message framing. Version 1 wraps
+// every message in a wsEnvelope; clients must ignore types they don't know.
+const wsProtocolVersion = 1
+
+// Live stream message types.
+const (
+	wsTypeLogs       = "logs"       // data is an array of logs
+	wsTypeSubscribed = "subscribed" // data is a wsSubscription: new filters are in effect
+	wsTypeError      = "error"      // data is an apiError: a client message was rejected
+	wsTypeSubscribe  = "subscribe"  // sent by clients: replaces the stream's filters
+)
+
+// wsMaxClientMessage bounds messages from clients, which only subscribe.
+const wsMaxClientMessage = 8 << 10
+
+// wsSubscription is a subscribe message, e.g.
+// {"type":"subscribe","filters":{"service":"api","level":["error","fatal"]}}.
+// Filters take the live stream parameters of /api/ws, each a string or an
+// array of strings; an empty object streams every log.
+type wsSubscription struct {
+	Type    string       `json:"type,omitempty"`
+	Filters wsFilterList `json:"filters"`
+}
+
+// wsFilterList holds subscription filters as query parameters.
+type wsFilterList url.Values
+
+func (f *wsFilterList) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	values := url.Values{}
+	for name, v := range raw {
+		var one string
+		if err := json.Unmarshal(v, &one); err == nil {
+			values.Add(name, one)
+			continue
+		}
+		var many []string
+		if err := json.Unmarshal(v, &many); err != nil {
+			return fmt.Errorf("filter %q must be a string or an array of strings", name)
+		}
+		values[name] = many
+	}
+	*f = wsFilterList(values)
+	return nil
+}
+
+func (f wsFilterList) MarshalJSON() ([]byte, error) {
+	if f == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(map[string][]string(f))
+}
+
+// wsEnvelope frames a live stream message so new message types can be added
+// without breaking clients.
+type wsEnvelope struct {
+	Type string          `json:"type"`
+	V    int             `json:"v"`
+	Data json.RawMessage `json:"data"`
+}
+
+// frameMessage wraps an encoded payload for the given protocol version.
+func frameMessage(protocol int, msgType string, data []byte) []byte {
+	if protocol == 0 || data == nil {
+		return data
+	}
+	framed, err := json.Marshal(wsEnvelope{Type: msgType, V: protocol, Data: data})
+	if err != nil {
+		slog.Error("failed to frame websocket message", "error", err)
+		return nil
+	}
+	return framed
+}
+
+// wsHub manages active WebSocket clients and broadcasts messages. Clients are
+// partitioned by tenant, each partition delivering its broadcasts from its own
+// goroutine.
+type wsHub struct {
+	mu sync.RWMutex
+	// tenants are the partitions clients were admitted to, by name
+	tenants map[string]*wsTenant
+	// stopped is set once stop closes the partitions; guarded by mu
+	stopped bool
+	// partitions tracks the partitions' goroutines
+	partitions sync.WaitGroup
+
+	broadcast  chan []models.Log
+	register   chan *wsClient
+	unregister chan *wsClient
+
+	// flushInterval coalesces the batches broadcast within it into one
+	// message per client; 0 sends every batch as it arrives
+	flushInterval time.Duration
+
+	// notify is closed and replaced on every broadcast, waking long-poll
+	// requests waiting for new logs.
+	notifyMu sync.Mutex
+	notify   chan struct{}
+
+	// writers tracks client write pumps, so shutdown waits for close frames
+	writers sync.WaitGroup
+	// closing is closed when the hub starts shutting down, after which
+	// clients aren't registered and broadcasts are dropped; done is closed
+	// once every client has been sent a close frame.
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// wsTenant is the partition of the hub serving one tenant's clients: those
+// whose first matching access policy is name, admins for wsAdminTenant, or
+// for "" those no policy matches. Each partition encodes and delivers broadcasts from
+// its own queue, so a tenant's dashboard farm only slows its own live tail.
+type wsTenant struct {
+	name string
+	// clients, conns (clients admitted, including those still connecting),
+	// limit (the most conns admitted; 0 is unlimited) and rejected are
+	// guarded by the hub's mu
+	clients  map[*wsClient]struct{}
+	conns    int
+	limit    int
+	rejected int64
+
+	queue chan []models.Log
+	// dropped counts batches not delivered because the queue was full
+	dropped atomic.Int64
+}
+
+// wsAdminTenant is the partition of admins' live streams, apart from those
+// no policy matches so they don't count against live_stream.max_connections.
+// Access policies can't take its name.
+const wsAdminTenant = "(admin)"
+
+// wsTenantQueue bounds the batches a partition may fall behind by before
+// its clients miss some.
+const wsTenantQueue = 64
+
+var (
+	// errHubClosing refuses clients once the hub is shutting down.
+	errHubClosing = errors.New("live stream hub is shutting down")
+	// errTenantLimit refuses clients over their tenant's connection limit.
+	errTenantLimit = errors.New("live stream connection limit reached")
+)
+
+func newWSHub() *wsHub {
+	return &wsHub{
+		tenants:    make(map[string]*wsTenant),
+		broadcast:  make(chan []models.Log, 256),
+		register:   make(chan *wsClient),
+		unregister: make(chan *wsClient),
+		notify:     make(chan struct{}),
+		closing:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// changed returns a channel that is closed the next time logs are broadcast.
+func (h *wsHub) changed() <-chan struct{} {
+	h.notifyMu.Lock()
+	defer h.notifyMu.Unlock()
+	return h.notify
+}
+
+// wsMaxCoalescedLogs flushes pending logs early so a burst doesn't build one
+// huge message.
+const wsMaxCoalescedLogs = 1000
+
+// run processes register, unregister, and broadcast events until ctx is
+// canceled, then shuts the hub down (see stop).
+func (h *wsHub) run(ctx context.Context) {
+	var pending []models.Log
+	var flush <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			h.stop(pending)
+			return
+
+		case client := <-h.register:
+			h.mu.Lock()
+			client.tenant.clients[client] = struct{}{}
+			h.mu.Unlock()
+			slog.Debug("websocket client connected", "tenant", client.tenant.name, "clients", h.clientCount())
+
+		case client := <-h.unregister:
+			h.mu.Lock()
+			if _, ok := client.tenant.clients[client]; ok {
+				h.remove(client)
+			}
+			h.mu.Unlock()
+			slog.Debug("websocket client disconnected", "tenant", client.tenant.name, "clients", h.clientCount())
+
+		case logs := <-h.broadcast:
+			if h.flushInterval <= 0 {
+				h.dispatch(logs)
+				continue
+			}
+			pending = append(pending, logs...)
+			if len(pending) >= wsMaxCoalescedLogs {
+				h.dispatch(pending)
+				pending, flush = nil, nil
+			} else if flush == nil {
+				flush = time.After(h.flushInterval)
+			}
+
+		case <-flush:
+			h.dispatch(pending)
+			pending, flush = nil, nil
+		}
+	}
+}
+
+// admit reserves a connection in the named tenant's partition, creating it
+// on first use, unless the partition already has limit connections (0 is
+// unlimited). The caller must release the partition unless it registers a
+// client with it.
+func (h *wsHub) admit(name string, limit int) (*wsTenant, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stopped {
+		return nil, errHubClosing
+	}
+	t, ok := h.tenants[name]
+	if !ok {
+		t = &wsTenant{name: name, clients: make(map[*wsClient]struct{}), queue: make(chan []models.Log, wsTenantQueue)}
+		h.tenants[name] = t
+		h.partitions.Add(1)
+		go h.deliver(t)
+	}
+	if limit > 0 {
+		t.limit = limit
+	}
+	if limit > 0 && t.conns >= limit {
+		t.rejected++
+		return nil, errTenantLimit
+	}
+	t.conns++
+	return t, nil
+}
+
+// release gives back a connection admit reserved for a client that never
+// registered.
+func (h *wsHub) release(t *wsTenant) {
+	h.mu.Lock()
+	t.conns--
+	h.mu.Unlock()
+}
+
+// remove drops a registered client and closes its send channel. The caller
+// must hold mu.
+func (h *wsHub) remove(client *wsClient) {
+	delete(client.tenant.clients, client)
+	client.tenant.conns--
+	client.dropped = true
+	close(client.send)
+}
+
+// dispatch queues logs for every partition with clients. A partition that
+// has fallen wsTenantQueue batches behind misses them rather than holding
+// up the others.
+func (h *wsHub) dispatch(logs []models.Log) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, t := range h.tenants {
+		if len(t.clients) == 0 {
+			continue
+		}
+		select {
+		case t.queue <- logs:
+		default:
+			t.dropped.Add(1)
+		}
+	}
+}
+
+// deliver sends the batches queued for a partition until stop closes its
+// queue.
+func (h *wsHub) deliver(t *wsTenant) {
+	defer h.partitions.Done()
+	for logs := range t.queue {
+		h.send(t, logs)
+	}
+}
+
+// send delivers logs to a partition's clients, encoding once per distinct
+// view. Each delivery is the root of its own trace, as it may coalesce
+// several ingests.
+func (h *wsHub) send(t *wsTenant, logs []models.Log) {
+	_, span := tracing.Start(context.Background(), "ws.send", tracing.KindInternal,
+		tracing.Int("ws.logs", len(logs)), tracing.String("ws.tenant", t.name))
+	defer span.End()
+
+	var clients, dropped int
+	encoded := make(map[string][]byte)
+	h.mu.RLock()
+	for client := range t.clients {
+		clients++
+		view := client.view()
+		message, ok := encoded[view]
+		if !ok {
+			message = frameMessage(client.protocol, wsTypeLogs,
+				encodeStreamLogs(logs, client.minLevel, client.hiddenStreams, client.services, client.query))
+			encoded[view] = message
+		}
+		if message == nil {
+			continue
+		}
+		select {
+		case client.send <- message:
+		default:
+			// Client's send buffer is full; disconnect it.
+			dropped++
+			h.mu.RUnlock()
+			h.mu.Lock()
+			if _, ok := t.clients[client]; ok {
+				h.remove(client)
+			}
+			h.mu.Unlock()
+			h.mu.RLock()
+		}
+	}
+	h.mu.RUnlock()
+	span.SetAttributes(tracing.Int("ws.clients", clients), tracing.Int("ws.encodings", len(encoded)),
+		tracing.Int("ws.dropped_clients", dropped))
+}
+
+// stop delivers pending logs and any batches still queued, then closes every
+// client with a going away close frame and waits for the write pumps to send
+// it before closing done.
+func (h *wsHub) stop(pending []models.Log) {
+	close(h.closing)
+	for queued := true; queued; {
+		select {
+		case logs := <-h.broadcast:
+			pending = append(pending, logs...)
+		default:
+			queued = false
+		}
+	}
+
+	h.mu.Lock()
+	h.stopped = true
+	tenants := make([]*wsTenant, 0, len(h.tenants))
+	for _, t := range h.tenants {
+		tenants = append(tenants, t)
+	}
+	h.mu.Unlock()
+	for _, t := range tenants {
+		if len(pending) > 0 {
+			t.queue <- pending
+		}
+		close(t.queue)
+	}
+	h.partitions.Wait()
+
+	h.mu.Lock()
+	for _, t := range tenants {
+		for client := range t.clients {
+			h.remove(client)
+		}
+	}
+	h.mu.Unlock()
+	h.writers.Wait()
+	close(h.done)
+}
+
+// closeMessage is the close frame sent to clients the hub drops: going away
+// once it is shutting down, otherwise empty.
+func (h *wsHub) closeMessage() []byte {
+	select {
+	case <-h.closing:
+		return websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	default:
+		return []byte{}
+	}
+}
+
+// shutdown waits for run to stop after its context is canceled, returning
+// ctx's error if it is done first.
+func (h *wsHub) shutdown(ctx context.Context) error {
+	select {
+	case <-h.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *wsHub) clientCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	n := 0
+	for _, t := range h.tenants {
+		n += len(t.clients)
+	}
+	return n
+}
+
+// tenantStats reports the partitions, by tenant name.
+func (h *wsHub) tenantStats() []models.LiveStreamTenant {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	stats := make([]models.LiveStreamTenant, 0, len(h.tenants))
+	for _, t := range h.tenants {
+		stats = append(stats, models.LiveStreamTenant{Tenant: t.name, Connections: len(t.clients),
+			MaxConnections: t.limit, Rejected: t.rejected, DroppedBatches: t.dropped.Load()})
+	}
+	slices.SortFunc(stats, func(a, b models.LiveStreamTenant) int { return strings.Compare(a.Tenant, b.Tenant) })
+	return stats
+}
+
+// broadcastLogs sends logs to all connected clients.
+func (h *wsHub) broadcastLogs(logs []models.Log) {
+	select {
+	case h.broadcast <- logs:
+	case <-h.closing:
+	}
+
+	h.notifyMu.Lock()
+	close(h.notify)
+	h.notify = make(chan struct{})
+	h.notifyMu.Unlock()
+}
+
+// encodeStreamLogs serializes the logs at or above minLevel, outside
+// hiddenStreams, of services matching a glob in services (if any) and matching
+// query, returning nil when there are none to send.
+func encodeStreamLogs(logs []models.Log, minLevel string, hiddenStreams, services []string, query *liveQuery) []byte {
+	if minLevel != "" || len(hiddenStreams) > 0 || len(services) > 0 || query != nil {
+		var kept []models.Log
+		for i, l := range logs {
+			if !belowLevel(l.Level, minLevel) && !slices.Contains(hiddenStreams, l.Stream) &&
+				serviceMatches(services, l.Service) && query.matches(&logs[i]) {
+				kept = append(kept, l)
+			}
+		}
+		logs = kept
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(logs)
+	if err != nil {
+		slog.Error("failed to marshal logs for websocket broadcast", "error", err)
+		return nil
+	}
+	return data
+}
+
+const (
+	// Time allowed to write a message to the peer.
+	writeWait = 10 * time.Second
+
+	// Time allowed to read the next pong message from the peer.
+	pongWait = 60 * time.Second
+
+	// Send pings to peer with this period. Must be less than pongWait.
+	pingPeriod = (pongWait * 9) / 10
+)
+
+// readPump reads messages from the WebSocket connection (handles control frames).
+func (c *wsClient) readPump() {
+	defer func() {
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.closing:
+		}
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(wsMaxClientMessage)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		c.subscribe(message)
+	}
+}
+
+// subscribe applies a subscribe message, replacing the client's filters.
+// Clients using v=1 are sent a subscribed message, or an error leaving the
+// filters unchanged.
+func (c *wsClient) subscribe(message []byte) {
+	var sub wsSubscription
+	if err := json.Unmarshal(message, &sub); err != nil || sub.Type != wsTypeSubscribe {
+		details := fmt.Sprintf("expected a %q message", wsTypeSubscribe)
+		if err != nil {
+			details = err.Error()
+		}
+		c.reply(wsTypeError, apiError{Error: "Invalid message", Code: "invalid_message", Details: details})
+		return
+	}
+	for name := range sub.Filters {
+		if !slices.Contains(liveQueryParams, name) {
+			c.reply(wsTypeError, apiError{Error: "Invalid filter", Code: "invalid_parameter",
+				Details: fmt.Sprintf("unknown filter %q; use %s", name, strings.Join(liveQueryParams, ", "))})
+			return
+		}
+	}
+	query, apiErr := c.parseQuery(url.Values(sub.Filters))
+	if apiErr != nil {
+		c.reply(wsTypeError, *apiErr)
+		return
+	}
+
+	c.hub.mu.Lock()
+	c.query = query
+	c.hub.mu.Unlock()
+	c.reply(wsTypeSubscribed, wsSubscription{Filters: sub.Filters})
+}
+
+// reply sends a framed message to a v=1 client. Older clients only
+// understand arrays of logs, so they get nothing.
+func (c *wsClient) reply(msgType string, v interface{}) {
+	if c.protocol == 0 {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("failed to marshal websocket reply", "error", err)
+		return
+	}
+	message := frameMessage(c.protocol, msgType, data)
+
+	// The hub closes send when it drops the client. A subscribe message may
+	// arrive before run has registered the client, which must still be
+	// answered.
+	c.hub.mu.RLock()
+	defer c.hub.mu.RUnlock()
+	if !c.dropped {
+		select {
+		case c.send <- message:
+		default:
+		}
+	}
+}
+
+// writePump pumps messages from the hub to the WebSocket connection.
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+		c.hub.writers.Done()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, c.hub.closeMessage())
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleWebSocket upgrades the HTTP connection to WebSocket and registers the
+// client. Clients pass v=1 to receive framed messages (see wsEnvelope);
+// without it they receive bare arrays of logs. q= and the filter parameters
+// in liveQueryParams limit the stream to matching logs; clients change them
+// later by sending a wsSubscription. Connections over the tenant's limit get
+// a 429.
+func (s *server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	protocol := 0
+	if v := r.URL.Query().Get("v"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > wsProtocolVersion {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid protocol version",
+				fmt.Sprintf("'v' must be between 0 and %d, got: %s", wsProtocolVersion, v))
+			return
+		}
+		protocol = n
+	}
+	query, apiErr := s.newLiveQuery(r.URL.Query())
+	if apiErr != nil {
+		writeJSONError(w, http.StatusBadRequest, apiErr.Code, apiErr.Error, apiErr.Details)
+		return
+	}
+	var access models.LogFilter
+	if !s.restrictFilter(w, r, &access) {
+		return
+	}
+
+	name, limit := s.liveStreamTenant(r)
+	tenant, err := s.hub.admit(name, limit)
+	if errors.Is(err, errTenantLimit) {
+		writeJSONError(w, http.StatusTooManyRequests, "live_stream_limit", "Too many live streams",
+			fmt.Sprintf("at most %d concurrent live streams%s; close one first", limit, tenantSuffix(name)))
+		return
+	}
+	closing := err != nil
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		if !closing {
+			s.hub.release(tenant)
+		}
+		slog.Error("websocket upgrade failed", "error", err)
+		return
+	}
+	if closing {
+		conn.WriteControl(websocket.CloseMessage, s.hub.closeMessage(), time.Now().Add(writeWait))
+		conn.Close()
+		return
+	}
+
+	client := &wsClient{
+		hub:           s.hub,
+		tenant:        tenant,
+		conn:          conn,
+		send:          make(chan []byte, 256),
+		minLevel:      s.streamMinLevel(r),
+		hiddenStreams: access.ExcludeStreams,
+		services:      access.ServicePatterns,
+		protocol:      protocol,
+		query:         query,
+		parseQuery:    s.newLiveQuery,
+	}
+
+	s.hub.writers.Add(1)
+	select {
+	case s.hub.register <- client:
+	case <-s.hub.closing:
+		s.hub.writers.Done()
+		s.hub.release(tenant)
+		conn.WriteControl(websocket.CloseMessage, s.hub.closeMessage(), time.Now().Add(writeWait))
+		conn.Close()
+		return
+	}
+
+	go client.writePump()
+	go client.readPump()
+}
+
+// liveStreamTenant returns the hub partition a live stream request belongs
+// to and its connection limit: the first access policy it matches and that
+// policy's max_live_streams, or "" and live_stream.max_connections. Admins
+// get wsAdminTenant without a limit.
+func (s *server) liveStreamTenant(r *http.Request) (string, int) {
+	if token := requestToken(r); s.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1 {
+		return wsAdminTenant, 0
+	}
+	if p := s.access.policy(r); p != nil {
+		return p.Name, p.MaxLiveStreams
+	}
+	return "", s.liveMaxConnections
+}
+
+// tenantSuffix names a tenant in messages, if any.
+func tenantSuffix(name string) string {
+	if name == "" {
+		return ""
+	}
+	return " for " + name
+}
+
+// handleLiveStreams reports the live stream connections of each tenant.
+func (s *server) handleLiveStreams(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.hub.tenantStats())
+}
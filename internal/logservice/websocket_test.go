@@ -0,0 +1,572 @@
+package logservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"locog/internal/models"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+// newTestServerWithHub creates a test server with a running WebSocket hub.
+func newTestServerWithHub(t *testing.T) *server {
+	t.Helper()
+	hub := newWSHub()
+	go hub.run(t.Context())
+	return &server{
+		db:      newTestDB(t),
+		limiter: newIPRateLimiter(rate.Limit(100), 100),
+		hub:     hub,
+	}
+}
+
+// TestWebSocketConnect tests that a client can connect via WebSocket.
+func TestWebSocketConnect(t *testing.T) {
+	srv := newTestServerWithHub(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ws", srv.handleWebSocket)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws"
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("expected status %d, got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+
+	// Wait briefly for registration
+	time.Sleep(50 * time.Millisecond)
+
+	if srv.hub.clientCount() != 1 {
+		t.Errorf("expected 1 connected client, got %d", srv.hub.clientCount())
+	}
+}
+
+// TestWebSocketReceivesLogs tests that connected clients receive broadcast logs.
+func TestWebSocketReceivesLogs(t *testing.T) {
+	srv := newTestServerWithHub(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ws", srv.handleWebSocket)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// Wait for registration
+	time.Sleep(50 * time.Millisecond)
+
+	// Broadcast test logs
+	testLogs := []models.Log{
+		{
+			Timestamp: time.Now(),
+			Service:   "test-svc",
+			Level:     "INFO",
+			Message:   "websocket test message",
+			Host:      "ws-host",
+		},
+	}
+	srv.hub.broadcastLogs(testLogs)
+
+	// Read the message
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+
+	var receivedLogs []models.Log
+	if err := json.Unmarshal(message, &receivedLogs); err != nil {
+		t.Fatalf("failed to unmarshal message: %v", err)
+	}
+
+	if len(receivedLogs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(receivedLogs))
+	}
+	if receivedLogs[0].Service != "test-svc" {
+		t.Errorf("expected service 'test-svc', got '%s'", receivedLogs[0].Service)
+	}
+	if receivedLogs[0].Message != "websocket test message" {
+		t.Errorf("expected message 'websocket test message', got '%s'", receivedLogs[0].Message)
+	}
+}
+
+// TestWebSocketFramedMessages tests that v=1 clients receive logs in an
+// envelope while legacy clients keep receiving bare arrays.
+func TestWebSocketFramedMessages(t *testing.T) {
+	srv := newTestServerWithHub(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ws", srv.handleWebSocket)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws"
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL+"?v=9", nil); err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an unknown version to be rejected, got %v", err)
+	}
+
+	framed, _, err := websocket.DefaultDialer.Dial(wsURL+"?v=1", nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer framed.Close()
+	legacy, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer legacy.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	srv.hub.broadcastLogs([]models.Log{{Timestamp: time.Now(), Service: "svc", Level: "INFO", Message: "framed"}})
+
+	framed.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var envelope struct {
+		Type string       `json:"type"`
+		V    int          `json:"v"`
+		Data []models.Log `json:"data"`
+	}
+	if err := framed.ReadJSON(&envelope); err != nil {
+		t.Fatalf("failed to read framed message: %v", err)
+	}
+	if envelope.Type != "logs" || envelope.V != 1 || len(envelope.Data) != 1 || envelope.Data[0].Message != "framed" {
+		t.Errorf("unexpected envelope: %+v", envelope)
+	}
+
+	legacy.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var logs []models.Log
+	if err := legacy.ReadJSON(&logs); err != nil || len(logs) != 1 {
+		t.Errorf("expected a bare array for legacy clients, got %v (%v)", logs, err)
+	}
+}
+
+// TestWebSocketCoalescesBroadcasts tests that batches broadcast within the
+// flush interval reach clients as one message.
+func TestWebSocketCoalescesBroadcasts(t *testing.T) {
+	hub := newWSHub()
+	hub.flushInterval = 100 * time.Millisecond
+	go hub.run(t.Context())
+	srv := &server{db: newTestDB(t), hub: hub}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ws", srv.handleWebSocket)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/api/ws", nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	for i := range 3 {
+		srv.hub.broadcastLogs([]models.Log{{Timestamp: time.Now(), Service: "svc", Level: "INFO", Message: fmt.Sprint("burst ", i)}})
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var logs []models.Log
+	if err := conn.ReadJSON(&logs); err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+	if len(logs) != 3 || logs[0].Message != "burst 0" || logs[2].Message != "burst 2" {
+		t.Errorf("expected the burst in one message in order, got %+v", logs)
+	}
+}
+
+// TestWebSocketShutdown tests that stopping the hub delivers pending logs,
+// then closes clients with a going away close frame.
+func TestWebSocketShutdown(t *testing.T) {
+	hub := newWSHub()
+	hub.flushInterval = time.Hour
+	ctx, stop := context.WithCancel(t.Context())
+	go hub.run(ctx)
+	srv := &server{db: newTestDB(t), hub: hub}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ws", srv.handleWebSocket)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	hub.broadcastLogs([]models.Log{{Timestamp: time.Now(), Service: "svc", Level: "INFO", Message: "pending"}})
+	stop()
+	waitCtx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	if err := hub.shutdown(waitCtx); err != nil {
+		t.Fatalf("hub didn't stop: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var logs []models.Log
+	if err := conn.ReadJSON(&logs); err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+	if len(logs) != 1 || logs[0].Message != "pending" {
+		t.Errorf("expected the pending log to be flushed, got %+v", logs)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("expected a going away close frame, got %v", err)
+	}
+
+	// Connections after shutdown are closed immediately
+	late, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer late.Close()
+	late.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := late.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("expected a going away close frame after shutdown, got %v", err)
+	}
+}
+
+// TestWebSocketDisconnect tests that disconnected clients are cleaned up.
+func TestWebSocketDisconnect(t *testing.T) {
+	srv := newTestServerWithHub(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ws", srv.handleWebSocket)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	// Wait for registration
+	time.Sleep(50 * time.Millisecond)
+	if srv.hub.clientCount() != 1 {
+		t.Errorf("expected 1 connected client, got %d", srv.hub.clientCount())
+	}
+
+	// Close the connection
+	conn.Close()
+
+	// Wait for unregistration
+	time.Sleep(100 * time.Millisecond)
+	if srv.hub.clientCount() != 0 {
+		t.Errorf("expected 0 connected clients after disconnect, got %d", srv.hub.clientCount())
+	}
+}
+
+// TestWebSocketMultipleClients tests broadcasting to multiple clients.
+func TestWebSocketMultipleClients(t *testing.T) {
+	srv := newTestServerWithHub(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ws", srv.handleWebSocket)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws"
+
+	// Connect two clients
+	conn1, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("client 1 failed to connect: %v", err)
+	}
+	defer conn1.Close()
+
+	conn2, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("client 2 failed to connect: %v", err)
+	}
+	defer conn2.Close()
+
+	time.Sleep(50 * time.Millisecond)
+
+	if srv.hub.clientCount() != 2 {
+		t.Errorf("expected 2 connected clients, got %d", srv.hub.clientCount())
+	}
+
+	// Broadcast
+	testLogs := []models.Log{
+		{Timestamp: time.Now(), Service: "multi", Level: "INFO", Message: "broadcast test"},
+	}
+	srv.hub.broadcastLogs(testLogs)
+
+	// Both clients should receive the message
+	for i, conn := range []*websocket.Conn{conn1, conn2} {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Errorf("client %d failed to read: %v", i+1, err)
+			continue
+		}
+		var logs []models.Log
+		json.Unmarshal(msg, &logs)
+		if len(logs) != 1 || logs[0].Message != "broadcast test" {
+			t.Errorf("client %d received unexpected data", i+1)
+		}
+	}
+}
+
+// TestIngestBroadcastsViaWebSocket tests the full flow: ingest triggers WebSocket broadcast.
+func TestIngestBroadcastsViaWebSocket(t *testing.T) {
+	srv := newTestServerWithHub(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ws", srv.handleWebSocket)
+	mux.HandleFunc("/api/ingest", srv.handleIngest)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// Connect WebSocket client
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	time.Sleep(50 * time.Millisecond)
+
+	// Ingest a log via HTTP
+	logJSON := `{"service":"ws-test","level":"info","message":"realtime log"}`
+	resp, err := http.Post(ts.URL+"/api/ingest", "application/json", strings.NewReader(logJSON))
+	if err != nil {
+		t.Fatalf("ingest request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	// WebSocket client should receive the log
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("failed to read WebSocket message: %v", err)
+	}
+
+	var receivedLogs []models.Log
+	if err := json.Unmarshal(message, &receivedLogs); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+
+	if len(receivedLogs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(receivedLogs))
+	}
+	if receivedLogs[0].Service != "ws-test" {
+		t.Errorf("expected service 'ws-test', got '%s'", receivedLogs[0].Service)
+	}
+	if receivedLogs[0].Message != "realtime log" {
+		t.Errorf("expected message 'realtime log', got '%s'", receivedLogs[0].Message)
+	}
+}
+
+// TestWebSocketSubscribe tests that subscribe messages replace a client's
+// filters and that invalid ones are rejected.
+func TestWebSocketSubscribe(t *testing.T) {
+	srv := newTestServerWithHub(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ws", srv.handleWebSocket)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws?v=1&service=web"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	read := func() wsEnvelope {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var env wsEnvelope
+		if err := conn.ReadJSON(&env); err != nil {
+			t.Fatalf("failed to read message: %v", err)
+		}
+		return env
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscribe","filters":{"service":"api","level":["error","fatal"],"search":"TIMEOUT"}}`))
+	if env := read(); env.Type != wsTypeSubscribed || !strings.Contains(string(env.Data), `"service":["api"]`) {
+		t.Fatalf("expected a subscribed message, got %s %s", env.Type, env.Data)
+	}
+	srv.hub.broadcastLogs([]models.Log{
+		{Service: "web", Level: "error", Message: "timeout from the old filter"},
+		{Service: "api", Level: "info", Message: "timeout but quiet"},
+		{Service: "api", Level: "fatal", Message: "upstream timeout"},
+		{Service: "api", Level: "error", Message: "bad input"},
+	})
+	env := read()
+	var logs []models.Log
+	json.Unmarshal(env.Data, &logs)
+	if env.Type != wsTypeLogs || len(logs) != 1 || logs[0].Message != "upstream timeout" {
+		t.Errorf("expected only the api timeout, got %s %s", env.Type, env.Data)
+	}
+
+	for _, message := range []string{
+		`{"type":"subscribe","filters":{"colour":"red"}}`,
+		`{"type":"subscribe","filters":{"level>":"loud"}}`,
+		`{"type":"subscribe","filters":{"service":1}}`,
+		`{"type":"unsubscribe"}`,
+		`not json`,
+	} {
+		conn.WriteMessage(websocket.TextMessage, []byte(message))
+		if env := read(); env.Type != wsTypeError {
+			t.Errorf("expected an error for %s, got %s %s", message, env.Type, env.Data)
+		}
+	}
+
+	// Rejected messages leave the filters unchanged
+	srv.hub.broadcastLogs([]models.Log{
+		{Service: "web", Level: "error", Message: "timeout"},
+		{Service: "api", Level: "error", Message: "timeout again"},
+	})
+	env = read()
+	logs = nil
+	json.Unmarshal(env.Data, &logs)
+	if len(logs) != 1 || logs[0].Message != "timeout again" {
+		t.Errorf("expected the subscription to still apply, got %s", env.Data)
+	}
+
+	// An empty subscription streams everything
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscribe","filters":{}}`))
+	if env := read(); env.Type != wsTypeSubscribed {
+		t.Fatalf("expected a subscribed message, got %s %s", env.Type, env.Data)
+	}
+	srv.hub.broadcastLogs([]models.Log{{Service: "web", Level: "debug", Message: "anything"}})
+	env = read()
+	logs = nil
+	json.Unmarshal(env.Data, &logs)
+	if len(logs) != 1 || logs[0].Message != "anything" {
+		t.Errorf("expected every log after an empty subscription, got %s", env.Data)
+	}
+}
+
+// TestWebSocketSubscribe_Immediate tests that a subscribe message sent as
+// soon as the connection opens, possibly before the hub has registered the
+// client, is answered.
+func TestWebSocketSubscribe_Immediate(t *testing.T) {
+	srv := newTestServerWithHub(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ws", srv.handleWebSocket)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws?v=1"
+	for range 20 {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscribe","filters":{"service":"api"}}`))
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var env wsEnvelope
+		if err := conn.ReadJSON(&env); err != nil || env.Type != wsTypeSubscribed {
+			t.Fatalf("expected a subscribed message, got %s %s (%v)", env.Type, env.Data, err)
+		}
+		conn.Close()
+	}
+}
+
+// TestWebSocketTenants tests per tenant live stream limits, that admins
+// don't take unmatched callers' connections, and that a tenant's partition
+// only gets its own logs.
+func TestWebSocketTenants(t *testing.T) {
+	cfg, err := loadConfig(writeTestConfig(t, `{
+		"live_stream": {"max_connections": 1},
+		"access": {"policies": [{"name": "checkout", "tokens": ["team-a"], "services": ["checkout-*"], "max_live_streams": 1}]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServerWithHub(t)
+	srv.access, _ = cfg.access()
+	srv.liveMaxConnections = cfg.LiveStream.MaxConnections
+	srv.adminToken = "admin"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ws", srv.handleWebSocket)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	dial := func(token string) (*websocket.Conn, int) {
+		url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws?token=" + token
+		conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			return nil, resp.StatusCode
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn, resp.StatusCode
+	}
+
+	for range 3 {
+		if _, code := dial("admin"); code != http.StatusSwitchingProtocols {
+			t.Errorf("expected admins not limited, got %d", code)
+		}
+	}
+	team, _ := dial("team-a")
+	if _, code := dial("team-a"); code != http.StatusTooManyRequests {
+		t.Errorf("expected a second checkout stream refused, got %d", code)
+	}
+	if _, code := dial(""); code != http.StatusSwitchingProtocols {
+		t.Errorf("expected an unmatched stream accepted beside the admins', got %d", code)
+	}
+	if _, code := dial(""); code != http.StatusTooManyRequests {
+		t.Errorf("expected a second unmatched stream refused, got %d", code)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	srv.hub.broadcastLogs([]models.Log{{Service: "search", Level: "INFO", Message: "other"}, {Service: "checkout-api", Level: "INFO", Message: "mine"}})
+	team.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var logs []models.Log
+	if err := team.ReadJSON(&logs); err != nil || len(logs) != 1 || logs[0].Message != "mine" {
+		t.Errorf("expected only the checkout log, got %+v, %v", logs, err)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.handleLiveStreams(rr, httptest.NewRequest(http.MethodGet, "/api/admin/live-streams", nil))
+	var stats []models.LiveStreamTenant
+	json.NewDecoder(rr.Body).Decode(&stats)
+	want := []models.LiveStreamTenant{
+		{Tenant: "", Connections: 1, MaxConnections: 1, Rejected: 1},
+		{Tenant: wsAdminTenant, Connections: 3},
+		{Tenant: "checkout", Connections: 1, MaxConnections: 1, Rejected: 1},
+	}
+	if fmt.Sprint(stats) != fmt.Sprint(want) {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+
+	// Closing a stream frees its slot
+	team.Close()
+	time.Sleep(100 * time.Millisecond)
+	if _, code := dial("team-a"); code != http.StatusSwitchingProtocols {
+		t.Errorf("expected a checkout stream accepted after one closed, got %d", code)
+	}
+}
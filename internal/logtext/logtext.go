@@ -0,0 +1,104 @@
+// Package logtext renders logs as text with Go templates, for the server's
+// text exports and the CLI.
+package logtext
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"locog/internal/models"
+)
+
+// DefaultTemplate renders a log as a classic log file line.
+const DefaultTemplate = `{{.Timestamp}} [{{.Level}}] {{.Service}}: {{.Message}}`
+
+// MaxTemplateLength bounds templates passed as query parameters.
+const MaxTemplateLength = 2048
+
+// Time prints as an RFC 3339 timestamp with milliseconds but keeps
+// time.Time's methods, e.g. {{.Timestamp.Format "Jan _2 15:04:05"}}.
+type Time struct{ time.Time }
+
+func (t Time) String() string { return t.Format("2006-01-02T15:04:05.000Z07:00") }
+
+// Log is what templates render: the log's fields, with Timestamp as a Time.
+type Log struct {
+	models.Log
+	Timestamp Time
+}
+
+// Meta returns the metadata value at a dotted key, e.g. "http.status", as
+// text: strings as is, objects and arrays as JSON, and "" when absent.
+func Meta(metadata map[string]interface{}, key string) string {
+	var v interface{} = metadata
+	for _, part := range strings.Split(key, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = m[part]
+	}
+	return Value(v)
+}
+
+// Value formats a metadata value as Meta does.
+func Value(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(v)
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// funcs are the functions available to templates.
+var funcs = template.FuncMap{
+	"meta": func(l Log, key string) string { return Meta(l.Metadata, key) },
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// Parse parses a Go text/template for rendering logs, e.g.
+// `{{.Timestamp}} [{{.Level}}] {{.Service}}: {{.Message}} {{meta . "request_id"}}`.
+// An empty template selects DefaultTemplate.
+func Parse(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultTemplate
+	}
+	if len(text) > MaxTemplateLength {
+		return nil, fmt.Errorf("template is longer than %d bytes", MaxTemplateLength)
+	}
+	return template.New("log").Funcs(funcs).Parse(text)
+}
+
+// Write renders each log with tmpl, one per line.
+func Write(w io.Writer, tmpl *template.Template, logs []models.Log) error {
+	var buf bytes.Buffer
+	for _, l := range logs {
+		buf.Reset()
+		if err := tmpl.Execute(&buf, Log{Log: l, Timestamp: Time{l.Timestamp}}); err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteByte('\n')
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -11,6 +11,9 @@ type Log struct {
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
 	Host      string                 `json:"host"`
 	CreatedAt time.Time              `json:"created_at"`
+	Tags      []string               `json:"tags,omitempty"`
+	Source    string                 `json:"source,omitempty"` // instance name in federated queries
+	Stream    string                 `json:"stream,omitempty"` // logical stream chosen by routing rules
 }
 
 type LogFilter struct {
@@ -21,10 +24,537 @@ type LogFilter struct {
 	EndTime   *time.Time
 	Limit     int
 	Search    string // Optional: full-text search in message
+	Meta      []MetaFilter
+	Tag       string // Optional: only logs carrying this tag
+	SinceID   int64  // Optional: only logs with a greater ID, returned oldest first
+	Ascending bool   // Optional: return the oldest logs first rather than the newest
+
+	MaxID  int64      // Optional: only logs with an ID up to this, pinning reads to the logs stored when it was read
+	Cursor *LogCursor // Optional: only logs after this one in the result order, for the next page
+
+	OmitMetadata bool // Optional: don't read metadata, e.g. for list views
+
+	SearchRegex bool // Search is an RE2 regular expression rather than a substring
+
+	Levels        []string // Optional: only these levels, compared case-insensitively
+	ExcludeLevels []string // Optional: levels to leave out, compared case-insensitively
+
+	ExcludeServices []string // Optional: services to leave out
+	ExcludeHosts    []string // Optional: hosts to leave out
+	ExcludeSearch   []string // Optional: leave out messages containing any of these
+
+	HostPatterns    []string // Optional: only hosts matching any of these globs, e.g. a host group
+	ServicePatterns []string // Optional: only services matching any of these globs, e.g. those the caller may read
+
+	Stream         string   // Optional: only logs routed to this stream
+	ExcludeStreams []string // Optional: streams to leave out, e.g. those the caller may not read
+}
+
+// LogCursor is the position of a log in query order, timestamp then ID,
+// from which the next page of a query continues.
+type LogCursor struct {
+	Timestamp time.Time
+	ID        int64
+}
+
+// MetaFilter compares a metadata value, e.g. duration_ms > 500. Key may be a
+// dotted path into nested objects.
+type MetaFilter struct {
+	Key     string
+	Op      string // =, !=, >, >=, <, <=
+	Value   string
+	Numeric bool // compare as numbers; always true for ordering operators
+	Number  float64
+	Derived bool // Key names a server-defined derived field rather than metadata
 }
 
 type FilterOptions struct {
 	Services []string `json:"services"`
 	Levels   []string `json:"levels"`
 	Hosts    []string `json:"hosts"`
+
+	// HostGroups lists the named host groups usable as host_group=
+	HostGroups []string `json:"host_groups,omitempty"`
+
+	// DerivedFields lists server-defined derived fields usable in filters
+	DerivedFields []string `json:"derived_fields,omitempty"`
+}
+
+// LogContext is a log with its neighbors from the same service and host,
+// each list oldest first.
+type LogContext struct {
+	Log    Log   `json:"log"`
+	Before []Log `json:"before"`
+	After  []Log `json:"after"`
+}
+
+// Sparkline is a coarse histogram of matching logs over a time range.
+type Sparkline struct {
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	BucketSeconds float64   `json:"bucket_seconds"`
+	Counts        []int64   `json:"counts"`
+}
+
+// LogStats counts matching logs per time bucket, read from rollups.
+type LogStats struct {
+	BucketSeconds float64 `json:"bucket_seconds"`
+	GroupBy       string  `json:"group_by,omitempty"`
+	// Approximate is set when the rollups count a sample of the logs
+	Approximate bool `json:"approximate"`
+	// RolledUntil is where rollups end; later buckets aren't returned
+	RolledUntil *time.Time    `json:"rolled_until,omitempty"`
+	Buckets     []StatsBucket `json:"buckets"`
+}
+
+// StatsBucket is one bucket of LogStats, with counts per group value when
+// grouped.
+type StatsBucket struct {
+	Start  time.Time        `json:"start"`
+	Count  int64            `json:"count"`
+	Groups map[string]int64 `json:"groups,omitempty"`
+}
+
+// QueryResponse wraps query results when extra data is requested alongside
+// the logs (e.g. include_sparkline=true).
+type QueryResponse struct {
+	Logs      []Log      `json:"logs"`
+	Sparkline *Sparkline `json:"sparkline,omitempty"`
+}
+
+// AvailabilityReport is the share of each service's logs that aren't errors,
+// per day or week, for status pages.
+type AvailabilityReport struct {
+	Period   string                `json:"period"` // day or week
+	Start    time.Time             `json:"start"`
+	End      time.Time             `json:"end"`
+	Services []ServiceAvailability `json:"services"`
+}
+
+// ServiceAvailability is a service's availability over the whole report and
+// per bucket. Availability is null when there are no logs.
+type ServiceAvailability struct {
+	Service      string               `json:"service"`
+	Total        int64                `json:"total"`
+	Errors       int64                `json:"errors"`
+	Availability *float64             `json:"availability"`
+	Buckets      []AvailabilityBucket `json:"buckets"`
+}
+
+// AvailabilityBucket is one day or week of a ServiceAvailability.
+type AvailabilityBucket struct {
+	Start        time.Time `json:"start"`
+	Total        int64     `json:"total"`
+	Errors       int64     `json:"errors"`
+	Availability *float64  `json:"availability"`
+}
+
+// LogCount is the number of logs matching a filter. Capped is set when
+// counting stopped at the requested maximum.
+type LogCount struct {
+	Count  int64 `json:"count"`
+	Capped bool  `json:"capped,omitempty"`
+	// Pending is set while a total counted in the background isn't known yet
+	Pending bool `json:"pending,omitempty"`
+}
+
+// QueryEstimate is the expected cost of a log query, estimated from
+// statistics and the query plan without running it.
+type QueryEstimate struct {
+	EstimatedRows int64    `json:"estimated_rows"` // logs expected to match
+	ScannedRows   int64    `json:"scanned_rows"`   // logs read to find them, at most
+	TotalRows     int64    `json:"total_rows"`
+	Indexes       []string `json:"indexes"`   // indexes the plan uses
+	FullScan      bool     `json:"full_scan"` // every log is read
+	FTS           bool     `json:"fts"`       // a full-text index serves the search; none exists yet
+
+	// Unestimated lists filters without statistics (search, meta, stream).
+	// EstimatedRows ignores them, so it is an upper bound.
+	Unestimated []string `json:"unestimated,omitempty"`
+	Plan        []string `json:"plan"`
+}
+
+// PollResponse is returned by the long-poll endpoint. LastID is the ID to pass
+// as since_id in the next request.
+type PollResponse struct {
+	Logs   []Log `json:"logs"`
+	LastID int64 `json:"last_id"`
+}
+
+// Capabilities describes a deployment's build and which optional subsystems
+// are enabled, so clients can adapt to it.
+type Capabilities struct {
+	Version       string      `json:"version"`
+	Commit        string      `json:"commit,omitempty"`
+	GoVersion     string      `json:"go_version"`
+	SQLiteVersion string      `json:"sqlite_version,omitempty"` // empty with PostgreSQL storage
+	Subsystems    []Subsystem `json:"subsystems"`
+}
+
+// Subsystem is an optional feature. Version is the protocol or API version it
+// implements, where that applies.
+type Subsystem struct {
+	Name    string                 `json:"name"`
+	Enabled bool                   `json:"enabled"`
+	Version string                 `json:"version,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// MigrationStatus reports the progress of a startup data migration.
+type MigrationStatus struct {
+	Name       string     `json:"name"`
+	State      string     `json:"state"` // pending, running, done, failed
+	Processed  int64      `json:"processed"`
+	Total      int64      `json:"total"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// MaintenanceStatus reports the index maintenance that runs after deletes
+// removed a large share of the logs: the current run's progress, or the
+// last run's outcome.
+type MaintenanceStatus struct {
+	State      string            `json:"state"` // idle, running, done, failed
+	Reason     string            `json:"reason,omitempty"`
+	Step       string            `json:"step,omitempty"` // running now
+	StepsDone  int               `json:"steps_done"`
+	StepsTotal int               `json:"steps_total"`
+	Steps      []MaintenanceStep `json:"steps"` // finished steps of the run
+	StartedAt  *time.Time        `json:"started_at,omitempty"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+	DurationMs int64             `json:"duration_ms,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Runs       int64             `json:"runs"`
+	// DeletedSince is the logs deleted since the last run, or startup
+	DeletedSince int64 `json:"deleted_since"`
+}
+
+// MaintenanceStep is a finished step of a maintenance run, e.g. "reindex
+// idx_logs_timestamp".
+type MaintenanceStep struct {
+	Name       string `json:"name"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// ArchiveReport is the outcome of verifying a tag policy's archive of a tag
+// against its manifest and, on the server, against the logs recorded as
+// exported to it.
+type ArchiveReport struct {
+	Tag            string     `json:"tag"`
+	Dir            string     `json:"dir"`
+	File           string     `json:"file,omitempty"` // data file, relative to Dir
+	Format         string     `json:"format,omitempty"`
+	Status         string     `json:"status"` // ok, incomplete or corrupt
+	SchemaVersion  int        `json:"schema_version,omitempty"`
+	Count          int64      `json:"count"`
+	Bytes          int64      `json:"bytes"`
+	Chunks         int        `json:"chunks"`
+	FirstTimestamp *time.Time `json:"first_timestamp,omitempty"`
+	LastTimestamp  *time.Time `json:"last_timestamp,omitempty"`
+	// Legacy is set when part of the data file was written before it had a
+	// manifest, which leaves the time range, and for text the count, open
+	Legacy bool `json:"legacy,omitempty"`
+	// Exported and Pending are the stored logs exported for the tag and
+	// still waiting to be; only the server reports them
+	Exported *int64 `json:"exported,omitempty"`
+	Pending  *int64 `json:"pending,omitempty"`
+	// Held is set when cleanups keep the tag's logs past their retention
+	// until the archive verifies and has no pending logs
+	Held     bool     `json:"held"`
+	Problems []string `json:"problems,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// RetentionPreview reports the logs a retention cleanup would delete.
+type RetentionPreview struct {
+	Cutoff          time.Time        `json:"cutoff"`
+	Total           int64            `json:"total"`
+	KeptByTags      int64            `json:"kept_by_tags"`      // older than cutoff but kept by a tag policy
+	KeptByIncidents int64            `json:"kept_by_incidents"` // older than cutoff but kept for an incident
+	Groups          []RetentionGroup `json:"groups"`
+}
+
+// CleanupPreview reports what a cleanup run now would delete: logs per
+// service, most first, and rows of auxiliary tables with a retention.
+type CleanupPreview struct {
+	Cutoff   time.Time      `json:"cutoff"`
+	Total    int64          `json:"total"`
+	Services []CleanupCount `json:"services"`
+	Tables   []CleanupCount `json:"tables"`
+}
+
+// CleanupCount is the number of rows a cleanup would delete for a service
+// or table.
+type CleanupCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// TransformStats reports what an ingest transform did since startup.
+type TransformStats struct {
+	Name        string     `json:"name"`
+	Service     string     `json:"service,omitempty"`
+	Applied     int64      `json:"applied"` // logs the script ran on
+	Dropped     int64      `json:"dropped"`
+	Failed      int64      `json:"failed"`         // runs that errored or exceeded a limit
+	LimitErrors int64      `json:"limit_exceeded"` // failed runs that exceeded a limit
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// TransformTest is the outcome of running a transform script on a log with
+// /api/admin/transforms/test.
+type TransformTest struct {
+	Log     *Log   `json:"log,omitempty"` // the transformed log, unless dropped
+	Dropped bool   `json:"dropped"`
+	Steps   int    `json:"steps"`
+	Bytes   int    `json:"bytes"`
+	Error   string `json:"error,omitempty"`
+}
+
+// TableCleanup reports the retention cleanups of one table since startup.
+type TableCleanup struct {
+	Table          string    `json:"table"`
+	Retention      string    `json:"retention"`
+	LastRun        time.Time `json:"last_run"`
+	LastDeleted    int64     `json:"last_deleted"`
+	LastDurationMs int64     `json:"last_duration_ms"`
+	LastError      string    `json:"last_error,omitempty"`
+	TotalDeleted   int64     `json:"total_deleted"`
+	Runs           int64     `json:"runs"`
+}
+
+// RetentionSchedule is how long logs are kept and how often the cleanup
+// deleting older ones runs, as durations such as "30d" or "6h0m0s".
+type RetentionSchedule struct {
+	Retention       string     `json:"retention"`
+	CleanupInterval string     `json:"cleanup_interval"`
+	NextCleanup     *time.Time `json:"next_cleanup,omitempty"`
+}
+
+// RetentionGroup counts the logs of one stream, service and level a cleanup
+// would delete.
+type RetentionGroup struct {
+	Stream  string `json:"stream"`
+	Service string `json:"service"`
+	Level   string `json:"level"`
+	Count   int64  `json:"count"`
+}
+
+// MetricAggregate summarizes a numeric metadata field for one group and,
+// optionally, one time bucket.
+type MetricAggregate struct {
+	Group  string     `json:"group"`
+	Bucket *time.Time `json:"bucket,omitempty"`
+	Count  int64      `json:"count"`
+	Avg    float64    `json:"avg"`
+	Min    float64    `json:"min"`
+	Max    float64    `json:"max"`
+	P50    float64    `json:"p50"`
+	P95    float64    `json:"p95"`
+	P99    float64    `json:"p99"`
+}
+
+// GroupCount is the number of logs in one group and, optionally, one time
+// bucket.
+type GroupCount struct {
+	Group  string     `json:"group"`
+	Bucket *time.Time `json:"bucket,omitempty"`
+	Count  int64      `json:"count"`
+}
+
+// MetadataKeyStats describes one metadata key observed in a service's logs.
+// Nested keys are reported with dotted paths (e.g. http.status_code).
+type MetadataKeyStats struct {
+	Service   string           `json:"service"`
+	Key       string           `json:"key"`
+	Count     int64            `json:"count"`
+	Frequency float64          `json:"frequency"` // fraction of the service's logs carrying the key
+	Types     map[string]int64 `json:"types"`     // JSON type -> occurrences
+	Examples  []string         `json:"examples"`
+	LastSeen  time.Time        `json:"last_seen"`
+}
+
+// MetadataKeysReport is the latest metadata keys report.
+type MetadataKeysReport struct {
+	GeneratedAt *time.Time         `json:"generated_at"`
+	Keys        []MetadataKeyStats `json:"keys"`
+}
+
+// TemplateCount is the number of logs sharing a message template, with one
+// example message.
+type TemplateCount struct {
+	Template string `json:"template"`
+	Count    int64  `json:"count"`
+	Example  string `json:"example"`
+}
+
+// ErrorGroup is an error message template, identified by a hash of the
+// template that stays the same across deploys, with its occurrences per
+// version for logs that carry one.
+type ErrorGroup struct {
+	ID       string         `json:"id"`
+	Template string         `json:"template"`
+	Count    int64          `json:"count"`
+	Example  string         `json:"example"`
+	Versions []VersionCount `json:"versions,omitempty"`
+}
+
+// VersionCount is the number of occurrences of an error in one version.
+type VersionCount struct {
+	Version string `json:"version"`
+	Count   int64  `json:"count"`
+}
+
+// TopErrors lists the most frequent error templates in a time window.
+type TopErrors struct {
+	Start  time.Time    `json:"start"`
+	End    time.Time    `json:"end"`
+	Errors []ErrorGroup `json:"errors"`
+}
+
+// FilterDiff lists the message templates matched by only one of two filters
+// over the same time window.
+type FilterDiff struct {
+	Start  time.Time       `json:"start"`
+	End    time.Time       `json:"end"`
+	OnlyA  []TemplateCount `json:"only_a"`
+	OnlyB  []TemplateCount `json:"only_b"`
+	Shared int             `json:"shared"` // number of templates matched by both
+}
+
+// ArrivalStats describes how late a service's logs arrive relative to their
+// timestamps. Percentiles are upper bounds taken from a histogram.
+type ArrivalStats struct {
+	Service            string           `json:"service"`
+	Since              time.Time        `json:"since"`
+	Count              int64            `json:"count"`
+	OutOfOrder         int64            `json:"out_of_order"`
+	Future             int64            `json:"future"`
+	AvgLatenessSeconds float64          `json:"avg_lateness_seconds"`
+	MaxLatenessSeconds float64          `json:"max_lateness_seconds"`
+	P50Seconds         float64          `json:"p50_seconds"`
+	P95Seconds         float64          `json:"p95_seconds"`
+	P99Seconds         float64          `json:"p99_seconds"`
+	Buckets            []LatenessBucket `json:"buckets"`
+}
+
+// LatenessBucket counts logs that arrived at most LESeconds late. The final
+// bucket has LESeconds -1 and counts everything later.
+type LatenessBucket struct {
+	LESeconds float64 `json:"le_seconds"`
+	Count     int64   `json:"count"`
+}
+
+// Source is a host and service that ships logs, with when its logs or
+// heartbeats last arrived. Heartbeats that name no service have Service "".
+type Source struct {
+	Host            string     `json:"host"`
+	Service         string     `json:"service"`
+	Logs            int64      `json:"logs"`
+	LastLogAt       *time.Time `json:"last_log_at,omitempty"`
+	LastHeartbeatAt *time.Time `json:"last_heartbeat_at,omitempty"`
+	LastSeen        time.Time  `json:"last_seen"`
+	QuietSeconds    float64    `json:"quiet_seconds"` // since LastSeen
+}
+
+// SourceHealth compares a registered source's recent log volume with what
+// it is expected to send.
+type SourceHealth struct {
+	Service       string     `json:"service"`
+	Host          string     `json:"host,omitempty"` // empty for any host
+	WindowSeconds float64    `json:"window_seconds"`
+	Min           int64      `json:"min"`
+	Max           int64      `json:"max,omitempty"` // 0 for no upper bound
+	Count         int64      `json:"count"`         // logs within the window
+	LastLogAt     *time.Time `json:"last_log_at,omitempty"`
+	Status        string     `json:"status"` // ok, silent, low or high
+}
+
+// AlertStatus is the current state of an alert rule.
+type AlertStatus struct {
+	Name      string `json:"name"`
+	State     string `json:"state"` // pending, ok, firing, error
+	Condition string `json:"condition"`
+	Threshold int64  `json:"threshold"`
+	Value     int64  `json:"value"`
+	// AbsentSeconds is set for absence rules, whose value is the seconds
+	// since matching logs last arrived.
+	AbsentSeconds float64    `json:"absent_seconds,omitempty"`
+	WindowStart   *time.Time `json:"window_start,omitempty"`
+	WindowEnd     *time.Time `json:"window_end,omitempty"` // the watermark
+	DelaySeconds  float64    `json:"delay_seconds"`
+	LastEvaluated *time.Time `json:"last_evaluated,omitempty"`
+	Since         *time.Time `json:"since,omitempty"` // when the current state began
+	Error         string     `json:"error,omitempty"`
+}
+
+// AlertBacktest reports when a rule would have fired over stored logs.
+type AlertBacktest struct {
+	Name              string        `json:"name"`
+	Condition         string        `json:"condition"`
+	Threshold         int64         `json:"threshold"`
+	Start             time.Time     `json:"start"`
+	End               time.Time     `json:"end"`
+	Evaluations       int           `json:"evaluations"`
+	FiringEvaluations int           `json:"firing_evaluations"`
+	Firings           []AlertFiring `json:"firings"`
+}
+
+// AlertFiring is a run of consecutive evaluations that breached the rule.
+type AlertFiring struct {
+	Start time.Time `json:"start"` // first breaching evaluation
+	End   time.Time `json:"end"`   // last breaching evaluation
+	Peak  int64     `json:"peak"`  // most extreme value counted
+}
+
+// Incident is an incident mode window: until EndsAt the Services' logs are
+// stored without sampling and under raised rate limits, and the logs they
+// sent from StartedAt to EndsAt are kept until KeepUntil.
+type Incident struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Services  []string  `json:"services"`
+	StartedAt time.Time `json:"started_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	KeepUntil time.Time `json:"keep_until"`
+	Active    bool      `json:"active"` // set when listed
+}
+
+// VaultReveal is an audit record of redacted values revealed from the vault.
+type VaultReveal struct {
+	ID         int64     `json:"id"`
+	RevealedAt time.Time `json:"revealed_at"`
+	Client     string    `json:"client"` // IP address of the caller
+	Reason     string    `json:"reason"`
+	Tokens     []string  `json:"tokens"`
+}
+
+// LiveStreamTenant reports the WebSocket live streams of one tenant: the
+// access policy its callers matched first, or "" for other callers.
+type LiveStreamTenant struct {
+	Tenant         string `json:"tenant"`
+	Connections    int    `json:"connections"`
+	MaxConnections int    `json:"max_connections"` // 0 is unlimited
+	Rejected       int64  `json:"rejected"`        // connections refused over the limit
+	DroppedBatches int64  `json:"dropped_batches"` // broadcasts missed while behind
+}
+
+// QuotaUsage reports what an ingest quota's token, or one service under a
+// service quota, ingested today (UTC).
+type QuotaUsage struct {
+	Quota   string `json:"quota"`
+	Scope   string `json:"scope"`             // "token" or "service"
+	Service string `json:"service,omitempty"` // for scope service
+	Day     string `json:"day"`
+
+	EventsPerSecond float64  `json:"events_per_second,omitempty"` // 0 is unlimited
+	Burst           int      `json:"burst,omitempty"`
+	EventsAvailable *float64 `json:"events_available,omitempty"` // events a request may send now
+	BytesPerDay     int64    `json:"bytes_per_day,omitempty"`    // 0 is unlimited
+
+	EventsToday   int64 `json:"events_today"`
+	BytesToday    int64 `json:"bytes_today"`
+	RejectedToday int64 `json:"rejected_today"` // requests refused
 }
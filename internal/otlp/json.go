@@ -0,0 +1,167 @@
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DecodeJSON parses an OTLP/JSON ExportLogsServiceRequest.
+func DecodeJSON(data []byte) (*Request, error) {
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("invalid OTLP/JSON: %w", err)
+	}
+	return &req, nil
+}
+
+// jsonInt64 accepts 64-bit integers encoded as JSON numbers or, as OTLP/JSON
+// requires, as decimal strings.
+type jsonInt64 int64
+
+func (n *jsonInt64) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		return nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer %s", data)
+	}
+	*n = jsonInt64(v)
+	return nil
+}
+
+// jsonUint64 is the unsigned counterpart of jsonInt64, used for timestamps.
+type jsonUint64 uint64
+
+func (n *jsonUint64) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		return nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %s", data)
+	}
+	*n = jsonUint64(v)
+	return nil
+}
+
+// severityLevels are the bases of the SeverityNumber enum names.
+var severityLevels = map[string]int32{"TRACE": 1, "DEBUG": 5, "INFO": 9, "WARN": 13, "ERROR": 17, "FATAL": 21}
+
+// jsonSeverity accepts a severity number or its enum name, e.g.
+// SEVERITY_NUMBER_WARN2.
+type jsonSeverity int32
+
+func (n *jsonSeverity) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] != '"' {
+		var v int32
+		if err := json.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("invalid severityNumber %s", data)
+		}
+		*n = jsonSeverity(v)
+		return nil
+	}
+
+	name := strings.TrimPrefix(strings.Trim(string(data), `"`), "SEVERITY_NUMBER_")
+	if name == "UNSPECIFIED" {
+		*n = 0
+		return nil
+	}
+	offset := int32(0)
+	if last := name[max(len(name)-1, 0):]; last >= "2" && last <= "4" {
+		offset = int32(last[0] - '1')
+		name = name[:len(name)-1]
+	}
+	base, ok := severityLevels[name]
+	if !ok {
+		return fmt.Errorf("invalid severityNumber %s", data)
+	}
+	*n = jsonSeverity(base + offset)
+	return nil
+}
+
+func (rec *LogRecord) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		TimeUnixNano         jsonUint64   `json:"timeUnixNano"`
+		ObservedTimeUnixNano jsonUint64   `json:"observedTimeUnixNano"`
+		SeverityNumber       jsonSeverity `json:"severityNumber"`
+		SeverityText         string       `json:"severityText"`
+		Body                 AnyValue     `json:"body"`
+		Attributes           []KeyValue   `json:"attributes"`
+		TraceID              string       `json:"traceId"`
+		SpanID               string       `json:"spanId"`
+		EventName            string       `json:"eventName"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*rec = LogRecord{
+		TimeUnixNano:         uint64(raw.TimeUnixNano),
+		ObservedTimeUnixNano: uint64(raw.ObservedTimeUnixNano),
+		SeverityNumber:       int32(raw.SeverityNumber),
+		SeverityText:         raw.SeverityText,
+		Body:                 raw.Body,
+		Attributes:           raw.Attributes,
+		TraceID:              strings.ToLower(raw.TraceID),
+		SpanID:               strings.ToLower(raw.SpanID),
+		EventName:            raw.EventName,
+	}
+	return nil
+}
+
+func (v *AnyValue) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		v.Value = nil
+		return nil
+	}
+
+	var raw struct {
+		StringValue *string    `json:"stringValue"`
+		BoolValue   *bool      `json:"boolValue"`
+		IntValue    *jsonInt64 `json:"intValue"`
+		DoubleValue *float64   `json:"doubleValue"`
+		BytesValue  *string    `json:"bytesValue"` // base64, kept as is
+		ArrayValue  *struct {
+			Values []AnyValue `json:"values"`
+		} `json:"arrayValue"`
+		KvlistValue *struct {
+			Values []KeyValue `json:"values"`
+		} `json:"kvlistValue"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	switch {
+	case raw.StringValue != nil:
+		v.Value = *raw.StringValue
+	case raw.BoolValue != nil:
+		v.Value = *raw.BoolValue
+	case raw.IntValue != nil:
+		v.Value = int64(*raw.IntValue)
+	case raw.DoubleValue != nil:
+		v.Value = *raw.DoubleValue
+	case raw.BytesValue != nil:
+		v.Value = *raw.BytesValue
+	case raw.ArrayValue != nil:
+		values := make([]interface{}, len(raw.ArrayValue.Values))
+		for i, av := range raw.ArrayValue.Values {
+			values[i] = av.Value
+		}
+		v.Value = values
+	case raw.KvlistValue != nil:
+		values := make(map[string]interface{}, len(raw.KvlistValue.Values))
+		for _, kv := range raw.KvlistValue.Values {
+			values[kv.Key] = kv.Value.Value
+		}
+		v.Value = values
+	default:
+		v.Value = nil
+	}
+	return nil
+}
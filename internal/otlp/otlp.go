@@ -0,0 +1,249 @@
+// Package otlp decodes OpenTelemetry Protocol (OTLP) log export requests and
+// converts them into locog logs.
+//
+// Both OTLP encodings are supported: protobuf (used by OTLP/gRPC and by
+// default over OTLP/HTTP) and OTLP/JSON. Protobuf is decoded directly from the
+// wire format for the handful of messages involved, so no generated code or
+// protobuf runtime is needed.
+package otlp
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"locog/internal/models"
+)
+
+// Resource attribute keys mapped onto log fields rather than metadata.
+const (
+	serviceNameKey = "service.name"
+	hostNameKey    = "host.name"
+)
+
+// DefaultServiceName is used for resources without a service.name attribute,
+// as the OpenTelemetry SDKs do.
+const DefaultServiceName = "unknown_service"
+
+// Request is an ExportLogsServiceRequest.
+type Request struct {
+	ResourceLogs []ResourceLogs `json:"resourceLogs"`
+}
+
+// ResourceLogs holds the logs of one resource (typically one process).
+type ResourceLogs struct {
+	Resource  Resource    `json:"resource"`
+	ScopeLogs []ScopeLogs `json:"scopeLogs"`
+}
+
+// Resource describes the entity producing logs.
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+// ScopeLogs holds the logs produced by one instrumentation scope.
+type ScopeLogs struct {
+	Scope      Scope       `json:"scope"`
+	LogRecords []LogRecord `json:"logRecords"`
+}
+
+// Scope is the instrumentation scope (usually the logger name).
+type Scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// LogRecord is a single OTLP log record. TraceID and SpanID are hex encoded.
+type LogRecord struct {
+	TimeUnixNano         uint64
+	ObservedTimeUnixNano uint64
+	SeverityNumber       int32
+	SeverityText         string
+	Body                 AnyValue
+	Attributes           []KeyValue
+	TraceID              string
+	SpanID               string
+	EventName            string
+}
+
+// KeyValue is an attribute.
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue holds an attribute or body value as a plain Go value: string,
+// bool, int64, float64, []interface{}, map[string]interface{}, or nil when
+// empty. Bytes values are base64 encoded strings.
+type AnyValue struct {
+	Value interface{}
+}
+
+// Logs converts the request into logs. Resource attributes service.name and
+// host.name become the service and host; all other resource, scope and record
+// attributes go into metadata. Dotted attribute names are nested, so
+// http.status_code can be filtered as meta=http.status_code>=500. Records
+// without a timestamp get a zero Timestamp, left for the caller to fill in.
+func (r *Request) Logs() []models.Log {
+	var logs []models.Log
+	for _, rl := range r.ResourceLogs {
+		service, host := DefaultServiceName, ""
+		var resourceAttrs []KeyValue
+		for _, kv := range rl.Resource.Attributes {
+			switch s, ok := kv.Value.Value.(string); {
+			case kv.Key == serviceNameKey && ok && s != "":
+				service = s
+			case kv.Key == hostNameKey && ok:
+				host = s
+			default:
+				resourceAttrs = append(resourceAttrs, kv)
+			}
+		}
+
+		for _, sl := range rl.ScopeLogs {
+			for _, rec := range sl.LogRecords {
+				metadata := make(map[string]interface{})
+				// Each record gets its own copy of array and map values, which
+				// are later modified in place per log
+				for _, kv := range resourceAttrs {
+					setAttribute(metadata, kv.Key, cloneValue(kv.Value.Value))
+				}
+				if sl.Scope.Name != "" {
+					setAttribute(metadata, "otel.scope.name", sl.Scope.Name)
+				}
+				if sl.Scope.Version != "" {
+					setAttribute(metadata, "otel.scope.version", sl.Scope.Version)
+				}
+				for _, kv := range rec.Attributes {
+					setAttribute(metadata, kv.Key, kv.Value.Value)
+				}
+				if rec.TraceID != "" {
+					metadata["trace_id"] = rec.TraceID
+				}
+				if rec.SpanID != "" {
+					metadata["span_id"] = rec.SpanID
+				}
+				if rec.EventName != "" {
+					metadata["event_name"] = rec.EventName
+				}
+				if len(metadata) == 0 {
+					metadata = nil
+				}
+
+				logs = append(logs, models.Log{
+					Timestamp: rec.timestamp(),
+					Service:   service,
+					Level:     rec.level(),
+					Message:   bodyMessage(rec.Body.Value),
+					Metadata:  metadata,
+					Host:      host,
+				})
+			}
+		}
+	}
+	return logs
+}
+
+func (rec LogRecord) timestamp() time.Time {
+	switch {
+	case rec.TimeUnixNano > 0:
+		return time.Unix(0, int64(rec.TimeUnixNano)).UTC()
+	case rec.ObservedTimeUnixNano > 0:
+		return time.Unix(0, int64(rec.ObservedTimeUnixNano)).UTC()
+	}
+	return time.Time{}
+}
+
+// level prefers the severity text set by the SDK and falls back to the
+// severity number's range.
+func (rec LogRecord) level() string {
+	if rec.SeverityText != "" {
+		return rec.SeverityText
+	}
+	switch n := rec.SeverityNumber; {
+	case n >= 1 && n <= 4:
+		return "TRACE"
+	case n >= 5 && n <= 8:
+		return "DEBUG"
+	case n >= 13 && n <= 16:
+		return "WARN"
+	case n >= 17 && n <= 20:
+		return "ERROR"
+	case n >= 21 && n <= 24:
+		return "FATAL"
+	}
+	return "INFO"
+}
+
+// bodyMessage renders the log body as the message. Structured bodies are
+// serialized as JSON.
+func bodyMessage(v interface{}) string {
+	switch b := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return b
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// cloneValue deep copies array and map attribute values.
+func cloneValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, e := range v {
+			c[i] = cloneValue(e)
+		}
+		return c
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			c[k] = cloneValue(e)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// setAttribute stores an attribute under its dotted path, e.g. http.method
+// becomes {"http": {"method": ...}}. When the path collides with an existing
+// value of a different shape the attribute is stored flat under its full name
+// instead. Later attributes replace earlier ones with the same name.
+func setAttribute(metadata map[string]interface{}, key string, value interface{}) {
+	parts := strings.Split(key, ".")
+	if len(parts) == 1 || slices.Contains(parts, "") {
+		metadata[key] = value
+		return
+	}
+
+	m := metadata
+	for _, p := range parts[:len(parts)-1] {
+		next, exists := m[p]
+		if !exists {
+			child := make(map[string]interface{})
+			m[p] = child
+			m = child
+			continue
+		}
+		child, ok := next.(map[string]interface{})
+		if !ok {
+			metadata[key] = value
+			return
+		}
+		m = child
+	}
+
+	leaf := parts[len(parts)-1]
+	if _, isObject := m[leaf].(map[string]interface{}); isObject {
+		metadata[key] = value
+		return
+	}
+	m[leaf] = value
+}
@@ -0,0 +1,189 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+const exportJSON = `{
+  "resourceLogs": [{
+    "resource": {"attributes": [
+      {"key": "service.name", "value": {"stringValue": "checkout"}},
+      {"key": "host.name", "value": {"stringValue": "web-1"}},
+      {"key": "deployment.environment", "value": {"stringValue": "prod"}}
+    ]},
+    "scopeLogs": [{
+      "scope": {"name": "checkout.handler"},
+      "logRecords": [{
+        "timeUnixNano": "1736942400000000000",
+        "severityNumber": 17,
+        "body": {"stringValue": "payment failed"},
+        "attributes": [
+          {"key": "http.status_code", "value": {"intValue": "502"}},
+          {"key": "http.method", "value": {"stringValue": "POST"}},
+          {"key": "retry", "value": {"boolValue": true}}
+        ],
+        "traceId": "5B8EFFF798038103D269B633813FC60C",
+        "spanId": "EEE19B7EC3C1B174"
+      }, {
+        "observedTimeUnixNano": 1736942401000000000,
+        "severityNumber": "SEVERITY_NUMBER_WARN2",
+        "body": {"kvlistValue": {"values": [{"key": "event", "value": {"stringValue": "slow"}}]}}
+      }]
+    }]
+  }]
+}`
+
+func TestDecodeJSON_Logs(t *testing.T) {
+	req, err := DecodeJSON([]byte(exportJSON))
+	if err != nil {
+		t.Fatalf("DecodeJSON failed: %v", err)
+	}
+	logs := req.Logs()
+	if len(logs) != 2 {
+		t.Fatalf("expected 2 logs, got %d", len(logs))
+	}
+
+	l := logs[0]
+	if l.Service != "checkout" || l.Host != "web-1" || l.Level != "ERROR" || l.Message != "payment failed" {
+		t.Errorf("unexpected log fields: %+v", l)
+	}
+	if want := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC); !l.Timestamp.Equal(want) {
+		t.Errorf("expected timestamp %v, got %v", want, l.Timestamp)
+	}
+	http, ok := l.Metadata["http"].(map[string]interface{})
+	if !ok || http["status_code"] != int64(502) || http["method"] != "POST" {
+		t.Errorf("expected nested http attributes, got %v", l.Metadata["http"])
+	}
+	if l.Metadata["trace_id"] != "5b8efff798038103d269b633813fc60c" || l.Metadata["retry"] != true {
+		t.Errorf("unexpected metadata: %v", l.Metadata)
+	}
+	if _, ok := l.Metadata["service"]; ok {
+		t.Errorf("service.name should not be copied into metadata: %v", l.Metadata)
+	}
+	env := l.Metadata["deployment"].(map[string]interface{})["environment"]
+	if env != "prod" {
+		t.Errorf("expected resource attribute in metadata, got %v", env)
+	}
+
+	l = logs[1]
+	if l.Level != "WARN" || l.Message != `{"event":"slow"}` {
+		t.Errorf("unexpected second log: %+v", l)
+	}
+	if l.Timestamp.Unix() != 1736942401 {
+		t.Errorf("expected observed time fallback, got %v", l.Timestamp)
+	}
+}
+
+func TestLogs_Defaults(t *testing.T) {
+	req := &Request{ResourceLogs: []ResourceLogs{{
+		ScopeLogs: []ScopeLogs{{LogRecords: []LogRecord{{Body: AnyValue{"hello"}}}}},
+	}}}
+	logs := req.Logs()
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(logs))
+	}
+	if logs[0].Service != DefaultServiceName || logs[0].Level != "INFO" || !logs[0].Timestamp.IsZero() || logs[0].Metadata != nil {
+		t.Errorf("unexpected defaults: %+v", logs[0])
+	}
+}
+
+func TestLogs_ResourceAttributesNotShared(t *testing.T) {
+	req := &Request{ResourceLogs: []ResourceLogs{{
+		Resource: Resource{Attributes: []KeyValue{{Key: "k8s", Value: AnyValue{map[string]interface{}{"pod": "p-1"}}}}},
+		ScopeLogs: []ScopeLogs{{LogRecords: []LogRecord{
+			{Body: AnyValue{"a"}, Attributes: []KeyValue{{Key: "k8s.container", Value: AnyValue{"c-1"}}}},
+			{Body: AnyValue{"b"}},
+		}}},
+	}}}
+	logs := req.Logs()
+	if _, leaked := logs[1].Metadata["k8s"].(map[string]interface{})["container"]; leaked {
+		t.Errorf("expected record attributes to stay on their record, got %v", logs[1].Metadata)
+	}
+}
+
+func TestSetAttribute_Collision(t *testing.T) {
+	m := map[string]interface{}{}
+	setAttribute(m, "http", "plain")
+	setAttribute(m, "http.method", "GET")
+	setAttribute(m, "db.system", "sqlite")
+	setAttribute(m, "db.system.name", "other")
+
+	if m["http"] != "plain" || m["http.method"] != "GET" {
+		t.Errorf("expected flat key when parent is not an object, got %v", m)
+	}
+	if m["db"].(map[string]interface{})["system"] != "sqlite" || m["db.system.name"] != "other" {
+		t.Errorf("expected flat key when a parent is a scalar, got %v", m)
+	}
+}
+
+// pbField encodes a length-delimited field.
+func pbField(field int, value []byte) []byte {
+	return appendField(nil, field, value)
+}
+
+func pbString(field int, s string) []byte {
+	return pbField(field, []byte(s))
+}
+
+func pbAttr(key string, value []byte) []byte {
+	return pbField(1, append(pbString(1, key), pbField(2, value)...))
+}
+
+func TestDecodeProto(t *testing.T) {
+	var record []byte
+	record = binary.AppendUvarint(record, 1<<3|wireFixed64)
+	record = binary.LittleEndian.AppendUint64(record, 1736942400000000000)
+	record = appendVarintField(record, 2, 9)
+	record = append(record, pbField(5, pbString(1, "user logged in"))...)
+	record = append(record, pbField(6, append(pbString(1, "user.id"), pbField(2, appendVarintField(nil, 3, 42))...))...)
+	double := binary.AppendUvarint(nil, 4<<3|wireFixed64)
+	double = binary.LittleEndian.AppendUint64(double, math.Float64bits(1.5))
+	record = append(record, pbField(6, append(pbString(1, "ratio"), pbField(2, double)...))...)
+	record = append(record, pbField(9, []byte{0xab, 0xcd})...)
+	record = appendVarintField(record, 99, 7) // unknown fields are skipped
+
+	resource := pbAttr("service.name", pbString(1, "auth"))
+	scopeLogs := append(pbField(1, pbString(1, "auth.login")), pbField(2, record)...)
+	resourceLogs := append(pbField(1, resource), pbField(2, scopeLogs)...)
+	data := pbField(1, resourceLogs)
+
+	req, err := DecodeProto(data)
+	if err != nil {
+		t.Fatalf("DecodeProto failed: %v", err)
+	}
+	logs := req.Logs()
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(logs))
+	}
+	l := logs[0]
+	if l.Service != "auth" || l.Level != "INFO" || l.Message != "user logged in" || l.Timestamp.Unix() != 1736942400 {
+		t.Errorf("unexpected log: %+v", l)
+	}
+	if l.Metadata["user"].(map[string]interface{})["id"] != int64(42) || l.Metadata["ratio"] != 1.5 {
+		t.Errorf("unexpected attributes: %v", l.Metadata)
+	}
+	if l.Metadata["trace_id"] != "abcd" {
+		t.Errorf("expected hex trace id, got %v", l.Metadata["trace_id"])
+	}
+	if l.Metadata["otel"].(map[string]interface{})["scope"].(map[string]interface{})["name"] != "auth.login" {
+		t.Errorf("expected scope name, got %v", l.Metadata["otel"])
+	}
+
+	if _, err := DecodeProto(data[:len(data)-3]); err == nil {
+		t.Error("expected error for truncated message")
+	}
+}
+
+func TestEncodeResponse(t *testing.T) {
+	if got := EncodeResponse(0, ""); len(got) != 0 {
+		t.Errorf("expected empty response, got %x", got)
+	}
+	got := EncodeResponse(2, "x")
+	want := []byte{0x0a, 0x05, 0x08, 0x02, 0x12, 0x01, 'x'}
+	if string(got) != string(want) {
+		t.Errorf("expected %x, got %x", want, got)
+	}
+}
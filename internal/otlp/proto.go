@@ -0,0 +1,390 @@
+package otlp
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated message")
+
+// protoReader iterates over the fields of one protobuf message.
+type protoReader struct {
+	b []byte
+}
+
+// next returns the next field number and wire type, or false at the end.
+func (r *protoReader) next() (int, int, bool, error) {
+	if len(r.b) == 0 {
+		return 0, 0, false, nil
+	}
+	key, err := r.varint()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	field, wire := int(key>>3), int(key&7)
+	if field == 0 {
+		return 0, 0, false, errors.New("invalid field number 0")
+	}
+	return field, wire, true, nil
+}
+
+func (r *protoReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.b = r.b[n:]
+	return v, nil
+}
+
+func (r *protoReader) fixed64() (uint64, error) {
+	if len(r.b) < 8 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return v, nil
+}
+
+func (r *protoReader) bytes() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.b)) {
+		return nil, errTruncated
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v, nil
+}
+
+// skip discards a field of the given wire type.
+func (r *protoReader) skip(wire int) error {
+	var err error
+	switch wire {
+	case wireVarint:
+		_, err = r.varint()
+	case wireFixed64:
+		_, err = r.fixed64()
+	case wireBytes:
+		_, err = r.bytes()
+	case wireFixed32:
+		if len(r.b) < 4 {
+			return errTruncated
+		}
+		r.b = r.b[4:]
+	default:
+		return fmt.Errorf("unsupported wire type %d", wire)
+	}
+	return err
+}
+
+// fields calls fn for every field of the message in b. fn must consume the
+// field's value or return handled=false to have it skipped.
+func fields(b []byte, fn func(r *protoReader, field, wire int) (bool, error)) error {
+	r := &protoReader{b: b}
+	for {
+		field, wire, ok, err := r.next()
+		if err != nil || !ok {
+			return err
+		}
+		handled, err := fn(r, field, wire)
+		if err != nil {
+			return err
+		}
+		if !handled {
+			if err := r.skip(wire); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// DecodeProto parses a protobuf-encoded ExportLogsServiceRequest.
+func DecodeProto(data []byte) (*Request, error) {
+	var req Request
+	err := fields(data, func(r *protoReader, field, wire int) (bool, error) {
+		if field != 1 || wire != wireBytes {
+			return false, nil
+		}
+		b, err := r.bytes()
+		if err != nil {
+			return true, err
+		}
+		rl, err := decodeResourceLogs(b)
+		req.ResourceLogs = append(req.ResourceLogs, rl)
+		return true, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP protobuf: %w", err)
+	}
+	return &req, nil
+}
+
+func decodeResourceLogs(data []byte) (ResourceLogs, error) {
+	var rl ResourceLogs
+	err := fields(data, func(r *protoReader, field, wire int) (bool, error) {
+		if wire != wireBytes || (field != 1 && field != 2) {
+			return false, nil
+		}
+		b, err := r.bytes()
+		if err != nil {
+			return true, err
+		}
+		if field == 1 {
+			rl.Resource, err = decodeResource(b)
+		} else {
+			var sl ScopeLogs
+			sl, err = decodeScopeLogs(b)
+			rl.ScopeLogs = append(rl.ScopeLogs, sl)
+		}
+		return true, err
+	})
+	return rl, err
+}
+
+func decodeResource(data []byte) (Resource, error) {
+	var res Resource
+	err := fields(data, func(r *protoReader, field, wire int) (bool, error) {
+		if field != 1 || wire != wireBytes {
+			return false, nil
+		}
+		b, err := r.bytes()
+		if err != nil {
+			return true, err
+		}
+		kv, err := decodeKeyValue(b)
+		res.Attributes = append(res.Attributes, kv)
+		return true, err
+	})
+	return res, err
+}
+
+func decodeScopeLogs(data []byte) (ScopeLogs, error) {
+	var sl ScopeLogs
+	err := fields(data, func(r *protoReader, field, wire int) (bool, error) {
+		if wire != wireBytes || (field != 1 && field != 2) {
+			return false, nil
+		}
+		b, err := r.bytes()
+		if err != nil {
+			return true, err
+		}
+		if field == 1 {
+			sl.Scope, err = decodeScope(b)
+		} else {
+			var rec LogRecord
+			rec, err = decodeLogRecord(b)
+			sl.LogRecords = append(sl.LogRecords, rec)
+		}
+		return true, err
+	})
+	return sl, err
+}
+
+func decodeScope(data []byte) (Scope, error) {
+	var s Scope
+	err := fields(data, func(r *protoReader, field, wire int) (bool, error) {
+		if wire != wireBytes || (field != 1 && field != 2) {
+			return false, nil
+		}
+		b, err := r.bytes()
+		if field == 1 {
+			s.Name = string(b)
+		} else {
+			s.Version = string(b)
+		}
+		return true, err
+	})
+	return s, err
+}
+
+func decodeLogRecord(data []byte) (LogRecord, error) {
+	var rec LogRecord
+	err := fields(data, func(r *protoReader, field, wire int) (bool, error) {
+		var err error
+		switch {
+		case field == 1 && wire == wireFixed64:
+			rec.TimeUnixNano, err = r.fixed64()
+		case field == 11 && wire == wireFixed64:
+			rec.ObservedTimeUnixNano, err = r.fixed64()
+		case field == 2 && wire == wireVarint:
+			var v uint64
+			v, err = r.varint()
+			rec.SeverityNumber = int32(v)
+		case wire == wireBytes && (field == 3 || field == 5 || field == 6 || field == 9 || field == 10 || field == 12):
+			var b []byte
+			if b, err = r.bytes(); err != nil {
+				return true, err
+			}
+			switch field {
+			case 3:
+				rec.SeverityText = string(b)
+			case 5:
+				rec.Body, err = decodeAnyValue(b)
+			case 6:
+				var kv KeyValue
+				kv, err = decodeKeyValue(b)
+				rec.Attributes = append(rec.Attributes, kv)
+			case 9:
+				rec.TraceID = hex.EncodeToString(b)
+			case 10:
+				rec.SpanID = hex.EncodeToString(b)
+			case 12:
+				rec.EventName = string(b)
+			}
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+	return rec, err
+}
+
+func decodeKeyValue(data []byte) (KeyValue, error) {
+	var kv KeyValue
+	err := fields(data, func(r *protoReader, field, wire int) (bool, error) {
+		if wire != wireBytes || (field != 1 && field != 2) {
+			return false, nil
+		}
+		b, err := r.bytes()
+		if err != nil {
+			return true, err
+		}
+		if field == 1 {
+			kv.Key = string(b)
+		} else {
+			kv.Value, err = decodeAnyValue(b)
+		}
+		return true, err
+	})
+	return kv, err
+}
+
+func decodeAnyValue(data []byte) (AnyValue, error) {
+	var v AnyValue
+	err := fields(data, func(r *protoReader, field, wire int) (bool, error) {
+		var err error
+		switch {
+		case field == 2 && wire == wireVarint:
+			var n uint64
+			n, err = r.varint()
+			v.Value = n != 0
+		case field == 3 && wire == wireVarint:
+			var n uint64
+			n, err = r.varint()
+			v.Value = int64(n)
+		case field == 4 && wire == wireFixed64:
+			var n uint64
+			n, err = r.fixed64()
+			v.Value = math.Float64frombits(n)
+		case wire == wireBytes && (field == 1 || field == 5 || field == 6 || field == 7):
+			var b []byte
+			if b, err = r.bytes(); err != nil {
+				return true, err
+			}
+			switch field {
+			case 1:
+				v.Value = string(b)
+			case 5:
+				v.Value, err = decodeArrayValue(b)
+			case 6:
+				v.Value, err = decodeKeyValueList(b)
+			case 7:
+				v.Value = base64.StdEncoding.EncodeToString(b)
+			}
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+	return v, err
+}
+
+func decodeArrayValue(data []byte) ([]interface{}, error) {
+	values := []interface{}{}
+	err := fields(data, func(r *protoReader, field, wire int) (bool, error) {
+		if field != 1 || wire != wireBytes {
+			return false, nil
+		}
+		b, err := r.bytes()
+		if err != nil {
+			return true, err
+		}
+		av, err := decodeAnyValue(b)
+		values = append(values, av.Value)
+		return true, err
+	})
+	return values, err
+}
+
+func decodeKeyValueList(data []byte) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	err := fields(data, func(r *protoReader, field, wire int) (bool, error) {
+		if field != 1 || wire != wireBytes {
+			return false, nil
+		}
+		b, err := r.bytes()
+		if err != nil {
+			return true, err
+		}
+		kv, err := decodeKeyValue(b)
+		values[kv.Key] = kv.Value.Value
+		return true, err
+	})
+	return values, err
+}
+
+// appendField appends a length-delimited field.
+func appendField(b []byte, field int, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// appendVarintField appends a varint field.
+func appendVarintField(b []byte, field int, value uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|wireVarint)
+	return binary.AppendUvarint(b, value)
+}
+
+// EncodeResponse encodes an ExportLogsServiceResponse. A partial success is
+// included when records were rejected.
+func EncodeResponse(rejected int64, message string) []byte {
+	if rejected == 0 && message == "" {
+		return []byte{}
+	}
+	var partial []byte
+	if rejected != 0 {
+		partial = appendVarintField(partial, 1, uint64(rejected))
+	}
+	if message != "" {
+		partial = appendField(partial, 2, []byte(message))
+	}
+	return appendField(nil, 1, partial)
+}
+
+// EncodeStatus encodes a google.rpc.Status, the OTLP/HTTP error body.
+func EncodeStatus(code int32, message string) []byte {
+	var b []byte
+	if code != 0 {
+		b = appendVarintField(b, 1, uint64(code))
+	}
+	if message != "" {
+		b = appendField(b, 2, []byte(message))
+	}
+	return b
+}
@@ -0,0 +1,269 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxQueuedSpans bounds the spans waiting for export; further spans are
+	// dropped rather than blocking requests when the collector is slow.
+	maxQueuedSpans = 4096
+	// maxExportBatch is the most spans sent in one request.
+	maxExportBatch = 512
+	// exportInterval is how often queued spans are sent.
+	exportInterval = 5 * time.Second
+)
+
+// Config configures a Tracer.
+type Config struct {
+	// Endpoint is the OTLP/HTTP traces URL, e.g. http://localhost:4318/v1/traces
+	Endpoint string
+	// SampleRatio is the fraction of new traces recorded; requests carrying
+	// a traceparent follow the caller's decision
+	SampleRatio float64
+	// ServiceName and ServiceVersion identify locog in the tracing backend
+	ServiceName    string
+	ServiceVersion string
+}
+
+// Tracer batches ended spans and exports them in the background.
+type Tracer struct {
+	endpoint    string
+	sampleRatio float64
+	resource    []jsonKeyValue
+	client      *http.Client
+
+	spans   chan *Span
+	flush   chan chan struct{}
+	done    chan struct{}
+	stopped sync.Once
+
+	mu      sync.Mutex
+	dropped int64
+}
+
+// NewTracer starts a tracer exporting to cfg.Endpoint. Call Shutdown to send
+// the remaining spans.
+func NewTracer(cfg Config) (*Tracer, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("tracing: an OTLP endpoint is required")
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, fmt.Errorf("tracing: sample ratio must be between 0 and 1, got %g", cfg.SampleRatio)
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "locog"
+	}
+	t := &Tracer{
+		endpoint:    cfg.Endpoint,
+		sampleRatio: cfg.SampleRatio,
+		resource:    []jsonKeyValue{keyValue(String("service.name", cfg.ServiceName))},
+		client:      &http.Client{Timeout: 10 * time.Second},
+		spans:       make(chan *Span, maxQueuedSpans),
+		flush:       make(chan chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if cfg.ServiceVersion != "" {
+		t.resource = append(t.resource, keyValue(String("service.version", cfg.ServiceVersion)))
+	}
+	go t.run()
+	return t, nil
+}
+
+// SampleRatio returns the fraction of new traces recorded.
+func (t *Tracer) SampleRatio() float64 {
+	return t.sampleRatio
+}
+
+// enqueue queues an ended span, dropping it if the queue is full.
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.spans <- s:
+	default:
+		t.mu.Lock()
+		t.dropped++
+		t.mu.Unlock()
+	}
+}
+
+// Shutdown exports the queued spans and stops the tracer. Spans ended
+// afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	var err error
+	t.stopped.Do(func() {
+		exported := make(chan struct{})
+		select {
+		case t.flush <- exported:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+		select {
+		case <-exported:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	})
+	return err
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case s := <-t.spans:
+			batch = append(batch, s)
+			if len(batch) >= maxExportBatch {
+				t.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				t.export(batch)
+				batch = nil
+			}
+		case exported := <-t.flush:
+			for len(t.spans) > 0 {
+				batch = append(batch, <-t.spans)
+			}
+			for len(batch) > 0 {
+				n := min(len(batch), maxExportBatch)
+				t.export(batch[:n])
+				batch = batch[n:]
+			}
+			close(exported)
+			return
+		}
+	}
+}
+
+// export sends one batch. Failures are logged and the batch discarded, as
+// traces are diagnostics rather than data.
+func (t *Tracer) export(batch []*Span) {
+	t.mu.Lock()
+	dropped := t.dropped
+	t.dropped = 0
+	t.mu.Unlock()
+	if dropped > 0 {
+		slog.Warn("dropped spans: export queue full", "count", dropped)
+	}
+
+	body, err := json.Marshal(t.request(batch))
+	if err != nil {
+		slog.Error("failed to encode spans", "error", err)
+		return
+	}
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("failed to export spans", "endpoint", t.endpoint, "error", err, "count", len(batch))
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		slog.Warn("failed to export spans", "endpoint", t.endpoint, "status", resp.StatusCode, "count", len(batch))
+	}
+}
+
+// The OTLP/JSON ExportTraceServiceRequest, limited to the fields locog sets.
+// IDs are hex and 64-bit integers decimal strings, as OTLP/JSON requires.
+type jsonTraceRequest struct {
+	ResourceSpans []jsonResourceSpans `json:"resourceSpans"`
+}
+
+type jsonResourceSpans struct {
+	Resource   jsonResource     `json:"resource"`
+	ScopeSpans []jsonScopeSpans `json:"scopeSpans"`
+}
+
+type jsonResource struct {
+	Attributes []jsonKeyValue `json:"attributes"`
+}
+
+type jsonScopeSpans struct {
+	Scope jsonScope  `json:"scope"`
+	Spans []jsonSpan `json:"spans"`
+}
+
+type jsonScope struct {
+	Name string `json:"name"`
+}
+
+type jsonSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []jsonKeyValue `json:"attributes,omitempty"`
+	Status            *jsonStatus    `json:"status,omitempty"`
+}
+
+type jsonStatus struct {
+	Code    int    `json:"code"` // 2 = STATUS_CODE_ERROR
+	Message string `json:"message,omitempty"`
+}
+
+type jsonKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func keyValue(a Attr) jsonKeyValue {
+	var v map[string]interface{}
+	switch value := a.Value.(type) {
+	case int64:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+	case bool:
+		v = map[string]interface{}{"boolValue": value}
+	case float64:
+		v = map[string]interface{}{"doubleValue": value}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(value)}
+	}
+	return jsonKeyValue{Key: a.Key, Value: v}
+}
+
+func (t *Tracer) request(batch []*Span) jsonTraceRequest {
+	spans := make([]jsonSpan, 0, len(batch))
+	for _, s := range batch {
+		s.mu.Lock()
+		js := jsonSpan{
+			TraceID:           hex.EncodeToString(s.sc.traceID[:]),
+			SpanID:            hex.EncodeToString(s.sc.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != [8]byte{} {
+			js.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for _, a := range s.attrs {
+			js.Attributes = append(js.Attributes, keyValue(a))
+		}
+		if s.failed {
+			js.Status = &jsonStatus{Code: 2, Message: s.errorText}
+		}
+		s.mu.Unlock()
+		spans = append(spans, js)
+	}
+	return jsonTraceRequest{ResourceSpans: []jsonResourceSpans{{
+		Resource:   jsonResource{Attributes: t.resource},
+		ScopeSpans: []jsonScopeSpans{{Scope: jsonScope{Name: "locog"}, Spans: spans}},
+	}}}
+}
@@ -0,0 +1,246 @@
+// Package tracing records OpenTelemetry spans for locog's own requests and
+// exports them to an OTLP/HTTP collector as JSON. It implements the small
+// part of the OpenTelemetry SDK locog needs: W3C trace context propagation,
+// ratio sampling that follows the caller's decision, and batched export.
+//
+// Spans are started with Start, which returns a nil *Span when tracing is
+// disabled; every Span method is a no-op on nil, so instrumented code needs
+// no checks.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind is the OTLP span kind.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Attr is a span attribute.
+type Attr struct {
+	Key   string
+	Value interface{} // string, int64, bool or float64
+}
+
+// String returns a string attribute.
+func String(key, value string) Attr { return Attr{Key: key, Value: value} }
+
+// Int returns an integer attribute.
+func Int(key string, value int) Attr { return Attr{Key: key, Value: int64(value)} }
+
+// Int64 returns an integer attribute.
+func Int64(key string, value int64) Attr { return Attr{Key: key, Value: value} }
+
+// Bool returns a boolean attribute.
+func Bool(key string, value bool) Attr { return Attr{Key: key, Value: value} }
+
+// spanContext identifies a span within a trace.
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+// Span is an operation within a trace. Unsampled spans propagate their trace
+// but are never exported.
+type Span struct {
+	tracer *Tracer
+	sc     spanContext
+	parent [8]byte
+	kind   Kind
+	start  time.Time
+
+	mu        sync.Mutex
+	name      string
+	end       time.Time
+	attrs     []Attr
+	errorText string
+	failed    bool
+	ended     bool
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+// SetDefault sets the tracer used by Start; nil disables tracing.
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Default returns the tracer used by Start, or nil when tracing is disabled.
+func Default() *Tracer {
+	return defaultTracer.Load()
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// Start starts a span as a child of the span in ctx, or of a remote parent
+// from Extract, and returns a context carrying it. It returns a nil span when
+// tracing is disabled.
+func Start(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	t := Default()
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: attrs}
+	if parent := FromContext(ctx); parent != nil {
+		s.sc.traceID, s.sc.sampled, s.parent = parent.sc.traceID, parent.sc.sampled, parent.sc.spanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(spanContext); ok {
+		s.sc.traceID, s.sc.sampled, s.parent = remote.traceID, remote.sampled, remote.spanID
+	} else {
+		rand.Read(s.sc.traceID[:])
+		s.sc.sampled = t.sample(s.sc.traceID)
+	}
+	rand.Read(s.sc.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// FromContext returns the span in ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SetName renames the span, e.g. once the route is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// RecordError marks the span as failed. A nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.SetError(err.Error())
+}
+
+// SetError marks the span as failed with a description.
+func (s *Span) SetError(description string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.failed, s.errorText = true, description
+	s.mu.Unlock()
+}
+
+// End completes the span and queues it for export. Calls after the first
+// are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.end = true, time.Now()
+	s.mu.Unlock()
+	if s.sc.sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+// TraceID returns the span's trace ID in hex, or "" for a nil span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.sc.traceID[:])
+}
+
+// Extract returns a context carrying the remote parent from a W3C
+// traceparent header, if h has a valid one.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, ok := parseTraceparent(h.Get("traceparent"))
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Inject sets the traceparent header for the span in ctx, so a downstream
+// service continues the trace.
+func Inject(ctx context.Context, h http.Header) {
+	if s := FromContext(ctx); s != nil {
+		h.Set("traceparent", formatTraceparent(s.sc))
+	}
+}
+
+// parseTraceparent parses a version 00 traceparent header,
+// e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func parseTraceparent(v string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, false
+	}
+	if sc.traceID == [16]byte{} || sc.spanID == [8]byte{} {
+		return sc, false
+	}
+	sc.sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+func formatTraceparent(sc spanContext) string {
+	flags := "00"
+	if sc.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.traceID[:]) + "-" + hex.EncodeToString(sc.spanID[:]) + "-" + flags
+}
+
+// sample decides whether a new trace is recorded, from the low 8 bytes of
+// its ID so every service sampling by ratio agrees.
+func (t *Tracer) sample(traceID [16]byte) bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	if t.sampleRatio <= 0 {
+		return false
+	}
+	return binary.BigEndian.Uint64(traceID[8:])>>1 < uint64(t.sampleRatio*(1<<63))
+}
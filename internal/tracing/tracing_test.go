@@ -0,0 +1,157 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// collect starts a collector and a default tracer exporting to it. The
+// returned function shuts the tracer down and returns the exported spans.
+func collect(t *testing.T, ratio float64) func() []jsonSpan {
+	t.Helper()
+	var mu sync.Mutex
+	var spans []jsonSpan
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req jsonTraceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid export: %v", err)
+		}
+		mu.Lock()
+		for _, rs := range req.ResourceSpans {
+			if rs.Resource.Attributes[0].Value["stringValue"] != "locog" {
+				t.Errorf("unexpected resource: %+v", rs.Resource)
+			}
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+		mu.Unlock()
+	}))
+	t.Cleanup(ts.Close)
+
+	tracer, err := NewTracer(Config{Endpoint: ts.URL + "/v1/traces", SampleRatio: ratio})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDefault(tracer)
+	t.Cleanup(func() { SetDefault(nil) })
+	return func() []jsonSpan {
+		if err := tracer.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return spans
+	}
+}
+
+// TestParseTraceparent tests W3C traceparent parsing and formatting.
+func TestParseTraceparent(t *testing.T) {
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := parseTraceparent(header)
+	if !ok || !sc.sampled || formatTraceparent(sc) != header {
+		t.Errorf("failed to round-trip %s: %+v", header, sc)
+	}
+	if sc, ok := parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future"); !ok || sc.sampled {
+		t.Errorf("expected a later version with extra fields to parse unsampled, got %+v %v", sc, ok)
+	}
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, ok := parseTraceparent(bad); ok {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+// TestStart_Disabled tests that spans are nil and safe to use without a
+// tracer.
+func TestStart_Disabled(t *testing.T) {
+	ctx, span := Start(context.Background(), "op", KindInternal)
+	if span != nil || FromContext(ctx) != nil {
+		t.Fatal("expected no span without a tracer")
+	}
+	span.SetAttributes(String("k", "v"))
+	span.RecordError(errors.New("boom"))
+	span.SetName("renamed")
+	span.End()
+
+	h := http.Header{}
+	Inject(ctx, h)
+	if h.Get("traceparent") != "" {
+		t.Error("expected no traceparent without a span")
+	}
+}
+
+// TestTracer_Export tests that spans continue a remote trace, nest, and are
+// exported as OTLP/JSON.
+func TestTracer_Export(t *testing.T) {
+	spans := collect(t, 1)
+
+	h := http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}
+	ctx, server := Start(Extract(context.Background(), h), "GET", KindServer, String("url.path", "/api/logs"))
+	server.SetName("GET /api/logs")
+	_, client := Start(ctx, "db.QueryLogs", KindClient, Int("db.rows", 3), Bool("cached", false))
+	client.RecordError(errors.New("database is locked"))
+	client.End()
+	server.End()
+	server.End() // ignored
+
+	got := spans()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 spans, got %+v", got)
+	}
+	db, srv := got[0], got[1]
+	if srv.Name != "GET /api/logs" || srv.Kind != KindServer || srv.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		srv.ParentSpanID != "00f067aa0ba902b7" || srv.Status != nil {
+		t.Errorf("unexpected server span: %+v", srv)
+	}
+	if db.TraceID != srv.TraceID || db.ParentSpanID != srv.SpanID || db.Status == nil ||
+		db.Status.Code != 2 || db.Status.Message != "database is locked" {
+		t.Errorf("unexpected client span: %+v", db)
+	}
+	if db.Attributes[0].Value["intValue"] != "3" || db.Attributes[1].Value["boolValue"] != false {
+		t.Errorf("unexpected attributes: %+v", db.Attributes)
+	}
+	if db.StartTimeUnixNano == "" || db.EndTimeUnixNano < db.StartTimeUnixNano {
+		t.Errorf("unexpected times: %s - %s", db.StartTimeUnixNano, db.EndTimeUnixNano)
+	}
+}
+
+// TestTracer_Sampling tests that unsampled traces propagate but aren't
+// exported, and that a sampled caller overrides the ratio.
+func TestTracer_Sampling(t *testing.T) {
+	spans := collect(t, 0)
+
+	ctx, root := Start(context.Background(), "root", KindInternal)
+	_, child := Start(ctx, "child", KindInternal)
+	child.End()
+	root.End()
+	h := http.Header{}
+	Inject(ctx, h)
+	if h.Get("traceparent") != "00-"+root.TraceID()+"-"+hex.EncodeToString(root.sc.spanID[:])+"-00" {
+		t.Errorf("unexpected traceparent: %s", h.Get("traceparent"))
+	}
+
+	remote := http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}
+	_, sampled := Start(Extract(context.Background(), remote), "sampled", KindServer)
+	sampled.End()
+
+	got := spans()
+	if len(got) != 1 || got[0].Name != "sampled" {
+		t.Errorf("expected only the sampled caller's span, got %+v", got)
+	}
+}
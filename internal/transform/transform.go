@@ -0,0 +1,1146 @@
+// Package transform compiles and runs the scripts operators configure to
+// rewrite logs at ingest, beyond what the built-in processors do. A script is
+// a list of statements, separated by newlines or ';':
+//
+//	set meta.region = upper(extract(host, '^([a-z]+)-'))
+//	if meta.status >= 500 { set level = 'error' } else if meta.status >= 400 { set level = 'warn' }
+//	delete meta.password
+//	if contains(message, 'healthcheck') { drop }
+//
+// Expressions read the fields service, level, host and message and metadata
+// as meta.<dotted.key>, and combine single-quoted strings (doubling quotes
+// within them), numbers, true, false and null with + (which concatenates
+// strings), - * / %, the comparisons == != < <= > >=, and, or, not and the
+// functions listed in functions. Setting a metadata key to null removes it.
+// # starts a comment.
+//
+// Scripts have no loops, so their run time is bounded by their length and
+// the size of the log; Limits bound each run further.
+package transform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"locog/internal/models"
+)
+
+// Limits bound a single run of a script on one log.
+type Limits struct {
+	// MaxSteps bounds evaluation steps: one per statement, operator and
+	// function call, plus one per 64 bytes a function reads or writes.
+	MaxSteps int
+	// MaxBytes bounds the bytes of strings and metadata a run allocates.
+	MaxBytes int
+	// Timeout bounds the run's wall clock time.
+	Timeout time.Duration
+}
+
+// DefaultLimits apply where a limit is zero.
+var DefaultLimits = Limits{MaxSteps: 10000, MaxBytes: 1 << 20, Timeout: 100 * time.Millisecond}
+
+// ErrLimit is wrapped by the errors of runs that exceeded a limit.
+var ErrLimit = errors.New("limit exceeded")
+
+// errDrop ends a run that executed drop.
+var errDrop = errors.New("drop")
+
+// Result reports a run.
+type Result struct {
+	Drop  bool // the script dropped the log
+	Steps int
+	Bytes int
+}
+
+// Script is a compiled script.
+type Script struct {
+	src  string
+	body []stmt
+}
+
+// String returns the script's source.
+func (s *Script) String() string { return s.src }
+
+// Compile parses a script.
+func Compile(src string) (*Script, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	body, err := p.parseBlock(false)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{src: src, body: body}, nil
+}
+
+// Run runs the script on l. l is only changed if the run succeeds: a run
+// failing with an error or exceeding a limit leaves it as it was. A script
+// may not leave service, level or message empty.
+func (s *Script) Run(l *models.Log, limits Limits) (Result, error) {
+	if limits.MaxSteps <= 0 {
+		limits.MaxSteps = DefaultLimits.MaxSteps
+	}
+	if limits.MaxBytes <= 0 {
+		limits.MaxBytes = DefaultLimits.MaxBytes
+	}
+	if limits.Timeout <= 0 {
+		limits.Timeout = DefaultLimits.Timeout
+	}
+	e := &env{
+		fields:   map[string]string{"service": l.Service, "level": l.Level, "host": l.Host, "message": l.Message},
+		meta:     l.Metadata,
+		limits:   limits,
+		deadline: time.Now().Add(limits.Timeout),
+	}
+	err := execAll(e, s.body)
+	result := Result{Steps: e.steps, Bytes: e.bytes}
+	if errors.Is(err, errDrop) {
+		result.Drop = true
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	for _, name := range []string{"service", "level", "message"} {
+		if strings.TrimSpace(e.fields[name]) == "" {
+			return result, fmt.Errorf("script left %s empty", name)
+		}
+	}
+	l.Service, l.Level, l.Host, l.Message = e.fields["service"], e.fields["level"], e.fields["host"], e.fields["message"]
+	l.Metadata = e.meta
+	return result, nil
+}
+
+// env is the state of a run.
+type env struct {
+	fields   map[string]string
+	meta     map[string]interface{}
+	limits   Limits
+	deadline time.Time
+	steps    int
+	bytes    int
+}
+
+// step charges n evaluation steps.
+func (e *env) step(n int) error {
+	e.steps += n
+	if e.steps > e.limits.MaxSteps {
+		return fmt.Errorf("%w: more than %d steps", ErrLimit, e.limits.MaxSteps)
+	}
+	// Reading the clock costs more than a step, so check it now and then
+	if e.steps%64 < n && time.Now().After(e.deadline) {
+		return fmt.Errorf("%w: ran longer than %s", ErrLimit, e.limits.Timeout)
+	}
+	return nil
+}
+
+// alloc charges n allocated bytes.
+func (e *env) alloc(n int) error {
+	e.bytes += n
+	if e.bytes > e.limits.MaxBytes {
+		return fmt.Errorf("%w: allocated more than %d bytes", ErrLimit, e.limits.MaxBytes)
+	}
+	return nil
+}
+
+// str charges for a string a function produced from input bytes.
+func (e *env) str(s string, input int) (interface{}, error) {
+	if err := e.reserve(len(s), input); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reserve charges for n bytes a function produces from input bytes. Functions
+// whose output can outgrow their input call it before building the output,
+// so a run fails before allocating past its limits.
+func (e *env) reserve(n, input int) error {
+	if err := e.step((input + n) / 64); err != nil {
+		return err
+	}
+	return e.alloc(n)
+}
+
+// regexReplace is regexp's ReplaceAllString charging as it goes. Each match
+// costs a step, so no more matches are found than steps remain, and the
+// output is checked against the bytes left before each expansion, which is
+// at most the template with each $ reference replaced by the whole match.
+func regexReplace(e *env, c call, s, template string) (interface{}, error) {
+	if err := e.step(len(s) / 64); err != nil {
+		return nil, err
+	}
+	matches := c.re.FindAllStringSubmatchIndex(s, e.limits.MaxSteps-e.steps+1)
+	if err := e.step(len(matches)); err != nil {
+		return nil, err
+	}
+	refs := strings.Count(template, "$")
+	var out []byte
+	last := 0
+	for _, m := range matches {
+		if len(out)+(m[0]-last)+len(template)+refs*(m[1]-m[0]) > e.limits.MaxBytes-e.bytes {
+			return nil, fmt.Errorf("%w: allocated more than %d bytes", ErrLimit, e.limits.MaxBytes)
+		}
+		out = append(out, s[last:m[0]]...)
+		out = c.re.ExpandString(out, template, s, m)
+		last = m[1]
+	}
+	out = append(out, s[last:]...)
+	if err := e.reserve(len(out), 0); err != nil {
+		return nil, err
+	}
+	return string(out), nil
+}
+
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '#':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case c == '\'':
+			j := i + 1
+			for ; j < len(s); j++ {
+				if s[j] == '\'' {
+					if j+1 < len(s) && s[j+1] == '\'' {
+						j++ // escaped quote
+						continue
+					}
+					break
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, s[i:j+1])
+			i = j + 1
+		case unicode.IsDigit(c) || c == '.' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1])):
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_' || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		case strings.ContainsRune("=!<>", c) && i+1 < len(s) && s[i+1] == '=':
+			tokens = append(tokens, s[i:i+2])
+			i += 2
+		case strings.ContainsRune("+-*/%(){},;=<>", c):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+// parser is a recursive descent parser producing statements.
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		if got == "" {
+			return fmt.Errorf("expected %q at the end of the script", tok)
+		}
+		return fmt.Errorf("expected %q, got %q", tok, got)
+	}
+	return nil
+}
+
+// parseBlock := { stmt [';'] }, up to a '}' when inBraces.
+func (p *parser) parseBlock(inBraces bool) ([]stmt, error) {
+	var body []stmt
+	for {
+		switch p.peek() {
+		case ";":
+			p.next()
+			continue
+		case "":
+			if inBraces {
+				return nil, fmt.Errorf("missing '}'")
+			}
+			return body, nil
+		case "}":
+			if !inBraces {
+				return nil, fmt.Errorf("unexpected '}'")
+			}
+			p.next()
+			return body, nil
+		}
+		st, err := p.parseStmt()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, st)
+	}
+}
+
+// parseStmt := 'set' target '=' expr | 'delete' meta | 'drop' | if
+func (p *parser) parseStmt() (stmt, error) {
+	switch tok := p.next(); tok {
+	case "set":
+		target := p.next()
+		path, isMeta := metaPath(target)
+		if !isMeta && !isField(target) {
+			return nil, fmt.Errorf("set: expected service, level, host, message or meta.<key>, got %q", target)
+		}
+		if isMeta && path == nil {
+			return nil, fmt.Errorf("set: missing metadata key")
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if isMeta {
+			return setMeta{path, x}, nil
+		}
+		return setField{target, x}, nil
+	case "delete":
+		target := p.next()
+		path, isMeta := metaPath(target)
+		if !isMeta || path == nil {
+			return nil, fmt.Errorf("delete: expected meta.<key>, got %q", target)
+		}
+		return setMeta{path, literal{nil}}, nil
+	case "drop":
+		return dropStmt{}, nil
+	case "if":
+		return p.parseIf()
+	case "":
+		return nil, fmt.Errorf("unexpected end of script")
+	default:
+		return nil, fmt.Errorf("expected set, delete, drop or if, got %q", tok)
+	}
+}
+
+// parseIf := expr '{' block '}' [ 'else' ( 'if' parseIf | '{' block '}' ) ]
+func (p *parser) parseIf() (stmt, error) {
+	cond, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	then, err := p.parseBlock(true)
+	if err != nil {
+		return nil, err
+	}
+	st := ifStmt{cond: cond, then: then}
+	if p.peek() != "else" {
+		return st, nil
+	}
+	p.next()
+	if p.peek() == "if" {
+		p.next()
+		elseIf, err := p.parseIf()
+		if err != nil {
+			return nil, err
+		}
+		st.els = []stmt{elseIf}
+		return st, nil
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	if st.els, err = p.parseBlock(true); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// parseExpr := and { 'or' and }
+func (p *parser) parseExpr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd := not { 'and' not }
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseNot := 'not' not | comparison
+func (p *parser) parseNot() (node, error) {
+	if p.peek() == "not" {
+		p.next()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return not{x}, nil
+	}
+	return p.parseComparison()
+}
+
+var comparisons = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// parseComparison := sum [ op sum ]
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if !comparisons[p.peek()] {
+		return left, nil
+	}
+	op := p.next()
+	right, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	return compare{op, left, right}, nil
+}
+
+// parseSum := term { ('+' | '-') term }
+func (p *parser) parseSum() (node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.next()
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = arith{op, left, right}
+	}
+	return left, nil
+}
+
+// parseTerm := unary { ('*' | '/' | '%') unary }
+func (p *parser) parseTerm() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "*" || p.peek() == "/" || p.peek() == "%" {
+		op := p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = arith{op, left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek() == "-" {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return arith{"-", literal{0.0}, x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of script")
+	case tok == "(":
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	case strings.HasPrefix(tok, "'"):
+		return literal{strings.ReplaceAll(tok[1:len(tok)-1], "''", "'")}, nil
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		f, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", tok)
+		}
+		return literal{f}, nil
+	case tok == "true" || tok == "false":
+		return literal{tok == "true"}, nil
+	case tok == "null":
+		return literal{nil}, nil
+	case isField(tok):
+		return field{tok}, nil
+	case p.peek() == "(":
+		return p.parseCall(tok)
+	}
+	if path, ok := metaPath(tok); ok {
+		if path == nil {
+			return nil, fmt.Errorf("missing metadata key")
+		}
+		return metaRef{path}, nil
+	}
+	return nil, fmt.Errorf("unknown identifier %q", tok)
+}
+
+func (p *parser) parseCall(name string) (node, error) {
+	fn, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	p.next() // (
+	c := call{name: name, fn: fn}
+	if p.peek() != ")" {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			c.args = append(c.args, arg)
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(c.args) < fn.minArgs || fn.maxArgs >= 0 && len(c.args) > fn.maxArgs {
+		return nil, fmt.Errorf("%s takes %s", name, fn.arity())
+	}
+	// Patterns are compiled once, so they must be literals
+	if fn.pattern {
+		lit, ok := c.args[1].(literal)
+		pattern, isString := lit.v.(string)
+		if !ok || !isString {
+			return nil, fmt.Errorf("%s: the pattern must be a 'string'", name)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		c.re = re
+	}
+	return c, nil
+}
+
+var scriptFields = map[string]bool{"service": true, "level": true, "host": true, "message": true}
+
+func isField(tok string) bool { return scriptFields[tok] }
+
+// metaPath returns the keys of a meta.<dotted.key> reference; ok is false for
+// other identifiers and the path nil for a bare "meta".
+func metaPath(tok string) (path []string, ok bool) {
+	if tok == "meta" || tok == "meta." {
+		return nil, true
+	}
+	key, ok := strings.CutPrefix(tok, "meta.")
+	if !ok {
+		return nil, false
+	}
+	path = strings.Split(key, ".")
+	for _, k := range path {
+		if k == "" {
+			return nil, true
+		}
+	}
+	return path, true
+}
+
+// stmt is a statement.
+type stmt interface {
+	exec(e *env) error
+}
+
+func execAll(e *env, body []stmt) error {
+	for _, st := range body {
+		if err := e.step(1); err != nil {
+			return err
+		}
+		if err := st.exec(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type setField struct {
+	name string
+	x    node
+}
+
+func (s setField) exec(e *env) error {
+	v, err := s.x.eval(e)
+	if err != nil {
+		return err
+	}
+	str := toString(v)
+	if err := e.alloc(len(str)); err != nil {
+		return err
+	}
+	e.fields[s.name] = str
+	return nil
+}
+
+// setMeta sets a metadata key, or removes it when set to null. Maps on the
+// path are copied, so the log's metadata is only changed once the run
+// succeeds.
+type setMeta struct {
+	path []string
+	x    node
+}
+
+func (s setMeta) exec(e *env) error {
+	v, err := s.x.eval(e)
+	if err != nil {
+		return err
+	}
+	m, err := setPath(e, e.meta, s.path, v)
+	if err != nil {
+		return err
+	}
+	e.meta = m
+	return nil
+}
+
+func setPath(e *env, m map[string]interface{}, path []string, v interface{}) (map[string]interface{}, error) {
+	if _, ok := m[path[0]]; !ok && v == nil {
+		return m, nil
+	}
+	if err := e.step(1 + len(m)/16); err != nil {
+		return nil, err
+	}
+	if err := e.alloc(32 * (len(m) + 1)); err != nil {
+		return nil, err
+	}
+	copied := make(map[string]interface{}, len(m)+1)
+	for k, x := range m {
+		copied[k] = x
+	}
+	switch {
+	case len(path) > 1:
+		child, _ := copied[path[0]].(map[string]interface{})
+		c, err := setPath(e, child, path[1:], v)
+		if err != nil {
+			return nil, err
+		}
+		copied[path[0]] = c
+	case v == nil:
+		delete(copied, path[0])
+	default:
+		copied[path[0]] = v
+	}
+	return copied, nil
+}
+
+type dropStmt struct{}
+
+func (dropStmt) exec(*env) error { return errDrop }
+
+type ifStmt struct {
+	cond node
+	then []stmt
+	els  []stmt
+}
+
+func (s ifStmt) exec(e *env) error {
+	v, err := s.cond.eval(e)
+	if err != nil {
+		return err
+	}
+	if truthy(v) {
+		return execAll(e, s.then)
+	}
+	return execAll(e, s.els)
+}
+
+// node is an expression. Values are nil, bool, float64 and string, and the
+// maps and slices of metadata.
+type node interface {
+	eval(e *env) (interface{}, error)
+}
+
+type literal struct{ v interface{} }
+
+func (n literal) eval(*env) (interface{}, error) { return n.v, nil }
+
+type field struct{ name string }
+
+func (n field) eval(e *env) (interface{}, error) { return e.fields[n.name], nil }
+
+type metaRef struct{ path []string }
+
+func (n metaRef) eval(e *env) (interface{}, error) {
+	var v interface{} = e.meta
+	for _, k := range n.path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		v = m[k]
+	}
+	return v, nil
+}
+
+type not struct{ x node }
+
+func (n not) eval(e *env) (interface{}, error) {
+	v, err := n.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(v), e.step(1)
+}
+
+type logical struct {
+	and         bool
+	left, right node
+}
+
+func (n logical) eval(e *env) (interface{}, error) {
+	if err := e.step(1); err != nil {
+		return nil, err
+	}
+	l, err := n.left.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(l) != n.and {
+		return !n.and, nil
+	}
+	r, err := n.right.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	return truthy(r), nil
+}
+
+type compare struct {
+	op          string
+	left, right node
+}
+
+func (n compare) eval(e *env) (interface{}, error) {
+	if err := e.step(1); err != nil {
+		return nil, err
+	}
+	l, err := n.left.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(e)
+	if err != nil {
+		return nil, err
+	}
+
+	// Numbers compare numerically, also with numeric strings, e.g. a
+	// status code shipped as "503"
+	var c int
+	_, ls := l.(string)
+	_, rs := r.(string)
+	lf, lok := toNumber(l)
+	rf, rok := toNumber(r)
+	switch {
+	case lok && rok && !(ls && rs):
+		c = cmpFloat(lf, rf)
+	case ls && rs:
+		c = strings.Compare(l.(string), r.(string))
+	case n.op == "==" || n.op == "!=":
+		equal := l == nil && r == nil
+		if lb, ok := l.(bool); ok {
+			rb, ok := r.(bool)
+			equal = ok && lb == rb
+		}
+		return equal == (n.op == "=="), nil
+	default:
+		// Ordering values of different types, or null, is false
+		return false, nil
+	}
+	switch n.op {
+	case "==":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+type arith struct {
+	op          string
+	left, right node
+}
+
+func (n arith) eval(e *env) (interface{}, error) {
+	if err := e.step(1); err != nil {
+		return nil, err
+	}
+	l, err := n.left.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	// Missing metadata propagates, as NULL does in SQL
+	if l == nil || r == nil {
+		return nil, nil
+	}
+	_, ls := l.(string)
+	_, rs := r.(string)
+	if n.op == "+" && (ls || rs) {
+		a, b := toString(l), toString(r)
+		return e.str(a+b, len(a)+len(b))
+	}
+	a, aok := toNumber(l)
+	b, bok := toNumber(r)
+	if !aok || !bok {
+		return nil, fmt.Errorf("%s needs numbers, got %s and %s", n.op, typeName(l), typeName(r))
+	}
+	switch n.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, nil
+		}
+		return a / b, nil
+	default:
+		if b == 0 {
+			return nil, nil
+		}
+		return math.Mod(a, b), nil
+	}
+}
+
+type call struct {
+	name string
+	fn   function
+	args []node
+	re   *regexp.Regexp
+}
+
+func (n call) eval(e *env) (interface{}, error) {
+	if err := e.step(1); err != nil {
+		return nil, err
+	}
+	args := make([]interface{}, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil && !n.fn.nulls {
+			return nil, nil
+		}
+		args[i] = v
+	}
+	v, err := n.fn.call(e, n, args)
+	if err != nil && !errors.Is(err, ErrLimit) {
+		return nil, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return v, err
+}
+
+// function is a built-in function. Unless nulls is set, it returns null if
+// an argument is null.
+type function struct {
+	minArgs, maxArgs int // maxArgs -1 is unbounded
+	nulls            bool
+	pattern          bool // the second argument is a regular expression
+	call             func(e *env, c call, args []interface{}) (interface{}, error)
+}
+
+func (fn function) arity() string {
+	switch {
+	case fn.maxArgs < 0:
+		return fmt.Sprintf("at least %d arguments", fn.minArgs)
+	case fn.minArgs == fn.maxArgs && fn.minArgs == 1:
+		return "one argument"
+	case fn.minArgs == fn.maxArgs:
+		return fmt.Sprintf("%d arguments", fn.minArgs)
+	}
+	return fmt.Sprintf("%d to %d arguments", fn.minArgs, fn.maxArgs)
+}
+
+// stringFunc is a function of one string.
+func stringFunc(f func(string) string) function {
+	return function{minArgs: 1, maxArgs: 1, call: func(e *env, _ call, args []interface{}) (interface{}, error) {
+		s := toString(args[0])
+		return e.str(f(s), len(s))
+	}}
+}
+
+// testFunc tests a string against another.
+func testFunc(f func(s, sub string) bool) function {
+	return function{minArgs: 2, maxArgs: 2, call: func(e *env, _ call, args []interface{}) (interface{}, error) {
+		s, sub := toString(args[0]), toString(args[1])
+		return f(s, sub), e.step(len(s) / 64)
+	}}
+}
+
+// mathFunc is a function of one number.
+func mathFunc(f func(float64) float64) function {
+	return function{minArgs: 1, maxArgs: 1, call: func(_ *env, _ call, args []interface{}) (interface{}, error) {
+		x, ok := toNumber(args[0])
+		if !ok {
+			return nil, fmt.Errorf("needs a number, got %s", typeName(args[0]))
+		}
+		return f(x), nil
+	}}
+}
+
+// functions are the built-in functions by name.
+var functions = map[string]function{
+	"lower":       stringFunc(strings.ToLower),
+	"upper":       stringFunc(strings.ToUpper),
+	"trim":        stringFunc(strings.TrimSpace),
+	"contains":    testFunc(strings.Contains),
+	"starts_with": testFunc(strings.HasPrefix),
+	"ends_with":   testFunc(strings.HasSuffix),
+	"floor":       mathFunc(math.Floor),
+	"ceil":        mathFunc(math.Ceil),
+	"round":       mathFunc(math.Round),
+	"abs":         mathFunc(math.Abs),
+	"length": {minArgs: 1, maxArgs: 1, call: func(e *env, _ call, args []interface{}) (interface{}, error) {
+		s := toString(args[0])
+		return float64(utf8.RuneCountInString(s)), e.step(len(s) / 64)
+	}},
+	// replace sizes its output first: replacing an empty string inserts
+	// the replacement around every character
+	"replace": {minArgs: 3, maxArgs: 3, call: func(e *env, _ call, args []interface{}) (interface{}, error) {
+		s, old, repl := toString(args[0]), toString(args[1]), toString(args[2])
+		if err := e.reserve(len(s)+strings.Count(s, old)*(len(repl)-len(old)), len(s)); err != nil {
+			return nil, err
+		}
+		return strings.ReplaceAll(s, old, repl), nil
+	}},
+	"matches": {minArgs: 2, maxArgs: 2, pattern: true, call: func(e *env, c call, args []interface{}) (interface{}, error) {
+		s := toString(args[0])
+		return c.re.MatchString(s), e.step(len(s) / 64)
+	}},
+	// extract returns the first group of the pattern's first match, or
+	// the whole match if it has no groups
+	"extract": {minArgs: 2, maxArgs: 2, pattern: true, call: func(e *env, c call, args []interface{}) (interface{}, error) {
+		s := toString(args[0])
+		m := c.re.FindStringSubmatch(s)
+		if m == nil {
+			return nil, e.step(len(s) / 64)
+		}
+		return e.str(m[min(1, len(m)-1)], len(s))
+	}},
+	"regex_replace": {minArgs: 3, maxArgs: 3, pattern: true, call: func(e *env, c call, args []interface{}) (interface{}, error) {
+		return regexReplace(e, c, toString(args[0]), toString(args[2]))
+	}},
+	// substr takes characters from start, counting from 0, up to length
+	"substr": {minArgs: 2, maxArgs: 3, call: func(e *env, _ call, args []interface{}) (interface{}, error) {
+		runes := []rune(toString(args[0]))
+		start, ok := toNumber(args[1])
+		if !ok {
+			return nil, fmt.Errorf("needs a number start, got %s", typeName(args[1]))
+		}
+		from := min(max(int(start), 0), len(runes))
+		to := len(runes)
+		if len(args) == 3 {
+			n, ok := toNumber(args[2])
+			if !ok {
+				return nil, fmt.Errorf("needs a number length, got %s", typeName(args[2]))
+			}
+			to = min(from+max(int(n), 0), len(runes))
+		}
+		return e.str(string(runes[from:to]), len(runes))
+	}},
+	// split returns the part at index, counting from 0, or null
+	"split": {minArgs: 3, maxArgs: 3, call: func(e *env, _ call, args []interface{}) (interface{}, error) {
+		s := toString(args[0])
+		i, ok := toNumber(args[2])
+		if !ok {
+			return nil, fmt.Errorf("needs a number index, got %s", typeName(args[2]))
+		}
+		parts := strings.Split(s, toString(args[1]))
+		if err := e.step(len(s) / 64); err != nil {
+			return nil, err
+		}
+		if i < 0 || int(i) >= len(parts) {
+			return nil, nil
+		}
+		return e.str(parts[int(i)], 0)
+	}},
+	// concat treats null as the empty string
+	"concat": {minArgs: 1, maxArgs: -1, nulls: true, call: func(e *env, _ call, args []interface{}) (interface{}, error) {
+		var b strings.Builder
+		for _, a := range args {
+			b.WriteString(toString(a))
+		}
+		return e.str(b.String(), b.Len())
+	}},
+	"coalesce": {minArgs: 1, maxArgs: -1, nulls: true, call: func(_ *env, _ call, args []interface{}) (interface{}, error) {
+		for _, a := range args {
+			if a != nil {
+				return a, nil
+			}
+		}
+		return nil, nil
+	}},
+	// to_number returns null for values that aren't numbers
+	"to_number": {minArgs: 1, maxArgs: 1, call: func(_ *env, _ call, args []interface{}) (interface{}, error) {
+		if f, ok := toNumber(args[0]); ok {
+			return f, nil
+		}
+		return nil, nil
+	}},
+	"to_string": {minArgs: 1, maxArgs: 1, nulls: true, call: func(e *env, _ call, args []interface{}) (interface{}, error) {
+		s := toString(args[0])
+		return e.str(s, len(s))
+	}},
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	}
+	if f, ok := toNumber(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+// toNumber converts numbers, of any of the types decoded metadata holds,
+// and numeric strings.
+func toNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// toString prints values as they read in metadata: null as "", numbers
+// without trailing zeros, and objects and arrays as JSON.
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	if f, ok := toNumber(v); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	}
+	return "a number"
+}
@@ -0,0 +1,130 @@
+package transform
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"locog/internal/models"
+)
+
+func newLog() models.Log {
+	return models.Log{Service: "nginx", Level: "info", Host: "web-1.eu", Message: "GET /health 200",
+		Metadata: map[string]interface{}{"status": "503", "http": map[string]interface{}{"path": "/api"}, "password": "x"}}
+}
+
+// TestRun tests statements, expressions and functions.
+func TestRun(t *testing.T) {
+	for _, tc := range []struct {
+		script string
+		check  func(l models.Log) bool
+	}{
+		{"set level = 'error'", func(l models.Log) bool { return l.Level == "error" }},
+		{"if meta.status >= 500 { set level = 'error' } else { set level = 'warn' }", func(l models.Log) bool { return l.Level == "error" }},
+		{"if meta.status == 404 { set level = 'warn' } else if meta.status != 503 { set level = 'x' } else { set level = 'y' }",
+			func(l models.Log) bool { return l.Level == "y" }},
+		{"set meta.region = upper(extract(host, '\\.([a-z]+)$'))", func(l models.Log) bool { return l.Metadata["region"] == "EU" }},
+		{"delete meta.password; set meta.http.method = 'GET'", func(l models.Log) bool {
+			http := l.Metadata["http"].(map[string]interface{})
+			_, ok := l.Metadata["password"]
+			return !ok && http["method"] == "GET" && http["path"] == "/api"
+		}},
+		{"set meta.class = floor(meta.status / 100) * 100", func(l models.Log) bool { return l.Metadata["class"] == 500.0 }},
+		{"set message = service + ': ' + message  # prefix", func(l models.Log) bool { return l.Message == "nginx: GET /health 200" }},
+		{"set meta.x = meta.missing + 1; set meta.y = concat('a', meta.missing, 'b')", func(l models.Log) bool {
+			_, ok := l.Metadata["x"]
+			return !ok && l.Metadata["y"] == "ab"
+		}},
+		{"set meta.part = split(host, '.', 1); set meta.short = substr(message, 0, 3)", func(l models.Log) bool {
+			return l.Metadata["part"] == "eu" && l.Metadata["short"] == "GET"
+		}},
+		{"if not matches(message, '^POST') and (contains(message, 'health') or false) { set meta.probe = true }",
+			func(l models.Log) bool { return l.Metadata["probe"] == true }},
+		{"set meta.quote = 'it''s'; set meta.len = length(meta.quote)", func(l models.Log) bool {
+			return l.Metadata["quote"] == "it's" && l.Metadata["len"] == 4.0
+		}},
+		{"set message = regex_replace(message, '[0-9]+', 'N')", func(l models.Log) bool { return l.Message == "GET /health N" }},
+		{"set host = regex_replace(host, '([a-z]+)', '<$1>'); set meta.a = regex_replace('abc', 'x*', '-')", func(l models.Log) bool {
+			return l.Host == "<web>-1.<eu>" && l.Metadata["a"] == "-a-b-c-"
+		}},
+		{"set meta.a = replace('abc', '', '-'); set meta.b = replace(message, ' ', '')", func(l models.Log) bool {
+			return l.Metadata["a"] == "-a-b-c-" && l.Metadata["b"] == "GET/health200"
+		}},
+	} {
+		s, err := Compile(tc.script)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tc.script, err)
+			continue
+		}
+		l := newLog()
+		if _, err := s.Run(&l, Limits{}); err != nil {
+			t.Errorf("Run(%q): %v", tc.script, err)
+			continue
+		}
+		if !tc.check(l) {
+			t.Errorf("unexpected result of %q: %+v", tc.script, l)
+		}
+	}
+
+	s, _ := Compile("if starts_with(message, 'GET /health') { drop }")
+	l := newLog()
+	if r, err := s.Run(&l, Limits{}); err != nil || !r.Drop {
+		t.Errorf("expected the log dropped, got %+v, %v", r, err)
+	}
+}
+
+// TestRunFailures tests that failed runs and limits leave the log as it was.
+func TestRunFailures(t *testing.T) {
+	for _, tc := range []struct {
+		script string
+		limits Limits
+		limit  bool
+	}{
+		{"set meta.a = 'x'; set service = ''", Limits{}, false},
+		{"set meta.a = 'x'; set meta.b = message * 2", Limits{}, false},
+		{"set meta.a = 'x'; set meta.b = message + message + message", Limits{MaxBytes: 40}, true},
+		{strings.Repeat("set meta.a = 1\n", 20), Limits{MaxSteps: 30}, true},
+		// Replacing empty strings or repeating matches is checked before the
+		// output is built
+		{"set meta.a = replace(message, '', message)", Limits{MaxBytes: 200}, true},
+		{"set meta.a = regex_replace(message, '', message)", Limits{MaxBytes: 200}, true},
+		{"set meta.a = regex_replace(message, '.*', '$0$0$0$0$0$0$0$0$0$0$0$0$0$0')", Limits{MaxBytes: 200}, true},
+		{"set meta.a = regex_replace(message, 'x*', '-')", Limits{MaxSteps: 10}, true},
+	} {
+		s, err := Compile(tc.script)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tc.script, err)
+		}
+		l := newLog()
+		_, err = s.Run(&l, tc.limits)
+		if err == nil || errors.Is(err, ErrLimit) != tc.limit {
+			t.Errorf("Run(%q) = %v, expected a limit error: %v", tc.script, err, tc.limit)
+		}
+		if want := newLog(); !reflect.DeepEqual(l, want) {
+			t.Errorf("expected %q to leave the log unchanged, got %+v", tc.script, l)
+		}
+	}
+}
+
+// TestCompileErrors tests that invalid scripts are rejected.
+func TestCompileErrors(t *testing.T) {
+	for _, script := range []string{
+		"set timestamp = 1",
+		"set level = ",
+		"delete level",
+		"if true { drop",
+		"drop }",
+		"set meta.a = nope(1)",
+		"set meta.a = lower(1, 2)",
+		"set meta.a = matches(message, meta.pattern)",
+		"set meta.a = matches(message, '(')",
+		"set meta.a = 'open",
+		"set meta.a = 1 @ 2",
+		"print message",
+	} {
+		if _, err := Compile(script); err == nil {
+			t.Errorf("expected an error for %q", script)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Package locogtest runs a locog service for integration tests of code that
+// ships logs to it or reads them back: a service on a temporary database and
+// a loopback port, with helpers to ingest logs, query them and wait for them
+// on the live stream.
+//
+// The service runs in the test's process, configured as logservice is from
+// command line flags and served by an httptest.Server.
+package locogtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"locog/internal/logservice"
+	"locog/internal/models"
+)
+
+// Log is a log as ingested and returned by the service.
+type Log = models.Log
+
+const (
+	// subscribeTimeout bounds waiting for the live stream to apply filters.
+	subscribeTimeout = 10 * time.Second
+	// stopTimeout bounds waiting for the service's background tasks to stop.
+	stopTimeout = 10 * time.Second
+	// pollInterval is how often WaitForLogs queries.
+	pollInterval = 20 * time.Millisecond
+)
+
+// Options configures a service started by Start.
+type Options struct {
+	// Config is the JSON configuration file passed with -config, if set.
+	Config string
+	// Args are further command line flags, e.g. "-admin-token", "secret".
+	Args []string
+}
+
+// Server is a running service.
+type Server struct {
+	// URL is the service's base URL, e.g. http://127.0.0.1:41234.
+	URL string
+	// DBPath is the SQLite database, removed with the test's temporary
+	// directory.
+	DBPath string
+}
+
+// Start runs a service on a temporary database until the test ends.
+func Start(t testing.TB, opts Options) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	s := &Server{DBPath: filepath.Join(dir, "logs.db")}
+	args := []string{"-db", s.DBPath, "-export-dir", filepath.Join(dir, "exports"), "-upload-dir", filepath.Join(dir, "uploads")}
+	if opts.Config != "" {
+		config := filepath.Join(dir, "config.json")
+		if err := os.WriteFile(config, []byte(opts.Config), 0o600); err != nil {
+			t.Fatalf("locogtest: %v", err)
+		}
+		args = append(args, "-config", config)
+	}
+	svc, err := logservice.New(append(args, opts.Args...))
+	if err != nil {
+		t.Fatalf("locogtest: %v", err)
+	}
+	if svc == nil {
+		t.Fatalf("locogtest: %v run a one-shot mode rather than a service", opts.Args)
+	}
+
+	// The HTTP server stops first so the hub delivers what its requests
+	// broadcast, as in logservice
+	ts := httptest.NewServer(svc.Handler())
+	svc.Start()
+	t.Cleanup(func() {
+		ts.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+		defer cancel()
+		if err := svc.Shutdown(ctx); err != nil {
+			t.Errorf("locogtest: shutdown: %v", err)
+		}
+	})
+	s.URL = ts.URL
+	return s
+}
+
+// Ingest sends logs to /api/ingest in one batch.
+func (s *Server) Ingest(t testing.TB, logs ...Log) {
+	t.Helper()
+	body, err := json.Marshal(logs)
+	if err != nil {
+		t.Fatalf("locogtest: %v", err)
+	}
+	s.ingest(t, body)
+}
+
+// IngestFile sends a fixture file holding a JSON log or array of logs to
+// /api/ingest as is.
+func (s *Server) IngestFile(t testing.TB, path string) {
+	t.Helper()
+	body, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("locogtest: %v", err)
+	}
+	s.ingest(t, body)
+}
+
+func (s *Server) ingest(t testing.TB, body []byte) {
+	t.Helper()
+	resp, err := http.Post(s.URL+"/api/ingest", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("locogtest: ingest: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(resp.Body)
+		t.Fatalf("locogtest: ingest: status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+}
+
+// Query returns the logs /api/logs returns for the filter parameters, e.g.
+// url.Values{"service": {"api"}, "level": {"error"}}.
+func (s *Server) Query(t testing.TB, query url.Values) []Log {
+	t.Helper()
+	logs, err := s.query(query)
+	if err != nil {
+		t.Fatalf("locogtest: query %s: %v", query.Encode(), err)
+	}
+	return logs
+}
+
+func (s *Server) query(query url.Values) ([]Log, error) {
+	resp, err := http.Get(s.URL + "/api/logs?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	var logs []Log
+	err = json.NewDecoder(resp.Body).Decode(&logs)
+	return logs, err
+}
+
+// WaitForLogs queries until at least n logs match, returning them, and fails
+// the test if that takes longer than timeout. Use it for logs sent by the
+// code under test rather than by Ingest, which returns once they're stored.
+func (s *Server) WaitForLogs(t testing.TB, query url.Values, n int, timeout time.Duration) []Log {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		logs, err := s.query(query)
+		if err != nil {
+			t.Fatalf("locogtest: query %s: %v", query.Encode(), err)
+		}
+		if len(logs) >= n {
+			return logs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("locogtest: expected %d logs matching %s within %s, got %d", n, query.Encode(), timeout, len(logs))
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// RequireLog fails the test unless a log matching the filter parameters
+// satisfies match, returning the first one that does.
+func (s *Server) RequireLog(t testing.TB, query url.Values, match func(Log) bool) Log {
+	t.Helper()
+	logs := s.Query(t, query)
+	for _, l := range logs {
+		if match(l) {
+			return l
+		}
+	}
+	t.Fatalf("locogtest: none of the %d logs matching %s satisfies the condition", len(logs), query.Encode())
+	return Log{}
+}
+
+// Stream is a subscription to the live stream, /api/ws.
+type Stream struct {
+	conn *websocket.Conn
+}
+
+// envelope is a live stream message.
+type envelope struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Subscribe opens the live stream for logs matching the filter parameters,
+// e.g. url.Values{"level": {"error"}}, returning once the service applies
+// them. The stream is closed when the test ends.
+func (s *Server) Subscribe(t testing.TB, filters url.Values) *Stream {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), subscribeTimeout)
+	defer cancel()
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, "ws"+strings.TrimPrefix(s.URL, "http")+"/api/ws?v=1", nil)
+	if err != nil {
+		t.Fatalf("locogtest: subscribe: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	// The acknowledgement means the hub has registered the client, so no
+	// log ingested afterwards is missed
+	if filters == nil {
+		filters = url.Values{}
+	}
+	if err := conn.WriteJSON(map[string]interface{}{"type": "subscribe", "filters": filters}); err != nil {
+		t.Fatalf("locogtest: subscribe: %v", err)
+	}
+	stream := &Stream{conn: conn}
+	for {
+		msg, err := stream.read(subscribeTimeout)
+		if err != nil {
+			t.Fatalf("locogtest: subscribe: %v", err)
+		}
+		switch msg.Type {
+		case "subscribed":
+			return stream
+		case "error":
+			t.Fatalf("locogtest: subscribe: %s", msg.Data)
+		}
+	}
+}
+
+func (st *Stream) read(timeout time.Duration) (envelope, error) {
+	st.conn.SetReadDeadline(time.Now().Add(timeout))
+	var msg envelope
+	err := st.conn.ReadJSON(&msg)
+	return msg, err
+}
+
+// Next waits up to timeout for the next frame of logs and returns them.
+func (st *Stream) Next(t testing.TB, timeout time.Duration) []Log {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		msg, err := st.read(time.Until(deadline))
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			t.Fatalf("locogtest: no logs streamed within %s", timeout)
+		}
+		if err != nil {
+			t.Fatalf("locogtest: stream: %v", err)
+		}
+		if msg.Type != "logs" {
+			continue
+		}
+		var logs []Log
+		if err := json.Unmarshal(msg.Data, &logs); err != nil {
+			t.Fatalf("locogtest: stream: %v", err)
+		}
+		return logs
+	}
+}
+
+// Await reads frames until n logs have been streamed, failing the test if
+// that takes longer than timeout. The service may split or coalesce logs
+// into frames, so tests should count logs rather than frames.
+func (st *Stream) Await(t testing.TB, n int, timeout time.Duration) []Log {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	var logs []Log
+	for len(logs) < n {
+		logs = append(logs, st.Next(t, time.Until(deadline))...)
+	}
+	return logs
+}
@@ -0,0 +1,38 @@
+package locogtest
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestServer tests ingesting, querying and streaming logs against a service.
+func TestServer(t *testing.T) {
+	srv := Start(t, Options{})
+	stream := srv.Subscribe(t, url.Values{"service": {"api"}})
+
+	srv.Ingest(t,
+		Log{Service: "api", Level: "error", Message: "payment failed", Host: "web-1"},
+		Log{Service: "web", Level: "info", Message: "page served", Host: "web-1"},
+	)
+	fixture := filepath.Join(t.TempDir(), "fixture.json")
+	os.WriteFile(fixture, []byte(`[{"service": "api", "level": "info", "message": "retrying", "host": "web-2"}]`), 0o600)
+	srv.IngestFile(t, fixture)
+
+	// Only the subscribed service is streamed
+	streamed := stream.Await(t, 2, 5*time.Second)
+	if len(streamed) != 2 || streamed[0].Message != "payment failed" || streamed[1].Message != "retrying" {
+		t.Errorf("unexpected streamed logs %+v", streamed)
+	}
+
+	logs := srv.WaitForLogs(t, url.Values{"service": {"api"}}, 2, 5*time.Second)
+	if len(logs) != 2 {
+		t.Errorf("expected 2 api logs, got %d", len(logs))
+	}
+	l := srv.RequireLog(t, url.Values{"level": {"error"}}, func(l Log) bool { return l.Host == "web-1" })
+	if l.Message != "payment failed" {
+		t.Errorf("unexpected log %+v", l)
+	}
+}